@@ -1,10 +1,11 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"os"
 
 	"github.com/so2liu/imgcd/internal/cli"
+	"github.com/so2liu/imgcd/internal/ui"
 )
 
 // version is set at build time via ldflags
@@ -13,7 +14,11 @@ var version = "dev"
 func main() {
 	cli.Version = version
 	if err := cli.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		var exitErr *cli.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
+		ui.Error(os.Stderr, err)
 		os.Exit(1)
 	}
 }
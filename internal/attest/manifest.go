@@ -0,0 +1,125 @@
+// Package attest builds the file manifest of a delivery and renders it as
+// a printable attestation page, so a receiving site can check physical
+// media against checksums transmitted over a separate channel.
+package attest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/checksum"
+)
+
+// Entry is one delivered file
+type Entry struct {
+	Name   string
+	Size   int64 // -1 when unknown
+	SHA256 string
+}
+
+// Manifest lists the files of a delivery
+type Manifest struct {
+	Entries []Entry
+}
+
+// Add records a file, replacing an earlier entry with the same name
+func (m *Manifest) Add(e Entry) {
+	for i := range m.Entries {
+		if m.Entries[i].Name == e.Name {
+			m.Entries[i] = e
+			return
+		}
+	}
+	m.Entries = append(m.Entries, e)
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Name < m.Entries[j].Name })
+}
+
+// FromFiles hashes the files; the manifest uses their base names
+func FromFiles(paths []string) (*Manifest, error) {
+	m := &Manifest{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(path)
+		for _, e := range m.Entries {
+			if e.Name == name {
+				return nil, fmt.Errorf("duplicate file name %s", name)
+			}
+		}
+
+		sum, err := checksum.File(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to checksum %s: %w", name, err)
+		}
+		m.Add(Entry{Name: name, Size: info.Size(), SHA256: sum})
+	}
+	return m, nil
+}
+
+// ReadSHA256SUMS loads a manifest written by SHA256SUMS or sha256sum.
+// Sizes are taken from files next to it when present.
+func ReadSHA256SUMS(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(text, " ")
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if !ok || len(sum) != sha256.Size*2 || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"<sha256>  <name>\"", path, line)
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid sha256 %q", path, line, sum)
+		}
+
+		size := int64(-1)
+		if info, err := os.Stat(filepath.Join(filepath.Dir(path), name)); err == nil {
+			size = info.Size()
+		}
+		m.Add(Entry{Name: name, Size: size, SHA256: strings.ToLower(sum)})
+	}
+	return m, scanner.Err()
+}
+
+// SHA256SUMS renders the manifest in sha256sum -c format, sorted by name
+func (m *Manifest) SHA256SUMS() []byte {
+	var buf bytes.Buffer
+	for _, e := range m.Entries {
+		fmt.Fprintf(&buf, "%s  %s\n", e.SHA256, e.Name)
+	}
+	return buf.Bytes()
+}
+
+// Digest is the sha256 of SHA256SUMS(), the one value to compare on paper
+func (m *Manifest) Digest() string {
+	sum := sha256.Sum256(m.SHA256SUMS())
+	return hex.EncodeToString(sum[:])
+}
+
+// TotalSize sums the known file sizes
+func (m *Manifest) TotalSize() int64 {
+	var total int64
+	for _, e := range m.Entries {
+		if e.Size > 0 {
+			total += e.Size
+		}
+	}
+	return total
+}
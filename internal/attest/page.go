@@ -0,0 +1,134 @@
+package attest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/qr"
+)
+
+// Attestation is the printable record of one delivery
+type Attestation struct {
+	Title    string
+	Created  time.Time
+	Manifest *Manifest
+}
+
+// QRPayload is the text encoded in the QR code
+func (a *Attestation) QRPayload() string {
+	return "sha256:" + a.Manifest.Digest()
+}
+
+// header is the text above the QR code
+func (a *Attestation) header() []string {
+	lines := []string{}
+	if a.Title != "" {
+		lines = append(lines, "Delivery: "+a.Title)
+	}
+	lines = append(lines,
+		"Created:  "+a.Created.UTC().Format(time.RFC3339),
+		fmt.Sprintf("Files:    %d (%s)", len(a.Manifest.Entries), humanize.Size(a.Manifest.TotalSize())),
+		"",
+		"Manifest SHA-256 (sha256 of SHA256SUMS):",
+	)
+	return append(lines, groupDigest(a.Manifest.Digest())...)
+}
+
+// body is the text below the QR code
+func (a *Attestation) body() []string {
+	lines := []string{"Files:"}
+	for _, e := range a.Manifest.Entries {
+		size := "size unknown"
+		if e.Size >= 0 {
+			size = fmt.Sprintf("%d bytes", e.Size)
+		}
+		lines = append(lines,
+			"  "+e.Name,
+			fmt.Sprintf("    %s  %s", e.SHA256, size),
+		)
+	}
+
+	return append(lines,
+		"",
+		"Receiving site:",
+		"  1. Run: sha256sum SHA256SUMS",
+		"     The result must equal the manifest SHA-256 above (or the QR code).",
+		"  2. Run: sh verify.sh  (or: sha256sum -c SHA256SUMS)",
+		"     Every file must report OK.",
+		"",
+		"Prepared by: ____________________________  Date: ______________",
+		"",
+		"Verified by: ____________________________  Date: ______________",
+	)
+}
+
+// WriteText renders the attestation as plain text, with the QR code drawn
+// in Unicode block characters
+func (a *Attestation) WriteText(w io.Writer) error {
+	code, err := qr.Encode([]byte(a.QRPayload()))
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("IMGCD DELIVERY ATTESTATION\n")
+	b.WriteString("==========================\n\n")
+	for _, line := range a.header() {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+	writeTextQR(&b, code)
+	b.WriteString("\n")
+	for _, line := range a.body() {
+		b.WriteString(line + "\n")
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// quietZone is the light border a QR code needs around it, in modules
+const quietZone = 4
+
+// writeTextQR draws two module rows per text line with half blocks, dark
+// modules printed as ink
+func writeTextQR(b *strings.Builder, code *qr.Code) {
+	dark := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < code.Size && y < code.Size && code.Dark(x, y)
+	}
+
+	for y := -quietZone; y < code.Size+quietZone; y += 2 {
+		b.WriteString("  ")
+		for x := -quietZone; x < code.Size+quietZone; x++ {
+			top, bottom := dark(x, y), dark(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+}
+
+// groupDigest splits a hex digest into two lines of 4-character groups,
+// which is easier to compare by eye
+func groupDigest(digest string) []string {
+	var groups []string
+	for i := 0; i < len(digest); i += 4 {
+		groups = append(groups, digest[i:min(i+4, len(digest))])
+	}
+	half := (len(groups) + 1) / 2
+	return []string{
+		"  " + strings.Join(groups[:half], " "),
+		"  " + strings.Join(groups[half:], " "),
+	}
+}
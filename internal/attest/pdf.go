@@ -0,0 +1,143 @@
+package attest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/qr"
+)
+
+// A4 page layout in points
+const (
+	pageWidth   = 595
+	pageHeight  = 842
+	pageMargin  = 50
+	lineHeight  = 12
+	fontSize    = 9
+	titleSize   = 16
+	moduleSize  = 3
+	maxLineChar = 90 // Courier 9pt across the printable width
+)
+
+// WritePDF renders the attestation as a PDF document using only the
+// standard Courier and Helvetica fonts, so nothing needs to be embedded
+func (a *Attestation) WritePDF(w io.Writer) error {
+	code, err := qr.Encode([]byte(a.QRPayload()))
+	if err != nil {
+		return err
+	}
+
+	var pages []*bytes.Buffer
+	page := &bytes.Buffer{}
+	pages = append(pages, page)
+	y := pageHeight - pageMargin
+
+	line := func(text string) {
+		for _, part := range wrap(text, maxLineChar) {
+			if y < pageMargin+lineHeight {
+				page = &bytes.Buffer{}
+				pages = append(pages, page)
+				y = pageHeight - pageMargin
+			}
+			y -= lineHeight
+			fmt.Fprintf(page, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", fontSize, pageMargin, y, pdfEscape(part))
+		}
+	}
+
+	y -= titleSize
+	fmt.Fprintf(page, "BT /F2 %d Tf %d %d Td (imgcd delivery attestation) Tj ET\n", titleSize, pageMargin, y)
+	y -= lineHeight
+	for _, text := range a.header() {
+		line(text)
+	}
+
+	// The QR code with its quiet zone, dark modules as filled squares
+	y -= quietZone * moduleSize
+	top := y
+	for qy := 0; qy < code.Size; qy++ {
+		for qx := 0; qx < code.Size; qx++ {
+			if code.Dark(qx, qy) {
+				fmt.Fprintf(page, "%d %d %d %d re\n", pageMargin+(quietZone+qx)*moduleSize, top-(qy+1)*moduleSize, moduleSize, moduleSize)
+			}
+		}
+	}
+	fmt.Fprintf(page, "f\n")
+	y = top - code.Size*moduleSize - quietZone*moduleSize
+
+	for _, text := range a.body() {
+		line(text)
+	}
+
+	return writePDF(w, pages)
+}
+
+// writePDF writes a minimal PDF with one content stream per page
+func writePDF(w io.Writer, pages []*bytes.Buffer) error {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are fixed, each page then takes a page and a content object
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfEscape escapes a PDF string literal; characters outside ASCII are
+// replaced since the standard fonts are used without embedding
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// wrap splits a line into chunks of at most width characters
+func wrap(s string, width int) []string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return []string{s}
+	}
+	var parts []string
+	for len(runes) > width {
+		parts = append(parts, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(parts, string(runes))
+}
@@ -0,0 +1,56 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ChecksumsName is the entry of a bundle tar, after image.tar.gz, that lists
+// the sha256 of the imgcd binary and of every entry of the image data. It is
+// in sha256sum format: with the image data unpacked next to it,
+// "sha256sum -c checksums.txt" checks the files by hand.
+const ChecksumsName = "checksums.txt"
+
+// Checksum is the sha256 (hex) of one file of a bundle
+type Checksum struct {
+	Name   string
+	SHA256 string
+}
+
+// FormatChecksums returns the content of checksums.txt
+func FormatChecksums(sums []Checksum) []byte {
+	var buf bytes.Buffer
+	for _, sum := range sums {
+		fmt.Fprintf(&buf, "%s  %s\n", sum.SHA256, sum.Name)
+	}
+	return buf.Bytes()
+}
+
+// ParseChecksums decodes checksums.txt; sha256sum's binary marker
+// ("<hex> *<name>") is accepted too
+func ParseChecksums(data []byte) ([]Checksum, error) {
+	var sums []Checksum
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(text, " ")
+		if !ok || len(sum) != 64 {
+			return nil, fmt.Errorf("malformed %s: line %d", ChecksumsName, line)
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			return nil, fmt.Errorf("malformed %s: line %d", ChecksumsName, line)
+		}
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		sums = append(sums, Checksum{Name: CleanName(name), SHA256: strings.ToLower(sum)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", ChecksumsName, err)
+	}
+	return sums, nil
+}
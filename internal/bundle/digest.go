@@ -0,0 +1,94 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+)
+
+// Digest algorithms imgcd can compute. Blobs are named by the digest the
+// registry gave them, sha256 today; LayerInfo.Checksums can record further
+// algorithms next to it.
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+// Algorithms are the supported digest algorithms
+var Algorithms = []string{SHA256, SHA512}
+
+// blobsDir is the directory of a bundle's image data holding the blobs
+const blobsDir = "blobs/"
+
+// NewHash returns a hash of a supported digest algorithm
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm %q (supported: %s)", algorithm, strings.Join(Algorithms, ", "))
+}
+
+// Algorithm returns the algorithm of an <algorithm>:<hex> digest
+func Algorithm(digest string) string {
+	algorithm, _, _ := strings.Cut(digest, ":")
+	return algorithm
+}
+
+// Encoded returns the hex part of an <algorithm>:<hex> digest
+func Encoded(digest string) string {
+	_, encoded, _ := strings.Cut(digest, ":")
+	return encoded
+}
+
+// BlobPath returns the name of a blob in the image data:
+// blobs/<algorithm>/<hex>
+func BlobPath(digest string) string {
+	return blobsDir + Algorithm(digest) + "/" + Encoded(digest)
+}
+
+// BlobDigest returns the digest of a blob entry named by BlobPath, and false
+// for entries that are not blobs
+func BlobDigest(name string) (string, bool) {
+	if !strings.HasPrefix(name, blobsDir) {
+		return "", false
+	}
+	algorithm, encoded := path.Split(strings.TrimPrefix(name, blobsDir))
+	algorithm = strings.TrimSuffix(algorithm, "/")
+	if algorithm == "" || strings.Contains(algorithm, "/") || encoded == "" {
+		return "", false
+	}
+	return algorithm + ":" + encoded, true
+}
+
+// Digests hashes r with each of the algorithms at once and returns the
+// digests by algorithm, and the size of r
+func Digests(r io.Reader, algorithms []string) (map[string]string, int64, error) {
+	hashes := make(map[string]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		h, err := NewHash(algorithm)
+		if err != nil {
+			return nil, 0, err
+		}
+		hashes[algorithm] = h
+		writers = append(writers, h)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return nil, 0, err
+	}
+	digests := make(map[string]string, len(hashes))
+	for algorithm, h := range hashes {
+		digests[algorithm] = algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+	}
+	return digests, size, nil
+}
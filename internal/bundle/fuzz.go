@@ -0,0 +1,89 @@
+//go:build gofuzz
+
+package bundle
+
+// go-fuzz / OSS-Fuzz targets for the parsers of untrusted bundle data; see
+// scripts/fuzz.sh. Each returns 1 for input that parsed, so the fuzzer
+// favors it, and 0 otherwise; panics and hangs are the findings.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// FuzzReader reads an image.tar.gz stream entry by entry, with checksums on
+// and blobs checked against their digests
+func FuzzReader(data []byte) int {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	defer r.Close()
+	r.Checksum()
+
+	for entry, err := range r.All() {
+		if err != nil {
+			return 0
+		}
+		if _, err := io.Copy(io.Discard, entry); err != nil {
+			return 0
+		}
+	}
+	if _, err := r.Checksums(); err != nil {
+		return 0
+	}
+	if m := r.Metadata(); m != nil {
+		exerciseMetadata(m)
+	}
+	return 1
+}
+
+// FuzzMetadata decodes and validates metadata.json and walks what loads
+// derive from it
+func FuzzMetadata(data []byte) int {
+	m, err := DecodeMetadata(data)
+	if err != nil {
+		return 0
+	}
+	exerciseMetadata(m)
+	return 1
+}
+
+// exerciseMetadata calls the accessors loads use on decoded metadata
+func exerciseMetadata(m *Metadata) {
+	m.ImageRefs()
+	m.Platforms()
+	for _, image := range m.PerImage() {
+		for _, layer := range image.Layers {
+			image.LayerChecksums(layer.Digest)
+		}
+	}
+	if m.Artifact != nil {
+		m.Artifact.Type()
+	}
+}
+
+// FuzzChecksums parses checksums.txt; what parses must survive a round trip
+func FuzzChecksums(data []byte) int {
+	sums, err := ParseChecksums(data)
+	if err != nil {
+		return 0
+	}
+	again, err := ParseChecksums(FormatChecksums(sums))
+	if err != nil {
+		panic(fmt.Sprintf("formatted checksums do not parse: %v", err))
+	}
+	if len(again) != len(sums) {
+		panic(fmt.Sprintf("round trip changed %d checksums into %d", len(sums), len(again)))
+	}
+	return 1
+}
+
+// FuzzSignature parses signature.json and its payload
+func FuzzSignature(data []byte) int {
+	if _, _, err := ParseSignature(data); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -1,6 +1,9 @@
 package bundle
 
 import (
+	"bytes"
+	"encoding/json"
+
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
@@ -25,12 +28,21 @@ type Metadata struct {
 	// Platform is the target platform (e.g., "linux/amd64")
 	Platform string `json:"platform"`
 
+	// BinaryPlatform is the platform the bundled imgcd binary was built for
+	// (e.g., "darwin/arm64"), read from the executable; empty if unknown
+	BinaryPlatform string `json:"binary_platform,omitempty"`
+
 	// Manifest is the OCI/Docker manifest
 	Manifest *v1.Manifest `json:"manifest"`
 
 	// Config is the image config
 	Config *v1.ConfigFile `json:"config"`
 
+	// RawConfig is the image config as its source stored it. The image ID is
+	// its digest, which encoding Config anew would change
+	// Empty in bundles of older versions and of artifacts
+	RawConfig []byte `json:"raw_config,omitempty"`
+
 	// Layers contains the mapping between digest (compressed) and diffid (uncompressed)
 	// This is crucial for Load to verify layers and rebuild image.tar
 	Layers []LayerInfo `json:"layers"`
@@ -40,6 +52,159 @@ type Metadata struct {
 
 	// CreatedAt is the timestamp when this bundle was created
 	CreatedAt string `json:"created_at"`
+
+	// ExpiresAt is the RFC3339 timestamp after which the bundle is considered stale
+	// Empty if the bundle never expires
+	ExpiresAt string `json:"expires_at,omitempty"`
+
+	// Note is a free-form human comment (e.g., "hotfix for CVE-2024-1234")
+	Note string `json:"note,omitempty"`
+
+	// Annotations are arbitrary key/value pairs supplied by the producer
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Provenance identifies the operator and pipeline that produced the bundle
+	// Nil if provenance stamping was disabled
+	Provenance *Provenance `json:"provenance,omitempty"`
+
+	// OnLoad are shell commands load runs after importing (e.g., "docker compose up -d")
+	// Only run when the receiver passes --run-post-load and confirms them
+	OnLoad []string `json:"on_load,omitempty"`
+
+	// Artifact describes a non-image OCI artifact (Helm chart, WASM module, ORAS files)
+	// Nil for container images; Config is nil and Layers lists the config blob too
+	Artifact *Artifact `json:"artifact,omitempty"`
+
+	// Images lists the further images of a bundle saved from several images
+	// or platforms; the fields above describe the first one, so older versions
+	// of imgcd still load that. Blobs shared between the images are stored once.
+	Images []ImageEntry `json:"images,omitempty"`
+}
+
+// ImageEntry is one of the further images of a multi-image bundle. Entries
+// of a multi-platform bundle share the reference and differ in Platform.
+type ImageEntry struct {
+	ImageRef  string         `json:"image_ref"`
+	Platform  string         `json:"platform"`
+	Manifest  *v1.Manifest   `json:"manifest"`
+	Config    *v1.ConfigFile `json:"config"`
+	RawConfig []byte         `json:"raw_config,omitempty"`
+	Layers    []LayerInfo    `json:"layers"` // Every layer of the image, stored once per bundle
+}
+
+// PerImage returns metadata for every image of the bundle, the first being m
+// itself; the others share m's bundle-wide fields (notes, provenance, expiry)
+func (m *Metadata) PerImage() []*Metadata {
+	images := []*Metadata{m}
+	for _, entry := range m.Images {
+		image := *m
+		image.Images = nil
+		image.ImageRef = entry.ImageRef
+		image.Platform = entry.Platform
+		image.Manifest = entry.Manifest
+		image.Config = entry.Config
+		image.RawConfig = entry.RawConfig
+		image.Layers = entry.Layers
+		image.TotalSize = 0
+		for _, layer := range entry.Layers {
+			image.TotalSize += layer.Size
+		}
+		images = append(images, &image)
+	}
+	return images
+}
+
+// ConfigBytes returns the image config to give a runtime: the raw config
+// when it is the one the manifest names, so the loaded image keeps its ID,
+// otherwise Config encoded anew
+func (m *Metadata) ConfigBytes() ([]byte, error) {
+	if len(m.RawConfig) > 0 && m.Manifest != nil {
+		if digest, _, err := v1.SHA256(bytes.NewReader(m.RawConfig)); err == nil && digest == m.Manifest.Config.Digest {
+			return m.RawConfig, nil
+		}
+	}
+	return json.Marshal(m.Config)
+}
+
+// LayerChecksums returns the checksums recorded for a stored blob, nil if
+// there are none
+func (m *Metadata) LayerChecksums(digest string) map[string]string {
+	for _, image := range m.PerImage() {
+		for _, layer := range image.Layers {
+			if layer.Digest == digest && len(layer.Checksums) > 0 {
+				return layer.Checksums
+			}
+		}
+	}
+	return nil
+}
+
+// ImageRefs lists the distinct image references of the bundle in order; a
+// reference saved for several platforms is listed once
+func (m *Metadata) ImageRefs() []string {
+	return distinct(m.ImageRef, m.Images, func(entry ImageEntry) string { return entry.ImageRef })
+}
+
+// Platforms lists the distinct platforms of the bundle's images in order
+func (m *Metadata) Platforms() []string {
+	return distinct(m.Platform, m.Images, func(entry ImageEntry) string { return entry.Platform })
+}
+
+func distinct(first string, entries []ImageEntry, field func(ImageEntry) string) []string {
+	values := []string{first}
+	seen := map[string]bool{first: true}
+	for _, entry := range entries {
+		if value := field(entry); !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Artifact records the media types of an OCI artifact so it can be restored
+// exactly as the registry served it
+type Artifact struct {
+	// ArtifactType is the manifest's artifactType, empty if it declares none
+	ArtifactType string `json:"artifact_type,omitempty"`
+
+	// ConfigMediaType is the config blob's media type (e.g., "application/vnd.cncf.helm.config.v1+json")
+	ConfigMediaType string `json:"config_media_type"`
+
+	// MediaType is the manifest's media type
+	MediaType string `json:"media_type"`
+
+	// Digest is the manifest digest; the raw manifest is stored next to the metadata
+	Digest string `json:"digest"`
+}
+
+// Type returns the artifactType, or the config media type of artifacts without one
+func (a *Artifact) Type() string {
+	if a.ArtifactType != "" {
+		return a.ArtifactType
+	}
+	return a.ConfigMediaType
+}
+
+// Provenance records who and what produced a bundle
+type Provenance struct {
+	// User is the operator account that ran imgcd save
+	User string `json:"user,omitempty"`
+
+	// Host is the hostname of the producing machine
+	Host string `json:"host,omitempty"`
+
+	// GitRepository is the source repository of the pipeline, if known
+	GitRepository string `json:"git_repository,omitempty"`
+
+	// GitCommit is the source commit the pipeline was building, if known
+	GitCommit string `json:"git_commit,omitempty"`
+
+	// Pipeline is a URL or identifier of the CI run (e.g., a GitHub Actions run URL)
+	Pipeline string `json:"pipeline,omitempty"`
+
+	// Builder is the version of imgcd that produced the bundle
+	Builder string `json:"builder,omitempty"`
 }
 
 // LayerInfo contains information about a single layer in the bundle
@@ -58,4 +223,9 @@ type LayerInfo struct {
 
 	// MediaType is the layer media type (e.g., "application/vnd.docker.image.rootfs.diff.tar.gzip")
 	MediaType string `json:"media_type,omitempty"`
+
+	// Checksums are further digests of the compressed layer by algorithm
+	// (e.g., "sha512": "sha512:..."), recorded with save --checksum
+	// Verified by load and verify for the algorithms they support
+	Checksums map[string]string `json:"checksums,omitempty"`
 }
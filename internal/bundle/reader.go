@@ -0,0 +1,267 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"iter"
+	"path"
+	"strings"
+
+	"github.com/klauspost/pgzip"
+)
+
+// Names of the metadata entries of a bundle's image data
+const (
+	MetadataName       = "metadata.json"
+	LegacyMetadataName = "imgcd-meta.json" // Bundles saved in local mode (format 1.0)
+)
+
+// EntryKind says what an entry of a bundle's image data holds
+type EntryKind int
+
+const (
+	OtherEntry          EntryKind = iota // image.tar of legacy bundles, extras/, statements and the like
+	MetadataEntry                        // metadata.json, also decoded into Reader.Metadata
+	LegacyMetadataEntry                  // imgcd-meta.json of a legacy bundle
+	BlobEntry                            // blobs/<algorithm>/<hex>
+)
+
+// Entry is one file of a bundle's image data. Reading a blob to its end
+// checks it against its digest, unless the Reader skips digests.
+type Entry struct {
+	io.Reader
+	Header *tar.Header
+	Kind   EntryKind
+	Digest string // Digest of a BlobEntry
+}
+
+// Name returns the path of the entry in the image data
+func (e *Entry) Name() string {
+	return e.Header.Name
+}
+
+// Size returns the size of the entry's content
+func (e *Entry) Size() int64 {
+	return e.Header.Size
+}
+
+// Reader reads the image data of a bundle (image.tar.gz) entry by entry, in
+// one pass: the metadata, which imgcd writes first, and the blobs come from
+// the same read of the stream. Bundles repacked by other tools may store
+// the metadata later, so callers must not rely on it preceding the blobs.
+type Reader struct {
+	gzr         *pgzip.Reader
+	tr          *tar.Reader
+	metadata    *Metadata
+	rawMetadata []byte
+	skipDigests bool
+	done        bool
+
+	// With checksums on, the entries are hashed as they are passed; the
+	// current one is drained and its sum recorded on the next Next
+	checksums bool
+	sums      []Checksum
+	current   io.Reader
+	hash      hash.Hash
+}
+
+// NewReader starts reading the gzip compressed image data in r
+func NewReader(r io.Reader) (*Reader, error) {
+	gzr, err := pgzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("image data is not gzip: %w", err)
+	}
+	return &Reader{gzr: gzr, tr: tar.NewReader(gzr)}, nil
+}
+
+// SkipDigests turns off checking blobs against their digest, for callers
+// that hash them on their own
+func (r *Reader) SkipDigests() {
+	r.skipDigests = true
+}
+
+// Checksum turns on recording the sha256 of every entry, for Checksums. The
+// sha256 of a blob stored under a sha256 digest is that digest: it is not
+// hashed again, callers check it against its digest as usual.
+func (r *Reader) Checksum() {
+	r.checksums = true
+}
+
+// Checksums returns the sha256 of the entries passed so far, in their order
+func (r *Reader) Checksums() ([]Checksum, error) {
+	if err := r.finishEntry(); err != nil {
+		return nil, err
+	}
+	return r.sums, nil
+}
+
+// finishEntry reads the rest of the entry being hashed and records its sum
+func (r *Reader) finishEntry() error {
+	if r.current == nil {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, r.current); err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if r.hash != nil {
+		r.sums[len(r.sums)-1].SHA256 = hex.EncodeToString(r.hash.Sum(nil))
+	}
+	r.current, r.hash = nil, nil
+	return nil
+}
+
+// Next returns the next entry, or io.EOF after the last one. Reaching the
+// end also reads the gzip stream to its end, which checks its CRC and length.
+func (r *Reader) Next() (*Entry, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	if err := r.finishEntry(); err != nil {
+		return nil, err
+	}
+	header, err := r.tr.Next()
+	if err == io.EOF {
+		r.done = true
+		// pgzip's WriteTo panics on a stream the tar reader already
+		// consumed, so hide it
+		if _, err := io.Copy(io.Discard, struct{ io.Reader }{r.gzr}); err != nil {
+			return nil, fmt.Errorf("image data is corrupt: %w", err)
+		}
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	header.Name = CleanName(header.Name)
+	content := io.Reader(r.tr)
+	if r.checksums {
+		r.sums = append(r.sums, Checksum{Name: header.Name})
+		if digest, ok := BlobDigest(header.Name); ok && Algorithm(digest) == "sha256" {
+			r.sums[len(r.sums)-1].SHA256 = Encoded(digest)
+		} else {
+			r.hash = sha256.New()
+			content = io.TeeReader(r.tr, r.hash)
+		}
+		r.current = content
+	}
+
+	entry := &Entry{Reader: content, Header: header}
+	switch {
+	case header.Name == MetadataName:
+		raw, err := io.ReadAll(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		metadata, err := DecodeMetadata(raw)
+		if err != nil {
+			return nil, err
+		}
+		r.metadata, r.rawMetadata = metadata, raw
+		entry.Kind, entry.Reader = MetadataEntry, bytes.NewReader(raw)
+
+	case header.Name == LegacyMetadataName:
+		entry.Kind = LegacyMetadataEntry
+
+	default:
+		digest, ok := BlobDigest(header.Name)
+		if !ok {
+			break
+		}
+		entry.Kind, entry.Digest = BlobEntry, digest
+		if !r.skipDigests {
+			h, err := NewHash(Algorithm(digest))
+			if err != nil {
+				return nil, fmt.Errorf("blob %s: %w", digest, err)
+			}
+			entry.Reader = &digestReader{r: content, hash: h, digest: digest}
+		}
+	}
+	return entry, nil
+}
+
+// CleanName returns the name of a bundle entry as imgcd writes it: tar -C
+// dir -c . and similar repacking store "./metadata.json" and the like, and
+// some tools store absolute names. Names stay case-sensitive.
+func CleanName(name string) string {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// Metadata returns the decoded metadata.json once its entry was read; nil
+// before that and for legacy bundles
+func (r *Reader) Metadata() *Metadata {
+	return r.metadata
+}
+
+// RawMetadata returns metadata.json as it is stored
+func (r *Reader) RawMetadata() []byte {
+	return r.rawMetadata
+}
+
+// All iterates over the remaining entries; an error ends the iteration
+// after it is yielded
+func (r *Reader) All() iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		for {
+			entry, err := r.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(entry, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Blobs iterates over the remaining blobs, skipping other entries; the
+// metadata is still decoded when passed
+func (r *Reader) Blobs() iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		for entry, err := range r.All() {
+			if err == nil && entry.Kind != BlobEntry {
+				continue
+			}
+			if !yield(entry, err) {
+				return
+			}
+		}
+	}
+}
+
+// Close releases the decompressor; it does not close the underlying reader
+func (r *Reader) Close() error {
+	return r.gzr.Close()
+}
+
+// ErrDigestMismatch reports a blob whose content does not match its digest
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// digestReader hashes a blob while it is read and fails at its end when the
+// content does not match the digest
+type digestReader struct {
+	r      io.Reader
+	hash   hash.Hash
+	digest string
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := Algorithm(d.digest) + ":" + hex.EncodeToString(d.hash.Sum(nil)); actual != d.digest {
+			return n, fmt.Errorf("blob %s: %w (content is %s)", d.digest, ErrDigestMismatch, actual)
+		}
+	}
+	return n, err
+}
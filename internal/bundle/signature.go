@@ -0,0 +1,54 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SignatureName is the entry of a bundle tar (after imgcd and image.tar.gz)
+// that signs the entries before it
+const SignatureName = "signature.json"
+
+// signatureType identifies the payload format of a Signature
+const signatureType = "imgcd-bundle-signature/v1"
+
+// Signature is the embedded signature of a bundle: an Ed25519 signature of
+// Payload, the JSON of SignedEntries. Both are base64 in the file.
+type Signature struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+}
+
+// SignedEntries is what a Signature signs: the sha256 of every entry of the
+// bundle tar before the signature, in their order
+type SignedEntries struct {
+	Type    string        `json:"type"`
+	Entries []SignedEntry `json:"entries"`
+}
+
+// SignedEntry is one signed entry of a bundle tar
+type SignedEntry struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"` // sha256:<hex>
+}
+
+// SignaturePayload returns the payload that signs entries
+func SignaturePayload(entries []SignedEntry) ([]byte, error) {
+	return json.Marshal(SignedEntries{Type: signatureType, Entries: entries})
+}
+
+// ParseSignature decodes signature.json and its payload
+func ParseSignature(data []byte) (*Signature, *SignedEntries, error) {
+	signature := &Signature{}
+	if err := json.Unmarshal(data, signature); err != nil {
+		return nil, nil, fmt.Errorf("malformed %s: %w", SignatureName, err)
+	}
+	signed := &SignedEntries{}
+	if err := json.Unmarshal(signature.Payload, signed); err != nil {
+		return nil, nil, fmt.Errorf("malformed %s payload: %w", SignatureName, err)
+	}
+	if signed.Type != signatureType {
+		return nil, nil, fmt.Errorf("unsupported signature type %q", signed.Type)
+	}
+	return signature, signed, nil
+}
@@ -0,0 +1,203 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// MetadataVersion is the version of metadata.json Validate checks
+const MetadataVersion = "2"
+
+// hintResave is the remedy for metadata only a damaged or edited bundle has
+const hintResave = "the bundle is damaged or was edited; save it again with imgcd save"
+
+// MetadataError reports a field of metadata.json that is missing or wrong
+type MetadataError struct {
+	Field   string // Path of the field in the JSON, e.g. layers[2].diffid
+	Problem string
+	Hint    string // What to do about it
+}
+
+func (e *MetadataError) Error() string {
+	msg := fmt.Sprintf("invalid metadata.json: %s %s", e.Field, e.Problem)
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+// DecodeMetadata decodes and validates metadata.json
+func DecodeMetadata(raw []byte) (*Metadata, error) {
+	m := &Metadata{}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks that the metadata describes a bundle imgcd can load:
+// required fields are set, digests are well-formed and the stored layers
+// add up with the shared ones to the layers of the config. Only version 2
+// is checked; loads reject other versions themselves.
+func (m *Metadata) Validate() error {
+	if m.Version == "" {
+		return &MetadataError{Field: "version", Problem: "is missing",
+			Hint: "imgcd bundles record version " + MetadataVersion + "; this file was not written by imgcd save"}
+	}
+	if m.Version != MetadataVersion {
+		return nil
+	}
+	if m.ImageRef == "" {
+		return &MetadataError{Field: "image_ref", Problem: "is missing", Hint: hintResave}
+	}
+	if m.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, m.ExpiresAt); err != nil {
+			return &MetadataError{Field: "expires_at", Problem: fmt.Sprintf("is not an RFC 3339 time: %q", m.ExpiresAt),
+				Hint: "write it like 2026-01-31T00:00:00Z"}
+		}
+	}
+	if m.SharedLayerCount < 0 {
+		return &MetadataError{Field: "shared_layer_count", Problem: fmt.Sprintf("is negative (%d)", m.SharedLayerCount), Hint: hintResave}
+	}
+	if m.SharedLayerCount > 0 && m.BaseRef == "" {
+		return &MetadataError{Field: "base_ref", Problem: fmt.Sprintf("is missing, but shared_layer_count says %d layers come from the base image", m.SharedLayerCount),
+			Hint: "an incremental bundle names the image its shared layers are taken from; " + hintResave}
+	}
+
+	if m.Artifact != nil {
+		return m.validateArtifact()
+	}
+	if err := validateImage("", m.Config, m.Layers, m.SharedLayerCount); err != nil {
+		return err
+	}
+	for i, entry := range m.Images {
+		prefix := fmt.Sprintf("images[%d].", i)
+		if entry.ImageRef == "" {
+			return &MetadataError{Field: prefix + "image_ref", Problem: "is missing", Hint: hintResave}
+		}
+		// Further images are always stored whole
+		if err := validateImage(prefix, entry.Config, entry.Layers, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateArtifact checks the metadata of an OCI artifact bundle, whose
+// layers are its blobs, config blob included
+func (m *Metadata) validateArtifact() error {
+	if m.Artifact.ConfigMediaType == "" {
+		return &MetadataError{Field: "artifact.config_media_type", Problem: "is missing", Hint: hintResave}
+	}
+	if problem := digestProblem(m.Artifact.Digest); problem != "" {
+		return &MetadataError{Field: "artifact.digest", Problem: problem, Hint: hintResave}
+	}
+	if len(m.Layers) == 0 {
+		return &MetadataError{Field: "layers", Problem: "is empty, but an artifact stores at least its config blob", Hint: hintResave}
+	}
+	for i, layer := range m.Layers {
+		if err := validateLayer(fmt.Sprintf("layers[%d].", i), layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateImage checks the config and stored layers of one image: the
+// layers after the shared ones must be those of the config, in order
+func validateImage(prefix string, config *v1.ConfigFile, layers []LayerInfo, shared int) error {
+	if config == nil {
+		return &MetadataError{Field: prefix + "config", Problem: "is missing",
+			Hint: "container image bundles store the image config, artifact bundles set artifact instead; " + hintResave}
+	}
+	diffIDs := config.RootFS.DiffIDs
+	if len(diffIDs) == 0 {
+		return &MetadataError{Field: prefix + "config.rootfs.diff_ids", Problem: "is empty, but an image has at least one layer", Hint: hintResave}
+	}
+	if shared > len(diffIDs) {
+		return &MetadataError{Field: "shared_layer_count",
+			Problem: fmt.Sprintf("is %d, but config.rootfs.diff_ids lists only %d layers", shared, len(diffIDs)), Hint: hintResave}
+	}
+	if shared+len(layers) != len(diffIDs) {
+		return &MetadataError{Field: prefix + "layers",
+			Problem: fmt.Sprintf("has %d entries, but config.rootfs.diff_ids lists %d layers of which %d are shared with the base image, so %d should be stored",
+				len(layers), len(diffIDs), shared, len(diffIDs)-shared),
+			Hint: hintResave}
+	}
+
+	for i, layer := range layers {
+		field := fmt.Sprintf("%slayers[%d].", prefix, i)
+		if err := validateLayer(field, layer); err != nil {
+			return err
+		}
+		if layer.DiffID == "" {
+			return &MetadataError{Field: field + "diffid", Problem: "is missing", Hint: hintResave}
+		}
+		if _, err := v1.NewHash(layer.DiffID); err != nil {
+			return &MetadataError{Field: field + "diffid", Problem: fmt.Sprintf("is not a sha256 digest: %q", layer.DiffID), Hint: hintResave}
+		}
+		if want := diffIDs[shared+i].String(); layer.DiffID != want {
+			return &MetadataError{Field: field + "diffid",
+				Problem: fmt.Sprintf("is %s, but layer %d of config.rootfs.diff_ids is %s", layer.DiffID, shared+i, want), Hint: hintResave}
+		}
+	}
+	return nil
+}
+
+// validateLayer checks the digests and size of a stored blob
+func validateLayer(field string, layer LayerInfo) error {
+	if problem := digestProblem(layer.Digest); problem != "" {
+		return &MetadataError{Field: field + "digest", Problem: problem, Hint: hintResave}
+	}
+	if layer.Size < 0 {
+		return &MetadataError{Field: field + "size", Problem: fmt.Sprintf("is negative (%d)", layer.Size), Hint: hintResave}
+	}
+	for algorithm, digest := range layer.Checksums {
+		if Algorithm(digest) != algorithm || !isHex(Encoded(digest)) {
+			return &MetadataError{Field: field + "checksums." + algorithm,
+				Problem: fmt.Sprintf("is not a %s digest: %q", algorithm, digest), Hint: hintResave}
+		}
+	}
+	return nil
+}
+
+// digestLengths are the lengths of the hex part of digests by algorithm
+var digestLengths = map[string]int{SHA256: 64, SHA512: 128}
+
+// digestProblem describes what is wrong with a blob digest, "" if nothing
+func digestProblem(digest string) string {
+	if digest == "" {
+		return "is missing"
+	}
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	length, supported := digestLengths[algorithm]
+	switch {
+	case !ok:
+		return fmt.Sprintf("is not an <algorithm>:<hex> digest: %q", digest)
+	case !supported:
+		return fmt.Sprintf("uses an unsupported algorithm: %q (supported: %s)", digest, strings.Join(Algorithms, ", "))
+	case len(encoded) != length || !isHex(encoded):
+		return fmt.Sprintf("is not a %s digest of %d lowercase hex digits: %q", algorithm, length, digest)
+	}
+	return ""
+}
+
+// isHex reports whether s is non-empty lowercase hex
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -1,16 +1,21 @@
 package cache
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
 )
 
 // BlobMetadata contains metadata about a cached blob
@@ -25,8 +30,9 @@ type BlobMetadata struct {
 
 // BlobCacheIndex contains the index of all cached blobs
 type BlobCacheIndex struct {
-	Version   string                   `json:"version"` // Index format version
-	Blobs     map[string]*BlobMetadata `json:"blobs"`   // digest -> metadata
+	Version   string                   `json:"version"`          // Index format version
+	Blobs     map[string]*BlobMetadata `json:"blobs"`            // digest -> metadata
+	Pinned    []string                 `json:"pinned,omitempty"` // Image references (as given) whose blobs prune never removes
 	CreatedAt time.Time                `json:"created_at"`
 	UpdatedAt time.Time                `json:"updated_at"`
 }
@@ -40,21 +46,22 @@ type BlobCache struct {
 	index     *BlobCacheIndex
 	mu        sync.RWMutex
 	enabled   bool
+	lease     *Lease // Lease of the running job, nil when none is active
 }
 
 // NewBlobCache creates a new blob cache
 func NewBlobCache(enabled bool) (*BlobCache, error) {
-	if !enabled {
+	if !enabled || state.Disabled() {
 		return &BlobCache{enabled: false}, nil
 	}
 
-	homeDir, err := os.UserHomeDir()
+	stateDir, err := state.Dir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return nil, err
 	}
 
-	cacheDir := filepath.Join(homeDir, ".imgcd", "cache", "blobs", "sha256")
-	indexPath := filepath.Join(homeDir, ".imgcd", "cache", "index.json")
+	cacheDir := filepath.Join(stateDir, "cache", "blobs", "sha256")
+	indexPath := filepath.Join(stateDir, "cache", "index.json")
 
 	// Create cache directory
 	if err := os.MkdirAll(cacheDir, 0755); err != nil {
@@ -76,13 +83,18 @@ func NewBlobCache(enabled bool) (*BlobCache, error) {
 	// Load existing index
 	if err := bc.loadIndex(); err != nil {
 		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "Warning: failed to load cache index: %v\n", err)
+			ui.Warning("failed to load cache index: %v", err)
 		}
 	}
 
 	return bc, nil
 }
 
+// Enabled reports whether blobs are cached at all
+func (bc *BlobCache) Enabled() bool {
+	return bc.enabled
+}
+
 // Exists checks if a blob exists in the cache by digest
 func (bc *BlobCache) Exists(digest string) bool {
 	if !bc.enabled {
@@ -94,7 +106,9 @@ func (bc *BlobCache) Exists(digest string) bool {
 
 	digest = bc.normalizeDigest(digest)
 	_, exists := bc.index.Blobs[digest]
-	return exists
+	// A blob found for a leased job is held from now on, if another
+	// process has not removed it already
+	return exists && bc.holdBlob(digest)
 }
 
 // Get retrieves a blob from the cache
@@ -113,6 +127,7 @@ func (bc *BlobCache) Get(digest string) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("blob not in cache")
 	}
 
+	bc.holdBlob(digest)
 	blobPath := bc.getBlobPath(digest)
 	file, err := os.Open(blobPath)
 	if err != nil {
@@ -130,6 +145,39 @@ func (bc *BlobCache) Get(digest string) (io.ReadCloser, error) {
 	return file, nil
 }
 
+// Open opens a cached blob for reading without recording the access or
+// touching the index, for long-running readers such as imgcd serve that
+// must not write back a stale index over one updated by other processes
+func (bc *BlobCache) Open(digest string) (*os.File, error) {
+	if !bc.enabled {
+		return nil, fmt.Errorf("cache is disabled")
+	}
+	digest = bc.normalizeDigest(digest)
+	if _, err := v1.NewHash(digest); err != nil {
+		return nil, err
+	}
+	return os.Open(bc.getBlobPath(digest))
+}
+
+// AddImageRef records that imageRef uses a cached blob, so blobs shared
+// between images are kept by a pin on any of them
+func (bc *BlobCache) AddImageRef(digest, imageRef string) error {
+	if !bc.enabled {
+		return nil
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	meta, exists := bc.index.Blobs[bc.normalizeDigest(digest)]
+	if !exists || bc.containsImageRef(meta.ImageRefs, imageRef) {
+		return nil
+	}
+	meta.ImageRefs = append(meta.ImageRefs, imageRef)
+	bc.index.UpdatedAt = time.Now()
+	return bc.saveIndex()
+}
+
 // Put saves a blob to the cache
 // reader should be the compressed blob data from the registry
 // digest verification is performed during write
@@ -144,8 +192,9 @@ func (bc *BlobCache) Put(digest, diffID string, reader io.Reader, imageRef strin
 	digest = bc.normalizeDigest(digest)
 	diffID = bc.normalizeDigest(diffID)
 
-	// Check if already exists
-	if meta, exists := bc.index.Blobs[digest]; exists {
+	// Check if already exists; another process may have removed its file
+	present := bc.holdBlob(digest)
+	if meta, exists := bc.index.Blobs[digest]; exists && present {
 		// Update image refs if not already present
 		if !bc.containsImageRef(meta.ImageRefs, imageRef) {
 			meta.ImageRefs = append(meta.ImageRefs, imageRef)
@@ -172,7 +221,8 @@ func (bc *BlobCache) Put(digest, diffID string, reader io.Reader, imageRef strin
 	defer file.Close()
 
 	// Calculate digest while writing
-	hasher := sha256.New()
+	hasher := checksum.NewHasher()
+	defer hasher.Close()
 	tee := io.TeeReader(reader, hasher)
 
 	written, err := io.Copy(file, tee)
@@ -182,18 +232,23 @@ func (bc *BlobCache) Put(digest, diffID string, reader io.Reader, imageRef strin
 	}
 
 	// Verify digest matches
-	calculatedDigest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	calculatedDigest := hasher.Digest()
 	if calculatedDigest != digest {
 		os.Remove(blobPath)
 		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
 	}
 
-	// Add metadata
+	return bc.record(digest, diffID, written, imageRef)
+}
+
+// record adds a blob written to its cache path to the index; callers hold
+// the lock
+func (bc *BlobCache) record(digest, diffID string, size int64, imageRef string) error {
 	now := time.Now()
 	bc.index.Blobs[digest] = &BlobMetadata{
 		Digest:     digest,
 		DiffID:     diffID,
-		Size:       written,
+		Size:       size,
 		ImageRefs:  []string{imageRef},
 		LastAccess: now,
 		CreatedAt:  now,
@@ -204,6 +259,56 @@ func (bc *BlobCache) Put(digest, diffID string, reader io.Reader, imageRef strin
 	return bc.saveIndex()
 }
 
+// TempFile creates a file in the cache directory for a blob to be assembled
+// in and handed to PutFile, which then only renames it
+func (bc *BlobCache) TempFile() (*os.File, error) {
+	if !bc.enabled {
+		return nil, fmt.Errorf("cache is disabled")
+	}
+	return os.CreateTemp(bc.cacheDir, ".download-*")
+}
+
+// PutFile moves a complete blob file into the cache after verifying its
+// digest; the file is removed either way. path should come from TempFile,
+// so the move is a rename.
+func (bc *BlobCache) PutFile(digest, diffID, path, imageRef string) error {
+	defer os.Remove(path)
+	if !bc.enabled {
+		return nil
+	}
+
+	digest = bc.normalizeDigest(digest)
+	diffID = bc.normalizeDigest(diffID)
+	sum, err := checksum.File(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify blob: %w", err)
+	}
+	if "sha256:"+sum != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got sha256:%s", digest, sum)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	present := bc.holdBlob(digest)
+	if meta, exists := bc.index.Blobs[digest]; exists && present {
+		if !bc.containsImageRef(meta.ImageRefs, imageRef) {
+			meta.ImageRefs = append(meta.ImageRefs, imageRef)
+			bc.index.UpdatedAt = time.Now()
+			return bc.saveIndex()
+		}
+		return nil
+	}
+	if err := os.Rename(path, bc.getBlobPath(digest)); err != nil {
+		return fmt.Errorf("failed to move blob into the cache: %w", err)
+	}
+	return bc.record(digest, diffID, info.Size(), imageRef)
+}
+
 // GetMetadata returns metadata for a blob
 func (bc *BlobCache) GetMetadata(digest string) (*BlobMetadata, error) {
 	if !bc.enabled {
@@ -248,6 +353,23 @@ func (bc *BlobCache) Clean() error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
+	unlock, err := bc.lockLeases()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Running jobs keep their blobs
+	if leased := bc.leased(); len(leased) > 0 {
+		var blobs []*BlobMetadata
+		for digest, meta := range bc.index.Blobs {
+			if !leased[digest] {
+				blobs = append(blobs, meta)
+			}
+		}
+		return bc.remove(blobs)
+	}
+
 	// Remove all blob files
 	cacheRoot := filepath.Join(filepath.Dir(bc.cacheDir), "..")
 	if err := os.RemoveAll(cacheRoot); err != nil {
@@ -259,11 +381,12 @@ func (bc *BlobCache) Clean() error {
 		return fmt.Errorf("failed to recreate cache directory: %w", err)
 	}
 
-	// Reset index
+	// Reset index, keeping the pins
 	now := time.Now()
 	bc.index = &BlobCacheIndex{
 		Version:   "2",
 		Blobs:     make(map[string]*BlobMetadata),
+		Pinned:    bc.index.Pinned,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
@@ -271,46 +394,224 @@ func (bc *BlobCache) Clean() error {
 	return bc.saveIndex()
 }
 
+// PruneOptions selects the blobs Prune removes
+type PruneOptions struct {
+	MaxAge     time.Duration // Remove blobs not accessed for this long; 0 disables
+	UntilUnder int64         // Then remove least recently used blobs until the cache is under this many bytes; 0 disables
+	DryRun     bool          // Only report what would be removed
+}
+
+// PruneResult describes the blobs a prune removed (or would remove)
+type PruneResult struct {
+	Removed   []*BlobMetadata // Oldest first
+	Freed     int64
+	Remaining int64 // Cache size after the prune
+	Pinned    int64 // Size of the pinned blobs that were kept
+	Leased    int64 // Size of the unpinned blobs kept for running jobs
+}
+
 // Prune removes blobs that haven't been accessed in maxAge
 func (bc *BlobCache) Prune(maxAge time.Duration) (int, int64, error) {
+	result, err := bc.PruneWithOptions(PruneOptions{MaxAge: maxAge})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(result.Removed), result.Freed, nil
+}
+
+// PruneWithOptions removes blobs by age and then, least recently used first,
+// until the cache is under a size target
+func (bc *BlobCache) PruneWithOptions(opts PruneOptions) (*PruneResult, error) {
+	result := &PruneResult{}
 	if !bc.enabled {
-		return 0, 0, nil
+		return result, nil
 	}
 
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	cutoff := time.Now().Add(-maxAge)
-	toRemove := []string{}
-	var freedSpace int64
+	unlock, err := bc.lockLeases()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	leased := bc.leased()
+
+	// Least recently used first
+	blobs := make([]*BlobMetadata, 0, len(bc.index.Blobs))
+	for _, meta := range bc.index.Blobs {
+		result.Remaining += meta.Size
+		if bc.isPinned(meta) {
+			result.Pinned += meta.Size
+			continue
+		}
+		if leased[meta.Digest] {
+			result.Leased += meta.Size
+			continue
+		}
+		blobs = append(blobs, meta)
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].LastAccess.Before(blobs[j].LastAccess)
+	})
 
-	// Find blobs to remove
-	for digest, meta := range bc.index.Blobs {
-		if meta.LastAccess.Before(cutoff) {
-			toRemove = append(toRemove, digest)
-			freedSpace += meta.Size
+	cutoff := time.Now().Add(-opts.MaxAge)
+	for _, meta := range blobs {
+		expired := opts.MaxAge > 0 && meta.LastAccess.Before(cutoff)
+		oversize := opts.UntilUnder > 0 && result.Remaining > opts.UntilUnder
+		if !expired && !oversize {
+			continue
 		}
+		result.Removed = append(result.Removed, meta)
+		result.Freed += meta.Size
+		result.Remaining -= meta.Size
 	}
 
-	// Remove blobs
-	for _, digest := range toRemove {
-		blobPath := bc.getBlobPath(digest)
+	if opts.DryRun {
+		return result, nil
+	}
+	return result, bc.remove(result.Removed)
+}
+
+// remove deletes blobs and their index entries; callers hold the lock and
+// the lease lock, and have left out leased blobs
+func (bc *BlobCache) remove(blobs []*BlobMetadata) error {
+	if len(blobs) == 0 {
+		return nil
+	}
+
+	for _, meta := range blobs {
+		blobPath := bc.getBlobPath(meta.Digest)
 		os.Remove(blobPath)
 
 		// Remove directory if empty
 		blobDir := filepath.Dir(blobPath)
 		os.Remove(blobDir)
 
-		delete(bc.index.Blobs, digest)
+		delete(bc.index.Blobs, meta.Digest)
 	}
 
 	bc.index.UpdatedAt = time.Now()
+	return bc.saveIndex()
+}
+
+// Pin marks the blobs of an image as non-evictable, including blobs cached
+// for it later. It returns the number and size of its blobs already cached.
+func (bc *BlobCache) Pin(imageRef string) (int, int64, error) {
+	if !bc.enabled {
+		return 0, 0, fmt.Errorf("cache is disabled")
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	ref, err := canonicalImageRef(imageRef)
+	if err != nil {
+		return 0, 0, err
+	}
+	if bc.pinIndex(ref) < 0 {
+		bc.index.Pinned = append(bc.index.Pinned, imageRef)
+		sort.Strings(bc.index.Pinned)
+		bc.index.UpdatedAt = time.Now()
+		if err := bc.saveIndex(); err != nil {
+			return 0, 0, err
+		}
+	}
 
-	if err := bc.saveIndex(); err != nil {
-		return len(toRemove), freedSpace, err
+	var count int
+	var size int64
+	for _, meta := range bc.index.Blobs {
+		if bc.usedBy(meta, ref) {
+			count++
+			size += meta.Size
+		}
 	}
+	return count, size, nil
+}
 
-	return len(toRemove), freedSpace, nil
+// Unpin removes a pin; it reports whether the image was pinned
+func (bc *BlobCache) Unpin(imageRef string) (bool, error) {
+	if !bc.enabled {
+		return false, fmt.Errorf("cache is disabled")
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	ref, err := canonicalImageRef(imageRef)
+	if err != nil {
+		return false, err
+	}
+	i := bc.pinIndex(ref)
+	if i < 0 {
+		return false, nil
+	}
+	bc.index.Pinned = append(bc.index.Pinned[:i], bc.index.Pinned[i+1:]...)
+	bc.index.UpdatedAt = time.Now()
+	return true, bc.saveIndex()
+}
+
+// pinIndex returns the position of the canonical reference ref among the pins, or -1
+func (bc *BlobCache) pinIndex(ref string) int {
+	for i, pinned := range bc.index.Pinned {
+		if canonical, err := canonicalImageRef(pinned); err == nil && canonical == ref {
+			return i
+		}
+	}
+	return -1
+}
+
+// Pinned returns the pinned image references
+func (bc *BlobCache) Pinned() []string {
+	if !bc.enabled {
+		return nil
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return append([]string(nil), bc.index.Pinned...)
+}
+
+// IsPinned reports whether a blob belongs to a pinned image
+func (bc *BlobCache) IsPinned(meta *BlobMetadata) bool {
+	if !bc.enabled {
+		return false
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.isPinned(meta)
+}
+
+func (bc *BlobCache) isPinned(meta *BlobMetadata) bool {
+	for _, pinned := range bc.index.Pinned {
+		ref, err := canonicalImageRef(pinned)
+		if err == nil && bc.usedBy(meta, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// usedBy reports whether a blob was recorded for the canonical reference ref
+func (bc *BlobCache) usedBy(meta *BlobMetadata, ref string) bool {
+	for _, imageRef := range meta.ImageRefs {
+		if canonical, err := canonicalImageRef(imageRef); err == nil && canonical == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalImageRef makes "alpine" and "docker.io/library/alpine:latest" compare equal
+func canonicalImageRef(imageRef string) (string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %w", imageRef, err)
+	}
+	return ref.Name(), nil
 }
 
 // GetStats returns cache statistics
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// PreparedBundle records a bundle that was produced for a given set of inputs
+type PreparedBundle struct {
+	Key       string    `json:"key"`                 // Hash of everything that determines the bundle content
+	Path      string    `json:"path"`                // Absolute path of the bundle file
+	Size      int64     `json:"size"`                // Size of the bundle file
+	SHA256    string    `json:"sha256"`              // Hex sha256 of the bundle file
+	ImageRef  string    `json:"image_ref"`           // Image the bundle was created for
+	BaseRef   string    `json:"base_ref,omitempty"`  // Base image for incremental bundles
+	CreatedAt time.Time `json:"created_at"`          // When the bundle was created
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // Zero if the bundle never expires
+}
+
+// BundleIndexFile is the on-disk format of the prepared bundle index
+type BundleIndexFile struct {
+	Version string                     `json:"version"`
+	Bundles map[string]*PreparedBundle `json:"bundles"` // bundle path -> record
+}
+
+// BundleIndex remembers which inputs produced each bundle on disk, so an
+// unchanged export can be answered with the existing file
+type BundleIndex struct {
+	indexPath string
+	index     *BundleIndexFile
+	mu        sync.Mutex
+}
+
+// NewBundleIndex opens the prepared bundle index at ~/.imgcd/cache/bundles.json;
+// it fails with state.ErrDisabled under --no-state
+func NewBundleIndex() (*BundleIndex, error) {
+	stateDir, err := state.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	bi := &BundleIndex{
+		indexPath: filepath.Join(stateDir, "cache", "bundles.json"),
+		index: &BundleIndexFile{
+			Version: "1",
+			Bundles: make(map[string]*PreparedBundle),
+		},
+	}
+
+	// Load existing index
+	if err := bi.loadIndex(); err != nil {
+		if !os.IsNotExist(err) {
+			ui.Warning("failed to load bundle index: %v", err)
+		}
+	}
+
+	return bi, nil
+}
+
+// Lookup returns the record for bundlePath if it was produced with key and the
+// file on disk still matches the recorded size and checksum
+func (bi *BundleIndex) Lookup(bundlePath, key string) (*PreparedBundle, bool) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	record, exists := bi.index.Bundles[bundlePath]
+	if !exists || record.Key != key {
+		return nil, false
+	}
+
+	if !record.ExpiresAt.IsZero() && time.Now().After(record.ExpiresAt) {
+		return nil, false
+	}
+
+	info, err := os.Stat(bundlePath)
+	if err != nil || info.Size() != record.Size {
+		return nil, false
+	}
+
+	sum, err := checksum.File(bundlePath)
+	if err != nil || sum != record.SHA256 {
+		return nil, false
+	}
+
+	return record, true
+}
+
+// Record stores the inputs key for a freshly written bundle
+func (bi *BundleIndex) Record(record PreparedBundle) error {
+	info, err := os.Stat(record.Path)
+	if err != nil {
+		return err
+	}
+
+	sum, err := checksum.File(record.Path)
+	if err != nil {
+		return err
+	}
+
+	record.Size = info.Size()
+	record.SHA256 = sum
+
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	// Drop records whose bundle has since been deleted
+	for path := range bi.index.Bundles {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(bi.index.Bundles, path)
+		}
+	}
+
+	bi.index.Bundles[record.Path] = &record
+	return bi.saveIndex()
+}
+
+// Retained returns the recorded bundles that still exist on disk
+func (bi *BundleIndex) Retained() []*PreparedBundle {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	var bundles []*PreparedBundle
+	for path, record := range bi.index.Bundles {
+		if _, err := os.Stat(path); err == nil {
+			bundles = append(bundles, record)
+		}
+	}
+	return bundles
+}
+
+// Forget drops the record of a deleted bundle
+func (bi *BundleIndex) Forget(bundlePath string) error {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	if _, exists := bi.index.Bundles[bundlePath]; !exists {
+		return nil
+	}
+	delete(bi.index.Bundles, bundlePath)
+	return bi.saveIndex()
+}
+
+// loadIndex loads index from disk
+func (bi *BundleIndex) loadIndex() error {
+	data, err := os.ReadFile(bi.indexPath)
+	if err != nil {
+		return err
+	}
+
+	var index BundleIndexFile
+	if err := json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+
+	// Validate version
+	if index.Version != "1" {
+		return fmt.Errorf("unsupported bundle index version: %s (expected 1)", index.Version)
+	}
+	if index.Bundles == nil {
+		index.Bundles = make(map[string]*PreparedBundle)
+	}
+
+	bi.index = &index
+	return nil
+}
+
+// saveIndex saves index to disk
+func (bi *BundleIndex) saveIndex() error {
+	data, err := json.MarshalIndent(bi.index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(bi.indexPath), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(bi.indexPath, data, 0644)
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// GCRoots are the references that keep cached blobs alive. Pinned images
+// are taken from the cache index itself.
+type GCRoots struct {
+	Bundles   map[string][]string // Blob digest -> retained bundles containing it
+	KeepSince time.Time           // Blobs used since then are kept as recent history
+	Only      map[string]bool     // Restrict collection to these digests; nil considers every blob
+}
+
+// BlobRefs lists what references a cached blob
+type BlobRefs struct {
+	Blob    *BlobMetadata
+	Pinned  []string // Pinned images using the blob
+	Bundles []string // Retained bundles containing the blob
+	Recent  bool     // Used since GCRoots.KeepSince
+	Leased  bool     // Held by the lease of a running job
+}
+
+// Count returns the number of references; blobs without any are garbage
+func (r *BlobRefs) Count() int {
+	count := len(r.Pinned) + len(r.Bundles)
+	if r.Recent {
+		count++
+	}
+	if r.Leased {
+		count++
+	}
+	return count
+}
+
+// References counts the references of every cached blob, least recently used first
+func (bc *BlobCache) References(roots GCRoots) []*BlobRefs {
+	if !bc.enabled {
+		return nil
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	unlock, err := bc.lockLeases()
+	if err != nil {
+		return bc.references(roots, nil)
+	}
+	defer unlock()
+	return bc.references(roots, bc.leased())
+}
+
+func (bc *BlobCache) references(roots GCRoots, leased map[string]bool) []*BlobRefs {
+	pins := make(map[string]string)
+	for _, pinned := range bc.index.Pinned {
+		if ref, err := canonicalImageRef(pinned); err == nil {
+			pins[ref] = pinned
+		}
+	}
+
+	refs := make([]*BlobRefs, 0, len(bc.index.Blobs))
+	for digest, meta := range bc.index.Blobs {
+		r := &BlobRefs{
+			Blob:    meta,
+			Bundles: roots.Bundles[digest],
+			Recent:  !roots.KeepSince.IsZero() && meta.LastAccess.After(roots.KeepSince),
+			Leased:  leased[digest],
+		}
+		for _, imageRef := range meta.ImageRefs {
+			ref, err := canonicalImageRef(imageRef)
+			if pinned, ok := pins[ref]; err == nil && ok {
+				r.Pinned = append(r.Pinned, pinned)
+			}
+		}
+		refs = append(refs, r)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Blob.LastAccess.Before(refs[j].Blob.LastAccess)
+	})
+	return refs
+}
+
+// GC removes the blobs nothing references any more
+func (bc *BlobCache) GC(roots GCRoots, dryRun bool) (*PruneResult, error) {
+	result := &PruneResult{}
+	if !bc.enabled {
+		return result, nil
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	unlock, err := bc.lockLeases()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	for _, r := range bc.references(roots, bc.leased()) {
+		result.Remaining += r.Blob.Size
+		if r.Count() > 0 || (roots.Only != nil && !roots.Only[r.Blob.Digest]) {
+			if len(r.Pinned) > 0 {
+				result.Pinned += r.Blob.Size
+			}
+			continue
+		}
+		result.Removed = append(result.Removed, r.Blob)
+		result.Freed += r.Blob.Size
+		result.Remaining -= r.Blob.Size
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, bc.remove(result.Removed)
+}
@@ -9,6 +9,9 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
 )
 
 // LayerMetadata contains metadata about a cached layer
@@ -42,17 +45,17 @@ type LayerCache struct {
 
 // NewLayerCache creates a new layer cache
 func NewLayerCache(enabled bool) (*LayerCache, error) {
-	if !enabled {
+	if !enabled || state.Disabled() {
 		return &LayerCache{enabled: false}, nil
 	}
 
-	homeDir, err := os.UserHomeDir()
+	stateDir, err := state.Dir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return nil, err
 	}
 
-	cacheDir := filepath.Join(homeDir, ".imgcd", "cache", "layers", "sha256")
-	metadataPath := filepath.Join(homeDir, ".imgcd", "cache", "metadata.json")
+	cacheDir := filepath.Join(stateDir, "cache", "layers", "sha256")
+	metadataPath := filepath.Join(stateDir, "cache", "metadata.json")
 
 	// Create cache directory
 	if err := os.MkdirAll(cacheDir, 0755); err != nil {
@@ -71,7 +74,7 @@ func NewLayerCache(enabled bool) (*LayerCache, error) {
 	if err := lc.loadMetadata(); err != nil {
 		// If metadata doesn't exist or is corrupt, start fresh
 		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "Warning: failed to load cache metadata: %v\n", err)
+			ui.Warning("failed to load cache metadata: %v", err)
 		}
 	}
 
@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+const (
+	// leaseTTL is when a lease is taken as abandoned even if its process
+	// cannot be checked, e.g. one of another host sharing the state directory
+	leaseTTL = 24 * time.Hour
+
+	// lockTimeout bounds the wait for the lease lock; a lock file older
+	// than lockStale was left by a crashed process and is broken. Holders
+	// touch it every lockRefresh, however long a prune holds it.
+	lockTimeout = time.Minute
+	lockStale   = 30 * time.Second
+	lockRefresh = lockStale / 3
+)
+
+// Lease keeps the cached blobs a running job uses from being removed by
+// prune, gc or clean in this or any other imgcd process sharing the cache.
+// While a lease is active, every blob the BlobCache hands out or stores is
+// added to it. Leases live in cache/leases, one file per job.
+type Lease struct {
+	bc   *BlobCache
+	path string
+
+	mu   sync.Mutex
+	file leaseFile
+	held map[string]bool
+}
+
+// leaseFile is the on-disk form of a lease
+type leaseFile struct {
+	Job       string    `json:"job"`
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	CreatedAt time.Time `json:"created_at"`
+	Digests   []string  `json:"digests"`
+}
+
+// Lease starts a lease for a job, e.g. "save alpine:3.20"; Release ends it.
+// It returns a nil lease, which does nothing, when the cache is disabled.
+func (bc *BlobCache) Lease(job string) (*Lease, error) {
+	if !bc.enabled {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(bc.leaseDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lease directory: %w", err)
+	}
+	f, err := os.CreateTemp(bc.leaseDir(), "lease-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lease: %w", err)
+	}
+	f.Close()
+
+	host, _ := os.Hostname()
+	lease := &Lease{
+		bc:   bc,
+		path: f.Name(),
+		file: leaseFile{Job: job, PID: os.Getpid(), Host: host, CreatedAt: time.Now()},
+		held: make(map[string]bool),
+	}
+	if err := lease.write(); err != nil {
+		os.Remove(lease.path)
+		return nil, err
+	}
+
+	bc.mu.Lock()
+	bc.lease = lease
+	bc.mu.Unlock()
+	return lease, nil
+}
+
+// Release ends the lease; its blobs are evictable again
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	l.bc.mu.Lock()
+	if l.bc.lease == l {
+		l.bc.lease = nil
+	}
+	l.bc.mu.Unlock()
+	os.Remove(l.path)
+}
+
+// hold adds a blob to the lease. It reports whether the blob is still on
+// disk: holding under the lease lock means no prune can remove it after
+// that, but one may have removed it before.
+func (l *Lease) hold(digest string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	path := l.bc.getBlobPath(digest)
+	if l.held[digest] {
+		return fileExists(path), nil
+	}
+
+	unlock, err := l.bc.lockLeases()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	l.held[digest] = true
+	l.file.Digests = append(l.file.Digests, digest)
+	if err := l.write(); err != nil {
+		return false, err
+	}
+	return fileExists(path), nil
+}
+
+func (l *Lease) write() error {
+	data, err := json.Marshal(l.file)
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed, so readers never see a partial lease
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write lease: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write lease: %w", err)
+	}
+	return nil
+}
+
+// holdBlob adds a blob to the active lease, if any; callers hold bc.mu. It
+// reports whether the blob file is still there.
+func (bc *BlobCache) holdBlob(digest string) bool {
+	if bc.lease == nil {
+		return true
+	}
+	present, err := bc.lease.hold(digest)
+	if err != nil {
+		// Without the lease the job still runs, as it did before leases
+		ui.Warning("cached blob %s is not leased, a concurrent prune may remove it: %v", digest, err)
+		return true
+	}
+	return present
+}
+
+// leaseDir is where the lease files of running jobs are
+func (bc *BlobCache) leaseDir() string {
+	return filepath.Join(filepath.Dir(bc.indexPath), "leases")
+}
+
+// leased returns the blobs held by the leases of running jobs, removing the
+// leases of jobs that are gone; callers hold the lease lock
+func (bc *BlobCache) leased() map[string]bool {
+	entries, err := os.ReadDir(bc.leaseDir())
+	if err != nil {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	digests := make(map[string]bool)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "lease-") || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(bc.leaseDir(), entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var lease leaseFile
+		if err := json.Unmarshal(data, &lease); err != nil {
+			continue
+		}
+		if time.Since(lease.CreatedAt) > leaseTTL || (lease.Host == host && !processAlive(lease.PID)) {
+			os.Remove(path)
+			continue
+		}
+		for _, digest := range lease.Digests {
+			digests[digest] = true
+		}
+	}
+	return digests
+}
+
+// lockLeases takes the lock that orders adding blobs to leases against
+// removing blobs: a blob is either removed before a lease holds it, which
+// then finds it gone, or kept
+func (bc *BlobCache) lockLeases() (func(), error) {
+	if err := os.MkdirAll(bc.leaseDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lease directory: %w", err)
+	}
+	path := filepath.Join(bc.leaseDir(), "lock")
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return refreshLock(path), nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock cache leases: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the cache lease lock %s", path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// refreshLock keeps the lock file at path fresh until the returned unlock
+// removes it, so others do not break it while a long prune holds it
+func refreshLock(path string) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		os.Remove(path)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package cache
+
+// processAlive cannot check processes on this platform; leases of jobs that
+// are gone only expire after leaseTTL
+func processAlive(pid int) bool {
+	return true
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// CachedManifest records the manifest of one platform of an image whose
+// blobs went through the blob cache
+type CachedManifest struct {
+	Platform  string    `json:"platform"`   // e.g. linux/amd64
+	Digest    string    `json:"digest"`     // Manifest digest
+	MediaType string    `json:"media_type"` // Manifest media type
+	Size      int64     `json:"size"`       // Manifest size
+	Config    string    `json:"config"`     // Config digest
+	Layers    []string  `json:"layers"`     // Layer digests
+	UpdatedAt time.Time `json:"updated_at"` // When the manifest was last fetched
+}
+
+// ManifestIndexFile is the on-disk format of the manifest cache index
+type ManifestIndexFile struct {
+	Version string                       `json:"version"`
+	Images  map[string][]*CachedManifest `json:"images"` // canonical image reference -> one per platform
+}
+
+// ManifestCache keeps the manifests and configs of the images saved or
+// pulled from a registry, so imgcd serve can hand them out along with the
+// layers of the blob cache. Manifests and configs are stored by digest in
+// ~/.imgcd/cache/manifests.
+type ManifestCache struct {
+	dir       string
+	indexPath string
+	index     *ManifestIndexFile
+	mu        sync.Mutex
+}
+
+// NewManifestCache opens the manifest cache; it fails with
+// state.ErrDisabled under --no-state
+func NewManifestCache() (*ManifestCache, error) {
+	stateDir, err := state.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	mc := &ManifestCache{
+		dir:       filepath.Join(stateDir, "cache", "manifests"),
+		indexPath: filepath.Join(stateDir, "cache", "manifests.json"),
+	}
+	if err := mc.Reload(); err != nil {
+		ui.Warning("failed to load manifest index: %v", err)
+	}
+	return mc, nil
+}
+
+// Put records the manifest and config of one platform of an image,
+// replacing what was recorded for that platform before
+func (mc *ManifestCache) Put(imageRef string, manifest CachedManifest, rawManifest, rawConfig []byte) error {
+	ref, err := canonicalImageRef(imageRef)
+	if err != nil {
+		return err
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if err := mc.write(manifest.Digest, rawManifest); err != nil {
+		return err
+	}
+	if err := mc.write(manifest.Config, rawConfig); err != nil {
+		return err
+	}
+
+	// Another imgcd may have recorded images since this one was opened
+	if err := mc.reload(); err != nil {
+		return err
+	}
+	manifest.Size = int64(len(rawManifest))
+	manifest.UpdatedAt = time.Now()
+
+	manifests := mc.index.Images[ref]
+	for i, m := range manifests {
+		if m.Platform == manifest.Platform {
+			manifests = append(manifests[:i], manifests[i+1:]...)
+			break
+		}
+	}
+	manifests = append(manifests, &manifest)
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Platform < manifests[j].Platform })
+	mc.index.Images[ref] = manifests
+	return mc.saveIndex()
+}
+
+// write stores content under its digest unless it is there already
+func (mc *ManifestCache) write(digest string, content []byte) error {
+	path := mc.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create manifest cache directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s to the manifest cache: %w", digest, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Open opens a cached manifest or config by digest
+func (mc *ManifestCache) Open(digest string) (*os.File, error) {
+	if _, err := v1.NewHash(digest); err != nil {
+		return nil, err
+	}
+	return os.Open(mc.path(digest))
+}
+
+// Images returns the recorded images by canonical reference
+func (mc *ManifestCache) Images() map[string][]*CachedManifest {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	images := make(map[string][]*CachedManifest, len(mc.index.Images))
+	for ref, manifests := range mc.index.Images {
+		images[ref] = append([]*CachedManifest(nil), manifests...)
+	}
+	return images
+}
+
+// Repository returns the repository path of a canonical reference, as it is
+// pulled from imgcd serve: app/web for registry.example.com/app/web:1.0
+func Repository(imageRef string) string {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return ""
+	}
+	return ref.Context().RepositoryStr()
+}
+
+// Reload reads the index again, to see images recorded by other imgcd
+// processes since the cache was opened
+func (mc *ManifestCache) Reload() error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.reload()
+}
+
+// reload reads the index; callers hold the lock. A missing index is empty.
+func (mc *ManifestCache) reload() error {
+	mc.index = &ManifestIndexFile{
+		Version: "1",
+		Images:  make(map[string][]*CachedManifest),
+	}
+
+	data, err := os.ReadFile(mc.indexPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var index ManifestIndexFile
+	if err := json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+	if index.Version != "1" {
+		return fmt.Errorf("unsupported manifest index version: %s (expected 1)", index.Version)
+	}
+	if index.Images != nil {
+		mc.index = &index
+	}
+	return nil
+}
+
+// saveIndex saves the index to disk; callers hold the lock
+func (mc *ManifestCache) saveIndex() error {
+	data, err := json.MarshalIndent(mc.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(mc.indexPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(mc.indexPath, data, 0644)
+}
+
+// path returns the file of a cached manifest or config
+func (mc *ManifestCache) path(digest string) string {
+	return filepath.Join(mc.dir, bundle.Algorithm(digest), bundle.Encoded(digest))
+}
@@ -0,0 +1,266 @@
+// Package checksum writes and verifies the sidecar files that accompany
+// bundles: <bundle>.sha256 in sha256sum format and an optional Ed25519
+// signature of it in <bundle>.sha256.sig.
+package checksum
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoSidecar reports that a bundle has no checksum sidecar
+var ErrNoSidecar = errors.New("no checksum sidecar")
+
+// SidecarPath returns the checksum sidecar path of a bundle
+func SidecarPath(bundlePath string) string {
+	return bundlePath + ".sha256"
+}
+
+// SignaturePath returns the sidecar signature path of a bundle
+func SignaturePath(bundlePath string) string {
+	return SidecarPath(bundlePath) + ".sig"
+}
+
+// File returns the hex sha256 of a file
+func File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := NewHasher()
+	defer hasher.Close()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hasher.Sum(), nil
+}
+
+// WriteSidecar writes <bundle>.sha256 in the format sha256sum -c accepts
+func WriteSidecar(bundlePath string) error {
+	sum, err := File(bundlePath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum bundle: %w", err)
+	}
+
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(bundlePath))
+	if err := os.WriteFile(SidecarPath(bundlePath), []byte(line), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return nil
+}
+
+// Recorded returns the checksum the sidecar records for the bundle, or
+// ErrNoSidecar
+func Recorded(bundlePath string) (string, error) {
+	data, err := os.ReadFile(SidecarPath(bundlePath))
+	if os.IsNotExist(err) {
+		return "", ErrNoSidecar
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sum, err := Parse(data)
+	if err != nil {
+		return "", fmt.Errorf("malformed checksum file %s", SidecarPath(bundlePath))
+	}
+	return sum, nil
+}
+
+// Parse returns the hex sha256 of a sidecar's "<hex>  <filename>" content
+func Parse(data []byte) (string, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("malformed checksum file")
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", fmt.Errorf("malformed checksum file: %w", err)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// VerifySidecar checks the bundle against its sidecar. It returns
+// ErrNoSidecar when there is nothing to check against.
+func VerifySidecar(bundlePath string) error {
+	expected, err := Recorded(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	actual, err := File(bundlePath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum bundle: %w", err)
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", filepath.Base(bundlePath), expected, actual)
+	}
+	return nil
+}
+
+// SignSidecar signs the sidecar with an Ed25519 private key (PKCS#8 PEM,
+// e.g. from "openssl genpkey -algorithm ed25519") into <bundle>.sha256.sig
+func SignSidecar(bundlePath, keyPath string) error {
+	key, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	sidecar, err := os.ReadFile(SidecarPath(bundlePath))
+	if err != nil {
+		return err
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, sidecar)) + "\n"
+	if err := os.WriteFile(SignaturePath(bundlePath), []byte(signature), 0644); err != nil {
+		return fmt.Errorf("failed to write signature: %w", err)
+	}
+	return nil
+}
+
+// VerifySignature checks <bundle>.sha256.sig with an Ed25519 public key
+// (PKIX PEM, e.g. from "openssl pkey -pubout")
+func VerifySignature(bundlePath, keyPath string) error {
+	key, err := loadPublicKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	sidecar, err := os.ReadFile(SidecarPath(bundlePath))
+	if err != nil {
+		return err
+	}
+	encoded, err := os.ReadFile(SignaturePath(bundlePath))
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(key, sidecar, encoded); err != nil {
+		return fmt.Errorf("signature of %s does not match the key %s", SidecarPath(bundlePath), keyPath)
+	}
+	return nil
+}
+
+// VerifySignatureData is VerifySignature for a sidecar and signature held
+// in memory, e.g. downloaded next to a bundle URL
+func VerifySignatureData(sidecar, signature []byte, keyPath string) error {
+	key, err := loadPublicKey(keyPath)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(key, sidecar, signature); err != nil {
+		return fmt.Errorf("checksum signature does not match the key %s", keyPath)
+	}
+	return nil
+}
+
+// verifySignature checks a base64 signature of a sidecar
+func verifySignature(key ed25519.PublicKey, sidecar, encoded []byte) error {
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("malformed signature file: %w", err)
+	}
+	if !ed25519.Verify(key, sidecar, signature) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// SignData signs data with an Ed25519 private key (PKCS#8 PEM), e.g. the
+// payload of a bundle's embedded signature
+func SignData(data []byte, keyPath string) ([]byte, error) {
+	key, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(key, data), nil
+}
+
+// VerifyData checks an Ed25519 signature of data with a public key (PKIX
+// PEM)
+func VerifyData(data, signature []byte, keyPath string) error {
+	key, err := loadPublicKey(keyPath)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("signature does not match the key %s", keyPath)
+	}
+	return nil
+}
+
+// CheckPublicKey reports whether path holds a usable verification key
+func CheckPublicKey(path string) error {
+	_, err := loadPublicKey(path)
+	return err
+}
+
+// HasSignature reports whether the bundle has a signature sidecar
+func HasSignature(bundlePath string) bool {
+	_, err := os.Stat(SignaturePath(bundlePath))
+	return err == nil
+}
+
+// CheckSigningKey reports whether path holds a usable signing key, so callers
+// can fail before doing expensive work
+func CheckSigningKey(path string) error {
+	_, err := loadPrivateKey(path)
+	return err
+}
+
+// loadPrivateKey reads an Ed25519 private key from a PEM file
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// loadPublicKey reads an Ed25519 public key from a PEM file
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// readPEM returns the first PEM block of a file
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
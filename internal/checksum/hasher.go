@@ -0,0 +1,135 @@
+package checksum
+
+import (
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+const (
+	// ChunkSize is the size of the reads and hashing steps on large files;
+	// far above io.Copy's 32 KiB, which keeps fast disks busy
+	ChunkSize = 1 << 20
+
+	hasherQueue = 4 // Chunks buffered ahead of the hashing goroutine
+)
+
+// Hasher computes a digest on its own goroutine: writes are gathered into
+// chunks and handed over, so hashing overlaps with the reads, decompression
+// or writes of the caller. Call Sum for the result, and Close on paths that
+// give up before it.
+type Hasher struct {
+	chunks    chan []byte
+	done      chan struct{}
+	buffers   sync.Pool
+	current   []byte
+	hash      hash.Hash
+	algorithm string
+	closed    bool
+}
+
+// NewHasher starts a sha256 Hasher
+func NewHasher() *Hasher {
+	h, _ := NewAlgorithmHasher(bundle.SHA256)
+	return h
+}
+
+// NewAlgorithmHasher starts a Hasher of one of bundle.Algorithms
+func NewAlgorithmHasher(algorithm string) (*Hasher, error) {
+	hash, err := bundle.NewHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	h := &Hasher{
+		chunks:    make(chan []byte, hasherQueue),
+		done:      make(chan struct{}),
+		buffers:   sync.Pool{New: func() any { return make([]byte, 0, ChunkSize) }},
+		hash:      hash,
+		algorithm: algorithm,
+	}
+	go func() {
+		defer close(h.done)
+		for chunk := range h.chunks {
+			h.hash.Write(chunk)
+			h.buffers.Put(chunk[:0])
+		}
+	}()
+	return h, nil
+}
+
+// Write queues p for hashing; it only fails to satisfy io.Writer
+func (h *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if h.current == nil {
+			h.current = h.buffers.Get().([]byte)
+		}
+		free := ChunkSize - len(h.current)
+		if free > len(p) {
+			free = len(p)
+		}
+		h.current = append(h.current, p[:free]...)
+		p = p[free:]
+		if len(h.current) == ChunkSize {
+			h.flush()
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom reads r straight into chunks, so io.Copy into a Hasher reads in
+// ChunkSize steps instead of 32 KiB ones
+func (h *Hasher) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		if h.current == nil {
+			h.current = h.buffers.Get().([]byte)
+		}
+		n, err := r.Read(h.current[len(h.current):ChunkSize])
+		h.current = h.current[:len(h.current)+n]
+		total += int64(n)
+		if len(h.current) == ChunkSize {
+			h.flush()
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// flush hands the current chunk to the hashing goroutine
+func (h *Hasher) flush() {
+	if len(h.current) > 0 {
+		h.chunks <- h.current
+	}
+	h.current = nil
+}
+
+// Close stops the hashing goroutine; it is safe to call after Sum
+func (h *Hasher) Close() {
+	if h.closed {
+		return
+	}
+	h.closed = true
+	h.flush()
+	close(h.chunks)
+	<-h.done
+}
+
+// Sum waits for everything written to be hashed and returns the hex digest.
+// Nothing may be written after it.
+func (h *Hasher) Sum() string {
+	h.Close()
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// Digest returns Sum as an OCI digest, <algorithm>:<hex>
+func (h *Hasher) Digest() string {
+	return h.algorithm + ":" + h.Sum()
+}
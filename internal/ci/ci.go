@@ -0,0 +1,149 @@
+// Package ci reports results to CI systems under --ci: annotations in the
+// workflow command syntax of GitHub Actions, a job summary in Markdown and
+// step outputs, so pipelines need not scrape imgcd's output
+package ci
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Provider is the CI system imgcd runs in
+type Provider string
+
+const (
+	GitHub  Provider = "github" // GitHub Actions (GITHUB_ACTIONS=true)
+	GitLab  Provider = "gitlab" // GitLab CI (GITLAB_CI=true)
+	Generic Provider = "generic"
+)
+
+// Level is the severity of an annotation
+type Level string
+
+const (
+	Notice  Level = "notice"
+	Warning Level = "warning"
+	Error   Level = "error"
+)
+
+const (
+	// defaultSummaryPath is where the summary goes outside GitHub Actions,
+	// e.g. for a GitLab artifact with expose_as
+	defaultSummaryPath = "imgcd-summary.md"
+	// defaultOutputPath takes step outputs outside GitHub Actions, in the
+	// format of a GitLab dotenv report
+	defaultOutputPath = "imgcd.env"
+)
+
+var (
+	enabled     bool
+	provider    Provider
+	summaryPath string
+)
+
+// Configure applies --ci and --ci-summary; IMGCD_CI=1 also turns CI mode
+// on. The provider is detected from the environment. Without --ci-summary
+// the summary goes to $GITHUB_STEP_SUMMARY on GitHub Actions and to
+// imgcd-summary.md elsewhere.
+func Configure(ciMode bool, summary string) {
+	enabled = ciMode || os.Getenv("IMGCD_CI") == "1"
+	provider = detect()
+	summaryPath = summary
+	if summaryPath == "" {
+		summaryPath = defaultSummaryPath
+		if provider == GitHub && os.Getenv("GITHUB_STEP_SUMMARY") != "" {
+			summaryPath = os.Getenv("GITHUB_STEP_SUMMARY")
+		}
+	}
+}
+
+// detect returns the CI system of the environment
+func detect() Provider {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		return GitHub
+	case os.Getenv("GITLAB_CI") == "true":
+		return GitLab
+	}
+	return Generic
+}
+
+// Enabled reports whether CI mode is on
+func Enabled() bool {
+	return enabled
+}
+
+// Annotate reports a message at level. GitHub Actions gets a workflow
+// command (::warning title=...::message); other providers get a
+// "LEVEL: title: message" line, which GitLab highlights in the job log.
+func Annotate(level Level, title, format string, args ...any) {
+	if !enabled {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	if provider != GitHub {
+		if title != "" {
+			message = title + ": " + message
+		}
+		fmt.Printf("%s: %s\n", strings.ToUpper(string(level)), message)
+		return
+	}
+	command := "::" + string(level)
+	if title != "" {
+		command += " title=" + escapeProperty(title)
+	}
+	fmt.Printf("%s::%s\n", command, escapeData(message))
+}
+
+// escapeData escapes the message of a workflow command
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value of a workflow command
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// Summary appends Markdown to the job summary
+func Summary(markdown string) error {
+	if !enabled {
+		return nil
+	}
+	return appendFile(summaryPath, strings.TrimRight(markdown, "\n")+"\n\n")
+}
+
+// Output sets step outputs: in $GITHUB_OUTPUT on GitHub Actions, in
+// imgcd.env (KEY=value, for a GitLab dotenv report) elsewhere. Keys are
+// written in the order given.
+func Output(pairs ...string) error {
+	if !enabled || len(pairs) == 0 {
+		return nil
+	}
+	path := defaultOutputPath
+	if provider == GitHub && os.Getenv("GITHUB_OUTPUT") != "" {
+		path = os.Getenv("GITHUB_OUTPUT")
+	}
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key, value := pairs[i], pairs[i+1]
+		if provider != GitHub {
+			key = "IMGCD_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		}
+		fmt.Fprintf(&b, "%s=%s\n", key, strings.ReplaceAll(value, "\n", " "))
+	}
+	return appendFile(path, b.String())
+}
+
+func appendFile(path, content string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/blang/semver"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// commandAlias is another name a command answers to. Deprecated names (old
+// names of renamed commands) warn on every use until RemovedIn, the release
+// that drops them; from then on they fail and point to the current name.
+type commandAlias struct {
+	Command   *cobra.Command
+	Name      string
+	RemovedIn string // Release removing the name, e.g. "v0.9.0"; "" for a permanent alias
+}
+
+// commandAliases lists every alias. To rename a command, change its Use and
+// add the old name here with a RemovedIn a few minor releases ahead, so
+// scripts keep working and their users see the warning first.
+var commandAliases = []commandAlias{
+	// Docker-like verbs
+	{Command: saveCmd, Name: "export"},
+	{Command: loadCmd, Name: "import"},
+	{Command: cacheListCmd, Name: "ls"},
+}
+
+func init() {
+	for _, alias := range commandAliases {
+		alias.Command.Aliases = append(alias.Command.Aliases, alias.Name)
+	}
+}
+
+// checkDeprecatedName warns when cmd was called by a deprecated name, and
+// fails when this release is past the name's removal
+func checkDeprecatedName(cmd *cobra.Command) error {
+	calledAs := cmd.CalledAs()
+	for _, alias := range commandAliases {
+		if alias.Command != cmd || alias.Name != calledAs || alias.RemovedIn == "" {
+			continue
+		}
+
+		oldPath := calledAs
+		if cmd.HasParent() {
+			oldPath = cmd.Parent().CommandPath() + " " + calledAs
+		}
+		if releaseReached(alias.RemovedIn) {
+			return fmt.Errorf("%s was removed in %s; use %s instead", oldPath, alias.RemovedIn, cmd.CommandPath())
+		}
+		ui.Warning("%s is deprecated and will be removed in %s; use %s instead", oldPath, alias.RemovedIn, cmd.CommandPath())
+	}
+	return nil
+}
+
+// releaseReached reports whether this build is the given release or a later
+// one; development builds never reach a release
+func releaseReached(release string) bool {
+	current, err := semver.Parse(stripVersionPrefix(Version))
+	if err != nil {
+		return false
+	}
+	target, err := semver.Parse(stripVersionPrefix(release))
+	if err != nil {
+		return false
+	}
+	return current.GTE(target)
+}
@@ -0,0 +1,396 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/priority"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyParallel      int
+	applyEnforceExpiry bool
+	applySquashExcess  bool
+	applyChecksumKey   string
+	applyIOPriority    string
+	applyImportRate    string
+	applyCheckRunning  bool
+	applyForce         bool
+	applyFailFast      bool
+	applyReport        string
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply <BUNDLE|DIR>...",
+	Short: "Import several bundles, in parallel where possible",
+	Long: `Import several bundles at once, e.g. when seeding a host from a directory
+of bundles.
+
+Directories are searched for bundles (.tar, .tar.gz and .sh). Bundles of the
+same incremental chain (one's --since image is another's image) are always
+imported one after another, base first. Independent chains are imported
+concurrently by up to --parallel workers.
+
+With more than one worker, each bundle's output is collected and only shown
+when it fails; progress is reported one line per bundle.
+
+A bundle that fails, or cannot even be read, does not stop the others; only
+the rest of its own chain is skipped, since it builds on the failed image.
+Images of a bundle of several images are loaded even if one of them fails.
+At the end a table lists every image with its outcome (loaded, failed or
+skipped), and --report writes the same to a JSON file. apply exits with
+status 3 when some images loaded and others failed or were skipped, and 1
+when none loaded. --fail-fast starts nothing new after the first failure.
+
+Examples:
+  # Import every bundle of a directory, four at a time
+  imgcd apply ./bundles --parallel 4
+
+  # Seed a host and keep a record of what loaded, for the delivery log
+  imgcd apply ./bundles --report apply-report.json
+
+  # Import an incremental chain in the right order
+  imgcd apply app-1.1__since-1.0.tar app-1.0__since-none.tar`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runApply,
+}
+
+func init() {
+	applyCmd.Flags().IntVarP(&applyParallel, "parallel", "p", 1, "Number of independent bundle chains imported concurrently")
+	applyCmd.Flags().BoolVar(&applySquashExcess, "squash-excess", false, "Squash the top layers of images deeper than 125 layers into one so overlay2 can load them")
+	applyCmd.Flags().BoolVar(&applyEnforceExpiry, "enforce-expiry", false, "Refuse to load bundles past their expiry date (default: warn only)")
+	applyCmd.Flags().StringVar(&applyChecksumKey, "checksum-key", "", "Ed25519 public key (PEM) the .sha256.sig signatures must verify against")
+	applyCmd.Flags().StringVar(&applyIOPriority, "io-priority", priority.Normal, "CPU and IO priority: normal, low or idle")
+	applyCmd.Flags().StringVar(&applyImportRate, "import-rate-limit", "", "Maximum rate each image is streamed into the runtime, per second (e.g., 50M, 1G)")
+	applyCmd.Flags().BoolVar(&applyCheckRunning, "check-running", false, "Before importing, look for running containers of the image's repository and refuse to retag a tag they run")
+	applyCmd.Flags().BoolVar(&applyForce, "force", false, "With --check-running, load even if running containers use the tag")
+	applyCmd.Flags().BoolVar(&applyFailFast, "fail-fast", false, "Skip all bundles not started yet once one fails")
+	applyCmd.Flags().StringVar(&applyReport, "report", "", "Write the outcome of every image (loaded, failed or skipped, error, time) to this JSON file")
+}
+
+// applyItem is one bundle to import
+type applyItem struct {
+	path    string
+	summary *image.BundleSummary
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	if applyParallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+	rateLimit, err := setIOLimits(applyIOPriority, applyImportRate)
+	if err != nil {
+		return err
+	}
+
+	paths, err := findBundles(args)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no bundles found")
+	}
+
+	// A bundle that cannot be read fails alone; it has no place in a chain
+	report := &image.LoadReport{}
+	items := make([]*applyItem, 0, len(paths))
+	unreadable := 0
+	for _, path := range paths {
+		summary, err := image.ReadBundleSummary(path)
+		if err != nil {
+			ui.Failure("%s cannot be read: %v", path, err)
+			report.Add(image.ImageStatus{Bundle: path, Status: image.StatusFailed, Error: err.Error()})
+			unreadable++
+			continue
+		}
+		items = append(items, &applyItem{path: path, summary: summary})
+	}
+
+	chains := groupChains(items)
+
+	// Importers are created before any chain is handed out, so that no
+	// chain goes to a worker unable to import it
+	var importers []*image.Importer
+	var importerErr error
+	for len(importers) < min(applyParallel, len(chains)) {
+		importer, err := image.NewImporter()
+		if err != nil {
+			importerErr = fmt.Errorf("failed to create importer: %w", err)
+			break
+		}
+		defer importer.Close()
+		importers = append(importers, importer)
+	}
+	if importerErr != nil && len(importers) > 0 {
+		ui.Warning("Only %d of %d worker(s) started: %v", len(importers), min(applyParallel, len(chains)), importerErr)
+	}
+	workers := len(importers)
+	fmt.Printf("Importing %d bundle(s) in %d chain(s) with %d worker(s)\n", len(items), len(chains), workers)
+
+	progress := &applyProgress{total: len(items), failed: unreadable, quiet: workers > 1, report: report}
+	jobs := make(chan []*applyItem)
+	var wg sync.WaitGroup
+	for _, importer := range importers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chain := range jobs {
+				applyChain(cmd.Context(), importer, chain, rateLimit, progress)
+			}
+		}()
+	}
+	for _, chain := range chains {
+		if workers == 0 {
+			for _, item := range chain {
+				progress.skip(item, importerErr.Error())
+			}
+			continue
+		}
+		jobs <- chain
+	}
+	close(jobs)
+	wg.Wait()
+
+	if progress.failed > 0 {
+		err = fmt.Errorf("%d of %d bundle(s) failed, %d skipped", progress.failed, len(paths), progress.skipped)
+	} else if workers == 0 {
+		err = importerErr
+	}
+	if err := finishLoadReport(cmd, report, applyReport, true, err); err != nil {
+		return err
+	}
+	ui.Success("Imported %d bundle(s)", len(items))
+	return nil
+}
+
+// applyChain imports the bundles of one chain in order; after a failure the
+// rest of the chain is skipped since it builds on the failed image. With
+// --fail-fast, a chain reached after another failed is skipped whole.
+func applyChain(ctx context.Context, importer *image.Importer, chain []*applyItem, rateLimit int64, progress *applyProgress) {
+	if applyFailFast && progress.hasFailed() {
+		for _, item := range chain {
+			progress.skip(item, "an earlier bundle failed (--fail-fast)")
+		}
+		return
+	}
+
+	for i, item := range chain {
+		out := progress.start(item)
+		started := time.Now()
+
+		expected, err := sidecarChecksum(out, item.path, applyChecksumKey, "")
+		if err == nil {
+			opts := image.LoadOptions{
+				EnforceExpiry:   applyEnforceExpiry,
+				SquashExcess:    applySquashExcess,
+				ImportRateLimit: rateLimit,
+				CheckRunning:    applyCheckRunning,
+				Force:           applyForce,
+				SHA256:          expected,
+				FailFast:        applyFailFast,
+				Report:          progress.report,
+				Output:          out,
+			}
+			_, err = importer.Import(ctx, item.path, opts)
+		}
+
+		progress.finish(item, time.Since(started), err, out)
+		if err != nil {
+			for _, rest := range chain[i+1:] {
+				progress.skip(rest, "its chain failed at "+item.summary.ImageRef)
+			}
+			return
+		}
+	}
+}
+
+// findBundles expands directories into the bundle files they contain
+func findBundles(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			n := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(n, ".") {
+				continue
+			}
+			if strings.HasSuffix(n, ".tar") || strings.HasSuffix(n, ".tar.gz") || strings.HasSuffix(n, ".sh") {
+				paths = append(paths, filepath.Join(arg, n))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// groupChains splits bundles into incremental chains, each ordered base
+// first; bundles of different chains do not depend on each other
+func groupChains(items []*applyItem) [][]*applyItem {
+	// Union-find over image references
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(ref string) string {
+		if p, ok := parent[ref]; ok && p != ref {
+			root := find(p)
+			parent[ref] = root
+			return root
+		}
+		parent[ref] = ref
+		return ref
+	}
+	union := func(a, b string) {
+		parent[find(a)] = find(b)
+	}
+
+	producer := make(map[string]*applyItem)
+	for _, item := range items {
+		ref := canonicalRef(item.summary.ImageRef)
+		find(ref)
+		if item.summary.BaseRef != "" {
+			union(ref, canonicalRef(item.summary.BaseRef))
+		}
+		producer[ref] = item
+	}
+
+	// Depth in the chain: 0 for bundles whose base is not in the set
+	depth := make(map[*applyItem]int)
+	var depthOf func(*applyItem, int) int
+	depthOf = func(item *applyItem, guard int) int {
+		if d, ok := depth[item]; ok {
+			return d
+		}
+		d := 0
+		if base, ok := producer[canonicalRef(item.summary.BaseRef)]; ok && base != item && guard < len(items) {
+			d = depthOf(base, guard+1) + 1
+		}
+		depth[item] = d
+		return d
+	}
+
+	groups := make(map[string][]*applyItem)
+	var roots []string
+	for _, item := range items {
+		root := find(canonicalRef(item.summary.ImageRef))
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], item)
+		depthOf(item, 0)
+	}
+
+	chains := make([][]*applyItem, 0, len(roots))
+	for _, root := range roots {
+		chain := groups[root]
+		sort.SliceStable(chain, func(i, j int) bool {
+			if depth[chain[i]] != depth[chain[j]] {
+				return depth[chain[i]] < depth[chain[j]]
+			}
+			return chain[i].summary.CreatedAt < chain[j].summary.CreatedAt
+		})
+		chains = append(chains, chain)
+	}
+
+	// Start the longest chains first so they do not finish last
+	sort.SliceStable(chains, func(i, j int) bool { return len(chains[i]) > len(chains[j]) })
+	return chains
+}
+
+// canonicalRef makes "alpine" and "docker.io/library/alpine:latest" compare equal
+func canonicalRef(ref string) string {
+	if ref == "" {
+		return ""
+	}
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return ref
+	}
+	return parsed.Name()
+}
+
+// applyProgress reports the bundles of concurrent workers one line each
+type applyProgress struct {
+	mu      sync.Mutex
+	total   int
+	started int
+	done    int
+	failed  int
+	skipped int
+	quiet   bool // Collect each bundle's output instead of streaming it
+
+	report *image.LoadReport // Outcome of every image
+}
+
+// start announces a bundle and returns where its output goes
+func (p *applyProgress) start(item *applyItem) io.Writer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.started++
+	fmt.Printf("[%d/%d] Importing %s (%s)\n", p.started, p.total, item.summary.ImageRef, filepath.Base(item.path))
+	if p.quiet {
+		return &bytes.Buffer{}
+	}
+	return os.Stdout
+}
+
+func (p *applyProgress) finish(item *applyItem, elapsed time.Duration, err error, out io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	if err == nil {
+		ui.Success("%s imported in %s (%d/%d done)", item.summary.ImageRef, humanize.Duration(elapsed), p.done, p.total)
+		return
+	}
+
+	p.failed++
+	ui.Failure("%s failed: %v", item.summary.ImageRef, err)
+	if log, ok := out.(*bytes.Buffer); ok && log.Len() > 0 {
+		for _, line := range strings.Split(strings.TrimRight(log.String(), "\n"), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+}
+
+func (p *applyProgress) skip(item *applyItem, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	p.skipped++
+	fmt.Printf("- %s skipped, %s\n", item.summary.ImageRef, reason)
+	for _, ref := range item.summary.ImageRefs() {
+		p.report.Add(image.ImageStatus{Bundle: item.path, Image: ref, Status: image.StatusSkipped, Error: reason})
+	}
+}
+
+// hasFailed reports whether a bundle failed so far
+func (p *applyProgress) hasFailed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failed > 0
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/attest"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	attestOutput   string
+	attestManifest string
+	attestTitle    string
+)
+
+var attestCmd = &cobra.Command{
+	Use:   "attest [BUNDLE...]",
+	Short: "Print an attestation page for a delivery",
+	Long: `Create a printable attestation page for a delivery on physical media.
+
+The page lists every file with its size and sha256, and the sha256 of the
+whole manifest (the SHA256SUMS file) as text and as a QR code. Send it to
+the receiving site over a separate channel (paper, fax, phone); they compare
+it with "sha256sum SHA256SUMS" on the media before running verify.sh.
+
+Bundles are listed with their .sha256 and .sha256.sig files, matching what
+"imgcd save --to iso:" puts on the media. Use --manifest to attest an
+existing SHA256SUMS file instead, e.g. from a mounted ISO.
+
+The output format follows the file extension: .pdf for a PDF page,
+anything else (or - for stdout) for plain text.
+
+Examples:
+  # Attestation for the bundle burnt with imgcd save --to iso:
+  imgcd attest out/myapp-2.0__since-1.9.tar -o attestation.pdf
+
+  # Attest the media contents as mounted
+  imgcd attest --manifest /media/cdrom/SHA256SUMS --title "OPS-42 transfer"`,
+	RunE: runAttest,
+}
+
+func init() {
+	attestCmd.Flags().StringVarP(&attestOutput, "output", "o", "-", "Output file (.pdf for PDF, otherwise text; - for stdout)")
+	attestCmd.Flags().StringVar(&attestManifest, "manifest", "", "Attest an existing SHA256SUMS file instead of bundles")
+	attestCmd.Flags().StringVar(&attestTitle, "title", "", "Delivery reference printed on the page (e.g., a ticket number)")
+}
+
+func runAttest(cmd *cobra.Command, args []string) error {
+	var manifest *attest.Manifest
+	var err error
+	switch {
+	case attestManifest != "" && len(args) > 0:
+		return fmt.Errorf("use either bundles or --manifest, not both")
+	case attestManifest != "":
+		manifest, err = attest.ReadSHA256SUMS(attestManifest)
+	case len(args) > 0:
+		manifest, err = attest.FromFiles(withSidecars(args))
+	default:
+		return fmt.Errorf("no bundles given (or use --manifest)")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build manifest: %w", err)
+	}
+	if len(manifest.Entries) == 0 {
+		return fmt.Errorf("manifest is empty")
+	}
+
+	a := &attest.Attestation{Title: attestTitle, Created: time.Now(), Manifest: manifest}
+
+	if attestOutput == "-" {
+		return a.WriteText(os.Stdout)
+	}
+
+	file, err := os.Create(attestOutput)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(attestOutput), ".pdf") {
+		err = a.WritePDF(file)
+	} else {
+		err = a.WriteText(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write attestation: %w", err)
+	}
+
+	ui.Success("Attestation written: %s", attestOutput)
+	fmt.Printf("  Manifest SHA-256: %s\n", manifest.Digest())
+	return nil
+}
+
+// withSidecars adds the checksum files that accompany each bundle
+func withSidecars(bundles []string) []string {
+	var paths []string
+	for _, bundlePath := range bundles {
+		paths = append(paths, bundlePath)
+		for _, sidecar := range []string{checksum.SidecarPath(bundlePath), checksum.SignaturePath(bundlePath)} {
+			if _, err := os.Stat(sidecar); err == nil {
+				paths = append(paths, sidecar)
+			}
+		}
+	}
+	return paths
+}
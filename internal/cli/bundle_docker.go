@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	toDockerOutput   string
+	toDockerImage    string
+	toDockerPlatform string
+	toDockerBase     string
+
+	fromDockerOutDir    string
+	fromDockerPlatform  string
+	fromDockerTag       string
+	fromDockerLayerList string
+	fromDockerSince     string
+)
+
+var bundleToDockerCmd = &cobra.Command{
+	Use:   "to-docker <BUNDLE> -o <FILE>",
+	Short: "Write an image of a bundle as a docker save archive",
+	Long: `Rebuild an image of a bundle as a docker save archive (manifest.json, config
+and one uncompressed tar per layer), without a container runtime. The archive
+loads with docker load, podman load or ctr images import, and can be inspected
+or scanned as it is.
+
+Layers are checked against their DiffID while they are written. A bundle of
+several images or platforms needs --image and/or --platform to pick one. An
+incremental bundle lacks the layers it shares with its base: pass a docker
+save archive of the base with --base to take them from there. With -o -, the
+archive is written to stdout and messages go to stderr.
+
+Examples:
+  # Archive for docker load
+  imgcd bundle to-docker myapp-2.0__since-none.sh -o myapp-2.0.tar
+
+  # Incremental bundle, with the base saved on the same machine
+  docker save myapp:1.9 -o myapp-1.9.tar
+  imgcd bundle to-docker myapp-2.0__since-1.9.sh --base myapp-1.9.tar -o myapp-2.0.tar
+
+  # One platform of a multi-platform bundle, straight into docker
+  imgcd bundle to-docker myapp-2.0__since-none.tar --platform linux/arm64 -o - | docker load`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBundleToDocker,
+}
+
+var bundleFromDockerCmd = &cobra.Command{
+	Use:   "from-docker <ARCHIVE.tar>",
+	Short: "Turn a docker save archive into a bundle",
+	Long: `Turn the images of a docker save archive into a bundle, for machines that
+build with docker save but deliver with imgcd. Every image the archive holds
+goes into the bundle, named by its first tag; an archive of one untagged
+image (docker save <ID>) needs --tag.
+
+With --since-layer-list, the bundle is incremental: the layers the image
+shares with the base image on the target are left out and taken from it on
+load. The list is the base's layers as the target reports them: the file
+imgcd layers <BASE> -o FILE writes, the output of docker inspect <BASE>, or
+one DiffID per line, e.g. from
+docker inspect -f '{{range .RootFS.Layers}}{{println .}}{{end}}' <BASE>.
+The first two name the base; a plain list needs --since.
+
+Examples:
+  # Everything in the archive
+  docker save myapp:2.0 -o myapp-2.0.tar
+  imgcd bundle from-docker myapp-2.0.tar
+
+  # Only what the target does not have yet
+  ssh target docker inspect myapp:1.9 > base.json
+  imgcd bundle from-docker myapp-2.0.tar --since-layer-list base.json
+
+  # A plain list of DiffIDs, and an image saved by ID
+  imgcd bundle from-docker image.tar --tag myapp:2.0 --since-layer-list layers.txt --since myapp:1.9`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBundleFromDocker,
+}
+
+func init() {
+	bundleToDockerCmd.Flags().StringVarP(&toDockerOutput, "output", "o", "", "Archive to write, - for stdout (required)")
+	bundleToDockerCmd.MarkFlagRequired("output")
+	bundleToDockerCmd.Flags().StringVar(&toDockerImage, "image", "", "Image to write, for bundles of several images")
+	bundleToDockerCmd.Flags().StringVar(&toDockerPlatform, "platform", "", "Platform to write, for images saved for several platforms (e.g., linux/arm64)")
+	bundleToDockerCmd.Flags().StringVar(&toDockerBase, "base", "", "docker save archive of the base image of an incremental bundle")
+	bundleCmd.AddCommand(bundleToDockerCmd)
+
+	bundleFromDockerCmd.Flags().StringVarP(&fromDockerOutDir, "out-dir", "o", "./out", "Output directory")
+	bundleFromDockerCmd.Flags().StringVarP(&fromDockerPlatform, "target-platform", "t", "", "Platform of the imgcd binary in the bundle (default: that of the first image)")
+	bundleFromDockerCmd.Flags().StringVar(&fromDockerTag, "tag", "", "Reference of an archive's single image (default: its first tag)")
+	bundleFromDockerCmd.Flags().StringVar(&fromDockerLayerList, "since-layer-list", "", "Layers of the base image on the target: imgcd layers or docker inspect output, or one DiffID per line")
+	bundleFromDockerCmd.Flags().StringVar(&fromDockerSince, "since", "", "Base image the layer list belongs to (default: from the docker inspect output)")
+	bundleCmd.AddCommand(bundleFromDockerCmd)
+}
+
+func runBundleToDocker(cmd *cobra.Command, args []string) error {
+	outPath := toDockerOutput
+	var out io.Writer = os.Stdout
+	if toDockerOutput == "-" {
+		// The archive is built in a file, then copied to stdout
+		temp, err := os.CreateTemp("", "imgcd-to-docker-*.tar")
+		if err != nil {
+			return fmt.Errorf("failed to create temp file: %w", err)
+		}
+		temp.Close()
+		defer os.Remove(temp.Name())
+		outPath, out = temp.Name(), os.Stderr
+	}
+
+	ref, err := image.BundleToDockerArchive(args[0], outPath, image.ToDockerOptions{
+		Image:    toDockerImage,
+		Platform: toDockerPlatform,
+		Base:     toDockerBase,
+		Output:   out,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to convert %s: %w", args[0], err)
+	}
+
+	if toDockerOutput == "-" {
+		archive, err := os.Open(outPath)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		if _, err := io.Copy(os.Stdout, archive); err != nil {
+			return fmt.Errorf("failed to write archive to stdout: %w", err)
+		}
+		return nil
+	}
+
+	absPath, _ := filepath.Abs(outPath)
+	if info, err := os.Stat(outPath); err == nil {
+		ui.Success("Wrote %s to %s (%s)", ref, absPath, humanize.Size(info.Size()))
+	} else {
+		ui.Success("Wrote %s to %s", ref, absPath)
+	}
+	fmt.Printf("\nTo load:\n  docker load -i %s\n", filepath.Base(outPath))
+	return nil
+}
+
+func runBundleFromDocker(cmd *cobra.Command, args []string) error {
+	opts := image.FromDockerOptions{
+		Tag:            fromDockerTag,
+		SinceRef:       fromDockerSince,
+		TargetPlatform: fromDockerPlatform,
+	}
+	if fromDockerLayerList != "" {
+		list, err := image.ReadLayerList(fromDockerLayerList)
+		if err != nil {
+			return err
+		}
+		if len(list.Layers) == 0 {
+			return fmt.Errorf("%s lists no layers", fromDockerLayerList)
+		}
+		if opts.SinceRef == "" {
+			opts.SinceRef = list.Image
+		}
+		if opts.SinceRef == "" {
+			return fmt.Errorf("%s does not name the base image; pass it with --since", fromDockerLayerList)
+		}
+		opts.SinceLayers = list.DiffIDs()
+	} else if fromDockerSince != "" {
+		return fmt.Errorf("--since needs --since-layer-list, the layers of that image on the target")
+	}
+
+	result, err := image.BundleFromDockerArchive(args[0], fromDockerOutDir, Version, opts)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s: %w", args[0], err)
+	}
+
+	absPath, _ := filepath.Abs(result.Path)
+	if info, err := os.Stat(result.Path); err == nil {
+		ui.Success("Converted %d image(s) into %s (%s)", len(result.Images), absPath, humanize.Size(info.Size()))
+	} else {
+		ui.Success("Converted %d image(s) into %s", len(result.Images), absPath)
+	}
+	for _, img := range result.Images {
+		fmt.Printf("  %s\n", img)
+	}
+	fmt.Printf("\nTo import on target system:\n  imgcd load --from %s\n", filepath.Base(result.Path))
+	return nil
+}
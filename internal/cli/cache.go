@@ -3,20 +3,36 @@ package cli
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/so2liu/imgcd/internal/cache"
+	"github.com/so2liu/imgcd/internal/format"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
 	"github.com/spf13/cobra"
 )
 
 var (
-	cacheForce    bool
-	cachePruneAge int
+	cacheForce      bool
+	cacheListFormat string
+	cachePruneAge   int
+	cacheUnder      string
+	cacheDryRun     bool
+	gcBundleDirs    []string
+	gcKeepDays      int
+	gcDryRun        bool
+	gcVerbose       bool
 )
 
+// defaultGCKeepDays is how long blobs used by an export count as recent history
+const defaultGCKeepDays = 7
+
 var cacheCmd = &cobra.Command{
 	Use:   "cache",
 	Short: "Manage imgcd layer cache",
@@ -28,6 +44,9 @@ Available commands:
   list   - List all cached layers
   clean  - Remove all cached layers
   prune  - Remove old/unused cached layers
+  pin    - Keep the blobs of an image out of prune
+  unpin  - Remove a pin
+  gc     - Remove blobs no pinned image, retained bundle or recent export uses
   info   - Show cache statistics`,
 }
 
@@ -36,7 +55,14 @@ var cacheListCmd = &cobra.Command{
 	Short: "List all cached layers",
 	Long: `List all layers currently in the cache.
 
-Shows layer ID (short format), size, source image, and last access time.`,
+Shows layer ID (short format), size, source image, and last access time.
+
+--format prints each layer, .Layer, through a Go template with the same
+helpers as imgcd inspect --format, one line per layer and without the total.
+
+Examples:
+  # Cached layers of one image with their full DiffIDs
+  imgcd cache list --format '{{.Layer.ImageRef}}\t{{.Layer.DiffID}}'`,
 	RunE: runCacheList,
 }
 
@@ -55,14 +81,82 @@ Use --force to skip confirmation prompt.`,
 var cachePruneCmd = &cobra.Command{
 	Use:   "prune",
 	Short: "Remove old/unused cached layers",
-	Long: `Remove layers that haven't been accessed in a specified number of days.
+	Long: `Remove layers and blobs that haven't been accessed in a specified number of days.
+
+By default, removes entries not accessed in the last 30 days.
+
+Use --days to specify a different age threshold. --until-under additionally
+evicts the least recently used blobs until the blob cache is below a size,
+which bounds disk usage on busy machines where everything is recent. Given
+alone, --until-under prunes by size only.
 
-By default, removes layers not accessed in the last 30 days.
+Use --dry-run to list what would be removed without deleting anything.
 
-Use --days to specify a different age threshold.`,
+Blobs of pinned images are never removed, nor blobs a running save, copy or
+pull is using, even one of another imgcd process sharing the cache: each
+such job holds a lease on the blobs it reads until it finishes.
+
+Examples:
+  # Keep the blob cache under 10GB
+  imgcd cache prune --until-under 10GB
+
+  # Show what a 60-day prune would remove
+  imgcd cache prune --days 60 --dry-run`,
 	RunE: runCachePrune,
 }
 
+var cachePinCmd = &cobra.Command{
+	Use:   "pin [IMAGE]...",
+	Short: "Keep the blobs of an image out of prune",
+	Long: `Pin images so prune never evicts their cached blobs, neither by age nor by
+--until-under. Pins cover blobs cached for the image later on too, so the base
+images of regular incremental exports stay cached.
+
+Without arguments, lists the pinned images.
+
+Examples:
+  # Never evict the base of the weekly incrementals
+  imgcd cache pin alpine:3.20
+
+  # List pins
+  imgcd cache pin`,
+	RunE: runCachePin,
+}
+
+var cacheUnpinCmd = &cobra.Command{
+	Use:   "unpin <IMAGE>...",
+	Short: "Remove a pin",
+	Long:  `Remove pins set by imgcd cache pin. The blobs stay cached until the next prune.`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runCacheUnpin,
+}
+
+var cacheGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove blobs no pinned image, retained bundle or recent export uses",
+	Long: `Remove cached blobs that nothing references any more.
+
+A blob is referenced by:
+  - a pinned image it belongs to (imgcd cache pin)
+  - a retained bundle containing it: bundles recorded by imgcd save that are
+    still on disk, plus the bundles in any --bundles directory
+  - recent history: an export used it within --keep-days
+  - a running save, copy or pull that uses it (in this or another imgcd
+    process sharing the cache)
+
+Unlike prune, gc keeps old blobs as long as something needs them and drops
+new ones as soon as nothing does. imgcd rm-bundle runs it automatically for
+the blobs of the bundles it deletes.
+
+Examples:
+  # Show each blob's references and what gc would remove
+  imgcd cache gc --bundles ./out --dry-run --verbose
+
+  # Collect garbage, counting only the last day as recent
+  imgcd cache gc --bundles ./out --keep-days 1`,
+	RunE: runCacheGC,
+}
+
 var cacheInfoCmd = &cobra.Command{
 	Use:   "info",
 	Short: "Show cache statistics",
@@ -78,21 +172,39 @@ func init() {
 	cacheCmd.AddCommand(cacheListCmd)
 	cacheCmd.AddCommand(cacheCleanCmd)
 	cacheCmd.AddCommand(cachePruneCmd)
+	cacheCmd.AddCommand(cachePinCmd)
+	cacheCmd.AddCommand(cacheUnpinCmd)
+	cacheCmd.AddCommand(cacheGCCmd)
 	cacheCmd.AddCommand(cacheInfoCmd)
 
 	// Add flags
+	cacheListCmd.Flags().StringVar(&cacheListFormat, "format", "", "Print each layer through a Go template, e.g. '{{.Layer.DiffID}}'")
 	cacheCleanCmd.Flags().BoolVarP(&cacheForce, "force", "f", false, "Skip confirmation prompt")
 	cachePruneCmd.Flags().IntVar(&cachePruneAge, "days", 30, "Remove layers not accessed in this many days")
+	cachePruneCmd.Flags().StringVar(&cacheUnder, "until-under", "", "Evict least recently used blobs until the blob cache is under this size (e.g., 10GB)")
+	cachePruneCmd.Flags().BoolVar(&cacheDryRun, "dry-run", false, "Only list what would be removed")
+	cacheGCCmd.Flags().StringArrayVar(&gcBundleDirs, "bundles", nil, "Directory of retained bundles whose blobs are kept (repeatable)")
+	cacheGCCmd.Flags().IntVar(&gcKeepDays, "keep-days", defaultGCKeepDays, "Keep blobs used by an export within this many days")
+	cacheGCCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "Only list what would be removed")
+	cacheGCCmd.Flags().BoolVarP(&gcVerbose, "verbose", "v", false, "Show the references of every blob")
 }
 
 func runCacheList(cmd *cobra.Command, args []string) error {
+	var tmpl *format.Template
+	if cacheListFormat != "" {
+		var err error
+		if tmpl, err = format.Parse(cacheListFormat); err != nil {
+			return err
+		}
+	}
+
 	lc, err := cache.NewLayerCache(true)
 	if err != nil {
 		return fmt.Errorf("failed to initialize cache: %w", err)
 	}
 
 	layers := lc.List()
-	if len(layers) == 0 {
+	if len(layers) == 0 && tmpl == nil {
 		fmt.Println("Cache is empty")
 		return nil
 	}
@@ -102,15 +214,24 @@ func runCacheList(cmd *cobra.Command, args []string) error {
 		return layers[i].LastAccess.After(layers[j].LastAccess)
 	})
 
+	if tmpl != nil {
+		for _, layer := range layers {
+			if err := tmpl.Execute(os.Stdout, struct{ Layer *cache.LayerMetadata }{layer}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	// Print table
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 	fmt.Fprintln(w, "LAYER ID\tSIZE\tIMAGE\tLAST ACCESSED")
 
 	for _, layer := range layers {
 		shortID := getShortID(layer.DiffID)
-		size := formatSize(layer.Size)
+		size := humanize.Size(layer.Size)
 		imageRef := formatImageRef(layer.ImageRef)
-		lastAccess := formatTime(layer.LastAccess)
+		lastAccess := humanize.Ago(layer.LastAccess)
 
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", shortID, size, imageRef, lastAccess)
 	}
@@ -119,7 +240,7 @@ func runCacheList(cmd *cobra.Command, args []string) error {
 
 	// Print summary
 	stats := lc.GetStats()
-	fmt.Printf("\nTotal: %d layers, %s\n", stats.LayerCount, formatSize(stats.TotalSize))
+	fmt.Printf("\nTotal: %d layers, %s\n", stats.LayerCount, humanize.Size(stats.TotalSize))
 
 	return nil
 }
@@ -138,7 +259,7 @@ func runCacheClean(cmd *cobra.Command, args []string) error {
 
 	// Ask for confirmation unless --force is used
 	if !cacheForce {
-		fmt.Printf("This will remove all %d cached layers (%s).\n", stats.LayerCount, formatSize(stats.TotalSize))
+		fmt.Printf("This will remove all %d cached layers (%s).\n", stats.LayerCount, humanize.Size(stats.TotalSize))
 		fmt.Print("Are you sure? (y/N): ")
 
 		var response string
@@ -156,33 +277,271 @@ func runCacheClean(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to clean cache: %w", err)
 	}
 
-	fmt.Printf("✓ Successfully cleaned cache (freed %s)\n", formatSize(stats.TotalSize))
+	ui.Success("Successfully cleaned cache (freed %s)", humanize.Size(stats.TotalSize))
 
 	return nil
 }
 
 func runCachePrune(cmd *cobra.Command, args []string) error {
+	var opts cache.PruneOptions
+	opts.DryRun = cacheDryRun
+	if cacheUnder != "" {
+		size, err := humanize.ParseSize(cacheUnder)
+		if err != nil {
+			return fmt.Errorf("invalid --until-under: %w", err)
+		}
+		opts.UntilUnder = size
+	}
+	// A size target alone prunes by size only
+	if cacheUnder == "" || cmd.Flags().Changed("days") {
+		opts.MaxAge = time.Duration(cachePruneAge) * 24 * time.Hour
+	}
+
 	lc, err := cache.NewLayerCache(true)
 	if err != nil {
 		return fmt.Errorf("failed to initialize cache: %w", err)
 	}
+	bc, err := cache.NewBlobCache(true)
+	if err != nil {
+		return fmt.Errorf("failed to initialize cache: %w", err)
+	}
 
-	maxAge := time.Duration(cachePruneAge) * 24 * time.Hour
-
-	fmt.Printf("Pruning layers not accessed in the last %d days...\n", cachePruneAge)
+	switch {
+	case opts.MaxAge > 0 && opts.UntilUnder > 0:
+		fmt.Printf("Pruning entries not accessed in the last %d days, then blobs until the cache is under %s...\n", cachePruneAge, humanize.Size(opts.UntilUnder))
+	case opts.UntilUnder > 0:
+		fmt.Printf("Pruning least recently used blobs until the cache is under %s...\n", humanize.Size(opts.UntilUnder))
+	default:
+		fmt.Printf("Pruning entries not accessed in the last %d days...\n", cachePruneAge)
+	}
 
-	count, freedSpace, err := lc.Prune(maxAge)
+	result, err := bc.PruneWithOptions(opts)
 	if err != nil {
 		return fmt.Errorf("failed to prune cache: %w", err)
 	}
+	count, freedSpace := len(result.Removed), result.Freed
+
+	if opts.MaxAge > 0 {
+		layerCount, layerFreed, err := pruneLayers(lc, opts.MaxAge, opts.DryRun)
+		if err != nil {
+			return fmt.Errorf("failed to prune cache: %w", err)
+		}
+		count += layerCount
+		freedSpace += layerFreed
+	}
+
+	if opts.DryRun {
+		for _, blob := range result.Removed {
+			fmt.Printf("  %s  %8s  %-14s  %s\n", getShortID(blob.Digest), humanize.Size(blob.Size), humanize.Ago(blob.LastAccess), formatImageRef(strings.Join(blob.ImageRefs, ", ")))
+		}
+	}
+
+	if result.Pinned > 0 {
+		fmt.Printf("Kept %s of pinned blobs\n", humanize.Size(result.Pinned))
+		if opts.UntilUnder > 0 && result.Remaining > opts.UntilUnder {
+			ui.Fwarning(os.Stdout, "pinned blobs alone exceed the %s target", humanize.Size(opts.UntilUnder))
+		}
+	}
+	if result.Leased > 0 {
+		fmt.Printf("Kept %s of blobs in use by running saves, copies and pulls\n", humanize.Size(result.Leased))
+	}
 
 	if count == 0 {
 		fmt.Println("No layers to prune")
 		return nil
 	}
 
-	fmt.Printf("✓ Successfully pruned %d layers (freed %s)\n", count, formatSize(freedSpace))
+	if opts.DryRun {
+		fmt.Printf("Would prune %d entries (free %s); blob cache would be %s\n", count, humanize.Size(freedSpace), humanize.Size(result.Remaining))
+		return nil
+	}
+	ui.Success("Successfully pruned %d entries (freed %s); blob cache is %s", count, humanize.Size(freedSpace), humanize.Size(result.Remaining))
+
+	return nil
+}
+
+// pruneLayers prunes the legacy layer cache by age
+func pruneLayers(lc *cache.LayerCache, maxAge time.Duration, dryRun bool) (int, int64, error) {
+	if !dryRun {
+		return lc.Prune(maxAge)
+	}
+
+	var count int
+	var size int64
+	cutoff := time.Now().Add(-maxAge)
+	for _, layer := range lc.List() {
+		if layer.LastAccess.Before(cutoff) {
+			fmt.Printf("  %s  %8s  %-14s  %s (layer)\n", getShortID(layer.DiffID), humanize.Size(layer.Size), humanize.Ago(layer.LastAccess), formatImageRef(layer.ImageRef))
+			count++
+			size += layer.Size
+		}
+	}
+	return count, size, nil
+}
+
+func runCachePin(cmd *cobra.Command, args []string) error {
+	bc, err := cache.NewBlobCache(true)
+	if err != nil {
+		return fmt.Errorf("failed to initialize cache: %w", err)
+	}
+
+	if len(args) == 0 {
+		pinned := bc.Pinned()
+		if len(pinned) == 0 {
+			fmt.Println("No pinned images")
+			return nil
+		}
+		for _, ref := range pinned {
+			fmt.Println(ref)
+		}
+		return nil
+	}
+
+	for _, ref := range args {
+		count, size, err := bc.Pin(ref)
+		if err != nil {
+			return fmt.Errorf("failed to pin %s: %w", ref, err)
+		}
+		if count == 0 {
+			ui.Success("Pinned %s (no blobs cached yet; they are kept once an export caches them)", ref)
+			continue
+		}
+		ui.Success("Pinned %s (%d blobs, %s)", ref, count, humanize.Size(size))
+	}
+	return nil
+}
+
+func runCacheUnpin(cmd *cobra.Command, args []string) error {
+	bc, err := cache.NewBlobCache(true)
+	if err != nil {
+		return fmt.Errorf("failed to initialize cache: %w", err)
+	}
+
+	for _, ref := range args {
+		removed, err := bc.Unpin(ref)
+		if err != nil {
+			return fmt.Errorf("failed to unpin %s: %w", ref, err)
+		}
+		if !removed {
+			fmt.Printf("%s was not pinned\n", ref)
+			continue
+		}
+		ui.Success("Unpinned %s", ref)
+	}
+	return nil
+}
+
+func runCacheGC(cmd *cobra.Command, args []string) error {
+	bc, err := cache.NewBlobCache(true)
+	if err != nil {
+		return fmt.Errorf("failed to initialize cache: %w", err)
+	}
+
+	paths, err := findBundles(gcBundleDirs)
+	if err != nil {
+		return err
+	}
+	bundles, err := retainedBundles(paths)
+	if err != nil {
+		return err
+	}
+	roots := gcRoots(bundles, gcKeepDays)
+
+	if gcVerbose {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "BLOB\tSIZE\tREFS\tREFERENCED BY")
+		for _, refs := range bc.References(roots) {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", getShortID(refs.Blob.Digest), humanize.Size(refs.Blob.Size), refs.Count(), describeRefs(refs))
+		}
+		w.Flush()
+		fmt.Println()
+	}
+
+	result, err := bc.GC(roots, gcDryRun)
+	if err != nil {
+		return fmt.Errorf("failed to collect cache garbage: %w", err)
+	}
+	return reportGC(result, gcDryRun)
+}
+
+// retainedBundles reads the bundles recorded by save plus those at paths
+func retainedBundles(paths []string) ([]*applyItem, error) {
+	index, err := cache.NewBundleIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle index: %w", err)
+	}
+	for _, record := range index.Retained() {
+		paths = append(paths, record.Path)
+	}
+
+	seen := make(map[string]bool)
+	var items []*applyItem
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil || seen[abs] {
+			continue
+		}
+		seen[abs] = true
+
+		summary, err := image.ReadBundleSummary(abs)
+		if err != nil {
+			ui.Fwarning(os.Stdout, "skipping %s: %v", path, err)
+			continue
+		}
+		items = append(items, &applyItem{path: abs, summary: summary})
+	}
+	return items, nil
+}
 
+// gcRoots turns retained bundles and the recent-history window into GC roots
+func gcRoots(bundles []*applyItem, keepDays int) cache.GCRoots {
+	roots := cache.GCRoots{Bundles: make(map[string][]string)}
+	for _, item := range bundles {
+		for _, digest := range item.summary.Layers {
+			roots.Bundles[digest] = append(roots.Bundles[digest], item.path)
+		}
+	}
+	if keepDays > 0 {
+		roots.KeepSince = time.Now().Add(-time.Duration(keepDays) * 24 * time.Hour)
+	}
+	return roots
+}
+
+// describeRefs summarizes what keeps a blob alive
+func describeRefs(refs *cache.BlobRefs) string {
+	var parts []string
+	for _, ref := range refs.Pinned {
+		parts = append(parts, "pin "+ref)
+	}
+	for _, path := range refs.Bundles {
+		parts = append(parts, "bundle "+filepath.Base(path))
+	}
+	if refs.Recent {
+		parts = append(parts, "used "+humanize.Ago(refs.Blob.LastAccess))
+	}
+	if refs.Leased {
+		parts = append(parts, "in use by a running job")
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func reportGC(result *cache.PruneResult, dryRun bool) error {
+	if len(result.Removed) == 0 {
+		fmt.Println("No unreferenced blobs")
+		return nil
+	}
+
+	if dryRun {
+		for _, blob := range result.Removed {
+			fmt.Printf("  %s  %8s  %-14s  %s\n", getShortID(blob.Digest), humanize.Size(blob.Size), humanize.Ago(blob.LastAccess), formatImageRef(strings.Join(blob.ImageRefs, ", ")))
+		}
+		fmt.Printf("Would remove %d unreferenced blobs (free %s); blob cache would be %s\n", len(result.Removed), humanize.Size(result.Freed), humanize.Size(result.Remaining))
+		return nil
+	}
+	ui.Success("Removed %d unreferenced blobs (freed %s); blob cache is %s", len(result.Removed), humanize.Size(result.Freed), humanize.Size(result.Remaining))
 	return nil
 }
 
@@ -195,8 +554,12 @@ func runCacheInfo(cmd *cobra.Command, args []string) error {
 	stats := lc.GetStats()
 
 	fmt.Println("Cache Statistics:")
-	fmt.Printf("  Location:     ~/.imgcd/cache/\n")
-	fmt.Printf("  Total size:   %s\n", formatSize(stats.TotalSize))
+	stateDir, err := state.Dir()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  Location:     %s\n", filepath.Join(stateDir, "cache"))
+	fmt.Printf("  Total size:   %s\n", humanize.Size(stats.TotalSize))
 	fmt.Printf("  Layer count:  %d\n", stats.LayerCount)
 
 	// Show cache hit/miss only if there's activity
@@ -210,7 +573,7 @@ func runCacheInfo(cmd *cobra.Command, args []string) error {
 	}
 
 	if !stats.LastPruneAt.IsZero() {
-		fmt.Printf("\nLast prune:   %s\n", formatTime(stats.LastPruneAt))
+		fmt.Printf("\nLast prune:   %s\n", humanize.Ago(stats.LastPruneAt))
 	}
 
 	return nil
@@ -226,25 +589,6 @@ func getShortID(diffID string) string {
 	return hash
 }
 
-func formatSize(bytes int64) string {
-	const (
-		KB = 1024
-		MB = 1024 * KB
-		GB = 1024 * MB
-	)
-
-	switch {
-	case bytes < KB:
-		return fmt.Sprintf("%dB", bytes)
-	case bytes < MB:
-		return fmt.Sprintf("%.1fKB", float64(bytes)/KB)
-	case bytes < GB:
-		return fmt.Sprintf("%.1fMB", float64(bytes)/MB)
-	default:
-		return fmt.Sprintf("%.2fGB", float64(bytes)/GB)
-	}
-}
-
 func formatImageRef(ref string) string {
 	// Truncate long image references
 	if len(ref) > 40 {
@@ -252,33 +596,3 @@ func formatImageRef(ref string) string {
 	}
 	return ref
 }
-
-func formatTime(t time.Time) string {
-	now := time.Now()
-	diff := now.Sub(t)
-
-	switch {
-	case diff < time.Minute:
-		return "just now"
-	case diff < time.Hour:
-		mins := int(diff.Minutes())
-		if mins == 1 {
-			return "1 minute ago"
-		}
-		return fmt.Sprintf("%d minutes ago", mins)
-	case diff < 24*time.Hour:
-		hours := int(diff.Hours())
-		if hours == 1 {
-			return "1 hour ago"
-		}
-		return fmt.Sprintf("%d hours ago", hours)
-	case diff < 7*24*time.Hour:
-		days := int(diff.Hours() / 24)
-		if days == 1 {
-			return "1 day ago"
-		}
-		return fmt.Sprintf("%d days ago", days)
-	default:
-		return t.Format("2006-01-02")
-	}
-}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/ci"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// reportSavedBundle tells the CI system about a bundle save created or
+// reused: a notice with its size and savings, a job summary table and the
+// step outputs bundle, checksum, size, stored-size, image, base and changed
+func reportSavedBundle(path string) {
+	if !ci.Enabled() {
+		return
+	}
+	info, err := image.InspectBundle(path)
+	if err != nil {
+		ui.Warning("cannot read %s for the CI summary: %v", filepath.Base(path), err)
+		return
+	}
+
+	var total, fromBase int64
+	stored, reused := 0, 0
+	for _, layer := range info.Layers {
+		total += layer.Size
+		if layer.Stored {
+			stored++
+		} else {
+			reused++
+			fromBase += layer.Size
+		}
+	}
+	base := info.BaseRef
+	if base == "" {
+		base = "none (full export)"
+	}
+	savings := ""
+	if fromBase > 0 && total > 0 {
+		savings = fmt.Sprintf(", %s (%d%%) left out as base layers", humanize.Size(fromBase), fromBase*100/total)
+	}
+	ci.Annotate(ci.Notice, "imgcd save", "%s: %s%s", filepath.Base(path), humanize.Size(info.Size), savings)
+
+	var md strings.Builder
+	fmt.Fprintf(&md, "### imgcd save: %s\n\n", info.ImageRef)
+	fmt.Fprintf(&md, "| | |\n|---|---|\n")
+	fmt.Fprintf(&md, "| Bundle | `%s` (%s) |\n", filepath.Base(path), humanize.Size(info.Size))
+	fmt.Fprintf(&md, "| Platform | %s |\n", info.Platform)
+	fmt.Fprintf(&md, "| Base | %s |\n", base)
+	fmt.Fprintf(&md, "| Layers | %d, %d stored (%s), %d from base |\n", len(info.Layers), stored, humanize.Size(info.StoredSize), reused)
+	if fromBase > 0 {
+		fmt.Fprintf(&md, "| Saved | %s of %s (%d%%) |\n", humanize.Size(fromBase), humanize.Size(total), fromBase*100/total)
+	}
+	for _, img := range info.Images {
+		fmt.Fprintf(&md, "| Image | %s (%s), %d layers, %s |\n", img.ImageRef, img.Platform, img.Layers, humanize.Size(img.Size))
+	}
+	if err := ci.Summary(md.String()); err != nil {
+		ui.Warning("cannot write the CI summary: %v", err)
+	}
+
+	err = ci.Output(
+		"bundle", path,
+		"checksum", checksum.SidecarPath(path),
+		"size", strconv.FormatInt(info.Size, 10),
+		"stored-size", strconv.FormatInt(info.StoredSize, 10),
+		"image", info.ImageRef,
+		"base", info.BaseRef,
+		"changed", "true",
+	)
+	if err != nil {
+		ui.Warning("cannot write the CI outputs: %v", err)
+	}
+}
+
+// reportStreamedBundle tells the CI system about a bundle save -o - wrote to
+// stdout: a notice and the step outputs bundle (its name), size, sha256 and
+// changed
+func reportStreamedBundle(name string, size int64, sum string) {
+	if !ci.Enabled() {
+		return
+	}
+	ci.Annotate(ci.Notice, "imgcd save", "streamed %s: %s", name, humanize.Size(size))
+	err := ci.Output("bundle", name, "size", strconv.FormatInt(size, 10), "sha256", sum, "changed", "true")
+	if err != nil {
+		ui.Warning("cannot write the CI outputs: %v", err)
+	}
+}
+
+// reportUnchanged tells the CI system that save --if-changed created no
+// bundle
+func reportUnchanged(ref, since string) {
+	if !ci.Enabled() {
+		return
+	}
+	ci.Annotate(ci.Notice, "imgcd save", "%s has no changes since %s, no bundle created", ref, since)
+	if err := ci.Summary(fmt.Sprintf("### imgcd save: %s\n\nNo changes since %s, no bundle created.\n", ref, since)); err != nil {
+		ui.Warning("cannot write the CI summary: %v", err)
+	}
+	if err := ci.Output("image", ref, "base", since, "changed", "false"); err != nil {
+		ui.Warning("cannot write the CI outputs: %v", err)
+	}
+}
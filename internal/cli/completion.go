@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/spf13/cobra"
+)
+
+// validTargetPlatforms are the platforms --target-platform accepts
+var validTargetPlatforms = []string{"linux/amd64", "linux/arm64", "darwin/amd64", "darwin/arm64"}
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Print the shell completion script",
+	Long: `Print a script that makes the shell complete imgcd commands, flags and
+flag values such as --target-platform and --output on TAB.
+
+Examples:
+  # Bash, for the current shell (needs the bash-completion package)
+  source <(imgcd completion bash)
+
+  # Bash, for every new shell
+  imgcd completion bash | sudo tee /etc/bash_completion.d/imgcd
+
+  # Zsh (compinit must be enabled)
+  imgcd completion zsh > "${fpath[1]}/_imgcd"
+
+  # Fish
+  imgcd completion fish > ~/.config/fish/completions/imgcd.fish
+
+  # PowerShell
+  imgcd completion powershell | Out-String | Invoke-Expression`,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	DisableFlagsInUseLine: true,
+	RunE:                  runCompletion,
+}
+
+func runCompletion(cmd *cobra.Command, args []string) error {
+	switch args[0] {
+	case "bash":
+		return rootCmd.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		return rootCmd.GenZshCompletion(os.Stdout)
+	case "fish":
+		return rootCmd.GenFishCompletion(os.Stdout, true)
+	case "powershell":
+		return rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+	}
+	return fmt.Errorf("unsupported shell: %s", args[0])
+}
+
+// completeTargetPlatforms completes --target-platform, also after the
+// commas of a list such as "linux/amd64,linux/"
+func completeTargetPlatforms(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	prefix := ""
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		prefix = toComplete[:i+1]
+	}
+	completions := make([]string, len(validTargetPlatforms))
+	for i, platform := range validTargetPlatforms {
+		completions[i] = prefix + platform
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeChecksumAlgorithms completes save --checksum
+func completeChecksumAlgorithms(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return bundle.Algorithms, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeInteropFormats completes the --to of convert
+func completeInteropFormats(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	formats := make([]string, len(image.InteropFormats))
+	for i, format := range image.InteropFormats {
+		formats[i] = string(format)
+	}
+	return formats, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeOutputFormats completes the --output of commands that print text
+// or JSON
+func completeOutputFormats(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/config"
+	"github.com/so2liu/imgcd/internal/remote"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// configBinding makes a config key the default of a command's flag. invert
+// is for bool keys that are the negation of the flag (cache.enabled and
+// --no-cache).
+type configBinding struct {
+	key     string
+	command string
+	flag    string
+	invert  bool
+}
+
+var configBindings = []configBinding{
+	{key: "out-dir", command: "imgcd save", flag: "out-dir"},
+	{key: "target-platform", command: "imgcd save", flag: "target-platform"},
+	{key: "target-platform", command: "imgcd diff", flag: "target-platform"},
+	{key: "target-platform", command: "imgcd pull", flag: "target-platform"},
+	{key: "target-platform", command: "imgcd copy", flag: "target-platform"},
+	{key: "concurrency", command: "imgcd apply", flag: "parallel"},
+	{key: "concurrency", command: "imgcd verify", flag: "parallel"},
+	{key: "cache.enabled", command: "imgcd save", flag: "no-cache", invert: true},
+	{key: "cache.enabled", command: "imgcd copy", flag: "no-cache", invert: true},
+	{key: "cache.prune-days", command: "imgcd cache prune", flag: "days"},
+	{key: "cache.gc-keep-days", command: "imgcd cache gc", flag: "keep-days"},
+	{key: "limit-rate", command: "imgcd save", flag: "limit-rate"},
+	{key: "limit-rate", command: "imgcd pull", flag: "limit-rate"},
+	{key: "peers.discover", command: "imgcd save", flag: "discover-peers"},
+	{key: "peers.discover", command: "imgcd pull", flag: "discover-peers"},
+}
+
+// configEnv are config keys read through an environment variable, which
+// takes precedence when set
+var configEnv = map[string]string{
+	"registry.release-mirror": "IMGCD_RELEASE_MIRROR",
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Get and set persistent defaults in the config file",
+	Long: `Keep defaults for flags in ~/.imgcd/config.yaml (in the --state-dir when
+one is given), so they need not be repeated on every command. A flag given on
+the command line always wins over the config file, and an environment
+variable over its config key. Under --no-state the file is not read.
+
+Keys:
+` + configKeyList() + `
+Nested keys are written with dots: cache.enabled is enabled under cache: in
+the file. The file can also be edited by hand; config set rewrites it without
+comments.
+
+proxies.<host> keys route the requests to one registry host through a proxy,
+where HTTPS_PROXY would apply to every host alike: the value is an http,
+https or socks5 proxy URL, or direct to bypass HTTPS_PROXY for that host.
+The host is the one requests go to (registry-1.docker.io for Docker Hub, with
+its port when it has one); *.example.com covers the subdomains of
+example.com. Redirects, e.g. to a registry's blob storage, keep the proxy of
+the registry that redirected. Hosts without a key use HTTPS_PROXY, HTTP_PROXY
+and NO_PROXY as before.
+
+Examples:
+  # Save into /srv/bundles for arm64 unless told otherwise
+  imgcd config set out-dir /srv/bundles
+  imgcd config set target-platform linux/arm64
+
+  # Show every value that is set, or one of them
+  imgcd config get
+  imgcd config get out-dir
+
+  # Go back to the built-in default
+  imgcd config unset out-dir
+
+  # Reach one registry through a proxy and another one without any
+  imgcd config set proxies.registry.corp.example:5000 http://proxy.corp.example:3128
+  imgcd config set 'proxies.*.docker.io' http://proxy.corp.example:3128
+  imgcd config set proxies.harbor.local direct`,
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get [KEY]",
+	Short: "Print the value of a key, or every value that is set",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runConfigGet,
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set KEY VALUE",
+	Short: "Set the value of a key",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runConfigSet,
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset KEY",
+	Short: "Remove a key so the built-in default applies",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runConfigUnset,
+}
+
+func init() {
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+
+	for _, cmd := range []*cobra.Command{configGetCmd, configSetCmd, configUnsetCmd} {
+		cmd.ValidArgsFunction = completeConfigKeys
+	}
+}
+
+// configKeyList lists the keys for the help text
+func configKeyList() string {
+	var b strings.Builder
+	for _, key := range config.Keys {
+		fmt.Fprintf(&b, "  %-24s %s (%s)\n", key.Name, key.Description, key.Kind)
+	}
+	return b.String()
+}
+
+func runConfigGet(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		value, ok := cfg.Get(args[0])
+		if _, known := config.LookupKey(args[0]); !known && !ok {
+			return fmt.Errorf("unknown config key %s", args[0])
+		}
+		if !ok {
+			return fmt.Errorf("%s is not set", args[0])
+		}
+		fmt.Println(value)
+		return nil
+	}
+
+	for _, name := range cfg.Names() {
+		value, _ := cfg.Get(name)
+		fmt.Printf("%s=%s\n", name, value)
+	}
+	return nil
+}
+
+func runConfigSet(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Set(args[0], args[1]); err != nil {
+		return err
+	}
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+	ui.Success("Set %s to %s", args[0], args[1])
+	return nil
+}
+
+func runConfigUnset(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Unset(args[0]); err != nil {
+		return err
+	}
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+	ui.Success("Unset %s", args[0])
+	return nil
+}
+
+// applyConfig sets the flags of cmd that were not given on the command line
+// to the values of the config file
+func applyConfig(cmd *cobra.Command) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range cfg.Unknown() {
+		ui.Failure("Ignoring unknown key %s in %s", name, cfg.File())
+	}
+
+	for _, binding := range configBindings {
+		if binding.command != cmd.CommandPath() {
+			continue
+		}
+		value, ok := cfg.Get(binding.key)
+		flag := cmd.Flags().Lookup(binding.flag)
+		if !ok || flag == nil || flag.Changed {
+			continue
+		}
+		if binding.invert {
+			enabled, _ := strconv.ParseBool(value)
+			value = strconv.FormatBool(!enabled)
+		}
+		// Setting the value directly leaves Changed false, so commands
+		// still tell it from a flag given on the command line
+		if err := flag.Value.Set(value); err != nil {
+			return fmt.Errorf("invalid %s in the config file for --%s: %w", binding.key, binding.flag, err)
+		}
+	}
+
+	for key, env := range configEnv {
+		if value, ok := cfg.Get(key); ok && os.Getenv(env) == "" {
+			os.Setenv(env, value)
+		}
+	}
+	return nil
+}
+
+// configureProxies sends registry requests through the proxies set under
+// proxies: in the config file. The config commands skip them, so a broken
+// entry can still be fixed with config set or unset.
+func configureProxies(cmd *cobra.Command) error {
+	if cmd.HasParent() && cmd.Parent() == configCmd {
+		return nil
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if err := remote.ProxyRegistries(cfg.Section("proxies")); err != nil {
+		return fmt.Errorf("%s: %w", cfg.File(), err)
+	}
+	return nil
+}
+
+// completeConfigKeys completes the KEY argument of the config commands
+func completeConfigKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := make([]string, len(config.Keys))
+	for i, key := range config.Keys {
+		names[i] = key.Name + "\t" + key.Description
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
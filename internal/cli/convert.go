@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	convertTo       string
+	convertOutDir   string
+	convertPlatform string
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <BUNDLE|PACKAGE>",
+	Short: "Convert between bundles and Zarf packages, Hauler stores or skopeo directories",
+	Long: `Translate images between imgcd bundles and the packages of other air-gap
+tools, so nothing has to be downloaded again when sites mix them.
+
+With --to zarf or --to hauler, the images of a bundle are written as a Zarf
+package (zarf-package-<name>-<arch>-<tag>.tar.zst, deployable with zarf
+package deploy) or a Hauler store archive (<bundle>.haul.tar.zst, for hauler
+store load). The bundle must hold every layer: incremental bundles, OCI
+artifacts and legacy bundles cannot be converted.
+
+With --to dir, every image is written to a directory of its own in the
+layout of skopeo's dir: transport, for skopeo copy dir:PATH docker://... or
+pipelines built on it. Going the other way needs no convert: save reads
+dir:PATH:NAME images directly, also as the --since base.
+
+Without --to, the input is a Zarf package or a Hauler store, as an archive
+(.tar.zst, .tar or .tar.gz) or a directory, and its container images become
+one bundle. Helm charts, files, signatures and Zarf components are left out
+and listed. Blobs are checked against their digest while they are read.
+
+Examples:
+  # Hand a bundle to a site running Zarf
+  imgcd convert myapp-2.0__since-none.tar --to zarf
+
+  # Store archive for Hauler
+  imgcd convert myapp-2.0__since-none.tar --to hauler -o /media/usb
+
+  # Directories for skopeo
+  imgcd convert myapp-2.0__since-none.tar --to dir -o ./skopeo
+
+  # Turn a Zarf package into a bundle, then load it without Zarf
+  imgcd convert zarf-package-podinfo-amd64-6.4.0.tar.zst
+  imgcd load --from out/ghcr.io_stefanprodan_podinfo-6.4.0__since-none.tar
+
+  # A Hauler store directory
+  imgcd convert ./store -o ./bundles`,
+	Args: cobra.ExactArgs(1),
+	RunE: runConvert,
+}
+
+func init() {
+	convertCmd.Flags().StringVar(&convertTo, "to", "", "Convert a bundle to this format: zarf, hauler or dir (default: convert a package to a bundle)")
+	convertCmd.RegisterFlagCompletionFunc("to", completeInteropFormats)
+	convertCmd.Flags().StringVarP(&convertOutDir, "out-dir", "o", "./out", "Output directory")
+	convertCmd.Flags().StringVarP(&convertPlatform, "target-platform", "t", "", "Platform of the imgcd binary in a bundle converted from a package (default: that of its first image)")
+}
+
+func runConvert(cmd *cobra.Command, args []string) error {
+	if convertTo != "" && convertPlatform != "" {
+		return fmt.Errorf("--target-platform only applies to bundles converted from a package")
+	}
+	if err := os.MkdirAll(convertOutDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	var result *image.ConvertResult
+	var err error
+	if convertTo != "" {
+		result, err = image.ConvertBundle(args[0], image.InteropFormat(convertTo), convertOutDir, Version)
+	} else {
+		result, err = image.ImportPackage(args[0], convertOutDir, convertPlatform, Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to convert %s: %w", args[0], err)
+	}
+
+	for _, skipped := range result.Skipped {
+		fmt.Printf("Skipped %s\n", skipped)
+	}
+	absPath, _ := filepath.Abs(result.Path)
+	if info, err := os.Stat(result.Path); err == nil && info.Mode().IsRegular() {
+		ui.Success("Converted %d image(s) into %s (%s)", len(result.Images), absPath, humanize.Size(info.Size()))
+	} else {
+		ui.Success("Converted %d image(s) into %s", len(result.Images), absPath)
+	}
+	for _, img := range result.Images {
+		fmt.Printf("  %s\n", img)
+	}
+
+	switch image.InteropFormat(convertTo) {
+	case image.FormatZarf:
+		fmt.Printf("\nTo deploy:\n  zarf package deploy %s\n", filepath.Base(result.Path))
+	case image.FormatHauler:
+		fmt.Printf("\nTo load:\n  hauler store load --filename %s\n", filepath.Base(result.Path))
+	case image.FormatSkopeoDir:
+		fmt.Printf("\nTo push:\n  skopeo copy dir:<DIR> docker://<REGISTRY>/<IMAGE>:<TAG>\n")
+	default:
+		fmt.Printf("\nTo import on target system:\n  imgcd load --from %s\n", filepath.Base(result.Path))
+	}
+	return nil
+}
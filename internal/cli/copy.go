@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	copyPlatform     string
+	copyAllPlatforms bool
+	copyNoCache      bool
+)
+
+var copyCmd = &cobra.Command{
+	Use:   "copy <SOURCE> <DESTINATION>",
+	Short: "Copy an image from one registry to another",
+	Long: `Copy an image straight from one registry to another, without a container
+runtime and without writing a bundle. Blobs the destination already has are
+skipped; the others go through the blob cache (~/.imgcd/cache), so an
+interrupted copy resumes without downloading them again and later saves of
+the image read them from disk.
+
+Manifests are copied unchanged, so the image keeps its digest. When the
+destination names no tag, the source's tag is used.
+
+To copy bundle files to removable media, use imgcd cp instead.
+
+Examples:
+  # Mirror a release into the internal registry
+  imgcd copy docker.io/ns/app:1.0 registry.local/ns/app
+
+  # Copy the arm64 variant under another tag
+  imgcd copy ns/app:1.0 registry.local/ns/app:1.0-arm64 -t linux/arm64
+
+  # Copy the manifest list with every platform
+  imgcd copy ns/app:1.0 registry.local/ns/app --all-platforms`,
+	Args: cobra.ExactArgs(2),
+	RunE: runCopy,
+}
+
+func init() {
+	copyCmd.Flags().StringVarP(&copyPlatform, "target-platform", "t", "linux/amd64", "Platform to copy out of a manifest list")
+	copyCmd.RegisterFlagCompletionFunc("target-platform", completeTargetPlatforms)
+	copyCmd.Flags().BoolVar(&copyAllPlatforms, "all-platforms", false, "Copy the whole manifest list instead of one platform")
+	copyCmd.Flags().BoolVar(&copyNoCache, "no-cache", false, "Stream blobs from the source without storing them in the cache")
+	copyCmd.MarkFlagsMutuallyExclusive("target-platform", "all-platforms")
+}
+
+func runCopy(cmd *cobra.Command, args []string) error {
+	exporter, err := image.NewRemoteExporter(Version, !copyNoCache)
+	if err != nil {
+		return err
+	}
+
+	result, err := exporter.Copy(cmd.Context(), args[0], args[1], image.CopyOptions{
+		TargetPlatform: image.ImagePlatform(copyPlatform),
+		AllPlatforms:   copyAllPlatforms,
+	})
+	if err != nil {
+		return err
+	}
+	ui.Success("Copied %s to %s: %d blob(s) transferred (%s), %d from the cache",
+		args[0], result.Ref, result.Blobs, humanize.Size(result.Bytes), result.FromCache)
+	return nil
+}
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/destination"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <BUNDLE>... <DIR|iso:PATH.iso>",
+	Short: "Copy bundles to removable media and verify the copies",
+	Long: `Copy bundles with their checksum files to a directory, typically a mounted
+USB drive, and verify every copy.
+
+Each bundle is written to a .partial file, flushed to the device with fsync
+and renamed. The copy is then read back from the device and compared with
+the bundle's .sha256 file (or with the source, for bundles without one).
+A source that does not match its own .sha256 is reported and never
+replaces an existing copy.
+
+With iso:PATH.iso instead of a directory, the bundles and their checksum
+files are packed into one ISO image for optical media, with a MANIFEST.txt,
+SHA256SUMS and a verify.sh script, as save --to iso: writes for a single
+bundle (needs xorriso, genisoimage, mkisofs or hdiutil). Every bundle is
+checked against its .sha256 before it is packed; burn and read back the
+disc with verify.sh.
+
+Examples:
+  # Copy a bundle to a USB drive
+  imgcd cp out/myapp-2.0__since-1.9.tar /media/usb
+
+  # Copy several bundles at once
+  imgcd cp out/*.tar /media/usb
+
+  # Pack a release and its increments into one ready-to-burn ISO
+  imgcd cp out/myapp-2.0__since-none.tar out/myapp-2.1__since-2.0.tar iso:./delivery.iso`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: runCp,
+}
+
+func runCp(cmd *cobra.Command, args []string) error {
+	bundles, dir := args[:len(args)-1], args[len(args)-1]
+	if strings.HasPrefix(dir, "iso:") {
+		return packISO(cmd, bundles, dir)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to access destination: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("destination %s is not a directory", dir)
+	}
+
+	for _, bundlePath := range bundles {
+		if err := copyBundle(bundlePath, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyBundle copies one bundle and its sidecars into dir and verifies the copy
+func copyBundle(bundlePath, dir string) error {
+	name := filepath.Base(bundlePath)
+	target := filepath.Join(dir, name)
+
+	expected, err := checksum.Recorded(bundlePath)
+	if err != nil && !errors.Is(err, checksum.ErrNoSidecar) {
+		return err
+	}
+
+	fmt.Printf("Copying %s...\n", name)
+	sum, err := destination.CopyFile(bundlePath, target, expected)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", name, err)
+	}
+	if expected == "" {
+		ui.Fwarning(os.Stdout, "%s has no checksum file, verifying against the source only", name)
+		expected = sum
+	}
+
+	for _, sidecar := range []string{checksum.SidecarPath(bundlePath), checksum.SignaturePath(bundlePath)} {
+		if _, err := os.Stat(sidecar); err != nil {
+			continue
+		}
+		if _, err := destination.CopyFile(sidecar, filepath.Join(dir, filepath.Base(sidecar)), ""); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", filepath.Base(sidecar), err)
+		}
+	}
+
+	fmt.Printf("Verifying %s...\n", target)
+	actual, err := destination.ReadBack(target)
+	if err != nil {
+		return fmt.Errorf("failed to read back %s: %w", target, err)
+	}
+	if actual != expected {
+		return fmt.Errorf("copy %s is corrupt (expected %s, got %s); the media may be faulty", target, expected, actual)
+	}
+
+	ui.Success("Copied and verified: %s", target)
+	return nil
+}
+
+// packISO packs the bundles and their sidecars into the ISO image spec
+// names, after checking each bundle against its .sha256
+func packISO(cmd *cobra.Command, bundles []string, spec string) error {
+	dest, err := destination.Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	names := make(map[string]string)
+	for _, bundlePath := range bundles {
+		name := filepath.Base(bundlePath)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("%s and %s would both be stored as %s", other, bundlePath, name)
+		}
+		names[name] = bundlePath
+
+		expected, err := checksum.Recorded(bundlePath)
+		switch {
+		case errors.Is(err, checksum.ErrNoSidecar):
+			ui.Fwarning(os.Stdout, "%s has no checksum file, packing it unverified", name)
+		case err != nil:
+			return err
+		default:
+			fmt.Printf("Checking %s...\n", name)
+			actual, err := checksum.File(bundlePath)
+			if err != nil {
+				return fmt.Errorf("failed to hash %s: %w", name, err)
+			}
+			if actual != expected {
+				return fmt.Errorf("%s does not match its checksum file (expected %s, got %s)", name, expected, actual)
+			}
+		}
+
+		files = append(files, bundlePath)
+		for _, sidecar := range []string{checksum.SidecarPath(bundlePath), checksum.SignaturePath(bundlePath)} {
+			if _, err := os.Stat(sidecar); err == nil {
+				files = append(files, sidecar)
+			}
+		}
+	}
+
+	return destination.Deliver(cmd.Context(), files, []destination.Destination{dest})
+}
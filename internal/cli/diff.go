@@ -5,7 +5,10 @@ import (
 	"os"
 	"strings"
 
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/so2liu/imgcd/internal/diff"
+	"github.com/so2liu/imgcd/internal/format"
+	"github.com/so2liu/imgcd/internal/image"
 	"github.com/so2liu/imgcd/internal/prompt"
 	"github.com/so2liu/imgcd/internal/remote"
 	"github.com/spf13/cobra"
@@ -16,6 +19,8 @@ var (
 	diffTargetPlatform string
 	diffVerbose        bool
 	diffOutput         string
+	diffFormat         string
+	diffSnapshot       string
 )
 
 var diffCmd = &cobra.Command{
@@ -31,6 +36,14 @@ The --since flag is required and supports two formats:
   • Full reference: alpine:3.19, myrepo/app:1.0.0
   • Short form (tag only): 3.19, 1.0.0 (uses same repository as main image)
 
+--format prints the comparison, .Diff, through a Go template with the same
+helpers as imgcd inspect --format, e.g. {{.Diff.NewLayersSize}} or
+{{len .Diff.NewLayers}}.
+
+--snapshot compares the images from a file written by imgcd metadata export
+instead of the registry, so planning works inside an air gap; references
+the snapshot does not cover fail.
+
 Examples:
   # Compare two alpine versions
   imgcd diff alpine:3.20 --since 3.19
@@ -41,8 +54,16 @@ Examples:
   # JSON output for scripting
   imgcd diff alpine:3.20 --since 3.19 --output json
 
+  # Only the bytes an incremental bundle would store
+  imgcd diff alpine:3.20 --since 3.19 --format '{{.Diff.NewLayersSize}}'
+
+  # Offline, from a snapshot exported before
+  imgcd diff myapp:2.0 --since 1.9 --snapshot myapp.snapshot.json
+
   # Specify target platform
   imgcd diff myapp:2.0 --since 1.9 --target-platform linux/arm64
+
+  # darwin targets compare the linux images their runtime runs (here linux/arm64)
   imgcd diff myapp:2.0 --since 1.9 -t darwin/arm64`,
 	Args: cobra.ExactArgs(1),
 	RunE: runDiff,
@@ -51,9 +72,14 @@ Examples:
 func init() {
 	diffCmd.Flags().StringVar(&diffSinceRef, "since", "", "Base image reference or tag (required)")
 	diffCmd.MarkFlagRequired("since")
-	diffCmd.Flags().StringVarP(&diffTargetPlatform, "target-platform", "t", "linux/amd64", "Target platform (linux/amd64, linux/arm64, darwin/amd64, darwin/arm64)")
+	diffCmd.Flags().StringVarP(&diffTargetPlatform, "target-platform", "t", "linux/amd64", "Platform of the target host (linux/amd64, linux/arm64, darwin/amd64, darwin/arm64; darwin compares linux images)")
+	diffCmd.RegisterFlagCompletionFunc("target-platform", completeTargetPlatforms)
 	diffCmd.Flags().BoolVarP(&diffVerbose, "verbose", "v", false, "Show detailed layer information")
 	diffCmd.Flags().StringVar(&diffOutput, "output", "text", "Output format: text or json")
+	diffCmd.RegisterFlagCompletionFunc("output", completeOutputFormats)
+	diffCmd.Flags().StringVar(&diffFormat, "format", "", "Print the comparison through a Go template, e.g. '{{.Diff.NewLayersSize}}'")
+	diffCmd.MarkFlagsMutuallyExclusive("output", "format")
+	diffCmd.Flags().StringVar(&diffSnapshot, "snapshot", "", "Compare from a metadata snapshot (imgcd metadata export) instead of the registry")
 }
 
 func runDiff(cmd *cobra.Command, args []string) error {
@@ -64,6 +90,15 @@ func runDiff(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("--since flag is required")
 	}
 
+	var fetchOptions []ggcrremote.Option
+	if diffSnapshot != "" {
+		snapshot, err := remote.LoadSnapshot(diffSnapshot)
+		if err != nil {
+			return err
+		}
+		fetchOptions = snapshot.Options()
+	}
+
 	// Resolve base reference with fuzzy matching
 	var baseRef string
 	if !strings.Contains(diffSinceRef, "/") && !strings.Contains(diffSinceRef, ":") {
@@ -73,7 +108,7 @@ func runDiff(cmd *cobra.Command, args []string) error {
 			repo = repo[:idx]
 		}
 
-		fetcher := remote.NewFetcher()
+		fetcher := remote.NewFetcher(fetchOptions...)
 		exactTag, matches, err := fetcher.ResolveTag(cmd.Context(), repo, diffSinceRef)
 		if err != nil {
 			return err
@@ -102,16 +137,15 @@ func runDiff(cmd *cobra.Command, args []string) error {
 	}
 
 	// Validate target platform
-	validPlatforms := []string{"linux/amd64", "linux/arm64", "darwin/amd64", "darwin/arm64"}
 	valid := false
-	for _, p := range validPlatforms {
+	for _, p := range validTargetPlatforms {
 		if p == diffTargetPlatform {
 			valid = true
 			break
 		}
 	}
 	if !valid {
-		return fmt.Errorf("invalid target platform: %s (valid options: %v)", diffTargetPlatform, validPlatforms)
+		return fmt.Errorf("invalid target platform: %s (valid options: %v)", diffTargetPlatform, validTargetPlatforms)
 	}
 
 	// Validate output format
@@ -125,16 +159,29 @@ func runDiff(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid output format: %s (valid options: text, json)", diffOutput)
 	}
 
+	var tmpl *format.Template
+	if diffFormat != "" {
+		var err error
+		if tmpl, err = format.Parse(diffFormat); err != nil {
+			return err
+		}
+	}
+
 	// Create fetcher and differ
-	fetcher := remote.NewFetcher()
+	fetcher := remote.NewFetcher(fetchOptions...)
 	differ := diff.NewDiffer(fetcher)
 
 	// Perform comparison
-	result, err := differ.Compare(cmd.Context(), newRef, baseRef, diffTargetPlatform)
+	// Images are linux for darwin targets too, as in save
+	result, err := differ.Compare(cmd.Context(), newRef, baseRef, image.ImagePlatform(diffTargetPlatform))
 	if err != nil {
 		return fmt.Errorf("failed to compare images: %w", err)
 	}
 
+	if tmpl != nil {
+		return tmpl.Execute(os.Stdout, struct{ Diff *diff.DiffResult }{result})
+	}
+
 	// Format and output result
 	formatter := diff.NewFormatter(diff.FormatOptions{
 		Format:  outputFormat,
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// printPlan prints what save --dry-run found an export would do: where each
+// layer comes from, what would be downloaded and the bundle it would write
+func printPlan(plan *image.ExportPlan) {
+	fmt.Printf("\nImage: %s (%s)\n", plan.Image, plan.Platform)
+	if plan.Base != "" {
+		fmt.Printf("Base: %s (%d of %d layer(s) shared)\n", plan.Base, plan.SharedLayers, len(plan.Layers))
+	}
+	if plan.Mode != "registry" {
+		fmt.Printf("Mode: local, from %s; layer sizes are known once it saves the image\n", plan.Mode)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tSIZE\tSOURCE")
+	for _, layer := range plan.Layers {
+		size, source := "-", "download"
+		if plan.Mode == "registry" {
+			size = humanize.Size(layer.Size)
+		} else {
+			source = plan.Mode
+		}
+		switch {
+		case layer.FromBase:
+			source = "base (not stored)"
+		case layer.Cached:
+			source = "cache"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", getShortID(layer.Digest), size, source)
+	}
+	w.Flush()
+	fmt.Println()
+
+	absPath, _ := filepath.Abs(plan.Path)
+	if plan.UpToDate {
+		ui.Success("Dry run: bundle is up to date, nothing to download: %s", absPath)
+		return
+	}
+	if plan.Mode == "registry" {
+		downloads, downloadSize := plan.Downloads()
+		stored, _ := plan.Stored()
+		fmt.Printf("To download: %d blob(s), %s\n", downloads, humanize.Size(downloadSize))
+		fmt.Printf("From cache:  %d blob(s)\n", stored-downloads)
+		if plan.BinarySize == 0 {
+			fmt.Println("The imgcd release binary is not cached yet and would be downloaded too")
+		}
+		ui.Success("Dry run: would create %s (about %s)", absPath, humanize.Size(plan.EstimatedSize))
+		return
+	}
+	ui.Success("Dry run: would create %s", absPath)
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/templates"
+	"github.com/spf13/cobra"
+)
+
+var (
+	examplesImage    string
+	examplesRegistry string
+	examplesPlatform string
+)
+
+// exampleScenario is a walkthrough rendered from templates/examples
+type exampleScenario struct {
+	Name     string   // Template file name without .sh
+	Title    string   // One line shown in the list
+	Commands []string // Commands the scenario shows, for imgcd examples <command>
+}
+
+var exampleScenarios = []exampleScenario{
+	{Name: "full-export", Title: "Ship one image to an offline host", Commands: []string{"save", "verify", "load"}},
+	{Name: "incremental-chain", Title: "Ship only the layers that changed since the last release", Commands: []string{"save", "apply", "load", "rm-bundle"}},
+	{Name: "registry-seed", Title: "Fill the private registry of an air-gapped site", Commands: []string{"save", "push"}},
+}
+
+var examplesCmd = &cobra.Command{
+	Use:   "examples [COMMAND|SCENARIO]",
+	Short: "Print ready-to-run example scenarios",
+	Long: `Print example scenarios as shell scripts that can be copied and run as they
+are: the commands of both the connected and the offline side, with the
+bundle names save produces. They are built into imgcd, so they are at hand
+on the offline side too.
+
+Without arguments, the scenarios and the commands they show are listed.
+With a command name, every scenario using the command is printed; with a
+scenario name, that scenario. --image, --registry and --target-platform fill
+in your own names.
+
+Examples:
+  # List the scenarios
+  imgcd examples
+
+  # Scenarios that use imgcd save, for your image
+  imgcd examples save --image registry.example.com/team/api
+
+  # Seeding an offline registry, saved as a script to edit
+  imgcd examples registry-seed --registry harbor.site.local > seed.sh`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runExamples,
+}
+
+func init() {
+	examplesCmd.Flags().StringVar(&examplesImage, "image", "registry.example.com/team/myapp", "Image repository used in the examples, without tag")
+	examplesCmd.Flags().StringVar(&examplesRegistry, "registry", "registry.local:5000", "Registry of the offline site used in the examples")
+	examplesCmd.Flags().StringVarP(&examplesPlatform, "target-platform", "t", "linux/amd64", "Target platform used in the examples")
+	examplesCmd.RegisterFlagCompletionFunc("target-platform", completeTargetPlatforms)
+}
+
+func runExamples(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		fmt.Println("Scenarios:")
+		for _, scenario := range exampleScenarios {
+			fmt.Printf("  %-18s %s (%s)\n", scenario.Name, scenario.Title, strings.Join(scenario.Commands, ", "))
+		}
+		fmt.Println("\nRun imgcd examples <SCENARIO> or imgcd examples <COMMAND> to print them.")
+		return nil
+	}
+
+	repository, err := name.NewRepository(examplesImage)
+	if err != nil {
+		return fmt.Errorf("invalid --image %q: give a repository without tag, e.g. registry.example.com/team/myapp", examplesImage)
+	}
+
+	scenarios, err := selectScenarios(args[0])
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Image      string
+		Repository string // Image path without its registry, for pushing elsewhere
+		Registry   string
+		Platform   string
+	}{
+		Image:      examplesImage,
+		Repository: strings.TrimPrefix(examplesImage, repository.RegistryStr()+"/"),
+		Registry:   examplesRegistry,
+		Platform:   examplesPlatform,
+	}
+	funcs := template.FuncMap{
+		"bundle": func(tag, since string) string {
+			return image.BundleFilename(examplesImage+":"+tag, since)
+		},
+	}
+
+	for i, scenario := range scenarios {
+		text, err := templates.Examples.ReadFile("examples/" + scenario.Name + ".sh")
+		if err != nil {
+			return fmt.Errorf("failed to read scenario %s: %w", scenario.Name, err)
+		}
+		tmpl, err := template.New(scenario.Name).Funcs(funcs).Parse(string(text))
+		if err != nil {
+			return fmt.Errorf("failed to parse scenario %s: %w", scenario.Name, err)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := tmpl.Execute(os.Stdout, data); err != nil {
+			return fmt.Errorf("failed to render scenario %s: %w", scenario.Name, err)
+		}
+	}
+	return nil
+}
+
+// selectScenarios finds a scenario by name, or the scenarios showing a
+// command, which may also be given by an alias
+func selectScenarios(arg string) ([]exampleScenario, error) {
+	for _, scenario := range exampleScenarios {
+		if scenario.Name == arg {
+			return []exampleScenario{scenario}, nil
+		}
+	}
+
+	target, _, err := rootCmd.Find([]string{arg})
+	if err != nil || target == rootCmd {
+		return nil, fmt.Errorf("no command or scenario named %q; run imgcd examples to list them", arg)
+	}
+	var scenarios []exampleScenario
+	for _, scenario := range exampleScenarios {
+		for _, command := range scenario.Commands {
+			if command == target.Name() {
+				scenarios = append(scenarios, scenario)
+				break
+			}
+		}
+	}
+	if len(scenarios) == 0 {
+		return nil, fmt.Errorf("no scenario shows imgcd %s; see imgcd %s --help for its examples", target.Name(), target.Name())
+	}
+	return scenarios, nil
+}
@@ -0,0 +1,300 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/format"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inspectOutput  string
+	inspectFormat  string
+	inspectVerbose bool
+	inspectDedup   bool
+	inspectSBOM    bool
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <BUNDLE>...",
+	Short: "Show the metadata of a bundle without loading it",
+	Long: `Show what a bundle contains without a container runtime.
+
+Works with .tar, self-extracting .sh and image.tar.gz bundles, in the current
+and the legacy (v1) format. Reports the image and base references, platform,
+format version, provenance, and the image's layers: which ones are stored in
+the bundle and which ones an incremental bundle takes from its base on load.
+
+--dedup-report reads every stored layer of one or more bundles and reports
+how much of their content is stored more than once: files a higher layer of
+the same image replaces or deletes (what squashing the layers would save),
+further copies of the same file content in any layer (what a file-level,
+content-addressed format would save), and layers stored in several of the
+bundles. Sizes of files are uncompressed; layers an incremental bundle takes
+from its base are not analyzed.
+
+--sbom writes the SBOM stored by imgcd save --sbom or --generate-sbom (SPDX
+or CycloneDX JSON, as given) to stdout, for compliance audits on the target
+side.
+
+--format takes a Go text/template. The bundle is .Bundle, whose fields are
+those of the JSON output in Go spelling (ImageRef for image_ref). Helpers:
+json, size (human-readable bytes), join, upper, lower and short (12-character
+digest); \t and \n are expanded. With --dedup-report the report is .Dedup.
+
+Examples:
+  # Show a bundle's metadata and layer summary
+  imgcd inspect myapp-2.0__since-1.9.tar
+
+  # List every layer with its digest and size
+  imgcd inspect myapp-2.0__since-1.9.sh --verbose
+
+  # JSON output for scripting
+  imgcd inspect myapp-2.0__since-1.9.tar --output json
+
+  # Extract the bundle's SBOM
+  imgcd inspect myapp-2.0.tar --sbom > myapp-2.0.spdx.json
+
+  # Estimate what squashing or file-level dedup would save across bundles
+  imgcd inspect --dedup-report myapp-1.9.tar myapp-2.0__since-1.9.tar
+
+  # Print single fields with a Go template
+  imgcd inspect myapp-2.0__since-1.9.tar --format '{{.Bundle.ImageRef}} {{size .Bundle.Size}}'
+  imgcd inspect myapp-2.0__since-1.9.tar --format '{{range .Bundle.Layers}}{{short .Digest}}\t{{.Size}}\n{{end}}'`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runInspect,
+}
+
+func init() {
+	inspectCmd.Flags().StringVar(&inspectOutput, "output", "text", "Output format: text or json")
+	inspectCmd.RegisterFlagCompletionFunc("output", completeOutputFormats)
+	inspectCmd.Flags().StringVar(&inspectFormat, "format", "", "Print the bundle through a Go template, e.g. '{{.Bundle.Size}}'")
+	inspectCmd.MarkFlagsMutuallyExclusive("output", "format")
+	inspectCmd.Flags().BoolVarP(&inspectVerbose, "verbose", "v", false, "List every layer")
+	inspectCmd.Flags().BoolVar(&inspectDedup, "dedup-report", false, "Report duplicate files across layers and what squashing or file-level dedup would save")
+	inspectCmd.Flags().BoolVar(&inspectSBOM, "sbom", false, "Write the SBOM stored in the bundle to stdout")
+	inspectCmd.MarkFlagsMutuallyExclusive("sbom", "dedup-report")
+	inspectCmd.MarkFlagsMutuallyExclusive("sbom", "format")
+	inspectCmd.MarkFlagsMutuallyExclusive("sbom", "output")
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	if inspectOutput != "text" && inspectOutput != "json" {
+		return fmt.Errorf("invalid output format: %s (valid options: text, json)", inspectOutput)
+	}
+	if len(args) > 1 && !inspectDedup {
+		return fmt.Errorf("inspect takes one bundle, or several with --dedup-report")
+	}
+
+	var tmpl *format.Template
+	if inspectFormat != "" {
+		var err error
+		if tmpl, err = format.Parse(inspectFormat); err != nil {
+			return err
+		}
+	}
+
+	if inspectDedup {
+		report, err := image.AnalyzeDuplicates(args)
+		if err != nil {
+			return fmt.Errorf("failed to analyze bundles: %w", err)
+		}
+		if tmpl != nil {
+			return tmpl.Execute(os.Stdout, struct{ Dedup *image.DedupReport }{report})
+		}
+		if inspectOutput == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		}
+		printDedupReport(report)
+		return nil
+	}
+
+	if inspectSBOM {
+		name, data, err := image.ReadBundleSBOM(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if name == "" {
+			return fmt.Errorf("%s carries no SBOM (see imgcd save --sbom)", args[0])
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	info, err := image.InspectBundle(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to inspect bundle: %w", err)
+	}
+
+	if tmpl != nil {
+		return tmpl.Execute(os.Stdout, struct{ Bundle *image.BundleInfo }{info})
+	}
+
+	if inspectOutput == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	printBundleInfo(info)
+	return nil
+}
+
+// printBundleInfo prints the text form of inspect
+func printBundleInfo(info *image.BundleInfo) {
+	version := info.Version
+	if version == "1.0" {
+		version += " (legacy format)"
+	}
+
+	fmt.Printf("Bundle:         %s\n", info.Path)
+	fmt.Printf("Format:         %s, version %s, %s\n", info.Format, version, humanize.Size(info.Size))
+	if info.Builder != "" {
+		fmt.Printf("Created by:     imgcd %s for %s\n", info.Builder, info.TargetPlatform)
+	}
+	if info.Artifact != nil {
+		fmt.Printf("Artifact:       %s\n", info.ImageRef)
+		fmt.Printf("Artifact type:  %s\n", info.Artifact.Type())
+	} else if len(info.Images) > 0 {
+		fmt.Printf("Images:         %d\n", len(info.Images))
+		multiPlatform := strings.Contains(info.Platform, ", ")
+		for _, image := range info.Images {
+			if multiPlatform {
+				fmt.Printf("  %s [%s] (%d layers, %s)\n", image.ImageRef, image.Platform, image.Layers, humanize.Size(image.Size))
+			} else {
+				fmt.Printf("  %s (%d layers, %s)\n", image.ImageRef, image.Layers, humanize.Size(image.Size))
+			}
+		}
+	} else {
+		fmt.Printf("Image:          %s\n", info.ImageRef)
+	}
+	if info.BaseRef != "" {
+		fmt.Printf("Base:           %s\n", info.BaseRef)
+	}
+	if info.Platform != "" {
+		fmt.Printf("Platform:       %s\n", info.Platform)
+	}
+	if info.Binary != "" {
+		fmt.Printf("imgcd binary:   %s\n", info.Binary)
+	}
+	if info.CreatedAt != "" {
+		fmt.Printf("Created:        %s\n", info.CreatedAt)
+	}
+	if info.ExpiresAt != "" {
+		fmt.Printf("Expires:        %s\n", info.ExpiresAt)
+	}
+	if info.Note != "" {
+		fmt.Printf("Note:           %s\n", info.Note)
+	}
+	keys := make([]string, 0, len(info.Annotations))
+	for key := range info.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("Annotation:     %s=%s\n", key, info.Annotations[key])
+	}
+	if prov := info.Provenance; prov != nil {
+		producer := prov.User
+		if prov.Host != "" {
+			producer += "@" + prov.Host
+		}
+		if producer != "" {
+			fmt.Printf("Produced by:    %s\n", producer)
+		}
+		if prov.GitCommit != "" {
+			source := prov.GitCommit
+			if prov.GitRepository != "" {
+				source = prov.GitRepository + "@" + prov.GitCommit
+			}
+			fmt.Printf("Source:         %s\n", source)
+		}
+		if prov.Pipeline != "" {
+			fmt.Printf("Pipeline:       %s\n", prov.Pipeline)
+		}
+	}
+	if info.Statement {
+		fmt.Printf("Provenance:     in-toto statement included\n")
+	}
+	if info.SBOM != "" {
+		fmt.Printf("SBOM:           %s, extract with inspect --sbom\n", info.SBOM)
+	}
+	if info.Signed {
+		fmt.Printf("Signature:      embedded, check with load --key\n")
+	}
+
+	stored := 0
+	for _, layer := range info.Layers {
+		if layer.Stored {
+			stored++
+		}
+	}
+	fmt.Printf("Layers:         %d, %d stored (%s)", len(info.Layers), stored, humanize.Size(info.StoredSize))
+	if fromBase := len(info.Layers) - stored; fromBase > 0 {
+		fmt.Printf(", %d from base", fromBase)
+	}
+	fmt.Printf("\n")
+	if inspectVerbose {
+		for i, layer := range info.Layers {
+			source := "stored"
+			if !layer.Stored {
+				source = "base"
+			}
+			fmt.Printf("  %3d. %-12s  %10s  %s\n", i+1, getShortID(layer.Digest), humanize.Size(layer.Size), source)
+		}
+	}
+
+	if len(info.Extras) > 0 {
+		fmt.Printf("Attached files: %d\n", len(info.Extras))
+		for _, extra := range info.Extras {
+			fmt.Printf("  %s\n", extra)
+		}
+	}
+	if len(info.OnLoad) > 0 {
+		fmt.Printf("On load:        %d command(s), run by load --run-post-load\n", len(info.OnLoad))
+		fmt.Print(image.FormatPostLoad(info.OnLoad))
+	}
+}
+
+// printDedupReport prints the text form of inspect --dedup-report
+func printDedupReport(report *image.DedupReport) {
+	share := func(size int64) string {
+		if report.Size == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.1f%%", float64(size)*100/float64(report.Size))
+	}
+
+	fmt.Printf("Bundles:        %d\n", len(report.Bundles))
+	fmt.Printf("Layers:         %d analyzed", report.Layers)
+	if report.BaseLayers > 0 {
+		fmt.Printf(", %d from base not analyzed", report.BaseLayers)
+	}
+	if len(report.Unreadable) > 0 {
+		fmt.Printf(", %d unreadable", len(report.Unreadable))
+	}
+	fmt.Printf("\n")
+	fmt.Printf("Files:          %d (%s uncompressed)\n", report.Files, humanize.Size(report.Size))
+	if report.SharedLayers > 0 {
+		fmt.Printf("Shared layers:  %d stored more than once (%s compressed)\n", report.SharedLayers, humanize.Size(report.SharedSize))
+	}
+	fmt.Printf("Squash:         %d file(s) replaced or deleted by higher layers, %s (%s) saved\n",
+		report.Squash.Files, humanize.Size(report.Squash.Size), share(report.Squash.Size))
+	fmt.Printf("File-level:     %d duplicate file(s), %s (%s) saved\n",
+		report.FileLevel.Files, humanize.Size(report.FileLevel.Size), share(report.FileLevel.Size))
+
+	if len(report.Largest) > 0 {
+		fmt.Printf("Largest duplicates:\n")
+		for _, content := range report.Largest {
+			fmt.Printf("  %-12s  %10s x %d  %s\n", getShortID(content.Digest), humanize.Size(content.Size), content.Copies, strings.Join(content.Paths, ", "))
+		}
+	}
+}
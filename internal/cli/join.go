@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var joinOutDir string
+
+var joinCmd = &cobra.Command{
+	Use:   "join <BUNDLE>",
+	Short: "Reassemble a bundle split with save --split-size",
+	Long: `Join the parts of a bundle written by save --split-size (<bundle>.part1,
+.part2, ...) back into the bundle, given the bundle name or any of its parts.
+
+Each part is checked against <bundle>.parts.sha256 while it is copied, so a
+part damaged in transfer is named before anything is loaded, and the joined
+bundle against <bundle>.sha256, which is copied next to it. The parts are
+left in place.
+
+Examples:
+  # Join the parts on a USB stick into the current directory
+  imgcd join /media/usb/myapp-2.0__since-none.tar.part1
+
+  # Join into another directory, then load
+  imgcd join /media/usb/myapp-2.0__since-none.tar -o /srv/bundles
+  imgcd load --from /srv/bundles/myapp-2.0__since-none.tar`,
+	Args: cobra.ExactArgs(1),
+	RunE: runJoin,
+}
+
+func init() {
+	joinCmd.Flags().StringVarP(&joinOutDir, "out-dir", "o", ".", "Directory to write the joined bundle to")
+}
+
+func runJoin(cmd *cobra.Command, args []string) error {
+	if err := os.MkdirAll(joinOutDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	result, err := image.JoinBundle(image.SplitBundleBase(args[0]), joinOutDir)
+	if err != nil {
+		return fmt.Errorf("failed to join bundle: %w", err)
+	}
+
+	absPath, _ := filepath.Abs(result.Path)
+	ui.Success("Joined %d parts into %s (%s)", result.Parts, absPath, humanize.Size(result.Size))
+	if !result.Verified {
+		ui.Fwarning(os.Stdout, "no checksum file for the joined bundle; run imgcd verify before loading")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	layersOutput   string
+	layersPlatform string
+	layersRemote   bool
+)
+
+var layersCmd = &cobra.Command{
+	Use:   "layers <IMAGE_REF>",
+	Short: "Write the layer list of an image, to base the next bundle on",
+	Long: `Write the layers of an image as a small JSON file: the image, its platform
+and ID, and the DiffID of every layer, with its digest and size when known.
+
+Run on a disconnected target, it records exactly which image the target
+has, as its runtime holds it and without pulling. Carried back, the file is
+all save --since-layers needs to leave out the layers the target already
+has, even when that image is in no registry or runtime on the saving side.
+Images the runtime lacks, and all with --remote, are listed from the
+registry.
+
+Without -o the list is printed on stdout.
+
+Examples:
+  # On the target: what does it have?
+  imgcd layers myapp:1.9 -o layers.json
+
+  # On the connected side: only what the target lacks
+  imgcd save myapp:2.0 --since-layers layers.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLayers,
+}
+
+func init() {
+	layersCmd.Flags().StringVarP(&layersOutput, "output", "o", "", "Layer list file to write (default: stdout)")
+	layersCmd.Flags().StringVar(&layersPlatform, "platform", "", "Platform of the image (default: the runtime's; linux/amd64 with --remote)")
+	layersCmd.Flags().BoolVar(&layersRemote, "remote", false, "List the image from the registry instead of the local runtime")
+}
+
+func runLayers(cmd *cobra.Command, args []string) error {
+	ref := args[0]
+
+	var list *image.LayerList
+	var err error
+	if layersRemote {
+		platform := layersPlatform
+		if platform == "" {
+			platform = "linux/amd64"
+		}
+		list, err = image.FetchLayerList(cmd.Context(), ref, platform)
+	} else {
+		exporter, exportErr := image.NewExporter(Version)
+		if exportErr != nil {
+			return fmt.Errorf("failed to create exporter: %w", exportErr)
+		}
+		defer exporter.Close()
+		list, err = exporter.ListLayers(cmd.Context(), ref, layersPlatform)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list the layers of %s: %w", ref, err)
+	}
+
+	if layersOutput == "" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+	if err := list.Write(layersOutput); err != nil {
+		return err
+	}
+	absPath, _ := filepath.Abs(layersOutput)
+	ui.Success("Wrote %d layer(s) of %s to %s", len(list.Layers), ref, absPath)
+	fmt.Printf("\nTo save only what a host with this image lacks:\n  imgcd save <NEW_IMAGE_REF> --since-layers %s\n", filepath.Base(layersOutput))
+	return nil
+}
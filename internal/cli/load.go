@@ -1,46 +1,576 @@
 package cli
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/checksum"
 	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/priority"
+	"github.com/so2liu/imgcd/internal/prompt"
+	"github.com/so2liu/imgcd/internal/ratelimit"
+	"github.com/so2liu/imgcd/internal/ui"
 	"github.com/spf13/cobra"
 )
 
-var fromFile string
+var (
+	fromFile      string
+	enforceExpiry bool
+	squashExcess  bool
+	checksumKey   string
+	ioPriority    string
+	importRate    string
+	checkRunning  bool
+	forceLoad     bool
+	ociLayout     string
+	pushTo        string
+	extrasDir     string
+	runPostLoad   bool
+	assumeYes     bool
+	loadContexts  []string
+	loadHosts     []string
+	noResume      bool
+	loadPlatform  string
+	loadSHA256    string
+	loadKey       string
+	requireSigned bool
+	loadFailFast  bool
+	loadReport    string
+	loadOutputFmt string
+)
 
 var loadCmd = &cobra.Command{
 	Use:   "load",
-	Short: "Import a container image from a tar.gz file",
-	Long: `Import a container image from a tar.gz file created by imgcd save.
+	Short: "Import a container image from a bundle",
+	Long: `Import a container image from a bundle created by imgcd save.
 The image name and tag are automatically detected from the archive metadata.
 
+--from accepts the .tar bundle, the image.tar.gz inside it, or a
+self-extracting .sh bundle; the image data is streamed out of the bundle
+without unpacking it first.
+
+--from - reads the bundle from stdin instead, e.g. from curl or ssh, so it
+is never stored on the host: only the extracted layers and the rebuilt
+image take space, as with any load. The stream is read once; its format is
+told from its first bytes, the payload checksum of a .sh bundle is checked
+at the end of the image data, and --sha256 checks the whole stream (against
+the sha256 save -o - prints) before the image is imported. Artifact bundles
+on stdin need --oci-layout or --push.
+
+--from https://... downloads and imports the bundle in one step, the same
+way: nothing but the extracted layers is written to disk. A connection that
+breaks is resumed with a range request where it stopped. The <url>.sha256
+file is fetched first, when the server has one, and the download is checked
+against it before the image is imported; --checksum-key requires it and its
+.sha256.sig signature. An interrupted load resumes when run again, keeping
+the layers extracted so far, but the bundle is downloaded again.
+
 Examples:
+  # Import a bundle directly
+  imgcd load --from ./out/ns_app-1.2.9__since-1.2.8.tar
+
+  # Import a self-extracting bundle without running its script
+  imgcd load --from ./out/ns_app-1.2.9__since-1.2.8.sh
+
   # Import image from tar.gz
-  imgcd load --from ./out/ns_app-1.2.9__since-1.2.8.tar.gz`,
+  imgcd load --from image.tar.gz
+
+  # Load a bundle straight from another host, without a copy on disk
+  ssh build-host cat out/app-2.0__since-1.0.tar | imgcd load --from -
+  curl -fsSL https://files.example.com/app.sh | imgcd load --from - --sha256 9f2c...
+
+  # Download, verify and import a bundle in one step
+  imgcd load --from https://artifacts.internal/app.sh --checksum-key release.pub
+
+  # Load an image deeper than docker's 125-layer limit
+  imgcd load --from image.tar.gz --squash-excess
+
+  # Require a valid signature on the bundle's checksum file
+  imgcd load --from app.tar --checksum-key release.pub
+
+  # Load on a busy production host without starving running workloads
+  imgcd load --from app.tar --io-priority low --import-rate-limit 50M
+
+  # Refuse to move a tag that running containers were started from
+  imgcd load --from app.tar --check-running
+
+  # Also extract the compose file and scripts attached with save --attach
+  imgcd load --from app.tar --extras-dir ./deploy
+
+  # Deploy in one step: extract the attached compose file and run the bundle's
+  # on-load commands (e.g., docker compose up -d) after confirming them
+  imgcd load --from app.tar --extras-dir ./deploy --run-post-load
+
+  # Seed the same image into several nodes from one operator machine
+  imgcd load --from app.tar --context node-1 --context node-2 --host ssh://ops@node-3
+
+  # Load into the docker or containerd of an edge device over ssh
+  imgcd load --from app-2.0__since-1.0.sh --host ssh://ops@edge-node
+
+  # Only import bundles signed with the release key (save --sign-key)
+  imgcd load --from app.tar --key release.pub --require-signature
+
+  # Load a bundle of several images and keep a per-image record of the outcome
+  imgcd load --from stack-3images__since-none.tar --report load-report.json
+
+  # Import the arm64 variant of a bundle saved with --all-platforms
+  imgcd load --from app-2.0+2platforms__since-none.tar --platform linux/arm64
+
+  # Push a Helm chart bundle to the cluster's registry
+  imgcd load --from mychart-1.4.0__since-none.tar --push registry.local/charts/mychart
+
+  # For scripts: the outcome as one JSON document on stdout
+  imgcd load --from app.tar --output json | jq -r .status
+
+A <bundle>.sha256 file next to the bundle is verified, and its .sha256.sig
+signature too when --checksum-key is given. --sha256 checks the bundle
+against a sha256 given on the command line. The bundle is read only once:
+it is hashed while its image data is extracted, and a mismatch stops the
+load before the image is imported.
+
+Bundles also carry a checksums.txt, written by save, with the sha256 of the
+imgcd binary, metadata.json and every blob. It is checked on every load,
+with no flag needed, once the image data was read and before anything is
+imported, so a copy damaged on the way (a flaky USB stick) fails there
+instead of loading a broken image. An image.tar.gz unpacked from a bundle
+is checked against the checksums.txt next to it.
+
+--key checks the signature save --sign-key embeds in the bundle against an
+Ed25519 public key, also for bundles read from stdin or a URL. It covers the
+imgcd binary and the image data, and a bad signature stops the load before
+the image is imported. Unsigned bundles only get a warning, unless
+--require-signature is given.
+
+--io-priority low (or idle) lowers the CPU and IO priority of imgcd and the
+runtime commands it starts (nice, and ionice on Linux). The runtime daemon
+itself is not reniced, so --import-rate-limit additionally paces the image
+stream handed to docker load / ctr import.
+
+--check-running lists running containers (docker ps, or ctr tasks in the
+namespace ctr imports into, e.g. CONTAINERD_NAMESPACE=k8s.io for pods) before
+importing. Loading moves the tag: running containers keep their image, but
+anything that recreates them (compose up, a pod restart) starts the new one.
+If containers run the exact tag being loaded, the load stops unless --force
+is given; containers on other tags of the repository are only listed.
+
+Incremental bundles take the layers they share with their base from the base
+image. When it is not present, or its tag has moved to an image without those
+layers, the load looks for them in all local images instead and exports the
+fewest that together hold them, whatever their names.
+
+An interrupted load (crash, reboot, Ctrl-C) resumes when it is run again: the
+extracted blobs, the exported base image and the reconstructed image.tar are
+kept in ~/.imgcd/loads with a checksummed journal of the finished steps, and
+reused after checking them (blobs by size, the base image by its ID, the
+image.tar by its sha256). The directory is removed once the image is loaded;
+--no-resume discards it and starts over.
+
+Bundles may carry on-load commands (save --on-load). They only run with
+--run-post-load, after the load succeeded and once they are confirmed
+(--yes to skip the question); each runs with sh -c in the --extras-dir
+directory, with IMGCD_IMAGE and IMGCD_EXTRAS_DIR set. The first failing
+command stops the rest.
+
+--context and --host load into other docker daemons instead of the local
+runtime: a docker context by name, or a host URL (tcp://host:2376) as
+accepted by docker --host. An ssh://[user@]host[:port] host runs docker, or
+containerd's ctr if there is no docker, on that host through ssh (with sudo -n
+if needed): the image streams over the connection, so neither the bundle nor
+imgcd has to be copied there, and ssh must log in without a password prompt.
+Both repeat; with several
+targets the bundle is read and reconstructed once and the image is streamed
+into every target at the same time. A failing target does not stop the
+others, and the load fails listing the targets that did. Incremental bundles
+take their base image from the first target, so only that one needs it.
+
+Bundles saved from several images (imgcd save app:1.0 db:14) import all of
+them, one after the other; --check-running checks each of them. An image
+that fails to load does not stop the others: the load goes on, prints a
+table of every image's outcome at the end, and exits with status 3 when some
+images loaded and others failed (1 when none did). --fail-fast stops at the
+first failure instead, and --report writes the outcome of every image to a
+JSON file. Of bundles
+saved for several platforms (save --all-platforms), every target gets the
+variant of its own platform, as reported by its docker daemon; an image the
+bundle has no variant of for a target's platform fails. --platform imports one
+variant into every target instead, e.g. when a daemon's platform cannot be
+detected or an emulated platform is wanted; with other bundles it only
+checks that the bundle was saved for that platform.
+
+Bundles of OCI artifacts (Helm charts, WASM modules, files pushed with ORAS)
+cannot be loaded into a container runtime. --oci-layout writes them into an
+OCI image layout directory, tagged with the bundle's tag, and --push uploads
+them to a registry repository (keeping the bundle's tag unless one is given).
+Manifests keep their digest, and no container runtime is needed.
+
+--output json prints one JSON document on stdout when the load ends, and
+everything else on stderr: the status (loaded, partial or failed, with the
+error), the bundle, its size and sha256, the base, layer counts and digests,
+how many blobs an interrupted earlier load had extracted already, the outcome
+of every image as in --report, and the duration.`,
 	RunE: runLoad,
 }
 
 func init() {
-	loadCmd.Flags().StringVar(&fromFile, "from", "", "Path to the tar.gz file to import (required)")
+	loadCmd.Flags().StringVar(&fromFile, "from", "", "Path to the bundle (.tar, .sh or image.tar.gz) to import, - for stdin, or an http(s) URL (required)")
 	loadCmd.MarkFlagRequired("from")
+	loadCmd.Flags().BoolVar(&squashExcess, "squash-excess", false, "Squash the top layers of images deeper than 125 layers into one so overlay2 can load them")
+	loadCmd.Flags().BoolVar(&enforceExpiry, "enforce-expiry", false, "Refuse to load bundles past their expiry date (default: warn only)")
+	loadCmd.Flags().StringVar(&loadSHA256, "sha256", "", "Expected sha256 of the bundle, e.g. as printed by save -o -; checked before importing")
+	loadCmd.Flags().StringVar(&checksumKey, "checksum-key", "", "Ed25519 public key (PEM) the .sha256.sig signature must verify against")
+	loadCmd.Flags().StringVar(&loadKey, "key", "", "Ed25519 public key (PEM) the bundle's embedded signature (save --sign-key) must verify against")
+	loadCmd.Flags().BoolVar(&requireSigned, "require-signature", false, "Refuse bundles without an embedded signature (needs --key)")
+	loadCmd.Flags().StringVar(&ioPriority, "io-priority", priority.Normal, "CPU and IO priority: normal, low or idle")
+	loadCmd.Flags().StringVar(&importRate, "import-rate-limit", "", "Maximum rate the image is streamed into the runtime, per second (e.g., 50M, 1G)")
+	loadCmd.Flags().BoolVar(&checkRunning, "check-running", false, "Before importing, look for running containers of the image's repository and refuse to retag a tag they run")
+	loadCmd.Flags().BoolVar(&forceLoad, "force", false, "With --check-running, load even if running containers use the tag")
+	loadCmd.Flags().StringVar(&ociLayout, "oci-layout", "", "Write OCI artifacts (Helm charts, WASM, ORAS files) into this OCI image layout directory")
+	loadCmd.Flags().StringVar(&extrasDir, "extras-dir", "", "Extract the files attached to the bundle (compose files, manifests, scripts) into this directory")
+	loadCmd.Flags().BoolVar(&runPostLoad, "run-post-load", false, "After loading, run the bundle's on-load commands (shown for confirmation first)")
+	loadCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "With --run-post-load, run the commands without asking")
+	loadCmd.Flags().StringArrayVar(&loadContexts, "context", nil, "Load into this docker context instead of the local runtime (repeatable)")
+	loadCmd.Flags().StringArrayVar(&loadHosts, "host", nil, "Load into the runtime at this host, e.g. ssh://user@node or tcp://node:2376 (repeatable)")
+	loadCmd.Flags().BoolVar(&noResume, "no-resume", false, "Start over instead of resuming an interrupted load of the same bundle")
+	loadCmd.Flags().StringVar(&loadPlatform, "platform", "", "Import the variant of this platform (os/arch[/variant]) from multi-platform bundles (default: each target's own)")
+	loadCmd.Flags().BoolVar(&loadFailFast, "fail-fast", false, "Stop a bundle of several images at the first image that fails instead of loading the others")
+	loadCmd.Flags().StringVar(&loadReport, "report", "", "Write the outcome of every image (loaded or failed, error, time) to this JSON file")
+	loadCmd.Flags().StringVar(&loadOutputFmt, "output", outputText, "Output format: text, or json for one JSON document on stdout with the rest on stderr")
+	loadCmd.RegisterFlagCompletionFunc("output", completeOutputFormats)
+	loadCmd.Flags().StringVar(&pushTo, "push", "", "Push OCI artifacts to this registry repository (e.g., registry.local/charts/app)")
 }
 
 func runLoad(cmd *cobra.Command, args []string) error {
-	// Create importer
-	importer, err := image.NewImporter()
+	if err := checkOutputFormat(loadOutputFmt); err != nil {
+		return err
+	}
+	doc := &loadOutput{Bundle: fromFile, report: &image.LoadReport{}}
+	if loadOutputFmt == outputText {
+		return load(cmd, doc)
+	}
+	if image.IsURL(fromFile) {
+		doc.Bundle = image.RedactURL(fromFile)
+	}
+	return runWithJSONOutput(func() error { return load(cmd, doc) }, doc.finish)
+}
+
+// load runs load, filling in doc for --output json
+func load(cmd *cobra.Command, doc *loadOutput) error {
+	rateLimit, err := setIOLimits(ioPriority, importRate)
 	if err != nil {
-		return fmt.Errorf("failed to create importer: %w", err)
+		return err
+	}
+	if loadPlatform != "" {
+		if _, err := v1.ParsePlatform(loadPlatform); err != nil || strings.Count(loadPlatform, "/") < 1 {
+			return fmt.Errorf("invalid --platform %q: expected os/arch[/variant], e.g. linux/arm64", loadPlatform)
+		}
+	}
+
+	expected := strings.ToLower(strings.TrimPrefix(loadSHA256, "sha256:"))
+	if _, err := hex.DecodeString(expected); err != nil || expected != "" && len(expected) != 64 {
+		return fmt.Errorf("invalid --sha256 %q: expected 64 hex digits", loadSHA256)
+	}
+
+	if requireSigned && loadKey == "" {
+		return fmt.Errorf("--require-signature needs --key to check the signature with")
+	}
+	if loadKey != "" {
+		if err := checksum.CheckPublicKey(loadKey); err != nil {
+			return fmt.Errorf("invalid --key: %w", err)
+		}
+	}
+
+	// Bundles on stdin and at URLs are read once, as a stream, and checked
+	// while they are read: against --sha256, or the .sha256 file next to
+	// the URL
+	stdin, remote := fromFile == "-", image.IsURL(fromFile)
+	switch {
+	case stdin:
+		if checksumKey != "" {
+			return fmt.Errorf("--checksum-key cannot be used with --from -, which has no .sha256 file; check the stream with --sha256")
+		}
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("refusing to read a bundle from a terminal; pipe or redirect it into stdin")
+		}
+	case remote:
+		if expected, err = verifyURLChecksums(os.Stdout, fromFile, checksumKey, expected); err != nil {
+			return err
+		}
+	default:
+		if expected, err = sidecarChecksum(os.Stdout, fromFile, checksumKey, expected); err != nil {
+			return err
+		}
+	}
+
+	doc.SHA256 = expected
+
+	// Artifacts do not go into a container runtime, so none is required.
+	// A file's metadata is peeked at; a stream cannot be looked into first,
+	// so --oci-layout and --push, which only apply to artifacts, say it
+	// holds one.
+	artifact := ociLayout != "" || pushTo != ""
+	if !stdin && !remote {
+		peeked, err := image.ReadBundleSummary(fromFile)
+		if err != nil {
+			return fmt.Errorf("failed to read bundle metadata: %w", err)
+		}
+		artifact = peeked.Artifact != ""
+	}
+	var importer *image.Importer
+	if artifact {
+		importer = image.NewArtifactImporter()
+	} else {
+		importer, err = newLoadImporter(append(loadContexts, loadHosts...))
+		if err != nil {
+			return fmt.Errorf("failed to create importer: %w", err)
+		}
 	}
 	defer importer.Close()
 
 	// Import image
-	imageName, err := importer.Import(cmd.Context(), fromFile)
+	opts := image.LoadOptions{
+		EnforceExpiry:   enforceExpiry,
+		SquashExcess:    squashExcess,
+		ImportRateLimit: rateLimit,
+		CheckRunning:    checkRunning,
+		Force:           forceLoad,
+		ArtifactLayout:  ociLayout,
+		ArtifactPush:    pushTo,
+		ExtrasDir:       extrasDir,
+		RunPostLoad:     runPostLoad,
+		ConfirmPostLoad: confirmPostLoad(assumeYes),
+		NoResume:        noResume,
+		Platform:        loadPlatform,
+		SHA256:          expected,
+
+		SignatureKey:     loadKey,
+		RequireSignature: requireSigned,
+	}
+	// The loader reports what it loaded, so the bundle is read only once
+	report := doc.report
+	opts.FailFast = loadFailFast
+	opts.Report = report
+	summary, err := importFrom(cmd.Context(), importer, fromFile, opts)
 	if err != nil {
-		return fmt.Errorf("failed to import image: %w", err)
+		err = fmt.Errorf("failed to import image: %w", err)
 	}
+	if err := finishLoadReport(cmd, report, loadReport, len(report.Images()) > 1, err); err != nil {
+		return err
+	}
+	doc.record(summary)
 
-	fmt.Printf("✓ Successfully imported image: %s\n", imageName)
+	imageName := strings.Join(summary.ImageRefs(), ", ")
+	if summary.Artifact != "" {
+		ui.Success("Successfully imported artifact: %s", imageName)
+		return nil
+	}
+	if len(summary.MoreImages) > 0 {
+		ui.Success("Successfully imported %d images: %s", len(summary.ImageRefs()), imageName)
+		return nil
+	}
+	ui.Success("Successfully imported image: %s", imageName)
+
+	return nil
+}
+
+// importFrom loads the bundle of --from: a file, stdin ("-") or a URL
+func importFrom(ctx context.Context, importer *image.Importer, from string, opts image.LoadOptions) (*image.BundleSummary, error) {
+	switch {
+	case from == "-":
+		return importer.ImportStream(ctx, os.Stdin, "stdin", opts)
+	case !image.IsURL(from):
+		return importer.ImportFile(ctx, from, opts)
+	}
+	body, err := image.OpenURL(ctx, from, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return importer.ImportStream(ctx, body, image.RedactURL(from), opts)
+}
+
+// newLoadImporter imports into the given docker targets, or the detected
+// runtime when there are none
+func newLoadImporter(targets []string) (*image.Importer, error) {
+	if len(targets) == 0 {
+		return image.NewImporter()
+	}
+	return image.NewTargetImporter(targets)
+}
+
+// confirmPostLoad shows the on-load commands and asks before running them
+func confirmPostLoad(assumeYes bool) func([]string) (bool, error) {
+	return func(commands []string) (bool, error) {
+		fmt.Printf("\nThe bundle asks to run these commands:\n%s", image.FormatPostLoad(commands))
+		if assumeYes {
+			return true, nil
+		}
+		ok, err := prompt.Confirm("Run them?")
+		if err != nil {
+			return false, fmt.Errorf("%w; pass --yes to run the post-load commands unattended", err)
+		}
+		return ok, nil
+	}
+}
+
+// setIOLimits applies --io-priority and parses --import-rate-limit
+func setIOLimits(level, rate string) (int64, error) {
+	if err := priority.Set(level); err != nil {
+		return 0, fmt.Errorf("failed to apply --io-priority: %w", err)
+	}
+	return parseRate("import-rate-limit", rate)
+}
+
+// parseRate parses a rate flag like 50M or 10MB/s, naming the flag in errors
+func parseRate(flag, rate string) (int64, error) {
+	limit, err := ratelimit.Parse(rate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --%s: %w", flag, err)
+	}
+	return limit, nil
+}
+
+// verifyURLChecksums fetches the <url>.sha256 file of a bundle URL and, with
+// a key, checks its .sha256.sig signature. It returns the sha256 the
+// download must match: the recorded one, or the --sha256 given.
+func verifyURLChecksums(w io.Writer, bundleURL, keyPath, given string) (string, error) {
+	name := image.RedactURL(bundleURL)
+	sidecar, err := image.FetchURL(siblingURL(bundleURL, ".sha256"), 4096)
+	if err != nil {
+		// Presigned URLs do not cover the .sha256 next to them, so only a
+		// key makes the checksum file mandatory
+		switch {
+		case keyPath != "" && errors.Is(err, image.ErrNotFound):
+			return "", fmt.Errorf("--checksum-key given but %s has no .sha256 file", name)
+		case keyPath != "":
+			return "", fmt.Errorf("failed to download the checksum of %s: %w", name, err)
+		case !errors.Is(err, image.ErrNotFound):
+			ui.Fwarning(w, "cannot download the checksum of %s (%v), loading without it", name, err)
+		}
+		return given, nil
+	}
+	recorded, err := checksum.Parse(sidecar)
+	if err != nil {
+		return "", fmt.Errorf("checksum of %s: %w", name, err)
+	}
+	if given != "" && given != recorded {
+		return "", fmt.Errorf("--sha256 %s does not match the checksum next to %s (%s)", given, name, recorded)
+	}
+	fmt.Fprintf(w, "Checksum from %s: %s, checked while loading\n", path.Base(urlPath(bundleURL))+".sha256", recorded)
+
+	signature, err := image.FetchURL(siblingURL(bundleURL, ".sha256.sig"), 4096)
+	switch {
+	case errors.Is(err, image.ErrNotFound):
+		if keyPath != "" {
+			return "", fmt.Errorf("--checksum-key given but the checksum of %s is not signed", name)
+		}
+	case err != nil:
+		return "", fmt.Errorf("failed to download the checksum signature of %s: %w", name, err)
+	case keyPath == "":
+		fmt.Fprintf(w, "Note: the checksum is signed, pass --checksum-key to verify the signature\n")
+	default:
+		if err := checksum.VerifySignatureData(sidecar, signature, keyPath); err != nil {
+			return "", err
+		}
+		ui.Fsuccess(w, "Checksum signature verified")
+	}
+	return recorded, nil
+}
+
+// urlPath returns the path of rawURL
+func urlPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Path
+}
+
+// siblingURL returns the URL of the file named like the one at rawURL plus
+// suffix, keeping the query (e.g. a presigned token)
+func siblingURL(rawURL, suffix string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL + suffix
+	}
+	u.Path += suffix
+	u.RawPath = ""
+	return u.String()
+}
+
+// sidecarChecksum returns the sha256 a bundle must match when it is loaded:
+// the one its .sha256 sidecar records, after checking the sidecar's
+// signature, or the --sha256 given. A key demands a valid signature. The
+// bundle itself is checked while it is read, so it is read only once.
+func sidecarChecksum(w io.Writer, bundlePath, keyPath, given string) (string, error) {
+	sidecar := filepath.Base(checksum.SidecarPath(bundlePath))
+
+	recorded, err := checksum.Recorded(bundlePath)
+	if errors.Is(err, checksum.ErrNoSidecar) {
+		if keyPath != "" {
+			return "", fmt.Errorf("--checksum-key given but %s does not exist", sidecar)
+		}
+		return given, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if given != "" && given != recorded {
+		return "", fmt.Errorf("--sha256 %s does not match %s (%s)", given, sidecar, recorded)
+	}
+	if err := verifySignature(w, bundlePath, keyPath); err != nil {
+		return "", err
+	}
+	return recorded, nil
+}
+
+// verifyChecksums checks a bundle against its sidecar files when present.
+// A key demands a valid signature.
+func verifyChecksums(w io.Writer, bundlePath, keyPath string) error {
+	sidecar := filepath.Base(checksum.SidecarPath(bundlePath))
+
+	err := checksum.VerifySidecar(bundlePath)
+	if errors.Is(err, checksum.ErrNoSidecar) {
+		if keyPath != "" {
+			return fmt.Errorf("--checksum-key given but %s does not exist", sidecar)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ui.Fsuccess(w, "Checksum verified (%s)", sidecar)
+
+	return verifySignature(w, bundlePath, keyPath)
+}
+
+// verifySignature checks the signature of a bundle's sidecar, if it has
+// one; a key demands it
+func verifySignature(w io.Writer, bundlePath, keyPath string) error {
+	sidecar := filepath.Base(checksum.SidecarPath(bundlePath))
+
+	if !checksum.HasSignature(bundlePath) {
+		if keyPath != "" {
+			return fmt.Errorf("--checksum-key given but %s is not signed", sidecar)
+		}
+		return nil
+	}
+	if keyPath == "" {
+		fmt.Fprintf(w, "Note: %s is signed, pass --checksum-key to verify the signature\n", sidecar)
+		return nil
+	}
+	if err := checksum.VerifySignature(bundlePath, keyPath); err != nil {
+		return err
+	}
+	ui.Fsuccess(w, "Checksum signature verified")
 
 	return nil
 }
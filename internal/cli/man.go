@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/manpage"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var manOutDir string
+
+var manCmd = &cobra.Command{
+	Use:   "man [COMMAND...]",
+	Short: "Print or install the manual pages",
+	Long: `Print the man page of imgcd or one of its commands, or write the pages of
+all commands into a directory. The pages are generated from the built-in
+help, so they are always at hand on offline hosts and match the binary.
+The page of imgcd itself also covers what applies to all commands, such as
+where imgcd keeps its state.
+
+Examples:
+  # Read what applies to all commands
+  imgcd man | man -l -
+
+  # Read the page of imgcd save
+  imgcd man save | man -l -
+
+  # Install all pages for man(1)
+  sudo imgcd man --out-dir /usr/local/share/man/man1
+  man imgcd-save
+
+  # Pages of subcommands are named after their path
+  imgcd man cache list`,
+	RunE: runMan,
+}
+
+func init() {
+	manCmd.Flags().StringVarP(&manOutDir, "out-dir", "o", "", "Write the pages of all commands into this directory instead of printing one")
+}
+
+func runMan(cmd *cobra.Command, args []string) error {
+	header := manpage.Header{
+		Section: "1",
+		Date:    time.Now(),
+		Source:  "imgcd " + Version,
+		Manual:  "imgcd Manual",
+
+		Sections: map[string][]manpage.Section{rootCmd.CommandPath(): rootManual},
+	}
+
+	if manOutDir != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--out-dir writes the pages of all commands; it takes no COMMAND")
+		}
+		paths, err := manpage.WriteTree(manOutDir, rootCmd, header)
+		if err != nil {
+			return err
+		}
+		ui.Success("Wrote %d man pages to %s", len(paths), manOutDir)
+		return nil
+	}
+
+	target, rest, err := rootCmd.Find(args)
+	if err != nil || len(rest) > 0 {
+		return fmt.Errorf("unknown command %q; see imgcd --help", strings.Join(args, " "))
+	}
+	return manpage.Render(os.Stdout, target, header)
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"github.com/so2liu/imgcd/internal/remote"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var metadataOutput string
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata",
+	Short: "Carry registry metadata for offline planning",
+	Long: `Carry registry metadata for offline planning.
+
+Available commands:
+  export - Store the manifests, configs and tags of images in a snapshot file`,
+}
+
+var metadataExportCmd = &cobra.Command{
+	Use:   "export <IMAGE_REF>...",
+	Short: "Store the manifests, configs and tags of images in a snapshot file",
+	Long: `Store the manifests, configs and tag lists of images in a snapshot file.
+
+Every platform of a multi-platform image is stored; layers are not. Carried
+into an air gap, the snapshot lets imgcd diff --snapshot compare the images,
+and resolve --since tags, without any connection to the registry.
+
+Examples:
+  # Snapshot two releases before going offline
+  imgcd metadata export myapp:1.0 myapp:2.0 -o myapp.snapshot.json
+
+  # Plan the incremental bundle later, offline
+  imgcd diff myapp:2.0 --since 1.0 --snapshot myapp.snapshot.json`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runMetadataExport,
+}
+
+func init() {
+	metadataCmd.AddCommand(metadataExportCmd)
+
+	metadataExportCmd.Flags().StringVarP(&metadataOutput, "output", "o", "", "Snapshot file to write (required)")
+	metadataExportCmd.MarkFlagRequired("output")
+}
+
+func runMetadataExport(cmd *cobra.Command, args []string) error {
+	snapshot, err := remote.ExportSnapshot(cmd.Context(), args)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Save(metadataOutput); err != nil {
+		return err
+	}
+	ui.Success("Metadata of %d images saved to %s", len(args), metadataOutput)
+	return nil
+}
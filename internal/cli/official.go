@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/so2liu/imgcd/internal/image"
+)
+
+// checkOfficialBase compares the images of a bundle with the official
+// images they were built from and prints the outcome of each; it fails if
+// any image is not built on the official layers
+func checkOfficialBase(ctx context.Context, bundlePath, base string, fetch []ggcrremote.Option) ([]image.OfficialCheck, error) {
+	checks, err := image.CheckOfficialBase(ctx, bundlePath, image.OfficialOptions{Base: base, Fetch: fetch})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the base image: %w", err)
+	}
+
+	failed := 0
+	for _, check := range checks {
+		label := check.Image
+		if check.Platform != "" {
+			label += " (" + check.Platform + ")"
+		}
+		if !check.OK() {
+			fmt.Printf("  Base of %s does not match: %s\n", label, check.Problem)
+			failed++
+			continue
+		}
+		fmt.Printf("  Base of %s matches %s (%d layer(s))\n", label, check.Base, check.Layers)
+		if !check.Official {
+			fmt.Printf("    %s is not a Docker Official Image\n", check.Base)
+		}
+	}
+	if failed > 0 {
+		return checks, fmt.Errorf("%d of %d image(s) not built on the official layers of their base", failed, len(checks))
+	}
+	return checks, nil
+}
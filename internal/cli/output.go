@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/image"
+)
+
+// Values of the --output flag of save and load
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// checkOutputFormat validates an --output flag
+func checkOutputFormat(format string) error {
+	if format != outputText && format != outputJSON {
+		return fmt.Errorf("invalid output format: %s (valid options: text, json)", format)
+	}
+	return nil
+}
+
+// runWithJSONOutput runs a command with what it prints sent to stderr, then
+// prints the document finish fills in from the command's error as the only
+// thing on stdout. The command's error is returned as it is.
+func runWithJSONOutput(run func() error, finish func(err error, elapsed time.Duration) any) error {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	started := time.Now()
+	err := run()
+	os.Stdout = stdout
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if werr := encoder.Encode(finish(err, time.Since(started))); werr != nil && err == nil {
+		return fmt.Errorf("failed to write JSON output: %w", werr)
+	}
+	return err
+}
+
+// errorText is the error field of a JSON document; exit statuses that are
+// not failures (save --if-changed) leave it empty
+func errorText(err error) string {
+	var exitErr *ExitError
+	if err == nil || errors.As(err, &exitErr) {
+		return ""
+	}
+	return err.Error()
+}
+
+// saveOutput is the document save --output json prints
+type saveOutput struct {
+	Status string `json:"status"` // created, up_to_date, unchanged, dry_run or failed
+	Error  string `json:"error,omitempty"`
+
+	Image    string `json:"image"`
+	Base     string `json:"base,omitempty"`
+	Platform string `json:"platform,omitempty"`
+
+	Bundle   string   `json:"bundle,omitempty"`
+	Checksum string   `json:"checksum_file,omitempty"`
+	SHA256   string   `json:"sha256,omitempty"`
+	Size     int64    `json:"size,omitempty"`
+	Parts    []string `json:"parts,omitempty"`
+
+	LayerCount int                 `json:"layer_count"`
+	Stored     int                 `json:"stored_layers"`
+	FromBase   int                 `json:"base_layers"`
+	StoredSize int64               `json:"stored_size"`
+	Downloads  image.DownloadStats `json:"downloads"`
+	Seconds    float64             `json:"duration_seconds"`
+
+	Images []image.ImageSummary `json:"images,omitempty"`
+	Layers []image.LayerSummary `json:"layers,omitempty"` // Digests of every layer, stored or from the base
+
+	Official []image.OfficialCheck `json:"official_base,omitempty"` // Of --check-official
+	Plan     *image.ExportPlan     `json:"plan,omitempty"`          // Of --dry-run
+}
+
+// record fills in the bundle save created or reused
+func (o *saveOutput) record(path string, result *image.ExportResult) error {
+	o.Status = "created"
+	if result.UpToDate {
+		o.Status = "up_to_date"
+	}
+	o.Bundle = path
+	o.Downloads = result.Downloads
+	if sum, err := checksum.Recorded(path); err == nil {
+		o.Checksum, o.SHA256 = checksum.SidecarPath(path), sum
+	}
+
+	info, err := image.InspectBundle(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the bundle for the JSON output: %w", err)
+	}
+	o.Image, o.Base, o.Platform = info.ImageRef, info.BaseRef, info.Platform
+	o.Size, o.StoredSize = info.Size, info.StoredSize
+	o.Images, o.Layers = info.Images, info.Layers
+	o.LayerCount = len(info.Layers)
+	for _, layer := range info.Layers {
+		if layer.Stored {
+			o.Stored++
+		} else {
+			o.FromBase++
+		}
+	}
+	return nil
+}
+
+// recordPlan fills in what save --dry-run found an export would do
+func (o *saveOutput) recordPlan(plan *image.ExportPlan) {
+	o.Status, o.Plan = "dry_run", plan
+	o.Image, o.Base, o.Platform, o.Bundle = plan.Image, plan.Base, plan.Platform, plan.Path
+	o.Size = plan.EstimatedSize
+	o.LayerCount, o.FromBase = len(plan.Layers), plan.SharedLayers
+	o.Stored, o.StoredSize = plan.Stored()
+}
+
+// finish completes the document once save returned err
+func (o *saveOutput) finish(err error, elapsed time.Duration) any {
+	o.Seconds = elapsed.Seconds()
+	if o.Error = errorText(err); o.Error != "" {
+		o.Status = "failed"
+	}
+	return o
+}
+
+// loadOutput is the document load --output json prints
+type loadOutput struct {
+	Status string `json:"status"` // loaded, partial or failed
+	Error  string `json:"error,omitempty"`
+
+	Bundle string `json:"bundle"`
+	Size   int64  `json:"size,omitempty"` // Of a bundle file; unknown for streams
+	SHA256 string `json:"sha256,omitempty"`
+	Base   string `json:"base,omitempty"`
+
+	LayerCount int      `json:"layer_count"`
+	FromBase   int      `json:"base_layers"`
+	Blobs      int      `json:"blobs"`         // Stored in the bundle
+	Resumed    int      `json:"resumed_blobs"` // Extracted by an interrupted earlier load
+	Layers     []string `json:"layers,omitempty"`
+	Seconds    float64  `json:"duration_seconds"`
+
+	Loaded  int                 `json:"loaded"`
+	Failed  int                 `json:"failed"`
+	Skipped int                 `json:"skipped"`
+	Images  []image.ImageStatus `json:"images"`
+
+	report *image.LoadReport
+}
+
+// record fills in the bundle load read
+func (o *loadOutput) record(summary *image.BundleSummary) {
+	o.Base = summary.BaseRef
+	o.Layers = summary.Layers
+	o.LayerCount = len(summary.Layers)
+	o.FromBase = summary.SharedLayers
+	o.Blobs, o.Resumed = summary.Blobs, summary.Resumed
+}
+
+// finish completes the document once load returned err
+func (o *loadOutput) finish(err error, elapsed time.Duration) any {
+	o.Seconds = elapsed.Seconds()
+	if info, serr := os.Stat(o.Bundle); serr == nil && info.Mode().IsRegular() {
+		o.Size = info.Size()
+	}
+	o.Images = o.report.Images()
+	if o.Images == nil {
+		o.Images = []image.ImageStatus{}
+	}
+	o.Loaded = o.report.Count(image.StatusLoaded)
+	o.Failed = o.report.Count(image.StatusFailed)
+	o.Skipped = o.report.Count(image.StatusSkipped)
+
+	var exitErr *ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.Code == ExitPartialLoad:
+		o.Status = "partial"
+	case err != nil:
+		o.Status, o.Error = "failed", err.Error()
+	default:
+		o.Status = "loaded"
+	}
+	return o
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/so2liu/imgcd/sdk"
+	"github.com/spf13/cobra"
+)
+
+// pluginPrefix names plugin executables: imgcd-<name> on PATH adds
+// "imgcd <name>"
+const pluginPrefix = "imgcd-"
+
+var pluginCmd = &cobra.Command{
+	Use:   "plugin",
+	Short: "Manage plugins, external commands named imgcd-<name>",
+	Long: `Plugins add site-specific commands (custom transports, approval workflows)
+without a fork of imgcd. Any executable on PATH named imgcd-<name> runs as
+"imgcd <name>", with the remaining arguments passed through. Dashes in the
+name nest: imgcd-bundle-sign runs for "imgcd bundle-sign" and, as the
+built-in bundle command has no sign subcommand, for "imgcd bundle sign".
+
+Built-in commands always win over plugins of the same name. Plugins get
+IMGCD_EXECUTABLE (the imgcd binary that ran them) and IMGCD_VERSION in
+their environment. Plugins written in Go can read and write bundles with
+the github.com/so2liu/imgcd/sdk package.
+
+Examples:
+  # List the plugins found on PATH
+  imgcd plugin list`,
+}
+
+var pluginListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the plugins found on PATH",
+	Args:  cobra.NoArgs,
+	RunE:  runPluginList,
+}
+
+func init() {
+	pluginCmd.AddCommand(pluginListCmd)
+}
+
+func runPluginList(cmd *cobra.Command, args []string) error {
+	plugins := findPlugins()
+	if len(plugins) == 0 {
+		fmt.Println("No plugins found on PATH")
+		return nil
+	}
+
+	seen := make(map[string]string)
+	for _, path := range plugins {
+		name := strings.TrimPrefix(filepath.Base(path), pluginPrefix)
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		fmt.Println(path)
+
+		words := strings.Split(name, "-")
+		if found, rest, err := rootCmd.Find(words); err == nil && found != rootCmd && len(rest) == 0 {
+			ui.Failure("%s is shadowed by the built-in command imgcd %s", path, strings.Join(words, " "))
+		} else if first, ok := seen[name]; ok {
+			ui.Failure("%s is shadowed by %s", path, first)
+		} else {
+			seen[name] = path
+		}
+	}
+	return nil
+}
+
+// findPlugins lists the plugin executables on PATH in lookup order
+func findPlugins() []string {
+	var plugins []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		var found []string
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), pluginPrefix) || entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if _, err := exec.LookPath(path); err == nil {
+				found = append(found, path)
+			}
+		}
+		sort.Strings(found)
+		plugins = append(plugins, found...)
+	}
+	return plugins
+}
+
+// lookupPlugin returns the plugin that handles args and the arguments left
+// for it. Built-in commands are never handed to plugins, and the longest
+// name wins: "imgcd foo bar" runs imgcd-foo-bar before imgcd-foo.
+func lookupPlugin(args []string) (string, []string, bool) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, false
+	}
+	// A built-in command wins unless it is only a group, such as bundle,
+	// given a subcommand it does not have
+	if found, rest, err := rootCmd.Find(args); err == nil && (found.Runnable() || len(rest) == 0) {
+		return "", nil, false
+	}
+
+	var words []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || strings.ContainsAny(arg, `/\`) {
+			break
+		}
+		words = append(words, arg)
+	}
+	for n := len(words); n > 0; n-- {
+		if path, err := exec.LookPath(pluginPrefix + strings.Join(words[:n], "-")); err == nil {
+			return path, args[n:], true
+		}
+	}
+	return "", nil, false
+}
+
+// runPlugin runs a plugin in the foreground and passes on its exit status
+func runPlugin(path string, args []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+
+	plugin := exec.Command(path, args...)
+	plugin.Stdin = os.Stdin
+	plugin.Stdout = os.Stdout
+	plugin.Stderr = os.Stderr
+	plugin.Env = append(os.Environ(), sdk.EnvExecutable+"="+self, sdk.EnvVersion+"="+Version)
+	if err := plugin.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// Killed by a signal when the code is -1
+			return &ExitError{Code: max(exitErr.ExitCode(), 1)}
+		}
+		return fmt.Errorf("failed to run plugin %s: %w", path, err)
+	}
+	return nil
+}
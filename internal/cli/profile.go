@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	goruntime "runtime"
+	runtimepprof "runtime/pprof"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pprofAddr  string
+	cpuProfile string
+	memProfile string
+
+	cpuProfileFile *os.File
+)
+
+// addProfilingFlags adds the hidden flags for profiling long saves and loads
+// in the field
+func addProfilingFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&pprofAddr, "pprof", "", "Serve net/http/pprof on this address, e.g. :6060 or localhost:6060")
+	flags.StringVar(&cpuProfile, "cpuprofile", "", "Write a CPU profile of the whole run to this file")
+	flags.StringVar(&memProfile, "memprofile", "", "Write a heap profile to this file when the command ends")
+	flags.MarkHidden("pprof")
+	flags.MarkHidden("cpuprofile")
+	flags.MarkHidden("memprofile")
+}
+
+// startProfiling starts what the profiling flags ask for; stopProfiling
+// writes the profiles out, also when the run is interrupted
+func startProfiling() error {
+	if pprofAddr != "" {
+		listener, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return fmt.Errorf("--pprof: %w", err)
+		}
+		// Its own mux, so the handlers never show up on imgcd serve
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go http.Serve(listener, mux)
+		fmt.Fprintf(os.Stderr, "pprof: http://%s/debug/pprof/\n", listener.Addr())
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("--cpuprofile: %w", err)
+		}
+		if err := runtimepprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("--cpuprofile: %w", err)
+		}
+		cpuProfileFile = f
+	}
+
+	if cpuProfile != "" || memProfile != "" {
+		// Ctrl-C ends a run without returning from Execute; keep what was profiled
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			stopProfiling()
+			os.Exit(130)
+		}()
+	}
+	return nil
+}
+
+// stopProfiling ends the CPU profile and writes the heap profile
+func stopProfiling() {
+	if cpuProfileFile != nil {
+		runtimepprof.StopCPUProfile()
+		cpuProfileFile.Close()
+		cpuProfileFile = nil
+	}
+
+	if memProfile != "" {
+		f, err := os.Create(memProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--memprofile: %v\n", err)
+			return
+		}
+		defer f.Close()
+		goruntime.GC() // Up-to-date statistics of what is still live
+		if err := runtimepprof.WriteHeapProfile(f); err != nil {
+			fmt.Fprintf(os.Stderr, "--memprofile: %v\n", err)
+		}
+		memProfile = ""
+	}
+}
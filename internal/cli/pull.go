@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pullPlatform   string
+	pullSegments   int
+	pullSegmentMin string
+	pullMirrors    []string
+	pullPeers      []string
+	pullDiscover   bool
+	pullLimitRate  string
+)
+
+var pullCmd = &cobra.Command{
+	Use:   "pull <IMAGE>...",
+	Short: "Download the blobs of images into the cache without creating a bundle",
+	Long: `Download every blob of the given images from their registry into the blob
+cache (~/.imgcd/cache), without creating a bundle. Later saves of these images,
+or of other tags sharing their layers, then read the cache instead of the
+network.
+
+Blobs already in the cache are not downloaded again; pull reports how many
+blobs of each image were new and how many were cached already.
+
+On high-latency links, --segments downloads each blob of at least
+--segment-min-size as several ranged requests at once, each --mirror taking
+its share; save has the same flags. Segments are reassembled in the cache and
+the blob's digest is verified before it is used. Registries that do not
+answer ranged requests get the blob downloaded in one piece.
+
+With --peer or --discover-peers, blobs are first asked from other machines
+running imgcd serve, e.g. a teammate's, and only downloaded from the registry
+when no peer has them. Blobs from peers are checked against their digest.
+
+--limit-rate caps the rate of all registry downloads together, segments
+included, so a pull on a shared office link leaves room for everyone else;
+blobs from peers are on the local network and not limited. save has the same
+flag.
+
+Examples:
+  # Pre-warm the cache with the releases about to be bundled
+  imgcd pull ns/app:1.2.8 ns/app:1.2.9
+
+  # Pull the arm64 variant
+  imgcd pull alpine:3.20 --target-platform linux/arm64
+
+  # Fetch large layers in 8 parallel segments, split with a mirror
+  imgcd pull ns/model-server:3.0 --segments 8 --mirror mirror.corp.local:5000
+
+  # Share downloads with the imgcd serve instances on the local network
+  imgcd pull ns/app:1.2.9 --discover-peers
+
+  # Pre-warm the cache overnight without saturating the uplink
+  imgcd pull ns/app:1.2.9 --limit-rate 10MB/s`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runPull,
+}
+
+func init() {
+	pullCmd.Flags().StringVarP(&pullPlatform, "target-platform", "t", "linux/amd64", "Platform of the images to pull")
+	pullCmd.RegisterFlagCompletionFunc("target-platform", completeTargetPlatforms)
+	pullCmd.Flags().IntVar(&pullSegments, "segments", 1, "Download each large blob as this many ranged requests at once")
+	pullCmd.Flags().StringVar(&pullSegmentMin, "segment-min-size", "64M", "Smallest blob downloaded in segments")
+	pullCmd.Flags().StringArrayVar(&pullMirrors, "mirror", nil, "Registry mirroring the images' repositories to download segments from too (repeatable)")
+	pullCmd.Flags().StringArrayVar(&pullPeers, "peer", nil, "imgcd serve instance (URL or host:port) asked for blobs before the registry (repeatable)")
+	pullCmd.Flags().BoolVar(&pullDiscover, "discover-peers", false, "Ask the imgcd serve instances on the local network for blobs before the registry")
+	pullCmd.Flags().StringVar(&pullLimitRate, "limit-rate", "", "Maximum rate of all layer downloads together, per second (e.g., 10MB/s, 500K)")
+}
+
+func runPull(cmd *cobra.Command, args []string) error {
+	segments, err := parseSegmentOptions(pullSegments, pullSegmentMin, pullMirrors)
+	if err != nil {
+		return err
+	}
+	rateLimit, err := parseRate("limit-rate", pullLimitRate)
+	if err != nil {
+		return err
+	}
+	exporter, err := image.NewRemoteExporter(Version, true)
+	if err != nil {
+		return err
+	}
+	exporter.SetSegments(segments)
+	exporter.SetPeers(findPeers(cmd.Context(), pullPeers, pullDiscover))
+	exporter.SetRateLimit(rateLimit)
+
+	var total image.PullResult
+	for _, ref := range args {
+		fmt.Printf("Pulling %s...\n", ref)
+		result, err := exporter.Pull(cmd.Context(), ref, image.ImagePlatform(pullPlatform))
+		if err != nil {
+			return fmt.Errorf("failed to pull %s: %w", ref, err)
+		}
+		ui.Success("%s: %d blob(s), %d new (%s), %d already cached",
+			ref, result.Blobs, result.New, humanize.Size(result.NewBytes), result.Cached)
+		if result.Peers > 0 {
+			fmt.Printf("  %d of the new blob(s) from peers\n", result.Peers)
+		}
+
+		total.Blobs += result.Blobs
+		total.New += result.New
+		total.NewBytes += result.NewBytes
+		total.Cached += result.Cached
+	}
+
+	if len(args) > 1 {
+		fmt.Printf("\nTotal: %d blob(s), %d new (%s), %d already cached\n", total.Blobs, total.New, humanize.Size(total.NewBytes), total.Cached)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var pushChecksumKey string
+
+var pushCmd = &cobra.Command{
+	Use:   "push <BUNDLE> <REPOSITORY[:TAG]>",
+	Short: "Upload the image of a bundle to a registry",
+	Long: `Upload the image of a bundle to a registry instead of loading it into a
+container runtime, e.g. into the private registry of an offline environment.
+No container runtime is needed.
+
+The bundle's blobs are pushed with their manifest; blobs the registry already
+has are skipped. The bundle's tag is kept unless REPOSITORY names one.
+
+Incremental bundles do not contain the layers of their base image: push the
+base image (or its bundle) to the same repository first, and the registry
+supplies them. The image config is written from the bundle's metadata, so the
+manifest digest may differ from the one in the source registry; the layers
+are pushed unchanged. OCI artifact bundles are pushed with their original
+manifest and keep its digest.
+
+Examples:
+  # Push a bundle to the registry of the offline environment
+  imgcd push myapp-2.0__since-none.tar registry.local:5000/team/myapp
+
+  # Push an incremental bundle under another tag, once its base was pushed
+  imgcd push myapp-2.0__since-1.9.sh registry.local:5000/team/myapp:2.0-rc`,
+	Args: cobra.ExactArgs(2),
+	RunE: runPush,
+}
+
+func init() {
+	pushCmd.Flags().StringVar(&pushChecksumKey, "checksum-key", "", "Ed25519 public key (PEM) the .sha256.sig signature must verify against")
+}
+
+func runPush(cmd *cobra.Command, args []string) error {
+	bundlePath, target := args[0], args[1]
+
+	if err := verifyChecksums(os.Stdout, bundlePath, pushChecksumKey); err != nil {
+		return err
+	}
+
+	pushed, err := image.PushBundle(cmd.Context(), bundlePath, target, image.PushOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to push bundle: %w", err)
+	}
+
+	ui.Success("Successfully pushed: %s", pushed)
+	return nil
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// ExitPartialLoad is the exit code of load and apply when some images were
+// loaded and others failed
+const ExitPartialLoad = 3
+
+// finishLoadReport ends a load of one or more bundles: it prints the table
+// of every image's outcome if table is set, writes the JSON report to path
+// if given, and turns err, the load's failure if any, into the exit status.
+// Nothing loaded exits 1 with err; some images loaded exits ExitPartialLoad.
+func finishLoadReport(cmd *cobra.Command, report *image.LoadReport, path string, table bool, err error) error {
+	if table {
+		fmt.Println()
+		if werr := report.WriteTable(os.Stdout); werr != nil {
+			return werr
+		}
+		fmt.Println()
+	}
+	if path != "" {
+		if werr := report.WriteJSON(path); werr != nil {
+			return werr
+		}
+		fmt.Printf("Load report written to %s\n", path)
+	}
+	if err == nil || report.Count(image.StatusLoaded) == 0 {
+		return err
+	}
+
+	ui.Error(os.Stderr, err)
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	return &ExitError{Code: ExitPartialLoad}
+}
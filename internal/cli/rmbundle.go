@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/so2liu/imgcd/internal/cache"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rmBundleForce  bool
+	rmBundleDryRun bool
+	rmBundleDirs   []string
+	rmBundleKeep   bool
+)
+
+var rmBundleCmd = &cobra.Command{
+	Use:   "rm-bundle <BUNDLE>...",
+	Short: "Delete bundles unless later bundles of their chain depend on them",
+	Long: `Delete bundles together with their .sha256 and .sha256.sig files.
+
+An incremental bundle can only be loaded where its --since image exists, so
+deleting a bundle strands the bundles built on top of it (and the ones built
+on those). rm-bundle reads the metadata of the bundles next to the ones being
+removed, plus those in any --dir, and refuses to delete a bundle that others
+still depend on. A dependent is not stranded if another remaining bundle
+provides the same image.
+
+Afterwards, cached blobs of the deleted bundles that nothing else references
+are removed, as imgcd cache gc would (use --keep-cache to skip this).
+
+Examples:
+  # Delete an old full export
+  imgcd rm-bundle out/myapp-1.0__since-none.tar
+
+  # See what deleting a bundle would strand, including bundles on the archive share
+  imgcd rm-bundle out/myapp-1.9__since-1.8.tar --dir /mnt/archive --dry-run
+
+  # Delete a bundle and its dependents
+  imgcd rm-bundle out/myapp-1.9__since-1.8.tar out/myapp-2.0__since-1.9.tar`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runRmBundle,
+}
+
+func init() {
+	rmBundleCmd.Flags().BoolVar(&rmBundleForce, "force", false, "Delete even if other bundles depend on the ones being removed")
+	rmBundleCmd.Flags().BoolVar(&rmBundleDryRun, "dry-run", false, "Only report what would be deleted and which bundles would be stranded")
+	rmBundleCmd.Flags().StringArrayVar(&rmBundleDirs, "dir", nil, "Additional directory to search for dependent bundles (repeatable)")
+	rmBundleCmd.Flags().BoolVar(&rmBundleKeep, "keep-cache", false, "Do not remove cached blobs only the deleted bundles used")
+}
+
+func runRmBundle(cmd *cobra.Command, args []string) error {
+	removing := make(map[string]bool)
+	var targets []*applyItem
+	dirs := append([]string(nil), rmBundleDirs...)
+	for _, arg := range args {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return err
+		}
+		summary, err := image.ReadBundleSummary(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", arg, err)
+		}
+		if !removing[path] {
+			removing[path] = true
+			targets = append(targets, &applyItem{path: path, summary: summary})
+		}
+		dirs = append(dirs, filepath.Dir(path))
+	}
+
+	remaining, err := otherBundles(dirs, removing)
+	if err != nil {
+		return err
+	}
+
+	stranded := strandedBundles(targets, remaining)
+	if len(stranded) > 0 {
+		fmt.Printf("Bundles that depend on the ones being removed:\n")
+		for _, item := range stranded {
+			fmt.Printf("  - %s (%s, needs %s)\n", item.path, item.summary.ImageRef, item.summary.BaseRef)
+		}
+		if !rmBundleForce && !rmBundleDryRun {
+			return fmt.Errorf("refusing to strand %d bundle(s); remove them as well or use --force", len(stranded))
+		}
+	}
+
+	for _, target := range targets {
+		files := []string{target.path}
+		for _, sidecar := range []string{checksum.SidecarPath(target.path), checksum.SignaturePath(target.path)} {
+			if _, err := os.Stat(sidecar); err == nil {
+				files = append(files, sidecar)
+			}
+		}
+
+		for _, file := range files {
+			if rmBundleDryRun {
+				fmt.Printf("Would remove %s\n", file)
+				continue
+			}
+			if err := os.Remove(file); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", file, err)
+			}
+		}
+		if !rmBundleDryRun {
+			ui.Success("Removed %s (%s)", target.path, target.summary.ImageRef)
+		}
+	}
+
+	if rmBundleDryRun && len(stranded) > 0 && !rmBundleForce {
+		fmt.Printf("Without --force, rm-bundle would refuse to strand %d bundle(s)\n", len(stranded))
+	}
+
+	if rmBundleKeep || state.Disabled() {
+		return nil
+	}
+	return collectBundleBlobs(targets, remaining)
+}
+
+// collectBundleBlobs forgets deleted bundles and garbage-collects the cached
+// blobs that only they referenced
+func collectBundleBlobs(removed, remaining []*applyItem) error {
+	index, err := cache.NewBundleIndex()
+	if err != nil {
+		return fmt.Errorf("failed to open bundle index: %w", err)
+	}
+	if !rmBundleDryRun {
+		for _, item := range removed {
+			if err := index.Forget(item.path); err != nil {
+				return fmt.Errorf("failed to update bundle index: %w", err)
+			}
+		}
+	}
+
+	only := make(map[string]bool)
+	for _, item := range removed {
+		for _, digest := range item.summary.Layers {
+			only[digest] = true
+		}
+	}
+	if len(only) == 0 {
+		return nil
+	}
+
+	var paths []string
+	for _, item := range remaining {
+		paths = append(paths, item.path)
+	}
+	retained, err := retainedBundles(paths)
+	if err != nil {
+		return err
+	}
+	// A dry run has not deleted anything yet
+	var kept []*applyItem
+	for _, item := range retained {
+		if !slices.ContainsFunc(removed, func(r *applyItem) bool { return r.path == item.path }) {
+			kept = append(kept, item)
+		}
+	}
+
+	bc, err := cache.NewBlobCache(true)
+	if err != nil {
+		return fmt.Errorf("failed to initialize cache: %w", err)
+	}
+	roots := gcRoots(kept, defaultGCKeepDays)
+	roots.Only = only
+	result, err := bc.GC(roots, rmBundleDryRun)
+	if err != nil {
+		return fmt.Errorf("failed to collect cache garbage: %w", err)
+	}
+	if len(result.Removed) == 0 {
+		return nil
+	}
+	return reportGC(result, rmBundleDryRun)
+}
+
+// otherBundles reads the bundles in dirs that are not being removed; files
+// that are not readable bundles are skipped
+func otherBundles(dirs []string, removing map[string]bool) ([]*applyItem, error) {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, dir := range dirs {
+		if abs, err := filepath.Abs(dir); err == nil && !seen[abs] {
+			seen[abs] = true
+			unique = append(unique, abs)
+		}
+	}
+
+	paths, err := findBundles(unique)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*applyItem
+	for _, path := range paths {
+		if removing[path] {
+			continue
+		}
+		summary, err := image.ReadBundleSummary(path)
+		if err != nil {
+			continue
+		}
+		items = append(items, &applyItem{path: path, summary: summary})
+	}
+	return items, nil
+}
+
+// strandedBundles returns the remaining bundles whose --since image would no
+// longer be provided by any bundle, following the chain transitively
+func strandedBundles(removed, remaining []*applyItem) []*applyItem {
+	stranded := make(map[*applyItem]bool)
+	for {
+		provided := make(map[string]bool)
+		for _, item := range remaining {
+			if !stranded[item] {
+				provided[canonicalRef(item.summary.ImageRef)] = true
+			}
+		}
+		lost := make(map[string]bool)
+		for _, item := range removed {
+			lost[canonicalRef(item.summary.ImageRef)] = true
+		}
+		for item := range stranded {
+			lost[canonicalRef(item.summary.ImageRef)] = true
+		}
+
+		changed := false
+		for _, item := range remaining {
+			base := canonicalRef(item.summary.BaseRef)
+			if stranded[item] || base == "" || !lost[base] || provided[base] {
+				continue
+			}
+			stranded[item] = true
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+
+	var result []*applyItem
+	for _, item := range remaining {
+		if stranded[item] {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -1,30 +1,230 @@
 package cli
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/ci"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/logging"
+	"github.com/so2liu/imgcd/internal/manpage"
+	"github.com/so2liu/imgcd/internal/remote"
+	"github.com/so2liu/imgcd/internal/retry"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
 	"github.com/spf13/cobra"
 )
 
 // Version is set by main.go at runtime
 var Version = "dev"
 
+var (
+	noState    bool
+	stateDir   string
+	plain      bool
+	language   string
+	sizeUnits  string
+	ciMode     bool
+	ciSummary  string
+	traceHTTP  string
+	metaTTL    time.Duration
+	retries    int
+	retryDelay time.Duration
+	logLevel   string
+	logFormat  string
+)
+
+// rootManual is what the man page of imgcd explains beyond its flags about
+// all commands, too long for imgcd --help
+var rootManual = []manpage.Section{
+	{Title: "STATE", Text: `imgcd keeps its caches in ~/.imgcd. Use --state-dir (or IMGCD_STATE_DIR) to
+keep them elsewhere, or --no-state (IMGCD_NO_STATE=1) to run without writing
+to HOME at all, e.g. in read-only containers or as a user without a home
+directory. Under --no-state the layer cache and bundle reuse are off, the
+cache commands are unavailable, and downloaded imgcd binaries go to the temp
+directory.`},
+	{Title: "TEMPORARY FILES", Text: `Scratch files go to TMPDIR, with two exceptions: loads keep their work in
+~/.imgcd/loads so an interrupted load can resume, and local-mode saves stage
+theirs in the output directory when TMPDIR is unset and the system temp
+directory is on another filesystem.`},
+	{Title: "OUTPUT", Text: `Status lines start with ✓ or ✗, colored on terminals. NO_COLOR turns color
+off; --plain (IMGCD_PLAIN=1) prints "OK:" and "FAILED:" instead, without
+symbols or color, for logs and scripts. Messages follow --lang, or else the
+first of IMGCD_LANG, LC_ALL, LC_MESSAGES and LANG that is set; zh-CN has a
+translation, other languages get English.`},
+	{Title: "SIZES", Text: `Sizes are printed in binary units (1 MiB = 1024 KiB); --size-units decimal
+(IMGCD_SIZE_UNITS=decimal) prints kB, MB and GB of 1000 instead. Sizes given
+on the command line (--import-rate-limit, --limit-rate, cache prune
+--until-under) are always read as powers of 1024.`},
+	{Title: "CONFIGURATION", Text: `Defaults for common flags can be kept in ~/.imgcd/config.yaml; see imgcd
+config --help.`},
+	{Title: "CI", Text: `--ci (IMGCD_CI=1) is for pipelines: warnings and the final error become
+GitHub Actions annotations (::warning::, ::error::), or WARNING: and ERROR:
+lines elsewhere, and save reports its bundle as a notice with size and
+savings, a Markdown job summary ($GITHUB_STEP_SUMMARY, else
+imgcd-summary.md or --ci-summary) and step outputs ($GITHUB_OUTPUT, else
+imgcd.env as a GitLab dotenv report). The repository root is also a GitHub
+composite action running imgcd save this way.`},
+	{Title: "HTTP TRACE", Text: `--trace-http FILE (IMGCD_TRACE_HTTP) appends a line per HTTP request to
+FILE: method, URL without query, status, bytes sent and received, duration,
+the retry number of requests repeated after a failure, and the challenge of
+401 responses. Registry and token requests are included, so it shows what a
+proxy or intercepting middlebox does to them.`},
+	{Title: "METADATA CACHE", Text: `--metadata-ttl 15m (IMGCD_METADATA_TTL) keeps the manifests and configs
+fetched from registries in ~/.imgcd/cache/metadata and answers the same
+requests from there for 15 minutes, so running diff and save again and
+again against the same references over a slow link does not fetch them
+again. Within that time a tag keeps the digest it had when first fetched:
+use it while planning, not to pick up freshly pushed tags.`},
+	{Title: "RETRIES", Text: `Registry requests, layer downloads and the downloads of bundles and imgcd
+binaries are retried after timeouts, broken connections, 429 and 5xx
+responses: --retries times (default 3), waiting --retry-delay (default 1s)
+before the first retry and twice as long before each further one, or as
+long as a Retry-After header asks. --retries 0 fails on the first error.`},
+	{Title: "LOGGING", Text: `--log-level debug (IMGCD_LOG_LEVEL) logs what happens under the hood to
+stderr: registry fetches with their timings, cache hits, and the peers and
+segments blobs come from. The default, info, only logs the pulls imgcd serve
+answers; warn and error also keep warnings quiet. --log-format json
+(IMGCD_LOG_FORMAT) logs one JSON object per line for log pipelines, warnings
+and the final error included; status lines and results stay as they are.`},
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "imgcd",
 	Short: "A tool for incremental container image export/import",
 	Long: `imgcd is a CLI tool that allows you to export and import container images
 with support for incremental/differential exports. It helps reduce the size
 of image transfers in offline environments by only exporting changed layers.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := startProfiling(); err != nil {
+			return err
+		}
+		ci.Configure(ciMode, ciSummary)
+		if err := logging.Configure(ui.Stderr, logLevel, logFormat); err != nil {
+			return err
+		}
+		if err := ui.Configure(plain, language); err != nil {
+			return err
+		}
+		if err := humanize.Configure(sizeUnits); err != nil {
+			return err
+		}
+		if err := checkDeprecatedName(cmd); err != nil {
+			return err
+		}
+		if err := state.Configure(noState, stateDir); err != nil {
+			return err
+		}
+		if traceHTTP == "" {
+			traceHTTP = os.Getenv("IMGCD_TRACE_HTTP")
+		}
+		if err := retry.Configure(retries, retryDelay); err != nil {
+			return err
+		}
+		if err := configureProxies(cmd); err != nil {
+			return err
+		}
+		if traceHTTP != "" {
+			if err := remote.TraceHTTP(traceHTTP); err != nil {
+				return err
+			}
+		}
+		remote.RetryRequests()
+		if err := configureMetadataCache(cmd); err != nil {
+			return err
+		}
+		// The cache and config commands only manage state
+		if state.Disabled() && cmd.HasParent() && (cmd.Parent() == cacheCmd || cmd.Parent() == configCmd) {
+			return fmt.Errorf("imgcd %s %s: %w", cmd.Parent().Name(), cmd.Name(), state.ErrDisabled)
+		}
+		return applyConfig(cmd)
+	},
 }
 
 func Execute() error {
 	// Set version dynamically before execution
 	rootCmd.Version = Version
+	if path, args, ok := lookupPlugin(os.Args[1:]); ok {
+		return runPlugin(path, args)
+	}
+	defer stopProfiling()
 	return rootCmd.Execute()
 }
 
 func init() {
+	rootCmd.PersistentFlags().BoolVar(&noState, "no-state", false, "Do not read or write caches and indexes, for a read-only HOME (IMGCD_NO_STATE=1)")
+	rootCmd.PersistentFlags().StringVar(&stateDir, "state-dir", "", "Directory for caches and indexes (default $IMGCD_STATE_DIR, else ~/.imgcd)")
+	rootCmd.PersistentFlags().BoolVar(&plain, "plain", false, "Print status lines as OK: and FAILED: without symbols or color (IMGCD_PLAIN=1)")
+	rootCmd.PersistentFlags().StringVar(&language, "lang", "", "Language of messages, e.g. zh-CN (default $IMGCD_LANG, else the locale)")
+	rootCmd.PersistentFlags().BoolVar(&ciMode, "ci", false, "Report warnings, errors and results as CI annotations, a job summary and step outputs (IMGCD_CI=1)")
+	rootCmd.PersistentFlags().StringVar(&ciSummary, "ci-summary", "", "Markdown job summary file for --ci (default $GITHUB_STEP_SUMMARY, else imgcd-summary.md)")
+	rootCmd.PersistentFlags().StringVar(&traceHTTP, "trace-http", "", "Append a line per HTTP request (method, URL, status, bytes, duration, retries) to this file (IMGCD_TRACE_HTTP)")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", retry.DefaultPolicy.Attempts-1, "Retries of registry requests and downloads after timeouts, 429 and 5xx responses; 0 fails on the first error")
+	rootCmd.PersistentFlags().DurationVar(&retryDelay, "retry-delay", retry.DefaultPolicy.Delay, "Delay before the first retry, doubled for each further one unless Retry-After asks for longer")
+	rootCmd.PersistentFlags().DurationVar(&metaTTL, "metadata-ttl", 0, "Reuse manifests and configs fetched from registries within this long, e.g. 15m; tags keep their digest meanwhile (IMGCD_METADATA_TTL, default off)")
+	rootCmd.PersistentFlags().StringVar(&sizeUnits, "size-units", "", "Print sizes in binary (KiB, MiB) or decimal (kB, MB) units (default $IMGCD_SIZE_UNITS, else binary)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Log level of diagnostics on stderr: debug, info, warn or error (default $IMGCD_LOG_LEVEL, else info)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "Log format: text, or json for one JSON object per line (default $IMGCD_LOG_FORMAT, else text)")
+	addProfilingFlags(rootCmd)
+
 	rootCmd.AddCommand(saveCmd)
 	rootCmd.AddCommand(loadCmd)
+	rootCmd.AddCommand(pushCmd)
+	rootCmd.AddCommand(pullCmd)
+	rootCmd.AddCommand(copyCmd)
 	rootCmd.AddCommand(updateCmd)
 	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(layersCmd)
 	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(bundleCmd)
+	rootCmd.AddCommand(cpCmd)
+	rootCmd.AddCommand(attestCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(rmBundleCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(manCmd)
+	rootCmd.AddCommand(examplesCmd)
+	rootCmd.AddCommand(pluginCmd)
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(joinCmd)
+	rootCmd.AddCommand(convertCmd)
+	rootCmd.AddCommand(metadataCmd)
+	rootCmd.AddCommand(selftestCmd)
+}
+
+// configureMetadataCache turns on the registry metadata cache for
+// --metadata-ttl or IMGCD_METADATA_TTL
+func configureMetadataCache(cmd *cobra.Command) error {
+	if !cmd.Flags().Changed("metadata-ttl") {
+		if env := os.Getenv("IMGCD_METADATA_TTL"); env != "" {
+			ttl, err := time.ParseDuration(env)
+			if err != nil {
+				return fmt.Errorf("invalid IMGCD_METADATA_TTL %q: %w", env, err)
+			}
+			metaTTL = ttl
+		}
+	}
+	if metaTTL <= 0 {
+		return nil
+	}
+	dir, err := state.Dir()
+	if err != nil {
+		return fmt.Errorf("--metadata-ttl: %w", err)
+	}
+	return remote.CacheMetadata(filepath.Join(dir, "cache", "metadata"), metaTTL)
+}
+
+// ExitError reports a non-zero exit status that is not a failure,
+// e.g. a result signalled to scripts through the exit code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
 }
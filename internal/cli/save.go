@@ -1,11 +1,24 @@
 package cli
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/destination"
+	"github.com/so2liu/imgcd/internal/humanize"
 	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/provenance"
+	"github.com/so2liu/imgcd/internal/registry"
+	"github.com/so2liu/imgcd/internal/remote"
+	"github.com/so2liu/imgcd/internal/ui"
 	"github.com/spf13/cobra"
 )
 
@@ -13,12 +26,48 @@ var (
 	sinceRef       string
 	outDir         string
 	targetPlatform string
+	allPlatforms   bool
 	forceLocal     bool
 	noCache        bool
+	saveNote       string
+	saveAnnotation []string
+	saveExpires    string
+	noProvenance   bool
+	provStatement  bool
+	saveSBOM       string
+	generateSBOM   bool
+	provOperator   string
+	provGitCommit  string
+	forceRebuild   bool
+	ifChanged      bool
+	selfExtracting bool
+	strictPlatform bool
+	signKey        string
+	bundleSignKey  string
+	saveChecksums  []string
+	saveTo         []string
+	saveAttach     []string
+	saveOnLoad     []string
+	saveSplitSize  string
+	saveSegments   int
+	saveSegmentMin string
+	saveMirrors    []string
+	savePeers      []string
+	saveDiscover   bool
+	saveLimitRate  string
+	saveOutputFmt  string
+	saveOfficial   bool
+	saveOffBase    string
+	saveSinceBndl  string
+	saveDryRun     bool
+	saveSinceLyrs  string
 )
 
+// ExitBundleCreated is the exit code of "save --if-changed" when a new bundle was written
+const ExitBundleCreated = 10
+
 var saveCmd = &cobra.Command{
-	Use:   "save <IMAGE_REF>",
+	Use:   "save <IMAGE_REF>...",
 	Short: "Export a container image to a self-extracting bundle",
 	Long: `Export a container image to a self-extracting bundle.
 
@@ -37,6 +86,31 @@ Export Modes (automatic selection):
 The --since flag supports two formats:
   • Full reference: alpine:3.19, myrepo/app:1.0.0
   • Short form (tag only): 3.19, 1.0.0 (uses same repository as main image)
+  • auto-local: compare the images of the same repository in the local
+    docker or containerd with the image and use the one that leaves the
+    smallest bundle, or save a full bundle when none shares its layers
+  • A bundle file: the same as --since-bundle
+
+--since-bundle takes the base from a bundle delivered before instead: the
+image it holds is what the target has, and its metadata lists that image's
+layers, so the old image need not be in any registry or runtime to compute
+the next increment. A bundle of several images gives the image of the same
+repository, in the variant for --target-platform.
+
+--since-layers takes the base from a layer list instead, written on the
+target by imgcd layers <BASE> -o FILE (docker inspect output and a plain
+list of DiffIDs work too): the layers the image shares with it are left
+out, and load takes them from the target's copy. Only the layers are known,
+so --since names the base if the list does not.
+
+--target-platform is the platform of the host the bundle is for. Container
+images are linux everywhere: for darwin/amd64 and darwin/arm64 (Docker
+Desktop and other macOS runtimes run linux images in a VM) the bundle holds
+the linux images of the same architecture and an imgcd built for macOS.
+
+The base is taken in the platform the image really is, which for
+single-platform images may differ from --target-platform. A base that is only available for another
+platform is an error, since it would share no layers with the image.
 
 Examples:
   # Export alpine (automatically uses remote mode for registry images)
@@ -50,46 +124,437 @@ Examples:
   imgcd save alpine:3.20 --since 3.19
   # Output: alpine-3.20__since-3.19.sh
 
+  # Show what an incremental export would download, without downloading
+  imgcd save alpine:3.20 --since 3.19 --dry-run
+
+  # Let imgcd pick the base among the local images of ns/app
+  imgcd save ns/app:2.1 --since auto-local
+
+  # Only what the last delivery lacks, without its image at hand
+  imgcd save app:2.0 --since-bundle out/app-1.9__since-1.8.sh
+
+  # Only what the target lacks, as it reported its image
+  imgcd save app:2.0 --since-layers layers.json
+
   # Specify target platform
   imgcd save myapp:2.0 --target-platform linux/arm64
+
+  # For a Mac with Docker Desktop: linux/arm64 images, imgcd for darwin/arm64
   imgcd save myapp:2.0 -t darwin/arm64
 
   # Force local mode (use container runtime)
   imgcd save myapp:dev --local
 
   # Export to custom directory
-  imgcd save ns/app:2.0.0 --out-dir /tmp/bundles`,
-	Args: cobra.ExactArgs(1),
+  imgcd save ns/app:2.0.0 --out-dir /tmp/bundles
+
+  # Create a shell script that unpacks and loads itself on the target
+  imgcd save ns/app:2.0.0 --self-extracting
+
+  # Attach a note and annotations for the receiving side
+  imgcd save myapp:2.0.1 --since 2.0.0 --note "hotfix for CVE-2024-1234" \
+    --annotation ticket=OPS-42 --annotation approved-by=alice
+
+  # Scheduled exports reuse the previous bundle when nothing changed;
+  # use --force to recreate it anyway
+  imgcd save myapp:2.0 --since 1.9 --force
+
+  # In cron jobs: exit 0 without output when nothing changed,
+  # exit 10 when a new bundle was created
+  imgcd save myapp:latest --since 2.0 --if-changed
+
+  # Mark the bundle as stale after 30 days
+  imgcd save myapp:2.0 --expires 30d
+
+  # Record the pipeline commit and add an in-toto provenance statement
+  imgcd save myapp:2.0 --git-commit "$(git rev-parse HEAD)" --provenance-statement
+
+  # Store the image's SBOM for audits on the target (imgcd inspect --sbom)
+  imgcd save myapp:2.0 --sbom myapp-2.0.spdx.json
+  imgcd save myapp:2.0 --generate-sbom
+
+  # Also write the bundle to a share and a remote host while it is created
+  imgcd save myapp:2.0 --to /mnt/transfer --to ssh://deploy@bastion/srv/bundles
+
+  # Produce a ready-to-burn ISO for optical media transfers
+  imgcd save myapp:2.0 --to iso:./delivery.iso
+
+  # Ship the compose file and install script along with the image
+  imgcd save myapp:2.0 --attach docker-compose.yml --attach ./scripts
+
+  # A one-shot offline deployment: the receiver runs
+  # imgcd load --from <bundle> --extras-dir ./deploy --run-post-load
+  imgcd save myapp:2.0 --attach docker-compose.yml \
+    --on-load "docker compose -f docker-compose.yml up -d"
+
+  # Sign the checksum file (key from: openssl genpkey -algorithm ed25519)
+  imgcd save myapp:2.0 --checksum-sign-key release.pem
+
+  # Embed a signature that travels with the bundle; load --key checks it
+  imgcd save myapp:2.0 --sign-key release.pem
+
+  # Also record sha512 digests of the layers for a site that requires them
+  imgcd save myapp:2.0 --checksum sha512
+
+  # Bundle an arm64 imgcd built elsewhere for arm64 hosts
+  IMGCD_BINARY_PATH=dist/imgcd-linux-arm64 imgcd save myapp:2.0 -t linux/arm64
+
+  # Ship a whole stack in one bundle; load imports all three images
+  imgcd save app:1.0 db:14 nginx:1.25
+  # Output: app-1.0+2more__since-none.tar
+
+  # One bundle for a mixed amd64/arm64 fleet
+  imgcd save myapp:2.0 --target-platform linux/amd64,linux/arm64
+  # Output: myapp-2.0+2platforms__since-none.tar
+
+  # Every platform the image is published for
+  imgcd save myapp:2.0 --all-platforms
+
+  # Parts that fit on FAT32 USB sticks; imgcd join reassembles them
+  imgcd save myapp:2.0 --split-size 4G
+
+  # Fetch large layers over a slow link in 8 ranged requests at once
+  imgcd save ns/model-server:3.0 --segments 8 --mirror mirror.corp.local:5000
+
+  # Leave room for everyone else on a shared office link
+  imgcd save myapp:2.0 --limit-rate 10MB/s
+
+  # Take layers a teammate already downloaded from their imgcd serve
+  imgcd save myapp:2.0 --discover-peers
+
+  # Stream the bundle to another host without writing it locally
+  imgcd save myapp:2.0 --since 1.9 --self-extracting -o - | ssh target 'cat > myapp.sh'
+
+  # Images a skopeo pipeline copied to directories, without a registry
+  imgcd save dir:./app-2.0:myapp:2.0 --since dir:./app-1.0:myapp:1.0
+
+  # For scripts: the bundle path, size, sha256 and layer digests as JSON
+  imgcd save myapp:2.0 --since 1.9 --output json | jq -r .bundle
+
+Several images:
+  With more than one image, all of them go into one bundle, named after the
+  first. Layers shared between the images are downloaded and stored once.
+  Such bundles are full exports from the registry: --since and --local are
+  not supported, and neither are OCI artifacts.
+
+Several platforms:
+  A comma-separated --target-platform, or --all-platforms for every platform
+  of the image's manifest list, saves each image once per platform into one
+  bundle, storing layers the platforms share once. load gives every target
+  the variant of its own platform. The bundled imgcd binary is the one for
+  the first --target-platform. Like bundles of several images, these are
+  full exports from the registry.
+
+Streaming:
+  -o - writes the bundle to stdout, e.g. into ssh or an object-store
+  uploader, while progress goes to stderr. The bundle is produced as it is
+  written: only the image data it is built from touches the disk, in TMPDIR.
+  No .sha256 file is written; its sha256 is printed instead. --to,
+  --split-size and --checksum-sign-key need a bundle file and cannot be used.
+
+Skopeo directories:
+  dir:PATH:NAME reads the image skopeo copy wrote to PATH with its dir:
+  transport instead of pulling it; NAME is the image reference the bundle
+  records. It works for --since as well, and both can be mixed with registry
+  images. Directories holding a manifest list (skopeo copy --all) give the
+  --target-platform image. imgcd convert --to dir writes such directories.
+
+OCI artifacts:
+  Helm charts, WASM modules and files pushed with ORAS are exported like
+  images: blobs, media types and the manifest are kept as they are. Load them
+  with imgcd load --push or --oci-layout. --since is not supported for them.
+
+Provenance:
+  Bundles record the producing user, host, git commit and CI run URL.
+  These are detected from the environment (IMGCD_OPERATOR, GITHUB_*, GitLab
+  CI_* and Jenkins variables, or the git checkout in the working directory).
+  Use --no-provenance to omit them.
+
+SBOM:
+  --sbom stores an SPDX or CycloneDX JSON SBOM (e.g. from syft or trivy) in
+  the bundle as sbom.spdx.json or sbom.cdx.json; --generate-sbom runs syft on
+  the image instead (syft must be in PATH). imgcd inspect --sbom extracts it.
+
+Checksums:
+  Every bundle gets a <bundle>.sha256 file (sha256sum -c compatible) that
+  imgcd load checks automatically. With --checksum-sign-key, the checksum file
+  is also signed into <bundle>.sha256.sig. --sign-key instead embeds the
+  signature into the bundle itself, as a signature.json entry after the imgcd
+  binary and image data that lists their sha256: it survives streaming (-o -),
+  .sh wrapping and copies that drop the sidecars. Bundles of other tools and
+  bare image.tar.gz files carry none.
+
+Bundled binary:
+  Bundles carry an imgcd binary for the target platform (-t): a release
+  build downloads the matching release, a dev build or IMGCD_BINARY_PATH
+  uses the given file. save reads the platform of that file and refuses one
+  that cannot run on the target; --strict-platform=false (the default of dev
+  builds) only warns. The binary's platform is recorded in the bundle, and
+  load warns when it is not the platform of the loading host.
+
+Destinations:
+  --to can be repeated to write the bundle to more places while it is
+  created, with no copy after the export: a directory (or dir:PATH),
+  ssh://[user@]host[:port]/path or s3://bucket/prefix (via the aws CLI,
+  which streams at most 50 GB). iso:PATH.iso writes an ISO image with the
+  files, a MANIFEST.txt, SHA256SUMS and a verify.sh script (needs xorriso,
+  genisoimage, mkisofs or hdiutil); imgcd cp BUNDLE... iso:PATH.iso packs
+  several bundles into one. With --split-size the parts are written
+  as they are split. http(s)://host/path (HTTP PUT) cannot stream, as an
+  upload needs its size: it gets a copy of the finished bundle, as does
+  every destination when an earlier bundle is reused. The checksum files
+  follow the bundle. Destinations only see a bundle under its name once it
+  is complete and checked; a failing destination does not stop the others,
+  and the local bundle in --out-dir is always kept.
+
+Splitting:
+  --split-size writes the bundle as <bundle>.part1, .part2, ... of at most
+  that size, for media with a file size limit such as FAT32 USB sticks
+  (--split-size 4G is taken as FAT32's 4 GiB minus one byte). The parts'
+  checksums go to <bundle>.parts.sha256. imgcd join, or cat on hosts without
+  imgcd, puts the bundle back together.
+
+Official base images:
+  --check-official compares every image with the official image it was
+  built from once the bundle is written: its bottom layers must be exactly
+  those the registry publishes for that image, named by the image's
+  org.opencontainers.image.base.name label, the --since image, or
+  --official-base. A mismatch means the base was tampered with or is not
+  the image it claims to be: save fails before splitting or delivering the
+  bundle, which is kept in --out-dir for inspection. imgcd verify
+  --check-official repeats the check on the receiving side. It cannot be
+  used with -o -.
+
+Peer caches:
+  Blobs can come from the cache of another machine running imgcd serve
+  instead of the internet: name it with --peer, or let --discover-peers find
+  the instances on the local network (config key peers.discover). Each blob
+  is asked from the peers first and checked against its digest; a peer that
+  lacks it or fails leaves the download to the registry. Peers need the blob
+  cache, so --no-cache does not use them.
+
+Dry run:
+  --dry-run fetches the manifests and configs, computes the layers the base
+  provides and looks up the blob cache, then prints which layers would be
+  downloaded, the bundle's file name and its estimated size. No blob is
+  downloaded and nothing is written, not even --out-dir. It plans one image
+  for one platform; in local mode the runtime lists the layers without
+  pulling, so their sizes are unknown.
+
+JSON output:
+  --output json prints one JSON document on stdout when save ends, and
+  everything else on stderr: the status (created, up_to_date, unchanged,
+  dry_run with the plan, or failed, with the error), the bundle, its checksum file, sha256 and size,
+  the image, base and platform, layer counts and the digest of every layer,
+  how many blobs came from the cache and from peers, and the duration. It
+  cannot be used with -o -.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: runSave,
 }
 
 func init() {
-	saveCmd.Flags().StringVar(&sinceRef, "since", "", "Base image reference or tag (e.g., 'alpine:3.19' or just '3.19')")
-	saveCmd.Flags().StringVarP(&outDir, "out-dir", "o", "./out", "Output directory for the exported file")
-	saveCmd.Flags().StringVarP(&targetPlatform, "target-platform", "t", "linux/amd64", "Target platform (linux/amd64, linux/arm64, darwin/amd64, darwin/arm64)")
+	saveCmd.Flags().StringVar(&sinceRef, "since", "", "Base image reference or tag (e.g., 'alpine:3.19' or just '3.19'), or auto-local to pick one among local images")
+	saveCmd.Flags().StringVar(&saveSinceBndl, "since-bundle", "", "Earlier bundle whose image is the base, read from its metadata instead of a registry or runtime")
+	saveCmd.Flags().StringVar(&saveSinceLyrs, "since-layers", "", "Layer list of the base image the target has (imgcd layers -o FILE); --since may name that image")
+	saveCmd.Flags().StringVarP(&outDir, "out-dir", "o", "./out", "Output directory for the exported file, or - to stream the bundle to stdout")
+	saveCmd.Flags().StringVarP(&targetPlatform, "target-platform", "t", "linux/amd64", "Platform of the target host (linux/amd64, linux/arm64, darwin/amd64, darwin/arm64; darwin gets linux images); comma-separated to save several into one bundle")
+	saveCmd.RegisterFlagCompletionFunc("target-platform", completeTargetPlatforms)
+	saveCmd.Flags().BoolVar(&allPlatforms, "all-platforms", false, "Save every platform of the image's manifest list into one bundle")
 	saveCmd.Flags().BoolVar(&forceLocal, "local", false, "Force using local container runtime instead of downloading directly from registry")
 	saveCmd.Flags().BoolVar(&noCache, "no-cache", false, "Disable layer caching (always download from registry)")
+	saveCmd.Flags().BoolVar(&selfExtracting, "self-extracting", false, "Create a self-extracting shell script (.sh) instead of a tar bundle")
+	saveCmd.Flags().BoolVar(&strictPlatform, "strict-platform", true, "Refuse to bundle an imgcd binary that cannot run on the target platform (default off in dev builds)")
+	saveCmd.Flags().BoolVar(&ifChanged, "if-changed", false, fmt.Sprintf("Create nothing and exit 0 when the image has no changes since --since; exit %d when a bundle was created", ExitBundleCreated))
+	saveCmd.Flags().BoolVar(&forceRebuild, "force", false, "Recreate the bundle even if an identical one from a previous run is up to date")
+	saveCmd.Flags().StringVar(&saveNote, "note", "", "Free-form note stored in the bundle metadata")
+	saveCmd.Flags().StringVar(&saveExpires, "expires", "", "Validity window after which load warns the bundle is stale (e.g., 30d, 2w, 12h)")
+	saveCmd.Flags().StringArrayVar(&saveAnnotation, "annotation", nil, "Annotation stored in the bundle metadata as key=value (repeatable)")
+	saveCmd.Flags().BoolVar(&noProvenance, "no-provenance", false, "Do not record producer identity (user, host, git commit, pipeline) in the bundle")
+	saveCmd.Flags().BoolVar(&provStatement, "provenance-statement", false, "Store an in-toto provenance statement in the bundle")
+	saveCmd.Flags().StringVar(&saveSBOM, "sbom", "", "SPDX or CycloneDX JSON SBOM stored in the bundle, extracted by inspect --sbom")
+	saveCmd.Flags().BoolVar(&generateSBOM, "generate-sbom", false, "Generate an SPDX SBOM of the image with syft and store it in the bundle")
+	saveCmd.MarkFlagsMutuallyExclusive("sbom", "generate-sbom")
+	saveCmd.Flags().StringVar(&provOperator, "operator", "", "Operator name recorded as producer (default: IMGCD_OPERATOR or current user)")
+	saveCmd.Flags().StringVar(&provGitCommit, "git-commit", "", "Source commit recorded as provenance (default: detected from CI or git)")
+	saveCmd.Flags().StringArrayVar(&saveTo, "to", nil, "Also write the bundle to a directory, ssh://, s3:// or iso: destination while it is created, or copy it to http(s):// once complete (repeatable)")
+	saveCmd.Flags().StringArrayVar(&saveAttach, "attach", nil, "File or directory stored under extras/ in the bundle, extracted by load --extras-dir (repeatable)")
+	saveCmd.Flags().StringArrayVar(&saveOnLoad, "on-load", nil, "Shell command recorded in the bundle for load --run-post-load to run after importing (repeatable)")
+	saveCmd.Flags().StringVar(&signKey, "checksum-sign-key", "", "Ed25519 private key (PEM) used to sign the .sha256 file into .sha256.sig")
+	saveCmd.Flags().StringVar(&bundleSignKey, "sign-key", "", "Ed25519 private key (PEM) used to embed a signature into the bundle, checked by load --key")
+	saveCmd.Flags().StringSliceVar(&saveChecksums, "checksum", nil, "Further digest algorithm recorded for every layer and checked by load and verify: sha512 (remote mode bundles)")
+	saveCmd.RegisterFlagCompletionFunc("checksum", completeChecksumAlgorithms)
+	saveCmd.Flags().StringVar(&saveSplitSize, "split-size", "", "Split the bundle into parts of at most this size (e.g. 4G for FAT32)")
+	saveCmd.Flags().IntVar(&saveSegments, "segments", 1, "Download each large blob as this many ranged requests at once")
+	saveCmd.Flags().StringVar(&saveSegmentMin, "segment-min-size", "64M", "Smallest blob downloaded in segments")
+	saveCmd.Flags().StringArrayVar(&saveMirrors, "mirror", nil, "Registry mirroring the image's repository to download segments from too (repeatable)")
+	saveCmd.Flags().StringArrayVar(&savePeers, "peer", nil, "imgcd serve instance (URL or host:port) asked for blobs before the registry (repeatable)")
+	saveCmd.Flags().BoolVar(&saveDiscover, "discover-peers", false, "Ask the imgcd serve instances on the local network for blobs before the registry")
+	saveCmd.Flags().StringVar(&saveLimitRate, "limit-rate", "", "Maximum rate of all layer downloads together, per second (e.g., 10MB/s, 500K)")
+	saveCmd.Flags().BoolVar(&saveOfficial, "check-official", false, "Fail unless the images are built on the unmodified layers of their official base image")
+	saveCmd.Flags().StringVar(&saveOffBase, "official-base", "", "Official image --check-official compares with (default: the one the image names, or --since)")
+	saveCmd.Flags().BoolVar(&saveDryRun, "dry-run", false, "Print the layers that would be downloaded, the bundle's name and estimated size, without downloading or writing anything")
+	saveCmd.Flags().StringVar(&saveOutputFmt, "output", outputText, "Output format: text, or json for one JSON document on stdout with the rest on stderr")
+	saveCmd.RegisterFlagCompletionFunc("output", completeOutputFormats)
 }
 
 func runSave(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(saveOutputFmt); err != nil {
+		return err
+	}
+	doc := &saveOutput{Image: args[0]}
+	if saveOutputFmt == outputText {
+		return save(cmd, args, doc)
+	}
+	if outDir == "-" {
+		return fmt.Errorf("--output json cannot be used with -o -, which writes the bundle to stdout")
+	}
+	return runWithJSONOutput(func() error { return save(cmd, args, doc) }, doc.finish)
+}
+
+// save runs save, filling in doc for --output json
+func save(cmd *cobra.Command, args []string, doc *saveOutput) error {
 	newRef := args[0]
 
-	// Ensure output directory exists
-	if err := os.MkdirAll(outDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	// -o - streams the bundle to stdout; what save prints goes to stderr
+	// instead, and the files the bundle is built from to a scratch directory
+	var stream *os.File
+	if outDir == "-" {
+		switch {
+		case len(saveTo) > 0:
+			return fmt.Errorf("--to cannot be used with -o -")
+		case saveSplitSize != "":
+			return fmt.Errorf("--split-size cannot be used with -o -")
+		case signKey != "":
+			return fmt.Errorf("--checksum-sign-key cannot be used with -o -, which writes no .sha256 file")
+		case saveOfficial:
+			return fmt.Errorf("--check-official cannot be used with -o -; check the bundle with imgcd verify --check-official")
+		case saveDryRun:
+			return fmt.Errorf("--dry-run cannot be used with -o -")
+		}
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("refusing to write a bundle to a terminal; redirect or pipe stdout")
+		}
+		scratch, err := os.MkdirTemp("", "imgcd-save-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer os.RemoveAll(scratch)
+		stream, os.Stdout = os.Stdout, os.Stderr
+		defer func() { os.Stdout = stream }()
+		outDir = scratch
+	}
+
+	// Dev builds bundle whatever binary they are given unless asked not to
+	if Version == "dev" && !cmd.Flags().Changed("strict-platform") {
+		strictPlatform = false
+	}
+
+	// Ensure output directory exists; a dry run writes nothing
+	if !saveDryRun {
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	// Validate target platforms; the first one also selects the bundled imgcd binary
+	platforms, err := parseTargetPlatforms(targetPlatform)
+	if err != nil {
+		return err
+	}
+	if allPlatforms && len(platforms) > 1 {
+		return fmt.Errorf("--all-platforms cannot be used with several --target-platform values")
+	}
+	imagePlatforms := imagePlatformsOf(platforms)
+
+	// Parse annotations
+	annotations, err := parseAnnotations(saveAnnotation)
+	if err != nil {
+		return err
+	}
+
+	// Parse expiry window
+	var expiresAt time.Time
+	if saveExpires != "" {
+		validity, err := humanize.ParseDuration(saveExpires)
+		if err != nil {
+			return fmt.Errorf("invalid --expires value: %w", err)
+		}
+		expiresAt = time.Now().Add(validity)
+	}
+
+	if noProvenance && provStatement {
+		return fmt.Errorf("--provenance-statement cannot be used with --no-provenance")
+	}
+	if generateSBOM && (len(args) > 1 || strings.Contains(targetPlatform, ",") || allPlatforms) {
+		return fmt.Errorf("--generate-sbom covers one image and platform; pass an SBOM of all of them with --sbom")
+	}
+
+	// Fail before exporting if the signing key is unusable
+	if signKey != "" {
+		if err := checksum.CheckSigningKey(signKey); err != nil {
+			return fmt.Errorf("invalid --checksum-sign-key: %w", err)
+		}
+	}
+	if bundleSignKey != "" {
+		if err := checksum.CheckSigningKey(bundleSignKey); err != nil {
+			return fmt.Errorf("invalid --sign-key: %w", err)
+		}
 	}
 
-	// Validate target platform
-	validPlatforms := []string{"linux/amd64", "linux/arm64", "darwin/amd64", "darwin/arm64"}
-	valid := false
-	for _, p := range validPlatforms {
-		if p == targetPlatform {
-			valid = true
-			break
+	var splitSize int64
+	if saveSplitSize != "" {
+		splitSize, err = humanize.ParseSize(saveSplitSize)
+		if err != nil {
+			return fmt.Errorf("invalid --split-size: %w", err)
 		}
 	}
-	if !valid {
-		return fmt.Errorf("invalid target platform: %s (valid options: %v)", targetPlatform, validPlatforms)
+
+	segments, err := parseSegmentOptions(saveSegments, saveSegmentMin, saveMirrors)
+	if err != nil {
+		return err
+	}
+	rateLimit, err := parseRate("limit-rate", saveLimitRate)
+	if err != nil {
+		return err
+	}
+
+	for _, algorithm := range saveChecksums {
+		if _, err := bundle.NewHash(algorithm); err != nil {
+			return fmt.Errorf("invalid --checksum: %w", err)
+		}
+	}
+
+	// Fail before exporting if an attachment is missing or ambiguous
+	attachNames := make(map[string]string)
+	for _, attachment := range saveAttach {
+		if _, err := os.Stat(attachment); err != nil {
+			return fmt.Errorf("invalid --attach: %w", err)
+		}
+		base := filepath.Base(filepath.Clean(attachment))
+		if other, ok := attachNames[base]; ok {
+			return fmt.Errorf("--attach %s and %s would both be stored as extras/%s", other, attachment, base)
+		}
+		attachNames[base] = attachment
+	}
+
+	// Parse delivery destinations
+	var dests []destination.Destination
+	for _, spec := range saveTo {
+		dest, err := destination.Parse(spec)
+		if err != nil {
+			return err
+		}
+		dests = append(dests, dest)
+	}
+
+	// Collect producer identity
+	var prov *bundle.Provenance
+	if !noProvenance {
+		prov = provenance.Collect(cmd.Context(), provenance.Options{
+			User:      provOperator,
+			GitCommit: provGitCommit,
+			Version:   Version,
+		})
 	}
 
 	// Create exporter
@@ -99,22 +564,296 @@ func runSave(cmd *cobra.Command, args []string) error {
 	}
 	defer exporter.Close()
 
+	// A bundle file given to --since is the same as --since-bundle
+	since, sinceBundle := sinceRef, saveSinceBndl
+	if info, err := os.Stat(since); sinceBundle == "" && err == nil && info.Mode().IsRegular() {
+		since, sinceBundle = "", since
+	}
+
+	if since == image.AutoLocalSince && saveSinceLyrs != "" {
+		return fmt.Errorf("--since %s cannot be used with --since-layers, which gives the base", image.AutoLocalSince)
+	}
+
+	// Pick the base among local images; bundles of several images or
+	// platforms take no base, which Export reports
+	if since == image.AutoLocalSince && len(args) == 1 && len(imagePlatforms) == 1 && !allPlatforms {
+		since, err = exporter.ChooseLocalBase(cmd.Context(), newRef, imagePlatforms[0])
+		if err != nil {
+			return err
+		}
+	}
+
 	// Export image
 	opts := image.ExportOptions{
-		TargetPlatform: targetPlatform,
+		TargetPlatform: imagePlatforms[0],
+		BinaryPlatform: platforms[0],
 		ForceLocal:     forceLocal,
 		UseCache:       !noCache, // Cache enabled by default
+		Rebuild:        forceRebuild,
+		SkipUnchanged:  ifChanged,
+		SelfExtracting: selfExtracting,
+		StrictPlatform: strictPlatform,
+		ExpiresAt:      expiresAt,
+		Note:           saveNote,
+		Annotations:    annotations,
+
+		Provenance:          prov,
+		ProvenanceStatement: provStatement,
+
+		SBOM:         saveSBOM,
+		GenerateSBOM: generateSBOM,
+
+		ChecksumSignKey: signKey,
+		SignKey:         bundleSignKey,
+		Checksums:       saveChecksums,
+
+		Attachments: saveAttach,
+		OnLoad:      saveOnLoad,
+
+		SinceBundle: sinceBundle,
+		SinceLayers: saveSinceLyrs,
+
+		MoreImages:    args[1:],
+		MorePlatforms: imagePlatforms[1:],
+		AllPlatforms:  allPlatforms,
+
+		Segments:  segments,
+		Peers:     findPeers(cmd.Context(), savePeers, saveDiscover),
+		RateLimit: rateLimit,
 	}
-	outputPath, err := exporter.Export(cmd.Context(), newRef, sinceRef, outDir, opts)
+	if stream != nil {
+		opts.Stream = stream
+	}
+
+	// Destinations receive the bundle while it is written, or the parts
+	// while it is split; what was streamed is discarded if save fails
+	var delivery *destination.Stream
+	var tee func(name string) io.Writer
+	if len(dests) > 0 && !saveDryRun {
+		delivery = destination.NewStream(cmd.Context(), dests)
+		defer delivery.Abort()
+		tee = delivery.Open
+		if splitSize == 0 {
+			opts.Tee = tee
+		}
+	}
+	if saveDryRun {
+		plan, err := exporter.Plan(cmd.Context(), newRef, since, outDir, opts)
+		if err != nil {
+			return fmt.Errorf("failed to plan export: %w", err)
+		}
+		doc.recordPlan(plan)
+		printPlan(plan)
+		return nil
+	}
+	result, err := exporter.Export(cmd.Context(), newRef, since, outDir, opts)
 	if err != nil {
 		return fmt.Errorf("failed to export image: %w", err)
 	}
 
-	absPath, _ := filepath.Abs(outputPath)
-	fmt.Printf("✓ Successfully created bundle: %s\n", absPath)
-	fmt.Printf("\nTo import on target system (%s):\n", targetPlatform)
-	fmt.Printf("  tar xf %s\n", filepath.Base(absPath))
-	fmt.Printf("  ./imgcd load --from image.tar.gz\n")
+	if result.Unchanged {
+		if sinceBundle != "" {
+			since = filepath.Base(sinceBundle)
+		} else if saveSinceLyrs != "" {
+			since = filepath.Base(saveSinceLyrs)
+		}
+		doc.Status, doc.Base = "unchanged", since
+		ui.Success("No changes since %s, no bundle created", since)
+		reportUnchanged(newRef, since)
+		return nil
+	}
+
+	if result.Streamed {
+		name := filepath.Base(result.Path)
+		ui.Success("Streamed bundle %s to stdout (%s)", name, humanize.Size(result.Size))
+		fmt.Printf("  sha256: %s\n", result.Checksum)
+		fmt.Printf("\nTo check it on the receiving side:\n  echo \"%s  %s\" | sha256sum -c\n", result.Checksum, name)
+		reportStreamedBundle(name, result.Size, result.Checksum)
+		return nil
+	}
+
+	absPath, _ := filepath.Abs(result.Path)
+	if result.UpToDate {
+		ui.Success("Bundle is up to date, nothing changed since the last export: %s", absPath)
+	} else {
+		ui.Success("Successfully created bundle: %s", absPath)
+	}
+	fmt.Printf("  Checksum: %s\n", filepath.Base(checksum.SidecarPath(absPath)))
+	if signKey != "" {
+		fmt.Printf("  Signature: %s\n", filepath.Base(checksum.SignaturePath(absPath)))
+	}
+	reportSavedBundle(absPath)
+	if saveOutputFmt == outputJSON {
+		if err := doc.record(absPath, result); err != nil {
+			return err
+		}
+	}
+
+	// A bundle on a tampered base must not go further
+	if saveOfficial {
+		fmt.Println("\nChecking the base layers against the official image...")
+		checks, err := checkOfficialBase(cmd.Context(), absPath, saveOffBase, nil)
+		doc.Official = checks
+		if err != nil {
+			return fmt.Errorf("%w; the bundle was kept for inspection but should not be delivered", err)
+		}
+	}
+
+	var parts []string
+	if splitSize > 0 {
+		parts, err = image.SplitBundle(absPath, splitSize, tee)
+		if err != nil {
+			return fmt.Errorf("failed to split bundle: %w", err)
+		}
+	}
+	if len(parts) > 0 {
+		ui.Success("Split the bundle into %d parts", len(parts))
+		for _, part := range parts {
+			fmt.Printf("  %s\n", filepath.Base(part))
+		}
+		fmt.Printf("  Part checksums: %s\n", filepath.Base(image.PartsSidecarPath(absPath)))
+		doc.Parts = parts
+	}
+
+	fmt.Printf("\nTo import on target system (%s):\n", platforms[0])
+	if len(parts) > 0 {
+		names := make([]string, len(parts))
+		for i, part := range parts {
+			names[i] = filepath.Base(part)
+		}
+		fmt.Printf("  imgcd join %s\n", names[0])
+		fmt.Printf("  # or, without imgcd: cat %s > %s && sha256sum -c %s\n",
+			strings.Join(names, " "), filepath.Base(absPath), filepath.Base(checksum.SidecarPath(absPath)))
+	}
+	if selfExtracting {
+		fmt.Printf("  sh %s\n", filepath.Base(absPath))
+		fmt.Printf("  # or, where imgcd is installed: imgcd load --from %s\n", filepath.Base(absPath))
+	} else {
+		fmt.Printf("  tar xf %s imgcd\n", filepath.Base(absPath))
+		fmt.Printf("  ./imgcd load --from %s\n", filepath.Base(absPath))
+	}
+
+	// Complete the streamed bundle and deliver its checksum files
+	if delivery != nil {
+		files := []string{absPath, checksum.SidecarPath(absPath)}
+		if len(parts) > 0 {
+			files = append(parts, image.PartsSidecarPath(absPath), checksum.SidecarPath(absPath))
+		}
+		if signKey != "" {
+			files = append(files, checksum.SignaturePath(absPath))
+		}
+		fmt.Println()
+		if err := delivery.Deliver(files); err != nil {
+			return err
+		}
+	}
+
+	// Let scheduled pipelines act only when something new was produced
+	if ifChanged && !result.UpToDate {
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		return &ExitError{Code: ExitBundleCreated}
+	}
 
 	return nil
 }
+
+// parseTargetPlatforms splits and validates a comma-separated --target-platform
+func parseTargetPlatforms(value string) ([]string, error) {
+	var platforms []string
+	seen := make(map[string]bool)
+	for _, platform := range strings.Split(value, ",") {
+		platform = strings.TrimSpace(platform)
+		valid := false
+		for _, p := range validTargetPlatforms {
+			if p == platform {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid target platform: %s (valid options: %v)", platform, validTargetPlatforms)
+		}
+		if !seen[platform] {
+			seen[platform] = true
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms, nil
+}
+
+// imagePlatformsOf maps target platforms to the platforms of their container
+// images, dropping duplicates: a darwin target gets linux images, which
+// macOS runtimes run in a VM, and only the bundled imgcd is built for darwin
+func imagePlatformsOf(targets []string) []string {
+	var platforms []string
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		platform := image.ImagePlatform(target)
+		if platform != target {
+			fmt.Printf("Target %s runs linux images: saving %s images with an imgcd for %s\n", target, platform, target)
+		}
+		if !seen[platform] {
+			seen[platform] = true
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+// parseSegmentOptions validates the flags for segmented blob downloads
+func parseSegmentOptions(segments int, minSize string, mirrors []string) (remote.SegmentOptions, error) {
+	if segments < 1 {
+		return remote.SegmentOptions{}, fmt.Errorf("--segments must be at least 1")
+	}
+	size, err := humanize.ParseSize(minSize)
+	if err != nil {
+		return remote.SegmentOptions{}, fmt.Errorf("invalid --segment-min-size: %w", err)
+	}
+	return remote.SegmentOptions{Segments: segments, MinSize: size, Mirrors: mirrors}, nil
+}
+
+// peerDiscoveryTimeout is how long save and pull wait for peers to answer
+const peerDiscoveryTimeout = time.Second
+
+// findPeers returns the given peers, and with discover those answering on
+// the local network. Discovery failing only leaves the registry.
+func findPeers(ctx context.Context, peers []string, discover bool) []string {
+	if !discover {
+		return peers
+	}
+	found, err := registry.Discover(ctx, peerDiscoveryTimeout)
+	if err != nil {
+		ui.Failure("Peer discovery failed: %v", err)
+		return peers
+	}
+	if len(found) == 0 {
+		fmt.Printf("No imgcd serve instance found on the local network\n")
+	}
+	for _, peer := range found {
+		if !slices.Contains(peers, peer) {
+			fmt.Printf("Found peer %s\n", peer)
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// parseAnnotations converts key=value pairs into a map
+func parseAnnotations(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	annotations := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid annotation %q (expected key=value)", pair)
+		}
+		annotations[key] = value
+	}
+
+	return annotations, nil
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var selftestKeep bool
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Check this installation with a miniature save and load",
+	Long: `Run a round trip in miniature to check that imgcd works on this host before
+trusting it with a real delivery, e.g. on a new air-gapped machine.
+
+A one-file test image is built in memory, saved to a bundle with the running
+imgcd binary, verified, loaded into the detected container runtime under a
+tag of its own (imgcd-selftest:<number>) and removed again, also when a step
+fails. Nothing is downloaded: the test passes offline if the runtime, the
+temp directory and imgcd itself work. The test uses a blob cache and
+indexes of its own in the temp directory, leaving those in the state
+directory alone. --keep leaves the image in the runtime and the bundle in
+its temp directory for a closer look.
+
+Examples:
+  # Check a fresh installation
+  imgcd selftest
+
+  # Keep the test image and bundle
+  imgcd selftest --keep`,
+	Args: cobra.NoArgs,
+	RunE: runSelftest,
+}
+
+func init() {
+	selftestCmd.Flags().BoolVar(&selftestKeep, "keep", false, "Keep the test image in the runtime and the test bundle on disk")
+}
+
+func runSelftest(cmd *cobra.Command, args []string) error {
+	if err := image.SelfTest(cmd.Context(), Version, image.SelfTestOptions{Keep: selftestKeep}); err != nil {
+		return err
+	}
+	ui.Success("Self-test passed")
+	return nil
+}
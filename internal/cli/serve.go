@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/cache"
+	"github.com/so2liu/imgcd/internal/registry"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen  string
+	serveTLSCert string
+	serveTLSKey  string
+	noAnnounce   bool
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the cached images as a read-only registry",
+	Long: `Start a read-only OCI distribution (registry v2) server on the blob cache, so
+machines on an offline network can docker pull from the imgcd host instead of
+each needing a bundle.
+
+Every image saved or pulled from a registry with the cache enabled can be
+pulled, under its repository path without the registry: an image saved as
+registry.example.com/app/web:1.0 is pulled as <host>:5000/app/web:1.0. Tags
+saved for several platforms are served as a multi-platform index. Images whose
+layers were removed by cache prune or gc are left out until they are saved or
+pulled again. Images saved or pulled while the server runs are picked up
+without restarting it.
+
+The server speaks plain HTTP unless --tls-cert and --tls-key are given; Docker
+then needs the host in "insecure-registries" of /etc/docker/daemon.json.
+Pushes are refused. Every image pulled is logged to stderr with the client
+and digest; --log-format json logs it as JSON for log pipelines.
+
+The server answers discovery probes on UDP port 47474, so imgcd save and pull
+--discover-peers on the local network take blobs from it instead of the
+internet. --no-announce turns this off.
+
+Examples:
+  # Fill the cache on the connected side, then serve it on the offline network
+  imgcd pull app/web:1.0 app/db:2.3
+  imgcd serve
+
+  # On another machine
+  docker pull imgcd-host:5000/app/web:1.0
+
+  # Serve over TLS on the standard port
+  imgcd serve --listen :443 --tls-cert host.crt --tls-key host.key`,
+	Args: cobra.NoArgs,
+	RunE: runServe,
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveListen, "listen", ":5000", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "Certificate file to serve HTTPS with")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "Key file of --tls-cert")
+	serveCmd.Flags().BoolVar(&noAnnounce, "no-announce", false, "Do not answer peer discovery from save and pull --discover-peers")
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	if (serveTLSCert == "") != (serveTLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+
+	blobs, err := cache.NewBlobCache(true)
+	if err != nil {
+		return err
+	}
+	if !blobs.Enabled() {
+		return fmt.Errorf("imgcd serve: the blob cache is disabled (--no-state)")
+	}
+	manifests, err := cache.NewManifestCache()
+	if err != nil {
+		return err
+	}
+	server := registry.NewServer(blobs, manifests)
+
+	listener, err := net.Listen("tcp", serveListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", serveListen, err)
+	}
+	scheme := "http"
+	if serveTLSCert != "" {
+		scheme = "https"
+	}
+
+	images := server.Images()
+	ui.Success("Serving %d image(s) from the cache on %s://%s", len(images), scheme, listener.Addr())
+	host := serveHost(listener.Addr())
+	for _, image := range images {
+		platforms := make([]string, len(image.Manifests))
+		for i, m := range image.Manifests {
+			platforms[i] = m.Platform
+		}
+		fmt.Printf("  %s/%s:%s (%s)\n", host, image.Repository, image.Tag, strings.Join(platforms, ", "))
+	}
+
+	if !noAnnounce {
+		port := listener.Addr().(*net.TCPAddr).Port
+		go func() {
+			if err := registry.Announce(cmd.Context(), scheme, port); err != nil {
+				ui.Failure("Not announcing to peers: %v", err)
+			}
+		}()
+	}
+
+	if serveTLSCert != "" {
+		err = http.ServeTLS(listener, server, serveTLSCert, serveTLSKey)
+	} else {
+		err = http.Serve(listener, server)
+	}
+	return fmt.Errorf("failed to serve: %w", err)
+}
+
+// serveHost is the host:port to pull from; an unspecified address is shown
+// as the host name
+func serveHost(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		if name, err := os.Hostname(); err == nil {
+			host = name
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
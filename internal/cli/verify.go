@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/image"
+	"github.com/so2liu/imgcd/internal/remote"
+	"github.com/so2liu/imgcd/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyWorkers      int
+	verifyChecksumKey  string
+	verifyOfficial     bool
+	verifyOfficialBase string
+	verifySnapshot     string
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <BUNDLE>...",
+	Short: "Check bundles for corruption without loading them",
+	Long: `Verify bundles in a single streaming pass, without a container runtime.
+
+The bundle is read once from start to end; nothing is extracted to disk and
+memory use stays bounded regardless of the bundle size. During that pass imgcd
+checks:
+  - the whole file against its .sha256 sidecar, if present
+  - a self-extracting bundle's payload against the size and sha256 in its header
+  - the gzip stream against its CRC
+  - every layer blob against its digest and the size recorded in the metadata
+  - the metadata against the image's manifest and config: layer sizes and
+    DiffIDs, the platform, and that a full bundle stores every layer
+
+Blobs are hashed by --parallel workers while the stream moves on, so large
+bundles verify at roughly disk read speed.
+
+--check-official also checks that every image is built on the unmodified
+layers of the official image it names as its base (the
+org.opencontainers.image.base.name label, else the base of an incremental
+bundle, or --official-base): its bottom layers must be exactly those the
+registry publishes for that image. This flags tampered bases, and images
+built on something other than what they claim. Without network access, pass
+a snapshot of the official images taken with imgcd metadata export.
+
+Examples:
+  # Verify a bundle after copying it to the target machine
+  imgcd verify /media/usb/myapp-2.0__since-1.9.tar
+
+  # Also require a valid signature on the checksum file
+  imgcd verify myapp-2.0__since-1.9.sh --checksum-key release.pub
+
+  # Check the base layers against Docker Hub's alpine:3.19, offline
+  imgcd metadata export alpine:3.19 -o alpine.snapshot.json   # connected side
+  imgcd verify myapp-2.0__since-none.tar --check-official --snapshot alpine.snapshot.json`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runVerify,
+}
+
+func init() {
+	verifyCmd.Flags().IntVarP(&verifyWorkers, "parallel", "p", 0, "Blobs hashed concurrently (default: number of CPUs)")
+	verifyCmd.Flags().StringVar(&verifyChecksumKey, "checksum-key", "", "Ed25519 public key (PEM) the .sha256.sig signature must verify against")
+	verifyCmd.Flags().BoolVar(&verifyOfficial, "check-official", false, "Check that the images are built on the unmodified layers of their official base image")
+	verifyCmd.Flags().StringVar(&verifyOfficialBase, "official-base", "", "Official image to check the base layers against (default: the one the image names)")
+	verifyCmd.Flags().StringVar(&verifySnapshot, "snapshot", "", "Take the official layers from a metadata snapshot (imgcd metadata export) instead of the registry")
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	if !verifyOfficial && (verifyOfficialBase != "" || verifySnapshot != "") {
+		return fmt.Errorf("--official-base and --snapshot need --check-official")
+	}
+	var fetchOptions []ggcrremote.Option
+	if verifySnapshot != "" {
+		snapshot, err := remote.LoadSnapshot(verifySnapshot)
+		if err != nil {
+			return err
+		}
+		fetchOptions = snapshot.Options()
+	}
+
+	failed := 0
+	for _, bundlePath := range args {
+		if err := verifyBundle(cmd.Context(), bundlePath, fetchOptions); err != nil {
+			ui.Failure("%s: %v", filepath.Base(bundlePath), err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d bundle(s) failed verification", failed, len(args))
+	}
+	return nil
+}
+
+// verifyBundle verifies one bundle and prints the outcome; fetchOptions
+// are those of the --check-official lookups
+func verifyBundle(ctx context.Context, bundlePath string, fetchOptions []ggcrremote.Option) error {
+	name := filepath.Base(bundlePath)
+
+	// The signature covers the sidecar, which is checked against the bundle below
+	if verifyChecksumKey != "" {
+		if !checksum.HasSignature(bundlePath) {
+			return fmt.Errorf("--checksum-key given but %s is not signed", filepath.Base(checksum.SidecarPath(bundlePath)))
+		}
+		if err := checksum.VerifySignature(bundlePath, verifyChecksumKey); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Verifying %s...\n", name)
+	started := time.Now()
+	report, err := image.VerifyBundle(bundlePath, image.VerifyOptions{Workers: verifyWorkers})
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(started)
+
+	if !report.OK() {
+		for _, problem := range report.Problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+		return fmt.Errorf("%d problem(s) found", len(report.Problems))
+	}
+
+	if report.Sidecar {
+		fmt.Printf("  Checksum matches %s\n", filepath.Base(checksum.SidecarPath(bundlePath)))
+	} else {
+		fmt.Printf("  No %s to check the file against\n", filepath.Base(checksum.SidecarPath(bundlePath)))
+	}
+	if verifyChecksumKey != "" {
+		fmt.Printf("  Checksum signature verified\n")
+	}
+	if report.Legacy {
+		fmt.Printf("  Legacy bundle: no per-layer digests to check\n")
+	} else {
+		fmt.Printf("  %d layer blob(s) verified (%s)\n", report.Blobs, humanize.Size(report.BlobBytes))
+	}
+	if report.Checksums > 0 {
+		fmt.Printf("  %d further checksum(s) verified\n", report.Checksums)
+	}
+	if len(report.Skipped) > 0 {
+		fmt.Printf("  Not verified: %s checksums (unsupported algorithm)\n", strings.Join(report.Skipped, ", "))
+	}
+	if report.Manifest {
+		fmt.Printf("  Manifest and config consistent with the metadata\n")
+	}
+	if verifyOfficial {
+		if _, err := checkOfficialBase(ctx, bundlePath, verifyOfficialBase, fetchOptions); err != nil {
+			return err
+		}
+	}
+
+	rate := ""
+	if r := humanize.Rate(report.Bytes, elapsed); r != "" {
+		rate = ", " + r
+	}
+	ui.Success("%s is intact: %s (%s) in %s%s", name, report.ImageRef, humanize.Size(report.Bytes), humanize.Duration(elapsed), rate)
+	return nil
+}
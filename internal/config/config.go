@@ -0,0 +1,285 @@
+// Package config reads and writes the config file, config.yaml in the state
+// directory, which holds persistent defaults for command-line flags
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/state"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the config file in the state directory
+const FileName = "config.yaml"
+
+// Kind is the type of a config value
+type Kind string
+
+const (
+	String Kind = "string"
+	Int    Kind = "int"
+	Bool   Kind = "bool"
+)
+
+// Key is a setting the config file can hold. Nested keys are written with
+// dots, e.g. cache.enabled for enabled under cache:. A name ending in
+// .<host> stands for one key per registry host under that section, where
+// the host keeps its own dots (proxies.registry.example.com:5000).
+type Key struct {
+	Name        string
+	Kind        Kind
+	Description string
+}
+
+// Keys are the settings the config file can hold
+var Keys = []Key{
+	{"out-dir", String, "Output directory of save"},
+	{"target-platform", String, "Platform of save, diff, pull and copy"},
+	{"concurrency", Int, "Parallel workers of apply and verify"},
+	{"cache.enabled", Bool, "Use the layer cache in save and copy"},
+	{"cache.prune-days", Int, "Days cache prune keeps unused layers"},
+	{"cache.gc-keep-days", Int, "Days cache gc keeps blobs of recent exports"},
+	{"limit-rate", String, "Maximum rate of the layer downloads of save and pull, e.g. 10MB/s"},
+	{"peers.discover", Bool, "Look for imgcd serve instances on the local network in save and pull"},
+	{"registry.release-mirror", String, "Mirror of the release assets for downloading imgcd binaries (IMGCD_RELEASE_MIRROR)"},
+	{"proxies.<host>", String, "Proxy URL for requests to a registry host (*.example.com for its subdomains), or direct to bypass HTTPS_PROXY"},
+}
+
+// hostSuffix ends the names of keys that are set per registry host
+const hostSuffix = ".<host>"
+
+// LookupKey returns the key of a name
+func LookupKey(name string) (Key, bool) {
+	for _, key := range Keys {
+		if key.Name == name {
+			return key, true
+		}
+		if section, ok := key.section(); ok {
+			if host, ok := strings.CutPrefix(name, section+"."); ok && host != "" {
+				return key, true
+			}
+		}
+	}
+	return Key{}, false
+}
+
+// section returns the section of a key set per registry host
+func (k Key) section() (string, bool) {
+	return strings.CutSuffix(k.Name, hostSuffix)
+}
+
+// Validate checks that value can be stored under the key
+func (k Key) Validate(value string) error {
+	switch k.Kind {
+	case Int:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s must be a non-negative number, got %q", k.Name, value)
+		}
+	case Bool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", k.Name, value)
+		}
+	}
+	return nil
+}
+
+// Config holds the values of a config file by key name
+type Config struct {
+	path   string
+	values map[string]string
+}
+
+// Path returns the location of the config file
+func Path() (string, error) {
+	dir, err := state.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// Load reads the config file. A missing file, or disabled state, gives an
+// empty config.
+func Load() (*Config, error) {
+	cfg := &Config{values: make(map[string]string)}
+	path, err := Path()
+	if errors.Is(err, state.ErrDisabled) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := flatten("", doc, cfg.values); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	for name, value := range cfg.values {
+		if key, ok := LookupKey(name); ok {
+			if err := key.Validate(value); err != nil {
+				return nil, fmt.Errorf("invalid config %s: %w", path, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// File returns the path the config was loaded from, or "" under --no-state
+func (c *Config) File() string {
+	return c.path
+}
+
+// Unknown returns the set keys that are not in Keys, e.g. misspelled ones
+// or those of a newer imgcd
+func (c *Config) Unknown() []string {
+	var names []string
+	for _, name := range c.Names() {
+		if _, ok := LookupKey(name); !ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// flatten stores the leaves of a YAML document under their dotted names
+func flatten(prefix string, doc map[string]any, values map[string]string) error {
+	for name, value := range doc {
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		switch value := value.(type) {
+		case map[string]any:
+			if err := flatten(name, value, values); err != nil {
+				return err
+			}
+		case []any:
+			return fmt.Errorf("%s: lists are not supported", name)
+		case nil:
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+// Get returns the value of a key and whether it is set
+func (c *Config) Get(name string) (string, bool) {
+	value, ok := c.values[name]
+	return value, ok
+}
+
+// Section returns the values set under a section by the rest of their name,
+// e.g. the hosts under proxies
+func (c *Config) Section(section string) map[string]string {
+	values := make(map[string]string)
+	for name, value := range c.values {
+		if rest, ok := strings.CutPrefix(name, section+"."); ok {
+			values[rest] = value
+		}
+	}
+	return values
+}
+
+// Names returns the set keys in sorted order
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set validates and sets the value of a key
+func (c *Config) Set(name, value string) error {
+	key, ok := LookupKey(name)
+	if !ok {
+		return fmt.Errorf("unknown config key %s", name)
+	}
+	if err := key.Validate(value); err != nil {
+		return err
+	}
+	c.values[name] = value
+	return nil
+}
+
+// Unset removes a key; unknown keys can be removed when they are set
+func (c *Config) Unset(name string) error {
+	_, known := LookupKey(name)
+	if _, set := c.values[name]; !known && !set {
+		return fmt.Errorf("unknown config key %s", name)
+	}
+	delete(c.values, name)
+	return nil
+}
+
+// Save writes the config file. Comments of a hand-written file are not kept.
+func (c *Config) Save() error {
+	if c.path == "" {
+		return fmt.Errorf("failed to save config: %w", state.ErrDisabled)
+	}
+
+	doc := make(map[string]any)
+	for name, value := range c.values {
+		key, _ := LookupKey(name)
+		parts := strings.Split(name, ".")
+		if section, ok := key.section(); ok {
+			// The host is one key, dots and all
+			parts = append(strings.Split(section, "."), strings.TrimPrefix(name, section+"."))
+		}
+		parent := doc
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := parent[part].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				parent[part] = child
+			}
+			parent = child
+		}
+		parent[parts[len(parts)-1]] = typedValue(key.Kind, value)
+	}
+
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// typedValue converts a validated value so YAML writes it unquoted
+func typedValue(kind Kind, value string) any {
+	switch kind {
+	case Int:
+		n, _ := strconv.Atoi(value)
+		return n
+	case Bool:
+		b, _ := strconv.ParseBool(value)
+		return b
+	}
+	return value
+}
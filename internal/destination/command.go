@@ -0,0 +1,169 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// sshDestination writes into a remote directory through the system ssh
+// client, so keys, agents and ~/.ssh/config apply as usual
+type sshDestination struct {
+	host string // [user@]host
+	port string
+	dir  string
+}
+
+func newSSHDestination(u *url.URL) (*sshDestination, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ssh destination %q: missing host", u.String())
+	}
+
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	dir := u.Path
+	if dir == "" {
+		dir = "."
+	}
+	return &sshDestination{host: host, port: u.Port(), dir: dir}, nil
+}
+
+func (d *sshDestination) String() string {
+	if d.port != "" {
+		return fmt.Sprintf("ssh://%s:%s%s", d.host, d.port, d.dir)
+	}
+	return fmt.Sprintf("ssh://%s%s", d.host, d.dir)
+}
+
+func (d *sshDestination) Create(ctx context.Context, name string, size int64) (Writer, error) {
+	target := path.Join(d.dir, name)
+	partial := target + ".partial"
+	remote := fmt.Sprintf("mkdir -p %s && cat > %s && mv %s %s",
+		shellQuote(d.dir), shellQuote(partial), shellQuote(partial), shellQuote(target))
+
+	args := []string{"-o", "BatchMode=yes"}
+	if d.port != "" {
+		args = append(args, "-p", d.port)
+	}
+	args = append(args, d.host, remote)
+
+	return startCommand(ctx, "ssh", args...)
+}
+
+// s3Destination uploads through the aws CLI, which streams stdin to S3
+type s3Destination struct {
+	bucket string
+	prefix string
+}
+
+func newS3Destination(u *url.URL) (*s3Destination, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 destination %q: missing bucket", u.String())
+	}
+	return &s3Destination{bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+func (d *s3Destination) String() string {
+	if d.prefix == "" {
+		return "s3://" + d.bucket
+	}
+	return "s3://" + d.bucket + "/" + d.prefix
+}
+
+func (d *s3Destination) Create(ctx context.Context, name string, size int64) (Writer, error) {
+	key := path.Join(d.prefix, name)
+	args := []string{"s3", "cp", "-", "s3://" + d.bucket + "/" + key}
+	// The expected size lets the CLI pick part sizes for uploads over 50 GB;
+	// it is unknown while a bundle is streamed as it is created
+	if size >= 0 {
+		args = append(args, "--expected-size", strconv.FormatInt(size, 10))
+	}
+	return startCommand(ctx, "aws", args...)
+}
+
+// commandWriter feeds a file to the stdin of a command
+type commandWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *bytes.Buffer
+	cancel context.CancelFunc
+	waited bool
+}
+
+func startCommand(ctx context.Context, name string, args ...string) (*commandWriter, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, fmt.Errorf("%s not found in PATH", name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := exec.CommandContext(ctx, name, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+
+	return &commandWriter{cmd: cmd, stdin: stdin, stderr: stderr, cancel: cancel}, nil
+}
+
+func (w *commandWriter) Write(p []byte) (int, error) {
+	n, err := w.stdin.Write(p)
+	if err != nil {
+		// The command exited early; its exit status explains more than EPIPE
+		if waitErr := w.wait(); waitErr != nil {
+			err = waitErr
+		}
+		return n, w.failure(err)
+	}
+	return n, nil
+}
+
+func (w *commandWriter) Close() error {
+	defer w.cancel()
+	if err := w.wait(); err != nil {
+		return w.failure(err)
+	}
+	return nil
+}
+
+func (w *commandWriter) Abort() {
+	w.cancel()
+	w.wait()
+}
+
+func (w *commandWriter) wait() error {
+	if w.waited {
+		return nil
+	}
+	w.waited = true
+	w.stdin.Close()
+	return w.cmd.Wait()
+}
+
+// failure adds the command's own error output to err
+func (w *commandWriter) failure(err error) error {
+	if msg := strings.TrimSpace(w.stderr.String()); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,98 @@
+package destination
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// copyBufferSize keeps writes large, which matters on USB flash media
+const copyBufferSize = 4 << 20
+
+// CopyFile copies src to dst through a .partial file that is fsynced and
+// then renamed. It returns the sha256 of the data read from src. When
+// expected is set, a source that does not match it leaves dst untouched.
+func CopyFile(src, dst, expected string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	partial := dst + ".partial"
+	out, err := os.Create(partial)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	w := bufio.NewWriterSize(out, copyBufferSize)
+	reader := io.TeeReader(bufio.NewReaderSize(in, copyBufferSize), hasher)
+	if _, err := io.Copy(w, reader); err != nil {
+		out.Close()
+		os.Remove(partial)
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		os.Remove(partial)
+		return "", err
+	}
+
+	// Make sure the data is on the device, not in the page cache
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(partial)
+		return "", fmt.Errorf("failed to sync %s: %w", partial, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && sum != expected {
+		os.Remove(partial)
+		return "", fmt.Errorf("source %s does not match its checksum (expected %s, got %s)", filepath.Base(src), expected, sum)
+	}
+
+	if err := os.Rename(partial, dst); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	syncDir(filepath.Dir(dst))
+
+	return sum, nil
+}
+
+// ReadBack returns the sha256 of a file as stored on its device, evicting
+// cached pages first where the platform allows it
+func ReadBack(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	dropCache(file)
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, bufio.NewReaderSize(file, copyBufferSize)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// syncDir persists a rename; not all filesystems support it (e.g. FAT)
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
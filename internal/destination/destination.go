@@ -0,0 +1,128 @@
+// Package destination delivers bundles to one or more places (directories,
+// ssh hosts, S3 buckets, HTTP endpoints, ISO images), while they are written
+// or, once finished, in a single read pass.
+package destination
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// Destination is a place bundles can be written to
+type Destination interface {
+	// String describes the destination for progress output
+	String() string
+	// Create opens name for writing; the file is committed by a successful
+	// Close and discarded by Abort
+	Create(ctx context.Context, name string, size int64) (Writer, error)
+}
+
+// Writer receives the content of one file
+type Writer interface {
+	io.Writer
+	Close() error
+	Abort()
+}
+
+// Parse creates a destination from a --to value:
+//
+//	/path/dir, dir:/path/dir        local directory
+//	ssh://[user@]host[:port]/path   remote directory over ssh
+//	s3://bucket/prefix              S3 prefix via the aws CLI
+//	http(s)://host/path             HTTP PUT to <url>/<name>
+//	iso:PATH.iso                    ISO image with manifest and verify.sh
+func Parse(spec string) (Destination, error) {
+	if path, ok := strings.CutPrefix(spec, "iso:"); ok {
+		return newISODestination(path)
+	}
+	if path, ok := strings.CutPrefix(spec, "dir:"); ok {
+		return newDirDestination(path), nil
+	}
+	if !strings.Contains(spec, "://") {
+		return newDirDestination(spec), nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q: %w", spec, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return newDirDestination(u.Path), nil
+	case "ssh":
+		return newSSHDestination(u)
+	case "s3":
+		return newS3Destination(u)
+	case "http", "https":
+		return newHTTPDestination(u), nil
+	default:
+		return nil, fmt.Errorf("unsupported destination %q (use a directory, ssh://, s3://, http://, https:// or iso:)", spec)
+	}
+}
+
+// Deliver copies the files to every destination, reading each file once.
+// A failing destination is dropped without stopping the others; the
+// returned error lists every destination that failed.
+func Deliver(ctx context.Context, paths []string, dests []Destination) error {
+	return deliver(ctx, paths, dests, make(map[Destination]error), nil)
+}
+
+// deliver is Deliver for destinations of which some may have failed or
+// received some of the files already, as recorded in failed and sent
+func deliver(ctx context.Context, paths []string, dests []Destination, failed map[Destination]error, sent map[Destination]map[string]bool) error {
+	for _, path := range paths {
+		var live []Destination
+		for _, dest := range dests {
+			if failed[dest] == nil && !sent[dest][remoteName(path)] {
+				live = append(live, dest)
+			}
+		}
+		if len(live) == 0 {
+			continue
+		}
+
+		for dest, err := range copyToAll(ctx, path, live) {
+			failed[dest] = err
+		}
+	}
+
+	// Assemblers build their artifact once everything was written
+	for _, dest := range dests {
+		assembler, ok := dest.(Assembler)
+		if !ok {
+			continue
+		}
+		if failed[dest] != nil {
+			assembler.Discard()
+			continue
+		}
+		if err := assembler.Finish(ctx); err != nil {
+			failed[dest] = err
+		}
+	}
+
+	var msgs []string
+	for _, dest := range dests {
+		if err := failed[dest]; err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", dest, err))
+		} else {
+			ui.Success("Delivered to %s", dest)
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("delivery failed for %d destination(s):\n  %s", len(msgs), strings.Join(msgs, "\n  "))
+	}
+	return nil
+}
+
+// remoteName returns the file name used at destinations
+func remoteName(path string) string {
+	return filepath.Base(path)
+}
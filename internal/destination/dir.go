@@ -0,0 +1,57 @@
+package destination
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// dirDestination writes into a local directory, e.g. a mounted share
+type dirDestination struct {
+	dir string
+}
+
+func newDirDestination(dir string) *dirDestination {
+	return &dirDestination{dir: dir}
+}
+
+func (d *dirDestination) String() string {
+	return d.dir
+}
+
+// Create writes to a .partial file that is renamed once complete, so readers
+// of the directory never see half-written bundles
+func (d *dirDestination) Create(ctx context.Context, name string, size int64) (Writer, error) {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	target := filepath.Join(d.dir, name)
+	file, err := os.Create(target + ".partial")
+	if err != nil {
+		return nil, err
+	}
+	return &dirWriter{File: file, target: target}, nil
+}
+
+type dirWriter struct {
+	*os.File
+	target string
+}
+
+func (w *dirWriter) Close() error {
+	if err := w.File.Sync(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	return os.Rename(w.File.Name(), w.target)
+}
+
+func (w *dirWriter) Abort() {
+	w.File.Close()
+	os.Remove(w.File.Name())
+}
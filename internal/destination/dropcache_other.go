@@ -0,0 +1,8 @@
+//go:build !linux
+
+package destination
+
+import "os"
+
+// dropCache is a no-op where the page cache cannot be bypassed per file
+func dropCache(file *os.File) {}
@@ -0,0 +1,102 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// fanoutChunkSize is how much is read from the source per round of writes
+const fanoutChunkSize = 1 << 20
+
+// copyToAll streams one file to all destinations in parallel and returns
+// the destinations that failed
+func copyToAll(ctx context.Context, path string, dests []Destination) map[Destination]error {
+	failed := make(map[Destination]error)
+
+	file, err := os.Open(path)
+	if err != nil {
+		for _, dest := range dests {
+			failed[dest] = err
+		}
+		return failed
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		for _, dest := range dests {
+			failed[dest] = err
+		}
+		return failed
+	}
+
+	name := remoteName(path)
+	fmt.Printf("Delivering %s to %d destination(s)...\n", name, len(dests))
+
+	writers := make(map[Destination]Writer)
+	for _, dest := range dests {
+		w, err := dest.Create(ctx, name, info.Size())
+		if err != nil {
+			failed[dest] = err
+			continue
+		}
+		writers[dest] = w
+	}
+
+	buf := make([]byte, fanoutChunkSize)
+	for len(writers) > 0 {
+		n, readErr := io.ReadFull(file, buf)
+		if n > 0 {
+			// Every destination gets the chunk before the next one is read
+			writeToAll(writers, buf[:n], failed)
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			for dest, w := range writers {
+				w.Abort()
+				failed[dest] = readErr
+			}
+			return failed
+		}
+	}
+
+	for dest, w := range writers {
+		if err := w.Close(); err != nil {
+			failed[dest] = err
+		}
+	}
+
+	return failed
+}
+
+// writeToAll writes p to every writer in parallel, then aborts and drops
+// the writers that failed, recording why in failed
+func writeToAll(writers map[Destination]Writer, p []byte, failed map[Destination]error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for dest, w := range writers {
+		wg.Add(1)
+		go func(dest Destination, w Writer) {
+			defer wg.Done()
+			if _, err := w.Write(p); err != nil {
+				mu.Lock()
+				failed[dest] = err
+				mu.Unlock()
+			}
+		}(dest, w)
+	}
+	wg.Wait()
+
+	for dest, w := range writers {
+		if failed[dest] != nil {
+			w.Abort()
+			delete(writers, dest)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// httpDestination uploads with PUT <url>/<name>, as accepted by artifact
+// repositories (Nexus raw, Artifactory, WebDAV) and presigned endpoints
+type httpDestination struct {
+	base *url.URL
+}
+
+func newHTTPDestination(u *url.URL) *httpDestination {
+	return &httpDestination{base: u}
+}
+
+func (d *httpDestination) String() string {
+	// Never print credentials embedded in the URL
+	redacted := *d.base
+	redacted.User = nil
+	return redacted.String()
+}
+
+// needsSize reports that uploads carry a Content-Length, which presigned
+// URLs and many servers require instead of chunked encoding
+func (d *httpDestination) needsSize() bool {
+	return true
+}
+
+func (d *httpDestination) Create(ctx context.Context, name string, size int64) (Writer, error) {
+	target := *d.base
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + url.PathEscape(name)
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if d.base.User != nil {
+		password, _ := d.base.User.Password()
+		req.SetBasicAuth(d.base.User.Username(), password)
+	}
+
+	w := &httpWriter{pw: pw, cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			pr.CloseWithError(err)
+			w.done <- err
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err := fmt.Errorf("upload rejected: %s", resp.Status)
+			pr.CloseWithError(err)
+			w.done <- err
+			return
+		}
+		w.done <- nil
+	}()
+
+	return w, nil
+}
+
+// httpWriter streams into the body of a running PUT request
+type httpWriter struct {
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func (w *httpWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *httpWriter) Close() error {
+	defer w.cancel()
+	w.pw.Close()
+	return <-w.done
+}
+
+func (w *httpWriter) Abort() {
+	w.cancel()
+	w.pw.CloseWithError(context.Canceled)
+	<-w.done
+}
@@ -0,0 +1,194 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/attest"
+	"github.com/so2liu/imgcd/templates"
+)
+
+// Assembler is a destination that builds one artifact from all delivered
+// files. Deliver calls Finish after every file was written, or Discard if
+// the destination failed.
+type Assembler interface {
+	Destination
+	Finish(ctx context.Context) error
+	Discard()
+}
+
+// isoDestination stages the delivered files and packs them into an ISO
+// image with a manifest and a verification script. Files over 4 GiB need
+// ISO level 3 or UDF, which all supported tools are asked for.
+type isoDestination struct {
+	path    string
+	tool    string
+	staging string
+	files   attest.Manifest
+}
+
+// isoTools are tried in order; hdiutil is the macOS builtin
+var isoTools = []string{"xorriso", "genisoimage", "mkisofs", "hdiutil"}
+
+func newISODestination(path string) (*isoDestination, error) {
+	if path == "" {
+		return nil, fmt.Errorf("invalid iso destination: missing file name (use iso:PATH.iso)")
+	}
+
+	for _, tool := range isoTools {
+		if _, err := exec.LookPath(tool); err == nil {
+			return &isoDestination{path: path, tool: tool}, nil
+		}
+	}
+	return nil, fmt.Errorf("iso destination needs one of %s in PATH", strings.Join(isoTools, ", "))
+}
+
+func (d *isoDestination) String() string {
+	return "iso:" + d.path
+}
+
+func (d *isoDestination) Create(ctx context.Context, name string, size int64) (Writer, error) {
+	if d.staging == "" {
+		dir := filepath.Dir(d.path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		staging, err := os.MkdirTemp(dir, ".imgcd-iso-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		d.staging = staging
+	}
+
+	file, err := os.Create(filepath.Join(d.staging, name))
+	if err != nil {
+		return nil, err
+	}
+	return &isoWriter{file: file, hasher: sha256.New(), dest: d, name: name}, nil
+}
+
+// isoWriter stages one file and records its checksum for the manifest
+type isoWriter struct {
+	file   *os.File
+	hasher hash.Hash
+	size   int64
+	dest   *isoDestination
+	name   string
+}
+
+func (w *isoWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hasher.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *isoWriter) Close() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.dest.files.Add(attest.Entry{Name: w.name, Size: w.size, SHA256: hex.EncodeToString(w.hasher.Sum(nil))})
+	return nil
+}
+
+func (w *isoWriter) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// Finish writes the manifest and verification script and builds the image
+func (d *isoDestination) Finish(ctx context.Context) error {
+	defer d.Discard()
+
+	if err := d.writeManifest(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(d.staging, "verify.sh"), []byte(templates.VerifyDelivery), 0755); err != nil {
+		return fmt.Errorf("failed to write verification script: %w", err)
+	}
+
+	// Keep the .iso extension, hdiutil appends one otherwise
+	partial := strings.TrimSuffix(d.path, filepath.Ext(d.path)) + ".partial.iso"
+	cmd := exec.CommandContext(ctx, d.tool, isoArgs(d.tool, partial, volumeLabel(d.path), d.staging)...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("%s failed: %w: %s", d.tool, err, strings.TrimSpace(output.String()))
+	}
+
+	if err := os.Rename(partial, d.path); err != nil {
+		return err
+	}
+	fmt.Printf("  Manifest SHA-256 of %s: %s (imgcd attest prints it for the receiving site)\n", filepath.Base(d.path), d.files.Digest())
+	return nil
+}
+
+// Discard removes the staged files
+func (d *isoDestination) Discard() {
+	if d.staging != "" {
+		os.RemoveAll(d.staging)
+		d.staging = ""
+	}
+}
+
+// writeManifest writes SHA256SUMS for verify.sh and a readable MANIFEST.txt
+func (d *isoDestination) writeManifest() error {
+	var manifest bytes.Buffer
+	fmt.Fprintf(&manifest, "imgcd delivery, created %s\n\n", time.Now().UTC().Format(time.RFC3339))
+	for _, e := range d.files.Entries {
+		fmt.Fprintf(&manifest, "%-64s  %14d  %s\n", e.SHA256, e.Size, e.Name)
+	}
+	fmt.Fprintf(&manifest, "\nManifest SHA-256 (sha256 of SHA256SUMS): %s\n", d.files.Digest())
+	fmt.Fprintf(&manifest, "Verify with: sh verify.sh\n")
+
+	if err := os.WriteFile(filepath.Join(d.staging, "SHA256SUMS"), d.files.SHA256SUMS(), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(d.staging, "MANIFEST.txt"), manifest.Bytes(), 0644)
+}
+
+// isoArgs returns the arguments to build output from dir with the given tool
+func isoArgs(tool, output, label, dir string) []string {
+	switch tool {
+	case "xorriso":
+		return []string{"-as", "mkisofs", "-quiet", "-iso-level", "3", "-J", "-joliet-long", "-R", "-V", label, "-o", output, dir}
+	case "hdiutil":
+		return []string{"makehybrid", "-quiet", "-iso", "-joliet", "-udf", "-default-volume-name", label, "-o", output, dir}
+	default: // genisoimage, mkisofs
+		return []string{"-quiet", "-iso-level", "3", "-udf", "-allow-limited-size", "-J", "-joliet-long", "-R", "-V", label, "-o", output, dir}
+	}
+}
+
+// volumeLabel derives an ISO 9660 volume identifier (d-characters, at most
+// 32) from the output file name
+func volumeLabel(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	label := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if len(label) > 32 {
+		label = label[:32]
+	}
+	if label == "" {
+		label = "IMGCD"
+	}
+	return label
+}
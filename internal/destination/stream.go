@@ -0,0 +1,134 @@
+package destination
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+)
+
+// sizedDestination is a destination that must know the size of a file
+// before it is written, so it cannot receive a file while it is created
+type sizedDestination interface {
+	needsSize() bool
+}
+
+// Stream writes files to several destinations while they are created, e.g.
+// a bundle as save writes it, so no second copy follows the export.
+// Destinations that need the size up front (http(s)) are skipped and get
+// the finished files from Deliver. A streamed file is committed when the
+// next one is opened or by Deliver, and discarded by Abort.
+type Stream struct {
+	ctx    context.Context
+	dests  []Destination
+	failed map[Destination]error
+	sent   map[Destination]map[string]bool // Files committed at each destination
+
+	name    string // File being streamed, "" if none
+	writers map[Destination]Writer
+	buf     *bufio.Writer
+}
+
+// NewStream creates a stream to dests
+func NewStream(ctx context.Context, dests []Destination) *Stream {
+	s := &Stream{
+		ctx:    ctx,
+		dests:  dests,
+		failed: make(map[Destination]error),
+		sent:   make(map[Destination]map[string]bool),
+	}
+	s.buf = bufio.NewWriterSize(fanWriter{s}, fanoutChunkSize)
+	return s
+}
+
+// Open starts streaming the file name and returns the writer it is to be
+// written to. A file still open is committed first, or discarded if it has
+// the same name, i.e. is being written anew.
+func (s *Stream) Open(name string) io.Writer {
+	if s.name == name {
+		s.discard()
+	} else {
+		s.commit()
+	}
+
+	s.name = name
+	s.writers = make(map[Destination]Writer)
+	var streamed int
+	for _, dest := range s.dests {
+		if s.failed[dest] != nil {
+			continue
+		}
+		if sized, ok := dest.(sizedDestination); ok && sized.needsSize() {
+			continue
+		}
+		w, err := dest.Create(s.ctx, name, -1)
+		if err != nil {
+			s.failed[dest] = err
+			continue
+		}
+		s.writers[dest] = w
+		streamed++
+	}
+	if streamed > 0 {
+		fmt.Printf("Streaming %s to %d destination(s) while it is written...\n", name, streamed)
+	}
+	return s.buf
+}
+
+// fanWriter passes what the buffer of a stream flushes to its destinations.
+// It never fails: a failing destination is dropped, and Deliver reports it.
+type fanWriter struct {
+	s *Stream
+}
+
+func (w fanWriter) Write(p []byte) (int, error) {
+	writeToAll(w.s.writers, p, w.s.failed)
+	return len(p), nil
+}
+
+// commit completes the file being streamed at every destination
+func (s *Stream) commit() {
+	if s.name == "" {
+		return
+	}
+	s.buf.Flush()
+	for dest, w := range s.writers {
+		if err := w.Close(); err != nil {
+			s.failed[dest] = err
+			continue
+		}
+		if s.sent[dest] == nil {
+			s.sent[dest] = make(map[string]bool)
+		}
+		s.sent[dest][s.name] = true
+	}
+	s.name, s.writers = "", nil
+}
+
+// discard drops the file being streamed at every destination
+func (s *Stream) discard() {
+	s.buf.Reset(fanWriter{s})
+	for _, w := range s.writers {
+		w.Abort()
+	}
+	s.name, s.writers = "", nil
+}
+
+// Deliver commits the file being streamed, then delivers the files that
+// were not streamed to each destination, such as checksum files or a
+// bundle of an earlier run that was reused, like the package's Deliver
+func (s *Stream) Deliver(paths []string) error {
+	s.commit()
+	return deliver(s.ctx, paths, s.dests, s.failed, s.sent)
+}
+
+// Abort discards the file being streamed and what assemblers staged, for
+// an export that failed or must not be delivered
+func (s *Stream) Abort() {
+	s.discard()
+	for _, dest := range s.dests {
+		if assembler, ok := dest.(Assembler); ok {
+			assembler.Discard()
+		}
+	}
+}
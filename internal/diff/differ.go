@@ -3,7 +3,7 @@ package diff
 import (
 	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -57,13 +57,8 @@ func NewDiffer(fetcher *remote.Fetcher) *Differ {
 
 // Compare compares two images and returns the differences
 func (d *Differ) Compare(ctx context.Context, newImageRef, baseImageRef, platform string) (*DiffResult, error) {
-	debug := os.Getenv("IMGCD_DEBUG") != ""
 	startTime := time.Now()
-
-	if debug {
-		fmt.Fprintf(os.Stderr, "\n[DEBUG] === Starting image comparison ===\n")
-		fmt.Fprintf(os.Stderr, "[DEBUG] Fetching both images in parallel...\n")
-	}
+	slog.Debug("comparing images", "new", newImageRef, "base", baseImageRef, "platform", platform)
 
 	// Fetch metadata for both images in parallel
 	type fetchResult struct {
@@ -106,9 +101,7 @@ func (d *Differ) Compare(ctx context.Context, newImageRef, baseImageRef, platfor
 		if result.err != nil {
 			return nil, fmt.Errorf("failed to fetch %s metadata: %w", result.name, result.err)
 		}
-		if debug {
-			fmt.Fprintf(os.Stderr, "[DEBUG] Fetch %s: %v\n", result.name, result.duration)
-		}
+		slog.Debug("fetched "+result.name, "ref", result.metadata.Reference, "duration", result.duration)
 
 		// Assign to correct variable based on reference
 		if result.metadata.Reference == newImageRef {
@@ -118,9 +111,7 @@ func (d *Differ) Compare(ctx context.Context, newImageRef, baseImageRef, platfor
 		}
 	}
 
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Parallel fetch completed: %v\n", time.Since(startTime))
-	}
+	slog.Debug("fetched both images", "duration", time.Since(startTime))
 
 	// Build a map of base image layer DiffIDs for quick lookup
 	t3 := time.Now()
@@ -158,9 +149,7 @@ func (d *Differ) Compare(ctx context.Context, newImageRef, baseImageRef, platfor
 
 		layerDiffs = append(layerDiffs, diff)
 	}
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Compare and calculate: %v\n", time.Since(t3))
-	}
+	slog.Debug("compared layers", "shared", len(sharedLayers), "new", len(newLayers), "duration", time.Since(t3))
 
 	// Calculate savings
 	totalNewImageSize := newImage.TotalSize
@@ -170,9 +159,7 @@ func (d *Differ) Compare(ctx context.Context, newImageRef, baseImageRef, platfor
 		savingsPercentage = float64(savingsSize) / float64(totalNewImageSize) * 100.0
 	}
 
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] === Total comparison time: %v ===\n\n", time.Since(startTime))
-	}
+	slog.Debug("compared images", "new", newImageRef, "base", baseImageRef, "duration", time.Since(startTime))
 
 	return &DiffResult{
 		NewImage:          newImage,
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"strings"
+
+	"github.com/so2liu/imgcd/internal/humanize"
 )
 
 // OutputFormat represents the output format type
@@ -119,7 +121,7 @@ func (f *Formatter) formatText(w io.Writer, result *DiffResult) error {
 			fmt.Fprintf(w, "  [%s] %s (%s)",
 				status,
 				diffIDShort,
-				formatSize(layer.Size),
+				humanize.Size(layer.Size),
 			)
 			if command != "" {
 				fmt.Fprintf(w, " - %s", command)
@@ -138,28 +140,12 @@ func (f *Formatter) formatText(w io.Writer, result *DiffResult) error {
 
 	// Size information
 	fmt.Fprintln(w, "Size Analysis:")
-	fmt.Fprintf(w, "  Incremental export: %s (new layers only)\n", formatSize(result.NewLayersSize))
-	fmt.Fprintf(w, "  Full export:        %s (all layers)\n", formatSize(result.TotalNewImageSize))
+	fmt.Fprintf(w, "  Incremental export: %s (new layers only)\n", humanize.Size(result.NewLayersSize))
+	fmt.Fprintf(w, "  Full export:        %s (all layers)\n", humanize.Size(result.TotalNewImageSize))
 	fmt.Fprintf(w, "  Space savings:      %s (%.1f%%)\n",
-		formatSize(result.SavingsSize),
+		humanize.Size(result.SavingsSize),
 		result.SavingsPercentage,
 	)
 
 	return nil
 }
-
-// formatSize formats a byte size into a human-readable string
-func formatSize(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
@@ -0,0 +1,74 @@
+// Package format renders command output through the Go templates given with
+// --format, for scripts that need a few fields without parsing JSON
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+)
+
+// Template is a parsed --format value
+type Template struct {
+	tmpl *template.Template
+}
+
+// funcs are the helpers templates can use besides the text/template builtins
+var funcs = template.FuncMap{
+	"json":  toJSON,
+	"size":  humanize.Size,
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"short": short,
+}
+
+// Parse parses a --format value. \t and \n are expanded, since tabs and
+// newlines are awkward to pass through a shell.
+func Parse(text string) (*Template, error) {
+	text = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(text)
+	tmpl, err := template.New("format").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format template: %w", err)
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Execute renders data and ends the output with a newline
+func (t *Template) Execute(w io.Writer, data any) error {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return fmt.Errorf("failed to render --format template: %w", err)
+	}
+	out := b.String()
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	_, err := io.WriteString(w, out)
+	return err
+}
+
+// toJSON renders a value as compact JSON, e.g. {{json .Bundle.Annotations}}
+func toJSON(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// short abbreviates a digest to the 12 hex characters commands print
+func short(value any) string {
+	digest := fmt.Sprint(value)
+	if i := strings.Index(digest, ":"); i >= 0 {
+		digest = digest[i+1:]
+	}
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return digest
+}
@@ -0,0 +1,118 @@
+// Package humanize formats sizes, rates, durations and ages for output, so
+// every command rounds and labels them the same way
+package humanize
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// Units selects how sizes are scaled and labelled
+type Units string
+
+const (
+	Binary  Units = "binary"  // Powers of 1024: KiB, MiB, GiB
+	Decimal Units = "decimal" // Powers of 1000: kB, MB, GB
+)
+
+var units = Binary
+
+var (
+	binaryLabels  = []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	decimalLabels = []string{"kB", "MB", "GB", "TB", "PB", "EB"}
+)
+
+// Configure applies --size-units; without it IMGCD_SIZE_UNITS is used, and
+// binary units are the default
+func Configure(name string) error {
+	if name == "" {
+		name = os.Getenv("IMGCD_SIZE_UNITS")
+	}
+	switch Units(name) {
+	case "", Binary:
+		units = Binary
+	case Decimal:
+		units = Decimal
+	default:
+		return fmt.Errorf("unsupported size units %q (supported: %s, %s)", name, Binary, Decimal)
+	}
+	return nil
+}
+
+// Size formats a byte count: bytes below one unit, otherwise one decimal
+// place, e.g. "512B", "1.5KiB", "14.0MiB"
+func Size(bytes int64) string {
+	base, labels := int64(1024), binaryLabels
+	if units == Decimal {
+		base, labels = 1000, decimalLabels
+	}
+
+	if bytes < base && bytes > -base {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	// Compare what is printed, so 1023.95KiB moves on to 1.0MiB
+	value := float64(bytes) / float64(base)
+	exp := 0
+	for math.Abs(math.Round(value*10)/10) >= float64(base) && exp < len(labels)-1 {
+		value /= float64(base)
+		exp++
+	}
+	return fmt.Sprintf("%.1f%s", value, labels[exp])
+}
+
+// Rate formats the throughput of bytes moved in elapsed, e.g. "52.3MiB/s";
+// it is empty when no time elapsed
+func Rate(bytes int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return ""
+	}
+	return Size(int64(float64(bytes)/elapsed.Seconds())) + "/s"
+}
+
+// Duration formats how long something took, precise for short operations
+// and coarser for long ones: "340ms", "4.2s", "3m07s", "2h05m"
+func Duration(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	// Round before picking the unit, so 59.96s is 1m00s rather than 60.0s
+	if r := d.Round(100 * time.Millisecond); r < time.Minute {
+		return fmt.Sprintf("%.1fs", r.Seconds())
+	}
+	if r := d.Round(time.Second); r < time.Hour {
+		return fmt.Sprintf("%dm%02ds", int(r.Minutes()), int(r.Seconds())%60)
+	}
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// Ago formats how long ago t was in the configured language, falling back
+// to the date after a week
+func Ago(t time.Time) string {
+	diff := time.Since(t)
+
+	switch {
+	case diff < time.Minute:
+		return ui.T("just now")
+	case diff < time.Hour:
+		return count(int(diff.Minutes()), "1 minute ago", "%d minutes ago")
+	case diff < 24*time.Hour:
+		return count(int(diff.Hours()), "1 hour ago", "%d hours ago")
+	case diff < 7*24*time.Hour:
+		return count(int(diff.Hours()/24), "1 day ago", "%d days ago")
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+// count picks the singular or plural message for n
+func count(n int, one, many string) string {
+	if n == 1 {
+		return ui.T(one)
+	}
+	return fmt.Sprintf(ui.T(many), n)
+}
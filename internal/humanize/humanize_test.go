@@ -0,0 +1,235 @@
+package humanize
+
+import (
+	"testing"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// withUnits runs a test with the given --size-units, restoring the default
+func withUnits(t *testing.T, name string) {
+	t.Helper()
+	t.Setenv("IMGCD_SIZE_UNITS", "")
+	if err := Configure(name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Configure("") })
+}
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		units Units
+		bytes int64
+		want  string
+	}{
+		{Binary, 0, "0B"},
+		{Binary, 512, "512B"},
+		{Binary, 1023, "1023B"},
+		{Binary, 1024, "1.0KiB"},
+		{Binary, 1536, "1.5KiB"},
+		{Binary, 1048524, "1023.9KiB"},
+		{Binary, 1048575, "1.0MiB"}, // 1023.999KiB rounds up into the next unit
+		{Binary, 1 << 20, "1.0MiB"},
+		{Binary, 14 << 20, "14.0MiB"},
+		{Binary, 3 << 29, "1.5GiB"},
+		{Binary, 1<<40 - 1, "1.0TiB"},
+		{Binary, 1 << 60, "1.0EiB"},
+		{Binary, -2048, "-2.0KiB"},
+
+		{Decimal, 999, "999B"},
+		{Decimal, 1000, "1.0kB"},
+		{Decimal, 1024, "1.0kB"},
+		{Decimal, 999949, "999.9kB"},
+		{Decimal, 999950, "1.0MB"}, // 999.95kB rounds up into the next unit
+		{Decimal, 1000000, "1.0MB"},
+		{Decimal, 1500000000, "1.5GB"},
+		{Decimal, 999999999999, "1.0TB"},
+	}
+	for _, tt := range tests {
+		withUnits(t, string(tt.units))
+		if got := Size(tt.bytes); got != tt.want {
+			t.Errorf("Size(%d) in %s units = %q, want %q", tt.bytes, tt.units, got, tt.want)
+		}
+	}
+}
+
+// TestSizeReplacesOldFormatters covers the sizes on which the formatters
+// Size replaced disagreed: cli/cache.go and attest/page.go printed "%.2fGB"
+// without a space and stopped at GB, diff/formatter.go printed "%.1f GB"
+// with one. All three call sites now print what Size does.
+func TestSizeReplacesOldFormatters(t *testing.T) {
+	withUnits(t, "")
+	tests := []struct {
+		bytes             int64
+		cacheAttest, diff string // What the old formatters printed
+		want              string
+	}{
+		{1536, "1.5KB", "1.5 KB", "1.5KiB"},
+		{5 << 20, "5.0MB", "5.0 MB", "5.0MiB"},
+		{3 << 29, "1.50GB", "1.5 GB", "1.5GiB"},
+		{1 << 40, "1024.00GB", "1.0 TB", "1.0TiB"},
+		{1<<30 - 1, "1024.0MB", "1024.0 MB", "1.0GiB"},
+	}
+	for _, tt := range tests {
+		if got := Size(tt.bytes); got != tt.want {
+			t.Errorf("Size(%d) = %q, want %q (formerly %q and %q)", tt.bytes, got, tt.want, tt.cacheAttest, tt.diff)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	t.Setenv("IMGCD_SIZE_UNITS", "decimal")
+	t.Cleanup(func() { Configure(string(Binary)) })
+
+	if err := Configure(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := Size(1000); got != "1.0kB" {
+		t.Errorf("IMGCD_SIZE_UNITS=decimal: Size(1000) = %q, want 1.0kB", got)
+	}
+	if err := Configure("binary"); err != nil {
+		t.Fatal(err)
+	}
+	if got := Size(1024); got != "1.0KiB" {
+		t.Errorf("--size-units binary overrides IMGCD_SIZE_UNITS: Size(1024) = %q, want 1.0KiB", got)
+	}
+	if err := Configure("si"); err == nil {
+		t.Error("Configure(\"si\") succeeded, want an error")
+	}
+}
+
+func TestRate(t *testing.T) {
+	withUnits(t, "")
+	tests := []struct {
+		bytes   int64
+		elapsed time.Duration
+		want    string
+	}{
+		{1 << 20, time.Second, "1.0MiB/s"},
+		{3 << 20, 2 * time.Second, "1.5MiB/s"},
+		{512, time.Second, "512B/s"},
+		{1 << 20, 0, ""},
+		{1 << 20, -time.Second, ""},
+	}
+	for _, tt := range tests {
+		if got := Rate(tt.bytes, tt.elapsed); got != tt.want {
+			t.Errorf("Rate(%d, %v) = %q, want %q", tt.bytes, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0ms"},
+		{340 * time.Millisecond, "340ms"},
+		{999 * time.Millisecond, "999ms"},
+		{time.Second, "1.0s"},
+		{4200 * time.Millisecond, "4.2s"},
+		{59900 * time.Millisecond, "59.9s"},
+		{59960 * time.Millisecond, "1m00s"}, // Not 60.0s
+		{3*time.Minute + 7*time.Second, "3m07s"},
+		{59*time.Minute + 59*time.Second + 600*time.Millisecond, "1h00m"}, // Not 60m00s
+		{2*time.Hour + 5*time.Minute, "2h05m"},
+		{26*time.Hour + 29*time.Minute + 40*time.Second, "26h30m"},
+	}
+	for _, tt := range tests {
+		if got := Duration(tt.d); got != tt.want {
+			t.Errorf("Duration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestAgo(t *testing.T) {
+	t.Setenv("IMGCD_LANG", "C")
+	if err := ui.Configure(false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	old := now.Add(-8 * 24 * time.Hour)
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{now.Add(-30 * time.Second), "just now"},
+		{now.Add(-90 * time.Second), "1 minute ago"},
+		{now.Add(-5 * time.Minute), "5 minutes ago"},
+		{now.Add(-61 * time.Minute), "1 hour ago"},
+		{now.Add(-3 * time.Hour), "3 hours ago"},
+		{now.Add(-25 * time.Hour), "1 day ago"},
+		{now.Add(-3 * 24 * time.Hour), "3 days ago"},
+		{old, old.Format("2006-01-02")},
+	}
+	for _, tt := range tests {
+		if got := Ago(tt.t); got != tt.want {
+			t.Errorf("Ago(now-%v) = %q, want %q", now.Sub(tt.t).Round(time.Second), got, tt.want)
+		}
+	}
+
+	if err := ui.Configure(false, "zh-CN"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ui.Configure(false, "") })
+	if got := Ago(now.Add(-5 * time.Minute)); got != "5 分钟前" {
+		t.Errorf("Ago in zh-CN = %q, want %q", got, "5 分钟前")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"1024", 1024},
+		{"512K", 512 << 10},
+		{"512k", 512 << 10},
+		{"50M", 50 << 20},
+		{"50MB", 50 << 20},
+		{"50MiB", 50 << 20},
+		{"1.5G", 3 << 29},
+		{"4G", 4 << 30},
+		{"2T", 2 << 40},
+		{" 64M ", 64 << 20},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tt.s, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "M", "abc", "0", "-1M", "1.5X"} {
+		if got, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", s, got)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Duration
+	}{
+		{"30d", 30 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"12h", 12 * time.Hour},
+		{"90m", 90 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", tt.s, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "d", "0d", "-2w", "1.5d", "0s", "-1h", "soon"} {
+		if got, err := ParseDuration(s); err == nil {
+			t.Errorf("ParseDuration(%q) = %v, want an error", s, got)
+		}
+	}
+}
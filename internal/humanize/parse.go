@@ -0,0 +1,63 @@
+package humanize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseSize parses sizes like 512K, 50M, 1.5G, 50MB or 50MiB; all units are
+// powers of 1024, whatever --size-units prints
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	}
+
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	number = strings.TrimSuffix(number, "I")
+	unit := 1.0
+	for _, u := range units {
+		if value, ok := strings.CutSuffix(number, u.suffix); ok {
+			number, unit = value, u.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * unit), nil
+}
+
+// ParseDuration parses durations with day and week units ("30d", "2w")
+// in addition to everything time.ParseDuration accepts
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+
+	for suffix, unit := range units {
+		if value, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,287 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+// artifactManifestName is the bundle entry holding an artifact's manifest
+// exactly as the registry served it, so it keeps its digest when restored
+const artifactManifestName = "artifact-manifest.json"
+
+// errArtifactUnsupported reports an export option OCI artifacts cannot use
+var errArtifactUnsupported = errors.New("not supported for OCI artifacts")
+
+// artifactInfo describes the manifest if it is a non-image OCI artifact: its
+// config is not an image config, or it declares an artifactType. It returns
+// nil for container images.
+func artifactInfo(img v1.Image, manifest *v1.Manifest) (*bundle.Artifact, error) {
+	raw, err := img.RawManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	// v1.Manifest does not carry artifactType
+	var declared struct {
+		ArtifactType string `json:"artifactType"`
+	}
+	if err := json.Unmarshal(raw, &declared); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	configType := manifest.Config.MediaType
+	if declared.ArtifactType == "" && (configType == types.DockerConfigJSON || configType == types.OCIConfigJSON) {
+		return nil, nil
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest digest: %w", err)
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest media type: %w", err)
+	}
+
+	return &bundle.Artifact{
+		ArtifactType:    declared.ArtifactType,
+		ConfigMediaType: string(configType),
+		MediaType:       string(mediaType),
+		Digest:          digest.String(),
+	}, nil
+}
+
+// artifactBlob is a blob of an OCI artifact. Artifact blobs are opaque, so
+// the digest stands in for the DiffID the blob cache records.
+type artifactBlob struct {
+	v1.Layer
+}
+
+func (b artifactBlob) DiffID() (v1.Hash, error) {
+	return b.Digest()
+}
+
+// exportArtifact exports an OCI artifact: the config and layer blobs are
+// stored as they are, along with the raw manifest
+func (re *RemoteExporter) exportArtifact(ctx context.Context, ref, sinceRef, outDir string, img v1.Image, manifest *v1.Manifest, artifact *bundle.Artifact, opts ExportOptions) (string, error) {
+	if sinceRef != "" {
+		return "", fmt.Errorf("--since is %w", errArtifactUnsupported)
+	}
+	fmt.Printf("OCI artifact: %s\n", artifact.Type())
+
+	raw, err := img.RawManifest()
+	if err != nil {
+		return "", fmt.Errorf("failed to get manifest: %w", err)
+	}
+	config, err := partial.ConfigLayer(img)
+	if err != nil {
+		return "", fmt.Errorf("failed to get config blob: %w", err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return "", fmt.Errorf("failed to get layers: %w", err)
+	}
+
+	// The config is a blob like any other: its media type is what identifies the artifact
+	blobs := []v1.Layer{artifactBlob{config}}
+	descriptors := append([]v1.Descriptor{manifest.Config}, manifest.Layers...)
+	for _, layer := range layers {
+		blobs = append(blobs, artifactBlob{layer})
+	}
+	var layerInfos []bundle.LayerInfo
+	for _, desc := range descriptors {
+		layerInfos = append(layerInfos, bundle.LayerInfo{
+			Digest:    desc.Digest.String(),
+			Size:      desc.Size,
+			MediaType: string(desc.MediaType),
+		})
+	}
+
+	blobs, err = uniqueLayers(blobs)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("\nDownloading %d blob(s)...\n", len(blobs))
+	results, err := re.blobDownloader.DownloadBlobsWithProgress(ctx, blobs, ref, 4, os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to download blobs: %w", err)
+	}
+	fmt.Printf("All blobs downloaded/cached\n")
+	re.recordManifest(ref, opts.TargetPlatform, img)
+	countDownloads(results, opts.downloads)
+
+	createdAt := time.Now()
+	metadata := bundle.Metadata{
+		Version:        "2",
+		ImageRef:       ref,
+		BinaryPlatform: opts.recordedBinaryPlatform(),
+		Manifest:       manifest,
+		Layers:         layerInfos,
+		TotalSize:      calculateTotalSize(layerInfos),
+		CreatedAt:      createdAt.Format(time.RFC3339),
+		ExpiresAt:      formatExpiry(opts.ExpiresAt),
+		Note:           opts.Note,
+		Annotations:    opts.Annotations,
+		OnLoad:         opts.OnLoad,
+		Provenance:     opts.Provenance,
+		Artifact:       artifact,
+	}
+
+	// Artifacts have no image ID; the manifest digest identifies them instead
+	extras, err := extraEntries(opts, ref, "", artifact.Digest, createdAt)
+	if err != nil {
+		return "", err
+	}
+	extras = append([]bundleEntry{{Name: artifactManifestName, Data: raw}}, extras...)
+
+	return re.writeBundle(outDir, metadata, extras, results, opts)
+}
+
+// loadArtifact restores an OCI artifact from the blobs extracted to blobDir.
+// Container runtimes cannot load artifacts, so it is written to an OCI image
+// layout and/or pushed to a registry instead.
+func (bl *BundleLoader) loadArtifact(ctx context.Context, blobDir string, metadata *bundle.Metadata, opts LoadOptions) error {
+	artifact := metadata.Artifact
+	if opts.ArtifactLayout == "" && opts.ArtifactPush == "" {
+		return fmt.Errorf("%s is an OCI artifact (%s) that container runtimes cannot load; use --oci-layout DIR or --push REPOSITORY", metadata.ImageRef, artifact.Type())
+	}
+
+	raw, err := os.ReadFile(filepath.Join(blobDir, artifactManifestName))
+	if err != nil {
+		return fmt.Errorf("artifact manifest not found in bundle: %w", err)
+	}
+	digest, size, err := v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	if digest.String() != artifact.Digest {
+		return fmt.Errorf("artifact manifest is corrupt: expected %s, got %s", artifact.Digest, digest)
+	}
+
+	layoutDir := opts.ArtifactLayout
+	if layoutDir == "" {
+		layoutDir = filepath.Join(blobDir, "oci-layout")
+	}
+	p, err := openLayout(layoutDir)
+	if err != nil {
+		return fmt.Errorf("failed to open OCI layout %s: %w", layoutDir, err)
+	}
+
+	fmt.Fprintf(bl.out, "\nVerifying %d blob(s)...\n", len(metadata.Layers))
+	for _, blob := range metadata.Layers {
+		hash, err := v1.NewHash(blob.Digest)
+		if err != nil {
+			return fmt.Errorf("invalid blob digest %s: %w", blob.Digest, err)
+		}
+		blobPath := filepath.Join(blobDir, hash.Hex)
+		if err := checkBlobDigest(blobPath, blob.Digest); err != nil {
+			return err
+		}
+		f, err := os.Open(blobPath)
+		if err != nil {
+			return err
+		}
+		if err := p.WriteBlob(hash, f); err != nil {
+			return fmt.Errorf("failed to write blob %s: %w", blob.Digest, err)
+		}
+	}
+	if err := p.WriteBlob(digest, io.NopCloser(bytes.NewReader(raw))); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	// Tag the manifest in index.json, replacing an earlier load of the same tag
+	_, tag := parseReference(metadata.ImageRef)
+	desc := v1.Descriptor{
+		MediaType:    types.MediaType(artifact.MediaType),
+		Size:         size,
+		Digest:       digest,
+		ArtifactType: artifact.ArtifactType,
+		Annotations:  map[string]string{"org.opencontainers.image.ref.name": tag},
+	}
+	if err := p.RemoveDescriptors(match.Name(tag)); err != nil {
+		return fmt.Errorf("failed to update OCI layout index: %w", err)
+	}
+	if err := p.AppendDescriptor(desc); err != nil {
+		return fmt.Errorf("failed to update OCI layout index: %w", err)
+	}
+	if opts.ArtifactLayout != "" {
+		fmt.Fprintf(bl.out, "Stored artifact in OCI layout %s as %s\n", layoutDir, tag)
+	}
+
+	if opts.ArtifactPush != "" {
+		target := artifactTarget(opts.ArtifactPush, tag)
+		ref, err := name.ParseReference(target)
+		if err != nil {
+			return fmt.Errorf("invalid --push reference %s: %w", target, err)
+		}
+		img, err := p.Image(digest)
+		if err != nil {
+			return fmt.Errorf("failed to read artifact from OCI layout: %w", err)
+		}
+
+		fmt.Fprintf(bl.out, "Pushing artifact to %s...\n", ref)
+		if err := remote.Write(ref, img, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+			return fmt.Errorf("failed to push artifact: %w", err)
+		}
+		fmt.Fprintf(bl.out, "Pushed %s@%s\n", ref.Context(), digest)
+	}
+
+	fmt.Fprintf(bl.out, "Successfully loaded artifact: %s\n", metadata.ImageRef)
+	return nil
+}
+
+// openLayout opens the OCI image layout at dir, creating it if needed
+func openLayout(dir string) (layout.Path, error) {
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		return layout.FromPath(dir)
+	}
+	return layout.Write(dir, empty.Index)
+}
+
+// artifactTarget keeps the bundle's tag when --push names only a repository
+func artifactTarget(push, tag string) string {
+	if strings.Contains(push, "@") || strings.Contains(path.Base(push), ":") {
+		return push
+	}
+	return push + ":" + tag
+}
+
+// checkBlobDigest reports whether the file content matches its digest
+func checkBlobDigest(path, digest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); actual != digest {
+		return fmt.Errorf("blob %s is corrupt: content hashes to %s", digest, actual)
+	}
+	return nil
+}
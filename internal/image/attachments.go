@@ -0,0 +1,119 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extrasPrefix is where attached files are stored in the image data of a bundle
+const extrasPrefix = "extras/"
+
+// attachmentEntries lists the files to attach: a file is stored under its
+// base name, a directory recursively under its base name
+func attachmentEntries(paths []string) ([]bundleEntry, error) {
+	var entries []bundleEntry
+	seen := make(map[string]string)
+	for _, root := range paths {
+		root = filepath.Clean(root)
+		base := filepath.Base(root)
+		if other, ok := seen[base]; ok {
+			return nil, fmt.Errorf("attachments %s and %s would both be stored as %s", other, root, extrasPrefix+base)
+		}
+		seen[base] = root
+
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := os.Stat(p)
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("%s is not a regular file", p)
+			}
+
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, bundleEntry{
+				Name: extrasPrefix + path.Join(base, filepath.ToSlash(rel)),
+				Path: p,
+				Mode: int64(info.Mode().Perm()),
+				Size: info.Size(),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to attach %s: %w", root, err)
+		}
+	}
+	return entries, nil
+}
+
+// attachmentsDigest identifies the names, modes and content of the attached
+// files, so a changed attachment invalidates a prepared bundle
+func attachmentsDigest(paths []string) (string, error) {
+	if len(paths) == 0 {
+		return "", nil
+	}
+	entries, err := attachmentEntries(paths)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	for _, entry := range entries {
+		fmt.Fprintf(hasher, "%s %o %d\n", entry.Name, entry.Mode, entry.Size)
+		f, err := os.Open(entry.Path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hasher, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// extractAttachment writes an extras/ entry below dir, refusing names that
+// would escape it
+func extractAttachment(r io.Reader, header *tar.Header, dir string) error {
+	rel := path.Clean(strings.TrimPrefix(header.Name, extrasPrefix))
+	if rel == "." || path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("refusing to extract attachment %s outside %s", header.Name, dir)
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	mode := fs.FileMode(header.Mode).Perm() | 0600
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if err := out.Chmod(mode); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,162 @@
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/runtime"
+)
+
+// AutoLocalSince is the --since value that picks the base among the images
+// of the same repository in the local runtime
+const AutoLocalSince = "auto-local"
+
+// targetLayers are the layers of the image being saved; sizes is nil when
+// the registry could not be asked and only the local DiffIDs are known
+type targetLayers struct {
+	diffIDs []v1.Hash
+	sizes   []int64
+}
+
+// sizeAfter returns the size of the layers a bundle stores when the first
+// shared layers come from the base
+func (t targetLayers) sizeAfter(shared int) int64 {
+	var size int64
+	for _, layerSize := range t.sizes[shared:] {
+		size += layerSize
+	}
+	return size
+}
+
+// ChooseLocalBase picks the --since base for newRef among the local images
+// of its repository: the one that leaves the smallest bundle, or without
+// layer sizes the one sharing the most leading layers. It prints every
+// candidate and the decision, and returns "" for a full export when no
+// local image shares a leading layer.
+func (e *Exporter) ChooseLocalBase(ctx context.Context, newRef, platform string) (string, error) {
+	lister, ok := e.runtime.(runtime.ImageLister)
+	if !ok {
+		return "", fmt.Errorf("--since %s: the %s runtime cannot list its images", AutoLocalSince, e.runtime.Name())
+	}
+
+	target, err := e.targetLayers(ctx, lister, newRef, platform)
+	if err != nil {
+		return "", err
+	}
+
+	repo, _ := parseReference(newRef)
+	refs, err := lister.ListImages(ctx, repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to list local images of %s: %w", repo, err)
+	}
+
+	fmt.Printf("Looking for a base among the local images of %s...\n", repo)
+	best, bestShared := "", 0
+	var bestSize int64
+	for _, ref := range refs {
+		if sameImageRef(ref, newRef) {
+			continue
+		}
+		diffIDs, err := lister.ImageDiffIDs(ctx, ref, platform)
+		if err != nil {
+			fmt.Printf("  %s: skipped (%v)\n", ref, err)
+			continue
+		}
+		hashes, err := parseHashes(diffIDs)
+		if err != nil {
+			fmt.Printf("  %s: skipped (%v)\n", ref, err)
+			continue
+		}
+
+		shared := sharedPrefixLength(hashes, target.diffIDs)
+		if target.sizes == nil {
+			fmt.Printf("  %s: %d/%d layers shared\n", ref, shared, len(target.diffIDs))
+			if shared > bestShared {
+				best, bestShared = ref, shared
+			}
+			continue
+		}
+		size := target.sizeAfter(shared)
+		fmt.Printf("  %s: %d/%d layers shared, %s to store\n", ref, shared, len(target.diffIDs), humanize.Size(size))
+		if shared > 0 && (best == "" || size < bestSize) {
+			best, bestShared, bestSize = ref, shared, size
+		}
+	}
+
+	switch {
+	case best == "":
+		fmt.Printf("No local image shares layers with %s, saving a full bundle\n", newRef)
+	case target.sizes == nil:
+		fmt.Printf("Selected --since %s (%d shared layers)\n", best, bestShared)
+	default:
+		fmt.Printf("Selected --since %s (%s to store instead of %s)\n", best, humanize.Size(bestSize), humanize.Size(target.sizeAfter(0)))
+	}
+	return best, nil
+}
+
+// targetLayers reads the image's layers from the registry, where remote
+// mode saves it from, or else from the local runtime without sizes
+func (e *Exporter) targetLayers(ctx context.Context, lister runtime.ImageLister, ref, platform string) (targetLayers, error) {
+	p, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return targetLayers{}, fmt.Errorf("failed to parse platform: %w", err)
+	}
+
+	img, err := fetchImage(ctx, ref, p)
+	if err == nil {
+		manifest, err := img.Manifest()
+		if err != nil {
+			return targetLayers{}, fmt.Errorf("failed to get manifest: %w", err)
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			return targetLayers{}, fmt.Errorf("failed to get config: %w", err)
+		}
+		if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+			return targetLayers{}, fmt.Errorf("%s has %d layers but %d DiffIDs", ref, len(manifest.Layers), len(config.RootFS.DiffIDs))
+		}
+		target := targetLayers{diffIDs: config.RootFS.DiffIDs}
+		for _, layer := range manifest.Layers {
+			target.sizes = append(target.sizes, layer.Size)
+		}
+		return target, nil
+	}
+
+	diffIDs, localErr := lister.ImageDiffIDs(ctx, ref, platform)
+	if localErr != nil {
+		return targetLayers{}, fmt.Errorf("failed to read %s from the registry (%v) or the local runtime: %w", ref, err, localErr)
+	}
+	fmt.Printf("%s is not in the registry; comparing local layers without sizes\n", ref)
+	hashes, err := parseHashes(diffIDs)
+	if err != nil {
+		return targetLayers{}, err
+	}
+	return targetLayers{diffIDs: hashes}, nil
+}
+
+// parseHashes parses the DiffIDs a runtime reports
+func parseHashes(digests []string) ([]v1.Hash, error) {
+	hashes := make([]v1.Hash, len(digests))
+	for i, digest := range digests {
+		hash, err := v1.NewHash(digest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid layer digest %q: %w", digest, err)
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+// sameImageRef reports whether two references name the same tag, e.g.
+// alpine:3.20 and docker.io/library/alpine:3.20
+func sameImageRef(a, b string) bool {
+	refA, errA := name.ParseReference(a)
+	refB, errB := name.ParseReference(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return refA.Name() == refB.Name()
+}
@@ -2,7 +2,10 @@ package image
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"context"
+	"debug/elf"
+	"debug/macho"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,11 +13,33 @@ import (
 	"path/filepath"
 	goruntime "runtime"
 	"strings"
+	"time"
+
+	"github.com/klauspost/pgzip"
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/retry"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
 )
 
+// defaultReleaseURL is where release assets are downloaded from
+const defaultReleaseURL = "https://github.com/so2liu/imgcd/releases/download"
+
 // BundleGenerator generates tar bundles containing imgcd binary and image data
 type BundleGenerator struct {
 	version string
+	signKey string // Ed25519 private key (PEM) to embed a signature with, empty to not sign
+	strict  bool   // Refuse an imgcd binary that cannot run on the target platform
+
+	// binaryPath is the imgcd binary resolved before the bundle was built,
+	// empty to resolve it when writing
+	binaryPath string
+
+	// tee receives a copy of the bundle file GenerateBundle or
+	// GenerateSelfExtractor writes, nil for none
+	tee func(name string) io.Writer
 }
 
 // NewBundleGenerator creates a new bundle generator
@@ -29,44 +54,119 @@ func (bg *BundleGenerator) GenerateBundle(imageTarGzPath, outputPath, targetPlat
 	fmt.Printf("Creating bundle...\n")
 
 	// Get imgcd binary for target platform
-	binaryPath, err := bg.getOrDownloadBinary(targetPlatform)
+	binaryPath, err := bg.binary(targetPlatform)
 	if err != nil {
-		return fmt.Errorf("failed to get imgcd binary: %w", err)
+		return err
 	}
 
-	// Create output tar file
-	outFile, err := os.Create(outputPath)
+	// Create output tar file next to its final path
+	outFile, err := createStaged(outputPath, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
-	defer outFile.Close()
+	defer outFile.Abort()
+
+	if err := writeBundleTar(bg.teed(outFile, outputPath), binaryPath, imageTarGzPath, bg.signKey); err != nil {
+		return err
+	}
+
+	// Get final size
+	finalInfo, err := outFile.Stat()
+	if err := outFile.Commit(); err != nil {
+		return err
+	}
+	if err == nil {
+		fmt.Printf("Bundle created successfully (%s)\n", humanize.Size(finalInfo.Size()))
+	}
+
+	return nil
+}
+
+// teed adds the tee of the generator to w, the writer of path
+func (bg *BundleGenerator) teed(w io.Writer, path string) io.Writer {
+	if bg.tee == nil {
+		return w
+	}
+	return io.MultiWriter(w, bg.tee(filepath.Base(path)))
+}
+
+// StreamBundle writes the bundle GenerateBundle would create to w
+func (bg *BundleGenerator) StreamBundle(w io.Writer, imageTarGzPath, targetPlatform string) error {
+	fmt.Printf("Streaming bundle...\n")
+	binaryPath, err := bg.binary(targetPlatform)
+	if err != nil {
+		return err
+	}
+	return writeBundleTar(w, binaryPath, imageTarGzPath, bg.signKey)
+}
 
-	// Create tar writer
-	tw := tar.NewWriter(outFile)
-	defer tw.Close()
+// writeBundleTar writes the tar of a bundle: the imgcd binary, then the
+// image data and the checksums of both, then with signKey the signature of
+// all three
+func writeBundleTar(w io.Writer, binaryPath, imageTarGzPath, signKey string) error {
+	tw := tar.NewWriter(w)
+	addFile := addFileToTar
+	var signer *bundleSigner
+	if signKey != "" {
+		signer = &bundleSigner{keyPath: signKey}
+		addFile = signer.addFile
+	}
 
 	// Add imgcd binary
 	fmt.Printf("Adding imgcd binary...\n")
-	if err := addFileToTar(tw, binaryPath, "imgcd", 0755); err != nil {
+	if err := addFile(tw, binaryPath, "imgcd", 0755); err != nil {
 		return fmt.Errorf("failed to add imgcd binary: %w", err)
 	}
 
 	// Add image tar.gz
 	fmt.Printf("Adding image data...\n")
-	if err := addFileToTar(tw, imageTarGzPath, "image.tar.gz", 0644); err != nil {
+	if err := addFile(tw, imageTarGzPath, "image.tar.gz", 0644); err != nil {
 		return fmt.Errorf("failed to add image data: %w", err)
 	}
 
-	// Get final size
-	finalInfo, err := outFile.Stat()
-	if err == nil {
-		sizeMB := float64(finalInfo.Size()) / (1024 * 1024)
-		fmt.Printf("Bundle created successfully (%.1f MB)\n", sizeMB)
+	// Checked by load before anything is imported, so a bundle corrupted in
+	// transfer fails early instead of loading broken images
+	fmt.Printf("Adding checksums...\n")
+	checksumsPath, err := writeChecksumsFile(binaryPath, imageTarGzPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(checksumsPath)
+	if err := addFile(tw, checksumsPath, bundle.ChecksumsName, 0644); err != nil {
+		return fmt.Errorf("failed to add checksums: %w", err)
 	}
 
+	if signer != nil {
+		fmt.Printf("Signing bundle...\n")
+		if err := signer.writeSignature(tw); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
 	return nil
 }
 
+// generateBundle writes the bundle of an export to bundlePath, or to
+// opts.Stream when it is streamed as it is, without a self-extractor
+func generateBundle(version, imageTarGzPath, bundlePath, imageName string, opts ExportOptions) error {
+	bundleGen := NewBundleGenerator(version)
+	bundleGen.signKey = opts.SignKey
+	bundleGen.strict = opts.StrictPlatform
+	if opts.binary != nil {
+		bundleGen.binaryPath = opts.binary.path
+	}
+	if opts.Stream != nil && !opts.SelfExtracting {
+		return bundleGen.StreamBundle(opts.Stream, imageTarGzPath, opts.binaryTarget())
+	}
+	// A self-extractor wraps this tar, and is what is teed
+	if !opts.SelfExtracting {
+		bundleGen.tee = opts.Tee
+	}
+	return bundleGen.GenerateBundle(imageTarGzPath, bundlePath, opts.binaryTarget(), imageName)
+}
+
 // addFileToTar adds a file to a tar archive
 func addFileToTar(tw *tar.Writer, filePath, tarPath string, mode int64) error {
 	file, err := os.Open(filePath)
@@ -80,13 +180,7 @@ func addFileToTar(tw *tar.Writer, filePath, tarPath string, mode int64) error {
 		return err
 	}
 
-	header := &tar.Header{
-		Name: tarPath,
-		Mode: mode,
-		Size: info.Size(),
-	}
-
-	if err := tw.WriteHeader(header); err != nil {
+	if err := tw.WriteHeader(fileHeader(tarPath, mode, info.Size())); err != nil {
 		return err
 	}
 
@@ -94,9 +188,97 @@ func addFileToTar(tw *tar.Writer, filePath, tarPath string, mode int64) error {
 	return err
 }
 
+// bundleBinary is the imgcd binary of a bundle and the platform it is for
+type bundleBinary struct {
+	path     string
+	platform string // Read from the executable; empty if it could not be
+}
+
+// binary returns the imgcd binary to bundle for platform, checked with
+// resolveBinary unless it was resolved before
+func (bg *BundleGenerator) binary(platform string) (string, error) {
+	if bg.binaryPath != "" {
+		return bg.binaryPath, nil
+	}
+	binary, err := bg.resolveBinary(platform)
+	if err != nil {
+		return "", err
+	}
+	return binary.path, nil
+}
+
+// resolveBinary gets the imgcd binary for platform and reads which platform
+// it really is for: IMGCD_BINARY_PATH and the binary of dev builds can be
+// for any. A mismatch is refused when strict, and warned about otherwise.
+func (bg *BundleGenerator) resolveBinary(platform string) (*bundleBinary, error) {
+	path, err := bg.getOrDownloadBinary(platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get imgcd binary: %w", err)
+	}
+
+	actual, err := executablePlatform(path)
+	switch {
+	case err != nil && bg.strict:
+		return nil, fmt.Errorf("cannot tell which platform the imgcd binary %s is for (%v); it must run on %s", path, err, platform)
+	case err != nil:
+		ui.Fwarning(os.Stdout, "cannot tell which platform the imgcd binary %s is for: %v", path, err)
+	case actual != platform && bg.strict:
+		return nil, fmt.Errorf("the imgcd binary %s is for %s and cannot run on %s: set IMGCD_BINARY_PATH to a %s build, or pass --strict-platform=false", path, actual, platform, platform)
+	case actual != platform:
+		ui.Fwarning(os.Stdout, "the bundled imgcd is for %s, this bundle will only work on %s systems", actual, actual)
+	}
+	return &bundleBinary{path: path, platform: actual}, nil
+}
+
+// executablePlatform reads the os/arch an executable was built for from its
+// ELF or Mach-O header
+func executablePlatform(path string) (string, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		arch, ok := elfArchs[f.Machine]
+		if !ok {
+			return "", fmt.Errorf("unsupported ELF machine %s", f.Machine)
+		}
+		return "linux/" + arch, nil
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		arch, ok := machoArchs[f.Cpu]
+		if !ok {
+			return "", fmt.Errorf("unsupported Mach-O CPU %s", f.Cpu)
+		}
+		return "darwin/" + arch, nil
+	}
+	return "", errors.New("neither an ELF nor a Mach-O executable")
+}
+
+// elfArchs and machoArchs map executable machine types to GOARCH
+var (
+	elfArchs = map[elf.Machine]string{
+		elf.EM_X86_64:  "amd64",
+		elf.EM_AARCH64: "arm64",
+		elf.EM_386:     "386",
+		elf.EM_ARM:     "arm",
+		elf.EM_RISCV:   "riscv64",
+	}
+	machoArchs = map[macho.Cpu]string{
+		macho.CpuAmd64: "amd64",
+		macho.CpuArm64: "arm64",
+	}
+)
+
 // getOrDownloadBinary gets the imgcd binary for the specified platform
 // It first checks the cache, and downloads if not found
 func (bg *BundleGenerator) getOrDownloadBinary(platform string) (string, error) {
+	// A pre-built binary always wins, e.g. on build machines without internet access
+	if customPath := os.Getenv("IMGCD_BINARY_PATH"); customPath != "" {
+		if _, err := os.Stat(customPath); err != nil {
+			return "", fmt.Errorf("custom binary not found at %s: %w", customPath, err)
+		}
+		fmt.Printf("Using custom binary from IMGCD_BINARY_PATH for %s\n", platform)
+		return customPath, nil
+	}
+
 	// In development mode (version == "dev"), use the current binary
 	if bg.version == "dev" {
 		return bg.useCurrentBinary(platform)
@@ -123,29 +305,15 @@ func (bg *BundleGenerator) getOrDownloadBinary(platform string) (string, error)
 
 // useCurrentBinary uses the current imgcd binary for development mode
 func (bg *BundleGenerator) useCurrentBinary(platform string) (string, error) {
-	// Check if user provided a pre-built binary for the target platform
-	if customPath := os.Getenv("IMGCD_BINARY_PATH"); customPath != "" {
-		if _, err := os.Stat(customPath); err != nil {
-			return "", fmt.Errorf("custom binary not found at %s: %w", customPath, err)
-		}
-		fmt.Printf("Development mode: using custom binary from IMGCD_BINARY_PATH\n")
-		return customPath, nil
-	}
-
 	// In dev mode, use current binary regardless of platform
-	// This is for development convenience - the bundle may not work on different platforms
+	// This is for development convenience
 	execPath, err := os.Executable()
 	if err != nil {
 		return "", fmt.Errorf("failed to get executable path: %w", err)
 	}
 
-	currentPlatform := detectCurrentPlatform()
-	if currentPlatform != platform {
-		fmt.Printf("Development mode: using current binary (%s) for target platform (%s)\n", currentPlatform, platform)
-		fmt.Printf("Warning: This bundle will only work on %s systems\n", currentPlatform)
-	} else {
-		fmt.Printf("Development mode: using current platform binary (%s)\n", currentPlatform)
-	}
+	// resolveBinary checks that it fits the target platform
+	fmt.Printf("Development mode: using current binary (%s) for target platform (%s)\n", detectCurrentPlatform(), platform)
 
 	return execPath, nil
 }
@@ -155,6 +323,17 @@ func detectCurrentPlatform() string {
 	return fmt.Sprintf("%s/%s", goruntime.GOOS, goruntime.GOARCH)
 }
 
+// ImagePlatform returns the platform of the container images a host of
+// target runs: macOS runtimes (Docker Desktop, Colima, OrbStack) run linux
+// images in a VM of the Mac's architecture, so darwin/arm64 maps to
+// linux/arm64. Other platforms are returned as they are.
+func ImagePlatform(target string) string {
+	if arch, ok := strings.CutPrefix(target, "darwin/"); ok {
+		return "linux/" + arch
+	}
+	return target
+}
+
 // getPlatformOS extracts OS from platform string
 func getPlatformOS(platform string) string {
 	parts := strings.Split(platform, "/")
@@ -193,7 +372,7 @@ func (bg *BundleGenerator) downloadBinary(platform, outputPath string) error {
 		version = "v" + version
 	}
 
-	url := fmt.Sprintf("https://github.com/so2liu/imgcd/releases/download/%s/%s", version, filename)
+	url := fmt.Sprintf("%s/%s/%s", releaseBaseURL(), version, filename)
 
 	// Create temporary directory for download
 	tempDir, err := os.MkdirTemp("", "imgcd-download-*")
@@ -205,56 +384,163 @@ func (bg *BundleGenerator) downloadBinary(platform, outputPath string) error {
 	// Download tar.gz
 	tarGzPath := filepath.Join(tempDir, filename)
 	if err := downloadFile(url, tarGzPath); err != nil {
-		return fmt.Errorf("failed to download binary from %s: %w", url, err)
+		return downloadFailure(url, platform, err)
 	}
 
-	// Extract binary from tar.gz
+	// Verify against the checksum published with the release
+	expected, err := fetchChecksum(url + ".sha256")
+	if err != nil {
+		return downloadFailure(url+".sha256", platform, err)
+	}
+	actual, err := checksum.File(tarGzPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", filename, err)
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected sha256:%s, got sha256:%s", filename, expected, actual)
+	}
+	ui.Success("Checksum verified (sha256:%s)", actual[:12])
+
+	// Extract next to the cache entry and rename, so an interrupted
+	// extraction never leaves a truncated binary in the cache
 	binaryName := fmt.Sprintf("imgcd-%s-%s", osName, arch)
-	if err := extractBinaryFromTarGz(tarGzPath, binaryName, outputPath); err != nil {
+	partialPath := outputPath + ".partial"
+	if err := extractBinaryFromTarGz(tarGzPath, binaryName, partialPath); err != nil {
+		os.Remove(partialPath)
 		return fmt.Errorf("failed to extract binary: %w", err)
 	}
+	if err := os.Rename(partialPath, outputPath); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("failed to cache binary: %w", err)
+	}
 
 	fmt.Printf("Binary downloaded and cached successfully\n")
 	return nil
 }
 
-// getCacheDir returns the cache directory for imgcd binaries
+// releaseBaseURL returns the base URL of release assets
+// IMGCD_RELEASE_MIRROR points it at a mirror with the same {version}/{file} layout
+func releaseBaseURL() string {
+	if mirror := os.Getenv("IMGCD_RELEASE_MIRROR"); mirror != "" {
+		return strings.TrimSuffix(mirror, "/")
+	}
+	return defaultReleaseURL
+}
+
+// downloadFailure explains how to build bundles without access to GitHub
+func downloadFailure(url, platform string, err error) error {
+	return fmt.Errorf("failed to download %s: %w\n"+
+		"  To build bundles without access to GitHub, either:\n"+
+		"    - set IMGCD_BINARY_PATH to a pre-built imgcd binary for %s, or\n"+
+		"    - set IMGCD_RELEASE_MIRROR to a mirror of the release assets\n"+
+		"  HTTPS_PROXY and NO_PROXY are honored for the download", url, err, platform)
+}
+
+// getCacheDir returns the cache directory for imgcd binaries. Under
+// --no-state downloads go to the temp directory instead.
 func (bg *BundleGenerator) getCacheDir() string {
-	homeDir, err := os.UserHomeDir()
+	stateDir, err := state.Dir()
+	if errors.Is(err, state.ErrDisabled) {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("imgcd-bin-%d", os.Getuid()))
+	}
 	if err != nil {
-		homeDir = "."
+		stateDir = ".imgcd"
 	}
-	return filepath.Join(homeDir, ".imgcd", "bin")
+	return filepath.Join(stateDir, "bin")
 }
 
-// downloadFile downloads a file from a URL
-func downloadFile(url, filepath string) error {
+// downloadClient honors HTTP(S)_PROXY/NO_PROXY and bounds how long a
+// stalled server can hold a download attempt
+var downloadClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// downloadFile downloads a file from a URL, retrying transient failures
+func downloadFile(url, filePath string) error {
 	// Create directory
-	if err := os.MkdirAll(filepath[:strings.LastIndex(filepath, "/")], 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return err
 	}
 
-	// Download
-	resp, err := http.Get(url)
-	if err != nil {
+	return httpGet(url, func(body io.Reader) error {
+		// Create file (truncating any partial previous attempt)
+		out, err := os.Create(filePath)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		defer out.Close()
+
+		// Write data
+		_, err = io.Copy(out, body)
 		return err
-	}
-	defer resp.Body.Close()
+	})
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %s", resp.Status)
+// fetchChecksum downloads a sha256sum-style file and returns the hex digest
+func fetchChecksum(url string) (string, error) {
+	var content []byte
+	err := httpGet(url, func(body io.Reader) error {
+		var err error
+		content, err = io.ReadAll(io.LimitReader(body, 4096))
+		return err
+	})
+	if err != nil {
+		return "", err
 	}
 
-	// Create file
-	out, err := os.Create(filepath)
-	if err != nil {
-		return err
+	return checksum.Parse(content)
+}
+
+// ErrNotFound reports a download the server answered with 404
+var ErrNotFound = errors.New("download failed with status: 404 Not Found")
+
+// httpGet fetches url and hands the body to consume, retrying network
+// errors, rate limiting and server errors with exponential backoff
+func httpGet(url string, consume func(io.Reader) error) error {
+	policy := retry.DefaultPolicy
+	policy.OnRetry = func(attempt int, delay time.Duration, err error) {
+		fmt.Printf("Download attempt %d failed (%v), retrying in %s...\n", attempt, err, delay)
 	}
-	defer out.Close()
 
-	// Write data
-	_, err = io.Copy(out, resp.Body)
-	return err
+	return retry.Do(context.Background(), policy, func(int) error {
+		resp, err := downloadClient.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			return retry.Permanent(ErrNotFound)
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := fmt.Errorf("download failed with status: %s", resp.Status)
+			if !retryableStatus(resp) {
+				return retry.Permanent(err)
+			}
+			return retry.After(err, retry.ServerDelay(resp))
+		}
+
+		return consume(resp.Body)
+	})
+}
+
+// retryableStatus reports whether a failed response is worth retrying
+func retryableStatus(resp *http.Response) bool {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode >= 500:
+		return true
+	case resp.StatusCode == http.StatusForbidden:
+		// GitHub signals exhausted rate limits with 403
+		return resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
 }
 
 // extractBinaryFromTarGz extracts a binary from a tar.gz archive
@@ -316,13 +602,13 @@ func extractBinaryFromTarGz(tarGzPath, binaryName, outputPath string) error {
 }
 
 // Helper functions for tar.gz extraction
-func gzipNewReader(path string) (*gzip.Reader, error) {
+func gzipNewReader(path string) (*pgzip.Reader, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return gzip.NewReader(file)
+	return pgzip.NewReader(file)
 }
 
 func tarNewReader(r io.Reader) *tar.Reader {
@@ -0,0 +1,130 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// maxChecksumsSize bounds how much of a checksums.txt is read
+const maxChecksumsSize = 64 << 20
+
+// bundleChecksums returns the checksums.txt of a bundle: the sha256 of its
+// imgcd binary and of every entry of its image data
+func bundleChecksums(binaryPath, imageTarGzPath string) ([]byte, error) {
+	binarySum, err := checksum.File(binaryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash imgcd binary: %w", err)
+	}
+
+	image, err := os.Open(imageTarGzPath)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	br, err := bundle.NewReader(image)
+	if err != nil {
+		return nil, err
+	}
+	defer br.Close()
+	br.SkipDigests()
+	br.Checksum()
+
+	for _, err := range br.All() {
+		if err != nil {
+			return nil, err
+		}
+	}
+	sums, err := br.Checksums()
+	if err != nil {
+		return nil, err
+	}
+	sums = append([]bundle.Checksum{{Name: "imgcd", SHA256: binarySum}}, sums...)
+	return bundle.FormatChecksums(sums), nil
+}
+
+// writeChecksumsFile writes the checksums.txt of a bundle to a temporary
+// file, for adding it to the bundle tar; the caller removes it
+func writeChecksumsFile(binaryPath, imageTarGzPath string) (string, error) {
+	data, err := bundleChecksums(binaryPath, imageTarGzPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute bundle checksums: %w", err)
+	}
+	f, err := os.CreateTemp("", "imgcd-checksums-*.txt")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// siblingChecksums returns the checksums.txt next to an image.tar.gz
+// unpacked from a bundle tar, as the self-extractor does; nil when there is
+// none
+func siblingChecksums(archivePath string) ([]byte, error) {
+	if filepath.Base(archivePath) != "image.tar.gz" {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(filepath.Dir(archivePath), bundle.ChecksumsName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", bundle.ChecksumsName, err)
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, maxChecksumsSize))
+}
+
+// verifyChecksums checks the files of a bundle against its checksums.txt:
+// outer are the sums of the bundle tar's entries besides the image data
+// (its imgcd binary), inner those of the image data's entries. Files the
+// stream did not carry, like the imgcd binary of bare image data, are not
+// checked; data is nil for bundles without checksums.txt.
+func verifyChecksums(out io.Writer, data []byte, outer map[string]string, inner []bundle.Checksum) error {
+	if data == nil {
+		return nil
+	}
+	listed, err := bundle.ParseChecksums(data)
+	if err != nil {
+		return err
+	}
+
+	actual := make(map[string]string, len(outer)+len(inner))
+	for name, sum := range outer {
+		actual[name] = sum
+	}
+	for _, sum := range inner {
+		actual[sum.Name] = sum.SHA256
+	}
+	checked := 0
+	for _, sum := range listed {
+		got, ok := actual[sum.Name]
+		switch {
+		case !ok && sum.Name == "imgcd":
+			// Bare image data, unpacked from the bundle tar
+			continue
+		case !ok:
+			return fmt.Errorf("bundle is incomplete: %s is listed in %s but missing", sum.Name, bundle.ChecksumsName)
+		case got != sum.SHA256:
+			return fmt.Errorf("bundle is corrupted: %s does not match %s (expected sha256:%s, got sha256:%s)", sum.Name, bundle.ChecksumsName, sum.SHA256, got)
+		}
+		checked++
+	}
+	ui.Fsuccess(out, "Checksums verified (%d files)", checked)
+	return nil
+}
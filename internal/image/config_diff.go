@@ -0,0 +1,123 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// configChanges lists the user-visible differences between two image configs,
+// e.g. for config-only updates where every layer is shared with the base
+func configChanges(base, updated *v1.ConfigFile) []string {
+	if base == nil || updated == nil {
+		return nil
+	}
+
+	var changes []string
+	changes = append(changes, mapChanges("label", base.Config.Labels, updated.Config.Labels)...)
+	changes = append(changes, mapChanges("env", envMap(base.Config.Env), envMap(updated.Config.Env))...)
+
+	fields := []struct {
+		name        string
+		old, update string
+	}{
+		{"entrypoint", formatArgs(base.Config.Entrypoint), formatArgs(updated.Config.Entrypoint)},
+		{"cmd", formatArgs(base.Config.Cmd), formatArgs(updated.Config.Cmd)},
+		{"workdir", base.Config.WorkingDir, updated.Config.WorkingDir},
+		{"user", base.Config.User, updated.Config.User},
+		{"stop signal", base.Config.StopSignal, updated.Config.StopSignal},
+		{"exposed ports", formatKeys(base.Config.ExposedPorts), formatKeys(updated.Config.ExposedPorts)},
+	}
+	for _, field := range fields {
+		if field.old != field.update {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", field.name, orUnset(field.old), orUnset(field.update)))
+		}
+	}
+
+	return changes
+}
+
+// printConfigChanges prints the config changes of a bundle without new layers
+func printConfigChanges(base, updated *v1.ConfigFile) {
+	changes := configChanges(base, updated)
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Printf("Config changes:\n")
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+}
+
+// mapChanges describes added, changed and removed keys between two maps
+func mapChanges(kind string, old, updated map[string]string) []string {
+	keys := make(map[string]bool)
+	for key := range old {
+		keys[key] = true
+	}
+	for key := range updated {
+		keys[key] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	var changes []string
+	for _, key := range sorted {
+		oldValue, inOld := old[key]
+		newValue, inNew := updated[key]
+		if inOld && inNew && oldValue == newValue {
+			continue
+		}
+		if !inOld {
+			oldValue = ""
+		}
+		if !inNew {
+			newValue = ""
+		}
+		changes = append(changes, fmt.Sprintf("%s %s: %s -> %s", kind, key, orUnset(oldValue), orUnset(newValue)))
+	}
+	return changes
+}
+
+// envMap converts KEY=VALUE entries into a map
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		m[key] = value
+	}
+	return m
+}
+
+// formatArgs renders a command line, empty if unset
+func formatArgs(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(args, " ") + "]"
+}
+
+// formatKeys renders the sorted keys of a set, empty if unset
+func formatKeys(set map[string]struct{}) string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// orUnset renders empty values as (unset)
+func orUnset(value string) string {
+	if value == "" {
+		return "(unset)"
+	}
+	return value
+}
@@ -0,0 +1,186 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/runtime"
+)
+
+// exportFromContentStore builds a v2 bundle straight from the runtime's
+// content store. Only the manifest, the config and the layers missing from
+// the base image are read; the image is never serialized as a whole.
+func (e *Exporter) exportFromContentStore(ctx context.Context, cs runtime.ContentStore, newRef, sinceRef, outDir string, opts ExportOptions) (string, error) {
+	manifest, configFile, rawConfig, err := readContentStoreImage(ctx, cs, newRef, opts.TargetPlatform)
+	if err != nil {
+		return "", fmt.Errorf("failed to read image %s: %w", newRef, err)
+	}
+
+	// Count consecutive shared layers from the start, same as remote mode
+	sharedLayerCount := 0
+	var baseManifest *v1.Manifest
+	var baseConfig *v1.ConfigFile
+	if sinceRef != "" {
+		// Pick the base's variant by the platform the image really is
+		basePlatform := opts.TargetPlatform
+		if newPlatform := configPlatform(configFile); newPlatform != nil {
+			basePlatform = newPlatform.String()
+		}
+		if opts.sinceBundle != nil {
+			baseManifest, baseConfig = opts.sinceBundle.Manifest, opts.sinceBundle.Config
+		} else {
+			baseManifest, baseConfig, _, err = readContentStoreImage(ctx, cs, sinceRef, basePlatform)
+			if err != nil {
+				return "", fmt.Errorf("failed to read base image %s: %w", sinceRef, err)
+			}
+		}
+		if err := checkBasePlatform(newRef, configPlatform(configFile), sinceRef, configPlatform(baseConfig)); err != nil {
+			return "", err
+		}
+
+		sharedLayerCount = sharedPrefixLength(baseConfig.RootFS.DiffIDs, configFile.RootFS.DiffIDs)
+	}
+
+	// Every layer is in the base image: the bundle only needs metadata
+	if sinceRef != "" && sharedLayerCount == len(manifest.Layers) {
+		// Identical configs mean nothing at all changed
+		if opts.SkipUnchanged && manifest.Config.Digest == baseManifest.Config.Digest {
+			return "", errNoChanges
+		}
+		fmt.Printf("All layers already exist in base image. Creating config-only bundle.\n")
+		printConfigChanges(baseConfig, configFile)
+	}
+
+	// Build layer infos for all layers after the shared prefix
+	var layerInfos []bundle.LayerInfo
+	var filteredSize int64
+	sizes := make(map[string]int64)
+	for i, layer := range manifest.Layers {
+		if i < sharedLayerCount {
+			filteredSize += layer.Size
+			continue
+		}
+
+		layerInfos = append(layerInfos, bundle.LayerInfo{
+			Digest:    layer.Digest.String(),
+			DiffID:    configFile.RootFS.DiffIDs[i].String(),
+			Size:      layer.Size,
+			MediaType: string(layer.MediaType),
+		})
+		sizes[layer.Digest.String()] = layer.Size
+	}
+
+	if sinceRef != "" {
+		fmt.Printf("Filtered %d/%d layers (saved %s)\n",
+			sharedLayerCount, len(manifest.Layers),
+			humanize.Size(filteredSize))
+	}
+
+	createdAt := time.Now()
+	metadata := bundle.Metadata{
+		Version:          "2",
+		ImageRef:         newRef,
+		BaseRef:          sinceRef,
+		SharedLayerCount: sharedLayerCount,
+		Platform:         opts.TargetPlatform,
+		BinaryPlatform:   opts.recordedBinaryPlatform(),
+		Manifest:         manifest,
+		Config:           configFile,
+		RawConfig:        rawConfig,
+		Layers:           layerInfos,
+		TotalSize:        calculateTotalSize(layerInfos),
+		CreatedAt:        createdAt.Format(time.RFC3339),
+		ExpiresAt:        formatExpiry(opts.ExpiresAt),
+		Note:             opts.Note,
+		Annotations:      opts.Annotations,
+		OnLoad:           opts.OnLoad,
+		Provenance:       opts.Provenance,
+	}
+
+	extras, err := extraEntries(opts, newRef, sinceRef, manifest.Config.Digest.String(), createdAt)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	repo, tag := parseReference(newRef)
+	tarGzPath := generateFilename(repo, tag, sinceRef, outDir, true)
+
+	digests := make([]string, 0, len(layerInfos))
+	for _, info := range layerInfos {
+		digests = append(digests, info.Digest)
+	}
+
+	fmt.Printf("Copying %d layer(s) from content store...\n", len(digests))
+	err = writeBlobBundle(tarGzPath, metadata, extras, digests, opts.Checksums, func(digest string) (io.ReadCloser, int64, error) {
+		rc, err := cs.ReadBlob(ctx, digest)
+		if err != nil {
+			return nil, 0, err
+		}
+		return rc, sizes[digest], nil
+	})
+	if err != nil {
+		os.Remove(tarGzPath)
+		return "", fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	// Create tar bundle
+	fmt.Printf("Creating bundle for %s...\n", opts.TargetPlatform)
+	bundlePath := generateFilename(repo, tag, sinceRef, outDir, false)
+
+	if err := generateBundle(e.version, tarGzPath, bundlePath, newRef, opts); err != nil {
+		return "", fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	// Remove the intermediate tar.gz file
+	os.Remove(tarGzPath)
+
+	return bundlePath, nil
+}
+
+// readContentStoreImage reads the manifest and config of ref from a content
+// store, the config also as stored
+func readContentStoreImage(ctx context.Context, cs runtime.ContentStore, ref, platform string) (*v1.Manifest, *v1.ConfigFile, []byte, error) {
+	manifestBytes, err := cs.ImageManifest(ctx, ref, platform)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(manifestBytes))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	configReader, err := cs.ReadBlob(ctx, manifest.Config.Digest.String())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rawConfig, err := io.ReadAll(configReader)
+	if closeErr := configReader.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	configFile, err := v1.ParseConfigFile(bytes.NewReader(rawConfig))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if len(configFile.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, nil, nil, fmt.Errorf("config has %d DiffIDs but manifest has %d layers",
+			len(configFile.RootFS.DiffIDs), len(manifest.Layers))
+	}
+
+	return manifest, configFile, rawConfig, nil
+}
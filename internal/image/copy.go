@@ -0,0 +1,276 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// CopyOptions configures RemoteExporter.Copy
+type CopyOptions struct {
+	TargetPlatform string // Platform copied out of a manifest list
+	AllPlatforms   bool   // Copy the manifest list with every platform instead
+
+	// Output receives progress messages; os.Stdout if nil
+	Output io.Writer
+}
+
+// CopyResult describes a finished copy
+type CopyResult struct {
+	Ref       string // Destination by digest
+	Blobs     int    // Blobs the destination did not have
+	Bytes     int64  // Size of those blobs
+	FromCache int    // Blobs of them read from the blob cache instead of the source
+}
+
+// Copy transfers an image from one registry to another without a container
+// runtime or bundle. Blobs the destination already has are skipped; the
+// others go through the blob cache when it is enabled, so a retried copy or
+// a later save does not download them again, and are streamed from the
+// source otherwise. Manifests are copied as they are and keep their digest.
+// dst is a repository, optionally with a tag; src's tag is used when it has
+// none.
+func (re *RemoteExporter) Copy(ctx context.Context, src, dst string, opts CopyOptions) (*CopyResult, error) {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	defer re.lease("copy " + src)()
+
+	srcRef, err := name.ParseReference(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %s: %w", src, err)
+	}
+	getOpts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+	}
+	if !opts.AllPlatforms {
+		platform, err := v1.ParsePlatform(opts.TargetPlatform)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse platform: %w", err)
+		}
+		getOpts = append(getOpts, remote.WithPlatform(*platform))
+	}
+	desc, err := remote.Get(srcRef, getOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", src, err)
+	}
+
+	stats := &copyStats{}
+	wrap := func(layer v1.Layer) v1.Layer {
+		return &copyLayer{Layer: layer, ctx: ctx, re: re, ref: src, stats: stats}
+	}
+
+	// The manifest list is copied whole; otherwise Image() resolves the
+	// platform's manifest out of it
+	var target interface {
+		Digest() (v1.Hash, error)
+	}
+	var write func(ref name.Reference, options ...remote.Option) error
+	if opts.AllPlatforms && desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest list: %w", err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest list: %w", err)
+		}
+		fmt.Fprintf(out, "Copying manifest list of %s (%d manifests)\n", src, len(manifest.Manifests))
+		copied := &copyIndex{index: index, wrap: wrap}
+		target = copied
+		write = func(ref name.Reference, options ...remote.Option) error {
+			return remote.WriteIndex(ref, copied, options...)
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", err)
+		}
+		if !opts.AllPlatforms {
+			fmt.Fprintf(out, "Copying %s (%s)\n", src, opts.TargetPlatform)
+		} else {
+			fmt.Fprintf(out, "Copying %s (no manifest list, single platform)\n", src)
+		}
+		copied := &copyImage{Image: img, wrap: wrap}
+		target = copied
+		write = func(ref name.Reference, options ...remote.Option) error {
+			return remote.Write(ref, copied, options...)
+		}
+	}
+
+	digest, err := target.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute manifest digest: %w", err)
+	}
+	dstName := dst
+	if artifactTarget(dst, "") != dst {
+		// dst names no tag: keep the source's, or push by digest
+		if tag, ok := srcRef.(name.Tag); ok {
+			dstName = dst + ":" + tag.TagStr()
+		} else {
+			dstName = dst + "@" + digest.String()
+		}
+	}
+	dstRef, err := name.ParseReference(dstName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %s: %w", dstName, err)
+	}
+
+	fmt.Fprintf(out, "Pushing to %s...\n", dstRef)
+	progress := make(chan v1.Update, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for update := range progress {
+			if update.Total > 0 {
+				fmt.Fprintf(os.Stderr, "Progress: %d/%d bytes uploaded\r", update.Complete, update.Total)
+			}
+		}
+	}()
+	err = write(dstRef,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithProgress(progress),
+	)
+	<-done
+	if err != nil {
+		return nil, fmt.Errorf("failed to push to %s: %w", dstRef, err)
+	}
+	fmt.Fprintf(out, "\n")
+
+	return &CopyResult{
+		Ref:       dstRef.Context().Digest(digest.String()).String(),
+		Blobs:     stats.blobs,
+		Bytes:     stats.bytes,
+		FromCache: stats.fromCache,
+	}, nil
+}
+
+// copyStats counts the blobs a copy transferred; layers upload concurrently
+type copyStats struct {
+	mu        sync.Mutex
+	blobs     int
+	bytes     int64
+	fromCache int
+}
+
+func (s *copyStats) add(size int64, fromCache bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs++
+	s.bytes += size
+	if fromCache {
+		s.fromCache++
+	}
+}
+
+// copyLayer is a layer of the source image. The registry client asks for
+// its content only when the destination lacks the blob.
+type copyLayer struct {
+	v1.Layer
+	ctx   context.Context
+	re    *RemoteExporter
+	ref   string
+	stats *copyStats
+}
+
+func (l *copyLayer) Compressed() (io.ReadCloser, error) {
+	size, err := l.Size()
+	if err != nil {
+		return nil, err
+	}
+	if !l.re.blobCache.Enabled() {
+		l.stats.add(size, false)
+		return l.Layer.Compressed()
+	}
+
+	// Blobs of artifacts and attestations have no DiffID
+	cached := l.Layer
+	if _, err := cached.DiffID(); err != nil {
+		cached = artifactBlob{l.Layer}
+	}
+	results, err := l.re.blobDownloader.DownloadBlobs(l.ctx, []v1.Layer{cached}, l.ref, 1)
+	if err != nil {
+		return nil, err
+	}
+	l.stats.add(size, results[0].FromCache)
+	return l.re.blobDownloader.GetCachedBlobReader(results[0].Digest)
+}
+
+// copyImage is a source image whose layers are copyLayers
+type copyImage struct {
+	v1.Image
+	wrap func(v1.Layer) v1.Layer
+}
+
+func (i *copyImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]v1.Layer, len(layers))
+	for j, layer := range layers {
+		wrapped[j] = i.wrap(layer)
+	}
+	return wrapped, nil
+}
+
+func (i *copyImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	layer, err := i.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	return i.wrap(layer), nil
+}
+
+// copyIndex is a source manifest list whose images are copyImages
+type copyIndex struct {
+	index v1.ImageIndex
+	wrap  func(v1.Layer) v1.Layer
+}
+
+func (i *copyIndex) MediaType() (types.MediaType, error) {
+	return i.index.MediaType()
+}
+
+func (i *copyIndex) Digest() (v1.Hash, error) {
+	return i.index.Digest()
+}
+
+func (i *copyIndex) Size() (int64, error) {
+	return i.index.Size()
+}
+
+func (i *copyIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return i.index.IndexManifest()
+}
+
+func (i *copyIndex) RawManifest() ([]byte, error) {
+	return i.index.RawManifest()
+}
+
+func (i *copyIndex) Image(h v1.Hash) (v1.Image, error) {
+	img, err := i.index.Image(h)
+	if err != nil {
+		return nil, err
+	}
+	return &copyImage{Image: img, wrap: i.wrap}, nil
+}
+
+func (i *copyIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) {
+	index, err := i.index.ImageIndex(h)
+	if err != nil {
+		return nil, err
+	}
+	return &copyIndex{index: index, wrap: i.wrap}, nil
+}
@@ -0,0 +1,288 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/pgzip"
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+// maxLargestDuplicates is how many duplicated contents a report lists
+const maxLargestDuplicates = 10
+
+// DedupReport estimates how much of the layer content of bundles is stored
+// more than once, and what squashing the layers of each image or storing
+// files by content would save. Sizes of files are uncompressed.
+type DedupReport struct {
+	Bundles      []string `json:"bundles"`
+	Layers       int      `json:"layers"`                  // Distinct stored layers analyzed
+	BaseLayers   int      `json:"base_layers"`             // Layers incremental bundles take from their base, not analyzed
+	Unreadable   []string `json:"unreadable,omitempty"`    // Layers in a compression the report cannot read
+	Files        int      `json:"files"`                   // Regular files in the analyzed layers
+	Size         int64    `json:"size"`                    // Their total size
+	SharedLayers int      `json:"shared_layers,omitempty"` // Layers stored in more than one of the bundles
+	SharedSize   int64    `json:"shared_size,omitempty"`   // Compressed size of their further copies
+
+	// Squash counts files a higher layer of the same image replaces or
+	// deletes; a squashed image would not store them
+	Squash DedupSaving `json:"squash"`
+
+	// FileLevel counts further copies of the same content in any layer; a
+	// content-addressed file store would keep one
+	FileLevel DedupSaving `json:"file_level"`
+
+	Largest []DuplicateContent `json:"largest,omitempty"` // Contents with the most bytes in further copies
+}
+
+// DedupSaving is what a storage mode would leave out
+type DedupSaving struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// DuplicateContent is a file content stored more than once
+type DuplicateContent struct {
+	Digest string   `json:"digest"`
+	Size   int64    `json:"size"`   // Size of one copy
+	Copies int      `json:"copies"` // Copies across the analyzed layers
+	Paths  []string `json:"paths"`  // Distinct paths of the copies
+}
+
+// layerFile is a regular file of a layer tar
+type layerFile struct {
+	name   string
+	size   int64
+	digest string
+}
+
+// layerContent is what the report needs of one layer: its files, and the
+// paths it deletes from the layers below
+type layerContent struct {
+	files     []layerFile
+	whiteouts map[string]bool
+	opaque    map[string]bool
+}
+
+// AnalyzeDuplicates reads every stored layer of the bundles and builds their
+// dedup report. Only v2 bundles of container images can be analyzed.
+func AnalyzeDuplicates(bundlePaths []string) (*DedupReport, error) {
+	report := &DedupReport{Bundles: bundlePaths}
+	layers := make(map[string]*layerContent)
+	var images [][]string // Layer digests of every image, bottom to top
+
+	for _, bundlePath := range bundlePaths {
+		var meta *bundle.Metadata
+		err := WalkBundle(bundlePath, func(header *tar.Header, r io.Reader) error {
+			switch {
+			case header.Name == "metadata.json":
+				raw, err := io.ReadAll(r)
+				if err != nil {
+					return fmt.Errorf("failed to read metadata: %w", err)
+				}
+				if meta, err = bundle.DecodeMetadata(raw); err != nil {
+					return err
+				}
+				if meta.Artifact != nil {
+					return fmt.Errorf("%s holds an artifact, not image layers", bundlePath)
+				}
+
+			case header.Name == "imgcd-meta.json":
+				return fmt.Errorf("%s is a legacy (v1) bundle, which the report cannot read", bundlePath)
+
+			case strings.HasPrefix(header.Name, "blobs/"):
+				digest, ok := bundle.BlobDigest(header.Name)
+				if !ok {
+					return nil
+				}
+				if _, ok := layers[digest]; ok {
+					report.SharedLayers++
+					report.SharedSize += header.Size
+					return nil
+				}
+				content, err := readLayerContent(r)
+				if err != nil {
+					// Config blobs and layers in other compressions
+					layers[digest] = nil
+					return nil
+				}
+				layers[digest] = content
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, fmt.Errorf("metadata not found in %s", bundlePath)
+		}
+
+		for _, image := range meta.PerImage() {
+			var digests []string
+			for _, layer := range image.Manifest.Layers {
+				digests = append(digests, layer.Digest.String())
+			}
+			images = append(images, digests)
+		}
+	}
+
+	report.count(layers, images)
+	return report, nil
+}
+
+// count fills the report from the analyzed layers of every image
+func (report *DedupReport) count(layers map[string]*layerContent, images [][]string) {
+	listed := make(map[string]bool)
+	var analyzed []string
+	for _, digests := range images {
+		for _, digest := range digests {
+			if listed[digest] {
+				continue
+			}
+			listed[digest] = true
+
+			content, stored := layers[digest]
+			switch {
+			case !stored:
+				report.BaseLayers++
+			case content == nil:
+				report.Unreadable = append(report.Unreadable, digest)
+			default:
+				analyzed = append(analyzed, digest)
+				report.Layers++
+				for _, file := range content.files {
+					report.Files++
+					report.Size += file.size
+				}
+			}
+		}
+	}
+
+	// Squash: walk each image from the top so a file is shadowed when a
+	// higher layer provides or deletes its path; a layer shared by several
+	// images counts once
+	shadowed := make(map[string]bool)
+	for _, digests := range images {
+		seen := make(map[string]bool)
+		whiteouts := make(map[string]bool)
+		opaque := make(map[string]bool)
+		for i := len(digests) - 1; i >= 0; i-- {
+			content := layers[digests[i]]
+			if content == nil {
+				continue
+			}
+			for _, file := range content.files {
+				if seen[file.name] || hiddenByUpper(file.name, whiteouts, opaque) {
+					key := digests[i] + file.name
+					if !shadowed[key] {
+						shadowed[key] = true
+						report.Squash.Files++
+						report.Squash.Size += file.size
+					}
+					continue
+				}
+				seen[file.name] = true
+			}
+			for p := range content.whiteouts {
+				whiteouts[p] = true
+			}
+			for p := range content.opaque {
+				opaque[p] = true
+			}
+		}
+	}
+
+	// File level: every copy of a content after the first
+	contents := make(map[string]*DuplicateContent)
+	for _, digest := range analyzed {
+		for _, file := range layers[digest].files {
+			if file.size == 0 {
+				continue
+			}
+			content, ok := contents[file.digest]
+			if !ok {
+				content = &DuplicateContent{Digest: file.digest, Size: file.size}
+				contents[file.digest] = content
+			}
+			content.Copies++
+			if !slices.Contains(content.Paths, file.name) {
+				content.Paths = append(content.Paths, file.name)
+			}
+		}
+	}
+	for _, content := range contents {
+		if content.Copies < 2 {
+			continue
+		}
+		report.FileLevel.Files += content.Copies - 1
+		report.FileLevel.Size += int64(content.Copies-1) * content.Size
+		sort.Strings(content.Paths)
+		report.Largest = append(report.Largest, *content)
+	}
+	sort.Slice(report.Largest, func(i, j int) bool {
+		a, b := report.Largest[i], report.Largest[j]
+		if wa, wb := int64(a.Copies-1)*a.Size, int64(b.Copies-1)*b.Size; wa != wb {
+			return wa > wb
+		}
+		return a.Digest < b.Digest
+	})
+	if len(report.Largest) > maxLargestDuplicates {
+		report.Largest = report.Largest[:maxLargestDuplicates]
+	}
+}
+
+// readLayerContent hashes the regular files of a gzip or plain layer tar
+// and collects its whiteouts
+func readLayerContent(r io.Reader) (*layerContent, error) {
+	layer := bufio.NewReader(r)
+	var tarStream io.Reader = layer
+	if magic, _ := layer.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzr, err := pgzip.NewReader(layer)
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		tarStream = gzr
+	}
+
+	content := &layerContent{whiteouts: make(map[string]bool), opaque: make(map[string]bool)}
+	tr := tar.NewReader(tarStream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return content, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean("/" + header.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			content.opaque[path.Clean(dir)] = true
+		case strings.HasPrefix(base, whiteoutPrefix):
+			content.whiteouts[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+		case header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeGNUSparse:
+			hasher := sha256.New()
+			size, err := io.Copy(hasher, tr)
+			if err != nil {
+				return nil, err
+			}
+			content.files = append(content.files, layerFile{
+				name:   name,
+				size:   size,
+				digest: "sha256:" + hex.EncodeToString(hasher.Sum(nil)),
+			})
+		}
+	}
+}
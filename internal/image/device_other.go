@@ -0,0 +1,8 @@
+//go:build !unix
+
+package image
+
+// sameFilesystem cannot tell devices apart on this platform
+func sameFilesystem(a, b string) (same, known bool) {
+	return false, false
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package image
+
+import (
+	"os"
+	"syscall"
+)
+
+// sameFilesystem reports whether two existing paths are on the same device;
+// known is false if that cannot be determined
+func sameFilesystem(a, b string) (same, known bool) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, false
+	}
+	statA, okA := infoA.Sys().(*syscall.Stat_t)
+	statB, okB := infoB.Sys().(*syscall.Stat_t)
+	if !okA || !okB {
+		return false, false
+	}
+	return statA.Dev == statB.Dev, true
+}
@@ -0,0 +1,171 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+)
+
+// blobDigests returns what a blob is checked against by algorithm: its own
+// digest and its recorded checksums. Algorithms imgcd cannot compute are
+// left out, so bundles of a newer imgcd still load.
+func blobDigests(digest string, checksums map[string]string) map[string]string {
+	expected := make(map[string]string)
+	for algorithm, value := range checksums {
+		if slices.Contains(bundle.Algorithms, algorithm) {
+			expected[algorithm] = value
+		}
+	}
+	if algorithm := bundle.Algorithm(digest); slices.Contains(bundle.Algorithms, algorithm) {
+		expected[algorithm] = digest
+	}
+	return expected
+}
+
+// digestWriter hashes what is written to it with several algorithms, each
+// on its own goroutine
+type digestWriter struct {
+	io.Writer
+	hashers map[string]*checksum.Hasher
+}
+
+// newDigestWriter starts the hashers of the algorithms; Close it on paths
+// that do not reach Digests
+func newDigestWriter(algorithms []string) (*digestWriter, error) {
+	w := &digestWriter{hashers: make(map[string]*checksum.Hasher)}
+	var writers []io.Writer
+	for _, algorithm := range algorithms {
+		hasher, err := checksum.NewAlgorithmHasher(algorithm)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.hashers[algorithm] = hasher
+		writers = append(writers, hasher)
+	}
+	w.Writer = io.MultiWriter(writers...)
+	return w, nil
+}
+
+// newBlobDigestWriter hashes with every algorithm of expected
+func newBlobDigestWriter(expected map[string]string) (*digestWriter, error) {
+	algorithms := make([]string, 0, len(expected))
+	for algorithm := range expected {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	return newDigestWriter(algorithms)
+}
+
+// Close stops the hashers
+func (w *digestWriter) Close() {
+	for _, hasher := range w.hashers {
+		hasher.Close()
+	}
+}
+
+// Digests waits for the hashers and returns the digests by algorithm
+func (w *digestWriter) Digests() map[string]string {
+	digests := make(map[string]string, len(w.hashers))
+	for algorithm, hasher := range w.hashers {
+		digests[algorithm] = hasher.Digest()
+	}
+	return digests
+}
+
+// verifyBlobFile checks an extracted blob against the checksums recorded for
+// it, for blobs extracted before the metadata that records them was read;
+// the digest itself was checked while extracting
+func verifyBlobFile(path, digest string, checksums map[string]string) error {
+	expected := blobDigests(digest, checksums)
+	delete(expected, bundle.Algorithm(digest))
+	if len(expected) == 0 {
+		return nil
+	}
+	hashes, err := newBlobDigestWriter(expected)
+	if err != nil {
+		return err
+	}
+	defer hashes.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(hashes, file); err != nil {
+		return err
+	}
+	return mismatch(digest, expected, hashes.Digests())
+}
+
+// mismatch returns an error for the first algorithm, in sorted order, whose
+// digest differs from the expected one, or nil
+func mismatch(name string, expected, actual map[string]string) error {
+	algorithms := make([]string, 0, len(expected))
+	for algorithm := range expected {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	for _, algorithm := range algorithms {
+		if actual[algorithm] != expected[algorithm] {
+			return fmt.Errorf("blob %s is corrupt: content hashes to %s", name, actual[algorithm])
+		}
+	}
+	return nil
+}
+
+// recordChecksums adds the digests of algorithms to the stored layers of
+// metadata, reading each blob through open. The layer slices are copied, so
+// the caller's metadata is left as it is.
+func recordChecksums(metadata *bundle.Metadata, digests, algorithms []string, open blobOpener) error {
+	sums := make(map[string]map[string]string)
+	for _, digest := range digests {
+		if _, ok := sums[digest]; ok {
+			continue
+		}
+		blob, _, err := open(digest)
+		if err != nil {
+			return err
+		}
+		w, err := newDigestWriter(algorithms)
+		if err != nil {
+			blob.Close()
+			return err
+		}
+		_, err = io.Copy(w, blob)
+		if closeErr := blob.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			w.Close()
+			return fmt.Errorf("failed to read blob %s: %w", digest, err)
+		}
+		sums[digest] = w.Digests()
+		// The digest itself is not repeated among the checksums
+		delete(sums[digest], bundle.Algorithm(digest))
+	}
+
+	metadata.Layers = withChecksums(metadata.Layers, sums)
+	metadata.Images = slices.Clone(metadata.Images)
+	for i := range metadata.Images {
+		metadata.Images[i].Layers = withChecksums(metadata.Images[i].Layers, sums)
+	}
+	return nil
+}
+
+// withChecksums returns a copy of layers with the checksums of their blobs
+func withChecksums(layers []bundle.LayerInfo, sums map[string]map[string]string) []bundle.LayerInfo {
+	layers = slices.Clone(layers)
+	for i := range layers {
+		if checksums := sums[layers[i].Digest]; len(checksums) > 0 {
+			layers[i].Checksums = checksums
+		}
+	}
+	return layers
+}
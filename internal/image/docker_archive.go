@@ -0,0 +1,433 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+// ToDockerOptions selects what BundleToDockerArchive writes
+type ToDockerOptions struct {
+	Image    string    // Image of a multi-image bundle; may be omitted if it holds one
+	Platform string    // Variant of an image saved for several platforms
+	Base     string    // docker save archive of the base of an incremental bundle
+	Output   io.Writer // Progress messages; os.Stdout if nil
+}
+
+// BundleToDockerArchive writes one image of a bundle to outPath as a docker
+// save archive, for docker load or inspection without a runtime. The shared
+// layers of an incremental bundle are taken from opts.Base. It returns the
+// reference the archive tags the image with.
+func BundleToDockerArchive(bundlePath, outPath string, opts ToDockerOptions) (string, error) {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	image, err := openBundleImage(bundlePath)
+	if err != nil {
+		return "", err
+	}
+	defer image.Close()
+
+	tempDir, err := os.MkdirTemp("", "imgcd-to-docker-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	blobDir := filepath.Join(tempDir, "blobs")
+	if err := os.Mkdir(blobDir, 0755); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(out, "Reading %s...\n", filepath.Base(bundlePath))
+	contents, err := extractPushContents(image, blobDir)
+	if err != nil {
+		return "", err
+	}
+	if contents.metadata == nil && contents.legacy != nil {
+		if contents.legacy.Incremental {
+			return "", fmt.Errorf("legacy (v1) incremental bundles cannot be converted; save the image again")
+		}
+		if contents.imageTar == "" {
+			return "", fmt.Errorf("legacy bundle has no image.tar")
+		}
+		if err := writeLegacyImageTar(contents.imageTar, outPath); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", outPath, err)
+		}
+		return contents.legacy.NewRef, nil
+	}
+	switch {
+	case contents.metadata == nil:
+		return "", fmt.Errorf("metadata not found in bundle (expected metadata.json)")
+	case contents.metadata.Artifact != nil:
+		return "", fmt.Errorf("OCI artifact bundles are not container images and have no docker save form")
+	}
+
+	metadata, err := selectBundleImage(contents.metadata, opts.Image, opts.Platform)
+	if err != nil {
+		return "", err
+	}
+
+	bl := NewBundleLoader(nil)
+	bl.out = out
+	var sharedLayers []string
+	if metadata.SharedLayerCount > 0 {
+		if opts.Base == "" {
+			return "", fmt.Errorf("%s is incremental: its first %d layers come from %s; pass a docker save archive of that image with --base",
+				filepath.Base(bundlePath), metadata.SharedLayerCount, metadata.BaseRef)
+		}
+		baseDir := filepath.Join(tempDir, "base")
+		if err := os.Mkdir(baseDir, 0755); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(out, "Reading base %s...\n", filepath.Base(opts.Base))
+		if err := bl.extractTarToDir(opts.Base, baseDir); err != nil {
+			return "", fmt.Errorf("failed to extract base archive: %w", err)
+		}
+		baseConfig, layers, err := bl.parseBaseImage(baseDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to read base archive: %w", err)
+		}
+		if err := checkSharedLayers(baseConfig, metadata.Config, metadata.SharedLayerCount); err != nil {
+			return "", err
+		}
+		for _, layer := range layers[:metadata.SharedLayerCount] {
+			sharedLayers = append(sharedLayers, filepath.Join(baseDir, layer))
+		}
+	}
+
+	if err := bl.rebuildImageTar(outPath, blobDir, metadata, sharedLayers); err != nil {
+		os.Remove(outPath)
+		return "", fmt.Errorf("failed to write %s: %w", outPath, err)
+	}
+	fmt.Fprintln(out)
+	return metadata.ImageRef, nil
+}
+
+// writeLegacyImageTar copies the image.tar of a legacy bundle, which is
+// already a docker save archive
+func writeLegacyImageTar(imageTar, outPath string) error {
+	src, err := os.Open(imageTar)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// selectBundleImage picks the image of a bundle a conversion asked for,
+// listing the choices when the request is missing or matches none
+func selectBundleImage(metadata *bundle.Metadata, ref, platform string) (*bundle.Metadata, error) {
+	var candidates []*bundle.Metadata
+	for _, m := range metadata.PerImage() {
+		if ref == "" || m.ImageRef == ref {
+			candidates = append(candidates, m)
+		}
+	}
+	if platform != "" {
+		if match := matchVariant(candidates, platform); match != nil {
+			candidates = []*bundle.Metadata{match}
+		} else {
+			candidates = nil
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	var available []string
+	for _, m := range metadata.PerImage() {
+		available = append(available, fmt.Sprintf("%s (%s)", m.ImageRef, m.Platform))
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("bundle has no image matching %s; it holds %s", describeSelection(ref, platform), strings.Join(available, ", "))
+	}
+	return nil, fmt.Errorf("bundle holds several images, choose one with --image and --platform: %s", strings.Join(available, ", "))
+}
+
+// describeSelection names an --image/--platform choice for errors
+func describeSelection(ref, platform string) string {
+	switch {
+	case ref == "":
+		return platform
+	case platform == "":
+		return ref
+	}
+	return ref + " for " + platform
+}
+
+// FromDockerOptions configures BundleFromDockerArchive
+type FromDockerOptions struct {
+	Tag            string    // Reference of an image the archive holds untagged
+	SinceLayers    []v1.Hash // DiffIDs of the base image on the target, bottom first
+	SinceRef       string    // Base image on the target SinceLayers are those of
+	TargetPlatform string    // Platform of the imgcd binary; default that of the image
+}
+
+// BundleFromDockerArchive turns the images of a docker save archive into a
+// bundle in outDir. With opts.SinceLayers, the layers the archive's image
+// shares with that base are left out and taken from it on load.
+func BundleFromDockerArchive(archivePath, outDir, version string, opts FromDockerOptions) (*ConvertResult, error) {
+	opener := func() (io.ReadCloser, error) { return os.Open(archivePath) }
+	manifest, err := tarball.LoadManifest(opener)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s as a docker save archive: %w", archivePath, err)
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("%s holds no images", archivePath)
+	}
+	if len(manifest) > 1 && opts.Tag != "" {
+		return nil, fmt.Errorf("--tag only applies to archives of one image; %s holds %d", archivePath, len(manifest))
+	}
+	if len(manifest) > 1 && len(opts.SinceLayers) > 0 {
+		return nil, fmt.Errorf("incremental bundles hold one image; %s holds %d", archivePath, len(manifest))
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	workDir, cleanup, err := stagingDir(outDir)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	blobDir, err := os.MkdirTemp(workDir, "imgcd-from-docker-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(blobDir)
+
+	result := &ConvertResult{}
+	var entries []bundle.ImageEntry
+	var digests []string
+	compressed := make(map[string]bundle.LayerInfo) // By DiffID, for layers images share
+	shared := 0
+	for i, desc := range manifest {
+		var tag *name.Tag
+		ref := opts.Tag
+		if len(desc.RepoTags) > 0 {
+			if t, err := name.NewTag(desc.RepoTags[0]); err == nil {
+				tag = &t
+			}
+			if ref == "" {
+				ref = desc.RepoTags[0]
+			}
+		}
+		if ref == "" {
+			return nil, fmt.Errorf("image %d of %s has no tag; name it with --tag", i+1, archivePath)
+		}
+		if tag == nil && len(manifest) > 1 {
+			return nil, fmt.Errorf("image %d of %s has no tag; untagged images can only be converted from archives of one image", i+1, archivePath)
+		}
+
+		img, err := tarball.Image(opener, tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", ref, err)
+		}
+		rawConfig, err := img.RawConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config of %s: %w", ref, err)
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config of %s: %w", ref, err)
+		}
+		if len(config.RootFS.DiffIDs) == 0 {
+			return nil, fmt.Errorf("config of %s has no layers (RootFS.DiffIDs is empty)", ref)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layers of %s: %w", ref, err)
+		}
+		if len(layers) != len(config.RootFS.DiffIDs) {
+			return nil, fmt.Errorf("%s has %d layers but its config lists %d", ref, len(layers), len(config.RootFS.DiffIDs))
+		}
+
+		if len(opts.SinceLayers) > 0 {
+			shared = sharedPrefixLength(opts.SinceLayers, config.RootFS.DiffIDs)
+			fmt.Printf("%d of %d layers of %s are in %s\n", shared, len(layers), ref, opts.SinceRef)
+		}
+
+		var infos []bundle.LayerInfo
+		for j, layer := range layers {
+			diffID := config.RootFS.DiffIDs[j].String()
+			info, ok := compressed[diffID]
+			if !ok {
+				// Shared layers are compressed only for the digest the manifest records
+				dir := blobDir
+				if j < shared {
+					dir = ""
+				}
+				fmt.Printf("Compressing layer %d/%d of %s...\r", j+1, len(layers), ref)
+				info, err = compressDockerLayer(layer, dir)
+				if err != nil {
+					return nil, fmt.Errorf("failed to compress layer %d of %s: %w", j, ref, err)
+				}
+				info.DiffID = diffID
+				if j >= shared {
+					compressed[diffID] = info
+					digests = append(digests, info.Digest)
+				}
+			}
+			infos = append(infos, info)
+		}
+		fmt.Println()
+
+		platform := ""
+		if p := configPlatform(config); p != nil {
+			platform = p.String()
+		}
+		entries = append(entries, bundle.ImageEntry{
+			ImageRef:  ref,
+			Platform:  platform,
+			Manifest:  dockerArchiveManifest(rawConfig, infos),
+			Config:    config,
+			RawConfig: rawConfig,
+			Layers:    infos[shared:],
+		})
+		result.Images = append(result.Images, fmt.Sprintf("%s (%s)", ref, platform))
+	}
+
+	first := entries[0]
+	metadata := bundle.Metadata{
+		Version:   "2",
+		ImageRef:  first.ImageRef,
+		Platform:  first.Platform,
+		Manifest:  first.Manifest,
+		Config:    first.Config,
+		RawConfig: first.RawConfig,
+		Layers:    first.Layers,
+		TotalSize: calculateTotalSize(first.Layers),
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Note:      fmt.Sprintf("Converted from docker save archive %s", filepath.Base(archivePath)),
+		Images:    entries[1:],
+	}
+	if len(opts.SinceLayers) > 0 {
+		metadata.BaseRef = opts.SinceRef
+		metadata.SharedLayerCount = shared
+	}
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+
+	tarGzPath, bundlePath := bundlePaths(metadata, outDir)
+	defer os.Remove(tarGzPath)
+	fmt.Printf("Packing %d blob(s) into bundle...\n", len(digests))
+	err = writeBlobBundle(tarGzPath, metadata, nil, digests, nil, func(digest string) (io.ReadCloser, int64, error) {
+		f, err := os.Open(filepath.Join(blobDir, bundle.Encoded(digest)))
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	targetPlatform := opts.TargetPlatform
+	if targetPlatform == "" {
+		targetPlatform = binaryPlatform(first.Platform)
+	}
+	gen := NewBundleGenerator(version)
+	gen.strict = version != "dev"
+	if err := gen.GenerateBundle(tarGzPath, bundlePath, targetPlatform, metadata.ImageRef); err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	if err := writeChecksums(bundlePath, ExportOptions{}, true); err != nil {
+		return nil, err
+	}
+	result.Path = bundlePath
+	return result, nil
+}
+
+// compressDockerLayer compresses a layer of a docker save archive into dir,
+// named by its digest; with dir empty it only computes the digest. Layers
+// the archive already holds compressed are copied as they are.
+func compressDockerLayer(layer v1.Layer, dir string) (bundle.LayerInfo, error) {
+	mediaType, err := layer.MediaType()
+	if err != nil {
+		mediaType = types.DockerLayer
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return bundle.LayerInfo{}, err
+	}
+	defer rc.Close()
+
+	var w io.Writer = io.Discard
+	var f *os.File
+	if dir != "" {
+		f, err = os.CreateTemp(dir, "layer-*")
+		if err != nil {
+			return bundle.LayerInfo{}, err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		w = f
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), rc)
+	if err != nil {
+		return bundle.LayerInfo{}, err
+	}
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return bundle.LayerInfo{}, err
+		}
+		if err := os.Rename(f.Name(), filepath.Join(dir, bundle.Encoded(digest))); err != nil {
+			return bundle.LayerInfo{}, err
+		}
+	}
+	return bundle.LayerInfo{Digest: digest, Size: size, MediaType: string(mediaType)}, nil
+}
+
+// dockerArchiveManifest is the registry manifest of an image converted from
+// a docker save archive, which stores none
+func dockerArchiveManifest(rawConfig []byte, layers []bundle.LayerInfo) *v1.Manifest {
+	configDigest := sha256.Sum256(rawConfig)
+	manifest := &v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.DockerManifestSchema2,
+		Config: v1.Descriptor{
+			MediaType: types.DockerConfigJSON,
+			Size:      int64(len(rawConfig)),
+			Digest:    v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(configDigest[:])},
+		},
+	}
+	for _, layer := range layers {
+		digest, _ := v1.NewHash(layer.Digest)
+		manifest.Layers = append(manifest.Layers, v1.Descriptor{
+			MediaType: types.MediaType(layer.MediaType),
+			Size:      layer.Size,
+			Digest:    digest,
+		})
+	}
+	return manifest
+}
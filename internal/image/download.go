@@ -0,0 +1,172 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/retry"
+)
+
+// maxResumes bounds how often a broken bundle download is resumed without
+// receiving any data in between
+const maxResumes = 5
+
+// IsURL reports whether a --from value is an http(s) URL
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// RedactURL returns rawURL without its password and query, which often
+// carry credentials, for messages
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "..."
+	}
+	return u.Redacted()
+}
+
+// FetchURL downloads a small file, e.g. the .sha256 next to a bundle, up to
+// limit bytes. It returns ErrNotFound when the server has none.
+func FetchURL(url string, limit int64) ([]byte, error) {
+	var content []byte
+	err := httpGet(url, func(body io.Reader) error {
+		var err error
+		content, err = io.ReadAll(io.LimitReader(body, limit))
+		return err
+	})
+	return content, err
+}
+
+// OpenURL starts downloading the bundle at url as a stream for
+// Importer.ImportStream. A connection that breaks mid-download is reopened
+// with a Range request from where it stopped, provided the server supports
+// ranges and the file did not change since (If-Range).
+func OpenURL(ctx context.Context, url string, out io.Writer) (io.ReadCloser, error) {
+	s := &urlStream{ctx: ctx, url: url, out: out, size: -1}
+	if err := s.open(); err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", RedactURL(url), err)
+	}
+	if s.size >= 0 {
+		fmt.Fprintf(out, "Downloading %s (%s)\n", RedactURL(url), humanize.Size(s.size))
+	} else {
+		fmt.Fprintf(out, "Downloading %s\n", RedactURL(url))
+	}
+	return s, nil
+}
+
+// urlStream is the body of a bundle download that resumes where a broken
+// connection stopped
+type urlStream struct {
+	ctx       context.Context
+	url       string
+	out       io.Writer
+	body      io.ReadCloser
+	offset    int64  // Bytes received so far
+	size      int64  // Content-Length of the first response, -1 if unknown
+	validator string // ETag or Last-Modified of the first response, for If-Range
+	resumes   int    // Resumes since data last arrived
+}
+
+func (s *urlStream) Read(p []byte) (int, error) {
+	for {
+		if s.body == nil {
+			if err := s.open(); err != nil {
+				return 0, fmt.Errorf("failed to resume download at %s: %w", humanize.Size(s.offset), err)
+			}
+		}
+
+		n, err := s.body.Read(p)
+		s.offset += int64(n)
+		if n > 0 {
+			s.resumes = 0
+		}
+		if err == io.EOF && s.size >= 0 && s.offset < s.size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || err == io.EOF || s.ctx.Err() != nil {
+			return n, err
+		}
+
+		// The connection broke: reopen it on the next read
+		s.body.Close()
+		s.body = nil
+		if s.resumes++; s.resumes > maxResumes {
+			return n, fmt.Errorf("download broke at %s: %w", humanize.Size(s.offset), err)
+		}
+		fmt.Fprintf(s.out, "Download interrupted at %s (%v), resuming...\n", humanize.Size(s.offset), err)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// open requests the download from the current offset, retrying transient
+// failures
+func (s *urlStream) open() error {
+	policy := retry.DefaultPolicy
+	policy.OnRetry = func(attempt int, delay time.Duration, err error) {
+		fmt.Fprintf(s.out, "Download attempt %d failed (%v), retrying in %s...\n", attempt, err, delay)
+	}
+
+	return retry.Do(s.ctx, policy, func(int) error {
+		req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, s.url, nil)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		if s.offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", s.offset))
+			if s.validator != "" {
+				req.Header.Set("If-Range", s.validator)
+			}
+		}
+		resp, err := downloadClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case s.offset == 0 && resp.StatusCode == http.StatusOK:
+			s.size = resp.ContentLength
+			s.validator = resp.Header.Get("ETag")
+			if s.validator == "" {
+				s.validator = resp.Header.Get("Last-Modified")
+			}
+		case s.offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		case s.offset > 0 && resp.StatusCode == http.StatusOK:
+			resp.Body.Close()
+			return retry.Permanent(errors.New("the server sent the whole file again: it does not support ranges, or the file changed"))
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return retry.Permanent(ErrNotFound)
+		default:
+			resp.Body.Close()
+			err := fmt.Errorf("download failed with status: %s", resp.Status)
+			if !retryableStatus(resp) {
+				return retry.Permanent(err)
+			}
+			return retry.After(err, retry.ServerDelay(resp))
+		}
+
+		s.body = resp.Body
+		return nil
+	})
+}
+
+// Close ends the download
+func (s *urlStream) Close() error {
+	if s.body == nil {
+		return nil
+	}
+	return s.body.Close()
+}
@@ -0,0 +1,135 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/provenance"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// bundleEntry is an extra file stored next to the metadata in a bundle,
+// either held in Data or read from Path
+type bundleEntry struct {
+	Name string
+	Data []byte
+
+	Path string
+	Mode int64 // Permission bits of Path
+	Size int64 // Size of Path
+}
+
+// writeMetadata writes the metadata document followed by any extra entries
+func writeMetadata(tw *tar.Writer, name string, meta any, extras []bundleEntry) error {
+	metaBytes, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	entries := append([]bundleEntry{{Name: name, Data: metaBytes}}, extras...)
+	for _, entry := range entries {
+		if entry.Path != "" {
+			if err := writeFileEntry(tw, entry); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tw.WriteHeader(fileHeader(entry.Name, 0644, int64(len(entry.Data)))); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFileEntry copies the file of an entry into the tar
+func writeFileEntry(tw *tar.Writer, entry bundleEntry) error {
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(fileHeader(entry.Name, entry.Mode, entry.Size)); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(tw, f, entry.Size); err != nil {
+		return fmt.Errorf("failed to attach %s: %w", entry.Path, err)
+	}
+	return nil
+}
+
+// extraEntries returns everything stored next to the metadata: the
+// provenance statement and the SBOM, if requested, and the attached files.
+// more are the further images of a multi-image bundle.
+func extraEntries(opts ExportOptions, imageRef, baseRef, imageID string, createdAt time.Time, more ...imageSubject) ([]bundleEntry, error) {
+	extras, err := provenanceEntries(opts, imageRef, baseRef, imageID, createdAt, more)
+	if err != nil {
+		return nil, err
+	}
+
+	sbom, err := sbomEntries(opts, imageRef)
+	if err != nil {
+		return nil, err
+	}
+	extras = append(extras, sbom...)
+
+	attachments, err := attachmentEntries(opts.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) > 0 {
+		fmt.Printf("Attaching %d file(s)\n", len(attachments))
+	}
+	return append(extras, attachments...), nil
+}
+
+// imageSubject is an image a provenance statement covers: its reference and
+// image ID
+type imageSubject struct {
+	Ref string
+	ID  string
+}
+
+// provenanceEntries returns the in-toto provenance statement when requested.
+// The subject digest is the image ID (config digest). Load writes the config
+// as the source stored it (bundle.Metadata.RawConfig), so receivers can check
+// the subject against the ID of the loaded image; bundles of older versions
+// re-encode the config, which changes the ID.
+func provenanceEntries(opts ExportOptions, imageRef, baseRef, imageID string, createdAt time.Time, more []imageSubject) ([]bundleEntry, error) {
+	if !opts.ProvenanceStatement {
+		return nil, nil
+	}
+
+	if imageID == "" {
+		ui.Fwarning(os.Stdout, "image ID of %s is unknown, skipping provenance statement", imageRef)
+		return nil, nil
+	}
+
+	params := map[string]string{
+		"image":    imageRef,
+		"platform": opts.TargetPlatform,
+	}
+	if baseRef != "" {
+		params["since"] = baseRef
+	}
+
+	stmt := provenance.NewStatement(imageRef, imageID, params, opts.Provenance, createdAt.Format(time.RFC3339))
+	for _, image := range more {
+		params["image"] += " " + image.Ref
+		stmt.AddSubject(image.Ref, image.ID)
+	}
+	data, err := stmt.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode provenance statement: %w", err)
+	}
+
+	return []bundleEntry{{Name: provenance.StatementFileName, Data: data}}, nil
+}
@@ -2,22 +2,29 @@ package image
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/klauspost/pgzip"
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	remotedownload "github.com/so2liu/imgcd/internal/remote"
 	"github.com/so2liu/imgcd/internal/runtime"
+	"github.com/so2liu/imgcd/internal/ui"
 )
 
 // Exporter exports container images to tar.gz archives or self-extracting bundles
 type Exporter struct {
 	runtime runtime.Runtime
 	version string
+	workDir string // Temporary files of a local export; empty for the system temp dir
 }
 
 // NewExporter creates a new image exporter
@@ -32,18 +39,293 @@ func NewExporter(version string) (*Exporter, error) {
 
 // ExportOptions contains options for exporting images
 type ExportOptions struct {
-	TargetPlatform string
-	ForceLocal     bool // Force using local runtime instead of remote mode
-	UseCache       bool // Enable layer caching (default: true)
+	TargetPlatform string // Platform of the images, always linux/*: see ImagePlatform
+	BinaryPlatform string // Platform of the bundled imgcd binary, e.g. darwin/arm64; TargetPlatform when empty
+	StrictPlatform bool   // Refuse an imgcd binary (IMGCD_BINARY_PATH, dev builds) that cannot run on BinaryPlatform
+	ForceLocal     bool   // Force using local runtime instead of remote mode
+	UseCache       bool   // Enable layer caching (default: true)
+	Rebuild        bool   // Create the bundle even if an identical one already exists
+	SkipUnchanged  bool   // Create nothing if the image has no changes relative to its base
+	SelfExtracting bool   // Wrap the bundle into a self-extracting shell script (.sh)
+
+	ExpiresAt   time.Time         // Zero if the bundle never expires
+	Note        string            // Free-form comment recorded in bundle metadata
+	Annotations map[string]string // Arbitrary key/value pairs recorded in bundle metadata
+
+	Provenance          *bundle.Provenance // Producer identity recorded in bundle metadata, nil to omit
+	ProvenanceStatement bool               // Also store an in-toto provenance statement in the bundle
+
+	SBOM         string // SPDX or CycloneDX JSON file stored in the bundle
+	GenerateSBOM bool   // Store an SPDX SBOM generated by syft instead
+
+	ChecksumSignKey string   // Ed25519 private key (PEM) to sign the .sha256 sidecar with, empty to not sign
+	SignKey         string   // Ed25519 private key (PEM) to embed a signature of the bundle with, empty to not sign
+	Checksums       []string // Further digest algorithms recorded for every stored blob (v2 bundles)
+
+	Attachments []string // Files and directories stored under extras/ in the bundle
+	OnLoad      []string // Shell commands recorded for load --run-post-load
+
+	// SinceBundle is an earlier bundle whose image is the base instead of
+	// sinceRef; its layers are read from the bundle's metadata, so the base
+	// need not be in any registry or runtime
+	SinceBundle string
+
+	// SinceLayers is a layer list (imgcd layers, docker inspect output or
+	// one DiffID per line) of the base the target has; sinceRef, if set,
+	// names that base
+	SinceLayers string
+
+	MoreImages    []string // Further images stored in the same bundle (remote mode, full exports only)
+	MorePlatforms []string // Further platforms of every image stored in the same bundle
+	AllPlatforms  bool     // Store every platform of the images' manifest lists
+
+	Segments  remotedownload.SegmentOptions // Ranged, concurrent download of large blobs (remote mode)
+	Peers     []string                      // imgcd serve instances asked for blobs before the registry (remote mode)
+	RateLimit int64                         // Bytes per second all layer downloads share, 0 for no limit (remote mode)
+
+	// Stream receives the bundle instead of a file in outDir, which then
+	// only holds the files it is built from. No checksum file is written,
+	// and no earlier bundle is reused.
+	Stream io.Writer
+
+	// Tee, if set, receives a copy of the bundle file while it is written,
+	// under its name, e.g. to deliver it as it is created. Not called for
+	// a bundle of an earlier run that is reused.
+	Tee func(name string) io.Writer
+
+	// binary is the imgcd binary resolved before the export, so its
+	// platform can be recorded in the metadata; selftest presets it
+	binary *bundleBinary
+
+	// downloads receives where the blobs of a registry export came from
+	downloads *DownloadStats
+
+	// sinceBundle is the image of SinceBundle, or the base SinceLayers
+	// lists, the export is based on
+	sinceBundle *bundle.Metadata
 }
 
-// Export exports an image to a self-extracting bundle
-func (e *Exporter) Export(ctx context.Context, newRef, sinceRef, outDir string, opts ExportOptions) (string, error) {
+// binaryTarget is the platform of the bundled imgcd binary
+func (opts ExportOptions) binaryTarget() string {
+	if opts.BinaryPlatform != "" {
+		return opts.BinaryPlatform
+	}
+	return opts.TargetPlatform
+}
+
+// recordedBinaryPlatform is the platform of the bundled imgcd binary for
+// the metadata; empty when it is unknown
+func (opts ExportOptions) recordedBinaryPlatform() string {
+	if opts.binary == nil {
+		return ""
+	}
+	return opts.binary.platform
+}
+
+// multiImage reports whether the bundle holds more than one image or platform
+func (opts ExportOptions) multiImage() bool {
+	return len(opts.MoreImages) > 0 || len(opts.MorePlatforms) > 0 || opts.AllPlatforms
+}
+
+// ExportResult describes the outcome of an export
+type ExportResult struct {
+	Path      string // Path of the bundle; the name it would have had when Streamed
+	UpToDate  bool   // An identical bundle from a previous run was reused
+	Unchanged bool   // No bundle was created because the image equals its base (SkipUnchanged)
+
+	Streamed bool   // The bundle was written to ExportOptions.Stream
+	Size     int64  // Size of a streamed bundle
+	Checksum string // sha256 of a streamed bundle
+
+	Downloads DownloadStats // Blobs fetched for the bundle; zero for local exports
+}
+
+// DownloadStats counts where the blobs of a registry export came from
+type DownloadStats struct {
+	Blobs     int `json:"blobs"`      // Blobs of the bundle, stored ones only
+	CacheHits int `json:"cache_hits"` // Found in the blob cache
+	FromPeers int `json:"from_peers"` // Fetched from imgcd serve peers
+}
+
+// errNoChanges reports that the image has nothing new relative to its base
+var errNoChanges = errors.New("image has no changes since base")
+
+// Export exports an image, or with opts.MoreImages several, to a bundle
+func (e *Exporter) Export(ctx context.Context, newRef, sinceRef, outDir string, opts ExportOptions) (*ExportResult, error) {
+	for _, platform := range append([]string{opts.TargetPlatform}, opts.MorePlatforms...) {
+		if ImagePlatform(platform) != platform {
+			return nil, fmt.Errorf("there are no %s container images: save %s images and set BinaryPlatform to %s for the bundled imgcd", platform, ImagePlatform(platform), platform)
+		}
+	}
+	sinceRef, err := readSinceFile(newRef, sinceRef, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.multiImage() {
+		if opts.ForceLocal {
+			return nil, errMultiImageLocal
+		}
+		if sinceRef != "" {
+			return nil, fmt.Errorf("--since cannot be used when saving several images or platforms into one bundle")
+		}
+	}
+	if opts.SkipUnchanged && sinceRef != "" && opts.sinceBundle == nil && e.sameAsBase(ctx, newRef, sinceRef, opts) {
+		return &ExportResult{Unchanged: true}, nil
+	}
+
+	var stream *streamWriter
+	if opts.Stream != nil {
+		stream = &streamWriter{w: opts.Stream, hasher: checksum.NewHasher()}
+		defer stream.hasher.Close()
+		opts.Stream = stream
+	}
+
+	// Reuse the bundle of a previous run if none of its inputs changed
+	var prepared *preparedBundle
+	if !opts.Rebuild && !opts.multiImage() && stream == nil {
+		prepared = e.prepareBundle(ctx, newRef, sinceRef, outDir, opts)
+		if prepared.upToDate() {
+			fmt.Printf("Bundle is up to date: %s\n", prepared.path)
+			if err := writeChecksums(prepared.path, opts, false); err != nil {
+				return nil, err
+			}
+			return &ExportResult{Path: prepared.path, UpToDate: true}, nil
+		}
+	}
+
+	if err := checkSBOM(opts); err != nil {
+		return nil, err
+	}
+
+	// The binary goes into the bundle last, but its platform into the metadata
+	if opts.binary == nil {
+		gen := NewBundleGenerator(e.version)
+		gen.strict = opts.StrictPlatform
+		binary, err := gen.resolveBinary(opts.binaryTarget())
+		if err != nil {
+			return nil, err
+		}
+		opts.binary = binary
+	}
+
+	downloads := &DownloadStats{}
+	opts.downloads = downloads
+	bundlePath, err := e.export(ctx, newRef, sinceRef, outDir, opts)
+	if errors.Is(err, errNoChanges) {
+		return &ExportResult{Unchanged: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.SelfExtracting && stream != nil {
+		err := NewBundleGenerator(e.version).StreamSelfExtractor(stream, bundlePath, opts.binaryTarget(), newRef)
+		os.Remove(bundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stream self-extracting bundle: %w", err)
+		}
+		bundlePath = selfExtractingPath(bundlePath)
+	}
+	if stream != nil {
+		return &ExportResult{Path: bundlePath, Streamed: true, Size: stream.size, Checksum: stream.hasher.Sum(), Downloads: *downloads}, nil
+	}
+
+	if opts.SelfExtracting {
+		shPath := selfExtractingPath(bundlePath)
+		bundleGen := NewBundleGenerator(e.version)
+		bundleGen.tee = opts.Tee
+		if err := bundleGen.GenerateSelfExtractor(bundlePath, shPath, opts.binaryTarget(), newRef); err != nil {
+			os.Remove(shPath)
+			return nil, fmt.Errorf("failed to create self-extracting bundle: %w", err)
+		}
+		os.Remove(bundlePath)
+		bundlePath = shPath
+	}
+
+	if err := writeChecksums(bundlePath, opts, true); err != nil {
+		return nil, err
+	}
+
+	prepared.record(bundlePath, opts)
+	return &ExportResult{Path: bundlePath, Downloads: *downloads}, nil
+}
+
+// streamWriter passes a streamed bundle on while counting and hashing it
+type streamWriter struct {
+	w      io.Writer
+	hasher *checksum.Hasher
+	size   int64
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.hasher.Write(p[:n])
+	s.size += int64(n)
+	return n, err
+}
+
+// writeChecksums writes the .sha256 sidecar of a bundle and signs it when a
+// key is configured. Existing sidecars are kept unless overwrite is set; the
+// signature is always made anew, as the key may differ from the last run's.
+func writeChecksums(bundlePath string, opts ExportOptions, overwrite bool) error {
+	_, err := os.Stat(checksum.SidecarPath(bundlePath))
+	if overwrite || os.IsNotExist(err) {
+		if err := checksum.WriteSidecar(bundlePath); err != nil {
+			return err
+		}
+		// A signature of an earlier bundle under this name no longer matches
+		if opts.ChecksumSignKey == "" {
+			os.Remove(checksum.SignaturePath(bundlePath))
+		}
+	}
+
+	if opts.ChecksumSignKey != "" {
+		if err := checksum.SignSidecar(bundlePath, opts.ChecksumSignKey); err != nil {
+			return fmt.Errorf("failed to sign checksum: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// sameAsBase reports whether newRef and its base resolve to the same digest
+func (e *Exporter) sameAsBase(ctx context.Context, newRef, sinceRef string, opts ExportOptions) bool {
+	newDigest, format, err := e.resolveDigest(ctx, newRef, "", opts)
+	if err != nil {
+		return false
+	}
+	baseDigest, _, err := e.resolveDigest(ctx, normalizeSinceRef(newRef, sinceRef), format, opts)
+	return err == nil && newDigest == baseDigest
+}
+
+// export selects the export mode and creates the bundle
+func (e *Exporter) export(ctx context.Context, newRef, sinceRef, outDir string, opts ExportOptions) (string, error) {
 	// Intelligent mode selection:
 	// 1. If ForceLocal is true, use local mode
 	// 2. Otherwise, try remote mode first
 	// 3. If remote mode fails, fallback to local mode
 
+	if opts.multiImage() {
+		remoteExporter, err := NewRemoteExporter(e.version, opts.UseCache)
+		if err != nil {
+			return "", fmt.Errorf("failed to create remote exporter: %w", err)
+		}
+		if !slices.ContainsFunc(append([]string{newRef}, opts.MoreImages...), isSkopeoDir) {
+			remoteExporter.SetSegments(opts.Segments)
+			remoteExporter.SetPeers(opts.Peers)
+			remoteExporter.SetRateLimit(opts.RateLimit)
+		}
+		return remoteExporter.ExportImages(ctx, append([]string{newRef}, opts.MoreImages...), outDir, opts)
+	}
+
+	if isSkopeoDir(newRef) || isSkopeoDir(sinceRef) {
+		// skopeo directories are read like a registry; there is no runtime to fall back to
+		if opts.ForceLocal {
+			return "", fmt.Errorf("--local cannot be used with dir: images")
+		}
+		return e.exportRemote(ctx, newRef, sinceRef, outDir, opts)
+	}
+
 	if opts.ForceLocal {
 		fmt.Printf("Using local mode (forced)\n")
 		return e.exportLocal(ctx, newRef, sinceRef, outDir, opts)
@@ -52,8 +334,8 @@ func (e *Exporter) Export(ctx context.Context, newRef, sinceRef, outDir string,
 	// Try remote mode first
 	fmt.Printf("Attempting remote mode...\n")
 	result, err := e.exportRemote(ctx, newRef, sinceRef, outDir, opts)
-	if err == nil {
-		return result, nil
+	if err == nil || errors.Is(err, errNoChanges) || errors.Is(err, errArtifactUnsupported) || errors.Is(err, errBasePlatform) {
+		return result, err
 	}
 
 	// Remote mode failed, fallback to local mode
@@ -67,6 +349,11 @@ func (e *Exporter) exportRemote(ctx context.Context, newRef, sinceRef, outDir st
 	if err != nil {
 		return "", fmt.Errorf("failed to create remote exporter: %w", err)
 	}
+	if !isSkopeoDir(newRef) {
+		remoteExporter.SetSegments(opts.Segments)
+		remoteExporter.SetPeers(opts.Peers)
+		remoteExporter.SetRateLimit(opts.RateLimit)
+	}
 	return remoteExporter.ExportFromRegistry(ctx, newRef, sinceRef, outDir, opts)
 }
 
@@ -80,7 +367,7 @@ func (e *Exporter) exportLocal(ctx context.Context, newRef, sinceRef, outDir str
 
 	// Check and pull the new image if necessary
 	fmt.Printf("Checking image %s...\n", newRef)
-	_, err := e.runtime.GetImageWithPlatform(ctx, newRef, pullPlatform)
+	newImage, err := e.runtime.GetImageWithPlatform(ctx, newRef, pullPlatform)
 	if err != nil {
 		return "", fmt.Errorf("failed to get image %s: %w", newRef, err)
 	}
@@ -90,33 +377,49 @@ func (e *Exporter) exportLocal(ctx context.Context, newRef, sinceRef, outDir str
 	if sinceRef != "" {
 		// If sinceRef is just a tag (no repo), use the same repo as newRef
 		fullSinceRef := normalizeSinceRef(newRef, sinceRef)
-		fmt.Printf("Calculating diff with: %s\n", fullSinceRef)
-
-		oldImage, err := e.runtime.GetImageWithPlatform(ctx, fullSinceRef, pullPlatform)
-		if err != nil {
-			return "", fmt.Errorf("failed to get base image %s: %w", fullSinceRef, err)
+		var oldImage *runtime.ImageInfo
+		if opts.sinceBundle != nil {
+			fmt.Printf("Calculating diff with: %s (from %s)\n", fullSinceRef, filepath.Base(opts.sinceFile()))
+			oldImage = bundleImageInfo(opts.sinceBundle)
+		} else {
+			fmt.Printf("Calculating diff with: %s\n", fullSinceRef)
+			oldImage, err = e.runtime.GetImageWithPlatform(ctx, fullSinceRef, pullPlatform)
+			if err != nil {
+				return "", fmt.Errorf("failed to get base image %s: %w", fullSinceRef, err)
+			}
+		}
+		if err := checkBasePlatform(newRef, runtimePlatform(newImage), fullSinceRef, runtimePlatform(oldImage)); err != nil {
+			return "", err
 		}
 
+		// Only the common prefix can be taken from the base on load
 		oldLayers = make(map[string]bool)
-		for _, layer := range oldImage.Layers {
+		sharedCount := 0
+		for i, layer := range newImage.Layers {
+			if i >= len(oldImage.Layers) || oldImage.Layers[i].Digest != layer.Digest {
+				break
+			}
 			oldLayers[layer.Digest] = true
+			sharedCount++
 		}
 
 		// Use fullSinceRef for metadata
 		sinceRef = fullSinceRef
-	}
 
-	// Save the new image to a temp file
-	tempFile, err := os.CreateTemp("", "imgcd-*.tar")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+		// Shared layers and an identical config mean nothing at all changed
+		if opts.SkipUnchanged && sharedCount == len(newImage.Layers) && newImage.ID == oldImage.ID {
+			return "", errNoChanges
+		}
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	fmt.Printf("Saving image %s...\n", newRef)
-	if err := e.runtime.SaveImage(ctx, newRef, tempFile.Name()); err != nil {
-		return "", fmt.Errorf("failed to save image: %w", err)
+	// Runtimes with a content store can hand out individual layers,
+	// so there is no need to save the whole image first
+	if cs, ok := e.runtime.(runtime.ContentStore); ok {
+		bundlePath, err := e.exportFromContentStore(ctx, cs, newRef, sinceRef, outDir, opts)
+		if err == nil || errors.Is(err, errNoChanges) || errors.Is(err, errBasePlatform) {
+			return bundlePath, err
+		}
+		fmt.Printf("Content store export failed (%v), falling back to image save...\n", err)
 	}
 
 	// Create output file
@@ -126,29 +429,63 @@ func (e *Exporter) exportLocal(ctx context.Context, newRef, sinceRef, outDir str
 		return "", fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// First create the tar.gz (either full or incremental)
-	var tarGzPath string
+	// The saved image is larger than the bundle; keep it on the output's volume
+	workDir, cleanup, err := stagingDir(outDir)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	e.workDir = workDir
 
-	if oldLayers == nil {
-		fmt.Printf("Creating full export...\n")
-		tarGzPath = generateFilename(repo, tag, sinceRef, outDir, true)
-		tarGzPath, err = e.compressImage(tempFile.Name(), tarGzPath, newRef, sinceRef)
-	} else {
-		fmt.Printf("Creating incremental export...\n")
-		tarGzPath = generateFilename(repo, tag, sinceRef, outDir, true)
-		tarGzPath, err = e.createIncrementalExport(tempFile.Name(), tarGzPath, newRef, sinceRef, oldLayers)
+	// First create the tar.gz (either full or incremental)
+	tarGzPath := generateFilename(repo, tag, sinceRef, outDir, true)
+	createdAt := time.Now()
+	meta := v1Metadata{
+		Version:     "1.0",
+		NewRef:      newRef,
+		SinceRef:    sinceRef,
+		CreatedAt:   createdAt.Format(time.RFC3339),
+		ExpiresAt:   formatExpiry(opts.ExpiresAt),
+		Note:        opts.Note,
+		Annotations: opts.Annotations,
+		OnLoad:      opts.OnLoad,
+		Provenance:  opts.Provenance,
+		Binary:      opts.recordedBinaryPlatform(),
 	}
 
+	// Only docker reports the image ID as a content digest
+	imageID := ""
+	if strings.HasPrefix(newImage.ID, "sha256:") {
+		imageID = newImage.ID
+	}
+	extras, err := extraEntries(opts, newRef, sinceRef, imageID, createdAt)
 	if err != nil {
 		return "", err
 	}
 
+	if oldLayers != nil {
+		fmt.Printf("Creating incremental export...\n")
+		_, err := e.createIncrementalExport(ctx, tarGzPath, meta, oldLayers, extras)
+		if errors.Is(err, errSharedLayerReused) {
+			ui.Fwarning(os.Stdout, "%v, creating full export instead.", err)
+			oldLayers = nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	if oldLayers == nil {
+		fmt.Printf("Creating full export...\n")
+		if err := e.createFullExport(ctx, tarGzPath, meta, extras); err != nil {
+			return "", err
+		}
+	}
+
 	// Create tar bundle
 	fmt.Printf("Creating bundle for %s...\n", opts.TargetPlatform)
 	bundlePath := generateFilename(repo, tag, sinceRef, outDir, false)
 
-	bundleGen := NewBundleGenerator(e.version)
-	if err := bundleGen.GenerateBundle(tarGzPath, bundlePath, opts.TargetPlatform, newRef); err != nil {
+	if err := generateBundle(e.version, tarGzPath, bundlePath, newRef, opts); err != nil {
 		return "", fmt.Errorf("failed to create bundle: %w", err)
 	}
 
@@ -158,7 +495,7 @@ func (e *Exporter) exportLocal(ctx context.Context, newRef, sinceRef, outDir str
 	return bundlePath, nil
 }
 
-func (e *Exporter) compressImage(inputPath, outputPath, newRef, sinceRef string) (string, error) {
+func (e *Exporter) compressImage(inputPath, outputPath string, meta v1Metadata, extras []bundleEntry) (string, error) {
 	// Open input file
 	inFile, err := os.Open(inputPath)
 	if err != nil {
@@ -174,7 +511,7 @@ func (e *Exporter) compressImage(inputPath, outputPath, newRef, sinceRef string)
 	defer outFile.Close()
 
 	// Create gzip writer
-	gzw := gzip.NewWriter(outFile)
+	gzw := pgzip.NewWriter(outFile)
 	defer gzw.Close()
 
 	// Create tar writer for metadata
@@ -182,30 +519,12 @@ func (e *Exporter) compressImage(inputPath, outputPath, newRef, sinceRef string)
 	defer tw.Close()
 
 	// Add metadata
-	meta := map[string]string{
-		"version":   "1.0",
-		"new_ref":   newRef,
-		"since_ref": sinceRef,
-	}
-	metaBytes, _ := json.MarshalIndent(meta, "", "  ")
-
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "imgcd-meta.json",
-		Mode: 0644,
-		Size: int64(len(metaBytes)),
-	}); err != nil {
-		return "", err
-	}
-	if _, err := tw.Write(metaBytes); err != nil {
+	if err := writeMetadata(tw, "imgcd-meta.json", meta, extras); err != nil {
 		return "", err
 	}
 
 	// Copy the original tar into our tar
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "image.tar",
-		Mode: 0644,
-		Size: getFileSize(inputPath),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader("image.tar", 0644, getFileSize(inputPath))); err != nil {
 		return "", err
 	}
 
@@ -216,9 +535,42 @@ func (e *Exporter) compressImage(inputPath, outputPath, newRef, sinceRef string)
 	return outputPath, nil
 }
 
-func (e *Exporter) createIncrementalExport(inputPath, outputPath, newRef, sinceRef string, oldLayers map[string]bool) (string, error) {
+// createFullExport saves the whole image and wraps it into a v1.0 tar.gz
+func (e *Exporter) createFullExport(ctx context.Context, outputPath string, meta v1Metadata, extras []bundleEntry) error {
+	// Save the new image to a temp file
+	tempFile, err := os.CreateTemp(e.workDir, "imgcd-*.tar")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	fmt.Printf("Saving image %s...\n", meta.NewRef)
+	if err := e.runtime.SaveImage(ctx, meta.NewRef, tempFile.Name()); err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+
+	_, err = e.compressImage(tempFile.Name(), outputPath, meta, extras)
+	return err
+}
+
+// createIncrementalExport streams the image out of the runtime, dropping
+// layers already present in the base image before they reach the disk
+func (e *Exporter) createIncrementalExport(ctx context.Context, outputPath string, meta v1Metadata, oldLayers map[string]bool, extras []bundleEntry) (string, error) {
+	spoolDir, err := os.MkdirTemp(e.workDir, "imgcd-spool-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(spoolDir)
+
+	fmt.Printf("Streaming image %s...\n", meta.NewRef)
+	spool, err := spoolImage(ctx, e.runtime, meta.NewRef, spoolDir, oldLayers)
+	if err != nil {
+		return "", fmt.Errorf("failed to save image: %w", err)
+	}
+
 	// Use the new v2 implementation for real incremental export
-	return e.createIncrementalExportV2(inputPath, outputPath, newRef, sinceRef, oldLayers)
+	return e.createIncrementalExportV2(spool, outputPath, meta, extras)
 }
 
 func parseReference(ref string) (repo, tag string) {
@@ -243,6 +595,17 @@ func normalizeSinceRef(newRef, sinceRef string) string {
 	return fmt.Sprintf("%s:%s", repo, sinceRef)
 }
 
+// BundleFilename returns the file name save gives the bundle of ref, e.g.
+// myapp-1.1__since-1.0.tar; sinceRef may be a tag of ref's repository
+func BundleFilename(ref, sinceRef string) string {
+	ref, sinceRef = imageName(ref), imageName(sinceRef)
+	if sinceRef != "" {
+		sinceRef = normalizeSinceRef(ref, sinceRef)
+	}
+	repo, tag := parseReference(ref)
+	return filepath.Base(generateFilename(repo, tag, sinceRef, "", false))
+}
+
 func generateFilename(repo, tag, sinceRef, outDir string, isTarGz bool) string {
 	// Clean repository name (replace / and : with _)
 	cleanRepo := strings.ReplaceAll(repo, "/", "_")
@@ -264,6 +627,14 @@ func generateFilename(repo, tag, sinceRef, outDir string, isTarGz bool) string {
 	return filepath.Join(outDir, filename)
 }
 
+// formatExpiry renders an expiry time for metadata, empty if unset
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
 func getFileSize(path string) int64 {
 	fi, err := os.Stat(path)
 	if err != nil {
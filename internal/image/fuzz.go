@@ -0,0 +1,54 @@
+//go:build gofuzz
+
+package image
+
+// go-fuzz / OSS-Fuzz targets for how bundles are recognized and where their
+// payload starts; see scripts/fuzz.sh. Each returns 1 for input that parsed
+// and 0 otherwise.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+// FuzzSelfExtractorHeader parses the header of a self-extracting bundle;
+// the payload it points to must lie within the file
+func FuzzSelfExtractorHeader(data []byte) int {
+	header, err := parseSelfExtractorHeader(data)
+	if err != nil {
+		return 0
+	}
+	if header.PayloadOffset < 0 || header.PayloadSize < 0 {
+		panic(fmt.Sprintf("header accepted payload offset %d, size %d", header.PayloadOffset, header.PayloadSize))
+	}
+	return 1
+}
+
+// FuzzBundleStream detects the format of a bundle stream (image data, bundle
+// tar or self-extractor), skips to the payload and reads the image data
+func FuzzBundleStream(data []byte) int {
+	s := newBundleStream(bytes.NewReader(data), true)
+	defer s.Close()
+
+	image, err := s.open(io.Discard, "fuzz")
+	if err != nil {
+		return 0
+	}
+	br, err := bundle.NewReader(image)
+	if err != nil {
+		return 0
+	}
+	defer br.Close()
+	for entry, err := range br.All() {
+		if err != nil {
+			return 0
+		}
+		if _, err := io.Copy(io.Discard, entry); err != nil {
+			return 0
+		}
+	}
+	return 1
+}
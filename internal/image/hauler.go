@@ -0,0 +1,21 @@
+package image
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// haulerKindAnnotation says what a manifest of a Hauler store holds
+	haulerKindAnnotation = "kind"
+	// haulerImageKind is the kind of container images; charts, files and
+	// signatures have others
+	haulerImageKind = "dev.cosignproject.cosign/image"
+)
+
+// haulName is the store archive name of a converted bundle, e.g.
+// myapp-2.0__since-none.haul.tar.zst, which hauler store load takes
+func haulName(bundlePath string) string {
+	base := filepath.Base(bundlePath)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ".haul.tar.zst"
+}
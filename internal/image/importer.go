@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -16,6 +17,7 @@ import (
 // Importer imports container images from tar.gz archives
 type Importer struct {
 	runtime runtime.Runtime
+	targets bool // Loads into other hosts (--context, --host)
 }
 
 // NewImporter creates a new image importer
@@ -28,38 +30,286 @@ func NewImporter() (*Importer, error) {
 	return &Importer{runtime: rt}, nil
 }
 
-// Import imports an image from a tar.gz file
-func (i *Importer) Import(ctx context.Context, archivePath string) (string, error) {
-	fmt.Printf("Using runtime: %s\n", i.runtime.Name())
-	fmt.Printf("Loading bundle: %s\n", archivePath)
+// NewTargetImporter creates an importer that loads into the given docker
+// contexts or hosts (ssh://, tcp://), ssh:// hosts also with containerd; with
+// several, each load streams into all of them at once
+func NewTargetImporter(targets []string) (*Importer, error) {
+	rt, err := runtime.NewDockerTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Importer{runtime: rt, targets: true}, nil
+}
 
-	// Load bundle using BundleLoader
-	loader := NewBundleLoader(i.runtime)
-	if err := loader.LoadBundle(ctx, archivePath); err != nil {
+// NewArtifactImporter creates an importer without a container runtime; it
+// can only load bundles of OCI artifacts
+func NewArtifactImporter() *Importer {
+	return &Importer{}
+}
+
+// Import imports an image from a bundle file: the image.tar.gz written by
+// save, the .tar bundle around it, or a self-extracting .sh bundle. The file
+// is read once, as ImportStream reads a stream: the metadata, the
+// running-container check, the checksums (the payload's of .sh bundles and
+// opts.SHA256) and the blobs all come from the same pass. An image.tar.gz
+// unpacked from a bundle tar is checked against the checksums.txt next to
+// it, as in the bundle.
+func (i *Importer) Import(ctx context.Context, archivePath string, opts LoadOptions) (string, error) {
+	loader, err := i.importFile(ctx, archivePath, opts)
+	if err != nil {
 		return "", err
 	}
+	return loader.loaded, nil
+}
 
-	// Extract image name from bundle metadata
-	imageName, err := i.extractImageName(archivePath)
+// ImportFile runs Import and returns the summary of the loaded bundle
+func (i *Importer) ImportFile(ctx context.Context, archivePath string, opts LoadOptions) (*BundleSummary, error) {
+	loader, err := i.importFile(ctx, archivePath, opts)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return loader.summary, nil
+}
+
+// importFile runs Import and returns the loader
+func (i *Importer) importFile(ctx context.Context, archivePath string, opts LoadOptions) (*BundleLoader, error) {
+	checksums, err := siblingChecksums(archivePath)
+	if err != nil {
+		return nil, err
 	}
+	opts.checksums = checksums
 
-	return imageName, nil
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer file.Close()
+
+	return i.importStream(ctx, file, archivePath, opts)
 }
 
-// extractImageName reads the metadata to get the image name
-// Supports both v1.0 (imgcd-meta.json) and v2 (metadata.json) formats
-func (i *Importer) extractImageName(archivePath string) (string, error) {
-	f, err := os.Open(archivePath)
+// isGzipFile reports whether the file starts with the gzip magic bytes
+func isGzipFile(path string) (bool, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return false, err
 	}
-	defer f.Close()
+	defer file.Close()
+
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false, nil
+	}
+	return magic[0] == 0x1f && magic[1] == 0x8b, nil
+}
 
-	gzr, err := gzip.NewReader(f)
+// openTarEntryFile returns the content of the named entry of a tar stored in
+// path at offset; size -1 reads to the end of the file
+func openTarEntryFile(path string, offset, size int64, name string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if size < 0 {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		size = info.Size() - offset
+	}
+
+	tr := tar.NewReader(io.NewSectionReader(file, offset, size))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			file.Close()
+			return nil, fmt.Errorf("%s not found", name)
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read tar: %w", err)
+		}
+		if bundle.CleanName(header.Name) == name {
+			return struct {
+				io.Reader
+				io.Closer
+			}{tr, file}, nil
+		}
+	}
+}
+
+// ImportSelfExtractor imports the image of a self-extracting bundle without
+// running its script: the payload is located through the header, and
+// checked against its checksum while it is streamed into the loader.
+func (i *Importer) ImportSelfExtractor(ctx context.Context, bundlePath string, opts LoadOptions) (string, error) {
+	if _, err := ReadSelfExtractorHeader(bundlePath); err != nil {
+		return "", fmt.Errorf("failed to read bundle header: %w", err)
+	}
+	return i.Import(ctx, bundlePath, opts)
+}
+
+// BundleSummary identifies a bundle and its place in an incremental chain
+type BundleSummary struct {
+	ImageRef  string
+	BaseRef   string // Full reference of the --since image, empty for full exports
+	CreatedAt string
+	Layers    []string // Compressed digests of all image layers; v2 bundles only
+	Artifact  string   // Artifact type of OCI artifact bundles, empty for images
+
+	BinaryPlatform string // Platform of the bundled imgcd binary, empty if not recorded
+
+	// Set by loads of v2 bundles: the layers taken from the base image, the
+	// blobs the bundle stored, and those an interrupted load had extracted
+	SharedLayers int
+	Blobs        int
+	Resumed      int
+
+	MoreImages []string // Further images of a multi-image bundle
+}
+
+// ImageRefs returns the references of every image in the bundle
+func (s *BundleSummary) ImageRefs() []string {
+	return append([]string{s.ImageRef}, s.MoreImages...)
+}
+
+// ReadBundleSummary reads the metadata of any bundle format without loading it
+func ReadBundleSummary(path string) (*BundleSummary, error) {
+	image, err := openBundleImage(path)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	return readBundleSummary(image)
+}
+
+// openBundleImage returns the image.tar.gz stream of a .sh, .tar or
+// image.tar.gz bundle
+func openBundleImage(path string) (io.ReadCloser, error) {
+	if header, err := ReadSelfExtractorHeader(path); err == nil {
+		return header.openPayloadImage(path)
+	} else if !errors.Is(err, errNotSelfExtractor) {
+		return nil, fmt.Errorf("failed to read bundle header: %w", err)
+	}
+
+	compressed, err := isGzipFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		return os.Open(path)
+	}
+
+	image, err := openTarEntryFile(path, 0, -1, "image.tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("unrecognized bundle %s: %w", path, err)
+	}
+	return image, nil
+}
+
+// OpenBundleData returns the image data (image.tar.gz) of a .sh, .tar or
+// image.tar.gz bundle, for bundle.NewReader
+func OpenBundleData(path string) (io.ReadCloser, error) {
+	return openBundleImage(path)
+}
+
+// WalkBundle calls fn for every entry of the image data of a .sh, .tar or
+// image.tar.gz bundle, in the order they are stored; metadata.json comes
+// first in bundles written by imgcd. Blobs fn reads to the end are checked
+// against their digest.
+func WalkBundle(path string, fn func(header *tar.Header, r io.Reader) error) error {
+	image, err := openBundleImage(path)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	br, err := bundle.NewReader(image)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+
+	for entry, err := range br.All() {
+		if err != nil {
+			return err
+		}
+		if err := fn(entry.Header, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newBundleSummary expands short --since tags ("3.19") to full references
+func newBundleSummary(imageRef, baseRef, createdAt string) *BundleSummary {
+	if baseRef != "" {
+		baseRef = normalizeSinceRef(imageRef, baseRef)
+	}
+	return &BundleSummary{ImageRef: imageRef, BaseRef: baseRef, CreatedAt: createdAt}
+}
+
+// metadataSummary returns the summary of a v2 bundle's metadata
+func metadataSummary(meta *bundle.Metadata) *BundleSummary {
+	summary := newBundleSummary(meta.ImageRef, meta.BaseRef, meta.CreatedAt)
+	summary.SharedLayers = meta.SharedLayerCount
+	if meta.Manifest != nil {
+		for _, layer := range meta.Manifest.Layers {
+			summary.Layers = append(summary.Layers, layer.Digest.String())
+		}
+	}
+	if meta.Artifact != nil {
+		summary.Artifact = meta.Artifact.Type()
+	}
+	summary.MoreImages = meta.ImageRefs()[1:]
+	summary.BinaryPlatform = meta.BinaryPlatform
+	return summary
+}
+
+// readBundleSummary reads the metadata entry of an image.tar.gz stream
+func readBundleSummary(r io.Reader) (*BundleSummary, error) {
+	meta, legacy, err := readMetadataEntry(r)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		return metadataSummary(meta), nil
+	}
+	summary := newBundleSummary(legacy.NewRef, legacy.SinceRef, legacy.CreatedAt)
+	summary.BinaryPlatform = legacy.Binary
+	return summary, nil
+}
+
+// readBundleMetadata reads the metadata of a v2 bundle of any format
+// without loading it
+func readBundleMetadata(path string) (*bundle.Metadata, error) {
+	image, err := openBundleImage(path)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	meta, _, err := readMetadataEntry(image)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("legacy (v1) bundles record no image config; save the image again")
+	}
+	return meta, nil
+}
+
+// readMetadataEntry reads the metadata entry of an image.tar.gz stream: the
+// v2 metadata, or that of a legacy bundle. It only needs the start of the
+// stream, which is why it does not use pgzip: its read-ahead would
+// decompress megabytes that are thrown away.
+func readMetadataEntry(r io.Reader) (*bundle.Metadata, *v1Metadata, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
 	}
 	defer gzr.Close()
 
@@ -71,34 +321,40 @@ func (i *Importer) extractImageName(archivePath string) (string, error) {
 			break
 		}
 		if err != nil {
-			return "", err
+			return nil, nil, err
 		}
 
 		// v2 format (remote mode)
-		if header.Name == "metadata.json" {
-			var meta bundle.Metadata
-			if err := json.NewDecoder(tr).Decode(&meta); err != nil {
-				return "", err
+		name := bundle.CleanName(header.Name)
+		if name == bundle.MetadataName {
+			raw, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, nil, err
+			}
+			meta, err := bundle.DecodeMetadata(raw)
+			if err != nil {
+				return nil, nil, err
 			}
-			return meta.ImageRef, nil
+			return meta, nil, nil
 		}
 
 		// v1.0 format (local mode)
-		if header.Name == "imgcd-meta.json" {
-			var v1Meta struct {
-				NewRef string `json:"new_ref"`
-			}
-			if err := json.NewDecoder(tr).Decode(&v1Meta); err != nil {
-				return "", err
+		if name == bundle.LegacyMetadataName {
+			var meta v1Metadata
+			if err := json.NewDecoder(tr).Decode(&meta); err != nil {
+				return nil, nil, err
 			}
-			return v1Meta.NewRef, nil
+			return nil, &meta, nil
 		}
 	}
 
-	return "", fmt.Errorf("metadata not found in bundle (expected metadata.json or imgcd-meta.json)")
+	return nil, nil, fmt.Errorf("metadata not found in bundle (expected metadata.json or imgcd-meta.json)")
 }
 
 // Close closes the importer
 func (i *Importer) Close() error {
+	if i.runtime == nil {
+		return nil
+	}
 	return i.runtime.Close()
 }
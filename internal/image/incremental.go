@@ -2,15 +2,19 @@ package image
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"strings"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/klauspost/pgzip"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/runtime"
 )
 
 // dockerManifest represents the manifest.json in docker save tar
@@ -20,71 +24,146 @@ type dockerManifest struct {
 	Layers   []string `json:"Layers"`
 }
 
+// errSharedLayerReused is returned when a layer of the base image appears again
+// above the shared prefix, e.g. the same COPY repeated later in a long chain
+var errSharedLayerReused = errors.New("a shared layer is repeated above the common prefix")
+
+// spooledLayer is a new (non-shared) layer unpacked from a docker save stream
+type spooledLayer struct {
+	DiffID string
+	Path   string
+}
+
+// sharedPrefixLength returns how many leading layers two images have in common.
+// Only a common prefix can be taken from the base on load: a layer applies on
+// top of everything below it, so a matching DiffID at another position does
+// not describe the same filesystem.
+func sharedPrefixLength(base, updated []v1.Hash) int {
+	n := 0
+	for n < len(base) && n < len(updated) && base[n] == updated[n] {
+		n++
+	}
+	return n
+}
+
+// configPlatform returns the platform an image config declares; nil when
+// it declares none
+func configPlatform(config *v1.ConfigFile) *v1.Platform {
+	if config == nil || config.OS == "" || config.Architecture == "" {
+		return nil
+	}
+	return &v1.Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+}
+
+// runtimePlatform returns the platform a runtime reported for an image;
+// nil when it reported none
+func runtimePlatform(info *runtime.ImageInfo) *v1.Platform {
+	if info.Platform == "" {
+		return nil
+	}
+	platform, err := v1.ParsePlatform(info.Platform)
+	if err != nil {
+		return nil
+	}
+	return platform
+}
+
+// samePlatform compares OS and architecture, and the variant when both name
+// one; platforms that are unknown compare equal
+func samePlatform(a, b *v1.Platform) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	return a.OS == b.OS && a.Architecture == b.Architecture &&
+		(a.Variant == "" || b.Variant == "" || a.Variant == b.Variant)
+}
+
+// errBasePlatform reports a --since base of another platform than the image
+var errBasePlatform = errors.New("platform mismatch")
+
+// checkBasePlatform fails when the --since base resolved to another platform
+// than the image: layers never match across platforms, so the diff would
+// keep every layer and quietly make a full-size bundle
+func checkBasePlatform(newRef string, newPlatform *v1.Platform, baseRef string, basePlatform *v1.Platform) error {
+	if samePlatform(newPlatform, basePlatform) {
+		return nil
+	}
+	return fmt.Errorf("base image %s is %s but %s is %s (%w): layers are never shared across platforms, so the bundle would hold every layer; pass a --since image that has a %s variant",
+		baseRef, basePlatform, newRef, newPlatform, errBasePlatform, newPlatform)
+}
+
 // createIncrementalExportV2 creates a real incremental export by filtering layers
-func (e *Exporter) createIncrementalExportV2(inputPath, outputPath, newRef, sinceRef string, oldLayerDigests map[string]bool) (string, error) {
-	// Parse the docker save tar to extract layers
-	img, err := tarball.ImageFromPath(inputPath, nil)
+// Shared layers were already dropped while spooling; this only assembles what is left
+func (e *Exporter) createIncrementalExportV2(spool *spooledImage, outputPath string, meta v1Metadata, extras []bundleEntry) (string, error) {
+	// Parse the docker save manifest to find config and layer order
+	manifestBytes, err := spool.readFile("manifest.json")
 	if err != nil {
 		return "", fmt.Errorf("failed to parse image tar: %w", err)
 	}
 
-	// Get layers from the new image
-	layers, err := img.Layers()
-	if err != nil {
-		return "", fmt.Errorf("failed to get layers: %w", err)
+	var manifests []dockerManifest
+	if err := json.Unmarshal(manifestBytes, &manifests); err != nil {
+		return "", fmt.Errorf("failed to parse manifest.json: %w", err)
+	}
+	if len(manifests) == 0 {
+		return "", fmt.Errorf("no manifests found in image tar")
 	}
 
 	// Get config
-	configFile, err := img.ConfigFile()
+	configBytes, err := spool.readFile(manifests[0].Config)
+	if err != nil {
+		return "", fmt.Errorf("failed to get config: %w", err)
+	}
+	configFile, err := v1.ParseConfigFile(bytes.NewReader(configBytes))
 	if err != nil {
 		return "", fmt.Errorf("failed to get config: %w", err)
 	}
 
-	// Filter out old layers
-	newLayers := []v1.Layer{}
-	newLayerPaths := []string{}
-	totalSize := int64(0)
+	// Collect the layers that survived spooling
+	layerNames := manifests[0].Layers
+	newLayers := []spooledLayer{}
 	filteredSize := int64(0)
 
-	for i, layer := range layers {
-		// Get DiffID (uncompressed digest) to match docker inspect format
-		diffID, err := layer.DiffID()
-		if err != nil {
-			return "", fmt.Errorf("failed to get layer DiffID: %w", err)
-		}
-
-		size, err := layer.Size()
-		if err != nil {
-			size = 0
-		}
-		totalSize += size
-
-		// Check if this layer exists in old image (using DiffID)
-		if oldLayerDigests[diffID.String()] {
+	for _, layerName := range layerNames {
+		layerName = path.Clean(layerName)
+		if size, shared := spool.skipped[layerName]; shared {
+			// A shared layer repeated above the common prefix was dropped while
+			// spooling, but the loader can only take the prefix from the base
+			if len(newLayers) > 0 {
+				return "", errSharedLayerReused
+			}
 			filteredSize += size
 			continue
 		}
 
-		newLayers = append(newLayers, layer)
-		newLayerPaths = append(newLayerPaths, fmt.Sprintf("layer-%d.tar", i))
+		layerPath, ok := spool.files[layerName]
+		if !ok {
+			return "", fmt.Errorf("layer %s not found in image archive", layerName)
+		}
+
+		newLayers = append(newLayers, spooledLayer{
+			DiffID: spool.digests[layerName],
+			Path:   layerPath,
+		})
 	}
 
-	fmt.Printf("Filtered %d/%d layers (saved %.1f MB)\n",
-		len(layers)-len(newLayers), len(layers),
-		float64(filteredSize)/(1024*1024))
+	fmt.Printf("Filtered %d/%d layers (saved %s)\n",
+		len(layerNames)-len(newLayers), len(layerNames),
+		humanize.Size(filteredSize))
 
-	// If all layers are filtered, we still need to export something
+	// Every layer is in the base image: the bundle only needs metadata,
+	// load rebuilds the image entirely from the base
 	if len(newLayers) == 0 {
-		fmt.Printf("Warning: All layers already exist in base image. Creating minimal export.\n")
-		// Fall back to full export in this case
-		return e.compressImage(inputPath, outputPath, newRef, sinceRef)
+		fmt.Printf("All layers already exist in base image. Creating config-only bundle.\n")
 	}
 
 	// Create the incremental tar.gz
-	return e.createIncrementalTar(outputPath, newRef, sinceRef, configFile, newLayers, newLayerPaths)
+	meta.Incremental = true
+	meta.LayerCount = len(newLayers)
+	return e.createIncrementalTar(outputPath, meta, configFile, newLayers, extras)
 }
 
-func (e *Exporter) createIncrementalTar(outputPath, newRef, sinceRef string, config *v1.ConfigFile, layers []v1.Layer, layerPaths []string) (string, error) {
+func (e *Exporter) createIncrementalTar(outputPath string, meta v1Metadata, config *v1.ConfigFile, layers []spooledLayer, extras []bundleEntry) (string, error) {
 	// Create output file
 	outFile, err := os.Create(outputPath)
 	if err != nil {
@@ -93,7 +172,7 @@ func (e *Exporter) createIncrementalTar(outputPath, newRef, sinceRef string, con
 	defer outFile.Close()
 
 	// Create gzip writer
-	gzw := gzip.NewWriter(outFile)
+	gzw := pgzip.NewWriter(outFile)
 	defer gzw.Close()
 
 	// Create tar writer
@@ -101,29 +180,13 @@ func (e *Exporter) createIncrementalTar(outputPath, newRef, sinceRef string, con
 	defer tw.Close()
 
 	// Write imgcd metadata
-	meta := map[string]interface{}{
-		"version":     "1.0",
-		"new_ref":     newRef,
-		"since_ref":   sinceRef,
-		"incremental": true,
-		"layer_count": len(layers),
-	}
-	metaBytes, _ := json.MarshalIndent(meta, "", "  ")
-
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "imgcd-meta.json",
-		Mode: 0644,
-		Size: int64(len(metaBytes)),
-	}); err != nil {
-		return "", err
-	}
-	if _, err := tw.Write(metaBytes); err != nil {
+	if err := writeMetadata(tw, "imgcd-meta.json", meta, extras); err != nil {
 		return "", err
 	}
 
 	// Now create a nested tar for the docker image format
 	// We need to create: manifest.json, config.json, and layer tars
-	imageTar, err := e.createDockerImageTar(config, layers, layerPaths, newRef)
+	imageTar, err := e.createDockerImageTar(config, layers, meta.NewRef)
 	if err != nil {
 		return "", fmt.Errorf("failed to create image tar: %w", err)
 	}
@@ -141,11 +204,7 @@ func (e *Exporter) createIncrementalTar(outputPath, newRef, sinceRef string, con
 		return "", err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "image.tar",
-		Mode: 0644,
-		Size: imageInfo.Size(),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader("image.tar", 0644, imageInfo.Size())); err != nil {
 		return "", err
 	}
 
@@ -156,9 +215,9 @@ func (e *Exporter) createIncrementalTar(outputPath, newRef, sinceRef string, con
 	return outputPath, nil
 }
 
-func (e *Exporter) createDockerImageTar(config *v1.ConfigFile, layers []v1.Layer, layerPaths []string, imageRef string) (string, error) {
+func (e *Exporter) createDockerImageTar(config *v1.ConfigFile, layers []spooledLayer, imageRef string) (string, error) {
 	// Create temp file for the docker image tar
-	tempFile, err := os.CreateTemp("", "imgcd-image-*.tar")
+	tempFile, err := os.CreateTemp(e.workDir, "imgcd-image-*.tar")
 	if err != nil {
 		return "", err
 	}
@@ -169,7 +228,7 @@ func (e *Exporter) createDockerImageTar(config *v1.ConfigFile, layers []v1.Layer
 	defer tw.Close()
 
 	// Write config file
-	configHash := fmt.Sprintf("%x", config.RootFS.DiffIDs[0]) // Simplified - use first layer hash
+	configHash := "unknown"
 	if len(config.RootFS.DiffIDs) > 0 {
 		configHash = strings.TrimPrefix(config.RootFS.DiffIDs[0].String(), "sha256:")[:12]
 	}
@@ -180,87 +239,29 @@ func (e *Exporter) createDockerImageTar(config *v1.ConfigFile, layers []v1.Layer
 		return "", err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: configName,
-		Mode: 0644,
-		Size: int64(len(configBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader(configName, 0644, int64(len(configBytes)))); err != nil {
 		return "", err
 	}
 	if _, err := tw.Write(configBytes); err != nil {
 		return "", err
 	}
 
-	// Write layers
+	// Write layers straight from the spool, no intermediate copies
+	// A layer repeated in the chain is stored once and referenced twice
 	writtenLayerPaths := []string{}
+	written := make(map[string]bool)
 	for _, layer := range layers {
-		digest, _ := layer.Digest()
-		layerDir := strings.TrimPrefix(digest.String(), "sha256:")[:12]
+		layerDir := strings.TrimPrefix(layer.DiffID, "sha256:")[:12]
 		layerPath := layerDir + "/layer.tar"
 		writtenLayerPaths = append(writtenLayerPaths, layerPath)
 
-		// Get layer content
-		rc, err := layer.Compressed()
-		if err != nil {
-			return "", err
-		}
-
-		// We need to get the uncompressed layer for docker format
-		layerReader, err := layer.Uncompressed()
-		if err != nil {
-			rc.Close()
-			return "", err
-		}
-
-		// Create a temp file for the layer
-		layerTemp, err := os.CreateTemp("", "layer-*.tar")
-		if err != nil {
-			layerReader.Close()
-			rc.Close()
-			return "", err
-		}
-		_, err = io.Copy(layerTemp, layerReader)
-		layerReader.Close()
-		rc.Close()
-		layerTemp.Close()
-
-		if err != nil {
-			os.Remove(layerTemp.Name())
-			return "", err
-		}
-
-		// Add layer to tar
-		layerFile, err := os.Open(layerTemp.Name())
-		if err != nil {
-			os.Remove(layerTemp.Name())
-			return "", err
-		}
-
-		layerInfo, err := layerFile.Stat()
-		if err != nil {
-			layerFile.Close()
-			os.Remove(layerTemp.Name())
-			return "", err
-		}
-
-		if err := tw.WriteHeader(&tar.Header{
-			Name: layerPath,
-			Mode: 0644,
-			Size: layerInfo.Size(),
-		}); err != nil {
-			layerFile.Close()
-			os.Remove(layerTemp.Name())
-			return "", err
+		if written[layerPath] {
+			continue
 		}
-
-		if _, err := io.Copy(tw, layerFile); err != nil {
-			layerFile.Close()
-			os.Remove(layerTemp.Name())
+		if err := addFileToTar(tw, layer.Path, layerPath, 0644); err != nil {
 			return "", err
 		}
-
-		layerFile.Close()
-		os.Remove(layerTemp.Name())
+		written[layerPath] = true
 	}
 
 	// Write manifest.json
@@ -277,17 +278,18 @@ func (e *Exporter) createDockerImageTar(config *v1.ConfigFile, layers []v1.Layer
 		return "", err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "manifest.json",
-		Mode: 0644,
-		Size: int64(len(manifestBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader("manifest.json", 0644, int64(len(manifestBytes)))); err != nil {
 		return "", err
 	}
 	if _, err := tw.Write(manifestBytes); err != nil {
 		return "", err
 	}
 
+	// Metadata-only exports carry no layers; load takes them all from the base
+	if len(writtenLayerPaths) == 0 {
+		return tempPath, nil
+	}
+
 	// Write repositories file (optional but docker expects it)
 	repo, tag := parseReference(imageRef)
 	repositories := map[string]map[string]string{
@@ -301,11 +303,7 @@ func (e *Exporter) createDockerImageTar(config *v1.ConfigFile, layers []v1.Layer
 		return "", err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "repositories",
-		Mode: 0644,
-		Size: int64(len(repoBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader("repositories", 0644, int64(len(repoBytes)))); err != nil {
 		return "", err
 	}
 	if _, err := tw.Write(repoBytes); err != nil {
@@ -0,0 +1,316 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/provenance"
+)
+
+// BundleInfo is everything a bundle records about itself, read without a
+// container runtime
+type BundleInfo struct {
+	Path    string `json:"path"`
+	Format  string `json:"format"`  // "tar", "self-extracting" or "tar.gz"
+	Version string `json:"version"` // Metadata version: "2", or "1.0" for legacy bundles
+	Size    int64  `json:"size"`    // Size of the bundle file
+
+	// Builder and TargetPlatform come from the header of self-extracting bundles
+	Builder        string `json:"builder,omitempty"`
+	TargetPlatform string `json:"target_platform,omitempty"`
+
+	ImageRef    string             `json:"image_ref"`
+	BaseRef     string             `json:"base_ref,omitempty"`
+	Platform    string             `json:"platform,omitempty"`
+	Binary      string             `json:"binary_platform,omitempty"` // Platform of the bundled imgcd, if recorded
+	CreatedAt   string             `json:"created_at,omitempty"`
+	ExpiresAt   string             `json:"expires_at,omitempty"`
+	Note        string             `json:"note,omitempty"`
+	Annotations map[string]string  `json:"annotations,omitempty"`
+	Provenance  *bundle.Provenance `json:"provenance,omitempty"`
+	Artifact    *bundle.Artifact   `json:"artifact,omitempty"`
+
+	// Images lists every image of a multi-image bundle, ImageRef being the first
+	Images []ImageSummary `json:"images,omitempty"`
+
+	// Layers lists every layer of the image in order; layers of an
+	// incremental bundle that come from the base are not Stored. For
+	// multi-image bundles, it lists the layers of all images, shared ones once.
+	Layers     []LayerSummary `json:"layers"`
+	StoredSize int64          `json:"stored_size"` // Total size of the stored layers
+
+	Extras    []string `json:"extras,omitempty"`  // Attached files, relative to extras/
+	OnLoad    []string `json:"on_load,omitempty"` // Commands for load --run-post-load
+	Statement bool     `json:"provenance_statement,omitempty"`
+	SBOM      string   `json:"sbom,omitempty"`   // Entry of the stored SBOM (save --sbom), see ReadBundleSBOM
+	Signed    bool     `json:"signed,omitempty"` // Carries an embedded signature (save --sign-key)
+}
+
+// ImageSummary describes one image of a multi-image bundle
+type ImageSummary struct {
+	ImageRef string `json:"image_ref"`
+	Platform string `json:"platform"`
+	Layers   int    `json:"layers"`
+	Size     int64  `json:"size"` // Compressed size of its layers, including those shared with other images
+}
+
+// LayerSummary describes one layer of a bundle's image
+type LayerSummary struct {
+	Digest    string `json:"digest"` // Layer path inside image.tar for legacy bundles without digests
+	DiffID    string `json:"diffid,omitempty"`
+	MediaType string `json:"media_type,omitempty"`
+	Size      int64  `json:"size"`
+	Stored    bool   `json:"stored"`
+}
+
+// InspectBundle reads the metadata and entry list of a bundle of any format
+func InspectBundle(bundlePath string) (*BundleInfo, error) {
+	stat, err := os.Stat(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	info := &BundleInfo{Path: bundlePath, Size: stat.Size(), Format: "tar"}
+
+	var signature io.ReadCloser
+	if header, err := ReadSelfExtractorHeader(bundlePath); err == nil {
+		info.Format = "self-extracting"
+		info.Builder = header.Version
+		info.TargetPlatform = header.TargetPlatform
+		signature, _ = openTarEntryFile(bundlePath, header.PayloadOffset, header.PayloadSize, bundle.SignatureName)
+	} else if !errors.Is(err, errNotSelfExtractor) {
+		return nil, fmt.Errorf("failed to read bundle header: %w", err)
+	} else if compressed, err := isGzipFile(bundlePath); err != nil {
+		return nil, err
+	} else if compressed {
+		info.Format = "tar.gz"
+	} else {
+		signature, _ = openTarEntryFile(bundlePath, 0, -1, bundle.SignatureName)
+	}
+	if signature != nil {
+		info.Signed = true
+		signature.Close()
+	}
+
+	image, err := openBundleImage(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	if err := info.read(image); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// read fills the info from the image.tar.gz stream
+func (info *BundleInfo) read(r io.Reader) error {
+	br, err := bundle.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+
+	var legacy *v1Metadata
+	var legacyLayers []LayerSummary
+	blobs := make(map[string]int64)
+
+	for entry, err := range br.All() {
+		if err != nil {
+			return err
+		}
+		header := entry.Header
+
+		switch {
+		case entry.Kind == bundle.LegacyMetadataEntry:
+			legacy = &v1Metadata{}
+			if err := json.NewDecoder(entry).Decode(legacy); err != nil {
+				return fmt.Errorf("failed to decode metadata: %w", err)
+			}
+
+		case header.Name == "image.tar":
+			legacyLayers, err = dockerArchiveLayers(entry)
+			if err != nil {
+				return fmt.Errorf("failed to read image.tar: %w", err)
+			}
+
+		case entry.Kind == bundle.BlobEntry:
+			blobs[entry.Digest] = header.Size
+
+		case strings.HasPrefix(header.Name, extrasPrefix):
+			info.Extras = append(info.Extras, strings.TrimPrefix(header.Name, extrasPrefix))
+
+		case header.Name == provenance.StatementFileName:
+			info.Statement = true
+
+		case isSBOMEntry(header.Name):
+			info.SBOM = header.Name
+		}
+	}
+
+	switch metadata := br.Metadata(); {
+	case metadata != nil:
+		info.fromMetadata(metadata, blobs)
+	case legacy != nil:
+		info.fromLegacy(legacy, legacyLayers)
+	default:
+		return fmt.Errorf("metadata not found in bundle (expected metadata.json or imgcd-meta.json)")
+	}
+
+	if info.Layers == nil {
+		info.Layers = []LayerSummary{}
+	}
+	for _, layer := range info.Layers {
+		if layer.Stored {
+			info.StoredSize += layer.Size
+		}
+	}
+	return nil
+}
+
+// fromMetadata fills the info from v2 metadata; blobs are the sizes of the
+// blobs actually present in the bundle
+func (info *BundleInfo) fromMetadata(meta *bundle.Metadata, blobs map[string]int64) {
+	info.Version = meta.Version
+	info.ImageRef = meta.ImageRef
+	info.BaseRef = meta.BaseRef
+	info.Platform = strings.Join(meta.Platforms(), ", ")
+	info.Binary = meta.BinaryPlatform
+	info.CreatedAt = meta.CreatedAt
+	info.ExpiresAt = meta.ExpiresAt
+	info.Note = meta.Note
+	info.Annotations = meta.Annotations
+	info.Provenance = meta.Provenance
+	info.Artifact = meta.Artifact
+	info.OnLoad = meta.OnLoad
+
+	// Artifacts list their config blob as well, and have no base
+	if meta.Manifest == nil || meta.Artifact != nil {
+		for _, layer := range meta.Layers {
+			_, stored := blobs[layer.Digest]
+			info.Layers = append(info.Layers, LayerSummary{
+				Digest:    layer.Digest,
+				DiffID:    layer.DiffID,
+				MediaType: layer.MediaType,
+				Size:      layer.Size,
+				Stored:    stored,
+			})
+		}
+		return
+	}
+
+	if len(meta.Images) == 0 {
+		info.Layers = imageLayers(meta, blobs)
+		return
+	}
+
+	listed := make(map[string]bool)
+	for _, image := range meta.PerImage() {
+		summary := ImageSummary{ImageRef: image.ImageRef, Platform: image.Platform}
+		for _, layer := range imageLayers(image, blobs) {
+			summary.Layers++
+			summary.Size += layer.Size
+			if !listed[layer.Digest] {
+				listed[layer.Digest] = true
+				info.Layers = append(info.Layers, layer)
+			}
+		}
+		info.Images = append(info.Images, summary)
+	}
+}
+
+// imageLayers lists the layers of one image of v2 metadata
+func imageLayers(meta *bundle.Metadata, blobs map[string]int64) []LayerSummary {
+	if meta.Manifest == nil {
+		return nil
+	}
+	var layers []LayerSummary
+	for i, desc := range meta.Manifest.Layers {
+		layer := LayerSummary{
+			Digest:    desc.Digest.String(),
+			MediaType: string(desc.MediaType),
+			Size:      desc.Size,
+		}
+		if meta.Config != nil && i < len(meta.Config.RootFS.DiffIDs) {
+			layer.DiffID = meta.Config.RootFS.DiffIDs[i].String()
+		}
+		_, layer.Stored = blobs[layer.Digest]
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+// fromLegacy fills the info from v1 metadata and the layers of its image.tar
+func (info *BundleInfo) fromLegacy(meta *v1Metadata, layers []LayerSummary) {
+	info.Version = meta.Version
+	info.ImageRef = meta.NewRef
+	info.BaseRef = meta.SinceRef
+	info.Binary = meta.Binary
+	info.CreatedAt = meta.CreatedAt
+	info.ExpiresAt = meta.ExpiresAt
+	info.Note = meta.Note
+	info.Annotations = meta.Annotations
+	info.Provenance = meta.Provenance
+	info.OnLoad = meta.OnLoad
+	info.Layers = layers
+	if info.BaseRef != "" {
+		info.BaseRef = normalizeSinceRef(info.ImageRef, info.BaseRef)
+	}
+}
+
+// dockerArchiveLayers lists the layers of a docker save archive in manifest
+// order; layers the archive does not contain (taken from the base on load)
+// are not Stored
+func dockerArchiveLayers(r io.Reader) ([]LayerSummary, error) {
+	var manifest []dockerManifest
+	sizes := make(map[string]int64)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == "manifest.json" {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("failed to decode manifest.json: %w", err)
+			}
+			continue
+		}
+		if header.Typeflag == tar.TypeReg {
+			sizes[header.Name] = header.Size
+		}
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("manifest.json not found")
+	}
+
+	var layers []LayerSummary
+	for _, name := range manifest[0].Layers {
+		size, stored := sizes[name]
+		layers = append(layers, LayerSummary{
+			Digest: archiveLayerDigest(name),
+			Size:   size,
+			Stored: stored,
+		})
+	}
+	return layers, nil
+}
+
+// archiveLayerDigest derives the digest of blobs/<algorithm>/<hex> layer
+// paths; <id>/layer.tar paths carry no digest and are returned as they are
+func archiveLayerDigest(name string) string {
+	if digest, ok := bundle.BlobDigest(name); ok {
+		return digest
+	}
+	return name
+}
@@ -0,0 +1,591 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+// InteropFormat is the package format of another air-gap tool that bundles
+// are converted to and from
+type InteropFormat string
+
+const (
+	FormatZarf      InteropFormat = "zarf"   // Zarf package (zarf-package-*.tar.zst)
+	FormatHauler    InteropFormat = "hauler" // Hauler store, as archived by hauler store save
+	FormatSkopeoDir InteropFormat = "dir"    // A directory per image, as written by skopeo's dir: transport
+)
+
+// InteropFormats are the formats bundles convert to
+var InteropFormats = []InteropFormat{FormatZarf, FormatHauler, FormatSkopeoDir}
+
+// imageRefAnnotations name the image of a manifest in an OCI layout: Zarf
+// records it as the base name, Hauler (like containerd) as the image name
+var imageRefAnnotations = []string{
+	"org.opencontainers.image.base.name",
+	"io.containerd.image.name",
+}
+
+// ConvertResult describes a converted bundle or package
+type ConvertResult struct {
+	Path    string
+	Format  InteropFormat // Format of the package read or written
+	Images  []string      // Converted images, with their platform
+	Skipped []string      // Manifests of a package that were not converted, with the reason
+}
+
+// ConvertBundle writes the images of a bundle as a Zarf package, Hauler
+// store archive or skopeo directories in outDir, without downloading
+// anything. Every layer must be in the bundle, so incremental bundles cannot
+// be converted, and neither can OCI artifacts or legacy bundles.
+func ConvertBundle(bundlePath string, format InteropFormat, outDir, version string) (*ConvertResult, error) {
+	if !slices.Contains(InteropFormats, format) {
+		return nil, fmt.Errorf("unsupported format %q (supported: zarf, hauler, dir)", format)
+	}
+
+	image, err := openBundleImage(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	tempDir, err := os.MkdirTemp("", "imgcd-convert-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	blobDir := filepath.Join(tempDir, "blobs")
+	if err := os.Mkdir(blobDir, 0755); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Reading %s...\n", filepath.Base(bundlePath))
+	contents, err := extractPushContents(image, blobDir)
+	if err != nil {
+		return nil, err
+	}
+	metadata := contents.metadata
+	switch {
+	case metadata == nil && contents.legacy != nil:
+		return nil, fmt.Errorf("legacy (v1) bundles cannot be converted; save the image again")
+	case metadata == nil:
+		return nil, fmt.Errorf("metadata not found in bundle (expected metadata.json)")
+	case metadata.Artifact != nil:
+		return nil, fmt.Errorf("OCI artifact bundles cannot be converted; load them with --oci-layout instead")
+	}
+
+	if format == FormatSkopeoDir {
+		return convertToSkopeoDirs(blobDir, contents, outDir)
+	}
+
+	packageDir := filepath.Join(tempDir, "package")
+	layoutDir := packageDir
+	if format == FormatZarf {
+		layoutDir = filepath.Join(packageDir, "images")
+	}
+	p, err := layout.Write(layoutDir, empty.Index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCI layout: %w", err)
+	}
+
+	result := &ConvertResult{Format: format}
+	var refs []string
+	for _, m := range metadata.PerImage() {
+		img, err := completeBundleImage(blobDir, contents, m)
+		if err != nil {
+			return nil, err
+		}
+
+		options := []layout.Option{layout.WithAnnotations(interopAnnotations(format, m.ImageRef))}
+		if platform, err := v1.ParsePlatform(m.Platform); err == nil && m.Platform != "" {
+			options = append(options, layout.WithPlatform(*platform))
+		}
+		fmt.Printf("Writing %s (%s)...\n", m.ImageRef, m.Platform)
+		if err := p.AppendImage(img, options...); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", m.ImageRef, err)
+		}
+		result.Images = append(result.Images, fmt.Sprintf("%s (%s)", m.ImageRef, m.Platform))
+		if !slices.Contains(refs, m.ImageRef) {
+			refs = append(refs, m.ImageRef)
+		}
+	}
+
+	var name string
+	switch format {
+	case FormatZarf:
+		name, err = writeZarfPackageConfig(packageDir, metadata, refs, filepath.Base(bundlePath), version)
+		if err != nil {
+			return nil, err
+		}
+	case FormatHauler:
+		name = haulName(bundlePath)
+	}
+
+	result.Path = filepath.Join(outDir, name)
+	fmt.Printf("Compressing %s...\n", name)
+	if err := writeZstdArchive(packageDir, result.Path); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return result, nil
+}
+
+// completeBundleImage returns an image of a bundle whose layers are all in
+// the bundle, which those of incremental bundles are not
+func completeBundleImage(blobDir string, contents *pushContents, m *bundle.Metadata) (v1.Image, error) {
+	for _, layer := range m.Manifest.Layers {
+		if !contents.blobs[layer.Digest.String()] {
+			return nil, fmt.Errorf("layer %s of %s comes from base image %s, which is not in the bundle; convert a bundle saved without --since", layer.Digest, m.ImageRef, m.BaseRef)
+		}
+	}
+	return newBundleImage(blobDir, m)
+}
+
+// convertToSkopeoDirs writes every image of a bundle to a directory of its
+// own in outDir, named after the image and, in bundles of several
+// platforms, the platform
+func convertToSkopeoDirs(blobDir string, contents *pushContents, outDir string) (*ConvertResult, error) {
+	images := contents.metadata.PerImage()
+	multiPlatform := len(contents.metadata.Platforms()) > 1
+	result := &ConvertResult{Format: FormatSkopeoDir, Path: outDir}
+	for _, m := range images {
+		img, err := completeBundleImage(blobDir, contents, m)
+		if err != nil {
+			return nil, err
+		}
+		repo, tag := parseReference(m.ImageRef)
+		dirName := strings.NewReplacer("/", "_", ":", "_").Replace(repo) + "-" + tag
+		if multiPlatform {
+			dirName += "-" + strings.ReplaceAll(m.Platform, "/", "-")
+		}
+		dir := filepath.Join(outDir, dirName)
+		fmt.Printf("Writing %s (%s)...\n", m.ImageRef, m.Platform)
+		if err := writeSkopeoDir(dir, img); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", dir, err)
+		}
+		if len(images) == 1 {
+			result.Path = dir
+		}
+		result.Images = append(result.Images, fmt.Sprintf("%s (%s): dir:%s", m.ImageRef, m.Platform, dir))
+	}
+	return result, nil
+}
+
+// interopAnnotations are the annotations of an image's manifest in the OCI
+// layout of a package, by which the tool finds it
+func interopAnnotations(format InteropFormat, ref string) map[string]string {
+	_, tag := parseReference(ref)
+	if format == FormatHauler {
+		return map[string]string{
+			"io.containerd.image.name":          ref,
+			"org.opencontainers.image.ref.name": tag,
+			haulerKindAnnotation:                haulerImageKind,
+		}
+	}
+	return map[string]string{
+		"org.opencontainers.image.base.name": ref,
+		"org.opencontainers.image.ref.name":  tag,
+	}
+}
+
+// ImportPackage converts the images of a Zarf package or Hauler store, an
+// archive or a directory, into one bundle in outDir. Charts, files and
+// other content that is not a container image are skipped and reported.
+// targetPlatform selects the bundled imgcd binary; empty means the platform
+// of the first image.
+func ImportPackage(packagePath, outDir, targetPlatform, version string) (*ConvertResult, error) {
+	dir := packagePath
+	if info, err := os.Stat(packagePath); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		tempDir, err := os.MkdirTemp("", "imgcd-convert-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer os.RemoveAll(tempDir)
+		fmt.Printf("Extracting %s...\n", filepath.Base(packagePath))
+		if err := extractPackage(packagePath, tempDir); err != nil {
+			return nil, err
+		}
+		dir = tempDir
+	}
+
+	result := &ConvertResult{}
+	layoutDir := dir
+	switch {
+	case fileExists(filepath.Join(dir, "version")) && fileExists(filepath.Join(dir, "manifest.json")):
+		return nil, fmt.Errorf("%s is a skopeo directory; save it with imgcd save dir:%s:NAME, which also supports --since", packagePath, packagePath)
+	case fileExists(filepath.Join(dir, "zarf.yaml")):
+		result.Format = FormatZarf
+		layoutDir = filepath.Join(dir, "images")
+		if !fileExists(filepath.Join(layoutDir, "index.json")) {
+			return nil, fmt.Errorf("the Zarf package has no images/ OCI layout; packages of Zarf before v0.25 are not supported")
+		}
+	case fileExists(filepath.Join(dir, "index.json")):
+		result.Format = FormatHauler
+	default:
+		return nil, fmt.Errorf("%s is neither a Zarf package nor a Hauler store (no zarf.yaml or index.json)", packagePath)
+	}
+
+	p, err := layout.FromPath(layoutDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OCI layout: %w", err)
+	}
+	images, skipped, err := packageImages(p)
+	if err != nil {
+		return nil, err
+	}
+	result.Skipped = skipped
+
+	var entries []bundle.ImageEntry
+	var digests []string
+	stored := make(map[string]bool)
+	for _, pi := range images {
+		entry, layers, err := describeImage(pi.img, pi.ref, pi.platform)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s (%s): %v", pi.ref, pi.platform, err))
+			continue
+		}
+		for _, layer := range layers {
+			digest, err := layer.Digest()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get layer digest: %w", err)
+			}
+			if !stored[digest.String()] {
+				stored[digest.String()] = true
+				digests = append(digests, digest.String())
+			}
+		}
+		entries = append(entries, entry)
+		result.Images = append(result.Images, fmt.Sprintf("%s (%s)", pi.ref, pi.platform))
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no container images found in %s", packagePath)
+	}
+
+	first := entries[0]
+	metadata := bundle.Metadata{
+		Version:   "2",
+		ImageRef:  first.ImageRef,
+		Platform:  first.Platform,
+		Manifest:  first.Manifest,
+		Config:    first.Config,
+		RawConfig: first.RawConfig,
+		Layers:    first.Layers,
+		TotalSize: calculateTotalSize(first.Layers),
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Note:      fmt.Sprintf("Converted from %s %s", formatName(result.Format), filepath.Base(packagePath)),
+		Images:    entries[1:],
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	tarGzPath, bundlePath := bundlePaths(metadata, outDir)
+	defer os.Remove(tarGzPath)
+	fmt.Printf("Packing %d blob(s) into bundle...\n", len(digests))
+	err = writeBlobBundle(tarGzPath, metadata, nil, digests, nil, func(digest string) (io.ReadCloser, int64, error) {
+		hash, err := v1.NewHash(digest)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := os.Stat(filepath.Join(layoutDir, "blobs", hash.Algorithm, hash.Hex))
+		if err != nil {
+			return nil, 0, fmt.Errorf("blob %s is missing from the package", digest)
+		}
+		blob, err := p.Blob(hash)
+		return blob, info.Size(), err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	if targetPlatform == "" {
+		targetPlatform = binaryPlatform(first.Platform)
+	}
+	gen := NewBundleGenerator(version)
+	gen.strict = version != "dev"
+	if err := gen.GenerateBundle(tarGzPath, bundlePath, targetPlatform, metadata.ImageRef); err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	if err := writeChecksums(bundlePath, ExportOptions{}, true); err != nil {
+		return nil, err
+	}
+	result.Path = bundlePath
+	return result, nil
+}
+
+// packageImage is an image found in the OCI layout of a package
+type packageImage struct {
+	ref      string
+	platform string
+	img      v1.Image
+}
+
+// packageImages lists the images of an OCI layout by their reference
+// annotation, each platform of a manifest list on its own. It also returns
+// what was skipped and why.
+func packageImages(p layout.Path) ([]packageImage, []string, error) {
+	index, err := p.ImageIndex()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read OCI layout index: %w", err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read OCI layout index: %w", err)
+	}
+
+	var images []packageImage
+	var skipped []string
+	for _, desc := range manifest.Manifests {
+		ref := ""
+		for _, key := range imageRefAnnotations {
+			if ref = desc.Annotations[key]; ref != "" {
+				break
+			}
+		}
+		if kind := desc.Annotations[haulerKindAnnotation]; kind != "" && kind != haulerImageKind {
+			skipped = append(skipped, fmt.Sprintf("%s: %s is not a container image", describeDescriptor(ref, desc), kind))
+			continue
+		}
+		if ref == "" {
+			skipped = append(skipped, fmt.Sprintf("%s: no image reference annotation", describeDescriptor(ref, desc)))
+			continue
+		}
+
+		if desc.MediaType.IsIndex() {
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read manifest list of %s: %w", ref, err)
+			}
+			childManifest, err := child.IndexManifest()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read manifest list of %s: %w", ref, err)
+			}
+			for _, m := range childManifest.Manifests {
+				// Attestations and variants the package left out
+				if m.Platform == nil || m.Platform.OS == "unknown" || !m.MediaType.IsImage() {
+					continue
+				}
+				img, err := child.Image(m.Digest)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to read %s (%s): %w", ref, m.Platform, err)
+				}
+				images = append(images, packageImage{ref: ref, platform: m.Platform.String(), img: img})
+			}
+			continue
+		}
+		if !desc.MediaType.IsImage() {
+			skipped = append(skipped, fmt.Sprintf("%s: unsupported media type %s", ref, desc.MediaType))
+			continue
+		}
+
+		img, err := index.Image(desc.Digest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", ref, err)
+		}
+		platform := ""
+		if desc.Platform != nil {
+			platform = desc.Platform.String()
+		} else if config, err := img.ConfigFile(); err == nil && config.Platform() != nil {
+			platform = config.Platform().String()
+		}
+		images = append(images, packageImage{ref: ref, platform: platform, img: img})
+	}
+	return images, skipped, nil
+}
+
+// describeDescriptor names a manifest of an OCI layout in messages
+func describeDescriptor(ref string, desc v1.Descriptor) string {
+	if ref != "" {
+		return ref
+	}
+	return desc.Digest.String()
+}
+
+// binaryPlatform reduces an image platform such as linux/arm64/v8 to the
+// os/arch of an imgcd binary
+func binaryPlatform(platform string) string {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 {
+		return detectCurrentPlatform()
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+// formatName is the name of a format in messages
+func formatName(format InteropFormat) string {
+	if format == FormatZarf {
+		return "Zarf package"
+	}
+	return "Hauler store"
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// extractPackage extracts the package metadata and OCI layout of a Zarf
+// package or Hauler store archive (tar, optionally zstd or gzip compressed)
+// to dir. Blobs are checked against their digest while they are written.
+func extractPackage(packagePath, dir string) error {
+	file, err := os.Open(packagePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic, _ := r.Peek(4)
+	var stream io.Reader = r
+	switch {
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		stream = zr
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		gzr, err := pgzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		stream = gzr
+	}
+
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filepath.Base(packagePath), err)
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if header.Typeflag != tar.TypeReg || !filepath.IsLocal(name) || !packageEntry(name) {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := extractPackageFile(tr, target, name); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+	}
+}
+
+// packageEntry reports whether an archive entry is package metadata or part
+// of its OCI layout; Zarf's components and SBOMs are not needed
+func packageEntry(name string) bool {
+	switch strings.TrimPrefix(name, "images/") {
+	case "zarf.yaml", "index.json", "oci-layout":
+		return true
+	}
+	return strings.HasPrefix(name, "blobs/") || strings.HasPrefix(name, "images/blobs/")
+}
+
+// extractPackageFile writes an archive entry to target; a blob named by its
+// sha256 must match it
+func extractPackageFile(r io.Reader, target, name string) error {
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hasher), r); err != nil {
+		return err
+	}
+	if dir, hexDigest := path.Split(name); strings.HasSuffix(dir, "blobs/sha256/") {
+		if actual := hex.EncodeToString(hasher.Sum(nil)); actual != hexDigest {
+			return fmt.Errorf("blob sha256:%s is corrupt: content hashes to sha256:%s", hexDigest, actual)
+		}
+	}
+	return out.Close()
+}
+
+// writeZstdArchive archives the files of dir, by their relative path, into
+// a zstd compressed tar as Zarf and Hauler write them
+func writeZstdArchive(dir, target string) error {
+	out, err := createStaged(target, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+
+	zw, err := zstd.NewWriter(out)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	err = filepath.WalkDir(dir, func(filePath string, d os.DirEntry, err error) error {
+		if err != nil || filePath == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Commit()
+}
@@ -0,0 +1,269 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+const (
+	journalFileName = "journal.json"
+	journalMaxAge   = 7 * 24 * time.Hour // Work directories of loads never retried are removed after this
+)
+
+// loadJournal records the completed phases of loading one bundle, next to the
+// files those phases produced, so a load that crashed or was interrupted
+// resumes where it stopped instead of extracting the blobs, exporting the
+// base image and decompressing the layers again
+type loadJournal struct {
+	dir   string
+	state journalState
+}
+
+// journalState is the on-disk form of a load journal
+type journalState struct {
+	Version   string    `json:"version"`
+	ImageRef  string    `json:"image_ref"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Blobs extracted to the work directory whose content matched their digest
+	Blobs map[string]bool `json:"blobs"`
+
+	// The base image extracted to base/, and the image ID it was exported from
+	BaseRef string `json:"base_ref,omitempty"`
+	BaseID  string `json:"base_id,omitempty"`
+
+	// The reconstructed image tar, ready to import, and its sha256
+	ImageTar    string `json:"image_tar,omitempty"`
+	ImageSHA256 string `json:"image_sha256,omitempty"`
+
+	// ImportStarted is set while the image is handed to the runtime
+	ImportStarted bool `json:"import_started,omitempty"`
+
+	// Checksum is the sha256 of the journal with this field empty; a journal
+	// that does not match it was torn or tampered with and is discarded
+	Checksum string `json:"checksum"`
+}
+
+// openLoadJournal opens the journal of the bundle whose metadata.json is
+// metaBytes, in ~/.imgcd/loads/<sha256 of the metadata>. It returns nil when
+// state is disabled. With fresh, an existing journal is discarded.
+func openLoadJournal(w io.Writer, metaBytes []byte, imageRef string, fresh bool) (*loadJournal, error) {
+	stateDir, err := state.Dir()
+	if err != nil {
+		return nil, nil
+	}
+	loadsDir := filepath.Join(stateDir, "loads")
+	pruneLoadJournals(loadsDir)
+
+	sum := sha256.Sum256(metaBytes)
+	j := &loadJournal{dir: filepath.Join(loadsDir, hex.EncodeToString(sum[:]))}
+
+	if fresh {
+		if err := os.RemoveAll(j.dir); err != nil {
+			return nil, fmt.Errorf("failed to discard load journal: %w", err)
+		}
+	} else if err := j.read(); err == nil {
+		j.report(w)
+		return j, nil
+	} else if !os.IsNotExist(err) {
+		ui.Fwarning(w, "discarding load journal: %v", err)
+		if err := os.RemoveAll(j.dir); err != nil {
+			return nil, fmt.Errorf("failed to discard load journal: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create load directory: %w", err)
+	}
+	j.state = journalState{Version: "1", ImageRef: imageRef, Blobs: make(map[string]bool)}
+	if err := j.save(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// read loads and checks the journal file
+func (j *loadJournal) read() error {
+	data, err := os.ReadFile(filepath.Join(j.dir, journalFileName))
+	if err != nil {
+		return err
+	}
+	var s journalState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", journalFileName, err)
+	}
+	expected := s.Checksum
+	if actual, err := s.checksum(); err != nil {
+		return err
+	} else if actual != expected {
+		return fmt.Errorf("%s is corrupt: checksum mismatch", journalFileName)
+	}
+	if s.Blobs == nil {
+		s.Blobs = make(map[string]bool)
+	}
+	j.state = s
+	return nil
+}
+
+// report tells what the interrupted load already finished
+func (j *loadJournal) report(w io.Writer) {
+	s := j.state
+	fmt.Fprintf(w, "Resuming interrupted load (%s)\n", j.dir)
+	switch {
+	case s.ImageTar != "":
+		fmt.Fprintf(w, "  Image already reconstructed; importing it\n")
+		if s.ImportStarted {
+			fmt.Fprintf(w, "  The previous import did not finish; importing again\n")
+		}
+	case s.BaseID != "":
+		fmt.Fprintf(w, "  %d blob(s) and base image %s already extracted\n", len(s.Blobs), s.BaseRef)
+	case len(s.Blobs) > 0:
+		fmt.Fprintf(w, "  %d blob(s) already extracted and verified\n", len(s.Blobs))
+	}
+}
+
+// checksum hashes the state with the Checksum field cleared
+func (s journalState) checksum() (string, error) {
+	s.Checksum = ""
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// save writes the journal atomically
+func (j *loadJournal) save() error {
+	j.state.UpdatedAt = time.Now()
+	sum, err := j.state.checksum()
+	if err != nil {
+		return err
+	}
+	j.state.Checksum = sum
+
+	data, err := json.MarshalIndent(j.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(j.dir, journalFileName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write load journal: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write load journal: %w", err)
+	}
+	return nil
+}
+
+// hasBlob reports whether the blob was extracted and verified earlier and is
+// still in place with the expected size
+func (j *loadJournal) hasBlob(digest string, size int64) bool {
+	if !j.state.Blobs[digest] {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(j.dir, bundle.Encoded(digest)))
+	return err == nil && info.Size() == size
+}
+
+// extractBlob extracts a blob into the work directory, checking it against
+// its digest and checksums while it is written, and records it
+func (j *loadJournal) extractBlob(r io.Reader, digest string, checksums map[string]string) error {
+	path := filepath.Join(j.dir, bundle.Encoded(digest))
+	if err := extractVerifiedBlob(r, path, digest, checksums); err != nil {
+		return err
+	}
+
+	j.state.Blobs[digest] = true
+	return j.save()
+}
+
+// baseDir is where the base image is extracted
+func (j *loadJournal) baseDir() string {
+	return filepath.Join(j.dir, "base")
+}
+
+// hasBase reports whether the base image was extracted from the image that
+// baseRef still names
+func (j *loadJournal) hasBase(baseRef, baseID string) bool {
+	if j.state.BaseRef != baseRef || j.state.BaseID == "" || j.state.BaseID != baseID {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(j.baseDir(), "manifest.json"))
+	return err == nil
+}
+
+// setBase records the extracted base image
+func (j *loadJournal) setBase(baseRef, baseID string) error {
+	j.state.BaseRef = baseRef
+	j.state.BaseID = baseID
+	return j.save()
+}
+
+// imageTar returns the reconstructed image tar if it is still intact
+func (j *loadJournal) imageTar(w io.Writer) string {
+	if j.state.ImageTar == "" {
+		return ""
+	}
+	path := filepath.Join(j.dir, j.state.ImageTar)
+	actual, err := checksum.File(path)
+	if err != nil || actual != j.state.ImageSHA256 {
+		ui.Fwarning(w, "reconstructed image.tar changed since it was built; rebuilding it")
+		j.state.ImageTar = ""
+		j.state.ImageSHA256 = ""
+		j.state.ImportStarted = false
+		return ""
+	}
+	return path
+}
+
+// setImageTar records the reconstructed image tar
+func (j *loadJournal) setImageTar(path string) error {
+	sum, err := checksum.File(path)
+	if err != nil {
+		return err
+	}
+	j.state.ImageTar = filepath.Base(path)
+	j.state.ImageSHA256 = sum
+	return j.save()
+}
+
+// startImport records that the image is being handed to the runtime
+func (j *loadJournal) startImport() error {
+	j.state.ImportStarted = true
+	return j.save()
+}
+
+// finish removes the work directory once the image is loaded
+func (j *loadJournal) finish() error {
+	return os.RemoveAll(j.dir)
+}
+
+// pruneLoadJournals removes work directories of loads that were not retried
+func pruneLoadJournals(loadsDir string) {
+	entries, err := os.ReadDir(loadsDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := os.Stat(filepath.Join(loadsDir, entry.Name(), journalFileName))
+		if err == nil && time.Since(info.ModTime()) < journalMaxAge {
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		os.RemoveAll(filepath.Join(loadsDir, entry.Name()))
+	}
+}
@@ -0,0 +1,252 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/runtime"
+)
+
+// LayerList is the layers of an image, as imgcd layers writes them: a small
+// file a disconnected target sends back to say exactly which image it has,
+// so save --since-layers can build the next increment on it
+type LayerList struct {
+	Image    string        `json:"image,omitempty"`
+	Platform string        `json:"platform,omitempty"`
+	ID       string        `json:"id,omitempty"` // Config digest, which docker shows as the image ID
+	Layers   []ListedLayer `json:"layers"`       // Bottom first
+}
+
+// ListedLayer is one layer of a LayerList
+type ListedLayer struct {
+	DiffID v1.Hash `json:"diff_id"`
+	Digest string  `json:"digest,omitempty"` // Compressed digest; unknown to runtimes
+	Size   int64   `json:"size,omitempty"`   // Compressed size; unknown to runtimes
+}
+
+// DiffIDs returns the DiffIDs of the listed layers
+func (l *LayerList) DiffIDs() []v1.Hash {
+	diffIDs := make([]v1.Hash, len(l.Layers))
+	for i, layer := range l.Layers {
+		diffIDs[i] = layer.DiffID
+	}
+	return diffIDs
+}
+
+// Write stores the list at path as indented JSON
+func (l *LayerList) Write(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode layer list: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write layer list: %w", err)
+	}
+	return nil
+}
+
+// ListLayers lists the layers of ref as the local runtime has it, which is
+// what a target can build on, without pulling; images the runtime lacks are
+// listed from the registry. platform selects the variant, by default the
+// runtime's.
+func (e *Exporter) ListLayers(ctx context.Context, ref, platform string) (*LayerList, error) {
+	if platform == "" {
+		var err error
+		if platform, err = runtime.PlatformOf(ctx, e.runtime); err != nil {
+			return nil, fmt.Errorf("failed to detect the platform of %s: %w", e.runtime.Name(), err)
+		}
+	}
+
+	lister, ok := e.runtime.(runtime.ImageLister)
+	if !ok {
+		return FetchLayerList(ctx, ref, platform)
+	}
+	diffIDs, localErr := lister.ImageDiffIDs(ctx, ref, platform)
+	if localErr != nil {
+		list, err := FetchLayerList(ctx, ref, platform)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from the %s runtime (%v) or the registry: %w", ref, e.runtime.Name(), localErr, err)
+		}
+		return list, nil
+	}
+	hashes, err := parseHashes(diffIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &LayerList{Image: ref, Platform: platform}
+	// The image is present, so this inspects it without pulling
+	if info, err := e.runtime.GetImage(ctx, ref); err == nil {
+		if info.Platform != "" {
+			list.Platform = info.Platform
+		}
+		if _, err := v1.NewHash(info.ID); err == nil {
+			list.ID = info.ID
+		}
+	}
+	for _, diffID := range hashes {
+		list.Layers = append(list.Layers, ListedLayer{DiffID: diffID})
+	}
+	return list, nil
+}
+
+// FetchLayerList lists the layers of ref from the registry, with their
+// compressed digests and sizes
+func FetchLayerList(ctx context.Context, ref, platform string) (*LayerList, error) {
+	p, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse platform: %w", err)
+	}
+	img, err := fetchImage(ctx, ref, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config: %w", err)
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("%s has %d layers but %d DiffIDs", ref, len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+
+	list := &LayerList{Image: ref, Platform: platform, ID: manifest.Config.Digest.String()}
+	if imagePlatform := configPlatform(config); imagePlatform != nil {
+		list.Platform = imagePlatform.String()
+	}
+	for i, layer := range manifest.Layers {
+		list.Layers = append(list.Layers, ListedLayer{
+			DiffID: config.RootFS.DiffIDs[i],
+			Digest: layer.Digest.String(),
+			Size:   layer.Size,
+		})
+	}
+	return list, nil
+}
+
+// ReadLayerList reads the layers of a base image the target already has:
+// the JSON imgcd layers writes, the output of docker inspect, or one DiffID
+// per line (blank lines and # comments skipped), which names no image
+func ReadLayerList(path string) (*LayerList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer list: %w", err)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var list LayerList
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, fmt.Errorf("failed to parse %s as a layer list: %w", path, err)
+		}
+		return &list, nil
+	}
+
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var inspect []struct {
+			ID           string `json:"Id"`
+			RepoTags     []string
+			Os           string
+			Architecture string
+			Variant      string
+			RootFS       struct {
+				Layers []string
+			}
+		}
+		if err := json.Unmarshal(trimmed, &inspect); err != nil {
+			return nil, fmt.Errorf("failed to parse %s as docker inspect output: %w", path, err)
+		}
+		if len(inspect) != 1 {
+			return nil, fmt.Errorf("%s describes %d images; inspect only the base image", path, len(inspect))
+		}
+		list := &LayerList{ID: inspect[0].ID}
+		if len(inspect[0].RepoTags) > 0 {
+			list.Image = inspect[0].RepoTags[0]
+		}
+		if inspect[0].Os != "" && inspect[0].Architecture != "" {
+			list.Platform = (&v1.Platform{OS: inspect[0].Os, Architecture: inspect[0].Architecture, Variant: inspect[0].Variant}).String()
+		}
+		for i, layer := range inspect[0].RootFS.Layers {
+			hash, err := v1.NewHash(layer)
+			if err != nil {
+				return nil, fmt.Errorf("%s: RootFS.Layers[%d] is not a digest: %q", path, i, layer)
+			}
+			list.Layers = append(list.Layers, ListedLayer{DiffID: hash})
+		}
+		return list, nil
+	}
+
+	list := &LayerList{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, err := v1.NewHash(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: not a layer DiffID: %q", path, line, text)
+		}
+		list.Layers = append(list.Layers, ListedLayer{DiffID: hash})
+	}
+	return list, nil
+}
+
+// readBaseLayers makes the base of an export of newRef from a layer list
+// (--since-layers), in the form of the image of a bundle: the config holds
+// its DiffIDs and platform, the manifest its ID. sinceRef, if given, names
+// the base, which lists of bare DiffIDs do not.
+func readBaseLayers(path, newRef, sinceRef string) (*bundle.Metadata, error) {
+	list, err := ReadLayerList(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --since-layers %s: %w", filepath.Base(path), err)
+	}
+	if len(list.Layers) == 0 {
+		return nil, fmt.Errorf("--since-layers %s lists no layers", filepath.Base(path))
+	}
+	ref := list.Image
+	if sinceRef != "" {
+		ref = normalizeSinceRef(newRef, sinceRef)
+	}
+	if ref == "" {
+		return nil, fmt.Errorf("--since-layers %s does not name the base image; pass it with --since", filepath.Base(path))
+	}
+
+	config := &v1.ConfigFile{RootFS: v1.RootFS{Type: "layers", DiffIDs: list.DiffIDs()}}
+	if list.Platform != "" {
+		platform, err := v1.ParsePlatform(list.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("--since-layers %s: invalid platform: %w", filepath.Base(path), err)
+		}
+		config.OS, config.Architecture, config.Variant = platform.OS, platform.Architecture, platform.Variant
+	}
+
+	// Without the image ID the layers identify the base, so that a bundle
+	// of an earlier run is not reused for a list that changed
+	id, err := v1.NewHash(list.ID)
+	if err != nil {
+		var joined strings.Builder
+		for _, diffID := range config.RootFS.DiffIDs {
+			joined.WriteString(diffID.String() + "\n")
+		}
+		id, _, _ = v1.SHA256(strings.NewReader(joined.String()))
+	}
+
+	return &bundle.Metadata{
+		ImageRef: ref,
+		Platform: list.Platform,
+		Config:   config,
+		Manifest: &v1.Manifest{SchemaVersion: 2, Config: v1.Descriptor{Digest: id}},
+	}, nil
+}
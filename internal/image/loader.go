@@ -2,70 +2,177 @@ package image
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/klauspost/pgzip"
 	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/ratelimit"
 	"github.com/so2liu/imgcd/internal/runtime"
+	"github.com/so2liu/imgcd/internal/ui"
 )
 
 // BundleLoader handles loading bundles and reconstructing Docker images
 type BundleLoader struct {
-	runtime runtime.Runtime
+	runtime  runtime.Runtime
+	out      io.Writer
+	postLoad *postLoad // On-load commands of the bundle being loaded
+	loaded   string    // Images of the bundle being loaded, comma-separated
+	summary  *BundleSummary
+	results  []ImageStatus // Outcomes of the images of the bundle being loaded
+
+	// Hooks of stream imports, which cannot look at the bundle before
+	// reading it: checkSummary runs once the metadata was read, and
+	// afterRead once the image data was, with the sha256 of its entries,
+	// before anything is imported
+	checkSummary func(*BundleSummary) error
+	afterRead    func(sums []bundle.Checksum) error
 }
 
 // v1Metadata represents the metadata format from local mode (v1.0)
 type v1Metadata struct {
-	Version     string `json:"version"`
-	NewRef      string `json:"new_ref"`
-	SinceRef    string `json:"since_ref"`
-	Incremental bool   `json:"incremental"`
-	LayerCount  int    `json:"layer_count"`
+	Version     string             `json:"version"`
+	NewRef      string             `json:"new_ref"`
+	SinceRef    string             `json:"since_ref"`
+	Incremental bool               `json:"incremental,omitempty"`
+	LayerCount  int                `json:"layer_count,omitempty"`
+	CreatedAt   string             `json:"created_at,omitempty"`
+	ExpiresAt   string             `json:"expires_at,omitempty"`
+	Note        string             `json:"note,omitempty"`
+	Annotations map[string]string  `json:"annotations,omitempty"`
+	Provenance  *bundle.Provenance `json:"provenance,omitempty"`
+	OnLoad      []string           `json:"on_load,omitempty"`
+	Binary      string             `json:"binary_platform,omitempty"`
+}
+
+// LoadOptions contains options for loading bundles
+type LoadOptions struct {
+	EnforceExpiry bool // Refuse to load expired bundles instead of warning
+	SquashExcess  bool // Squash layers beyond the runtime's depth limit into one
+
+	// ImportRateLimit caps the bytes per second streamed into the runtime; 0 means unlimited
+	ImportRateLimit int64
+
+	// CheckRunning looks for running containers of the image's repository
+	// before importing and refuses to retag a tag they run, unless Force
+	CheckRunning bool
+	Force        bool
+
+	// ArtifactLayout and ArtifactPush are where OCI artifacts go, which
+	// container runtimes cannot load: an OCI image layout directory and a
+	// registry repository
+	ArtifactLayout string
+	ArtifactPush   string
+
+	// ExtrasDir receives the files attached to the bundle; empty to skip them
+	ExtrasDir string
+
+	// RunPostLoad runs the bundle's on-load commands after a successful load,
+	// once ConfirmPostLoad (if set) approves them
+	RunPostLoad     bool
+	ConfirmPostLoad func(commands []string) (bool, error)
+
+	// NoResume discards the journal of an interrupted load of the same bundle
+	// and starts over
+	NoResume bool
+
+	// Platform ("os/arch[/variant]") selects the image variant of
+	// multi-platform bundles for every target; empty picks the platform each
+	// target reports
+	Platform string
+
+	// SHA256 is the expected sha256 of a bundle ImportStream reads; it is
+	// checked before anything is imported
+	SHA256 string
+
+	// SignatureKey is an Ed25519 public key (PEM) the embedded signature of
+	// the bundle is checked against before anything is imported; unsigned
+	// bundles only warn unless RequireSignature is set
+	SignatureKey     string
+	RequireSignature bool
+
+	// FailFast stops a multi-image bundle at its first image that fails;
+	// otherwise the other images are still loaded and the error names the
+	// failed ones
+	FailFast bool
+
+	// Report, if set, receives the outcome of each image of the bundle
+	Report *LoadReport
+
+	// checksums is the checksums.txt found next to bare image data, which
+	// bundle tars carry inside
+	checksums []byte
+
+	// Output receives progress messages; os.Stdout if nil
+	Output io.Writer
+}
+
+// output returns where progress messages go
+func (o LoadOptions) output() io.Writer {
+	if o.Output != nil {
+		return o.Output
+	}
+	return os.Stdout
 }
 
 // NewBundleLoader creates a new bundle loader
 func NewBundleLoader(rt runtime.Runtime) *BundleLoader {
 	return &BundleLoader{
 		runtime: rt,
+		out:     os.Stdout,
 	}
 }
 
-// LoadBundle loads a bundle and imports it into the container runtime
-// Supports both v1.0 (imgcd-meta.json + image.tar) and v2 (metadata.json + blobs) formats
-func (bl *BundleLoader) LoadBundle(ctx context.Context, bundlePath string) error {
-	fmt.Printf("Loading bundle: %s\n", bundlePath)
-
-	// Open bundle tar.gz
-	bundleFile, err := os.Open(bundlePath)
-	if err != nil {
-		return fmt.Errorf("failed to open bundle: %w", err)
+// loadBundle loads the compressed image data of a bundle from r, then runs
+// its on-load commands if requested
+func (bl *BundleLoader) loadBundle(ctx context.Context, r io.Reader, opts LoadOptions) error {
+	bl.postLoad = nil
+	bl.loaded = ""
+	bl.summary = nil
+	bl.results = nil
+	if err := bl.loadImage(ctx, r, opts); err != nil {
+		return err
 	}
-	defer bundleFile.Close()
+	return bl.runPostLoad(ctx, opts)
+}
 
-	gzr, err := gzip.NewReader(bundleFile)
+// loadImage loads the compressed image data of a bundle from r
+func (bl *BundleLoader) loadImage(ctx context.Context, r io.Reader, opts LoadOptions) error {
+	bl.out = opts.output()
+
+	br, err := bundle.NewReader(r)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return err
+	}
+	defer br.Close()
+	// Blobs are checked against their digest and checksums while they are
+	// extracted, all at once
+	br.SkipDigests()
+	if bl.afterRead != nil {
+		br.Checksum()
 	}
-	defer gzr.Close()
-
-	tr := tar.NewReader(gzr)
 
-	// Read metadata first
+	// imgcd writes the metadata first, but repacked bundles may store it
+	// anywhere; blobs met before it are checked against their recorded
+	// checksums once it was read
 	var metadata bundle.Metadata
 	var v1Meta v1Metadata
 	var blobsFound map[string]bool = make(map[string]bool)
+	var earlyBlobs []string
 	var tempDir string
 	var isV1Format bool
 	var imageTarPath string
+	var attachments, resumed int
+	var journal *loadJournal
 
 	// Create temp directory for blobs
 	tempDir, err = os.MkdirTemp("", "imgcd-load-*")
@@ -73,122 +180,562 @@ func (bl *BundleLoader) LoadBundle(ctx context.Context, bundlePath string) error
 		return fmt.Errorf("failed to create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
+	blobDir := tempDir
 
 	// Extract bundle contents
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
+	for entry, err := range br.All() {
 		if err != nil {
-			return fmt.Errorf("failed to read tar: %w", err)
+			return err
 		}
+		header := entry.Header
 
 		switch {
-		case header.Name == "imgcd-meta.json":
+		case entry.Kind == bundle.LegacyMetadataEntry:
 			// v1.0 format (local mode)
-			if err := json.NewDecoder(tr).Decode(&v1Meta); err != nil {
+			if err := json.NewDecoder(entry).Decode(&v1Meta); err != nil {
 				return fmt.Errorf("failed to decode v1 metadata: %w", err)
 			}
 			isV1Format = true
-			fmt.Printf("Bundle version: %s (legacy format)\n", v1Meta.Version)
-			fmt.Printf("Image: %s\n", v1Meta.NewRef)
+			bl.loaded = v1Meta.NewRef
+			fmt.Fprintf(bl.out, "Bundle version: %s (legacy format)\n", v1Meta.Version)
+			fmt.Fprintf(bl.out, "Image: %s\n", v1Meta.NewRef)
 			if v1Meta.SinceRef != "" {
-				fmt.Printf("Base: %s\n", v1Meta.SinceRef)
+				fmt.Fprintf(bl.out, "Base: %s\n", v1Meta.SinceRef)
+			}
+			printNotes(bl.out, v1Meta.Note, v1Meta.Annotations)
+			printProvenance(bl.out, v1Meta.Provenance)
+			if err := checkExpiry(bl.out, v1Meta.ExpiresAt, opts.EnforceExpiry); err != nil {
+				return err
+			}
+			bl.announcePostLoad(v1Meta.NewRef, v1Meta.OnLoad, opts)
+			bl.summary = newBundleSummary(v1Meta.NewRef, v1Meta.SinceRef, v1Meta.CreatedAt)
+			bl.summary.BinaryPlatform = v1Meta.Binary
+			if err := bl.runCheckSummary(); err != nil {
+				return err
 			}
 
-		case header.Name == "image.tar" && isV1Format:
-			// v1.0 format: extract the nested image.tar
-			imageTarPath = filepath.Join(tempDir, "image.tar")
-			if err := bl.extractFile(tr, imageTarPath); err != nil {
+		case header.Name == "image.tar":
+			// v1.0 format: extract the nested image.tar, which may come
+			// before imgcd-meta.json; apart from the image.tar v2 loads
+			// rebuild in the same directory
+			imageTarPath = filepath.Join(tempDir, "legacy-image.tar")
+			if err := bl.extractFile(entry, imageTarPath); err != nil {
 				return fmt.Errorf("failed to extract image.tar: %w", err)
 			}
 
-		case header.Name == "metadata.json":
+		case entry.Kind == bundle.MetadataEntry:
 			// v2 format (remote mode)
-			if err := json.NewDecoder(tr).Decode(&metadata); err != nil {
-				return fmt.Errorf("failed to decode metadata: %w", err)
-			}
+			metaBytes := br.RawMetadata()
+			metadata = *br.Metadata()
+			bl.loaded = strings.Join(metadata.ImageRefs(), ", ")
 
 			// Validate version
 			if metadata.Version != "2" {
 				return fmt.Errorf("unsupported bundle version: %s (expected 2)", metadata.Version)
 			}
 
-			fmt.Printf("Bundle version: %s\n", metadata.Version)
-			fmt.Printf("Image: %s\n", metadata.ImageRef)
-			fmt.Printf("Platform: %s\n", metadata.Platform)
+			fmt.Fprintf(bl.out, "Bundle version: %s\n", metadata.Version)
+			if metadata.Artifact != nil {
+				fmt.Fprintf(bl.out, "Artifact: %s\n", metadata.ImageRef)
+				fmt.Fprintf(bl.out, "Artifact type: %s\n", metadata.Artifact.Type())
+			} else {
+				for _, ref := range metadata.ImageRefs() {
+					fmt.Fprintf(bl.out, "Image: %s\n", ref)
+				}
+				fmt.Fprintf(bl.out, "Platform: %s\n", strings.Join(metadata.Platforms(), ", "))
+			}
 			if metadata.BaseRef != "" {
-				fmt.Printf("Base: %s\n", metadata.BaseRef)
+				fmt.Fprintf(bl.out, "Base: %s\n", metadata.BaseRef)
+			}
+			printNotes(bl.out, metadata.Note, metadata.Annotations)
+			printProvenance(bl.out, metadata.Provenance)
+			if err := checkExpiry(bl.out, metadata.ExpiresAt, opts.EnforceExpiry); err != nil {
+				return err
+			}
+			bl.announcePostLoad(metadata.ImageRef, metadata.OnLoad, opts)
+			bl.summary = metadataSummary(&metadata)
+			if err := bl.runCheckSummary(); err != nil {
+				return err
 			}
 
-		case strings.HasPrefix(header.Name, "blobs/sha256/"):
-			// Extract blob to temp directory
-			hash := filepath.Base(header.Name)
-			digest := "sha256:" + hash
+			// Image loads keep their work in the journal's directory so an
+			// interrupted load can resume
+			if metadata.Artifact == nil && bl.runtime != nil && len(blobsFound) == 0 {
+				journal, err = openLoadJournal(bl.out, metaBytes, metadata.ImageRef, opts.NoResume)
+				if err != nil {
+					return err
+				}
+				if journal != nil {
+					blobDir = journal.dir
+				}
+			}
 
-			blobPath := filepath.Join(tempDir, hash)
-			if err := bl.extractFile(tr, blobPath); err != nil {
-				return fmt.Errorf("failed to extract blob %s: %w", digest, err)
+		case entry.Kind == bundle.BlobEntry:
+			// Extract blob to the work directory
+			digest := entry.Digest
+			hash := bundle.Encoded(digest)
+			checksums := metadata.LayerChecksums(digest)
+
+			switch {
+			case journal != nil && journal.hasBlob(digest, header.Size):
+				// Extracted and verified before the load was interrupted
+				resumed++
+			case journal != nil:
+				if err := journal.extractBlob(entry, digest, checksums); err != nil {
+					return fmt.Errorf("failed to extract blob %s: %w", digest, err)
+				}
+			default:
+				if err := extractVerifiedBlob(entry, filepath.Join(blobDir, hash), digest, checksums); err != nil {
+					return fmt.Errorf("failed to extract blob %s: %w", digest, err)
+				}
 			}
 
 			blobsFound[digest] = true
+			if metadata.Version == "" {
+				earlyBlobs = append(earlyBlobs, digest)
+			}
+
+		case strings.HasPrefix(header.Name, extrasPrefix):
+			attachments++
+			if opts.ExtrasDir != "" {
+				if err := extractAttachment(entry, header, opts.ExtrasDir); err != nil {
+					return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+				}
+			}
+
+		case header.Name == artifactManifestName:
+			if err := bl.extractFile(entry, filepath.Join(tempDir, artifactManifestName)); err != nil {
+				return fmt.Errorf("failed to extract artifact manifest: %w", err)
+			}
 		}
 	}
 
+	if !isV1Format && metadata.Version == "" {
+		return fmt.Errorf("metadata not found in bundle (expected metadata.json or imgcd-meta.json)")
+	}
+	if bl.summary != nil && !isV1Format {
+		bl.summary.Blobs, bl.summary.Resumed = len(blobsFound), resumed
+	}
+	for _, digest := range earlyBlobs {
+		path := filepath.Join(blobDir, bundle.Encoded(digest))
+		if err := verifyBlobFile(path, digest, metadata.LayerChecksums(digest)); err != nil {
+			return err
+		}
+	}
+	if bl.afterRead != nil {
+		sums, err := br.Checksums()
+		if err != nil {
+			return err
+		}
+		if err := bl.afterRead(sums); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case attachments > 0 && opts.ExtrasDir != "":
+		fmt.Fprintf(bl.out, "Extracted %d attached file(s) to %s\n", attachments, opts.ExtrasDir)
+	case attachments > 0:
+		fmt.Fprintf(bl.out, "Bundle has %d attached file(s); use --extras-dir to extract them\n", attachments)
+	}
+
+	if bl.runtime == nil && metadata.Artifact == nil {
+		return fmt.Errorf("bundle contains a container image; a container runtime is required to load it")
+	}
+
 	// Handle v1.0 format (legacy local mode)
 	if isV1Format {
-		return bl.loadV1Bundle(ctx, imageTarPath, v1Meta)
+		return bl.loadV1Bundle(ctx, imageTarPath, v1Meta, opts)
 	}
 
 	// Validate we have all required blobs
-	fmt.Printf("\nValidating blobs...\n")
-	for _, layerInfo := range metadata.Layers {
-		if !blobsFound[layerInfo.Digest] {
-			return fmt.Errorf("missing blob: %s", layerInfo.Digest)
+	fmt.Fprintf(bl.out, "\nValidating blobs...\n")
+	images := metadata.PerImage()
+	for _, image := range images {
+		for _, layerInfo := range image.Layers {
+			if !blobsFound[layerInfo.Digest] {
+				return fmt.Errorf("missing blob: %s", layerInfo.Digest)
+			}
 		}
 	}
 
-	// For incremental imports, get base image info
-	var baseImageDir string
-	if metadata.BaseRef != "" {
-		fmt.Printf("\nExporting base image from local runtime: %s\n", metadata.BaseRef)
-		fmt.Printf("(This may take a while for large images...)\n")
-		var err error
-		baseImageDir, err = bl.extractBaseImage(ctx, metadata.BaseRef)
-		if err != nil {
-			return fmt.Errorf("incremental import requires base image %s: %w", metadata.BaseRef, err)
-		}
-		defer os.RemoveAll(baseImageDir)
-		fmt.Printf("Base image exported successfully\n")
+	if metadata.Artifact != nil {
+		return bl.loadArtifact(ctx, tempDir, &metadata, opts)
+	}
+	if opts.Platform != "" && matchVariant(images, opts.Platform) == nil {
+		return fmt.Errorf("bundle has no image for %s (it holds %s)", opts.Platform, strings.Join(metadata.Platforms(), ", "))
+	}
+	if len(images) > 1 {
+		return bl.loadImages(ctx, blobDir, images, journal, opts)
 	}
 
-	// Reconstruct Docker image.tar
-	fmt.Printf("Reconstructing Docker image.tar...\n")
-	imageTarPath = filepath.Join(tempDir, "image.tar")
-	if err := bl.rebuildImageTar(imageTarPath, tempDir, &metadata, baseImageDir); err != nil {
-		return fmt.Errorf("failed to rebuild image.tar: %w", err)
+	if len(metadata.Layers) == 0 && metadata.SharedLayerCount > 0 {
+		fmt.Fprintf(bl.out, "Config-only bundle: all %d layers come from base image %s\n", metadata.SharedLayerCount, metadata.BaseRef)
+	}
+
+	// A stray image.tar in a v2 bundle is not covered by the metadata's
+	// digests: the image is always rebuilt from the verified blobs
+	imageTarPath = ""
+	if journal != nil {
+		imageTarPath = journal.imageTar(bl.out)
+	}
+	if imageTarPath == "" {
+		imageTarPath, err = bl.reconstructImage(ctx, blobDir, &metadata, journal, opts.SquashExcess)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Load into runtime
-	fmt.Printf("\nLoading image into container runtime...\n")
+	fmt.Fprintf(bl.out, "\nLoading image into container runtime...\n")
 	imageTarFile, err := os.Open(imageTarPath)
 	if err != nil {
 		return fmt.Errorf("failed to open image.tar: %w", err)
 	}
 	defer imageTarFile.Close()
 
-	if err := bl.runtime.LoadImageFromReader(ctx, imageTarFile); err != nil {
+	if journal != nil {
+		if err := journal.startImport(); err != nil {
+			return err
+		}
+	}
+	if err := bl.runtime.LoadImageFromReader(ctx, ratelimit.New(opts.ImportRateLimit).Reader(ctx, imageTarFile)); err != nil {
 		return fmt.Errorf("failed to load image: %w", err)
 	}
+	if journal != nil {
+		if err := journal.finish(); err != nil {
+			ui.Fwarning(bl.out, "failed to remove load work directory: %v", err)
+		}
+	}
+
+	fmt.Fprintf(bl.out, "Successfully loaded image: %s\n", metadata.ImageRef)
+	bl.results = append(bl.results, ImageStatus{Image: metadata.ImageRef, Platform: metadata.Platform, Status: StatusLoaded})
+	return nil
+}
+
+// runCheckSummary runs the checkSummary hook, if any
+func (bl *BundleLoader) runCheckSummary() error {
+	if bl.checkSummary == nil {
+		return nil
+	}
+	return bl.checkSummary(bl.summary)
+}
+
+// loadImages rebuilds and imports the images of a multi-image bundle one
+// after the other. Of an image saved for several platforms, every target
+// gets the variant of its own platform. An image that fails does not stop
+// the others unless opts.FailFast. The journal only spares extracting the
+// blobs again, so it is kept for a retry while any image failed.
+func (bl *BundleLoader) loadImages(ctx context.Context, blobDir string, images []*bundle.Metadata, journal *loadJournal, opts LoadOptions) error {
+	loads, err := bl.planImageLoads(ctx, images, opts.Platform)
+	if err != nil {
+		return err
+	}
+	if opts.FailFast {
+		for _, load := range loads {
+			if load.err != nil {
+				return load.err
+			}
+		}
+	}
+
+	var failed []string
+	for i, load := range loads {
+		fmt.Fprintf(bl.out, "\n[%d/%d] %s\n", i+1, len(loads), load.label)
+		started := time.Now()
+		err := load.err
+		if err == nil {
+			err = bl.loadOne(ctx, blobDir, load, opts)
+		}
+
+		status := ImageStatus{Image: load.ref, Status: StatusLoaded, Seconds: time.Since(started).Seconds()}
+		if load.image != nil {
+			status.Platform = load.image.Platform
+		}
+		if load.target {
+			status.Target = load.runtime.Name()
+		}
+		if err != nil {
+			status.Status, status.Error = StatusFailed, err.Error()
+		}
+		bl.results = append(bl.results, status)
+
+		if err != nil {
+			// Once interrupted, the other images would fail the same way
+			if opts.FailFast || ctx.Err() != nil {
+				return err
+			}
+			ui.Ffailure(bl.out, "%v", err)
+			failed = append(failed, load.label)
+			continue
+		}
+		fmt.Fprintf(bl.out, "Successfully loaded image: %s\n", load.ref)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d images failed to load: %s", len(failed), len(loads), strings.Join(failed, ", "))
+	}
+
+	if journal != nil {
+		if err := journal.finish(); err != nil {
+			ui.Fwarning(bl.out, "failed to remove load work directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// loadOne rebuilds one image of a multi-image bundle and imports it
+func (bl *BundleLoader) loadOne(ctx context.Context, blobDir string, load imageLoad, opts LoadOptions) error {
+	image := load.image
+	imageTarPath, err := bl.reconstructImage(ctx, blobDir, image, nil, opts.SquashExcess)
+	if err != nil {
+		return fmt.Errorf("%s: %w", image.ImageRef, err)
+	}
+	defer os.Remove(imageTarPath)
+
+	fmt.Fprintf(bl.out, "Loading image into container runtime...\n")
+	imageTarFile, err := os.Open(imageTarPath)
+	if err != nil {
+		return fmt.Errorf("failed to open image.tar: %w", err)
+	}
+	defer imageTarFile.Close()
+	if err := load.runtime.LoadImageFromReader(ctx, ratelimit.New(opts.ImportRateLimit).Reader(ctx, imageTarFile)); err != nil {
+		return fmt.Errorf("failed to load image %s: %w", image.ImageRef, err)
+	}
+	return nil
+}
+
+// imageLoad is one image of a multi-image bundle and the runtime it goes to
+type imageLoad struct {
+	ref     string
+	image   *bundle.Metadata // Nil if the image was not saved for the target's platform
+	runtime runtime.Runtime
+	target  bool   // The bundle goes to several targets, which the report names
+	label   string // Progress label, naming platform and targets where they vary
+	err     error  // Why the image cannot be loaded
+}
+
+// planImageLoads decides which runtime gets which image. Bundles of a
+// single platform send every image to every target; multi-platform bundles
+// send each group of targets the variants of its platform, or every target
+// the variants of the given platform; images missing for a platform are
+// planned with the error that stops them.
+func (bl *BundleLoader) planImageLoads(ctx context.Context, images []*bundle.Metadata, platform string) ([]imageLoad, error) {
+	var refs []string
+	variants := make(map[string][]*bundle.Metadata)
+	multiPlatform := false
+	for _, image := range images {
+		if len(variants[image.ImageRef]) == 0 {
+			refs = append(refs, image.ImageRef)
+		} else {
+			multiPlatform = true
+		}
+		variants[image.ImageRef] = append(variants[image.ImageRef], image)
+	}
+
+	var loads []imageLoad
+	if !multiPlatform {
+		for _, image := range images {
+			loads = append(loads, imageLoad{ref: image.ImageRef, image: image, runtime: bl.runtime, label: image.ImageRef})
+		}
+		return loads, nil
+	}
+
+	forced := platform != ""
+	groups := map[string]runtime.Runtime{platform: bl.runtime}
+	if !forced {
+		var err error
+		groups, err = runtime.GroupByPlatform(ctx, bl.runtime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect target platform (choose one with --platform): %w", err)
+		}
+	}
+	platforms := make([]string, 0, len(groups))
+	for platform := range groups {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	for _, platform := range platforms {
+		target := groups[platform]
+		for _, ref := range refs {
+			load := imageLoad{ref: ref, image: matchVariant(variants[ref], platform), runtime: target, target: len(groups) > 1}
+			saved := platform
+			if load.image != nil {
+				saved = load.image.Platform
+			}
+			load.label = fmt.Sprintf("%s (%s)", ref, saved)
+			if load.target {
+				load.label += " for " + target.Name()
+			}
+			if load.image == nil {
+				var available []string
+				for _, variant := range variants[ref] {
+					available = append(available, variant.Platform)
+				}
+				whose := ""
+				if !forced {
+					whose = ", the platform of " + target.Name()
+				}
+				load.err = fmt.Errorf("%s was not saved for %s%s (bundle has %s)", ref, platform, whose, strings.Join(available, ", "))
+			}
+			loads = append(loads, load)
+		}
+	}
+	return loads, nil
+}
+
+// matchVariant picks the variant saved for a platform, preferring an exact
+// match; an os/arch/variant platform such as linux/arm/v7 matches linux/arm
+func matchVariant(variants []*bundle.Metadata, platform string) *bundle.Metadata {
+	for _, variant := range variants {
+		if variant.Platform == platform {
+			return variant
+		}
+	}
+	for _, variant := range variants {
+		if strings.HasPrefix(variant.Platform, platform+"/") {
+			return variant
+		}
+	}
+	return nil
+}
+
+// reconstructImage builds the docker image tar from the extracted blobs and,
+// for incremental bundles, the layers shared with the base. With a journal,
+// each finished step is recorded so an interrupted load does not repeat it.
+func (bl *BundleLoader) reconstructImage(ctx context.Context, blobDir string, metadata *bundle.Metadata, journal *loadJournal, squash bool) (string, error) {
+	var sharedLayers []string
+	if metadata.BaseRef != "" && metadata.SharedLayerCount > 0 {
+		if metadata.Config == nil || metadata.SharedLayerCount > len(metadata.Config.RootFS.DiffIDs) {
+			return "", fmt.Errorf("metadata declares %d shared layers but its config does not list them", metadata.SharedLayerCount)
+		}
+		files, cleanup, err := bl.sharedLayerFiles(ctx, metadata, journal)
+		if err != nil {
+			return "", fmt.Errorf("incremental import requires base image %s: %w", metadata.BaseRef, err)
+		}
+		defer cleanup()
+		sharedLayers = files
+	}
+
+	// Reconstruct Docker image.tar
+	fmt.Fprintf(bl.out, "Reconstructing Docker image.tar...\n")
+	imageTarPath := filepath.Join(blobDir, "image.tar")
+	if err := bl.rebuildImageTar(imageTarPath, blobDir, metadata, sharedLayers); err != nil {
+		return "", fmt.Errorf("failed to rebuild image.tar: %w", err)
+	}
+
+	imageTarPath, err := bl.checkLayerDepth(imageTarPath, squash)
+	if err != nil {
+		return "", err
+	}
+	if journal != nil {
+		if err := journal.setImageTar(imageTarPath); err != nil {
+			return "", err
+		}
+	}
+	return imageTarPath, nil
+}
+
+// journaledBaseImage extracts the base image into the journal's directory,
+// reusing an earlier extraction while the base tag still names the same image
+func (bl *BundleLoader) journaledBaseImage(ctx context.Context, baseRef string, journal *loadJournal) (string, error) {
+	baseID := ""
+	if info, err := bl.runtime.GetImage(ctx, baseRef); err == nil {
+		baseID = info.ID
+	}
+	if baseID != "" && journal.hasBase(baseRef, baseID) {
+		fmt.Fprintf(bl.out, "\nUsing base image %s exported before the interruption\n", baseRef)
+		return journal.baseDir(), nil
+	}
+
+	fmt.Fprintf(bl.out, "\nExporting base image from local runtime: %s\n", baseRef)
+	fmt.Fprintf(bl.out, "(This may take a while for large images...)\n")
+	dir := journal.baseDir()
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := bl.exportBaseImage(ctx, baseRef, dir); err != nil {
+		return "", err
+	}
+	if err := journal.setBase(baseRef, baseID); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(bl.out, "Base image exported successfully\n")
+	return dir, nil
+}
 
-	fmt.Printf("Successfully loaded image: %s\n", metadata.ImageRef)
+// printNotes prints the producer's note and annotations, if any
+func printNotes(w io.Writer, note string, annotations map[string]string) {
+	if note != "" {
+		fmt.Fprintf(w, "Note: %s\n", note)
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "Annotation: %s=%s\n", key, annotations[key])
+	}
+}
+
+// printProvenance prints who and which pipeline produced the bundle
+func printProvenance(w io.Writer, prov *bundle.Provenance) {
+	if prov == nil {
+		return
+	}
+
+	producer := prov.User
+	if prov.Host != "" {
+		producer += "@" + prov.Host
+	}
+	if producer != "" {
+		fmt.Fprintf(w, "Produced by: %s\n", producer)
+	}
+	if prov.GitCommit != "" {
+		source := prov.GitCommit
+		if prov.GitRepository != "" {
+			source = prov.GitRepository + "@" + prov.GitCommit
+		}
+		fmt.Fprintf(w, "Source: %s\n", source)
+	}
+	if prov.Pipeline != "" {
+		fmt.Fprintf(w, "Pipeline: %s\n", prov.Pipeline)
+	}
+}
+
+// checkExpiry warns about (or, when enforced, rejects) bundles past their expiry
+func checkExpiry(w io.Writer, expiresAt string, enforce bool) error {
+	if expiresAt == "" {
+		return nil
+	}
+
+	expiry, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return fmt.Errorf("invalid expires_at in metadata: %w", err)
+	}
+
+	fmt.Fprintf(w, "Expires: %s\n", expiry.Format(time.RFC3339))
+	if time.Now().Before(expiry) {
+		return nil
+	}
+
+	if enforce {
+		return fmt.Errorf("bundle expired on %s (refusing to load with --enforce-expiry)", expiry.Format(time.RFC3339))
+	}
+
+	ui.Fwarning(w, "bundle expired on %s, it may contain stale artifacts", expiry.Format(time.RFC3339))
 	return nil
 }
 
 // rebuildImageTar reconstructs a Docker-format image.tar from blobs
-// If baseImageDir is provided (incremental), merges base image layers with new layers
-func (bl *BundleLoader) rebuildImageTar(outputPath, blobDir string, metadata *bundle.Metadata, baseImageDir string) error {
+// For incremental bundles, sharedLayers are the layer tars taken from local
+// images, which come before the bundle's new layers
+func (bl *BundleLoader) rebuildImageTar(outputPath, blobDir string, metadata *bundle.Metadata, sharedLayers []string) error {
 	outFile, err := os.Create(outputPath)
 	if err != nil {
 		return err
@@ -209,37 +756,27 @@ func (bl *BundleLoader) rebuildImageTar(outputPath, blobDir string, metadata *bu
 	// Use metadata's full config (already contains all layers)
 	mergedConfig := metadata.Config
 	var writtenLayerPaths []string
-	var totalLayers int
+	totalLayers := len(sharedLayers) + len(metadata.Layers)
 
-	if baseImageDir != "" && metadata.SharedLayerCount > 0 {
-		// Incremental: copy shared layers from base, then add new layers
-		_, baseLayers, err := bl.parseBaseImage(baseImageDir)
-		if err != nil {
-			return fmt.Errorf("failed to parse base image: %w", err)
-		}
+	// Layers repeated in the chain are stored once and referenced repeatedly
+	written := make(map[string]bool)
 
-		// Validate we have enough base layers
-		if metadata.SharedLayerCount > len(baseLayers) {
-			return fmt.Errorf("base image has %d layers but need %d shared layers", len(baseLayers), metadata.SharedLayerCount)
+	// Copy the shared layers first, named by DiffID as the new layers are
+	for i, sourcePath := range sharedLayers {
+		layerPath := layerTarPath(mergedConfig.RootFS.DiffIDs[i].String())
+		writtenLayerPaths = append(writtenLayerPaths, layerPath)
+		if written[layerPath] {
+			continue
 		}
-
-		// Copy first N layers from base image (shared layers)
-		totalLayers = metadata.SharedLayerCount + len(metadata.Layers)
-		for i := 0; i < metadata.SharedLayerCount; i++ {
-			layerPath := baseLayers[i]
-			fmt.Printf("Processing base layer %d/%d...\r", i+1, totalLayers)
-			if err := bl.copyLayerToTar(tw, filepath.Join(baseImageDir, layerPath), layerPath); err != nil {
-				return fmt.Errorf("failed to copy base layer: %w", err)
-			}
-			writtenLayerPaths = append(writtenLayerPaths, layerPath)
+		fmt.Fprintf(bl.out, "Processing base layer %d/%d...\r", i+1, totalLayers)
+		if err := bl.copyLayerToTar(tw, sourcePath, layerPath); err != nil {
+			return fmt.Errorf("failed to copy base layer: %w", err)
 		}
-	} else {
-		// Full export: all layers from bundle
-		totalLayers = len(metadata.Layers)
+		written[layerPath] = true
 	}
 
-	// Write merged config
-	configBytes, err := json.Marshal(mergedConfig)
+	// Write merged config, as the source stored it so the image ID is kept
+	configBytes, err := metadata.ConfigBytes()
 	if err != nil {
 		return err
 	}
@@ -250,11 +787,7 @@ func (bl *BundleLoader) rebuildImageTar(outputPath, blobDir string, metadata *bu
 	}
 	configName := configHash + ".json"
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: configName,
-		Mode: 0644,
-		Size: int64(len(configBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader(configName, 0644, int64(len(configBytes)))); err != nil {
 		return err
 	}
 	if _, err := tw.Write(configBytes); err != nil {
@@ -264,54 +797,21 @@ func (bl *BundleLoader) rebuildImageTar(outputPath, blobDir string, metadata *bu
 	// Process new layers from bundle
 	baseLayerCount := len(writtenLayerPaths)
 	for i, layerInfo := range metadata.Layers {
-		fmt.Printf("Processing layer %d/%d...\r", baseLayerCount+i+1, totalLayers)
-
-		// Get blob path
-		hash := strings.TrimPrefix(layerInfo.Digest, "sha256:")
-		blobPath := filepath.Join(blobDir, hash)
-
-		// Decompress and verify
-		uncompressedLayer, calculatedDiffID, err := bl.decompressAndVerify(blobPath, layerInfo.DiffID)
-		if err != nil {
-			return fmt.Errorf("failed to decompress/verify layer %d: %w", i, err)
-		}
-		defer os.Remove(uncompressedLayer)
-
-		if calculatedDiffID != layerInfo.DiffID {
-			return fmt.Errorf("DiffID mismatch for layer %d: expected %s, got %s",
-				i, layerInfo.DiffID, calculatedDiffID)
-		}
-
 		// Write layer to image.tar
-		layerDir := strings.TrimPrefix(layerInfo.DiffID, "sha256:")[:12]
-		layerPath := layerDir + "/layer.tar"
+		layerPath := layerTarPath(layerInfo.DiffID)
 		writtenLayerPaths = append(writtenLayerPaths, layerPath)
-
-		layerFile, err := os.Open(uncompressedLayer)
-		if err != nil {
-			return err
-		}
-		defer layerFile.Close()
-
-		layerInfo, err := layerFile.Stat()
-		if err != nil {
-			return err
-		}
-
-		if err := tw.WriteHeader(&tar.Header{
-			Name: layerPath,
-			Mode: 0644,
-			Size: layerInfo.Size(),
-		}); err != nil {
-			return err
+		if written[layerPath] {
+			continue
 		}
 
-		if _, err := io.Copy(tw, layerFile); err != nil {
-			return err
+		fmt.Fprintf(bl.out, "Processing layer %d/%d...\r", baseLayerCount+i+1, totalLayers)
+		if err := bl.writeBundleLayer(tw, blobDir, layerInfo, layerPath); err != nil {
+			return fmt.Errorf("failed to decompress/verify layer %d: %w", i, err)
 		}
+		written[layerPath] = true
 	}
 
-	fmt.Printf("\nAll layers processed\n")
+	fmt.Fprintf(bl.out, "\nAll layers processed\n")
 
 	// Write manifest.json
 	manifest := []dockerManifest{
@@ -327,11 +827,7 @@ func (bl *BundleLoader) rebuildImageTar(outputPath, blobDir string, metadata *bu
 		return err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "manifest.json",
-		Mode: 0644,
-		Size: int64(len(manifestBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader("manifest.json", 0644, int64(len(manifestBytes)))); err != nil {
 		return err
 	}
 	if _, err := tw.Write(manifestBytes); err != nil {
@@ -354,11 +850,7 @@ func (bl *BundleLoader) rebuildImageTar(outputPath, blobDir string, metadata *bu
 		return err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "repositories",
-		Mode: 0644,
-		Size: int64(len(repoBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader("repositories", 0644, int64(len(repoBytes)))); err != nil {
 		return err
 	}
 	if _, err := tw.Write(repoBytes); err != nil {
@@ -368,6 +860,32 @@ func (bl *BundleLoader) rebuildImageTar(outputPath, blobDir string, metadata *bu
 	return nil
 }
 
+// layerTarPath names a layer in the reconstructed image tar by its DiffID
+func layerTarPath(diffID string) string {
+	return strings.TrimPrefix(diffID, "sha256:")[:12] + "/layer.tar"
+}
+
+// writeBundleLayer decompresses a blob, verifies its DiffID and writes it to
+// the image tar. Each temp file is removed before the next layer is processed
+// so long layer chains do not pile up uncompressed copies.
+func (bl *BundleLoader) writeBundleLayer(tw *tar.Writer, blobDir string, layerInfo bundle.LayerInfo, layerPath string) error {
+	// Get blob path
+	blobPath := filepath.Join(blobDir, bundle.Encoded(layerInfo.Digest))
+
+	// Decompress and verify
+	uncompressedLayer, calculatedDiffID, err := bl.decompressAndVerify(blobPath, layerInfo.DiffID)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(uncompressedLayer)
+
+	if calculatedDiffID != layerInfo.DiffID {
+		return fmt.Errorf("DiffID mismatch: expected %s, got %s", layerInfo.DiffID, calculatedDiffID)
+	}
+
+	return bl.copyLayerToTar(tw, uncompressedLayer, layerPath)
+}
+
 // decompressAndVerify decompresses a blob and verifies its DiffID
 // Returns the path to the uncompressed layer tar and the calculated DiffID
 func (bl *BundleLoader) decompressAndVerify(blobPath, expectedDiffID string) (string, string, error) {
@@ -379,7 +897,7 @@ func (bl *BundleLoader) decompressAndVerify(blobPath, expectedDiffID string) (st
 	defer blobFile.Close()
 
 	// Create gzip reader
-	gzr, err := gzip.NewReader(blobFile)
+	gzr, err := pgzip.NewReader(blobFile)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to create gzip reader: %w", err)
 	}
@@ -393,22 +911,23 @@ func (bl *BundleLoader) decompressAndVerify(blobPath, expectedDiffID string) (st
 	defer tempFile.Close()
 
 	// Decompress while calculating SHA256
-	hasher := sha256.New()
+	hasher := checksum.NewHasher()
+	defer hasher.Close()
 	tee := io.TeeReader(gzr, hasher)
 
-	if _, err := io.Copy(tempFile, tee); err != nil {
+	if _, err := copySparse(tempFile, tee); err != nil {
 		os.Remove(tempFile.Name())
 		return "", "", fmt.Errorf("failed to decompress: %w", err)
 	}
 
 	// Calculate DiffID
-	calculatedDiffID := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	calculatedDiffID := hasher.Digest()
 
 	return tempFile.Name(), calculatedDiffID, nil
 }
 
 // extractFile extracts a file from tar to the specified path
-func (bl *BundleLoader) extractFile(tr *tar.Reader, outputPath string) error {
+func (bl *BundleLoader) extractFile(r io.Reader, outputPath string) error {
 	// Create parent directory
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
 		return err
@@ -421,11 +940,38 @@ func (bl *BundleLoader) extractFile(tr *tar.Reader, outputPath string) error {
 	}
 	defer outFile.Close()
 
-	// Copy content
-	if _, err := io.Copy(outFile, tr); err != nil {
+	// Copy content, keeping zero runs of sparse files as holes
+	if _, err := copySparse(outFile, r); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// extractVerifiedBlob extracts a blob from r, checking it against its
+// digest and recorded checksums while it is written; a corrupt blob is
+// removed again
+func extractVerifiedBlob(r io.Reader, outputPath, digest string, checksums map[string]string) error {
+	expected := blobDigests(digest, checksums)
+	hashes, err := newBlobDigestWriter(expected)
+	if err != nil {
+		return err
+	}
+	defer hashes.Close()
+
+	out, err := os.Create(outputPath)
+	if err != nil {
 		return err
 	}
+	defer out.Close()
 
+	if _, err := copySparse(out, io.TeeReader(r, hashes)); err != nil {
+		return err
+	}
+	if err := mismatch(digest, expected, hashes.Digests()); err != nil {
+		os.Remove(outputPath)
+		return err
+	}
 	return nil
 }
 
@@ -437,11 +983,19 @@ func (bl *BundleLoader) extractBaseImage(ctx context.Context, baseRef string) (s
 		return "", err
 	}
 
+	if err := bl.exportBaseImage(ctx, baseRef, tempDir); err != nil {
+		os.RemoveAll(tempDir)
+		return "", err
+	}
+	return tempDir, nil
+}
+
+// exportBaseImage exports the base image from runtime and extracts it to dir
+func (bl *BundleLoader) exportBaseImage(ctx context.Context, baseRef, dir string) error {
 	// Create temp file for base image tar
 	baseTarFile, err := os.CreateTemp("", "base-*.tar")
 	if err != nil {
-		os.RemoveAll(tempDir)
-		return "", err
+		return err
 	}
 	baseTarPath := baseTarFile.Name()
 	baseTarFile.Close()
@@ -449,15 +1003,13 @@ func (bl *BundleLoader) extractBaseImage(ctx context.Context, baseRef string) (s
 
 	// Save base image to tar
 	if err := bl.runtime.SaveImage(ctx, baseRef, baseTarPath); err != nil {
-		os.RemoveAll(tempDir)
-		return "", fmt.Errorf("failed to save base image: %w", err)
+		return fmt.Errorf("failed to save base image: %w", err)
 	}
 
 	// Extract base image tar
 	baseTar, err := os.Open(baseTarPath)
 	if err != nil {
-		os.RemoveAll(tempDir)
-		return "", err
+		return err
 	}
 	defer baseTar.Close()
 
@@ -468,25 +1020,22 @@ func (bl *BundleLoader) extractBaseImage(ctx context.Context, baseRef string) (s
 			break
 		}
 		if err != nil {
-			os.RemoveAll(tempDir)
-			return "", err
+			return err
 		}
 
-		targetPath := filepath.Join(tempDir, header.Name)
+		targetPath := filepath.Join(dir, header.Name)
 		if header.Typeflag == tar.TypeDir {
 			if err := os.MkdirAll(targetPath, 0755); err != nil {
-				os.RemoveAll(tempDir)
-				return "", err
+				return err
 			}
 		} else {
 			if err := bl.extractFile(tr, targetPath); err != nil {
-				os.RemoveAll(tempDir)
-				return "", err
+				return err
 			}
 		}
 	}
 
-	return tempDir, nil
+	return nil
 }
 
 // parseBaseImage parses the extracted base image directory and returns config and layer paths
@@ -524,6 +1073,26 @@ func (bl *BundleLoader) parseBaseImage(baseImageDir string) (*v1.ConfigFile, []s
 	return &config, manifest.Layers, nil
 }
 
+// checkSharedLayers verifies that the base image provides exactly the layers
+// the bundle expects to take from it. Bundles without new layers are rebuilt
+// from the base alone, so a moved base tag must not go unnoticed.
+func checkSharedLayers(baseConfig, config *v1.ConfigFile, shared int) error {
+	baseDiffIDs := baseConfig.RootFS.DiffIDs
+	diffIDs := config.RootFS.DiffIDs
+	if shared > len(baseDiffIDs) || shared > len(diffIDs) {
+		return fmt.Errorf("base image has %d layers but need %d shared layers", len(baseDiffIDs), shared)
+	}
+
+	for i := 0; i < shared; i++ {
+		if baseDiffIDs[i] != diffIDs[i] {
+			return fmt.Errorf("base image layer %d is %s but the bundle expects %s (does the base tag point to a different image?)",
+				i, baseDiffIDs[i], diffIDs[i])
+		}
+	}
+
+	return nil
+}
+
 // copyLayerToTar copies a layer file from source to the tar writer
 func (bl *BundleLoader) copyLayerToTar(tw *tar.Writer, sourcePath, tarPath string) error {
 	layerFile, err := os.Open(sourcePath)
@@ -537,11 +1106,7 @@ func (bl *BundleLoader) copyLayerToTar(tw *tar.Writer, sourcePath, tarPath strin
 		return err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: tarPath,
-		Mode: 0644,
-		Size: info.Size(),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader(tarPath, 0644, info.Size())); err != nil {
 		return err
 	}
 
@@ -555,14 +1120,19 @@ func (bl *BundleLoader) copyLayerToTar(tw *tar.Writer, sourcePath, tarPath strin
 // loadV1Bundle handles the legacy v1.0 format (local mode)
 // For non-incremental: image.tar can be loaded directly
 // For incremental: need to merge base image layers with new layers
-func (bl *BundleLoader) loadV1Bundle(ctx context.Context, imageTarPath string, meta v1Metadata) error {
+func (bl *BundleLoader) loadV1Bundle(ctx context.Context, imageTarPath string, meta v1Metadata, opts LoadOptions) error {
 	if imageTarPath == "" {
 		return fmt.Errorf("image.tar not found in v1 bundle")
 	}
 
 	// Non-incremental: load directly
 	if !meta.Incremental || meta.SinceRef == "" {
-		fmt.Printf("\nLoading v1.0 format bundle (Docker-format image.tar)...\n")
+		fmt.Fprintf(bl.out, "\nLoading v1.0 format bundle (Docker-format image.tar)...\n")
+
+		imageTarPath, err := bl.checkLayerDepth(imageTarPath, opts.SquashExcess)
+		if err != nil {
+			return err
+		}
 
 		imageTarFile, err := os.Open(imageTarPath)
 		if err != nil {
@@ -570,27 +1140,30 @@ func (bl *BundleLoader) loadV1Bundle(ctx context.Context, imageTarPath string, m
 		}
 		defer imageTarFile.Close()
 
-		if err := bl.runtime.LoadImageFromReader(ctx, imageTarFile); err != nil {
+		if err := bl.runtime.LoadImageFromReader(ctx, ratelimit.New(opts.ImportRateLimit).Reader(ctx, imageTarFile)); err != nil {
 			return fmt.Errorf("failed to load image: %w", err)
 		}
 
-		fmt.Printf("Successfully loaded image: %s\n", meta.NewRef)
+		fmt.Fprintf(bl.out, "Successfully loaded image: %s\n", meta.NewRef)
 		return nil
 	}
 
 	// Incremental: need to merge base image layers with new layers
-	fmt.Printf("\nLoading v1.0 incremental format bundle...\n")
-	fmt.Printf("This requires merging layers from base image: %s\n", meta.SinceRef)
+	fmt.Fprintf(bl.out, "\nLoading v1.0 incremental format bundle...\n")
+	fmt.Fprintf(bl.out, "This requires merging layers from base image: %s\n", meta.SinceRef)
+	if meta.LayerCount == 0 {
+		fmt.Fprintf(bl.out, "Config-only bundle: all layers come from the base image\n")
+	}
 
 	// Export base image to temp directory
-	fmt.Printf("Exporting base image from local runtime...\n")
-	fmt.Printf("(This may take a while for large images...)\n")
+	fmt.Fprintf(bl.out, "Exporting base image from local runtime...\n")
+	fmt.Fprintf(bl.out, "(This may take a while for large images...)\n")
 	baseImageDir, err := bl.extractBaseImage(ctx, meta.SinceRef)
 	if err != nil {
 		return fmt.Errorf("incremental import requires base image %s: %w", meta.SinceRef, err)
 	}
 	defer os.RemoveAll(baseImageDir)
-	fmt.Printf("Base image exported successfully\n")
+	fmt.Fprintf(bl.out, "Base image exported successfully\n")
 
 	// Extract new image.tar to temp directory
 	newImageDir, err := os.MkdirTemp("", "imgcd-new-*")
@@ -604,25 +1177,30 @@ func (bl *BundleLoader) loadV1Bundle(ctx context.Context, imageTarPath string, m
 	}
 
 	// Merge and rebuild
-	fmt.Printf("Merging base and new layers...\n")
+	fmt.Fprintf(bl.out, "Merging base and new layers...\n")
 	mergedTarPath := filepath.Join(newImageDir, "merged.tar")
 	if err := bl.mergeV1Layers(mergedTarPath, baseImageDir, newImageDir, meta.NewRef); err != nil {
 		return fmt.Errorf("failed to merge layers: %w", err)
 	}
 
+	mergedTarPath, err = bl.checkLayerDepth(mergedTarPath, opts.SquashExcess)
+	if err != nil {
+		return err
+	}
+
 	// Load merged image
-	fmt.Printf("Loading merged image into container runtime...\n")
+	fmt.Fprintf(bl.out, "Loading merged image into container runtime...\n")
 	mergedFile, err := os.Open(mergedTarPath)
 	if err != nil {
 		return fmt.Errorf("failed to open merged image: %w", err)
 	}
 	defer mergedFile.Close()
 
-	if err := bl.runtime.LoadImageFromReader(ctx, mergedFile); err != nil {
+	if err := bl.runtime.LoadImageFromReader(ctx, ratelimit.New(opts.ImportRateLimit).Reader(ctx, mergedFile)); err != nil {
 		return fmt.Errorf("failed to load image: %w", err)
 	}
 
-	fmt.Printf("Successfully loaded image: %s\n", meta.NewRef)
+	fmt.Fprintf(bl.out, "Successfully loaded image: %s\n", meta.NewRef)
 	return nil
 }
 
@@ -665,7 +1243,7 @@ func (bl *BundleLoader) extractTarToDir(tarPath, destDir string) error {
 // mergeV1Layers merges base image layers with new image layers for v1.0 incremental format
 func (bl *BundleLoader) mergeV1Layers(outputPath, baseDir, newDir, imageRef string) error {
 	// Parse base image manifest and config
-	_, baseLayers, err := bl.parseBaseImage(baseDir)
+	baseConfig, baseLayers, err := bl.parseBaseImage(baseDir)
 	if err != nil {
 		return fmt.Errorf("failed to parse base image: %w", err)
 	}
@@ -683,7 +1261,14 @@ func (bl *BundleLoader) mergeV1Layers(outputPath, baseDir, newDir, imageRef stri
 		sharedLayerCount = 0
 	}
 
-	fmt.Printf("Merging %d base layers + %d new layers = %d total layers\n",
+	if sharedLayerCount > len(baseLayers) {
+		return fmt.Errorf("base image has %d layers but need %d shared layers", len(baseLayers), sharedLayerCount)
+	}
+	if err := checkSharedLayers(baseConfig, newConfig, sharedLayerCount); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(bl.out, "Merging %d base layers + %d new layers = %d total layers\n",
 		sharedLayerCount, len(newLayers), len(newConfig.RootFS.DiffIDs))
 
 	// Create output tar
@@ -697,24 +1282,33 @@ func (bl *BundleLoader) mergeV1Layers(outputPath, baseDir, newDir, imageRef stri
 	defer tw.Close()
 
 	var allLayerPaths []string
+	written := make(map[string]bool)
 
 	// Copy shared layers from base image
-	for i := 0; i < sharedLayerCount && i < len(baseLayers); i++ {
+	for i := 0; i < sharedLayerCount; i++ {
 		layerPath := baseLayers[i]
+		allLayerPaths = append(allLayerPaths, layerPath)
+		if written[layerPath] {
+			continue
+		}
 		sourcePath := filepath.Join(baseDir, layerPath)
 		if err := bl.copyLayerToTar(tw, sourcePath, layerPath); err != nil {
 			return fmt.Errorf("failed to copy base layer %d: %w", i, err)
 		}
-		allLayerPaths = append(allLayerPaths, layerPath)
+		written[layerPath] = true
 	}
 
-	// Copy new layers
+	// Copy new layers (repeated layers are stored once)
 	for _, layerPath := range newLayers {
+		allLayerPaths = append(allLayerPaths, layerPath)
+		if written[layerPath] {
+			continue
+		}
 		sourcePath := filepath.Join(newDir, layerPath)
 		if err := bl.copyLayerToTar(tw, sourcePath, layerPath); err != nil {
 			return fmt.Errorf("failed to copy new layer: %w", err)
 		}
-		allLayerPaths = append(allLayerPaths, layerPath)
+		written[layerPath] = true
 	}
 
 	// Write config (use new image's config as it has all DiffIDs)
@@ -729,11 +1323,7 @@ func (bl *BundleLoader) mergeV1Layers(outputPath, baseDir, newDir, imageRef stri
 	}
 	configName := configHash + ".json"
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: configName,
-		Mode: 0644,
-		Size: int64(len(configBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader(configName, 0644, int64(len(configBytes)))); err != nil {
 		return err
 	}
 	if _, err := tw.Write(configBytes); err != nil {
@@ -753,11 +1343,7 @@ func (bl *BundleLoader) mergeV1Layers(outputPath, baseDir, newDir, imageRef stri
 		return err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "manifest.json",
-		Mode: 0644,
-		Size: int64(len(manifestBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader("manifest.json", 0644, int64(len(manifestBytes)))); err != nil {
 		return err
 	}
 	if _, err := tw.Write(manifestBytes); err != nil {
@@ -779,11 +1365,7 @@ func (bl *BundleLoader) mergeV1Layers(outputPath, baseDir, newDir, imageRef stri
 		return err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "repositories",
-		Mode: 0644,
-		Size: int64(len(repoBytes)),
-	}); err != nil {
+	if err := tw.WriteHeader(fileHeader("repositories", 0644, int64(len(repoBytes)))); err != nil {
 		return err
 	}
 	if _, err := tw.Write(repoBytes); err != nil {
@@ -0,0 +1,214 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/runtime"
+)
+
+// sharedLayerFiles returns the uncompressed tars of the layers an
+// incremental bundle takes from its base, in order, and a cleanup for what
+// was exported to find them. The declared base image provides them when it
+// is present and still has them; otherwise any local images that together
+// hold them do, so a bundle loads wherever its shared layers exist under
+// whatever tags.
+func (bl *BundleLoader) sharedLayerFiles(ctx context.Context, metadata *bundle.Metadata, journal *loadJournal) ([]string, func(), error) {
+	noCleanup := func() {}
+	shared := metadata.Config.RootFS.DiffIDs[:metadata.SharedLayerCount]
+
+	lister, canList := bl.runtime.(runtime.ImageLister)
+	baseErr := fmt.Errorf("base image %s not found locally", metadata.BaseRef)
+	if canList {
+		// Asking the runtime is cheap, exporting a base without the layers is not
+		diffIDs, err := lister.ImageDiffIDs(ctx, metadata.BaseRef, metadata.Platform)
+		if err == nil {
+			baseErr = hasLeadingLayers(diffIDs, shared)
+		}
+	}
+	if !canList || baseErr == nil {
+		files, cleanup, err := bl.baseLayerFiles(ctx, metadata, journal)
+		if err == nil || !canList {
+			return files, cleanup, err
+		}
+		baseErr = err
+	}
+
+	fmt.Fprintf(bl.out, "\nBase image %s cannot provide the shared layers (%v)\n", metadata.BaseRef, baseErr)
+	fmt.Fprintf(bl.out, "Looking for them among all local images...\n")
+	sources, err := chooseLayerSources(ctx, lister, shared, metadata.Platform)
+	if err != nil {
+		return nil, noCleanup, fmt.Errorf("%v, and %w", baseErr, err)
+	}
+
+	var dirs []string
+	cleanup := func() {
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}
+	found := make(map[v1.Hash]string)
+	for _, source := range sources {
+		fmt.Fprintf(bl.out, "Exporting %s (%d shared layers) from local runtime\n", source.ref, source.layers)
+		dir, err := bl.extractBaseImage(ctx, source.ref)
+		if err != nil {
+			cleanup()
+			return nil, noCleanup, fmt.Errorf("failed to export %s: %w", source.ref, err)
+		}
+		dirs = append(dirs, dir)
+
+		config, layers, err := bl.parseBaseImage(dir)
+		if err != nil {
+			cleanup()
+			return nil, noCleanup, fmt.Errorf("failed to parse %s: %w", source.ref, err)
+		}
+		for i, diffID := range config.RootFS.DiffIDs {
+			if i < len(layers) {
+				found[diffID] = filepath.Join(dir, layers[i])
+			}
+		}
+	}
+
+	files := make([]string, len(shared))
+	for i, diffID := range shared {
+		file, ok := found[diffID]
+		if !ok {
+			// The image changed between listing and exporting it
+			cleanup()
+			return nil, noCleanup, fmt.Errorf("shared layer %d (%s) is missing from the exported local images", i, diffID)
+		}
+		files[i] = file
+	}
+	return files, cleanup, nil
+}
+
+// baseLayerFiles exports the declared base image, through the journal if
+// there is one, and returns its shared layers
+func (bl *BundleLoader) baseLayerFiles(ctx context.Context, metadata *bundle.Metadata, journal *loadJournal) ([]string, func(), error) {
+	noCleanup := func() {}
+	var baseImageDir string
+	var err error
+	cleanup := noCleanup
+	if journal != nil {
+		baseImageDir, err = bl.journaledBaseImage(ctx, metadata.BaseRef, journal)
+	} else {
+		fmt.Fprintf(bl.out, "\nExporting base image from local runtime: %s\n", metadata.BaseRef)
+		fmt.Fprintf(bl.out, "(This may take a while for large images...)\n")
+		baseImageDir, err = bl.extractBaseImage(ctx, metadata.BaseRef)
+		if err == nil {
+			cleanup = func() { os.RemoveAll(baseImageDir) }
+			fmt.Fprintf(bl.out, "Base image exported successfully\n")
+		}
+	}
+	if err != nil {
+		return nil, noCleanup, err
+	}
+
+	baseConfig, baseLayers, err := bl.parseBaseImage(baseImageDir)
+	if err != nil {
+		cleanup()
+		return nil, noCleanup, fmt.Errorf("failed to parse base image: %w", err)
+	}
+	if metadata.SharedLayerCount > len(baseLayers) {
+		cleanup()
+		return nil, noCleanup, fmt.Errorf("base image has %d layers but need %d shared layers", len(baseLayers), metadata.SharedLayerCount)
+	}
+	if err := checkSharedLayers(baseConfig, metadata.Config, metadata.SharedLayerCount); err != nil {
+		cleanup()
+		return nil, noCleanup, err
+	}
+
+	files := make([]string, metadata.SharedLayerCount)
+	for i := range files {
+		files[i] = filepath.Join(baseImageDir, baseLayers[i])
+	}
+	return files, cleanup, nil
+}
+
+// hasLeadingLayers checks the DiffIDs a runtime reports for the base image
+// against the shared layers
+func hasLeadingLayers(diffIDs []string, shared []v1.Hash) error {
+	if len(diffIDs) < len(shared) {
+		return fmt.Errorf("it has %d layers but %d are shared", len(diffIDs), len(shared))
+	}
+	for i, diffID := range shared {
+		if diffIDs[i] != diffID.String() {
+			return fmt.Errorf("its layer %d is %s but the bundle expects %s", i, diffIDs[i], diffID)
+		}
+	}
+	return nil
+}
+
+// layerSource is a local image to export shared layers from
+type layerSource struct {
+	ref    string
+	layers int // Shared layers taken from it
+}
+
+// chooseLayerSources picks local images that together hold the shared
+// layers, each next the one holding most of those still missing, so as few
+// images as possible are exported
+func chooseLayerSources(ctx context.Context, lister runtime.ImageLister, shared []v1.Hash, platform string) ([]layerSource, error) {
+	refs, err := lister.ListImages(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local images: %w", err)
+	}
+
+	holds := make(map[string]map[v1.Hash]bool)
+	for _, ref := range refs {
+		diffIDs, err := lister.ImageDiffIDs(ctx, ref, platform)
+		if err != nil {
+			continue
+		}
+		layers := make(map[v1.Hash]bool)
+		for _, diffID := range diffIDs {
+			if hash, err := v1.NewHash(diffID); err == nil {
+				layers[hash] = true
+			}
+		}
+		holds[ref] = layers
+	}
+
+	missing := make(map[v1.Hash]bool)
+	for _, diffID := range shared {
+		missing[diffID] = true
+	}
+	var sources []layerSource
+	for len(missing) > 0 {
+		best, bestCount := "", 0
+		for _, ref := range refs {
+			count := 0
+			for diffID := range missing {
+				if holds[ref][diffID] {
+					count++
+				}
+			}
+			if count > bestCount {
+				best, bestCount = ref, count
+			}
+		}
+		if best == "" {
+			break
+		}
+		for diffID := range holds[best] {
+			delete(missing, diffID)
+		}
+		sources = append(sources, layerSource{ref: best, layers: bestCount})
+	}
+
+	if len(missing) > 0 {
+		var absent []string
+		for i, diffID := range shared {
+			if missing[diffID] {
+				absent = append(absent, fmt.Sprintf("%d (%s)", i, diffID))
+			}
+		}
+		return nil, fmt.Errorf("no local image has shared layer %s", strings.Join(absent, ", "))
+	}
+	return sources, nil
+}
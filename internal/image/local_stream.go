@@ -0,0 +1,131 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/runtime"
+)
+
+// spooledImage is a docker save archive unpacked entry by entry from a stream.
+// Entries whose content digest appears in the skip set are discarded as soon
+// as they have been hashed, so shared layers never accumulate on disk.
+type spooledImage struct {
+	dir     string
+	files   map[string]string // tar entry name -> path on disk
+	digests map[string]string // tar entry name -> sha256 digest of content
+	skipped map[string]int64  // tar entry name -> size of discarded entry
+}
+
+// spoolImage streams the runtime's image archive for ref into dir.
+// Unlike SaveImage + tarball.ImageFromPath, the full image tar is never
+// written to disk, which halves the space needed for local incremental exports.
+func spoolImage(ctx context.Context, rt runtime.Runtime, ref, dir string, skip map[string]bool) (*spooledImage, error) {
+	pr, pw := io.Pipe()
+	saveErr := make(chan error, 1)
+
+	go func() {
+		err := rt.SaveImageToWriter(ctx, ref, pw)
+		pw.CloseWithError(err)
+		saveErr <- err
+	}()
+
+	spool, err := readSpool(pr, dir, skip)
+	if err == nil {
+		// Drain trailing padding so the runtime process can exit cleanly
+		_, err = io.Copy(io.Discard, pr)
+	}
+	if err != nil {
+		pr.CloseWithError(err)
+		<-saveErr
+		return nil, err
+	}
+
+	if err := <-saveErr; err != nil {
+		return nil, err
+	}
+
+	return spool, nil
+}
+
+// readSpool unpacks a docker save tar stream into dir, hashing each entry
+func readSpool(r io.Reader, dir string, skip map[string]bool) (*spooledImage, error) {
+	spool := &spooledImage{
+		dir:     dir,
+		files:   make(map[string]string),
+		digests: make(map[string]string),
+		skipped: make(map[string]int64),
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image stream: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Clean the entry name so it can never escape the spool directory
+		entryName := path.Clean("/" + header.Name)[1:]
+		targetPath := filepath.Join(dir, filepath.FromSlash(entryName))
+
+		digest, err := spoolEntry(tr, targetPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to spool %s: %w", header.Name, err)
+		}
+
+		if skip[digest] {
+			os.Remove(targetPath)
+			spool.skipped[entryName] = header.Size
+			continue
+		}
+
+		spool.files[entryName] = targetPath
+		spool.digests[entryName] = digest
+	}
+
+	return spool, nil
+}
+
+// spoolEntry writes the current tar entry to targetPath and returns its digest
+func spoolEntry(r io.Reader, targetPath string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return "", err
+	}
+
+	outFile, err := os.Create(targetPath)
+	if err != nil {
+		return "", err
+	}
+	defer outFile.Close()
+
+	hasher := checksum.NewHasher()
+	defer hasher.Close()
+	if _, err := copySparse(outFile, io.TeeReader(r, hasher)); err != nil {
+		os.Remove(targetPath)
+		return "", err
+	}
+
+	return hasher.Digest(), nil
+}
+
+// readFile returns the content of a spooled entry
+func (s *spooledImage) readFile(name string) ([]byte, error) {
+	filePath, ok := s.files[path.Clean(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in image archive", name)
+	}
+	return os.ReadFile(filePath)
+}
@@ -0,0 +1,255 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/so2liu/imgcd/internal/bundle"
+	remotedownload "github.com/so2liu/imgcd/internal/remote"
+)
+
+// errMultiImageLocal reports that a multi-image bundle needs the registry
+var errMultiImageLocal = errors.New("bundles of several images or platforms are saved from the registry only; local mode is not supported")
+
+// ExportImages exports several images, or several platforms of them, from
+// the registry into one bundle. Blobs shared between the images are
+// downloaded and stored once. The first image is described by the top-level
+// metadata fields, the others by metadata.Images; incremental exports and
+// OCI artifacts are not supported.
+func (re *RemoteExporter) ExportImages(ctx context.Context, refs []string, outDir string, opts ExportOptions) (string, error) {
+	fmt.Printf("Using remote mode: downloading compressed blobs\n")
+	defer re.lease("save " + strings.Join(refs, " "))()
+	platforms := append([]string{opts.TargetPlatform}, opts.MorePlatforms...)
+	multiPlatform := opts.AllPlatforms || len(platforms) > 1
+	if opts.AllPlatforms {
+		fmt.Printf("Target platforms: all platforms of each image\n")
+	} else {
+		fmt.Printf("Target platform: %s\n", strings.Join(platforms, ", "))
+	}
+
+	var entries []bundle.ImageEntry
+	var subjects []imageSubject
+	var results []remotedownload.DownloadResult
+	stored := make(map[string]bool)
+	layerCount := 0
+	for _, source := range refs {
+		ref := imageName(source)
+		refPlatforms := platforms
+		if opts.AllPlatforms {
+			var err error
+			refPlatforms, err = indexPlatforms(ctx, source)
+			if err != nil {
+				return "", fmt.Errorf("failed to list platforms of %s: %w", ref, err)
+			}
+			fmt.Printf("%s has %d platform(s): %s\n", ref, len(refPlatforms), strings.Join(refPlatforms, ", "))
+		}
+
+		for _, platformName := range refPlatforms {
+			platform, err := v1.ParsePlatform(platformName)
+			if err != nil {
+				return "", fmt.Errorf("failed to parse platform: %w", err)
+			}
+			label := ref
+			if multiPlatform {
+				label = fmt.Sprintf("%s (%s)", ref, platformName)
+			}
+
+			fmt.Printf("Fetching image metadata for %s...\n", label)
+			img, err := fetchImage(ctx, source, platform)
+			if err != nil {
+				return "", fmt.Errorf("failed to fetch image %s: %w", label, err)
+			}
+			entry, layers, err := describeImage(img, ref, platformName)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", label, err)
+			}
+			// Single-platform images are served whatever the platform asked
+			// for; one must not end up in the bundle under another's name
+			if multiPlatform {
+				got := entry.Config.Platform()
+				if got == nil || got.OS != platform.OS || got.Architecture != platform.Architecture {
+					return "", fmt.Errorf("%s has no %s variant (the registry serves %s)", ref, platformName, describePlatform(got))
+				}
+			}
+			configName, err := img.ConfigName()
+			if err != nil {
+				return "", fmt.Errorf("failed to get image ID of %s: %w", label, err)
+			}
+			entries = append(entries, entry)
+			subjects = append(subjects, imageSubject{Ref: ref, ID: configName.String()})
+			layerCount += len(layers)
+
+			// Blobs of earlier images are not downloaded again
+			var missing []v1.Layer
+			for _, layer := range layers {
+				digest, err := layer.Digest()
+				if err != nil {
+					return "", fmt.Errorf("failed to get layer digest: %w", err)
+				}
+				if !stored[digest.String()] {
+					stored[digest.String()] = true
+					missing = append(missing, layer)
+				}
+			}
+			if len(missing) == 0 {
+				fmt.Printf("All %d layer(s) of %s are shared with the images before it\n", len(layers), label)
+				re.recordManifest(ref, platformName, img)
+				continue
+			}
+
+			fmt.Printf("Downloading %d layer(s) of %s...\n", len(missing), label)
+			downloaded, err := re.blobDownloader.DownloadBlobsWithProgress(ctx, missing, ref, 4, os.Stderr)
+			if err != nil {
+				return "", fmt.Errorf("failed to download blobs of %s: %w", label, err)
+			}
+			results = append(results, downloaded...)
+			re.recordManifest(ref, platformName, img)
+		}
+	}
+
+	fmt.Printf("%d image(s), %d layer(s), %d stored after deduplication\n", len(entries), layerCount, len(results))
+	countDownloads(results, opts.downloads)
+
+	createdAt := time.Now()
+	first := entries[0]
+	metadata := bundle.Metadata{
+		Version:        "2",
+		ImageRef:       first.ImageRef,
+		Platform:       first.Platform,
+		BinaryPlatform: opts.recordedBinaryPlatform(),
+		Manifest:       first.Manifest,
+		Config:         first.Config,
+		RawConfig:      first.RawConfig,
+		Layers:         first.Layers,
+		TotalSize:      calculateTotalSize(first.Layers),
+		CreatedAt:      createdAt.Format(time.RFC3339),
+		ExpiresAt:      formatExpiry(opts.ExpiresAt),
+		Note:           opts.Note,
+		Annotations:    opts.Annotations,
+		OnLoad:         opts.OnLoad,
+		Provenance:     opts.Provenance,
+		Images:         entries[1:],
+	}
+
+	extras, err := extraEntries(opts, subjects[0].Ref, "", subjects[0].ID, createdAt, subjects[1:]...)
+	if err != nil {
+		return "", err
+	}
+
+	return re.writeBundle(outDir, metadata, extras, results, opts)
+}
+
+// describeImage reads the manifest, config and layers of an image for a
+// multi-image bundle
+func describeImage(img v1.Image, ref, platform string) (bundle.ImageEntry, []v1.Layer, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return bundle.ImageEntry{}, nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	artifact, err := artifactInfo(img, manifest)
+	if err != nil {
+		return bundle.ImageEntry{}, nil, err
+	}
+	if artifact != nil {
+		return bundle.ImageEntry{}, nil, fmt.Errorf("OCI artifacts (%s) cannot share a bundle with other images", artifact.Type())
+	}
+
+	config, err := img.ConfigFile()
+	if err != nil {
+		return bundle.ImageEntry{}, nil, fmt.Errorf("failed to get config file: %w", err)
+	}
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return bundle.ImageEntry{}, nil, fmt.Errorf("failed to get config file: %w", err)
+	}
+	if config == nil || len(config.RootFS.DiffIDs) == 0 {
+		return bundle.ImageEntry{}, nil, fmt.Errorf("config file has no layers (RootFS.DiffIDs is empty)")
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return bundle.ImageEntry{}, nil, fmt.Errorf("failed to get layers: %w", err)
+	}
+	infos, err := describeLayers(layers, manifest)
+	if err != nil {
+		return bundle.ImageEntry{}, nil, err
+	}
+
+	return bundle.ImageEntry{
+		ImageRef:  ref,
+		Platform:  platform,
+		Manifest:  manifest,
+		Config:    config,
+		RawConfig: rawConfig,
+		Layers:    infos,
+	}, layers, nil
+}
+
+// indexPlatforms lists the platforms of an image's manifest list, skipping
+// attestation manifests; an image without a list has its own platform only
+func indexPlatforms(ctx context.Context, imageRef string) ([]string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference: %w", err)
+	}
+	desc, err := remote.Get(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+
+	if !desc.MediaType.IsIndex() {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, err
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config file: %w", err)
+		}
+		if config.OS == "" || config.Architecture == "" {
+			return nil, fmt.Errorf("image config names no platform")
+		}
+		return []string{config.Platform().String()}, nil
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest list: %w", err)
+	}
+	var platforms []string
+	seen := make(map[string]bool)
+	for _, child := range manifest.Manifests {
+		// Build attestations are listed as unknown/unknown
+		if child.Platform == nil || child.Platform.OS == "unknown" {
+			continue
+		}
+		if platform := child.Platform.String(); !seen[platform] {
+			seen[platform] = true
+			platforms = append(platforms, platform)
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("manifest list names no platforms")
+	}
+	return platforms, nil
+}
+
+// describePlatform names a config's platform for messages
+func describePlatform(platform *v1.Platform) string {
+	if platform == nil || platform.OS == "" {
+		return "an image of unknown platform"
+	}
+	return platform.String()
+}
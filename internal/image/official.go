@@ -0,0 +1,138 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/so2liu/imgcd/internal/bundle"
+	remotedownload "github.com/so2liu/imgcd/internal/remote"
+)
+
+// Labels of the OCI image spec naming the image an image was built from
+const (
+	baseNameLabel   = "org.opencontainers.image.base.name"
+	baseDigestLabel = "org.opencontainers.image.base.digest"
+)
+
+// OfficialOptions configures CheckOfficialBase
+type OfficialOptions struct {
+	// Base is the official image to compare with; by default the one the
+	// image's labels name, else the base of an incremental bundle
+	Base string
+
+	// Fetch are the registry options, e.g. those of a metadata snapshot for
+	// checks without network access
+	Fetch []remote.Option
+}
+
+// OfficialCheck is the outcome of comparing the bottom layers of an image
+// of a bundle with those of the official image it was built from
+type OfficialCheck struct {
+	Image    string `json:"image"`
+	Platform string `json:"platform,omitempty"`
+	Base     string `json:"base,omitempty"`    // Official image compared with; empty if none is known
+	Official bool   `json:"docker_official"`   // Base is a Docker Official Image (docker.io/library)
+	Layers   int    `json:"layers"`            // Layers of Base, all found in the image when Problem is empty
+	Problem  string `json:"problem,omitempty"` // Why the check failed; empty when the layers match
+}
+
+// OK reports whether the image is built on the official layers
+func (c OfficialCheck) OK() bool {
+	return c.Problem == ""
+}
+
+// CheckOfficialBase compares every image of a bundle with the official
+// image it was built from: the image's bottom layers must be exactly the
+// layers that image publishes, by DiffID. A mismatch means the base was
+// modified, is not the image it claims to be, or is a different build of
+// it. The official layers are fetched from the registry, or from a metadata
+// snapshot for a check on the disconnected side.
+func CheckOfficialBase(ctx context.Context, bundlePath string, opts OfficialOptions) ([]OfficialCheck, error) {
+	meta, err := readBundleMetadata(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle metadata: %w", err)
+	}
+	if meta.Artifact != nil {
+		return nil, fmt.Errorf("%s is an artifact bundle; only container images have a base image", meta.ImageRef)
+	}
+
+	fetcher := remotedownload.NewFetcher(opts.Fetch...)
+	var checks []OfficialCheck
+	for _, m := range meta.PerImage() {
+		checks = append(checks, checkOfficialImage(ctx, fetcher, m, opts.Base))
+	}
+	return checks, nil
+}
+
+// checkOfficialImage compares one image of a bundle with its official base
+func checkOfficialImage(ctx context.Context, fetcher *remotedownload.Fetcher, m *bundle.Metadata, base string) OfficialCheck {
+	check := OfficialCheck{Image: m.ImageRef, Platform: m.Platform}
+	if check.Platform == "" {
+		if platform := configPlatform(m.Config); platform != nil {
+			check.Platform = platform.String()
+		}
+	}
+	if m.Config == nil {
+		check.Problem = "the bundle records no image config"
+		return check
+	}
+
+	check.Base = officialBaseOf(m, base)
+	if check.Base == "" {
+		check.Problem = fmt.Sprintf("the image does not name its base (no %s label); pass the official image with --official-base", baseNameLabel)
+		return check
+	}
+	check.Official = isDockerOfficialImage(check.Base)
+
+	official, err := fetcher.FetchImageMetadata(ctx, check.Base, check.Platform)
+	if err != nil {
+		check.Problem = fmt.Sprintf("cannot fetch the layers of %s: %v", check.Base, err)
+		return check
+	}
+	check.Layers = len(official.Layers)
+
+	diffIDs := m.Config.RootFS.DiffIDs
+	for i, layer := range official.Layers {
+		if i >= len(diffIDs) {
+			check.Problem = fmt.Sprintf("the image has %d layer(s), fewer than the %d of %s", len(diffIDs), len(official.Layers), check.Base)
+			return check
+		}
+		if diffIDs[i] != layer.DiffID {
+			check.Problem = fmt.Sprintf("layer %d is %s where %s has %s: the base was modified, is not %s, or is another build of it",
+				i+1, diffIDs[i].String()[:19], check.Base, layer.DiffID.String()[:19], check.Base)
+			return check
+		}
+	}
+	return check
+}
+
+// officialBaseOf returns the image to compare an image of a bundle with:
+// base if given, else the base its labels name, pinned to the recorded
+// digest, else the base of an incremental bundle
+func officialBaseOf(m *bundle.Metadata, base string) string {
+	if base != "" {
+		return base
+	}
+	labels := m.Config.Config.Labels
+	if labelled := labels[baseNameLabel]; labelled != "" {
+		if digest := labels[baseDigestLabel]; digest != "" && !strings.Contains(labelled, "@") {
+			return labelled + "@" + digest
+		}
+		return labelled
+	}
+	return m.BaseRef
+}
+
+// isDockerOfficialImage reports whether ref is one of Docker Hub's official
+// images, e.g. alpine or docker.io/library/debian
+func isDockerOfficialImage(ref string) bool {
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return false
+	}
+	repo := parsed.Context()
+	return repo.RegistryStr() == name.DefaultRegistry && strings.HasPrefix(repo.RepositoryStr(), "library/")
+}
@@ -0,0 +1,237 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/cache"
+	"github.com/so2liu/imgcd/internal/runtime"
+)
+
+// ExportPlan is what an export would do, worked out from manifests,
+// configs and the blob cache without downloading blobs or writing files
+type ExportPlan struct {
+	Image    string `json:"image"`
+	Base     string `json:"base,omitempty"`
+	Platform string `json:"platform"`
+	Mode     string `json:"mode"`       // registry, or the runtime a local-mode export reads from
+	Path     string `json:"bundle"`     // Bundle the export would write
+	UpToDate bool   `json:"up_to_date"` // An identical bundle of an earlier run would be kept
+
+	Layers       []PlannedLayer `json:"layers"` // Every layer of the image, bottom first
+	SharedLayers int            `json:"base_layers"`
+
+	BinarySize    int64 `json:"binary_size"`    // Of the bundled imgcd; 0 if it would be downloaded first
+	MetadataSize  int64 `json:"metadata_size"`  // Manifest and config recorded in the metadata
+	EstimatedSize int64 `json:"estimated_size"` // Of the bundle; 0 if unknown (local mode)
+}
+
+// PlannedLayer is one layer of an ExportPlan
+type PlannedLayer struct {
+	Digest   string `json:"digest"`    // Compressed digest; the DiffID in local mode
+	Size     int64  `json:"size"`      // Compressed size; 0 if unknown
+	FromBase bool   `json:"from_base"` // Taken from the base on load, not stored
+	Cached   bool   `json:"cached"`    // Stored from the blob cache
+}
+
+// Stored counts the distinct blobs the bundle would hold and their size
+func (p *ExportPlan) Stored() (int, int64) {
+	return p.count(func(layer PlannedLayer) bool { return !layer.FromBase })
+}
+
+// Downloads counts the distinct blobs that would be downloaded and their size
+func (p *ExportPlan) Downloads() (int, int64) {
+	return p.count(func(layer PlannedLayer) bool { return !layer.FromBase && !layer.Cached })
+}
+
+func (p *ExportPlan) count(match func(PlannedLayer) bool) (int, int64) {
+	seen := make(map[string]bool)
+	var size int64
+	for _, layer := range p.Layers {
+		if match(layer) && !seen[layer.Digest] {
+			seen[layer.Digest] = true
+			size += layer.Size
+		}
+	}
+	return len(seen), size
+}
+
+// Plan works out what Export would do with the same arguments: which layers
+// come from the base, the blob cache or the registry, the bundle's path and
+// its estimated size. Only manifests and configs are fetched, and the
+// imgcd binary is not downloaded. It plans one image for one platform.
+func (e *Exporter) Plan(ctx context.Context, newRef, sinceRef, outDir string, opts ExportOptions) (*ExportPlan, error) {
+	if opts.multiImage() {
+		return nil, fmt.Errorf("--dry-run plans one image for one platform")
+	}
+	sinceRef, err := readSinceFile(newRef, sinceRef, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ExportPlan{Image: imageName(newRef), Platform: opts.TargetPlatform, Mode: "registry"}
+	if !opts.ForceLocal {
+		err = e.planRemote(ctx, plan, newRef, sinceRef, opts)
+	}
+	// Like Export, images only a runtime has are planned in local mode
+	if opts.ForceLocal || (err != nil && !isSkopeoDir(newRef) && !isSkopeoDir(sinceRef) && !errors.Is(err, errBasePlatform) && !errors.Is(err, errArtifactUnsupported)) {
+		if err != nil {
+			fmt.Printf("Not in a registry (%v), planning a local-mode export...\n", err)
+		}
+		plan.Mode = e.runtime.Name()
+		err = e.planLocal(ctx, plan, newRef, sinceRef, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	repo, tag := parseReference(plan.Image)
+	plan.Path = generateFilename(repo, tag, plan.Base, outDir, false)
+	if opts.SelfExtracting {
+		plan.Path = selfExtractingPath(plan.Path)
+	}
+	if plan.Mode == "registry" && !opts.Rebuild {
+		if prepared := e.prepareBundle(ctx, newRef, sinceRef, outDir, opts); prepared.upToDate() {
+			plan.Path, plan.UpToDate = prepared.path, true
+		}
+	}
+
+	if path := NewBundleGenerator(e.version).availableBinary(opts.binaryTarget()); path != "" {
+		if info, err := os.Stat(path); err == nil {
+			plan.BinarySize = info.Size()
+		}
+	}
+	if plan.Mode == "registry" {
+		_, stored := plan.Stored()
+		plan.EstimatedSize = stored + plan.BinarySize + plan.MetadataSize
+	}
+	return plan, nil
+}
+
+// planRemote plans an export from the registry
+func (e *Exporter) planRemote(ctx context.Context, plan *ExportPlan, newRef, sinceRef string, opts ExportOptions) error {
+	platform, err := v1.ParsePlatform(opts.TargetPlatform)
+	if err != nil {
+		return fmt.Errorf("failed to parse platform: %w", err)
+	}
+	newImage, err := fetchImage(ctx, newRef, platform)
+	if err != nil {
+		return fmt.Errorf("failed to fetch image: %w", err)
+	}
+	manifest, err := newImage.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+	if artifact, err := artifactInfo(newImage, manifest); err != nil {
+		return err
+	} else if artifact != nil {
+		return fmt.Errorf("--dry-run does not plan OCI artifacts (%w)", errArtifactUnsupported)
+	}
+	configFile, err := newImage.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to get config file: %w", err)
+	}
+	rawManifest, err := newImage.RawManifest()
+	if err != nil {
+		return err
+	}
+	rawConfig, err := newImage.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	plan.MetadataSize = int64(len(rawManifest) + len(rawConfig))
+
+	if sinceRef != "" {
+		basePlatform := platform
+		if newPlatform := configPlatform(configFile); newPlatform != nil {
+			basePlatform = newPlatform
+		}
+		var baseConfig *v1.ConfigFile
+		if opts.sinceBundle != nil {
+			plan.Base, baseConfig = opts.sinceBundle.ImageRef, opts.sinceBundle.Config
+		} else {
+			plan.Base, baseConfig, _, err = fetchBase(ctx, newRef, sinceRef, basePlatform)
+			if err != nil {
+				return err
+			}
+		}
+		if err := checkBasePlatform(plan.Image, configPlatform(configFile), plan.Base, configPlatform(baseConfig)); err != nil {
+			return err
+		}
+		plan.SharedLayers = sharedPrefixLength(baseConfig.RootFS.DiffIDs, configFile.RootFS.DiffIDs)
+	}
+
+	blobCache, err := cache.NewBlobCache(opts.UseCache)
+	if err != nil {
+		return fmt.Errorf("failed to initialize blob cache: %w", err)
+	}
+	for i, layer := range manifest.Layers {
+		digest := layer.Digest.String()
+		plan.Layers = append(plan.Layers, PlannedLayer{
+			Digest:   digest,
+			Size:     layer.Size,
+			FromBase: i < plan.SharedLayers,
+			Cached:   i >= plan.SharedLayers && blobCache.Exists(digest),
+		})
+	}
+	return nil
+}
+
+// planLocal plans a local-mode export from the layers the runtime lists,
+// without pulling; their sizes are only known once the runtime saves them
+func (e *Exporter) planLocal(ctx context.Context, plan *ExportPlan, newRef, sinceRef string, opts ExportOptions) error {
+	lister, ok := e.runtime.(runtime.ImageLister)
+	if !ok {
+		return fmt.Errorf("the %s runtime cannot list the layers of its images", e.runtime.Name())
+	}
+	plan.Image = newRef
+	diffIDs, err := lister.ImageDiffIDs(ctx, newRef, opts.TargetPlatform)
+	if err != nil {
+		return fmt.Errorf("%s is neither in a registry nor in the %s runtime: %w", newRef, e.runtime.Name(), err)
+	}
+
+	if sinceRef != "" {
+		var baseDiffIDs []string
+		if opts.sinceBundle != nil {
+			plan.Base = opts.sinceBundle.ImageRef
+			for _, layer := range bundleImageInfo(opts.sinceBundle).Layers {
+				baseDiffIDs = append(baseDiffIDs, layer.Digest)
+			}
+		} else {
+			plan.Base = normalizeSinceRef(newRef, sinceRef)
+			baseDiffIDs, err = lister.ImageDiffIDs(ctx, plan.Base, opts.TargetPlatform)
+			if err != nil {
+				return fmt.Errorf("base image %s is not in the %s runtime: %w", plan.Base, e.runtime.Name(), err)
+			}
+		}
+		for plan.SharedLayers < len(diffIDs) && plan.SharedLayers < len(baseDiffIDs) && diffIDs[plan.SharedLayers] == baseDiffIDs[plan.SharedLayers] {
+			plan.SharedLayers++
+		}
+	}
+
+	for i, diffID := range diffIDs {
+		plan.Layers = append(plan.Layers, PlannedLayer{Digest: diffID, FromBase: i < plan.SharedLayers})
+	}
+	return nil
+}
+
+// availableBinary returns the imgcd binary for platform if it is at hand
+// without downloading a release, "" otherwise
+func (bg *BundleGenerator) availableBinary(platform string) string {
+	if customPath := os.Getenv("IMGCD_BINARY_PATH"); customPath != "" {
+		return customPath
+	}
+	if bg.version == "dev" {
+		path, _ := os.Executable()
+		return path
+	}
+	binaryPath := filepath.Join(bg.getCacheDir(), bg.version, platform, "imgcd")
+	if _, err := os.Stat(binaryPath); err == nil {
+		return binaryPath
+	}
+	return ""
+}
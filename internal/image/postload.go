@@ -0,0 +1,84 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// postLoad is the on-load command list a bundle carries
+type postLoad struct {
+	imageRef string
+	commands []string
+}
+
+// announcePostLoad prints the bundle's on-load commands when the metadata is read
+func (bl *BundleLoader) announcePostLoad(imageRef string, commands []string, opts LoadOptions) {
+	if len(commands) == 0 {
+		return
+	}
+	bl.postLoad = &postLoad{imageRef: imageRef, commands: commands}
+	if !opts.RunPostLoad {
+		fmt.Fprintf(bl.out, "Bundle has %d post-load command(s); use --run-post-load to run them\n", len(commands))
+	}
+}
+
+// runPostLoad runs the on-load commands of a loaded bundle one after another
+// with sh -c, in the extras directory if there is one, once they are confirmed
+func (bl *BundleLoader) runPostLoad(ctx context.Context, opts LoadOptions) error {
+	if bl.postLoad == nil || !opts.RunPostLoad {
+		return nil
+	}
+	commands := bl.postLoad.commands
+
+	if opts.ConfirmPostLoad != nil {
+		ok, err := opts.ConfirmPostLoad(commands)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintf(bl.out, "Post-load commands skipped\n")
+			return nil
+		}
+	}
+
+	out := opts.output()
+	for i, command := range commands {
+		fmt.Fprintf(bl.out, "\nRunning post-load command %d/%d: %s\n", i+1, len(commands), command)
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Dir = opts.ExtrasDir
+		cmd.Env = append(os.Environ(),
+			"IMGCD_IMAGE="+bl.postLoad.imageRef,
+			"IMGCD_EXTRAS_DIR="+opts.ExtrasDir,
+		)
+		cmd.Stdout = out
+		cmd.Stderr = postLoadStderr(out)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("post-load command %d (%s) failed: %w", i+1, command, err)
+		}
+	}
+
+	fmt.Fprintf(bl.out, "Post-load commands completed\n")
+	return nil
+}
+
+// postLoadStderr keeps command errors on stderr unless output is collected
+func postLoadStderr(out io.Writer) io.Writer {
+	if out == os.Stdout {
+		return os.Stderr
+	}
+	return out
+}
+
+// FormatPostLoad lists on-load commands for a confirmation prompt
+func FormatPostLoad(commands []string) string {
+	var b strings.Builder
+	for i, command := range commands {
+		fmt.Fprintf(&b, "  %d. %s\n", i+1, command)
+	}
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/cache"
+	"github.com/so2liu/imgcd/internal/runtime"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// preparedKey describes everything that determines the content of a bundle.
+// Provenance is deliberately left out: re-exporting the same image from a
+// different pipeline run is still the same delivery.
+type preparedKey struct {
+	ImageDigest string            `json:"image_digest"`
+	BaseDigest  string            `json:"base_digest,omitempty"`
+	Platform    string            `json:"platform"`
+	Format      string            `json:"format"` // Where digests were resolved: "registry" or the runtime name
+	Version     string            `json:"version"`
+	BinaryPath  string            `json:"binary_path,omitempty"`
+	Binary      string            `json:"binary_platform,omitempty"`
+	SignKey     string            `json:"sign_key,omitempty"`
+	Note        string            `json:"note,omitempty"`
+	ExpiresAt   string            `json:"expires_at,omitempty"` // --expires counts from the save, so such bundles are always rebuilt
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Statement   bool              `json:"provenance_statement,omitempty"`
+	SBOM        string            `json:"sbom,omitempty"` // Digest of the SBOM file, or "syft"
+	SelfExtract bool              `json:"self_extracting,omitempty"`
+	Attachments string            `json:"attachments,omitempty"` // Digest of the attached files
+	OnLoad      []string          `json:"on_load,omitempty"`
+	Checksums   []string          `json:"checksums,omitempty"`
+}
+
+// hash returns a stable identifier for the key
+func (k preparedKey) hash() string {
+	// encoding/json sorts map keys, so equal keys always hash the same
+	data, _ := json.Marshal(k)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// preparedBundle is the bundle an export is expected to produce
+type preparedBundle struct {
+	index   *cache.BundleIndex
+	path    string
+	key     string
+	newRef  string
+	baseRef string
+}
+
+// prepareBundle resolves the inputs of an export into a key. It returns nil
+// when the inputs cannot be pinned to digests, in which case the export
+// always runs.
+func (e *Exporter) prepareBundle(ctx context.Context, newRef, sinceRef, outDir string, opts ExportOptions) *preparedBundle {
+	// Development builds embed whatever binary is running, which changes on every build
+	if e.version == "dev" {
+		return nil
+	}
+
+	imageDigest, format, err := e.resolveDigest(ctx, newRef, "", opts)
+	if err != nil {
+		return nil
+	}
+
+	key := preparedKey{
+		ImageDigest: imageDigest,
+		Platform:    opts.TargetPlatform,
+		Format:      format,
+		Version:     e.version,
+		BinaryPath:  os.Getenv("IMGCD_BINARY_PATH"),
+		Binary:      opts.BinaryPlatform,
+		SignKey:     opts.SignKey,
+		Note:        opts.Note,
+		ExpiresAt:   formatExpiry(opts.ExpiresAt),
+		Annotations: opts.Annotations,
+		Statement:   opts.ProvenanceStatement,
+		SelfExtract: opts.SelfExtracting,
+		OnLoad:      opts.OnLoad,
+		Checksums:   opts.Checksums,
+	}
+	key.Attachments, err = attachmentsDigest(opts.Attachments)
+	if err != nil {
+		return nil
+	}
+	key.SBOM, err = sbomDigest(opts)
+	if err != nil {
+		return nil
+	}
+
+	baseRef := ""
+	if sinceRef != "" {
+		baseRef = normalizeSinceRef(newRef, sinceRef)
+		if opts.sinceBundle != nil {
+			// The bundle keeps no raw manifest to hash; the config digest identifies the base
+			key.BaseDigest = opts.sinceBundle.Manifest.Config.Digest.String()
+		} else {
+			key.BaseDigest, _, err = e.resolveDigest(ctx, baseRef, format, opts)
+			if err != nil {
+				return nil
+			}
+		}
+	}
+
+	index, err := cache.NewBundleIndex()
+	if err != nil {
+		return nil
+	}
+
+	repo, tag := parseReference(imageName(newRef))
+	bundlePath, err := filepath.Abs(generateFilename(repo, tag, imageName(baseRef), outDir, false))
+	if err != nil {
+		return nil
+	}
+	if opts.SelfExtracting {
+		bundlePath = selfExtractingPath(bundlePath)
+	}
+
+	return &preparedBundle{
+		index:   index,
+		path:    bundlePath,
+		key:     key.hash(),
+		newRef:  imageName(newRef),
+		baseRef: imageName(baseRef),
+	}
+}
+
+// upToDate reports whether the bundle on disk was produced from the same inputs
+func (p *preparedBundle) upToDate() bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.index.Lookup(p.path, p.key)
+	return ok
+}
+
+// record remembers the inputs of a freshly created bundle
+func (p *preparedBundle) record(bundlePath string, opts ExportOptions) {
+	if p == nil {
+		return
+	}
+
+	// The exporter may have resolved the base differently (e.g., fuzzy tags)
+	absPath, err := filepath.Abs(bundlePath)
+	if err != nil || absPath != p.path {
+		return
+	}
+
+	err = p.index.Record(cache.PreparedBundle{
+		Key:       p.key,
+		Path:      p.path,
+		ImageRef:  p.newRef,
+		BaseRef:   p.baseRef,
+		CreatedAt: time.Now(),
+		ExpiresAt: opts.ExpiresAt,
+	})
+	if err != nil {
+		ui.Warning("failed to record bundle in index: %v", err)
+	}
+}
+
+// resolveDigest pins ref to a content digest without downloading layers.
+// format selects the source ("registry" or a runtime name); empty tries the
+// registry first unless local mode is forced, mirroring Export.
+func (e *Exporter) resolveDigest(ctx context.Context, ref, format string, opts ExportOptions) (string, string, error) {
+	if format == "registry" || (format == "" && !opts.ForceLocal) {
+		platform, err := v1.ParsePlatform(opts.TargetPlatform)
+		if err != nil {
+			return "", "", err
+		}
+		img, err := fetchImage(ctx, ref, platform)
+		if err == nil {
+			digest, err := img.Digest()
+			if err != nil {
+				return "", "", err
+			}
+			return digest.String(), "registry", nil
+		}
+		if format == "registry" {
+			return "", "", err
+		}
+	}
+
+	// Content stores hand out the platform manifest directly
+	if cs, ok := e.runtime.(runtime.ContentStore); ok {
+		manifest, err := cs.ImageManifest(ctx, ref, opts.TargetPlatform)
+		if err != nil {
+			return "", "", err
+		}
+		sum := sha256.Sum256(manifest)
+		return "sha256:" + hex.EncodeToString(sum[:]), e.runtime.Name(), nil
+	}
+
+	info, err := e.runtime.GetImageWithPlatform(ctx, ref, opts.TargetPlatform)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(info.ID, "sha256:") {
+		return "", "", fmt.Errorf("runtime did not report a digest for %s", ref)
+	}
+	return info.ID, e.runtime.Name(), nil
+}
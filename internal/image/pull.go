@@ -0,0 +1,87 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+)
+
+// PullResult counts the blobs of an image pulled into the blob cache
+type PullResult struct {
+	Blobs    int   // Distinct blobs of the image
+	New      int   // Blobs downloaded by this pull
+	NewBytes int64 // Size of the downloaded blobs
+	Cached   int   // Blobs that were already cached
+	Peers    int   // Blobs of New fetched from peer imgcd serve instances
+}
+
+// Pull downloads every blob of an image into the blob cache without building
+// a bundle, so later saves of it (or of images sharing its layers) only read
+// the cache. OCI artifacts are pulled blob for blob, config included.
+func (re *RemoteExporter) Pull(ctx context.Context, ref, targetPlatform string) (*PullResult, error) {
+	if !re.blobCache.Enabled() {
+		return nil, fmt.Errorf("the blob cache is disabled (--no-state)")
+	}
+	defer re.lease("pull " + ref)()
+
+	platform, err := v1.ParsePlatform(targetPlatform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse platform: %w", err)
+	}
+	img, err := fetchImage(ctx, ref, platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layers: %w", err)
+	}
+	artifact, err := artifactInfo(img, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if artifact != nil {
+		// Artifact exports store the config blob too, under its digest
+		config, err := partial.ConfigLayer(img)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config blob: %w", err)
+		}
+		blobs := []v1.Layer{artifactBlob{config}}
+		for _, layer := range layers {
+			blobs = append(blobs, artifactBlob{layer})
+		}
+		layers = blobs
+	}
+	layers, err = uniqueLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := re.blobDownloader.DownloadBlobsWithProgress(ctx, layers, ref, 4, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blobs: %w", err)
+	}
+	re.recordManifest(ref, targetPlatform, img)
+
+	result := &PullResult{Blobs: len(results)}
+	for _, r := range results {
+		if r.FromCache {
+			result.Cached++
+		} else {
+			result.New++
+			result.NewBytes += r.Size
+		}
+		if r.Peer != "" {
+			result.Peers++
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,324 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+// PushOptions configures PushBundle
+type PushOptions struct {
+	// Output receives progress messages; os.Stdout if nil
+	Output io.Writer
+}
+
+// PushBundle uploads the image (or OCI artifact) of a bundle to a registry
+// instead of loading it into a runtime. target is a repository, optionally
+// with a tag; the bundle's tag is used when it has none. Blobs the registry
+// already has are not uploaded again, which is also how the base layers of
+// incremental bundles are found. It returns the pushed reference by digest.
+func PushBundle(ctx context.Context, bundlePath, target string, opts PushOptions) (string, error) {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	image, err := openBundleImage(bundlePath)
+	if err != nil {
+		return "", err
+	}
+	defer image.Close()
+
+	tempDir, err := os.MkdirTemp("", "imgcd-push-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	contents, err := extractPushContents(image, tempDir)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case contents.metadata != nil && contents.metadata.Artifact != nil:
+		bl := NewBundleLoader(nil)
+		bl.out = out
+		if err := bl.loadArtifact(ctx, tempDir, contents.metadata, LoadOptions{ArtifactPush: target, Output: out}); err != nil {
+			return "", err
+		}
+		_, tag := parseReference(contents.metadata.ImageRef)
+		return artifactTarget(target, tag) + "@" + contents.metadata.Artifact.Digest, nil
+
+	case contents.metadata != nil && len(contents.metadata.Images) > 0:
+		return "", fmt.Errorf("bundle holds %d images; only bundles of a single image and platform can be pushed", len(contents.metadata.Images)+1)
+
+	case contents.metadata != nil:
+		img, err := newBundleImage(tempDir, contents.metadata)
+		if err != nil {
+			return "", err
+		}
+		stored := 0
+		for _, layer := range contents.metadata.Manifest.Layers {
+			if contents.blobs[layer.Digest.String()] {
+				stored++
+			}
+		}
+		fmt.Fprintf(out, "Image: %s (%d layer(s) in the bundle", contents.metadata.ImageRef, stored)
+		if fromBase := len(contents.metadata.Manifest.Layers) - stored; fromBase > 0 {
+			fmt.Fprintf(out, ", %d from base %s expected in the registry", fromBase, contents.metadata.BaseRef)
+		}
+		fmt.Fprintf(out, ")\n")
+		return pushImage(ctx, out, img, contents.metadata.ImageRef, target)
+
+	case contents.legacy != nil:
+		if contents.legacy.Incremental && contents.legacy.SinceRef != "" {
+			return "", fmt.Errorf("legacy incremental bundles cannot be pushed: their image.tar lacks the base layers")
+		}
+		if contents.imageTar == "" {
+			return "", fmt.Errorf("image.tar not found in v1 bundle")
+		}
+		img, err := tarball.ImageFromPath(contents.imageTar, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to read image.tar: %w", err)
+		}
+		fmt.Fprintf(out, "Image: %s (legacy format)\n", contents.legacy.NewRef)
+		return pushImage(ctx, out, img, contents.legacy.NewRef, target)
+	}
+
+	return "", fmt.Errorf("metadata not found in bundle (expected metadata.json or imgcd-meta.json)")
+}
+
+// pushContents is what PushBundle extracted from a bundle
+type pushContents struct {
+	metadata *bundle.Metadata
+	legacy   *v1Metadata
+	imageTar string          // Extracted image.tar of a legacy bundle
+	blobs    map[string]bool // Digests of the extracted blobs
+}
+
+// extractPushContents extracts the metadata, blobs and artifact manifest of
+// an image.tar.gz stream to dir, checking every blob against its digest
+func extractPushContents(r io.Reader, dir string) (*pushContents, error) {
+	br, err := bundle.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer br.Close()
+	br.SkipDigests() // extractVerifiedBlob checks them with the checksums
+
+	bl := NewBundleLoader(nil)
+	contents := &pushContents{blobs: make(map[string]bool)}
+	var early []string // Blobs stored before the metadata, by repacking
+	for entry, err := range br.All() {
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case entry.Kind == bundle.MetadataEntry:
+			contents.metadata = br.Metadata()
+
+		case entry.Kind == bundle.LegacyMetadataEntry:
+			contents.legacy = &v1Metadata{}
+			if err := json.NewDecoder(entry).Decode(contents.legacy); err != nil {
+				return nil, fmt.Errorf("failed to decode metadata: %w", err)
+			}
+
+		case entry.Name() == "image.tar":
+			contents.imageTar = filepath.Join(dir, "image.tar")
+			if err := bl.extractFile(entry, contents.imageTar); err != nil {
+				return nil, fmt.Errorf("failed to extract image.tar: %w", err)
+			}
+
+		case entry.Kind == bundle.BlobEntry:
+			digest := entry.Digest
+			var checksums map[string]string
+			if contents.metadata != nil {
+				checksums = contents.metadata.LayerChecksums(digest)
+			} else {
+				early = append(early, digest)
+			}
+			if err := extractVerifiedBlob(entry, filepath.Join(dir, bundle.Encoded(digest)), digest, checksums); err != nil {
+				return nil, fmt.Errorf("failed to extract blob %s: %w", digest, err)
+			}
+			contents.blobs[digest] = true
+
+		case entry.Name() == artifactManifestName:
+			if err := bl.extractFile(entry, filepath.Join(dir, artifactManifestName)); err != nil {
+				return nil, fmt.Errorf("failed to extract artifact manifest: %w", err)
+			}
+		}
+	}
+
+	if contents.metadata != nil {
+		for _, digest := range early {
+			path := filepath.Join(dir, bundle.Encoded(digest))
+			if err := verifyBlobFile(path, digest, contents.metadata.LayerChecksums(digest)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return contents, nil
+}
+
+// pushImage writes img to target, printing upload progress
+func pushImage(ctx context.Context, out io.Writer, img v1.Image, imageRef, target string) (string, error) {
+	_, tag := parseReference(imageRef)
+	target = artifactTarget(target, tag)
+	ref, err := name.ParseReference(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid push target %s: %w", target, err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return "", fmt.Errorf("failed to compute manifest digest: %w", err)
+	}
+
+	fmt.Fprintf(out, "Pushing to %s...\n", ref)
+	progress := make(chan v1.Update, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for update := range progress {
+			if update.Total > 0 {
+				fmt.Fprintf(os.Stderr, "Progress: %d/%d bytes uploaded\r", update.Complete, update.Total)
+			}
+		}
+	}()
+	err = remote.Write(ref, img,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithProgress(progress),
+	)
+	<-done
+	if err != nil {
+		return "", fmt.Errorf("failed to push image: %w", err)
+	}
+	fmt.Fprintf(out, "\n")
+
+	return ref.Context().Digest(digest.String()).String(), nil
+}
+
+// bundleImage is the image of a v2 bundle for remote.Write: the manifest's
+// layers, read from the extracted blobs, under the metadata's config
+type bundleImage struct {
+	blobDir  string
+	baseRef  string
+	config   []byte
+	manifest []byte
+	media    types.MediaType
+	layers   map[v1.Hash]v1.Descriptor
+}
+
+// newBundleImage assembles the image of a v2 bundle whose blobs are in
+// blobDir. The config keeps its bytes (bundles of older versions encode it
+// anew), but the manifest is encoded from the metadata, so its digest can
+// differ from the one the image had in its source registry.
+func newBundleImage(blobDir string, metadata *bundle.Metadata) (v1.Image, error) {
+	if metadata.Manifest == nil || metadata.Config == nil {
+		return nil, fmt.Errorf("bundle metadata has no manifest or image config")
+	}
+	config, err := metadata.ConfigBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image config: %w", err)
+	}
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := *metadata.Manifest
+	manifest.Config = v1.Descriptor{
+		MediaType: manifest.Config.MediaType,
+		Size:      configSize,
+		Digest:    configDigest,
+	}
+	media := manifest.MediaType
+	if media == "" {
+		media = types.DockerManifestSchema2
+		if manifest.Config.MediaType == types.OCIConfigJSON {
+			media = types.OCIManifestSchema1
+		}
+		manifest.MediaType = media
+	}
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	layers := make(map[v1.Hash]v1.Descriptor)
+	for _, desc := range manifest.Layers {
+		layers[desc.Digest] = desc
+	}
+	return partial.CompressedToImage(&bundleImage{
+		blobDir:  blobDir,
+		baseRef:  metadata.BaseRef,
+		config:   config,
+		manifest: raw,
+		media:    media,
+		layers:   layers,
+	})
+}
+
+func (b *bundleImage) RawConfigFile() ([]byte, error) {
+	return b.config, nil
+}
+
+func (b *bundleImage) MediaType() (types.MediaType, error) {
+	return b.media, nil
+}
+
+func (b *bundleImage) RawManifest() ([]byte, error) {
+	return b.manifest, nil
+}
+
+func (b *bundleImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	desc, ok := b.layers[h]
+	if !ok {
+		return nil, fmt.Errorf("layer %s is not in the manifest", h)
+	}
+	return &bundleBlob{desc: desc, path: filepath.Join(b.blobDir, h.Hex), baseRef: b.baseRef}, nil
+}
+
+// bundleBlob is a compressed layer stored in the bundle, or one of the base
+// layers an incremental bundle leaves out
+type bundleBlob struct {
+	desc    v1.Descriptor
+	path    string
+	baseRef string
+}
+
+func (l *bundleBlob) Digest() (v1.Hash, error) {
+	return l.desc.Digest, nil
+}
+
+func (l *bundleBlob) Size() (int64, error) {
+	return l.desc.Size, nil
+}
+
+func (l *bundleBlob) MediaType() (types.MediaType, error) {
+	return l.desc.MediaType, nil
+}
+
+// Compressed is only called for blobs the registry does not have yet
+func (l *bundleBlob) Compressed() (io.ReadCloser, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("layer %s comes from base image %s, which the registry does not have; push the base first", l.desc.Digest, l.baseRef)
+	}
+	return f, err
+}
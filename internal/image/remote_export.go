@@ -3,7 +3,6 @@ package image
 import (
 	"archive/tar"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -18,8 +17,10 @@ import (
 	"github.com/klauspost/pgzip"
 	"github.com/so2liu/imgcd/internal/bundle"
 	"github.com/so2liu/imgcd/internal/cache"
+	"github.com/so2liu/imgcd/internal/humanize"
 	"github.com/so2liu/imgcd/internal/prompt"
 	remotedownload "github.com/so2liu/imgcd/internal/remote"
+	"github.com/so2liu/imgcd/internal/ui"
 )
 
 // RemoteExporter handles exporting images using blob-based caching
@@ -28,6 +29,7 @@ import (
 type RemoteExporter struct {
 	version        string
 	blobCache      *cache.BlobCache
+	manifestCache  *cache.ManifestCache // nil when the blob cache is disabled
 	blobDownloader *remotedownload.BlobDownloader
 }
 
@@ -38,17 +40,109 @@ func NewRemoteExporter(version string, useCache bool) (*RemoteExporter, error) {
 		return nil, fmt.Errorf("failed to initialize blob cache: %w", err)
 	}
 
+	var manifestCache *cache.ManifestCache
+	if blobCache.Enabled() {
+		manifestCache, err = cache.NewManifestCache()
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize manifest cache: %w", err)
+		}
+	}
+
 	return &RemoteExporter{
 		version:        version,
 		blobCache:      blobCache,
+		manifestCache:  manifestCache,
 		blobDownloader: remotedownload.NewBlobDownloader(blobCache),
 	}, nil
 }
 
+// SetSegments makes large blobs download in ranged segments, from the
+// registry and the mirrors of opts
+func (re *RemoteExporter) SetSegments(opts remotedownload.SegmentOptions) {
+	re.blobDownloader.SetSegments(opts)
+}
+
+// SetPeers makes blobs be asked from other imgcd serve instances before the
+// registry
+func (re *RemoteExporter) SetPeers(peers []string) {
+	re.blobDownloader.SetPeers(peers)
+}
+
+// SetRateLimit caps the bytes per second of the layer downloads together;
+// 0 removes the cap
+func (re *RemoteExporter) SetRateLimit(rate int64) {
+	re.blobDownloader.SetRateLimit(rate)
+}
+
+// lease holds the cached blobs of a job until the returned func is called,
+// so that an imgcd cache prune or gc running meanwhile keeps them
+func (re *RemoteExporter) lease(job string) func() {
+	lease, err := re.blobCache.Lease(job)
+	if err != nil {
+		ui.Warning("failed to lease cached blobs, a concurrent cache prune may remove them: %v", err)
+		return func() {}
+	}
+	return lease.Release
+}
+
+// recordManifest keeps the manifest and config of an image fetched for the
+// given platform in the manifest cache, for imgcd serve. It only warns on
+// failure; the export does not depend on it.
+func (re *RemoteExporter) recordManifest(ref, platform string, img v1.Image) {
+	if re.manifestCache == nil {
+		return
+	}
+	if err := re.putManifest(ref, platform, img); err != nil {
+		ui.Warning("failed to record the manifest of %s: %v", ref, err)
+	}
+}
+
+func (re *RemoteExporter) putManifest(ref, platform string, img v1.Image) error {
+	// Single-platform images are served whatever platform was asked for
+	if config, err := img.ConfigFile(); err == nil {
+		if p := config.Platform(); p != nil && p.OS != "" {
+			platform = p.String()
+		}
+	}
+	rawManifest, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return err
+	}
+
+	layers := make([]string, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		layers[i] = layer.Digest.String()
+	}
+	return re.manifestCache.Put(ref, cache.CachedManifest{
+		Platform:  platform,
+		Digest:    digest.String(),
+		MediaType: string(mediaType),
+		Config:    manifest.Config.Digest.String(),
+		Layers:    layers,
+	}, rawManifest, rawConfig)
+}
+
 // ExportFromRegistry exports an image directly from registry using blob caching
 func (re *RemoteExporter) ExportFromRegistry(ctx context.Context, newRef, sinceRef, outDir string, opts ExportOptions) (string, error) {
 	fmt.Printf("Using remote mode: downloading compressed blobs\n")
 	fmt.Printf("Target platform: %s\n", opts.TargetPlatform)
+	defer re.lease("save " + newRef)()
 
 	// Parse platform
 	platform, err := v1.ParsePlatform(opts.TargetPlatform)
@@ -58,10 +152,11 @@ func (re *RemoteExporter) ExportFromRegistry(ctx context.Context, newRef, sinceR
 
 	// Fetch new image from registry
 	fmt.Printf("Fetching image metadata for %s...\n", newRef)
-	newImage, err := re.fetchImage(ctx, newRef, platform)
+	newImage, err := fetchImage(ctx, newRef, platform)
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch new image: %w", err)
 	}
+	newRef = imageName(newRef)
 
 	// Get manifest and config
 	manifest, err := newImage.Manifest()
@@ -72,10 +167,23 @@ func (re *RemoteExporter) ExportFromRegistry(ctx context.Context, newRef, sinceR
 		return "", fmt.Errorf("manifest is nil")
 	}
 
+	// Helm charts, WASM modules and ORAS files are carried blob for blob
+	artifact, err := artifactInfo(newImage, manifest)
+	if err != nil {
+		return "", err
+	}
+	if artifact != nil {
+		return re.exportArtifact(ctx, newRef, sinceRef, outDir, newImage, manifest, artifact, opts)
+	}
+
 	configFile, err := newImage.ConfigFile()
 	if err != nil {
 		return "", fmt.Errorf("failed to get config file: %w", err)
 	}
+	rawConfig, err := newImage.RawConfigFile()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config file: %w", err)
+	}
 
 	// Validate config file
 	if configFile == nil {
@@ -96,60 +204,32 @@ func (re *RemoteExporter) ExportFromRegistry(ctx context.Context, newRef, sinceR
 	var layerInfos []bundle.LayerInfo
 	var sharedLayerCount int // Number of layers shared with base
 	fullSinceRef := ""
+	var baseConfig *v1.ConfigFile
+	var baseConfigName v1.Hash
 
 	if sinceRef != "" {
-		// Incremental export - resolve tag with fuzzy matching
-		if !strings.Contains(sinceRef, "/") && !strings.Contains(sinceRef, ":") {
-			// Short tag format - resolve with exact-first-then-fuzzy logic
-			repo, _ := parseReference(newRef)
-			fetcher := remotedownload.NewFetcher()
-
-			exactTag, matches, err := fetcher.ResolveTag(ctx, repo, sinceRef)
-			if err != nil {
-				return "", err
-			}
-
-			if exactTag != "" {
-				// Exact or single fuzzy match
-				if exactTag != sinceRef {
-					fmt.Printf("Resolved --since %q to tag: %s\n", sinceRef, exactTag)
-				}
-				fullSinceRef = fmt.Sprintf("%s:%s", repo, exactTag)
-			} else {
-				// Multiple matches - prompt user
-				selected, err := prompt.PromptSelection(
-					fmt.Sprintf("Multiple tags found matching %q:", sinceRef),
-					matches,
-				)
-				if err != nil {
-					return "", err
-				}
-				fmt.Printf("Selected: %s\n", selected)
-				fullSinceRef = fmt.Sprintf("%s:%s", repo, selected)
+		// A single-platform image is served whatever platform was asked
+		// for, so pick the base's variant by the platform the image really is
+		basePlatform := platform
+		if newPlatform := configPlatform(configFile); newPlatform != nil {
+			if !samePlatform(newPlatform, platform) {
+				fmt.Printf("%s is %s, not %s: using the base variant for %s\n", newRef, newPlatform, platform, newPlatform)
 			}
-		} else {
-			fullSinceRef = normalizeSinceRef(newRef, sinceRef)
+			basePlatform = newPlatform
 		}
-		fmt.Printf("Calculating diff with: %s\n", fullSinceRef)
 
-		baseImage, err := re.fetchImage(ctx, fullSinceRef, platform)
-		if err != nil {
-			return "", fmt.Errorf("failed to fetch base image: %w", err)
-		}
-
-		baseLayers, err := baseImage.Layers()
-		if err != nil {
-			return "", fmt.Errorf("failed to get base layers: %w", err)
-		}
-
-		// Build map of base layer DiffIDs
-		baseDiffIDs := make(map[string]bool)
-		for _, layer := range baseLayers {
-			diffID, err := layer.DiffID()
+		if opts.sinceBundle != nil {
+			fullSinceRef = opts.sinceBundle.ImageRef
+			baseConfig, baseConfigName = opts.sinceBundle.Config, opts.sinceBundle.Manifest.Config.Digest
+			fmt.Printf("Calculating diff with: %s (from %s)\n", fullSinceRef, filepath.Base(opts.sinceFile()))
+		} else {
+			fullSinceRef, baseConfig, baseConfigName, err = fetchBase(ctx, newRef, sinceRef, basePlatform)
 			if err != nil {
-				continue
+				return "", err
 			}
-			baseDiffIDs[diffID.String()] = true
+		}
+		if err := checkBasePlatform(newRef, configPlatform(configFile), fullSinceRef, configPlatform(baseConfig)); err != nil {
+			return "", err
 		}
 
 		// Filter out shared layers (but keep full config/manifest)
@@ -161,19 +241,7 @@ func (re *RemoteExporter) ExportFromRegistry(ctx context.Context, newRef, sinceR
 		var totalSize int64
 
 		// First pass: find consecutive shared layers from start
-		for i, layer := range newLayers {
-			diffID, err := layer.DiffID()
-			if err != nil {
-				return "", fmt.Errorf("failed to get layer DiffID: %w", err)
-			}
-			if baseDiffIDs[diffID.String()] {
-				sharedLayerCount++
-			} else {
-				// Stop counting at first non-shared layer
-				break
-			}
-			_ = i // silence unused variable warning
-		}
+		sharedLayerCount = sharedPrefixLength(baseConfig.RootFS.DiffIDs, configFile.RootFS.DiffIDs)
 
 		// Second pass: build layer infos for all layers after shared prefix
 		for i, layer := range newLayers {
@@ -212,77 +280,61 @@ func (re *RemoteExporter) ExportFromRegistry(ctx context.Context, newRef, sinceR
 			})
 		}
 
-		fmt.Printf("Filtered %d/%d layers (saved %.1f MB)\n",
+		fmt.Printf("Filtered %d/%d layers (saved %s)\n",
 			sharedLayerCount, len(newLayers),
-			float64(filteredSize)/(1024*1024))
+			humanize.Size(filteredSize))
 	} else {
 		// Full export
 		fmt.Printf("Creating full export...\n")
 		layersToExport = newLayers
 
 		// Build layer infos for all layers
-		for i, layer := range newLayers {
-			diffID, err := layer.DiffID()
-			if err != nil {
-				return "", fmt.Errorf("failed to get layer DiffID: %w", err)
-			}
-
-			digest, err := layer.Digest()
-			if err != nil {
-				return "", fmt.Errorf("failed to get layer digest: %w", err)
-			}
-
-			size, _ := layer.Size()
-
-			mediaType := ""
-			if i < len(manifest.Layers) {
-				mediaType = string(manifest.Layers[i].MediaType)
-			}
-
-			layerInfos = append(layerInfos, bundle.LayerInfo{
-				Digest:    digest.String(),
-				DiffID:    diffID.String(),
-				Size:      size,
-				MediaType: mediaType,
-			})
+		layerInfos, err = describeLayers(newLayers, manifest)
+		if err != nil {
+			return "", err
 		}
 	}
 
-	// Check if we have layers to export
-	if len(layersToExport) == 0 {
-		fmt.Printf("Warning: All layers already exist in base image. Creating minimal export.\n")
-		layersToExport = newLayers
+	// Every layer is in the base image: the bundle only needs metadata,
+	// load rebuilds the image entirely from the base
+	if len(layersToExport) == 0 && fullSinceRef != "" {
+		newConfigName, _ := newImage.ConfigName()
+
+		// Identical configs mean nothing at all changed
+		if opts.SkipUnchanged && newConfigName == baseConfigName {
+			return "", errNoChanges
+		}
+		fmt.Printf("All layers already exist in base image. Creating config-only bundle.\n")
+		printConfigChanges(baseConfig, configFile)
 	}
 
-	// Download blobs (this is the key optimization - no decompression!)
-	fmt.Printf("\nDownloading %d layer(s)...\n", len(layersToExport))
-	results, err := re.blobDownloader.DownloadBlobsWithProgress(
-		ctx,
-		layersToExport,
-		newRef,
-		4, // Max 4 concurrent downloads
-		func(completed, total int, currentBlob string) {
-			fmt.Fprintf(os.Stderr, "Progress: %d/%d blobs downloaded\r", completed, total)
-		},
-	)
+	var results []remotedownload.DownloadResult
+	layersToExport, err = uniqueLayers(layersToExport)
 	if err != nil {
-		return "", fmt.Errorf("failed to download blobs: %w", err)
+		return "", err
 	}
-
-	fmt.Printf("\nAll blobs downloaded/cached\n")
-
-	// Count cache hits
-	cacheHits := 0
-	for _, result := range results {
-		if result.FromCache {
-			cacheHits++
+	if len(layersToExport) > 0 {
+		// Download blobs (this is the key optimization - no decompression!)
+		fmt.Printf("\nDownloading %d layer(s)...\n", len(layersToExport))
+		results, err = re.blobDownloader.DownloadBlobsWithProgress(
+			ctx,
+			layersToExport,
+			newRef,
+			4, // Max 4 concurrent downloads
+			os.Stderr,
+		)
+		if err != nil {
+			return "", fmt.Errorf("failed to download blobs: %w", err)
 		}
+
+		fmt.Printf("All blobs downloaded/cached\n")
 	}
-	if cacheHits > 0 {
-		fmt.Printf("Cache hits: %d/%d blobs\n", cacheHits, len(results))
-	}
+	re.recordManifest(newRef, opts.TargetPlatform, newImage)
+
+	countDownloads(results, opts.downloads)
 
 	// Create bundle metadata with full config/manifest
+	createdAt := time.Now()
 	// For incremental exports, Layers contains only new layers but Config/Manifest are complete
 	metadata := bundle.Metadata{
 		Version:          "2",
@@ -290,34 +342,105 @@ func (re *RemoteExporter) ExportFromRegistry(ctx context.Context, newRef, sinceR
 		BaseRef:          fullSinceRef,
 		SharedLayerCount: sharedLayerCount,
 		Platform:         opts.TargetPlatform,
+		BinaryPlatform:   opts.recordedBinaryPlatform(),
 		Manifest:         manifest,   // Full manifest (all layers)
 		Config:           configFile, // Full config (all DiffIDs and History)
+		RawConfig:        rawConfig,
 		Layers:           layerInfos, // Only new layers for incremental
 		TotalSize:        calculateTotalSize(layerInfos),
-		CreatedAt:        time.Now().Format(time.RFC3339),
+		CreatedAt:        createdAt.Format(time.RFC3339),
+		ExpiresAt:        formatExpiry(opts.ExpiresAt),
+		Note:             opts.Note,
+		Annotations:      opts.Annotations,
+		OnLoad:           opts.OnLoad,
+		Provenance:       opts.Provenance,
+	}
+
+	configName, err := newImage.ConfigName()
+	if err != nil {
+		return "", fmt.Errorf("failed to get image ID: %w", err)
 	}
+	extras, err := extraEntries(opts, newRef, fullSinceRef, configName.String(), createdAt)
+	if err != nil {
+		return "", err
+	}
+
+	return re.writeBundle(outDir, metadata, extras, results, opts)
+}
+
+// fetchBase resolves the --since reference of an export of newRef, a tag
+// matched against the repository's tags or a full reference, and fetches
+// the base's config for platform
+func fetchBase(ctx context.Context, newRef, sinceRef string, platform *v1.Platform) (string, *v1.ConfigFile, v1.Hash, error) {
+	fullSinceRef := ""
+	if !strings.Contains(sinceRef, "/") && !strings.Contains(sinceRef, ":") {
+		// Short tag format - resolve with exact-first-then-fuzzy logic
+		repo, _ := parseReference(newRef)
+		fetcher := remotedownload.NewFetcher()
 
+		exactTag, matches, err := fetcher.ResolveTag(ctx, repo, sinceRef)
+		if err != nil {
+			return "", nil, v1.Hash{}, err
+		}
+
+		if exactTag != "" {
+			// Exact or single fuzzy match
+			if exactTag != sinceRef {
+				fmt.Printf("Resolved --since %q to tag: %s\n", sinceRef, exactTag)
+			}
+			fullSinceRef = fmt.Sprintf("%s:%s", repo, exactTag)
+		} else {
+			// Multiple matches - prompt user
+			selected, err := prompt.PromptSelection(
+				fmt.Sprintf("Multiple tags found matching %q:", sinceRef),
+				matches,
+			)
+			if err != nil {
+				return "", nil, v1.Hash{}, err
+			}
+			fmt.Printf("Selected: %s\n", selected)
+			fullSinceRef = fmt.Sprintf("%s:%s", repo, selected)
+		}
+	} else {
+		fullSinceRef = normalizeSinceRef(newRef, sinceRef)
+	}
+	fmt.Printf("Calculating diff with: %s\n", fullSinceRef)
+
+	baseImage, err := fetchImage(ctx, fullSinceRef, platform)
+	if err != nil {
+		return "", nil, v1.Hash{}, fmt.Errorf("failed to fetch base image: %w", err)
+	}
+	baseConfig, err := baseImage.ConfigFile()
+	if err != nil {
+		return "", nil, v1.Hash{}, fmt.Errorf("failed to get base config: %w", err)
+	}
+	baseConfigName, err := baseImage.ConfigName()
+	if err != nil {
+		return "", nil, v1.Hash{}, fmt.Errorf("failed to get base image ID: %w", err)
+	}
+	return imageName(fullSinceRef), baseConfig, baseConfigName, nil
+}
+
+// writeBundle packs the metadata and downloaded blobs into a bundle in outDir
+func (re *RemoteExporter) writeBundle(outDir string, metadata bundle.Metadata, extras []bundleEntry, results []remotedownload.DownloadResult, opts ExportOptions) (string, error) {
 	// Create output directory
 	if err := os.MkdirAll(outDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create output directory: %w", err)
 	}
 
 	// Generate output paths
-	repo, tag := parseReference(newRef)
-	tarGzPath := generateFilename(repo, tag, fullSinceRef, outDir, true)
+	tarGzPath, bundlePath := bundlePaths(metadata, outDir)
 
 	// Create the bundle tar.gz
 	fmt.Printf("\nPacking blobs into bundle...\n")
-	if err := re.createBundleTarGz(tarGzPath, metadata, results); err != nil {
+	if err := re.createBundleTarGz(tarGzPath, metadata, extras, results, opts.Checksums); err != nil {
 		return "", fmt.Errorf("failed to create bundle: %w", err)
 	}
 
 	// Create tar bundle
 	fmt.Printf("Creating bundle for %s...\n", opts.TargetPlatform)
-	bundlePath := generateFilename(repo, tag, fullSinceRef, outDir, false)
 
-	bundleGen := NewBundleGenerator(re.version)
-	if err := bundleGen.GenerateBundle(tarGzPath, bundlePath, opts.TargetPlatform, newRef); err != nil {
+	if err := generateBundle(re.version, tarGzPath, bundlePath, metadata.ImageRef, opts); err != nil {
 		return "", fmt.Errorf("failed to create bundle: %w", err)
 	}
 
@@ -327,8 +450,61 @@ func (re *RemoteExporter) ExportFromRegistry(ctx context.Context, newRef, sinceR
 	return bundlePath, nil
 }
 
+// bundlePaths returns the image.tar.gz and bundle paths of a v2 bundle in
+// outDir, named after its first image
+func bundlePaths(metadata bundle.Metadata, outDir string) (string, string) {
+	repo, tag := parseReference(metadata.ImageRef)
+	if refs := metadata.ImageRefs(); len(refs) > 1 {
+		// app-1.0+2more__since-none.tar holds app:1.0 and two more images
+		tag = fmt.Sprintf("%s+%dmore", tag, len(refs)-1)
+	}
+	if platforms := metadata.Platforms(); len(platforms) > 1 {
+		// app-1.0+2platforms__since-none.tar holds app:1.0 for two platforms
+		tag = fmt.Sprintf("%s+%dplatforms", tag, len(platforms))
+	}
+	return generateFilename(repo, tag, metadata.BaseRef, outDir, true), generateFilename(repo, tag, metadata.BaseRef, outDir, false)
+}
+
 // createBundleTarGz creates a tar.gz bundle with metadata and compressed blobs
-func (re *RemoteExporter) createBundleTarGz(outputPath string, metadata bundle.Metadata, downloadResults []remotedownload.DownloadResult) error {
+func (re *RemoteExporter) createBundleTarGz(outputPath string, metadata bundle.Metadata, extras []bundleEntry, downloadResults []remotedownload.DownloadResult, checksums []string) error {
+	digests := make([]string, 0, len(downloadResults))
+	for _, result := range downloadResults {
+		digests = append(digests, result.Digest)
+	}
+
+	return writeBlobBundle(outputPath, metadata, extras, digests, checksums, func(digest string) (io.ReadCloser, int64, error) {
+		// Get blob from cache
+		blobReader, err := re.blobDownloader.GetCachedBlobReader(digest)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read blob %s from cache: %w", digest, err)
+		}
+
+		// Get blob file info for size
+		meta, err := re.blobCache.GetMetadata(digest)
+		if err != nil {
+			blobReader.Close()
+			return nil, 0, fmt.Errorf("failed to get blob metadata: %w", err)
+		}
+
+		return blobReader, meta.Size, nil
+	})
+}
+
+// blobOpener opens a compressed blob by digest and reports its size
+type blobOpener func(digest string) (io.ReadCloser, int64, error)
+
+// writeBlobBundle writes a v2 tar.gz containing metadata.json followed by
+// each blob stored as blobs/<algorithm>/<hex>. The checksums of further
+// algorithms are recorded in the metadata first, which reads every blob
+// once more.
+func writeBlobBundle(outputPath string, metadata bundle.Metadata, extras []bundleEntry, digests, checksums []string, open blobOpener) error {
+	if len(checksums) > 0 {
+		fmt.Printf("Recording %s checksums...\n", strings.Join(checksums, ", "))
+		if err := recordChecksums(&metadata, digests, checksums, open); err != nil {
+			return err
+		}
+	}
+
 	// Create output file
 	outFile, err := os.Create(outputPath)
 	if err != nil {
@@ -336,8 +512,26 @@ func (re *RemoteExporter) createBundleTarGz(outputPath string, metadata bundle.M
 	}
 	defer outFile.Close()
 
+	if err := writeBlobStream(outFile, metadata, extras, digests, open, os.Stderr); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "\nBundle created successfully\n")
+	return nil
+}
+
+// WriteBundleData writes the image data of a v2 bundle to w: metadata.json
+// followed by the blobs of digests, read through open. imgcd load and
+// imgcd inspect accept the stream on its own, as an image.tar.gz bundle.
+func WriteBundleData(w io.Writer, metadata bundle.Metadata, digests []string, open func(digest string) (io.ReadCloser, int64, error)) error {
+	return writeBlobStream(w, metadata, nil, digests, open, nil)
+}
+
+// writeBlobStream writes the tar.gz of a v2 bundle, reporting every packed
+// blob to progress unless it is nil
+func writeBlobStream(w io.Writer, metadata bundle.Metadata, extras []bundleEntry, digests []string, open blobOpener, progress io.Writer) error {
 	// Create pgzip writer for parallel compression
-	gzw := pgzip.NewWriter(outFile)
+	gzw := pgzip.NewWriter(w)
 	defer gzw.Close()
 
 	// Create tar writer
@@ -345,64 +539,54 @@ func (re *RemoteExporter) createBundleTarGz(outputPath string, metadata bundle.M
 	defer tw.Close()
 
 	// Write metadata.json
-	metaBytes, err := json.MarshalIndent(metadata, "", "  ")
-	if err != nil {
+	if err := writeMetadata(tw, "metadata.json", metadata, extras); err != nil {
 		return err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: "metadata.json",
-		Mode: 0644,
-		Size: int64(len(metaBytes)),
-	}); err != nil {
-		return err
-	}
-	if _, err := tw.Write(metaBytes); err != nil {
-		return err
-	}
-
-	// Write each blob to the tar
-	for i, result := range downloadResults {
-		// Get blob from cache
-		blobReader, err := re.blobDownloader.GetCachedBlobReader(result.Digest)
-		if err != nil {
-			return fmt.Errorf("failed to read blob %s from cache: %w", result.Digest, err)
+	// Write each blob to the tar (a layer repeated in the chain is stored once)
+	written := make(map[string]bool)
+	for i, digest := range digests {
+		if written[digest] {
+			continue
 		}
-		defer blobReader.Close()
+		written[digest] = true
 
-		// Get blob file info for size
-		meta, err := re.blobCache.GetMetadata(result.Digest)
+		blobReader, size, err := open(digest)
 		if err != nil {
-			return fmt.Errorf("failed to get blob metadata: %w", err)
+			return err
 		}
 
-		// Write blob to tar as blobs/sha256/{hash}
-		hash := strings.TrimPrefix(result.Digest, "sha256:")
-		blobPath := filepath.Join("blobs", "sha256", hash)
-
-		if err := tw.WriteHeader(&tar.Header{
-			Name: blobPath,
-			Mode: 0644,
-			Size: meta.Size,
-		}); err != nil {
+		if err := tw.WriteHeader(fileHeader(bundle.BlobPath(digest), 0644, size)); err != nil {
+			blobReader.Close()
 			return err
 		}
 
 		// Copy blob content
 		written, err := io.Copy(tw, blobReader)
+		closeErr := blobReader.Close()
 		if err != nil {
 			return fmt.Errorf("failed to write blob to tar: %w", err)
 		}
+		if closeErr != nil {
+			return closeErr
+		}
 
-		fmt.Fprintf(os.Stderr, "Packed blob %d/%d (%s, %d bytes)\r", i+1, len(downloadResults), result.Digest[:19], written)
+		if progress != nil {
+			fmt.Fprintf(progress, "Packed blob %d/%d (%s, %d bytes)\r", i+1, len(digests), digest[:19], written)
+		}
 	}
 
-	fmt.Fprintf(os.Stderr, "\nBundle created successfully\n")
-	return nil
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
 }
 
 // fetchImage fetches an image from registry
-func (re *RemoteExporter) fetchImage(ctx context.Context, imageRef string, platform *v1.Platform) (v1.Image, error) {
+func fetchImage(ctx context.Context, imageRef string, platform *v1.Platform) (v1.Image, error) {
+	if isSkopeoDir(imageRef) {
+		return openSkopeoDir(imageRef, platform)
+	}
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse reference: %w", err)
@@ -422,6 +606,78 @@ func (re *RemoteExporter) fetchImage(ctx context.Context, imageRef string, platf
 	return desc.Image()
 }
 
+// describeLayers builds the layer infos of every layer of an image
+func describeLayers(layers []v1.Layer, manifest *v1.Manifest) ([]bundle.LayerInfo, error) {
+	var infos []bundle.LayerInfo
+	for i, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer DiffID: %w", err)
+		}
+
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer digest: %w", err)
+		}
+
+		size, _ := layer.Size()
+
+		mediaType := ""
+		if i < len(manifest.Layers) {
+			mediaType = string(manifest.Layers[i].MediaType)
+		}
+
+		infos = append(infos, bundle.LayerInfo{
+			Digest:    digest.String(),
+			DiffID:    diffID.String(),
+			Size:      size,
+			MediaType: mediaType,
+		})
+	}
+	return infos, nil
+}
+
+// uniqueLayers drops repeated layers so each blob is downloaded only once
+func uniqueLayers(layers []v1.Layer) ([]v1.Layer, error) {
+	seen := make(map[v1.Hash]bool)
+	var unique []v1.Layer
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer digest: %w", err)
+		}
+		if seen[digest] {
+			continue
+		}
+		seen[digest] = true
+		unique = append(unique, layer)
+	}
+	return unique, nil
+}
+
+// countDownloads prints how many blobs came from the cache and from peers,
+// and records the counts in stats unless it is nil
+func countDownloads(results []remotedownload.DownloadResult, stats *DownloadStats) {
+	counts := DownloadStats{Blobs: len(results)}
+	for _, result := range results {
+		if result.FromCache {
+			counts.CacheHits++
+		}
+		if result.Peer != "" {
+			counts.FromPeers++
+		}
+	}
+	if counts.CacheHits > 0 {
+		fmt.Printf("Cache hits: %d/%d blobs\n", counts.CacheHits, counts.Blobs)
+	}
+	if counts.FromPeers > 0 {
+		fmt.Printf("From peers: %d/%d blobs\n", counts.FromPeers, counts.Blobs)
+	}
+	if stats != nil {
+		*stats = counts
+	}
+}
+
 // calculateTotalSize calculates the total compressed size of all layers
 func calculateTotalSize(layers []bundle.LayerInfo) int64 {
 	var total int64
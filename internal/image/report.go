@@ -0,0 +1,151 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+)
+
+// Outcomes of an image in a LoadReport
+const (
+	StatusLoaded  = "loaded"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped" // Not tried, e.g. because its base failed
+)
+
+// ImageStatus is the outcome of loading one image of a delivery
+type ImageStatus struct {
+	Bundle   string  `json:"bundle"`
+	Image    string  `json:"image"` // Empty if the bundle could not be read
+	Platform string  `json:"platform,omitempty"`
+	Target   string  `json:"target,omitempty"` // Runtime, when loading into several
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// LoadReport collects the outcome of every image of one or several loads;
+// it is safe for concurrent use
+type LoadReport struct {
+	mu     sync.Mutex
+	images []ImageStatus
+}
+
+// Add records the outcome of an image
+func (r *LoadReport) Add(status ImageStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images = append(r.images, status)
+}
+
+// Images returns the outcomes in the order they were recorded
+func (r *LoadReport) Images() []ImageStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ImageStatus(nil), r.images...)
+}
+
+// Count returns the number of images with the given status
+func (r *LoadReport) Count(status string) int {
+	n := 0
+	for _, image := range r.Images() {
+		if image.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// addBundle records the images a loader went through, or one entry for the
+// whole bundle when it failed before reaching them or has no images of its
+// own to report (artifacts, legacy bundles). A nil report records nothing.
+func (r *LoadReport) addBundle(name string, bl *BundleLoader, err error, elapsed time.Duration) {
+	if r == nil {
+		return
+	}
+	var results []ImageStatus
+	if bl != nil {
+		results = bl.results
+	}
+	if len(results) == 0 {
+		status := ImageStatus{Status: StatusLoaded}
+		if bl != nil {
+			status.Image = bl.loaded
+		}
+		if err != nil {
+			status.Status, status.Error = StatusFailed, err.Error()
+		}
+		results = []ImageStatus{status}
+	}
+	// A single image took the whole load
+	if len(results) == 1 && results[0].Seconds == 0 {
+		results[0].Seconds = elapsed.Seconds()
+	}
+	for _, status := range results {
+		status.Bundle = name
+		r.Add(status)
+	}
+}
+
+// WriteTable prints one line per image
+func (r *LoadReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tIMAGE\tPLATFORM\tBUNDLE\tTIME\tERROR")
+	for _, image := range r.Images() {
+		platform := image.Platform
+		if image.Target != "" {
+			platform += " on " + image.Target
+		}
+		elapsed := "-"
+		if image.Status != StatusSkipped {
+			elapsed = humanize.Duration(time.Duration(image.Seconds * float64(time.Second)))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", image.Status, orDash(image.Image), orDash(platform), image.Bundle,
+			elapsed, orDash(firstLine(image.Error)))
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the report to path as JSON, with totals by status
+func (r *LoadReport) WriteJSON(path string) error {
+	images := r.Images()
+	report := struct {
+		Loaded  int           `json:"loaded"`
+		Failed  int           `json:"failed"`
+		Skipped int           `json:"skipped"`
+		Images  []ImageStatus `json:"images"`
+	}{r.Count(StatusLoaded), r.Count(StatusFailed), r.Count(StatusSkipped), images}
+	if report.Images == nil {
+		report.Images = []ImageStatus{}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode load report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write load report: %w", err)
+	}
+	return nil
+}
+
+// orDash shows empty table cells as -
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// firstLine keeps table rows to one line
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,108 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/so2liu/imgcd/internal/runtime"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// checkRunning warns about running containers of the repositories of a
+// bundle's images. Loading moves the tag: containers keep running the old
+// image, but whatever recreates them (compose up, a pod restart, a systemd
+// unit doing docker run) starts the new one. Containers on the exact tag block the import unless
+// opts.Force is set.
+func (i *Importer) checkRunning(ctx context.Context, imageRefs []string, opts LoadOptions) error {
+	// Artifact importers have no runtime, and artifacts do not run
+	if !opts.CheckRunning || i.runtime == nil {
+		return nil
+	}
+	out := opts.output()
+
+	lister, ok := i.runtime.(runtime.ContainerLister)
+	if !ok {
+		ui.Fwarning(out, "%s cannot list running containers, skipping the running-container check", i.runtime.Name())
+		return nil
+	}
+
+	containers, err := lister.RunningContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list running containers: %w", err)
+	}
+
+	var blocked []string
+	for _, imageRef := range imageRefs {
+		target, err := name.ParseReference(imageRef)
+		if err != nil {
+			return fmt.Errorf("invalid image reference %q in bundle: %w", imageRef, err)
+		}
+
+		sameTag, sameRepo := matchContainers(containers, target)
+		if len(sameTag) == 0 && len(sameRepo) == 0 {
+			fmt.Fprintf(out, "No running containers use the repository of %s\n", imageRef)
+			continue
+		}
+
+		if len(sameRepo) > 0 {
+			fmt.Fprintf(out, "Note: running containers use other tags of %s's repository (not affected by this load):\n", imageRef)
+			printContainers(out, sameRepo)
+		}
+		if len(sameTag) == 0 {
+			continue
+		}
+
+		ui.Fwarning(out, "%d running container(s) use %s, which this load retags:", len(sameTag), imageRef)
+		printContainers(out, sameTag)
+		fmt.Fprintf(out, "They keep running the current image, but will start the loaded one when recreated\n")
+		fmt.Fprintf(out, "(docker compose up, a pod restart, docker run in a restart script).\n")
+		blocked = append(blocked, fmt.Sprintf("%d running container(s) use %s", len(sameTag), imageRef))
+	}
+
+	if len(blocked) == 0 {
+		return nil
+	}
+	if !opts.Force {
+		return fmt.Errorf("%s; use --force to load anyway", strings.Join(blocked, ", "))
+	}
+	fmt.Fprintf(out, "Continuing because of --force\n")
+	return nil
+}
+
+// matchContainers splits containers into those started from target's tag
+// and those started from another tag or digest of the same repository
+func matchContainers(containers []runtime.Container, target name.Reference) (sameTag, sameRepo []runtime.Container) {
+	for _, c := range containers {
+		// Image IDs are shown once the tag was moved away, nothing to match
+		if c.Image == "" || strings.HasPrefix(c.Image, "sha256:") {
+			continue
+		}
+		ref, err := name.ParseReference(c.Image)
+		if err != nil || ref.Context().Name() != target.Context().Name() {
+			continue
+		}
+		if ref.Name() == target.Name() {
+			sameTag = append(sameTag, c)
+		} else {
+			sameRepo = append(sameRepo, c)
+		}
+	}
+	return sameTag, sameRepo
+}
+
+func printContainers(w io.Writer, containers []runtime.Container) {
+	for _, c := range containers {
+		id := c.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		if c.Name != "" && c.Name != c.ID {
+			fmt.Fprintf(w, "  - %s (%s) %s\n", c.Name, id, c.Image)
+		} else {
+			fmt.Fprintf(w, "  - %s %s\n", id, c.Image)
+		}
+	}
+}
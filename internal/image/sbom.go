@@ -0,0 +1,154 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+)
+
+// Names of the SBOM entry next to the metadata, by document format
+const (
+	sbomSPDXName      = "sbom.spdx.json"
+	sbomCycloneDXName = "sbom.cdx.json"
+)
+
+// sbomEntries returns the SBOM to store in the bundle: the file given with
+// --sbom, or one generated by syft for --generate-sbom
+func sbomEntries(opts ExportOptions, imageRef string) ([]bundleEntry, error) {
+	var data []byte
+	switch {
+	case opts.SBOM != "":
+		var err error
+		if data, err = os.ReadFile(opts.SBOM); err != nil {
+			return nil, fmt.Errorf("failed to read SBOM: %w", err)
+		}
+	case opts.GenerateSBOM:
+		var err error
+		if data, err = generateSBOM(imageRef, opts.TargetPlatform); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	name, err := sbomName(data)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Storing SBOM (%s)\n", name)
+	return []bundleEntry{{Name: name, Data: data}}, nil
+}
+
+// checkSBOM fails before an export whose SBOM could not be stored
+func checkSBOM(opts ExportOptions) error {
+	switch {
+	case opts.SBOM != "":
+		data, err := os.ReadFile(opts.SBOM)
+		if err != nil {
+			return fmt.Errorf("failed to read SBOM: %w", err)
+		}
+		_, err = sbomName(data)
+		return err
+	case opts.GenerateSBOM:
+		_, err := findSyft()
+		return err
+	}
+	return nil
+}
+
+// sbomName returns the entry name of an SPDX or CycloneDX JSON document
+func sbomName(data []byte) (string, error) {
+	var doc struct {
+		SPDXVersion string `json:"spdxVersion"`
+		BOMFormat   string `json:"bomFormat"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("SBOM is not a JSON document: %w", err)
+	}
+	switch {
+	case doc.SPDXVersion != "":
+		return sbomSPDXName, nil
+	case doc.BOMFormat == "CycloneDX":
+		return sbomCycloneDXName, nil
+	}
+	return "", fmt.Errorf("SBOM is neither SPDX JSON (spdxVersion) nor CycloneDX JSON (bomFormat)")
+}
+
+// sbomDigest identifies the SBOM of an export for the prepared-bundle key
+func sbomDigest(opts ExportOptions) (string, error) {
+	if opts.GenerateSBOM {
+		return "syft", nil
+	}
+	if opts.SBOM == "" {
+		return "", nil
+	}
+	return checksum.File(opts.SBOM)
+}
+
+// generateSBOM runs syft on the image, which it reads from the local
+// daemon if it is there and from the registry otherwise
+func generateSBOM(imageRef, platform string) ([]byte, error) {
+	syft, err := findSyft()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Generating SBOM of %s with syft...\n", imageRef)
+	var stdout bytes.Buffer
+	cmd := exec.Command(syft, imageRef, "--platform", platform, "-o", "spdx-json", "-q")
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to generate SBOM: syft: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func findSyft() (string, error) {
+	syft, err := exec.LookPath("syft")
+	if err != nil {
+		return "", fmt.Errorf("--generate-sbom needs syft (https://github.com/anchore/syft) in PATH, or pass an SBOM with --sbom")
+	}
+	return syft, nil
+}
+
+// isSBOMEntry reports whether an entry of the image data is the SBOM
+func isSBOMEntry(name string) bool {
+	return name == sbomSPDXName || name == sbomCycloneDXName
+}
+
+// ReadBundleSBOM returns the SBOM stored in a bundle of any format and the
+// name of its entry; the name is empty when the bundle carries none
+func ReadBundleSBOM(bundlePath string) (string, []byte, error) {
+	image, err := openBundleImage(bundlePath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer image.Close()
+
+	br, err := bundle.NewReader(image)
+	if err != nil {
+		return "", nil, err
+	}
+	defer br.Close()
+
+	for entry, err := range br.All() {
+		if err != nil {
+			return "", nil, err
+		}
+		if isSBOMEntry(entry.Header.Name) {
+			data, err := io.ReadAll(entry)
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to read %s: %w", entry.Header.Name, err)
+			}
+			return entry.Header.Name, data, nil
+		}
+	}
+	return "", nil, nil
+}
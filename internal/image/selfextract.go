@@ -0,0 +1,201 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/templates"
+)
+
+// payloadOffsetWidth is the fixed width of the rendered PAYLOAD_OFFSET value.
+// Padding it keeps the header length independent of the offset it contains.
+const payloadOffsetWidth = 20
+
+// selfExtractingPath returns the .sh path for a bundle tar path
+func selfExtractingPath(bundlePath string) string {
+	return strings.TrimSuffix(bundlePath, ".tar") + ".sh"
+}
+
+// GenerateSelfExtractor wraps a bundle tar into a self-extracting shell script.
+// The tar is appended raw after the script header, which records its offset,
+// size and sha256 so both the script and imgcd can locate and verify it.
+func (bg *BundleGenerator) GenerateSelfExtractor(bundlePath, outputPath, targetPlatform, imageName string) error {
+	fmt.Printf("Creating self-extracting bundle...\n")
+
+	out, err := createStaged(outputPath, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer out.Abort()
+
+	if err := bg.writeSelfExtractor(bg.teed(out, outputPath), bundlePath, targetPlatform, imageName); err != nil {
+		return err
+	}
+	return out.Commit()
+}
+
+// StreamSelfExtractor writes the self-extracting bundle GenerateSelfExtractor
+// would create to w
+func (bg *BundleGenerator) StreamSelfExtractor(w io.Writer, bundlePath, targetPlatform, imageName string) error {
+	fmt.Printf("Streaming self-extracting bundle...\n")
+	return bg.writeSelfExtractor(w, bundlePath, targetPlatform, imageName)
+}
+
+// writeSelfExtractor writes the shell header of a bundle followed by the
+// bundle itself
+func (bg *BundleGenerator) writeSelfExtractor(w io.Writer, bundlePath, targetPlatform, imageName string) error {
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return err
+	}
+	sum, err := checksum.File(bundlePath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum bundle: %w", err)
+	}
+
+	vars := map[string]string{
+		"TARGET_PLATFORM": targetPlatform,
+		"IMAGE_NAME":      imageName,
+		"IMGCD_VERSION":   bg.version,
+		"PAYLOAD_SIZE":    strconv.FormatInt(info.Size(), 10),
+		"PAYLOAD_SHA256":  sum,
+		"PAYLOAD_OFFSET":  "0",
+	}
+	header := renderSelfExtractor(vars)
+	vars["PAYLOAD_OFFSET"] = strconv.Itoa(len(header))
+	header = renderSelfExtractor(vars)
+
+	in, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return fmt.Errorf("failed to write payload: %w", err)
+	}
+	return nil
+}
+
+// renderSelfExtractor fills the template placeholders. Values end up inside
+// double quotes, so characters the shell would expand there are escaped.
+func renderSelfExtractor(vars map[string]string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+
+	pairs := make([]string, 0, len(vars)*2)
+	for key, value := range vars {
+		if key == "PAYLOAD_OFFSET" {
+			value = fmt.Sprintf("%-*s", payloadOffsetWidth, value)
+		}
+		pairs = append(pairs, "{{"+key+"}}", escape.Replace(value))
+	}
+	return strings.NewReplacer(pairs...).Replace(templates.SelfExtractor)
+}
+
+// selfExtractorMagic starts every self-extracting bundle
+const selfExtractorMagic = "#!/bin/sh\n# imgcd self-extracting bundle\n"
+
+// selfExtractorHeaderLimit bounds how much of a file is searched for the
+// header variables; they sit at the top of the script
+const selfExtractorHeaderLimit = 64 * 1024
+
+// errNotSelfExtractor reports a file without a self-extractor header
+var errNotSelfExtractor = errors.New("not a self-extracting bundle")
+
+// SelfExtractorHeader holds the variables recorded in a self-extractor script
+type SelfExtractorHeader struct {
+	TargetPlatform string
+	ImageName      string
+	Version        string
+	PayloadOffset  int64
+	PayloadSize    int64
+	PayloadSHA256  string
+}
+
+// ReadSelfExtractorHeader parses the header of a self-extracting bundle
+func ReadSelfExtractorHeader(path string) (*SelfExtractorHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, selfExtractorHeaderLimit)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return parseSelfExtractorHeader(buf[:n])
+}
+
+// parseSelfExtractorHeader parses the header at the start of buf
+func parseSelfExtractorHeader(buf []byte) (*SelfExtractorHeader, error) {
+	if !bytes.HasPrefix(buf, []byte(selfExtractorMagic)) {
+		return nil, errNotSelfExtractor
+	}
+
+	vars := make(map[string]string)
+	for _, line := range strings.Split(string(buf), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.ContainsAny(key, " \t#") {
+			continue
+		}
+		if _, seen := vars[key]; !seen {
+			vars[key] = unquoteShell(strings.TrimSpace(value))
+		}
+	}
+
+	header := &SelfExtractorHeader{
+		TargetPlatform: vars["TARGET_PLATFORM"],
+		ImageName:      vars["IMAGE_NAME"],
+		Version:        vars["IMGCD_VERSION"],
+		PayloadSHA256:  vars["PAYLOAD_SHA256"],
+	}
+	var err error
+	if header.PayloadOffset, err = strconv.ParseInt(vars["PAYLOAD_OFFSET"], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid PAYLOAD_OFFSET in bundle header: %w", err)
+	}
+	if header.PayloadSize, err = strconv.ParseInt(vars["PAYLOAD_SIZE"], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid PAYLOAD_SIZE in bundle header: %w", err)
+	}
+	if header.PayloadOffset < 0 || header.PayloadSize < 0 {
+		return nil, fmt.Errorf("invalid payload in bundle header: offset %d, size %d", header.PayloadOffset, header.PayloadSize)
+	}
+
+	return header, nil
+}
+
+// unquoteShell reverses the escaping of renderSelfExtractor
+func unquoteShell(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	value = value[1 : len(value)-1]
+
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		sb.WriteByte(value[i])
+	}
+	return sb.String()
+}
+
+// openPayloadImage returns the image.tar.gz stream inside the payload
+func (h *SelfExtractorHeader) openPayloadImage(path string) (io.ReadCloser, error) {
+	image, err := openTarEntryFile(path, h.PayloadOffset, h.PayloadSize, "image.tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("bundle payload: %w", err)
+	}
+	return image, nil
+}
@@ -0,0 +1,187 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/so2liu/imgcd/internal/runtime"
+	"github.com/so2liu/imgcd/internal/state"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// SelfTestRepo is the repository selftest loads its test image into, under
+// a tag of its own per run
+const SelfTestRepo = "imgcd-selftest"
+
+// selfTestSteps is the number of steps SelfTest prints
+const selfTestSteps = 5
+
+// SelfTestOptions configures SelfTest
+type SelfTestOptions struct {
+	Keep bool // Leave the test image in the runtime and the work directory on disk
+}
+
+// SelfTest runs a round trip in miniature on this host: it builds a
+// one-file image, saves it to a bundle with the running imgcd binary,
+// verifies the bundle, loads it into the detected runtime, checks the
+// runtime has it and removes it again. Nothing is downloaded, so it passes
+// on an air-gapped host with a working installation. The blob cache and
+// indexes are private to the run, so the user's are neither used nor
+// changed.
+func SelfTest(ctx context.Context, version string, opts SelfTestOptions) error {
+	rt, err := runtime.DetectRuntime()
+	if err != nil {
+		return fmt.Errorf("failed to detect runtime: %w", err)
+	}
+	defer rt.Close()
+
+	platform, err := runtime.PlatformOf(ctx, rt)
+	if err != nil {
+		return fmt.Errorf("failed to detect the platform of %s: %w", rt.Name(), err)
+	}
+	platform = ImagePlatform(platform)
+	fmt.Printf("Runtime: %s (%s)\n", rt.Name(), platform)
+
+	workDir, err := os.MkdirTemp("", "imgcd-selftest-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	if opts.Keep {
+		fmt.Printf("Work directory: %s\n", workDir)
+	} else {
+		defer os.RemoveAll(workDir)
+	}
+	defer state.Override(filepath.Join(workDir, "state"))()
+
+	// A tag of its own, so no image of the user's or of a concurrent run
+	// is replaced or removed
+	ref := SelfTestRepo + ":" + strings.TrimPrefix(filepath.Base(workDir), "imgcd-selftest-")
+
+	selfTestStep(1, "Building a test image for %s", platform)
+	img, err := selfTestImage(platform)
+	if err != nil {
+		return fmt.Errorf("failed to build the test image: %w", err)
+	}
+	imageDir := filepath.Join(workDir, "image")
+	if err := writeSkopeoDir(imageDir, img); err != nil {
+		return fmt.Errorf("failed to write the test image: %w", err)
+	}
+
+	selfTestStep(2, "Saving it to a bundle")
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+	exporter := &Exporter{runtime: rt, version: version}
+	result, err := exporter.Export(ctx, "dir:"+imageDir+":"+ref, "", filepath.Join(workDir, "out"), ExportOptions{
+		TargetPlatform: platform,
+		UseCache:       true, // Remote mode packs blobs from the cache
+		Rebuild:        true,
+		Note:           "imgcd selftest",
+		binary:         &bundleBinary{path: binary, platform: detectCurrentPlatform()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save the test bundle: %w", err)
+	}
+
+	selfTestStep(3, "Verifying %s", filepath.Base(result.Path))
+	report, err := VerifyBundle(result.Path, VerifyOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to verify the test bundle: %w", err)
+	}
+	if !report.OK() {
+		return fmt.Errorf("the test bundle does not verify: %s", strings.Join(report.Problems, "; "))
+	}
+	ui.Success("Bundle verified (%d blobs)", report.Blobs)
+
+	selfTestStep(4, "Loading it into %s", rt.Name())
+	remover, canRemove := rt.(runtime.ImageRemover)
+	removed := false
+	if canRemove && !opts.Keep {
+		// Also when a later step fails or the load stops halfway
+		defer func() {
+			if !removed {
+				remover.RemoveImage(context.WithoutCancel(ctx), ref)
+			}
+		}()
+	}
+	importer := &Importer{runtime: rt}
+	if _, err := importer.ImportFile(ctx, result.Path, LoadOptions{}); err != nil {
+		return fmt.Errorf("failed to load the test bundle: %w", err)
+	}
+	if _, err := rt.GetImage(ctx, ref); err != nil {
+		return fmt.Errorf("%s does not list %s after loading it: %w", rt.Name(), ref, err)
+	}
+
+	selfTestStep(5, "Cleaning up")
+	switch {
+	case opts.Keep:
+		fmt.Printf("Keeping %s in %s\n", ref, rt.Name())
+	case !canRemove:
+		ui.Warning("the %s runtime cannot remove images; remove %s by hand", rt.Name(), ref)
+	default:
+		removed = true
+		if err := remover.RemoveImage(ctx, ref); err != nil {
+			return fmt.Errorf("failed to remove the test image: %w", err)
+		}
+		fmt.Printf("Removed %s from %s\n", ref, rt.Name())
+	}
+	return nil
+}
+
+// selfTestStep prints the heading of a step
+func selfTestStep(n int, format string, args ...any) {
+	fmt.Printf("\n[%d/%d] %s\n", n, selfTestSteps, fmt.Sprintf(format, args...))
+}
+
+// selfTestImage builds the test image: one layer with a single small file
+func selfTestImage(platform string) (v1.Image, error) {
+	p, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse platform: %w", err)
+	}
+
+	content := []byte("imgcd selftest\n")
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(fileHeader("imgcd-selftest", 0644, int64(len(content)))); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		return nil, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config = config.DeepCopy()
+	config.OS = p.OS
+	config.Architecture = p.Architecture
+	config.Variant = p.Variant
+	config.Config.Labels = map[string]string{"org.opencontainers.image.title": "imgcd selftest"}
+	return mutate.ConfigFile(img, config)
+}
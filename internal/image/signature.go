@@ -0,0 +1,136 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// maxSignatureSize bounds how much of a signature.json is read
+const maxSignatureSize = 1 << 20
+
+// bundleSigner hashes the entries of a bundle tar as they are written and
+// signs them into a final signature.json, so the signature travels inside
+// the bundle: through streams, .sh wrappers and copies that lose sidecars
+type bundleSigner struct {
+	keyPath string
+	entries []bundle.SignedEntry
+}
+
+// addFile is addFileToTar, recording the digest of the file
+func (s *bundleSigner) addFile(tw *tar.Writer, filePath, tarPath string, mode int64) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(fileHeader(tarPath, mode, info.Size())); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), file); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, bundle.SignedEntry{Name: tarPath, Digest: "sha256:" + hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+// writeSignature signs the entries written so far into signature.json
+func (s *bundleSigner) writeSignature(tw *tar.Writer) error {
+	payload, err := bundle.SignaturePayload(s.entries)
+	if err != nil {
+		return err
+	}
+	sig, err := checksum.SignData(payload, s.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to sign bundle: %w", err)
+	}
+	data, err := json.MarshalIndent(bundle.Signature{Payload: payload, Signature: sig}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(fileHeader(bundle.SignatureName, 0644, int64(len(data)))); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// signatureCheck verifies the embedded signature of a bundle read as a
+// stream: the digests of the entries before signature.json are taken while
+// they are read, and checked once it is reached
+type signatureCheck struct {
+	keyPath  string
+	required bool
+	entries  []bundle.SignedEntry
+	current  *bundle.SignedEntry // Entry being hashed
+	hash     hash.Hash
+}
+
+// entry starts hashing an entry of the bundle tar and returns its reader
+func (c *signatureCheck) entry(name string, r io.Reader) io.Reader {
+	c.finish()
+	c.entries = append(c.entries, bundle.SignedEntry{Name: name})
+	c.current = &c.entries[len(c.entries)-1]
+	c.hash = sha256.New()
+	return io.TeeReader(r, c.hash)
+}
+
+// finish records the digest of the entry being hashed
+func (c *signatureCheck) finish() {
+	if c.current != nil {
+		c.current.Digest = "sha256:" + hex.EncodeToString(c.hash.Sum(nil))
+		c.current = nil
+	}
+}
+
+// verify checks signature.json against the entries read before it; data is
+// nil when the bundle has no signature
+func (c *signatureCheck) verify(out io.Writer, data []byte) error {
+	c.finish()
+	if data == nil {
+		if c.required {
+			return fmt.Errorf("bundle is not signed, but a signature is required")
+		}
+		ui.Fwarning(out, "bundle is not signed, its origin cannot be checked with %s", filepath.Base(c.keyPath))
+		return nil
+	}
+
+	signature, signed, err := bundle.ParseSignature(data)
+	if err != nil {
+		return err
+	}
+	if err := checksum.VerifyData(signature.Payload, signature.Signature, c.keyPath); err != nil {
+		return fmt.Errorf("bundle signature is invalid: %w", err)
+	}
+	if len(signed.Entries) != len(c.entries) {
+		return fmt.Errorf("bundle signature is invalid: it signs %d entries, the bundle has %d", len(signed.Entries), len(c.entries))
+	}
+	for i, entry := range signed.Entries {
+		actual := c.entries[i]
+		if actual.Name != entry.Name {
+			return fmt.Errorf("bundle signature is invalid: entry %d is %s, the signature lists %s", i+1, actual.Name, entry.Name)
+		}
+		if actual.Digest != entry.Digest {
+			return fmt.Errorf("bundle signature is invalid: %s was modified (signed %s, is %s)", entry.Name, entry.Digest, actual.Digest)
+		}
+	}
+	ui.Fsuccess(out, "Signature verified (%s)", filepath.Base(c.keyPath))
+	return nil
+}
@@ -0,0 +1,105 @@
+package image
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/runtime"
+)
+
+// readBaseBundle reads the image of an earlier bundle that an export of
+// newRef for platform is based on (--since-bundle): an image of the same
+// repository if the bundle holds several, in the variant for platform. Its
+// metadata records the complete config and manifest, also of incremental
+// bundles, which is all an export needs of its base.
+func readBaseBundle(path, newRef, platform string) (*bundle.Metadata, error) {
+	meta, err := readBundleMetadata(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --since-bundle %s: %w", filepath.Base(path), err)
+	}
+	if meta.Artifact != nil {
+		return nil, fmt.Errorf("--since-bundle %s holds an OCI artifact, not an image", filepath.Base(path))
+	}
+
+	images := meta.PerImage()
+	repo, _ := parseReference(imageName(newRef))
+	var sameRepo []*bundle.Metadata
+	for _, m := range images {
+		if baseRepo, _ := parseReference(m.ImageRef); baseRepo == repo {
+			sameRepo = append(sameRepo, m)
+		}
+	}
+	if len(sameRepo) > 0 {
+		images = sameRepo
+	}
+
+	var available, refs []string
+	for _, m := range images {
+		available = append(available, fmt.Sprintf("%s (%s)", m.ImageRef, m.Platform))
+		if !slices.Contains(refs, m.ImageRef) {
+			refs = append(refs, m.ImageRef)
+		}
+	}
+	if len(refs) > 1 {
+		return nil, fmt.Errorf("--since-bundle %s holds several images %s could be based on: %s", filepath.Base(path), newRef, strings.Join(available, ", "))
+	}
+	base := matchVariant(images, platform)
+	if base == nil {
+		return nil, fmt.Errorf("--since-bundle %s has no %s image: %s", filepath.Base(path), platform, strings.Join(available, ", "))
+	}
+	if base.Config == nil || base.Manifest == nil {
+		return nil, fmt.Errorf("--since-bundle %s records no config of %s", filepath.Base(path), base.ImageRef)
+	}
+	return base, nil
+}
+
+// readSinceFile reads the base of --since-bundle or --since-layers into
+// opts.sinceBundle and returns its reference, the sinceRef of the export
+func readSinceFile(newRef, sinceRef string, opts *ExportOptions) (string, error) {
+	var base *bundle.Metadata
+	var err error
+	switch {
+	case opts.SinceBundle != "" && opts.SinceLayers != "":
+		return "", fmt.Errorf("--since-bundle and --since-layers cannot be used together")
+	case opts.SinceBundle != "":
+		if sinceRef != "" {
+			return "", fmt.Errorf("--since and --since-bundle cannot be used together")
+		}
+		base, err = readBaseBundle(opts.SinceBundle, newRef, opts.TargetPlatform)
+	case opts.SinceLayers != "":
+		base, err = readBaseLayers(opts.SinceLayers, newRef, sinceRef)
+	default:
+		return sinceRef, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	opts.sinceBundle = base
+	return base.ImageRef, nil
+}
+
+// sinceFile is the file the base was read from, SinceBundle or SinceLayers
+func (opts ExportOptions) sinceFile() string {
+	if opts.SinceBundle != "" {
+		return opts.SinceBundle
+	}
+	return opts.SinceLayers
+}
+
+// bundleImageInfo describes the base image of a bundle the way a runtime
+// does, for local-mode exports: layers by DiffID and the config digest,
+// which is what docker reports as the image ID
+func bundleImageInfo(base *bundle.Metadata) *runtime.ImageInfo {
+	info := &runtime.ImageInfo{
+		Reference: base.ImageRef,
+		ID:        base.Manifest.Config.Digest.String(),
+		Platform:  base.Platform,
+	}
+	for _, diffID := range base.Config.RootFS.DiffIDs {
+		info.Layers = append(info.Layers, runtime.LayerInfo{Digest: diffID.String()})
+	}
+	return info
+}
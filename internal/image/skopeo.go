@@ -0,0 +1,256 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// skopeoDirPrefix marks an image reference as a directory in the layout of
+// skopeo's dir: transport, written as dir:PATH:NAME. NAME is the image the
+// bundle records, since the directory itself does not name its image.
+const skopeoDirPrefix = "dir:"
+
+// skopeoDirVersion is the version file skopeo writes into every directory
+const skopeoDirVersion = "Directory Transport Version: 1.1\n"
+
+// isSkopeoDir reports whether ref is a dir:PATH:NAME reference
+func isSkopeoDir(ref string) bool {
+	return strings.HasPrefix(ref, skopeoDirPrefix)
+}
+
+// parseSkopeoDir splits a dir:PATH:NAME reference; the path ends at the
+// first colon, as in the references of skopeo's oci: transport
+func parseSkopeoDir(ref string) (string, string) {
+	path, name, _ := strings.Cut(strings.TrimPrefix(ref, skopeoDirPrefix), ":")
+	return path, name
+}
+
+// imageName returns the image a reference names: NAME for dir:PATH:NAME,
+// ref itself otherwise
+func imageName(ref string) string {
+	if !isSkopeoDir(ref) {
+		return ref
+	}
+	_, name := parseSkopeoDir(ref)
+	return name
+}
+
+// openSkopeoDir reads the image of a dir:PATH:NAME reference. A directory
+// holding a manifest list (skopeo copy --all) gives the image of platform.
+func openSkopeoDir(ref string, platform *v1.Platform) (v1.Image, error) {
+	dir, name := parseSkopeoDir(ref)
+	if name == "" {
+		return nil, fmt.Errorf("%s does not name its image; use dir:PATH:NAME, e.g. dir:%s:registry.example.com/app:1.0", ref, dir)
+	}
+	version, err := os.ReadFile(filepath.Join(dir, "version"))
+	if err != nil || !strings.HasPrefix(string(version), "Directory Transport Version: ") {
+		return nil, fmt.Errorf("%s is not a directory written by skopeo's dir: transport (no version file)", dir)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	media, err := manifestMediaType(raw)
+	if err != nil {
+		return nil, err
+	}
+	if media.IsIndex() {
+		index, err := v1.ParseIndexManifest(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifest list: %w", err)
+		}
+		var found *v1.Descriptor
+		for i, desc := range index.Manifests {
+			if desc.Platform != nil && desc.Platform.Satisfies(*platform) {
+				found = &index.Manifests[i]
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("the manifest list in %s has no %s image", dir, platform)
+		}
+		raw, err = os.ReadFile(filepath.Join(dir, found.Digest.Hex+".manifest.json"))
+		if err != nil {
+			return nil, fmt.Errorf("the %s manifest is missing from %s (copy with skopeo copy --all): %w", platform, dir, err)
+		}
+		if media, err = manifestMediaType(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return partial.CompressedToImage(&skopeoImage{dir: dir, manifest: manifest, raw: raw, media: media})
+}
+
+// manifestMediaType returns the media type of a manifest, which OCI
+// manifests may leave out
+func manifestMediaType(raw []byte) (types.MediaType, error) {
+	var probe struct {
+		MediaType types.MediaType `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+		Config    struct {
+			MediaType types.MediaType `json:"mediaType"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return "", fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	switch {
+	case probe.MediaType != "":
+		return probe.MediaType, nil
+	case probe.Manifests != nil:
+		return types.OCIImageIndex, nil
+	case probe.Config.MediaType == types.DockerConfigJSON:
+		return types.DockerManifestSchema2, nil
+	}
+	return types.OCIManifestSchema1, nil
+}
+
+// skopeoBlobPath is the file of a blob in a skopeo directory: the hex of a
+// sha256 digest, the whole digest for other algorithms
+func skopeoBlobPath(dir string, digest v1.Hash) string {
+	if digest.Algorithm == "sha256" {
+		return filepath.Join(dir, digest.Hex)
+	}
+	return filepath.Join(dir, digest.String())
+}
+
+// skopeoImage is the image of a skopeo directory
+type skopeoImage struct {
+	dir      string
+	manifest *v1.Manifest
+	raw      []byte
+	media    types.MediaType
+}
+
+func (s *skopeoImage) RawConfigFile() ([]byte, error) {
+	return os.ReadFile(skopeoBlobPath(s.dir, s.manifest.Config.Digest))
+}
+
+func (s *skopeoImage) MediaType() (types.MediaType, error) {
+	return s.media, nil
+}
+
+func (s *skopeoImage) RawManifest() ([]byte, error) {
+	return s.raw, nil
+}
+
+func (s *skopeoImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	for _, desc := range s.manifest.Layers {
+		if desc.Digest == h {
+			return &skopeoBlob{desc: desc, path: skopeoBlobPath(s.dir, h)}, nil
+		}
+	}
+	return nil, fmt.Errorf("layer %s is not in the manifest", h)
+}
+
+// skopeoBlob is a layer file of a skopeo directory
+type skopeoBlob struct {
+	desc v1.Descriptor
+	path string
+}
+
+func (l *skopeoBlob) Digest() (v1.Hash, error) {
+	return l.desc.Digest, nil
+}
+
+func (l *skopeoBlob) Size() (int64, error) {
+	return l.desc.Size, nil
+}
+
+func (l *skopeoBlob) MediaType() (types.MediaType, error) {
+	return l.desc.MediaType, nil
+}
+
+func (l *skopeoBlob) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+// writeSkopeoDir writes img into dir in the layout of skopeo's dir:
+// transport. Like skopeo, it replaces the content of an earlier skopeo
+// directory but refuses any other non-empty directory.
+func writeSkopeoDir(dir string, img v1.Image) error {
+	entries, err := os.ReadDir(dir)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case len(entries) > 0 && !fileExists(filepath.Join(dir, "version")):
+		return fmt.Errorf("%s is not empty and not a skopeo directory", dir)
+	default:
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "version"), []byte(skopeoDirVersion), 0644); err != nil {
+		return err
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return fmt.Errorf("failed to get layers: %w", err)
+	}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		if err := writeSkopeoBlob(skopeoBlobPath(dir, digest), layer); err != nil {
+			return fmt.Errorf("failed to write layer %s: %w", digest, err)
+		}
+	}
+
+	config, err := img.RawConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to get config: %w", err)
+	}
+	configName, err := img.ConfigName()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(skopeoBlobPath(dir, configName), config, 0644); err != nil {
+		return err
+	}
+	manifest, err := img.RawManifest()
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, "manifest.json"), manifest, 0644)
+}
+
+// writeSkopeoBlob copies the compressed content of a layer to path
+func writeSkopeoBlob(path string, layer v1.Layer) error {
+	compressed, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer compressed.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, compressed); err != nil {
+		return err
+	}
+	return file.Close()
+}
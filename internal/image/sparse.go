@@ -0,0 +1,66 @@
+package image
+
+import (
+	"io"
+	"os"
+)
+
+// sparseBlockSize is the granularity at which zero runs become holes; it
+// matches the block size of common filesystems
+const sparseBlockSize = 4096
+
+// copySparse copies src to dst, seeking over blocks that are entirely zero so
+// the filesystem leaves holes instead of allocating them. Layers holding
+// sparse files (databases, VM images) carry their empty regions as zeros, so
+// this keeps temp files close to the size of the actual data. Filesystems
+// without sparse support simply fill the holes with zeros.
+func copySparse(dst *os.File, src io.Reader) (int64, error) {
+	buf := make([]byte, 32*sparseBlockSize)
+	var written int64
+	pendingHole := false
+
+	for {
+		n, readErr := io.ReadFull(src, buf)
+		for offset := 0; offset < n; offset += sparseBlockSize {
+			block := buf[offset:min(offset+sparseBlockSize, n)]
+			if isZero(block) {
+				if _, err := dst.Seek(int64(len(block)), io.SeekCurrent); err != nil {
+					return written, err
+				}
+				pendingHole = true
+			} else {
+				if _, err := dst.Write(block); err != nil {
+					return written, err
+				}
+				pendingHole = false
+			}
+			written += int64(len(block))
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+
+	// A trailing hole is only materialized by setting the file size
+	if pendingHole {
+		if err := dst.Truncate(written); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// isZero reports whether every byte of b is zero
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
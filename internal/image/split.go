@@ -0,0 +1,287 @@
+package image
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/checksum"
+)
+
+// FAT32Limit is the largest file FAT32 holds, 4 GiB minus one byte;
+// --split-size 4G means this, so the parts fit on FAT32 media
+const FAT32Limit = 4<<30 - 1
+
+// partSuffix matches the suffix of a part of a split bundle
+var partSuffix = regexp.MustCompile(`\.part([0-9]+)$`)
+
+// PartPath returns the path of part n, from 1, of a split bundle
+func PartPath(bundlePath string, n int) string {
+	return fmt.Sprintf("%s.part%d", bundlePath, n)
+}
+
+// PartsSidecarPath returns the file that records the checksums of the parts
+// of a split bundle, in sha256sum format
+func PartsSidecarPath(bundlePath string) string {
+	return bundlePath + ".parts.sha256"
+}
+
+// SplitBundle splits a bundle into parts of at most size bytes next to it,
+// records their checksums in <bundle>.parts.sha256 and removes the bundle;
+// its .sha256 stays to check the joined file. A bundle no larger than size
+// is left as it is, and no parts are returned. tee, if set, receives a copy
+// of each part while it is written.
+func SplitBundle(bundlePath string, size int64, tee func(name string) io.Writer) ([]string, error) {
+	if size == 4<<30 {
+		size = FAT32Limit
+	}
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() <= size {
+		return nil, nil
+	}
+
+	bundle, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer bundle.Close()
+
+	var parts []string
+	var sums strings.Builder
+	for n := 1; int64(len(parts))*size < info.Size(); n++ {
+		path := PartPath(bundlePath, n)
+		var copyTo io.Writer
+		if tee != nil {
+			copyTo = tee(filepath.Base(path))
+		}
+		part, sum, err := writePart(path, io.LimitReader(bundle, size), info.Mode().Perm(), copyTo)
+		if err == nil && part == "" {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			removeFiles(parts)
+			return nil, fmt.Errorf("failed to write part %d: %w", n, err)
+		}
+		parts = append(parts, part)
+		fmt.Fprintf(&sums, "%s  %s\n", sum, filepath.Base(part))
+	}
+
+	if err := os.WriteFile(PartsSidecarPath(bundlePath), []byte(sums.String()), 0644); err != nil {
+		removeFiles(parts)
+		return nil, fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	bundle.Close()
+	if err := os.Remove(bundlePath); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// writePart writes a part through a staged file and returns its path and
+// hex sha256, or "" once r is exhausted. tee, if not nil, receives a copy.
+func writePart(path string, r io.Reader, perm os.FileMode, tee io.Writer) (string, string, error) {
+	file, err := createStaged(path, perm)
+	if err != nil {
+		return "", "", err
+	}
+	hasher := checksum.NewHasher()
+	defer hasher.Close()
+	w := io.MultiWriter(file, hasher)
+	if tee != nil {
+		w = io.MultiWriter(w, tee)
+	}
+	written, err := io.Copy(w, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil || written == 0 {
+		file.Abort()
+		return "", "", err
+	}
+	if err := file.Commit(); err != nil {
+		return "", "", err
+	}
+	return path, hasher.Sum(), nil
+}
+
+// removeFiles removes the parts written before a failure
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// JoinResult describes a joined bundle
+type JoinResult struct {
+	Path     string
+	Parts    int
+	Size     int64
+	Verified bool // The joined bundle matched the .sha256 of the bundle
+}
+
+// SplitBundleBase returns the bundle path of one of its parts, or the path
+// itself when it is not a part
+func SplitBundleBase(path string) string {
+	return partSuffix.ReplaceAllString(path, "")
+}
+
+// JoinBundle joins the parts of a split bundle into outDir. Each part is
+// checked against <bundle>.parts.sha256 while it is copied, and the result
+// against <bundle>.sha256, which is copied along when outDir is elsewhere.
+func JoinBundle(bundlePath, outDir string) (*JoinResult, error) {
+	parts, sums, err := bundleParts(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	target := filepath.Join(outDir, filepath.Base(bundlePath))
+	out, err := createStaged(target, info.Mode().Perm())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", target, err)
+	}
+	defer out.Abort()
+
+	whole := checksum.NewHasher()
+	defer whole.Close()
+	result := &JoinResult{Path: target, Parts: len(parts)}
+	for _, part := range parts {
+		fmt.Printf("Joining %s...\n", filepath.Base(part))
+		written, err := appendPart(io.MultiWriter(out, whole), part, sums[filepath.Base(part)])
+		if err != nil {
+			return nil, err
+		}
+		result.Size += written
+	}
+
+	expected, err := checksum.Recorded(bundlePath)
+	switch {
+	case errors.Is(err, checksum.ErrNoSidecar):
+	case err != nil:
+		return nil, err
+	case whole.Sum() != expected:
+		return nil, fmt.Errorf("checksum mismatch for the joined %s: expected %s, got %s", filepath.Base(bundlePath), expected, whole.Sum())
+	default:
+		result.Verified = true
+	}
+
+	if err := out.Sync(); err != nil {
+		return nil, err
+	}
+	if err := out.Commit(); err != nil {
+		return nil, err
+	}
+	if result.Verified {
+		if err := copySidecars(bundlePath, target); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// bundleParts returns the parts of a split bundle in order with their
+// recorded checksums. The parts sidecar names every part, so a missing one
+// is reported; without it the parts are .part1 up to the first gap.
+func bundleParts(bundlePath string) ([]string, map[string]string, error) {
+	sums := make(map[string]string)
+	var parts []string
+
+	file, err := os.Open(PartsSidecarPath(bundlePath))
+	switch {
+	case os.IsNotExist(err):
+		for n := 1; ; n++ {
+			if _, err := os.Stat(PartPath(bundlePath, n)); err != nil {
+				break
+			}
+			parts = append(parts, PartPath(bundlePath, n))
+		}
+	case err != nil:
+		return nil, nil, err
+	default:
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				continue
+			}
+			name := strings.TrimPrefix(fields[1], "*")
+			match := partSuffix.FindStringSubmatch(name)
+			if match == nil {
+				continue
+			}
+			if n, _ := strconv.Atoi(match[1]); n != len(parts)+1 {
+				return nil, nil, fmt.Errorf("malformed checksum file %s", PartsSidecarPath(bundlePath))
+			}
+			part := filepath.Join(filepath.Dir(bundlePath), name)
+			if _, err := os.Stat(part); err != nil {
+				return nil, nil, fmt.Errorf("part %s is missing", name)
+			}
+			parts = append(parts, part)
+			sums[name] = strings.ToLower(fields[0])
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, nil, fmt.Errorf("no parts of %s found (expected %s)", filepath.Base(bundlePath), filepath.Base(PartPath(bundlePath, 1)))
+	}
+	return parts, sums, nil
+}
+
+// appendPart copies a part to w, checking it against its recorded checksum
+// when there is one
+func appendPart(w io.Writer, part, expected string) (int64, error) {
+	file, err := os.Open(part)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	hasher := checksum.NewHasher()
+	defer hasher.Close()
+	written, err := io.Copy(io.MultiWriter(w, hasher), file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to join %s: %w", filepath.Base(part), err)
+	}
+	if expected != "" && hasher.Sum() != expected {
+		return 0, fmt.Errorf("part %s is corrupt: expected sha256 %s, got %s; copy it again", filepath.Base(part), expected, hasher.Sum())
+	}
+	return written, nil
+}
+
+// copySidecars copies the checksum file, and its signature if any, of a
+// bundle to the joined bundle when that is in another directory
+func copySidecars(bundlePath, target string) error {
+	if filepath.Clean(bundlePath) == filepath.Clean(target) {
+		return nil
+	}
+	sidecars := []string{checksum.SidecarPath(bundlePath)}
+	if checksum.HasSignature(bundlePath) {
+		sidecars = append(sidecars, checksum.SignaturePath(bundlePath))
+	}
+	for _, sidecar := range sidecars {
+		data, err := os.ReadFile(sidecar)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(filepath.Dir(target), filepath.Base(sidecar)), data, 0644); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", filepath.Base(sidecar), err)
+		}
+	}
+	return nil
+}
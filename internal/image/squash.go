@@ -0,0 +1,354 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/klauspost/pgzip"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// maxLayerDepth is the deepest layer chain docker's overlay2 driver accepts
+const maxLayerDepth = 125
+
+// Overlay whiteout markers used in layer tars
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// checkLayerDepth warns about images deeper than the runtime supports, or
+// squashes the excess layers when asked to. It returns the tar to load.
+func (bl *BundleLoader) checkLayerDepth(imageTarPath string, squash bool) (string, error) {
+	manifests, err := readImageTarManifest(imageTarPath)
+	if err != nil {
+		return "", err
+	}
+	if len(manifests) == 0 || len(manifests[0].Layers) <= maxLayerDepth {
+		return imageTarPath, nil
+	}
+
+	layerCount := len(manifests[0].Layers)
+	if !squash {
+		ui.Fwarning(bl.out, "image has %d layers, more than the %d supported by docker's overlay2 driver; use --squash-excess if loading fails",
+			layerCount, maxLayerDepth)
+		return imageTarPath, nil
+	}
+
+	fmt.Fprintf(bl.out, "Image has %d layers, squashing the top %d into one...\n", layerCount, layerCount-maxLayerDepth+1)
+	squashedPath := imageTarPath + ".squashed"
+	if err := bl.squashImageTar(imageTarPath, squashedPath, maxLayerDepth); err != nil {
+		os.Remove(squashedPath)
+		return "", fmt.Errorf("failed to squash layers: %w", err)
+	}
+	ui.Fwarning(bl.out, "squashed image has a different ID than the original")
+
+	return squashedPath, nil
+}
+
+// readImageTarManifest reads manifest.json from a docker image tar
+func readImageTarManifest(imageTarPath string) ([]dockerManifest, error) {
+	file, err := os.Open(imageTarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("manifest.json not found in image tar")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if path.Clean(header.Name) == "manifest.json" {
+			var manifests []dockerManifest
+			if err := json.NewDecoder(tr).Decode(&manifests); err != nil {
+				return nil, fmt.Errorf("failed to parse manifest.json: %w", err)
+			}
+			return manifests, nil
+		}
+	}
+}
+
+// squashImageTar rewrites a docker image tar so it has at most limit layers
+// by merging the topmost layers into one
+func (bl *BundleLoader) squashImageTar(srcPath, dstPath string, limit int) error {
+	workDir, err := os.MkdirTemp("", "imgcd-squash-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := bl.extractTarToDir(srcPath, workDir); err != nil {
+		return fmt.Errorf("failed to extract image: %w", err)
+	}
+
+	manifests, err := readImageTarManifest(srcPath)
+	if err != nil {
+		return err
+	}
+	config, layers, err := bl.parseBaseImage(workDir)
+	if err != nil {
+		return err
+	}
+	if len(config.RootFS.DiffIDs) != len(layers) {
+		return fmt.Errorf("config has %d DiffIDs but manifest has %d layers", len(config.RootFS.DiffIDs), len(layers))
+	}
+
+	// Merge everything from position limit-1 upwards
+	keep := limit - 1
+	var groupPaths []string
+	for _, layerPath := range layers[keep:] {
+		groupPaths = append(groupPaths, filepath.Join(workDir, layerPath))
+	}
+
+	squashedLayer := filepath.Join(workDir, "squashed.tar")
+	diffID, err := mergeLayers(groupPaths, squashedLayer)
+	if err != nil {
+		return err
+	}
+
+	squashHistory(config, len(layers), keep)
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs[:keep:keep], diffID)
+
+	// Write the new image tar
+	outFile, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	tw := tar.NewWriter(outFile)
+	defer tw.Close()
+
+	newLayers := make([]string, 0, limit)
+	written := make(map[string]bool)
+	for _, layerPath := range layers[:keep] {
+		newLayers = append(newLayers, layerPath)
+		if written[layerPath] {
+			continue
+		}
+		if err := bl.copyLayerToTar(tw, filepath.Join(workDir, layerPath), layerPath); err != nil {
+			return err
+		}
+		written[layerPath] = true
+	}
+
+	squashedPath := diffID.Hex[:12] + "/layer.tar"
+	if err := bl.copyLayerToTar(tw, squashedLayer, squashedPath); err != nil {
+		return err
+	}
+	newLayers = append(newLayers, squashedPath)
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	configSum := sha256.Sum256(configBytes)
+	configName := hex.EncodeToString(configSum[:]) + ".json"
+
+	manifest := []dockerManifest{{
+		Config:   configName,
+		RepoTags: manifests[0].RepoTags,
+		Layers:   newLayers,
+	}}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range []bundleEntry{
+		{Name: configName, Data: configBytes},
+		{Name: "manifest.json", Data: manifestBytes},
+	} {
+		if err := tw.WriteHeader(fileHeader(entry.Name, 0644, int64(len(entry.Data)))); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// squashHistory marks the history entries of squashed layers as empty so the
+// number of non-empty entries keeps matching the number of layers
+func squashHistory(config *v1.ConfigFile, layerCount, keep int) {
+	nonEmpty := 0
+	for _, entry := range config.History {
+		if !entry.EmptyLayer {
+			nonEmpty++
+		}
+	}
+	if nonEmpty != layerCount {
+		// History does not describe the layers (e.g., stripped by the builder)
+		return
+	}
+
+	index := 0
+	for i := range config.History {
+		if config.History[i].EmptyLayer {
+			continue
+		}
+		if index >= keep && index < layerCount-1 {
+			config.History[i].EmptyLayer = true
+		}
+		if index == layerCount-1 {
+			config.History[i].Comment = fmt.Sprintf("imgcd: squashed %d layers", layerCount-keep)
+		}
+		index++
+	}
+}
+
+// mergeLayers merges layer tars (bottom to top) into a single layer tar with
+// the same result when applied, and returns its DiffID. Entries are taken from
+// the topmost layer that has them; whiteouts hide paths of lower layers in the
+// group and are kept so they still apply to the layers below the group.
+func mergeLayers(layerPaths []string, outputPath string) (v1.Hash, error) {
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	defer outFile.Close()
+
+	hasher := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(outFile, hasher))
+
+	seen := make(map[string]bool)      // paths already provided by a higher layer
+	whiteouts := make(map[string]bool) // paths deleted by a higher layer
+	opaque := make(map[string]bool)    // directories whose lower contents were hidden
+	var links []*tar.Header            // hard links, written last so their targets exist
+
+	for i := len(layerPaths) - 1; i >= 0; i-- {
+		layerWhiteouts, layerOpaque, err := mergeLayer(tw, layerPaths[i], seen, whiteouts, opaque, &links)
+		if err != nil {
+			return v1.Hash{}, fmt.Errorf("failed to merge layer %s: %w", layerPaths[i], err)
+		}
+
+		// Whiteouts only apply to the layers below the one that has them
+		for p := range layerWhiteouts {
+			whiteouts[p] = true
+		}
+		for p := range layerOpaque {
+			opaque[p] = true
+		}
+	}
+
+	for _, link := range links {
+		link.Format = tar.FormatPAX
+		if err := tw.WriteHeader(link); err != nil {
+			return v1.Hash{}, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return v1.Hash{}, err
+	}
+
+	return v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+// mergeLayer copies the visible entries of one layer into tw
+func mergeLayer(tw *tar.Writer, layerPath string, seen, whiteouts, opaque map[string]bool, links *[]*tar.Header) (map[string]bool, map[string]bool, error) {
+	file, err := os.Open(layerPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	// docker save writes plain tars, other tools may write compressed layers
+	var layer io.Reader = bufio.NewReader(file)
+	if magic, _ := layer.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzr, err := pgzip.NewReader(layer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer gzr.Close()
+		layer = gzr
+	}
+
+	layerWhiteouts := make(map[string]bool)
+	layerOpaque := make(map[string]bool)
+
+	tr := tar.NewReader(layer)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := path.Clean("/" + header.Name)
+		if name == "/" || hiddenByUpper(name, whiteouts, opaque) || seen[name] {
+			continue
+		}
+
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			layerOpaque[path.Clean(dir)] = true
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			layerWhiteouts[target] = true
+			// A higher layer re-created the path, the whiteout is moot
+			if seen[target] {
+				continue
+			}
+		}
+		seen[name] = true
+
+		if header.Typeflag == tar.TypeLink {
+			*links = append(*links, header)
+			continue
+		}
+
+		// Source layers may use any format, keep large entries representable
+		header.Format = tar.FormatPAX
+		// The reader fills holes of sparse entries with zeros, which the writer
+		// cannot record as sparse again; store them as regular files and leave
+		// the zero runs to gzip
+		if header.Typeflag == tar.TypeGNUSparse {
+			header.Typeflag = tar.TypeReg
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, nil, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return layerWhiteouts, layerOpaque, nil
+}
+
+// hiddenByUpper reports whether a higher layer deleted name or made one of
+// its parent directories opaque
+func hiddenByUpper(name string, whiteouts, opaque map[string]bool) bool {
+	if whiteouts[name] {
+		return true
+	}
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if whiteouts[dir] || opaque[dir] {
+			return true
+		}
+	}
+	return opaque["/"]
+}
@@ -0,0 +1,71 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// stagingDir returns the directory for the temporary files of an export
+// writing to outDir. That is the system temp dir, unless it lies on another
+// filesystem than outDir and TMPDIR does not ask for it explicitly: then a
+// hidden directory in outDir is created, so large intermediates are not
+// written to one volume (often a small tmpfs) only to be copied to the
+// other. An empty result means the system temp dir; cleanup removes what
+// was created.
+func stagingDir(outDir string) (dir string, cleanup func(), err error) {
+	cleanup = func() {}
+	if os.Getenv("TMPDIR") != "" {
+		return "", cleanup, nil
+	}
+	tempDir := os.TempDir()
+	if same, known := sameFilesystem(tempDir, outDir); !known || same {
+		return "", cleanup, nil
+	}
+
+	dir, err = os.MkdirTemp(outDir, ".imgcd-staging-*")
+	if err != nil {
+		return "", cleanup, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	ui.Fwarning(os.Stdout, "%s is on a different filesystem than %s; staging temporary files in %s (set TMPDIR to override)",
+		tempDir, outDir, dir)
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// stagedFile is written next to its final path and renamed into place once
+// complete, so the rename never crosses filesystems and an interrupted
+// export never leaves a truncated bundle under the final name
+type stagedFile struct {
+	*os.File
+	target string
+}
+
+// createStaged creates path.partial for writing
+func createStaged(path string, perm os.FileMode) (*stagedFile, error) {
+	file, err := os.OpenFile(path+".partial", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &stagedFile{File: file, target: path}, nil
+}
+
+// Commit closes the file and moves it to its final path
+func (f *stagedFile) Commit() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if err := os.Rename(f.File.Name(), f.target); err != nil {
+		os.Remove(f.File.Name())
+		return fmt.Errorf("failed to move %s into place: %w", filepath.Base(f.target), err)
+	}
+	return nil
+}
+
+// Abort discards the file unless it was committed
+func (f *stagedFile) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
@@ -0,0 +1,272 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// ImportStream imports a bundle read once from r, e.g. stdin: a .tar or
+// self-extracting .sh bundle, or its image.tar.gz, told apart by their first
+// bytes. name says where the stream comes from. The checks of a load happen
+// while the stream is read: the running-container check once the metadata
+// was read, the payload checksum of a self-extracting bundle and
+// opts.SHA256 once the image data was, before anything is imported. Returns
+// the summary of the loaded bundle.
+func (i *Importer) ImportStream(ctx context.Context, r io.Reader, name string, opts LoadOptions) (*BundleSummary, error) {
+	loader, err := i.importStream(ctx, r, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return loader.summary, nil
+}
+
+// importStream runs ImportStream and returns the loader
+func (i *Importer) importStream(ctx context.Context, r io.Reader, name string, opts LoadOptions) (*BundleLoader, error) {
+	out := opts.output()
+	started := time.Now()
+	if i.runtime != nil {
+		fmt.Fprintf(out, "Using runtime: %s\n", i.runtime.Name())
+	}
+
+	stream := newBundleStream(r, opts.SHA256 != "")
+	defer stream.Close()
+	stream.checksums = opts.checksums
+	if opts.SignatureKey != "" {
+		stream.signature = &signatureCheck{keyPath: opts.SignatureKey, required: opts.RequireSignature}
+	}
+	image, err := stream.open(out, name)
+	if err != nil {
+		opts.Report.addBundle(name, nil, err, time.Since(started))
+		return nil, err
+	}
+
+	loader := NewBundleLoader(i.runtime)
+	loader.checkSummary = func(summary *BundleSummary) error {
+		// This imgcd can load the bundle, but its own imgcd and
+		// self-extractor were made for other hosts
+		if platform := summary.BinaryPlatform; platform != "" && !i.targets && platform != detectCurrentPlatform() {
+			ui.Fwarning(out, "bundle was made for %s hosts, this is %s: its bundled imgcd would not run here", platform, detectCurrentPlatform())
+		}
+		if summary.Artifact != "" {
+			return nil
+		}
+		return i.checkRunning(ctx, summary.ImageRefs(), opts)
+	}
+	loader.afterRead = func(sums []bundle.Checksum) error {
+		return stream.verify(out, opts.SHA256, sums)
+	}
+	err = loader.loadBundle(ctx, image, opts)
+	opts.Report.addBundle(name, loader, err, time.Since(started))
+	if err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// bundleStream reads a bundle from a stream, hashing all of it when its
+// sha256 is to be checked
+type bundleStream struct {
+	hasher *checksum.Hasher // nil when not hashing
+	buf    *bufio.Reader
+
+	// header and payload are set for self-extracting bundles; payload
+	// hashes what is read of the bundle tar after the script
+	header  *SelfExtractorHeader
+	payload io.Reader
+	payHash *checksum.Hasher
+
+	// tr and image are the bundle tar and its image.tar.gz; outer has the
+	// sha256 of the entries before it (the imgcd binary), checksums and
+	// signatureData the entries after it
+	tr            *tar.Reader
+	image         io.Reader
+	outer         map[string]string
+	checksums     []byte
+	signatureData []byte
+
+	// signature, when set, hashes the entries of the bundle tar to check its
+	// embedded signature
+	signature *signatureCheck
+}
+
+func newBundleStream(r io.Reader, hash bool) *bundleStream {
+	s := &bundleStream{}
+	if hash {
+		s.hasher = checksum.NewHasher()
+		r = io.TeeReader(r, s.hasher)
+	}
+	s.buf = bufio.NewReaderSize(r, selfExtractorHeaderLimit)
+	return s
+}
+
+// open detects the format of the stream and returns its image.tar.gz
+func (s *bundleStream) open(out io.Writer, name string) (io.Reader, error) {
+	start, err := s.buf.Peek(selfExtractorHeaderLimit)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read bundle from %s: %w", name, err)
+	}
+	if len(start) == 0 {
+		return nil, fmt.Errorf("no bundle on %s: the stream is empty", name)
+	}
+
+	if bytes.HasPrefix(start, []byte{0x1f, 0x8b}) {
+		fmt.Fprintf(out, "Loading bundle: %s\n", name)
+		return s.buf, nil
+	}
+
+	tarStream := io.Reader(s.buf)
+	header, err := parseSelfExtractorHeader(start)
+	switch {
+	case err == nil:
+		fmt.Fprintf(out, "Loading self-extracting bundle: %s\n", name)
+		fmt.Fprintf(out, "Created by imgcd %s for %s\n", header.Version, header.TargetPlatform)
+		if _, err := s.buf.Discard(int(header.PayloadOffset)); err != nil {
+			return nil, fmt.Errorf("bundle is truncated: %w", err)
+		}
+		s.header, s.payHash = header, checksum.NewHasher()
+		s.payload = io.TeeReader(io.LimitReader(s.buf, header.PayloadSize), s.payHash)
+		tarStream = s.payload
+	case errors.Is(err, errNotSelfExtractor):
+		fmt.Fprintf(out, "Loading bundle: %s\n", name)
+	default:
+		return nil, fmt.Errorf("failed to read bundle header: %w", err)
+	}
+
+	// The imgcd binary comes first in the bundle tar and is hashed for
+	// checksums.txt
+	tr := tar.NewReader(tarStream)
+	s.outer = make(map[string]string)
+	for {
+		entry, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("unrecognized bundle %s: image.tar.gz not found", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized bundle %s: %w", name, err)
+		}
+		name := bundle.CleanName(entry.Name)
+		content := io.Reader(tr)
+		if s.signature != nil {
+			content = s.signature.entry(name, tr)
+		}
+		if name == "image.tar.gz" {
+			s.tr, s.image = tr, content
+			return content, nil
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, content); err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		s.outer[name] = hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// Close stops hashing the stream
+func (s *bundleStream) Close() {
+	if s.hasher != nil {
+		s.hasher.Close()
+	}
+	if s.payHash != nil {
+		s.payHash.Close()
+	}
+}
+
+// verify checks the embedded signature, checksums.txt against the entries
+// of the bundle (sums are those of the image data's), the payload of a
+// self-extracting bundle and, if given, the sha256 of the whole stream,
+// reading what is left of it
+func (s *bundleStream) verify(out io.Writer, expected string, sums []bundle.Checksum) error {
+	if s.tr != nil {
+		if err := s.readTrailer(); err != nil {
+			return err
+		}
+	}
+	if s.signature != nil {
+		if err := s.signature.verify(out, s.signatureData); err != nil {
+			return err
+		}
+	}
+	if err := verifyChecksums(out, s.checksums, s.outer, sums); err != nil {
+		return err
+	}
+
+	if s.header != nil {
+		// A truncated stream ends the payload early and fails the checksum
+		if _, err := io.Copy(io.Discard, s.payload); err != nil {
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if actual := s.payHash.Sum(); actual != s.header.PayloadSHA256 {
+			return fmt.Errorf("bundle checksum mismatch: expected %s, got %s", s.header.PayloadSHA256, actual)
+		}
+		fmt.Fprintf(out, "Payload checksum verified\n")
+	}
+
+	if s.hasher == nil {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, s.buf); err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	sum := s.hasher.Sum()
+	if expected = strings.ToLower(strings.TrimPrefix(expected, "sha256:")); sum != expected {
+		return fmt.Errorf("bundle checksum mismatch: expected %s, got %s", expected, sum)
+	}
+	ui.Fsuccess(out, "Checksum verified (sha256:%s)", sum[:12])
+	return nil
+}
+
+// readTrailer reads the bundle tar after image.tar.gz, up to its
+// signature.json: the entries there are hashed for the signature, and
+// checksums.txt is kept
+func (s *bundleStream) readTrailer() error {
+	if _, err := io.Copy(io.Discard, s.image); err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	for {
+		entry, err := s.tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+		name := bundle.CleanName(entry.Name)
+		if name == bundle.SignatureName {
+			data, err := io.ReadAll(io.LimitReader(s.tr, maxSignatureSize))
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", bundle.SignatureName, err)
+			}
+			s.signatureData = data
+			return nil
+		}
+
+		content := io.Reader(s.tr)
+		if s.signature != nil {
+			content = s.signature.entry(name, s.tr)
+		}
+		if name == bundle.ChecksumsName {
+			data, err := io.ReadAll(io.LimitReader(content, maxChecksumsSize))
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", bundle.ChecksumsName, err)
+			}
+			s.checksums = data
+			continue
+		}
+		if _, err := io.Copy(io.Discard, content); err != nil {
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+	}
+}
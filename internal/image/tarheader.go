@@ -0,0 +1,16 @@
+package image
+
+import "archive/tar"
+
+// fileHeader returns the tar header for a regular file. Entries are written
+// as PAX so files over the 8 GiB ustar size limit (e.g., model layers) get an
+// exact size record instead of depending on the writer's format guess.
+func fileHeader(name string, mode, size int64) *tar.Header {
+	return &tar.Header{
+		Name:     name,
+		Mode:     mode,
+		Size:     size,
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatPAX,
+	}
+}
@@ -0,0 +1,264 @@
+#!/bin/sh
+# imgcd self-extracting bundle
+# This script contains an embedded imgcd binary and container image data
+# Generated by imgcd - https://github.com/so2liu/imgcd
+#
+# Strictly POSIX sh: embedded targets often only ship busybox ash or dash,
+# so no bashisms (local, echo -e, read -p, [[ ]], arrays, $'...').
+# scripts/test-self-extractor.sh runs it under every shell it can find.
+
+set -e
+
+# Metadata (will be replaced during generation)
+TARGET_PLATFORM="linux/amd64"
+IMAGE_NAME="127.0.0.1:5999/app/web:1.0"
+IMGCD_VERSION="dev"
+
+# Payload location: the bundle tar (imgcd binary, image.tar.gz and the
+# checksums.txt of both) is appended
+# raw right after this script, PAYLOAD_OFFSET bytes from the start of the file.
+# imgcd reads these lines too, keep them one assignment per line.
+PAYLOAD_OFFSET=8370                
+PAYLOAD_SIZE=7168
+PAYLOAD_SHA256="6b563c4ef9bbed35ab98f83582aa8aba73c2e6ea6de2991bb43be1bdd68ebc27"
+
+# Colors for output, only on terminals
+if [ -t 1 ]; then
+    ESC=$(printf '\033')
+    RED="${ESC}[0;31m"
+    GREEN="${ESC}[0;32m"
+    YELLOW="${ESC}[1;33m"
+    NC="${ESC}[0m" # No Color
+else
+    RED=''
+    GREEN=''
+    YELLOW=''
+    NC=''
+fi
+
+# Print a colored message
+say() {
+    printf '%s%s%s\n' "$1" "$2" "$NC"
+}
+
+# Detect current platform
+detect_platform() {
+    detect_os=$(uname -s | tr '[:upper:]' '[:lower:]')
+    detect_arch=$(uname -m)
+
+    case "$detect_arch" in
+        x86_64|amd64)
+            detect_arch="amd64"
+            ;;
+        aarch64|arm64)
+            detect_arch="arm64"
+            ;;
+        *)
+            say "$RED" "Error: Unsupported architecture: $detect_arch" >&2
+            exit 1
+            ;;
+    esac
+
+    echo "${detect_os}/${detect_arch}"
+}
+
+# Write the payload to stdout; tail -c is POSIX and binary safe
+read_payload() {
+    tail -c +$((PAYLOAD_OFFSET + 1)) "$0"
+}
+
+# Print the sha256 of stdin with whatever tool the system has
+sha256_stdin() {
+    if command -v sha256sum >/dev/null 2>&1; then
+        sha256sum | awk '{ print $1 }'
+    elif command -v shasum >/dev/null 2>&1; then
+        shasum -a 256 | awk '{ print $1 }'
+    elif command -v openssl >/dev/null 2>&1; then
+        openssl dgst -sha256 | awk '{ print $NF }'
+    fi
+}
+
+# Check that the payload is complete and unmodified
+verify_payload() {
+    actual_size=$(( $(wc -c < "$0") - PAYLOAD_OFFSET ))
+    if [ "$actual_size" -ne "$PAYLOAD_SIZE" ]; then
+        say "$RED" "Error: bundle is truncated or modified: payload is $actual_size bytes, expected $PAYLOAD_SIZE." >&2
+        echo "  Copy the bundle again and compare its checksum with the original." >&2
+        exit 1
+    fi
+
+    actual_sha256=$(read_payload | sha256_stdin)
+    if [ -z "$actual_sha256" ]; then
+        say "$YELLOW" "Warning: no sha256 tool found (sha256sum, shasum, openssl), skipping checksum verification"
+        return 0
+    fi
+    if [ "$actual_sha256" != "$PAYLOAD_SHA256" ]; then
+        say "$RED" "Error: bundle checksum mismatch, the payload is corrupted." >&2
+        echo "  Expected: $PAYLOAD_SHA256" >&2
+        echo "  Actual:   $actual_sha256" >&2
+        exit 1
+    fi
+    echo "Payload checksum verified"
+}
+
+# Check the unpacked imgcd binary against checksums.txt before running it;
+# imgcd load checks image.tar.gz against the rest of the file
+verify_binary() {
+    [ -f "$TEMP_DIR/checksums.txt" ] || return 0
+    expected_sha256=$(awk '$2 == "imgcd" || $2 == "*imgcd" { print $1 }' "$TEMP_DIR/checksums.txt")
+    [ -n "$expected_sha256" ] || return 0
+
+    actual_sha256=$(sha256_stdin < "$IMGCD_BIN")
+    # Without a sha256 tool verify_payload already warned
+    [ -n "$actual_sha256" ] || return 0
+    if [ "$actual_sha256" != "$expected_sha256" ]; then
+        say "$RED" "Error: the unpacked imgcd binary is corrupted, check the space in ${EXTRACT_BASE}." >&2
+        echo "  Expected: $expected_sha256" >&2
+        echo "  Actual:   $actual_sha256" >&2
+        exit 1
+    fi
+}
+
+# Check that a container runtime imgcd can load into is usable
+check_runtime() {
+    if command -v docker >/dev/null 2>&1; then
+        if docker version >/dev/null 2>&1; then
+            echo "Container runtime: docker"
+            return 0
+        fi
+        say "$RED" "Error: docker is installed but the daemon is not reachable." >&2
+        echo "  Start it (e.g. 'sudo systemctl start docker') and make sure this user may use it" >&2
+        echo "  (member of the 'docker' group, or run this bundle with sudo)." >&2
+        exit 1
+    fi
+
+    if command -v ctr >/dev/null 2>&1; then
+        if ctr version >/dev/null 2>&1; then
+            echo "Container runtime: containerd"
+            return 0
+        fi
+        say "$RED" "Error: ctr is installed but containerd is not reachable." >&2
+        echo "  Start containerd (e.g. 'sudo systemctl start containerd') or run this bundle with sudo." >&2
+        exit 1
+    fi
+
+    say "$RED" "Error: no supported container runtime found." >&2
+    echo "  Install docker or containerd (ctr) on this machine, then run this bundle again." >&2
+    exit 1
+}
+
+# Check that the extraction directory can hold the decoded payload
+check_disk_space() {
+    # The unpacked payload is as large as the embedded one, plus some slack
+    need_kb=$(( PAYLOAD_SIZE / 1024 + 1024 ))
+    avail_kb=$(df -Pk "$1" 2>/dev/null | awk 'NR == 2 { print $4 }')
+
+    case "$avail_kb" in
+        ''|*[!0-9]*)
+            say "$YELLOW" "Warning: could not determine free space in $1"
+            return 0
+            ;;
+    esac
+
+    if [ "$avail_kb" -lt "$need_kb" ]; then
+        say "$RED" "Error: not enough space in $1: need $((need_kb / 1024)) MB, $((avail_kb / 1024)) MB available." >&2
+        echo "  Free up space there, or point TMPDIR at a larger directory:" >&2
+        echo "    TMPDIR=/path/with/space sh $0" >&2
+        exit 1
+    fi
+    echo "Free space in $1: $((avail_kb / 1024)) MB (need $((need_kb / 1024)) MB)"
+}
+
+# Main execution
+main() {
+    echo "imgcd self-extracting bundle v${IMGCD_VERSION}"
+    echo "Target platform: ${TARGET_PLATFORM}"
+    echo "Image: ${IMAGE_NAME}"
+    echo ""
+
+    # Detect current platform
+    CURRENT_PLATFORM=$(detect_platform)
+    echo "Detected platform: ${CURRENT_PLATFORM}"
+
+    # Warn if platforms don't match
+    if [ "$CURRENT_PLATFORM" != "$TARGET_PLATFORM" ]; then
+        say "$YELLOW" "Warning: Current platform ($CURRENT_PLATFORM) differs from target platform ($TARGET_PLATFORM)"
+        say "$YELLOW" "The embedded imgcd binary may not be compatible with this system."
+        printf "Continue anyway? (y/N) "
+        REPLY=""
+        read -r REPLY || true
+        case "$REPLY" in
+            [Yy]*)
+                ;;
+            *)
+                echo "Aborted."
+                exit 1
+                ;;
+        esac
+    fi
+
+    # Check everything that can fail before unpacking gigabytes;
+    # IMGCD_SKIP_CHECKS=1 skips the runtime and disk space checks
+    EXTRACT_BASE="${TMPDIR:-/tmp}"
+    verify_payload
+    if [ "${IMGCD_SKIP_CHECKS:-}" != "1" ]; then
+        check_runtime
+        check_disk_space "$EXTRACT_BASE"
+    fi
+
+    # Create temporary directory (busybox mktemp has no -t)
+    TEMP_DIR=$(mktemp -d "${EXTRACT_BASE}/imgcd-bundle.XXXXXX")
+    trap 'rm -rf "$TEMP_DIR"' EXIT
+    trap 'exit 130' INT
+    trap 'exit 143' TERM
+
+    echo ""
+    echo "Extracting bundle to temporary directory..."
+
+    # Unpack the imgcd binary and image data
+    if ! read_payload | tar -xf - -C "$TEMP_DIR"; then
+        say "$RED" "Error: failed to unpack the bundle payload" >&2
+        exit 1
+    fi
+    IMGCD_BIN="$TEMP_DIR/imgcd"
+    IMAGE_FILE="$TEMP_DIR/image.tar.gz"
+    verify_binary
+    chmod +x "$IMGCD_BIN"
+
+    echo "Extraction complete."
+    echo ""
+    echo "Importing image..."
+
+    # Import the image using the extracted imgcd binary, which checks it
+    # against the checksums.txt next to it;
+    # IMGCD_EXTRAS_DIR=DIR also extracts the attached files and
+    # IMGCD_RUN_POST_LOAD=1 runs the bundle's on-load commands after confirmation
+    if "$IMGCD_BIN" load --from "$IMAGE_FILE" ${IMGCD_EXTRAS_DIR:+--extras-dir "$IMGCD_EXTRAS_DIR"} ${IMGCD_RUN_POST_LOAD:+--run-post-load}; then
+        echo ""
+        say "$GREEN" "Successfully imported image: ${IMAGE_NAME}"
+        exit 0
+    else
+        echo ""
+        say "$RED" "Failed to import image" >&2
+        exit 1
+    fi
+}
+
+# Run main function, never reaching the payload below
+main "$@"
+exit 0
+imgcd                                                                                               0000755 0000000 0000000 00000000004 00000000000 010210  0                                                                                                    ustar 00                                                                0000000 0000000                                                                                                                                                                        tiny                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                            image.tar.gz                                                                                        0000644 0000000 0000000 00000006724 00000000000 011427  0                                                                                                    ustar 00                                                                0000000 0000000                                                                                                                                                                          	n W	TSFTPPp@%ƙ!f2VH2!"PEDdPb28H"*
+
+BVPW)kjZur{?Rez$b   ߭  ~ah;54 DX6򱠐QX F3T&:bwD~)%)q `g Lﰿ/*$R?Y_X*QLվt~OH}N}$DS?pT.YJ1$$)e"\*}{L%d._F!\"D^i\""1)e#TmTaL3W5LBD!$B$`.(.'P Ϧp|(J@B 0Y'RC@ww_GD*)QSJ^E~|d?QcG!(@a˅	"p1&$8Ba|(81 NȠ8 (ȃ8   ȡ<$D|3@AcR\(\!U1t.%Q9I  } @1H&HP\(MHQ1!c.Qy/zb))V2C."GI?2ې߭ɗT-ˋ_{<E !>Ca>
+@0%d\I\Iˡ!qgI @(bsl P.0āx9DlB<6"  0C)P@:2>j&"!'O<mM Gӡ?O)5ߔZ1957.e !f `"N GT$Ũ'}(0!|jL!%R}it`Lꛊ?q~ }zO´Ջư'n+n_ǄQ(ӷZSqg|%_mD
+SWEnU]woƾ0']{U~g7#^ԃ6sg՛8~|ss7[fjn^)!k&cx#cF$6添%a11%ũńtk+~zh[$%j(;i[k"=zz3ܴ<r.sYZy	m6._fՙs{=äz;ۉ{ʦ[Jxa,4Ag[D$,$0/qd,C-7E鷎Ŭw[9e3w??#gF,-ʲ˩'ژ9V>hϲ*|ky*fjgXǍiW"N}`PJND	S~s{NB7MEO/ra*+zz)zq擘c[&8TgoRӴbW'ae)Cj^O420:ةMo!{bSjۛT%o|fHxiFi,_\:^;Zr(paUVݭvmcj^plz{tΗURwӝIg6"bBKJK"K$p?niY7&gױzQuXۆ\/XKC+AȐ<ףxNnsy{f=g^<6*WUx].]eӱ"w(pZŻ2"vo72=||z̈>qw/0#ۺ.Ъ}θlAz9[y<X=Q^fBNF+8L^Z<X@s4-}[~ymkM+6镋]{/>}0{8s`8mKy?Vd«[fjzB'OJ阡9,OPdSeu1i--gf^w=|hŵK^R&RUJם-)ΚZrwWCk9>*/ʶ2kێCFh浻;̓
+ϡU"Ӡz}7C?o54 `0P_t)-*N3O.f%U|-&MMqYd]U][c[*DEuLc{X,+p'aW3G);K=p-cHgU'ndeCyT}mwVDw^C*=s[yɚ^KFK?3K  w;cC8
+7*8cU.Y2!؁mAWg܄m퍒Wln5[MW:-Iƶ҈X}MuM
+]Wڬ[`c<$[	-B`gӦo_[uIq$ՙQɏr	Ĕ=h|n|=1qXKC~io|k}qdYCCS>C3׵={>荕jݖrn9Z}౤8M4^MWhfXIwjcƭ6sW߆Z&G	yVrdUgӎ_XKݙgvIiu{$o+:Oħ$ٟmbZ^ٶZC
+#1J_g
+n74X<tQj5?@Y_^u*9藯F,TiGvֳ3iA-;ad^?ET)TuoxRDܭ&mggN6lQrs{f-fgL?8NJULEY}	-1S%'30h椞7KZz𿙻ұ9Jeh?eoqKH잣H|g&\{"mݵtX'.k'yL>YƳor
+,'.wb~awņYyz34撅ԑ+X&Ϟ>~U\y2FIX/L8w׼!twB6΂)O9!")N~VŒsY:"h12v:^-Gn<_y&7VR5ހ ?i׺tP&<¯kB|yJ(d,Wu41kA/7WD~s<mVӒo>i˖ZJ&ʻo7?8?t*b.UŦġ=4hb		w_    GM                                                checksums.txt                                                                                       0000644 0000000 0000000 00000000670 00000000000 011736  0                                                                                                    ustar 00                                                                0000000 0000000                                                                                                                                                                        8950abfda7b727630760dd35bcf5c3daa7631aff223a90f7728c0d2521dde10c  imgcd
+089b14aecf17169245a4ebfe5cdbc32d2891cc2023ef848244a3c1fcea383004  metadata.json
+31b98f6a0fbf3774d4fdcbf79b44e3e2aec6fb9f939cab0cc721bbfc68078a68  blobs/sha256/31b98f6a0fbf3774d4fdcbf79b44e3e2aec6fb9f939cab0cc721bbfc68078a68
+ac200a2bdb1840c7c1c1cd6c6c6661b10808f2a0f0d115f98e310e2d19b146b5  blobs/sha256/ac200a2bdb1840c7c1c1cd6c6c6661b10808f2a0f0d115f98e310e2d19b146b5
+                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                        
\ No newline at end of file
@@ -0,0 +1,460 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	goruntime "runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+)
+
+const (
+	verifyChunkSize = 1 << 20 // Blob data is handed to hashers in chunks of this size
+	verifyQueue     = 4       // Chunks buffered per blob being hashed
+)
+
+// VerifyOptions configures VerifyBundle
+type VerifyOptions struct {
+	Workers int // Blobs hashed concurrently; 0 uses the number of CPUs
+}
+
+// VerifyReport is the outcome of verifying one bundle
+type VerifyReport struct {
+	ImageRef  string   // Image of the bundle; all of them, comma-separated, for multi-image bundles
+	Legacy    bool     // v1 bundle: no per-blob digests to check
+	Bytes     int64    // Size of the bundle file
+	SHA256    string   // Hex sha256 of the bundle file
+	Sidecar   bool     // Whether a .sha256 file was checked
+	Blobs     int      // Blobs verified against their digest
+	Checksums int      // Further checksums of those blobs verified (LayerInfo.Checksums)
+	Skipped   []string // Recorded digest algorithms imgcd cannot compute, not verified
+	Manifest  bool     // Whether metadata, manifest and config were checked against each other
+	BlobBytes int64    // Total size of those blobs
+	Problems  []string // Everything that did not match; empty if the bundle is intact
+}
+
+// OK reports whether the bundle verified without problems
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) problem(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// VerifyBundle checks a bundle in a single sequential read without writing
+// anything to disk: the file against its .sha256 sidecar, a self-extractor's
+// payload against its header, the gzip stream against its CRC, every blob
+// against its digest and the sizes recorded in the metadata, and the metadata
+// against the image's manifest and config. Blobs are hashed concurrently
+// while the stream advances, and so is the file as a whole; memory use is
+// bounded by the number of workers.
+func VerifyBundle(path string, opts VerifyOptions) (*VerifyReport, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = goruntime.NumCPU()
+	}
+
+	expected, err := checksum.Recorded(path)
+	if err != nil && !errors.Is(err, checksum.ErrNoSidecar) {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	report := &VerifyReport{Sidecar: expected != ""}
+	fileHash := checksum.NewHasher()
+	defer fileHash.Close()
+	stream := &countingReader{r: io.TeeReader(bufio.NewReaderSize(file, checksum.ChunkSize), fileHash)}
+
+	v, err := openVerifyStream(path, stream)
+	if err != nil {
+		return nil, err
+	}
+	if v.payloadHash != nil {
+		defer v.payloadHash.Close()
+	}
+
+	if err := verifyImage(v.image, workers, report); err != nil {
+		report.problem("%v", err)
+	}
+
+	// Hash whatever the readers above did not need, payload first
+	if _, err := io.Copy(io.Discard, v.payload); err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	report.Bytes = stream.n
+	report.SHA256 = fileHash.Sum()
+
+	if expected != "" && report.SHA256 != expected {
+		report.problem("checksum mismatch: %s records %s, bundle is %s", checksum.SidecarPath(path), expected, report.SHA256)
+	}
+	if header := v.header; header != nil {
+		if actual := report.Bytes - header.PayloadOffset; actual != header.PayloadSize {
+			report.problem("payload is %d bytes, header records %d", actual, header.PayloadSize)
+		} else if actual := v.payloadHash.Sum(); actual != header.PayloadSHA256 {
+			report.problem("payload checksum mismatch: header records %s, payload is %s", header.PayloadSHA256, actual)
+		}
+	}
+
+	return report, nil
+}
+
+// verifyStream is a bundle positioned at its image.tar.gz
+type verifyStream struct {
+	image       io.Reader
+	payload     io.Reader // The bundle tar; drained after the image to finish payloadHash
+	header      *SelfExtractorHeader
+	payloadHash *checksum.Hasher // Fed with the payload of self-extractors
+}
+
+// openVerifyStream positions stream at the image.tar.gz of the bundle
+func openVerifyStream(path string, stream io.Reader) (*verifyStream, error) {
+	header, err := ReadSelfExtractorHeader(path)
+	if err != nil && !errors.Is(err, errNotSelfExtractor) {
+		return nil, fmt.Errorf("failed to read bundle header: %w", err)
+	}
+
+	v := &verifyStream{payload: stream, header: header}
+	if header != nil {
+		if _, err := io.CopyN(io.Discard, stream, header.PayloadOffset); err != nil {
+			return nil, fmt.Errorf("failed to read bundle header: %w", err)
+		}
+		v.payloadHash = checksum.NewHasher()
+		v.payload = io.TeeReader(io.LimitReader(stream, header.PayloadSize), v.payloadHash)
+	} else if compressed, err := isGzipFile(path); err != nil {
+		return nil, err
+	} else if compressed {
+		v.image = stream
+		return v, nil
+	}
+
+	tr := tar.NewReader(v.payload)
+	for {
+		entry, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("unrecognized bundle %s: image.tar.gz not found", path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle tar: %w", err)
+		}
+		if bundle.CleanName(entry.Name) == "image.tar.gz" {
+			v.image = tr
+			return v, nil
+		}
+	}
+}
+
+// verifyImage walks the image.tar.gz stream and checks its blobs
+func verifyImage(r io.Reader, workers int, report *VerifyReport) error {
+	br, err := bundle.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+	br.SkipDigests() // The hashers check them, with the recorded checksums
+
+	var metadata *bundle.Metadata
+	var artifactManifest []byte
+	hashers := newBlobHashers(workers)
+	for entry, err := range br.All() {
+		if err != nil {
+			hashers.wait()
+			return fmt.Errorf("image data is corrupt: %w", err)
+		}
+
+		switch {
+		case entry.Kind == bundle.MetadataEntry:
+			metadata = br.Metadata()
+			report.ImageRef = strings.Join(metadata.ImageRefs(), ", ")
+
+		case entry.Kind == bundle.LegacyMetadataEntry:
+			var meta v1Metadata
+			if err := json.NewDecoder(entry).Decode(&meta); err != nil {
+				hashers.wait()
+				return fmt.Errorf("failed to decode metadata: %w", err)
+			}
+			report.ImageRef = meta.NewRef
+			report.Legacy = true
+
+		case entry.Name() == artifactManifestName:
+			if artifactManifest, err = io.ReadAll(entry); err != nil {
+				hashers.wait()
+				return fmt.Errorf("image data is corrupt: %w", err)
+			}
+
+		case entry.Kind == bundle.BlobEntry:
+			digest := entry.Digest
+			var expected map[string]string
+			if metadata != nil {
+				expected = blobDigests(digest, metadata.LayerChecksums(digest))
+			} else {
+				// Stored before the metadata by repacking: hash with every
+				// algorithm a recorded checksum may use
+				expected = make(map[string]string)
+				for _, algorithm := range bundle.Algorithms {
+					expected[algorithm] = ""
+				}
+				expected[bundle.Algorithm(digest)] = digest
+			}
+			if err := hashers.hash(digest, entry, expected); err != nil {
+				hashers.wait()
+				return fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+			}
+		}
+	}
+
+	blobs := hashers.wait()
+	if metadata == nil && !report.Legacy {
+		return fmt.Errorf("metadata not found in bundle (expected metadata.json or imgcd-meta.json)")
+	}
+	if metadata == nil {
+		return nil
+	}
+
+	// Blobs shared by the images of a multi-image bundle are stored once
+	expected := make(map[string]bool)
+	var layers []bundle.LayerInfo
+	for _, image := range metadata.PerImage() {
+		for _, layer := range image.Layers {
+			if !expected[layer.Digest] {
+				expected[layer.Digest] = true
+				layers = append(layers, layer)
+			}
+		}
+	}
+	skipped := make(map[string]bool)
+	for _, layer := range layers {
+		blob, ok := blobs[layer.Digest]
+		expected := blobDigests(layer.Digest, layer.Checksums)
+		switch {
+		case !ok:
+			report.problem("missing blob %s", layer.Digest)
+		case mismatch(layer.Digest, expected, blob.digests) != nil:
+			report.problem("%v", mismatch(layer.Digest, expected, blob.digests))
+		case layer.Size > 0 && blob.size != layer.Size:
+			report.problem("blob %s is %d bytes, metadata records %d", layer.Digest, blob.size, layer.Size)
+		default:
+			report.Blobs++
+			report.BlobBytes += blob.size
+			report.Checksums += len(expected) - 1
+		}
+		recorded := []string{bundle.Algorithm(layer.Digest)}
+		for algorithm := range layer.Checksums {
+			recorded = append(recorded, algorithm)
+		}
+		for _, algorithm := range recorded {
+			if _, ok := expected[algorithm]; !ok && !skipped[algorithm] {
+				skipped[algorithm] = true
+				report.Skipped = append(report.Skipped, algorithm)
+			}
+		}
+	}
+	sort.Strings(report.Skipped)
+	for digest := range blobs {
+		if !expected[digest] {
+			report.problem("unexpected blob %s not listed in metadata", digest)
+		}
+	}
+
+	for _, image := range metadata.PerImage() {
+		checkManifest(image, artifactManifest, report)
+	}
+	return nil
+}
+
+// checkManifest checks that the metadata agrees with the manifest and config
+// it carries: every stored layer sits in the manifest with the same size and
+// the DiffID the config records at that position, and a full bundle stores
+// every layer. Artifacts are checked against their raw manifest instead.
+func checkManifest(metadata *bundle.Metadata, artifactManifest []byte, report *VerifyReport) {
+	if metadata.Manifest == nil {
+		report.problem("metadata has no manifest")
+		return
+	}
+	report.Manifest = true
+	manifest := metadata.Manifest
+
+	if artifact := metadata.Artifact; artifact != nil {
+		if artifactManifest == nil {
+			report.problem("artifact manifest %s is missing", artifactManifestName)
+			return
+		}
+		digest, _, err := v1.SHA256(bytes.NewReader(artifactManifest))
+		if err != nil {
+			report.problem("failed to hash %s: %v", artifactManifestName, err)
+		} else if digest.String() != artifact.Digest {
+			report.problem("artifact manifest hashes to %s, metadata records %s", digest, artifact.Digest)
+		}
+		listed := make(map[string]bool)
+		for _, layer := range metadata.Layers {
+			listed[layer.Digest] = true
+		}
+		for _, desc := range append([]v1.Descriptor{manifest.Config}, manifest.Layers...) {
+			if !listed[desc.Digest.String()] {
+				report.problem("manifest blob %s is not in the bundle", desc.Digest)
+			}
+		}
+		return
+	}
+
+	if metadata.Config == nil {
+		report.problem("metadata has no image config")
+		return
+	}
+	if len(metadata.RawConfig) > 0 {
+		if digest, _, err := v1.SHA256(bytes.NewReader(metadata.RawConfig)); err == nil && digest != manifest.Config.Digest {
+			report.problem("raw config hashes to %s, manifest records %s", digest, manifest.Config.Digest)
+		}
+	}
+	diffIDs := metadata.Config.RootFS.DiffIDs
+	if len(diffIDs) != len(manifest.Layers) {
+		report.problem("manifest lists %d layer(s), config records %d DiffID(s)", len(manifest.Layers), len(diffIDs))
+		return
+	}
+	if platform := metadata.Config.Platform(); metadata.Platform != "" && platform != nil && platform.OS != "" {
+		if actual := platform.OS + "/" + platform.Architecture; !strings.HasPrefix(metadata.Platform+"/", actual+"/") {
+			report.problem("metadata records platform %s, config is %s", metadata.Platform, actual)
+		}
+	}
+
+	position := make(map[string]int)
+	for i, desc := range manifest.Layers {
+		position[desc.Digest.String()] = i
+	}
+	stored := make(map[string]bool)
+	for _, layer := range metadata.Layers {
+		stored[layer.Digest] = true
+		i, ok := position[layer.Digest]
+		switch {
+		case !ok:
+			report.problem("layer %s is not in the manifest", layer.Digest)
+		case manifest.Layers[i].Size != layer.Size:
+			report.problem("layer %s is %d bytes in the manifest, metadata records %d", layer.Digest, manifest.Layers[i].Size, layer.Size)
+		case layer.DiffID != "" && layer.DiffID != diffIDs[i].String():
+			report.problem("layer %s has DiffID %s, config records %s", layer.Digest, layer.DiffID, diffIDs[i])
+		}
+	}
+
+	// Layers of an incremental bundle's base come from the runtime on load
+	if metadata.BaseRef == "" {
+		for _, desc := range manifest.Layers {
+			if !stored[desc.Digest.String()] {
+				report.problem("full bundle is missing layer %s", desc.Digest)
+			}
+		}
+	}
+}
+
+// blobResult is the hash of one blob entry
+type blobResult struct {
+	digests map[string]string // What the content hashes to, by algorithm
+	size    int64
+}
+
+// blobHashers hashes blob entries on a bounded number of goroutines while the
+// tar stream moves on to the next entry
+type blobHashers struct {
+	slots   chan struct{}
+	buffers sync.Pool
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	results map[string]blobResult // Digest from the entry name -> result
+}
+
+func newBlobHashers(workers int) *blobHashers {
+	return &blobHashers{
+		slots:   make(chan struct{}, workers),
+		buffers: sync.Pool{New: func() any { return make([]byte, verifyChunkSize) }},
+		results: make(map[string]blobResult),
+	}
+}
+
+// hash reads the entry and queues its chunks for a hasher goroutine, which
+// hashes them with the algorithms of expected; it blocks while all workers
+// are busy
+func (h *blobHashers) hash(digest string, r io.Reader, expected map[string]string) error {
+	h.slots <- struct{}{}
+	chunks := make(chan []byte, verifyQueue)
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		defer func() { <-h.slots }()
+
+		hashes := make(map[string]hash.Hash)
+		for algorithm := range expected {
+			hashes[algorithm], _ = bundle.NewHash(algorithm)
+		}
+		var size int64
+		for chunk := range chunks {
+			for _, hasher := range hashes {
+				hasher.Write(chunk)
+			}
+			size += int64(len(chunk))
+			h.buffers.Put(chunk[:cap(chunk)])
+		}
+
+		result := blobResult{digests: make(map[string]string), size: size}
+		for algorithm, hasher := range hashes {
+			result.digests[algorithm] = algorithm + ":" + hex.EncodeToString(hasher.Sum(nil))
+		}
+		h.mu.Lock()
+		h.results[digest] = result
+		h.mu.Unlock()
+	}()
+	defer close(chunks)
+
+	for {
+		buf := h.buffers.Get().([]byte)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunks <- buf[:n]
+		} else {
+			h.buffers.Put(buf)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// wait returns the results once every queued blob is hashed
+func (h *blobHashers) wait() map[string]blobResult {
+	h.wg.Wait()
+	return h.results
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,133 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/checksum"
+	"gopkg.in/yaml.v3"
+)
+
+// zarfPackageConfig is the part of zarf.yaml imgcd writes
+type zarfPackageConfig struct {
+	Kind       string          `yaml:"kind"`
+	Metadata   zarfMetadata    `yaml:"metadata"`
+	Build      zarfBuild       `yaml:"build"`
+	Components []zarfComponent `yaml:"components"`
+}
+
+type zarfMetadata struct {
+	Name              string `yaml:"name"`
+	Description       string `yaml:"description,omitempty"`
+	Version           string `yaml:"version,omitempty"`
+	Architecture      string `yaml:"architecture"`
+	AggregateChecksum string `yaml:"aggregateChecksum"`
+}
+
+type zarfBuild struct {
+	Terminal     string `yaml:"terminal"`
+	User         string `yaml:"user"`
+	Architecture string `yaml:"architecture"`
+	Timestamp    string `yaml:"timestamp"`
+	Version      string `yaml:"version"`
+}
+
+type zarfComponent struct {
+	Name     string   `yaml:"name"`
+	Required bool     `yaml:"required"`
+	Images   []string `yaml:"images"`
+}
+
+// zarfNameInvalid matches what Zarf does not allow in package names
+var zarfNameInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// writeZarfPackageConfig writes checksums.txt and zarf.yaml for the images/
+// layout in dir, with the images in one required component, and returns the
+// file name Zarf gives the package
+func writeZarfPackageConfig(dir string, metadata *bundle.Metadata, refs []string, source, version string) (string, error) {
+	aggregate, err := writeZarfChecksums(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to write checksums.txt: %w", err)
+	}
+
+	repo, tag := parseReference(metadata.ImageRef)
+	name := strings.Trim(zarfNameInvalid.ReplaceAllString(strings.ToLower(filepath.Base(repo)), "-"), "-")
+	if name == "" {
+		name = "imgcd"
+	}
+	arch := getPlatformArch(metadata.Platform)
+	user, host := "", ""
+	if metadata.Provenance != nil {
+		user, host = metadata.Provenance.User, metadata.Provenance.Host
+	}
+
+	config := zarfPackageConfig{
+		Kind: "ZarfPackageConfig",
+		Metadata: zarfMetadata{
+			Name:              name,
+			Description:       "Converted from imgcd bundle " + source,
+			Version:           tag,
+			Architecture:      arch,
+			AggregateChecksum: aggregate,
+		},
+		Build: zarfBuild{
+			Terminal:     host,
+			User:         user,
+			Architecture: arch,
+			Timestamp:    time.Now().Format(time.RFC1123Z),
+			Version:      "imgcd-" + version,
+		},
+		Components: []zarfComponent{{Name: "images", Required: true, Images: refs}},
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "zarf.yaml"), data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write zarf.yaml: %w", err)
+	}
+	return fmt.Sprintf("zarf-package-%s-%s-%s.tar.zst", name, arch, tag), nil
+}
+
+// writeZarfChecksums records the sha256 of every file of the package in
+// checksums.txt, sorted by path, and returns the sha256 of that file, which
+// zarf.yaml carries as the aggregate checksum
+func writeZarfChecksums(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	slices.Sort(files)
+
+	var sums strings.Builder
+	for _, file := range files {
+		sum, err := checksum.File(file)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sums, "%s %s\n", sum, filepath.ToSlash(rel))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "checksums.txt"), []byte(sums.String()), 0644); err != nil {
+		return "", err
+	}
+	aggregate := sha256.Sum256([]byte(sums.String()))
+	return hex.EncodeToString(aggregate[:]), nil
+}
@@ -0,0 +1,89 @@
+// Package logging is imgcd's diagnostic log: what happens under the hood of
+// a command (registry fetches and their timings, cache hits, peer and
+// segment decisions, pulls served) as opposed to the status lines of package
+// ui. Records go through log/slog to stderr, as text or, for log pipelines,
+// one JSON object per line.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Values of --log-format
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	level      slog.LevelVar
+	jsonFormat bool
+)
+
+func init() {
+	Configure(os.Stderr, "", "")
+}
+
+// Configure applies --log-level and --log-format, logging to w. Without
+// them IMGCD_LOG_LEVEL and IMGCD_LOG_FORMAT apply; IMGCD_DEBUG, the
+// switch of older versions, selects debug. The default is info as text.
+func Configure(w io.Writer, levelName, format string) error {
+	if levelName == "" {
+		levelName = os.Getenv("IMGCD_LOG_LEVEL")
+	}
+	if levelName == "" && os.Getenv("IMGCD_DEBUG") != "" {
+		levelName = "debug"
+	}
+	if format == "" {
+		format = os.Getenv("IMGCD_LOG_FORMAT")
+	}
+
+	parsed, err := parseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	level.Set(parsed)
+
+	options := &slog.HandlerOptions{Level: &level}
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		jsonFormat = false
+		slog.SetDefault(slog.New(slog.NewTextHandler(w, options)))
+	case FormatJSON:
+		jsonFormat = true
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, options)))
+	default:
+		return fmt.Errorf("invalid log format: %s (valid options: text, json)", format)
+	}
+	return nil
+}
+
+// parseLevel reads a --log-level; empty is info
+func parseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level: %s (valid options: debug, info, warn, error)", name)
+}
+
+// JSON reports whether records are logged as JSON, in which case warnings
+// and errors are logged rather than printed as text
+func JSON() bool {
+	return jsonFormat
+}
+
+// Enabled reports whether records of level l are logged
+func Enabled(l slog.Level) bool {
+	return l >= level.Level()
+}
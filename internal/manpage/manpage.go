@@ -0,0 +1,216 @@
+// Package manpage renders the help of cobra commands as roff man pages, so
+// the manual is available on offline hosts without extra tooling
+package manpage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Header identifies the manual the pages belong to
+type Header struct {
+	Section string    // Manual section, "1" for user commands
+	Date    time.Time // Shown in the page footer
+	Source  string    // Footer source, e.g. "imgcd v1.2.0"
+	Manual  string    // Page header title, e.g. "imgcd Manual"
+
+	// Sections are added to the pages of commands, by command path, for
+	// what is too long for their --help, e.g. the environment of imgcd
+	Sections map[string][]Section
+}
+
+// Section is a titled part of a page, laid out like help text
+type Section struct {
+	Title string // Upper case, e.g. ENVIRONMENT
+	Text  string
+}
+
+// PageName returns the file name of a command's page, e.g. imgcd-cache-list.1
+func PageName(cmd *cobra.Command, section string) string {
+	return strings.ReplaceAll(cmd.CommandPath(), " ", "-") + "." + section
+}
+
+// WriteTree writes the pages of cmd and all its available subcommands into
+// dir and returns their paths
+func WriteTree(dir string, cmd *cobra.Command, header Header) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	var paths []string
+	var write func(cmd *cobra.Command) error
+	write = func(cmd *cobra.Command) error {
+		path := filepath.Join(dir, PageName(cmd, header.Section))
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := Render(file, cmd, header); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+
+		for _, sub := range cmd.Commands() {
+			if sub.IsAvailableCommand() {
+				if err := write(sub); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := write(cmd); err != nil {
+		return nil, fmt.Errorf("failed to write man page: %w", err)
+	}
+	return paths, nil
+}
+
+// Render writes the man page of cmd: name, synopsis, the long help as
+// description, options, the sections of the header for cmd, and links to
+// the parent and subcommands
+func Render(w io.Writer, cmd *cobra.Command, header Header) error {
+	name := strings.ReplaceAll(cmd.CommandPath(), " ", "-")
+	var b strings.Builder
+
+	fmt.Fprintf(&b, ".TH %q %q %q %q %q\n", strings.ToUpper(name), header.Section,
+		header.Date.Format("Jan 2006"), header.Source, header.Manual)
+	b.WriteString(".nh\n.ad l\n")
+
+	b.WriteString(".SH NAME\n")
+	fmt.Fprintf(&b, "%s \\- %s\n", name, escape(cmd.Short))
+
+	b.WriteString(".SH SYNOPSIS\n")
+	synopsis := strings.TrimPrefix(cmd.UseLine(), cmd.CommandPath())
+	fmt.Fprintf(&b, "\\fB%s\\fP%s\n", escape(cmd.CommandPath()), escape(synopsis))
+
+	description := cmd.Long
+	if description == "" {
+		description = cmd.Short
+	}
+	b.WriteString(".SH DESCRIPTION\n")
+	writeVerbatim(&b, description)
+
+	if len(cmd.Aliases) > 0 {
+		b.WriteString(".SH ALIASES\n")
+		aliases := make([]string, len(cmd.Aliases))
+		for i, alias := range cmd.Aliases {
+			aliases[i] = "\\fB" + escape(alias) + "\\fP"
+		}
+		b.WriteString(strings.Join(aliases, ", ") + "\n")
+	}
+
+	writeFlags(&b, "OPTIONS", cmd.NonInheritedFlags())
+	writeFlags(&b, "OPTIONS INHERITED FROM PARENT COMMANDS", cmd.InheritedFlags())
+
+	if cmd.Example != "" {
+		b.WriteString(".SH EXAMPLES\n")
+		writeVerbatim(&b, cmd.Example)
+	}
+
+	for _, section := range header.Sections[cmd.CommandPath()] {
+		fmt.Fprintf(&b, ".SH %s\n", escape(section.Title))
+		writeVerbatim(&b, section.Text)
+	}
+
+	var related []string
+	if cmd.HasParent() {
+		related = append(related, reference(cmd.Parent(), header.Section))
+	}
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() {
+			related = append(related, reference(sub, header.Section))
+		}
+	}
+	if len(related) > 0 {
+		b.WriteString(".SH SEE ALSO\n")
+		b.WriteString(strings.Join(related, ", ") + "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeFlags lists flags as tagged paragraphs; hidden and deprecated flags
+// are left out
+func writeFlags(b *strings.Builder, title string, flags *pflag.FlagSet) {
+	wroteTitle := false
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Hidden || flag.Deprecated != "" {
+			return
+		}
+		if !wroteTitle {
+			fmt.Fprintf(b, ".SH %s\n", title)
+			wroteTitle = true
+		}
+
+		varname, usage := pflag.UnquoteUsage(flag)
+		b.WriteString(".TP\n")
+		if flag.Shorthand != "" && flag.ShorthandDeprecated == "" {
+			fmt.Fprintf(b, "\\fB\\-%s\\fP, ", flag.Shorthand)
+		}
+		fmt.Fprintf(b, "\\fB\\-\\-%s\\fP", escape(flag.Name))
+		if varname != "" {
+			fmt.Fprintf(b, "=\\fI%s\\fP", escape(varname))
+		}
+		b.WriteString("\n" + escapeLine(usage))
+		if hasDefault(flag) {
+			fmt.Fprintf(b, " (default %s)", escape(flag.DefValue))
+		}
+		b.WriteString("\n")
+	})
+}
+
+// hasDefault reports whether a flag's default is worth printing
+func hasDefault(flag *pflag.Flag) bool {
+	switch flag.DefValue {
+	case "", "false", "0", "[]":
+		return false
+	}
+	return true
+}
+
+// reference links to another command's page
+func reference(cmd *cobra.Command, section string) string {
+	return fmt.Sprintf("\\fB%s\\fP(%s)", strings.ReplaceAll(cmd.CommandPath(), " ", "-"), section)
+}
+
+// writeVerbatim keeps the line breaks and indentation of help text, which is
+// wrapped and laid out by hand
+func writeVerbatim(b *strings.Builder, text string) {
+	b.WriteString(".nf\n")
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			// Blank lines are paragraph breaks in roff; keep the empty line
+			b.WriteString("\\&\n")
+			continue
+		}
+		b.WriteString(escapeLine(line) + "\n")
+	}
+	b.WriteString(".fi\n")
+}
+
+// escape protects backslashes and dashes in running text
+func escape(text string) string {
+	text = strings.ReplaceAll(text, "\\", "\\e")
+	return strings.ReplaceAll(text, "-", "\\-")
+}
+
+// escapeLine escapes a line of text, which must not start like a request
+func escapeLine(line string) string {
+	line = escape(line)
+	if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+		return "\\&" + line
+	}
+	return line
+}
@@ -0,0 +1,36 @@
+// Package priority lowers the CPU and IO scheduling priority of imgcd and
+// of the runtime commands it starts, so that loading a large image on a
+// production host does not starve running workloads.
+package priority
+
+import "fmt"
+
+// Levels accepted by --io-priority
+const (
+	Normal = "normal"
+	Low    = "low"
+	Idle   = "idle"
+)
+
+// Linux IO scheduling classes (see ioprio_set(2))
+const (
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+// Set applies a priority level to the current process. Commands started
+// afterwards (docker load, ctr import) inherit it.
+func Set(level string) error {
+	switch level {
+	case "", Normal:
+		return nil
+	case Low:
+		// Lowest best-effort IO priority, still makes progress under load
+		return apply(10, ioClassBestEffort, 7)
+	case Idle:
+		// Only use CPU and disk time nobody else wants
+		return apply(19, ioClassIdle, 0)
+	default:
+		return fmt.Errorf("invalid priority %q (valid options: %s, %s, %s)", level, Normal, Low, Idle)
+	}
+}
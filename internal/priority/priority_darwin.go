@@ -0,0 +1,15 @@
+package priority
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// apply sets the nice value of the process; macOS has no per-process IO
+// class that child processes inherit, so only CPU priority is lowered
+func apply(nice, ioClass, ioLevel int) error {
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice); err != nil {
+		return fmt.Errorf("failed to set CPU priority: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package priority
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const ioprioWhoProcess = 1
+
+// apply sets nice and IO priority on every thread: on Linux both are per
+// thread, and threads (and child processes) inherit them from their creator
+func apply(nice, ioClass, ioLevel int) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("failed to list threads: %w", err)
+	}
+
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		// A thread may have exited since the listing (ESRCH)
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to set CPU priority: %w", err)
+		}
+		ioprio := ioClass<<13 | ioLevel
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio))
+		if errno != 0 && errno != syscall.ESRCH {
+			return fmt.Errorf("failed to set IO priority: %w", errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package priority
+
+import "fmt"
+
+func apply(nice, ioClass, ioLevel int) error {
+	return fmt.Errorf("priorities are not supported on this platform")
+}
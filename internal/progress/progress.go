@@ -0,0 +1,293 @@
+// Package progress shows the progress of concurrent transfers: on a
+// terminal, a bar per transfer in flight and one for all of them with the
+// overall rate and ETA, redrawn in place; elsewhere (pipes, CI logs, --plain)
+// one log line per finished transfer
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/ci"
+	"github.com/so2liu/imgcd/internal/humanize"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+const (
+	barWidth    = 24
+	redrawEvery = 200 * time.Millisecond
+
+	// defaultWidth is assumed of terminals that do not tell their width
+	defaultWidth = 80
+)
+
+// Multi is the display of a set of transfers. Its methods are safe for
+// concurrent use.
+type Multi struct {
+	w     io.Writer
+	tty   bool
+	width int    // Columns of the terminal; longer lines are cut so they do not wrap
+	verb  string // What the transfers do, e.g. "Downloaded"
+	count int
+
+	mu       sync.Mutex
+	started  time.Time
+	total    int64 // Bytes to transfer, without those found cached
+	moved    int64 // Bytes of finished transfers
+	finished int
+	cached   int
+	active   []*Bar
+	drawn    int // Lines of the last drawing, cleared by the next
+
+	restore func()
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// New starts the display of count transfers of total bytes on w. verb
+// names what they do in the past tense, e.g. "Downloaded". While it runs,
+// ui warnings are printed above the bars.
+func New(w io.Writer, verb string, count int, total int64) *Multi {
+	m := &Multi{
+		w:       w,
+		tty:     isTerminal(w) && !ci.Enabled() && !ui.Plain(),
+		verb:    verb,
+		count:   count,
+		total:   total,
+		started: time.Now(),
+	}
+	if m.tty {
+		m.width = termWidth(w.(*os.File))
+		if m.width <= 0 {
+			m.width = defaultWidth
+		}
+		m.restore = ui.RedirectStderr(m)
+		m.stop = make(chan struct{})
+		m.stopped = make(chan struct{})
+		go m.redraw()
+	}
+	return m
+}
+
+// Start adds a transfer of size bytes to the display
+func (m *Multi) Start(name string, size int64) *Bar {
+	b := &Bar{m: m, name: name, size: size}
+	m.mu.Lock()
+	m.active = append(m.active, b)
+	m.mu.Unlock()
+	return b
+}
+
+// Write prints p above the bars, e.g. a warning
+func (m *Multi) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clear()
+	n, err := m.w.Write(p)
+	m.draw()
+	return n, err
+}
+
+// Finish stops the display and prints a summary line
+func (m *Multi) Finish() {
+	if m.tty {
+		close(m.stop)
+		<-m.stopped
+		m.restore()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clear()
+	elapsed := time.Since(m.started)
+	line := fmt.Sprintf("%s %s in %s", m.verb, humanize.Size(m.moved), humanize.Duration(elapsed))
+	if m.moved > 0 {
+		line += " (" + humanize.Rate(m.moved, elapsed) + ")"
+	}
+	if m.cached > 0 {
+		line += fmt.Sprintf(", %d of %d cached", m.cached, m.count)
+	}
+	fmt.Fprintln(m.w, line)
+}
+
+// redraw draws the bars until Finish
+func (m *Multi) redraw() {
+	defer close(m.stopped)
+	ticker := time.NewTicker(redrawEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			m.clear()
+			m.draw()
+			m.mu.Unlock()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// clear erases the last drawing; m.mu is held
+func (m *Multi) clear() {
+	if m.drawn > 0 {
+		// Back to the start of the first line drawn, then erase to the end
+		fmt.Fprintf(m.w, "\x1b[%dF\x1b[J", m.drawn)
+		m.drawn = 0
+	}
+}
+
+// draw draws a bar per active transfer and the total; m.mu is held
+func (m *Multi) draw() {
+	if !m.tty {
+		return
+	}
+	var lines []string
+	moved := m.moved
+	for _, bar := range m.active {
+		done := min(bar.done, bar.size)
+		moved += done
+		lines = append(lines, fmt.Sprintf("  %-19s %s %s / %s", bar.name, gauge(done, bar.size), humanize.Size(done), humanize.Size(bar.size)))
+	}
+
+	elapsed := time.Since(m.started)
+	total := fmt.Sprintf("  %-19s %s %s / %s", fmt.Sprintf("%d/%d done", m.finished, m.count), gauge(moved, m.total), humanize.Size(moved), humanize.Size(m.total))
+	if rate := humanize.Rate(moved, elapsed); rate != "" && moved > 0 {
+		total += fmt.Sprintf("  %s  %s", rate, eta(m.total-moved, moved, elapsed))
+	}
+	lines = append(lines, total)
+
+	var b strings.Builder
+	for _, line := range lines {
+		// A wrapped line would throw off clear
+		if len(line) >= m.width {
+			line = line[:m.width-1]
+		}
+		b.WriteString(line + "\n")
+	}
+	io.WriteString(m.w, b.String())
+	m.drawn = len(lines)
+}
+
+// remove takes a transfer off the bars; m.mu is held
+func (m *Multi) remove(b *Bar) {
+	for i, active := range m.active {
+		if active == b {
+			m.active = append(m.active[:i], m.active[i+1:]...)
+			return
+		}
+	}
+}
+
+// finish records a finished transfer; the log line stands in for the bars
+// where there are none
+func (m *Multi) finish(b *Bar, cached bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(b)
+	m.finished++
+	if cached {
+		m.cached++
+		m.total -= b.size
+		return
+	}
+	m.moved += b.size
+	if !m.tty {
+		elapsed := time.Since(m.started)
+		line := fmt.Sprintf("[%d/%d] %s %s (%s in %s)", m.finished, m.count, b.name, humanize.Size(b.size), humanize.Size(m.moved), humanize.Duration(elapsed))
+		if m.moved > 0 && m.moved < m.total {
+			line += fmt.Sprintf(", %s, %s", humanize.Rate(m.moved, elapsed), eta(m.total-m.moved, m.moved, elapsed))
+		}
+		fmt.Fprintln(m.w, line)
+	}
+}
+
+// Bar is one transfer of a Multi; a nil Bar ignores all calls. It counts
+// the bytes written to it, e.g. through io.TeeReader.
+type Bar struct {
+	m    *Multi
+	name string
+	size int64
+
+	done int64 // Guarded by m.mu
+}
+
+// Write counts len(p) bytes as transferred
+func (b *Bar) Write(p []byte) (int, error) {
+	if b != nil {
+		b.m.mu.Lock()
+		b.done += int64(len(p))
+		b.m.mu.Unlock()
+	}
+	return len(p), nil
+}
+
+// Restart counts the transfer from zero again, e.g. for a retry
+func (b *Bar) Restart() {
+	if b != nil {
+		b.m.mu.Lock()
+		b.done = 0
+		b.m.mu.Unlock()
+	}
+}
+
+// Done marks the transfer finished
+func (b *Bar) Done() {
+	if b != nil {
+		b.m.finish(b, false)
+	}
+}
+
+// Cached marks a transfer that was not needed; its bytes leave the total
+func (b *Bar) Cached() {
+	if b != nil {
+		b.m.finish(b, true)
+	}
+}
+
+// Abort removes a failed transfer from the display
+func (b *Bar) Abort() {
+	if b != nil {
+		b.m.mu.Lock()
+		b.m.remove(b)
+		b.m.mu.Unlock()
+	}
+}
+
+// eta estimates when the remaining bytes are done at the rate moved bytes
+// took elapsed
+func eta(remaining, moved int64, elapsed time.Duration) string {
+	left := time.Duration(float64(remaining) / float64(moved) * float64(elapsed))
+	if left < time.Second {
+		return "ETA <1s"
+	}
+	return "ETA " + humanize.Duration(left.Round(time.Second))
+}
+
+// gauge draws done of total as a bar with the percentage
+func gauge(done, total int64) string {
+	fraction := 1.0
+	if total > 0 {
+		fraction = min(float64(done)/float64(total), 1)
+	}
+	filled := int(fraction * barWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %3.0f%%", bar, fraction*100)
+}
+
+// isTerminal reports whether w is a terminal
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package progress
+
+import "os"
+
+// termWidth cannot ask the terminal for its width on this platform
+func termWidth(w *os.File) int {
+	return 0
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package progress
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// termWidth returns the columns of the terminal w is, 0 if unknown
+func termWidth(w *os.File) int {
+	size, err := unix.IoctlGetWinsize(int(w.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0
+	}
+	return int(size.Col)
+}
@@ -31,3 +31,24 @@ func PromptSelection(prompt string, options []string) (string, error) {
 
 	return options[num-1], nil
 }
+
+// Confirm asks a yes/no question on stdin; anything but y or yes is a no.
+// It fails when stdin is not a terminal, so nothing is approved unattended.
+func Confirm(question string) (bool, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, fmt.Errorf("cannot ask for confirmation: stdin is not a terminal")
+	}
+	fmt.Printf("%s (y/N) ", question)
+
+	reader := bufio.NewReader(os.Stdin)
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("cannot ask for confirmation: no answer on stdin")
+	}
+
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,128 @@
+package provenance
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+// Options controls how provenance is collected
+// Empty fields are auto-detected from the environment
+type Options struct {
+	User          string // Operator name (default: IMGCD_OPERATOR or the current OS user)
+	GitRepository string // Source repository (default: CI variables or git remote)
+	GitCommit     string // Source commit (default: CI variables or git HEAD)
+	Pipeline      string // CI run URL or identifier (default: CI variables)
+	Version       string // imgcd version stamped as the builder
+}
+
+// Collect gathers the identity of the operator and pipeline producing a bundle
+func Collect(ctx context.Context, opts Options) *bundle.Provenance {
+	prov := &bundle.Provenance{
+		User:          opts.User,
+		GitRepository: opts.GitRepository,
+		GitCommit:     opts.GitCommit,
+		Pipeline:      opts.Pipeline,
+		Builder:       opts.Version,
+	}
+
+	if prov.User == "" {
+		prov.User = currentUser()
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		prov.Host = host
+	}
+
+	// CI systems expose the source and run through well-known variables
+	if prov.GitRepository == "" {
+		prov.GitRepository = ciRepository()
+	}
+	if prov.GitCommit == "" {
+		prov.GitCommit = firstEnv("GITHUB_SHA", "CI_COMMIT_SHA", "GIT_COMMIT", "BUILDKITE_COMMIT", "CIRCLE_SHA1")
+	}
+	if prov.Pipeline == "" {
+		prov.Pipeline = ciPipeline()
+	}
+
+	// Fall back to the git checkout in the working directory
+	if prov.GitCommit == "" {
+		prov.GitCommit = gitOutput(ctx, "rev-parse", "HEAD")
+	}
+	if prov.GitRepository == "" {
+		prov.GitRepository = gitOutput(ctx, "config", "--get", "remote.origin.url")
+	}
+	prov.GitRepository = stripCredentials(prov.GitRepository)
+
+	return prov
+}
+
+// currentUser returns the operator name from IMGCD_OPERATOR or the OS account
+func currentUser() string {
+	if name := os.Getenv("IMGCD_OPERATOR"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return firstEnv("USER", "USERNAME")
+}
+
+// ciRepository returns the source repository reported by common CI systems
+func ciRepository() string {
+	if repo := os.Getenv("GITHUB_REPOSITORY"); repo != "" {
+		server := os.Getenv("GITHUB_SERVER_URL")
+		if server == "" {
+			server = "https://github.com"
+		}
+		return server + "/" + repo
+	}
+	return firstEnv("CI_PROJECT_URL", "GIT_URL", "BUILDKITE_REPO", "CIRCLE_REPOSITORY_URL")
+}
+
+// ciPipeline returns the URL of the current CI run, if any
+func ciPipeline() string {
+	if runID := os.Getenv("GITHUB_RUN_ID"); runID != "" && os.Getenv("GITHUB_REPOSITORY") != "" {
+		return ciRepository() + "/actions/runs/" + runID
+	}
+	return firstEnv("CI_JOB_URL", "CI_PIPELINE_URL", "BUILD_URL", "BUILDKITE_BUILD_URL", "CIRCLE_BUILD_URL")
+}
+
+// firstEnv returns the first non-empty value among the given variables
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// stripCredentials removes user info from repository URLs so tokens
+// embedded in clone URLs never end up in bundle metadata
+func stripCredentials(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || u.User == nil || u.Scheme == "" {
+		return repo
+	}
+	u.User = nil
+	return u.String()
+}
+
+// gitOutput runs git and returns its trimmed output, empty on any failure
+func gitOutput(ctx context.Context, args ...string) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
@@ -0,0 +1,135 @@
+package provenance
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+)
+
+// StatementFileName is the name of the in-toto statement inside a bundle
+const StatementFileName = "provenance.intoto.json"
+
+const (
+	statementType  = "https://in-toto.io/Statement/v1"
+	predicateType  = "https://slsa.dev/provenance/v1"
+	buildType      = "https://github.com/so2liu/imgcd/save@v1"
+	defaultBuilder = "https://github.com/so2liu/imgcd"
+)
+
+// Subject is the artifact a statement is about
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Statement is an in-toto v1 statement carrying a SLSA v1 provenance predicate
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// Predicate is the SLSA v1 provenance predicate
+type Predicate struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes the inputs of the export
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   map[string]string    `json:"externalParameters"`
+	InternalParameters   map[string]string    `json:"internalParameters,omitempty"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// ResourceDescriptor identifies a source the export depended on
+type ResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// RunDetails describes who ran the export and when
+type RunDetails struct {
+	Builder  Builder     `json:"builder"`
+	Metadata RunMetadata `json:"metadata"`
+}
+
+// Builder identifies the build platform
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// RunMetadata carries the invocation details of the export
+type RunMetadata struct {
+	InvocationID string `json:"invocationId,omitempty"`
+	StartedOn    string `json:"startedOn,omitempty"`
+}
+
+// NewStatement builds a provenance statement for an exported image
+// digest is the image digest ("sha256:..."); params are the save parameters
+func NewStatement(imageRef, digest string, params map[string]string, prov *bundle.Provenance, startedOn string) *Statement {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+
+	stmt := &Statement{
+		Type: statementType,
+		Subject: []Subject{{
+			Name:   imageRef,
+			Digest: map[string]string{algorithm: hex},
+		}},
+		PredicateType: predicateType,
+		Predicate: Predicate{
+			BuildDefinition: BuildDefinition{
+				BuildType:          buildType,
+				ExternalParameters: params,
+			},
+			RunDetails: RunDetails{
+				Builder:  Builder{ID: defaultBuilder},
+				Metadata: RunMetadata{StartedOn: startedOn},
+			},
+		},
+	}
+
+	if prov == nil {
+		return stmt
+	}
+
+	stmt.Predicate.BuildDefinition.InternalParameters = map[string]string{
+		"user": prov.User,
+		"host": prov.Host,
+	}
+
+	if prov.GitRepository != "" {
+		dep := ResourceDescriptor{URI: "git+" + prov.GitRepository}
+		if prov.GitCommit != "" {
+			dep.Digest = map[string]string{"gitCommit": prov.GitCommit}
+		}
+		stmt.Predicate.BuildDefinition.ResolvedDependencies = []ResourceDescriptor{dep}
+	}
+
+	// The CI run is the builder when there is one
+	if prov.Pipeline != "" {
+		stmt.Predicate.RunDetails.Builder.ID = prov.Pipeline
+		stmt.Predicate.RunDetails.Metadata.InvocationID = prov.Pipeline
+	}
+	if prov.Builder != "" {
+		stmt.Predicate.RunDetails.Builder.Version = map[string]string{"imgcd": prov.Builder}
+	}
+
+	return stmt
+}
+
+// AddSubject adds another image the statement is about, for bundles that
+// carry several
+func (s *Statement) AddSubject(imageRef, digest string) {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	s.Subject = append(s.Subject, Subject{Name: imageRef, Digest: map[string]string{algorithm: hex}})
+}
+
+// Marshal renders the statement as indented JSON
+func (s *Statement) Marshal() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
@@ -0,0 +1,92 @@
+// Package ratelimit paces byte streams: layer downloads (--limit-rate) and
+// imports into the runtime (--import-rate-limit)
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/so2liu/imgcd/internal/humanize"
+)
+
+// Limiter paces the readers sharing it to a number of bytes per second in
+// total, so parallel downloads and segments split the rate between them
+type Limiter struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time // When the bytes read so far are paid for
+}
+
+// New returns a limiter of rate bytes per second, or nil for rate 0, which
+// readers pass through unlimited
+func New(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{rate: rate}
+}
+
+// Parse parses a rate like 50M or 10MB/s into bytes per second; "" means
+// no limit
+func Parse(rate string) (int64, error) {
+	if rate == "" {
+		return 0, nil
+	}
+	return humanize.ParseSize(strings.TrimSuffix(rate, "/s"))
+}
+
+// Reader wraps r to read at the limiter's pace until ctx is done
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiter: l}
+}
+
+// wait blocks until n more bytes fit in the rate. Time a link was idle is
+// not saved up for a burst afterwards.
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader reads through a Limiter
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	// Small reads keep the pace even instead of bursting
+	if chunk := max(r.limiter.rate/10, 1); int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.wait(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
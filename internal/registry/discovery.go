@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscoveryPort is the UDP port imgcd serve answers discovery probes on
+const DiscoveryPort = 47474
+
+const (
+	discoveryProbe = "imgcd-discover 1"
+	discoveryReply = "imgcd-serve 1" // Followed by the scheme and port of the server
+)
+
+// Announce answers discovery probes from the local network with the scheme
+// and port of a server, until ctx is done
+func Announce(ctx context.Context, scheme string, port int) error {
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", DiscoveryPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen for discovery probes: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	reply := []byte(fmt.Sprintf("%s %s %d", discoveryReply, scheme, port))
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if string(buf[:n]) == discoveryProbe {
+			conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// Discover broadcasts a probe on the local network and returns the URLs of
+// the imgcd serve instances that answer within timeout, e.g.
+// http://192.168.1.20:5000
+func Discover(ctx context.Context, timeout time.Duration) ([]string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	targets := broadcastAddresses()
+	sent := false
+	for _, target := range targets {
+		if _, err := conn.WriteTo([]byte(discoveryProbe), &net.UDPAddr{IP: target, Port: DiscoveryPort}); err == nil {
+			sent = true
+		}
+	}
+	if !sent {
+		return nil, fmt.Errorf("failed to send discovery probe")
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	var peers []string
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) || isTimeout(err) {
+			break
+		}
+		if err != nil {
+			return peers, err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		reply, ok := strings.CutPrefix(string(buf[:n]), discoveryReply)
+		fields := strings.Fields(reply)
+		if !ok || len(fields) != 2 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		peer := fields[0] + "://" + net.JoinHostPort(udpAddr.IP.String(), fields[1])
+		if !slices.Contains(peers, peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers, nil
+}
+
+// broadcastAddresses returns the broadcast address of every IPv4 network of
+// the host, and the limited broadcast address
+func broadcastAddresses() []net.IP {
+	targets := []net.IP{net.IPv4bcast}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return targets
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil || ipNet.IP.IsLoopback() {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		broadcast := make(net.IP, len(ip))
+		for i := range ip {
+			broadcast[i] = ip[i] | ^ipNet.Mask[len(ipNet.Mask)-len(ip)+i]
+		}
+		targets = append(targets, broadcast)
+	}
+	return targets
+}
+
+// isTimeout reports whether err is a read deadline passing
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,318 @@
+// Package registry serves the images of imgcd's caches over the read-only
+// part of the OCI distribution API, so docker pull works against an imgcd
+// host on an offline network
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/so2liu/imgcd/internal/cache"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// Server answers pulls from the manifest cache and the blob cache. Images are
+// served under their repository path without the registry, e.g.
+// registry.example.com/app/web:1.0 as <host>/app/web:1.0; when several
+// registries have the same repository and tag, the most recently fetched
+// one wins.
+type Server struct {
+	blobs     *cache.BlobCache
+	manifests *cache.ManifestCache
+}
+
+// NewServer creates a server; pulls are logged at info level
+func NewServer(blobs *cache.BlobCache, manifests *cache.ManifestCache) *Server {
+	return &Server{blobs: blobs, manifests: manifests}
+}
+
+// Image is a tag the server can hand out
+type Image struct {
+	Repository string
+	Tag        string
+	Source     string                  // Canonical reference it was fetched as
+	Manifests  []*cache.CachedManifest // One per platform, all blobs cached
+	Missing    int                     // Platforms left out because blobs were pruned from the cache
+}
+
+// Images lists the tags that can be pulled, reading the manifest index again
+// to see images saved or pulled since the server started
+func (s *Server) Images() []*Image {
+	if err := s.manifests.Reload(); err != nil {
+		ui.Warning("failed to load manifest index: %v", err)
+	}
+
+	byName := make(map[string]*Image)
+	newest := make(map[string]time.Time)
+	for ref, manifests := range s.manifests.Images() {
+		tag, err := name.NewTag(ref, name.StrictValidation)
+		if err != nil {
+			continue // Images saved by digest have no tag to pull
+		}
+		image := &Image{Repository: tag.RepositoryStr(), Tag: tag.TagStr(), Source: ref}
+		var updated time.Time
+		for _, m := range manifests {
+			if !s.complete(m) {
+				image.Missing++
+				continue
+			}
+			image.Manifests = append(image.Manifests, m)
+			if m.UpdatedAt.After(updated) {
+				updated = m.UpdatedAt
+			}
+		}
+		if len(image.Manifests) == 0 {
+			continue
+		}
+		key := image.Repository + ":" + image.Tag
+		if _, ok := byName[key]; !ok || updated.After(newest[key]) {
+			byName[key] = image
+			newest[key] = updated
+		}
+	}
+
+	images := make([]*Image, 0, len(byName))
+	for _, image := range byName {
+		images = append(images, image)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].Repository != images[j].Repository {
+			return images[i].Repository < images[j].Repository
+		}
+		return images[i].Tag < images[j].Tag
+	})
+	return images
+}
+
+// complete reports whether the config and every layer of a manifest can be
+// served; prune and gc remove layers without touching the manifest index
+func (s *Server) complete(m *cache.CachedManifest) bool {
+	if !s.exists(m.Digest) || !s.exists(m.Config) {
+		return false
+	}
+	for _, layer := range m.Layers {
+		if !s.exists(layer) {
+			return false
+		}
+	}
+	return true
+}
+
+// exists reports whether a blob, manifest or config is in one of the caches
+func (s *Server) exists(digest string) bool {
+	f, _, err := s.open(digest)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// open opens a blob of the blob cache, or a manifest or config of the
+// manifest cache
+func (s *Server) open(digest string) (io.ReadSeekCloser, time.Time, error) {
+	f, err := s.blobs.Open(digest)
+	if err != nil {
+		f, err = s.manifests.Open(digest)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+// ServeHTTP implements the pull side of the distribution API: the version
+// check, manifests, blobs, tag lists and the catalog
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "imgcd serve is read-only")
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/v2/")
+	switch {
+	case !ok && r.URL.Path != "/v2":
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "not a distribution API path")
+	case path == "":
+		writeJSON(w, r, struct{}{})
+	case path == "_catalog":
+		s.serveCatalog(w, r)
+	case strings.HasSuffix(path, "/tags/list"):
+		s.serveTags(w, r, strings.TrimSuffix(path, "/tags/list"))
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		s.serveManifest(w, r, path[:i], path[i+len("/manifests/"):])
+	case strings.Contains(path, "/blobs/"):
+		i := strings.LastIndex(path, "/blobs/")
+		s.serveBlob(w, r, path[i+len("/blobs/"):])
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "not a distribution API path")
+	}
+}
+
+func (s *Server) serveCatalog(w http.ResponseWriter, r *http.Request) {
+	repositories := []string{}
+	for _, image := range s.Images() {
+		if n := len(repositories); n == 0 || repositories[n-1] != image.Repository {
+			repositories = append(repositories, image.Repository)
+		}
+	}
+	writeJSON(w, r, map[string][]string{"repositories": repositories})
+}
+
+func (s *Server) serveTags(w http.ResponseWriter, r *http.Request, repository string) {
+	tags := []string{}
+	for _, image := range s.Images() {
+		if image.Repository == repository {
+			tags = append(tags, image.Tag)
+		}
+	}
+	if len(tags) == 0 {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", fmt.Sprintf("repository %s is not in the cache", repository))
+		return
+	}
+	writeJSON(w, r, map[string]any{"name": repository, "tags": tags})
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, repository, reference string) {
+	for _, image := range s.Images() {
+		if image.Repository != repository {
+			continue
+		}
+		content, mediaType, digest, err := s.manifest(image)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+
+		// A tag, the digest of the tag's manifest or index, or the digest
+		// of one of its platform manifests
+		switch reference {
+		case image.Tag, digest:
+		default:
+			content = nil
+			for _, m := range image.Manifests {
+				if m.Digest == reference {
+					content, err = s.read(m.Digest)
+					mediaType, digest = m.MediaType, m.Digest
+					break
+				}
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+				return
+			}
+			if content == nil {
+				continue
+			}
+		}
+
+		if r.Method == http.MethodGet {
+			slog.Info("pulled", "client", r.RemoteAddr, "image", repository+":"+image.Tag, "digest", digest)
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.Header().Set("Docker-Content-Digest", digest)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		return
+	}
+	writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", fmt.Sprintf("%s:%s is not in the cache", repository, reference))
+}
+
+// manifest returns what a tag resolves to: its manifest when one platform is
+// cached, else an OCI index of the cached platforms
+func (s *Server) manifest(image *Image) ([]byte, string, string, error) {
+	if len(image.Manifests) == 1 {
+		m := image.Manifests[0]
+		content, err := s.read(m.Digest)
+		return content, m.MediaType, m.Digest, err
+	}
+
+	index := v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+	}
+	for _, m := range image.Manifests {
+		digest, err := v1.NewHash(m.Digest)
+		if err != nil {
+			return nil, "", "", err
+		}
+		platform, err := v1.ParsePlatform(m.Platform)
+		if err != nil {
+			return nil, "", "", err
+		}
+		index.Manifests = append(index.Manifests, v1.Descriptor{
+			MediaType: types.MediaType(m.MediaType),
+			Size:      m.Size,
+			Digest:    digest,
+			Platform:  platform,
+		})
+	}
+	content, err := json.Marshal(index)
+	if err != nil {
+		return nil, "", "", err
+	}
+	sum := sha256.Sum256(content)
+	return content, string(types.OCIImageIndex), "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// read returns a cached manifest
+func (s *Server) read(digest string) ([]byte, error) {
+	f, _, err := s.open(digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", digest, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, digest string) {
+	f, modTime, err := s.open(digest)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", fmt.Sprintf("blob %s is not in the cache", digest))
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	// ServeContent answers range requests, so interrupted pulls resume
+	http.ServeContent(w, r, "", modTime, f)
+}
+
+// writeJSON answers with a JSON document
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// writeError answers with an error in the format of the distribution API
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
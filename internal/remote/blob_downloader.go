@@ -2,35 +2,58 @@ package remote
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"log/slog"
 	"sync"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/so2liu/imgcd/internal/cache"
+	"github.com/so2liu/imgcd/internal/progress"
+	"github.com/so2liu/imgcd/internal/ratelimit"
+	"github.com/so2liu/imgcd/internal/retry"
+	"github.com/so2liu/imgcd/internal/ui"
 )
 
 // BlobDownloader handles downloading compressed blobs from registry
 type BlobDownloader struct {
 	blobCache *cache.BlobCache
-	debug     bool
+	segments  SegmentOptions
+	peers     []string // imgcd serve URLs asked before the registry
+	limiter   *ratelimit.Limiter
+
+	sourcesMu sync.Mutex
+	sources   map[string][]blobSource // Image reference -> registry and mirrors, for segments
 }
 
 // NewBlobDownloader creates a new blob downloader
 func NewBlobDownloader(blobCache *cache.BlobCache) *BlobDownloader {
 	return &BlobDownloader{
 		blobCache: blobCache,
-		debug:     os.Getenv("IMGCD_DEBUG") != "",
 	}
 }
 
+// SetSegments makes large blobs download in ranged segments
+func (bd *BlobDownloader) SetSegments(opts SegmentOptions) {
+	bd.segments = opts
+}
+
+// SetRateLimit caps the bytes per second that all registry downloads of
+// bd share, segments included; 0 removes the cap. Blobs from peers, which
+// are on the local network, are not limited.
+func (bd *BlobDownloader) SetRateLimit(rate int64) {
+	bd.limiter = ratelimit.New(rate)
+}
+
 // DownloadResult represents the result of a blob download
 type DownloadResult struct {
 	Digest    string
 	DiffID    string
 	Size      int64
 	FromCache bool
+	Peer      string // imgcd serve instance the blob came from, if any
 	Err       error
 }
 
@@ -65,7 +88,7 @@ func (bd *BlobDownloader) DownloadBlobs(ctx context.Context, layers []v1.Layer,
 			}
 
 			// Download blob
-			result := bd.downloadSingleBlob(ctx, l, imageRef)
+			result := bd.downloadSingleBlob(ctx, l, imageRef, nil)
 			results[index] = result
 		}(i, layer)
 	}
@@ -82,8 +105,8 @@ func (bd *BlobDownloader) DownloadBlobs(ctx context.Context, layers []v1.Layer,
 	return results, nil
 }
 
-// downloadSingleBlob downloads a single blob
-func (bd *BlobDownloader) downloadSingleBlob(ctx context.Context, layer v1.Layer, imageRef string) DownloadResult {
+// downloadSingleBlob downloads a single blob, counting its bytes on bar
+func (bd *BlobDownloader) downloadSingleBlob(ctx context.Context, layer v1.Layer, imageRef string, bar *progress.Bar) DownloadResult {
 	// Get digest (compressed)
 	digest, err := layer.Digest()
 	if err != nil {
@@ -101,14 +124,14 @@ func (bd *BlobDownloader) downloadSingleBlob(ctx context.Context, layer v1.Layer
 
 	// Check cache first
 	if bd.blobCache.Exists(digestStr) {
-		if bd.debug {
-			fmt.Fprintf(os.Stderr, "[DEBUG] Blob %s already cached\n", digestStr[:19])
-		}
+		slog.Debug("blob cached", "digest", digestStr, "image", imageRef)
 
 		// Still update metadata to track this image reference
 		cachedReader, err := bd.blobCache.Get(digestStr)
 		if err == nil {
 			cachedReader.Close() // We just needed to update access time
+			bd.blobCache.AddImageRef(digestStr, imageRef)
+			bar.Cached()
 			return DownloadResult{
 				Digest:    digestStr,
 				DiffID:    diffIDStr,
@@ -117,59 +140,92 @@ func (bd *BlobDownloader) downloadSingleBlob(ctx context.Context, layer v1.Layer
 		}
 	}
 
-	if bd.debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Downloading blob %s...\n", digestStr[:19])
-	}
-
-	// Get compressed blob from registry
-	compressed, err := layer.Compressed()
-	if err != nil {
-		return DownloadResult{Err: fmt.Errorf("failed to get compressed layer: %w", err)}
-	}
-	defer compressed.Close()
-
 	// Get size
 	size, err := layer.Size()
 	if err != nil {
 		return DownloadResult{Err: fmt.Errorf("failed to get layer size: %w", err)}
 	}
 
-	// Download and cache blob (with digest verification inside Put)
-	if err := bd.blobCache.Put(digestStr, diffIDStr, compressed, imageRef); err != nil {
-		return DownloadResult{Err: fmt.Errorf("failed to cache blob: %w", err)}
+	slog.Debug("downloading blob", "digest", digestStr, "image", imageRef, "size", size)
+	peer := bd.downloadFromPeer(ctx, digest, diffID, imageRef, bar)
+	if peer == "" {
+		if err := bd.download(ctx, layer, digest, diffID, size, imageRef, bar); err != nil {
+			bar.Abort()
+			return DownloadResult{Err: err}
+		}
 	}
+	bar.Done()
 
-	if bd.debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Blob %s downloaded and cached (%d bytes)\n", digestStr[:19], size)
-	}
+	slog.Debug("downloaded blob", "digest", digestStr, "size", size, "peer", peer)
 
 	return DownloadResult{
 		Digest:    digestStr,
 		DiffID:    diffIDStr,
 		Size:      size,
 		FromCache: false,
+		Peer:      peer,
 	}
 }
 
-// DownloadProgressCallback is called with progress updates
-type DownloadProgressCallback func(completed, total int, currentBlob string)
+// download fetches a blob into the cache, in ranged segments when it is
+// large enough and SetSegments asked for them
+func (bd *BlobDownloader) download(ctx context.Context, layer v1.Layer, digest, diffID v1.Hash, size int64, imageRef string, bar *progress.Bar) error {
+	if segments := bd.segments.segments(size); segments > 1 && bd.blobCache.Enabled() {
+		err := bd.downloadSegmented(ctx, digest, diffID, size, imageRef, segments, bar)
+		if !errors.Is(err, errRangeUnsupported) {
+			return err
+		}
+		ui.Warning("%s; downloading %s in one piece", err, digest.String()[:19])
+	}
+
+	// A transfer that breaks midway starts over; the requests themselves
+	// are retried by RetryRequests
+	policy := retry.DefaultPolicy
+	policy.OnRetry = func(attempt int, delay time.Duration, err error) {
+		ui.Warning("download of %s failed (%v), retrying in %s", digest.String()[:19], err, delay)
+	}
+	return retry.Do(ctx, policy, func(int) error {
+		bar.Restart()
 
-// DownloadBlobsWithProgress downloads blobs with progress reporting
+		// Get compressed blob from registry
+		compressed, err := layer.Compressed()
+		if err != nil {
+			return transient(fmt.Errorf("failed to get compressed layer: %w", err))
+		}
+		defer compressed.Close()
+
+		// Download and cache blob (with digest verification inside Put)
+		if err := bd.blobCache.Put(digest.String(), diffID.String(), io.TeeReader(bd.limiter.Reader(ctx, compressed), bar), imageRef); err != nil {
+			return transient(fmt.Errorf("failed to cache blob: %w", err))
+		}
+		return nil
+	})
+}
+
+// DownloadBlobsWithProgress downloads blobs like DownloadBlobs, showing
+// their progress on out: a bar per blob in flight and one for all of them
+// on terminals, a line per finished blob elsewhere
 func (bd *BlobDownloader) DownloadBlobsWithProgress(
 	ctx context.Context,
 	layers []v1.Layer,
 	imageRef string,
 	maxConcurrency int,
-	progressCallback DownloadProgressCallback,
+	out io.Writer,
 ) ([]DownloadResult, error) {
 	if maxConcurrency <= 0 {
 		maxConcurrency = 4
 	}
 
+	var total int64
+	for _, layer := range layers {
+		if size, err := layer.Size(); err == nil {
+			total += size
+		}
+	}
+	display := progress.New(out, "Downloaded", len(layers), total)
+
 	results := make([]DownloadResult, len(layers))
 	var wg sync.WaitGroup
-	var completed int
-	var mu sync.Mutex
 	sem := make(chan struct{}, maxConcurrency)
 
 	for i, layer := range layers {
@@ -189,30 +245,17 @@ func (bd *BlobDownloader) DownloadBlobsWithProgress(
 			default:
 			}
 
-			// Get digest for progress reporting
 			digest, _ := l.Digest()
-			digestStr := ""
-			if digest.String() != "" {
-				digestStr = digest.String()
-			}
+			size, _ := l.Size()
+			bar := display.Start(shortDigest(digest.String()), size)
 
 			// Download blob
-			result := bd.downloadSingleBlob(ctx, l, imageRef)
-			results[index] = result
-
-			// Update progress
-			mu.Lock()
-			completed++
-			current := completed
-			mu.Unlock()
-
-			if progressCallback != nil {
-				progressCallback(current, len(layers), digestStr)
-			}
+			results[index] = bd.downloadSingleBlob(ctx, l, imageRef, bar)
 		}(i, layer)
 	}
 
 	wg.Wait()
+	display.Finish()
 
 	// Check for errors
 	for i, result := range results {
@@ -224,6 +267,14 @@ func (bd *BlobDownloader) DownloadBlobsWithProgress(
 	return results, nil
 }
 
+// shortDigest shortens a digest to its algorithm and 12 hex digits
+func shortDigest(digest string) string {
+	if len(digest) > 19 {
+		return digest[:19]
+	}
+	return digest
+}
+
 // GetCachedBlobReader returns a reader for a cached blob
 func (bd *BlobDownloader) GetCachedBlobReader(digest string) (io.ReadCloser, error) {
 	return bd.blobCache.Get(digest)
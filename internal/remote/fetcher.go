@@ -3,7 +3,7 @@ package remote
 import (
 	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -45,12 +45,8 @@ func NewFetcher(opts ...remote.Option) *Fetcher {
 
 // FetchImageMetadata retrieves image metadata from a remote registry without downloading layers
 func (f *Fetcher) FetchImageMetadata(ctx context.Context, imageRef string, platformSpec string) (*ImageMetadata, error) {
-	debug := os.Getenv("IMGCD_DEBUG") != ""
 	startTime := time.Now()
-
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Fetching metadata for %s (%s)\n", imageRef, platformSpec)
-	}
+	slog.Debug("fetching image metadata", "ref", imageRef, "platform", platformSpec)
 
 	// Parse the image reference
 	ref, err := name.ParseReference(imageRef)
@@ -78,9 +74,7 @@ func (f *Fetcher) FetchImageMetadata(ctx context.Context, imageRef string, platf
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch image descriptor: %w", err)
 	}
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG]   remote.Get: %v\n", time.Since(t1))
-	}
+	slog.Debug("fetched descriptor", "ref", imageRef, "duration", time.Since(t1))
 
 	// Get the image from the descriptor
 	t2 := time.Now()
@@ -88,9 +82,7 @@ func (f *Fetcher) FetchImageMetadata(ctx context.Context, imageRef string, platf
 	if err != nil {
 		return nil, fmt.Errorf("failed to get image from descriptor: %w", err)
 	}
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG]   desc.Image: %v\n", time.Since(t2))
-	}
+	slog.Debug("resolved image", "ref", imageRef, "duration", time.Since(t2))
 
 	// Get the image digest
 	digest, err := img.Digest()
@@ -104,9 +96,7 @@ func (f *Fetcher) FetchImageMetadata(ctx context.Context, imageRef string, platf
 	if err != nil {
 		return nil, fmt.Errorf("failed to get config file: %w", err)
 	}
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG]   img.ConfigFile: %v\n", time.Since(t3))
-	}
+	slog.Debug("fetched config", "ref", imageRef, "duration", time.Since(t3))
 
 	// Get layers
 	t4 := time.Now()
@@ -114,9 +104,7 @@ func (f *Fetcher) FetchImageMetadata(ctx context.Context, imageRef string, platf
 	if err != nil {
 		return nil, fmt.Errorf("failed to get layers: %w", err)
 	}
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG]   img.Layers: %v\n", time.Since(t4))
-	}
+	slog.Debug("listed layers", "ref", imageRef, "duration", time.Since(t4))
 
 	// Extract layer metadata
 	t5 := time.Now()
@@ -156,10 +144,8 @@ func (f *Fetcher) FetchImageMetadata(ctx context.Context, imageRef string, platf
 			Command: command,
 		})
 	}
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG]   Extract layer metadata (%d layers): %v\n", len(layers), time.Since(t5))
-		fmt.Fprintf(os.Stderr, "[DEBUG] Total fetch time for %s: %v\n", imageRef, time.Since(startTime))
-	}
+	slog.Debug("read layer metadata", "ref", imageRef, "layers", len(layers), "duration", time.Since(t5))
+	slog.Debug("fetched image metadata", "ref", imageRef, "digest", digest.String(), "duration", time.Since(startTime))
 
 	return &ImageMetadata{
 		Reference:  imageRef,
@@ -0,0 +1,304 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// maxCachedMetadata bounds the manifests and configs CacheMetadata keeps;
+// larger ones are passed through
+const maxCachedMetadata = 4 << 20
+
+// CacheMetadata answers the manifest and config requests of registry
+// operations from dir for ttl after they were first made, so repeated
+// diffs and saves of the same references do not ask the registry
+// again. Manifests and configs are stored by digest; tags resolve to the
+// digest they had when fetched, until ttl passes. Layers are not cached
+// here (that is the blob cache), and ping and token requests still go out.
+func CacheMetadata(dir string, ttl time.Duration) error {
+	c := &metadataCache{dir: dir, ttl: ttl, configs: make(map[string]bool)}
+	if err := os.MkdirAll(c.refsDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create metadata cache: %w", err)
+	}
+	c.expire()
+	remote.DefaultTransport = &metadataTransport{next: remote.DefaultTransport, cache: c}
+	return nil
+}
+
+// metadataCache is the on-disk cache of CacheMetadata: content by digest in
+// sha256/, and what each manifest request was answered with in refs/
+type metadataCache struct {
+	dir string
+	ttl time.Duration
+
+	mu      sync.Mutex
+	configs map[string]bool // Config digests of the manifests seen
+}
+
+// metadataRef records the answer to a manifest request
+type metadataRef struct {
+	URL       string    `json:"url"`
+	Accept    string    `json:"accept"`
+	Digest    string    `json:"digest"`
+	MediaType string    `json:"media_type"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// metadataTransport answers the requests it can from the cache and fills it
+// with the answers of next
+type metadataTransport struct {
+	next  http.RoundTripper
+	cache *metadataCache
+}
+
+func (t *metadataTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || req.Header.Get("Range") != "" {
+		return t.next.RoundTrip(req)
+	}
+	kind, reference, ok := registryPath(req.URL.Path)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	switch kind {
+	case "manifests":
+		if ref, data, ok := t.cache.manifest(req); ok {
+			return cachedResponse(req, data, ref.MediaType, ref.Digest), nil
+		}
+	case "blobs":
+		if data, ok := t.cache.content(reference); ok {
+			return cachedResponse(req, data, "application/octet-stream", reference), nil
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || req.Method != http.MethodGet {
+		return resp, err
+	}
+	if kind == "blobs" && !t.cache.isConfig(reference) {
+		return resp, nil
+	}
+	return t.cache.fill(req, resp, kind, reference)
+}
+
+// registryPath splits a registry API path, /v2/<name>/manifests/<reference>
+// or /v2/<name>/blobs/<digest>, into its kind and reference
+func registryPath(path string) (kind, reference string, ok bool) {
+	if !strings.HasPrefix(path, "/v2/") {
+		return "", "", false
+	}
+	for _, kind := range []string{"manifests", "blobs"} {
+		if i := strings.LastIndex(path, "/"+kind+"/"); i > len("/v2") {
+			reference = path[i+len(kind)+2:]
+			if reference == "" || strings.Contains(reference, "/") {
+				return "", "", false
+			}
+			if kind == "blobs" && !strings.HasPrefix(reference, "sha256:") {
+				return "", "", false
+			}
+			return kind, reference, true
+		}
+	}
+	return "", "", false
+}
+
+// manifest returns the cached answer to a manifest request, unless it is
+// older than the TTL
+func (c *metadataCache) manifest(req *http.Request) (*metadataRef, []byte, bool) {
+	data, err := os.ReadFile(c.refPath(req))
+	if err != nil {
+		return nil, nil, false
+	}
+	var ref metadataRef
+	if err := json.Unmarshal(data, &ref); err != nil || time.Since(ref.FetchedAt) > c.ttl {
+		return nil, nil, false
+	}
+	content, ok := c.content(ref.Digest)
+	if !ok {
+		return nil, nil, false
+	}
+	c.noteConfig(content)
+	return &ref, content, true
+}
+
+// content returns what is stored under a digest, if it is younger than the
+// TTL and still matches the digest
+func (c *metadataCache) content(digest string) ([]byte, bool) {
+	path := c.contentPath(digest)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > c.ttl {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || sha256Digest(data) != digest {
+		return nil, false
+	}
+	return data, true
+}
+
+// isConfig reports whether a blob is the config of a manifest seen before,
+// the only blobs worth keeping here
+func (c *metadataCache) isConfig(digest string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.configs[digest]
+}
+
+// noteConfig remembers the config digest of a manifest; indexes have none
+func (c *metadataCache) noteConfig(manifest []byte) {
+	var m struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if json.Unmarshal(manifest, &m) != nil || m.Config.Digest == "" {
+		return
+	}
+	c.mu.Lock()
+	c.configs[m.Config.Digest] = true
+	c.mu.Unlock()
+}
+
+// fill stores the answer to a manifest or config request and returns the
+// response with its body read into memory
+func (c *metadataCache) fill(req *http.Request, resp *http.Response, kind, reference string) (*http.Response, error) {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedMetadata+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(data) > maxCachedMetadata {
+		// Too large to keep, hand it on as it is
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	digest := sha256Digest(data)
+	switch {
+	case kind == "blobs" && digest != reference:
+		return resp, nil
+	case kind == "manifests" && strings.HasPrefix(reference, "sha256:") && digest != reference:
+		return resp, nil
+	case kind == "manifests" && resp.Header.Get("Docker-Content-Digest") != "" && resp.Header.Get("Docker-Content-Digest") != digest:
+		return resp, nil
+	}
+
+	// The cache only saves round trips; failing to write it fails nothing
+	if err := c.write(digest, data); err != nil {
+		return resp, nil
+	}
+	if kind == "manifests" {
+		c.noteConfig(data)
+		ref := metadataRef{
+			URL:       req.URL.Redacted(),
+			Accept:    req.Header.Get("Accept"),
+			Digest:    digest,
+			MediaType: resp.Header.Get("Content-Type"),
+			FetchedAt: time.Now(),
+		}
+		if encoded, err := json.Marshal(ref); err == nil {
+			writeFileAtomic(c.refPath(req), encoded)
+		}
+	}
+	return resp, nil
+}
+
+// write stores content under its digest; rewriting it restarts its TTL
+func (c *metadataCache) write(digest string, data []byte) error {
+	path := c.contentPath(digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// expire removes what is older than the TTL
+func (c *metadataCache) expire() {
+	for _, dir := range []string{c.refsDir(), filepath.Join(c.dir, "sha256")} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > c.ttl {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+}
+
+func (c *metadataCache) refsDir() string {
+	return filepath.Join(c.dir, "refs")
+}
+
+// refPath is the record of a manifest request: manifests of a tag differ
+// by the media types asked for
+func (c *metadataCache) refPath(req *http.Request) string {
+	key := sha256.Sum256([]byte(req.URL.Host + req.URL.Path + "\n" + req.Header.Get("Accept")))
+	return filepath.Join(c.refsDir(), hex.EncodeToString(key[:])+".json")
+}
+
+func (c *metadataCache) contentPath(digest string) string {
+	return filepath.Join(c.dir, "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// cachedResponse is the answer to req from the cache
+func cachedResponse(req *http.Request, data []byte, mediaType, digest string) *http.Response {
+	header := http.Header{}
+	header.Set("Content-Type", mediaType)
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	header.Set("Docker-Content-Digest", digest)
+
+	body := io.NopCloser(bytes.NewReader(data))
+	if req.Method == http.MethodHead {
+		body = http.NoBody
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes a file aside and renames it into place, so other
+// imgcd processes never read it half-written
+func writeFileAtomic(path string, data []byte) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/so2liu/imgcd/internal/progress"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// peerClient asks peers for blobs; peers that do not answer quickly are not
+// worth waiting for, the registry is always there
+var peerClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 2 * time.Second}).DialContext,
+		ResponseHeaderTimeout: 5 * time.Second,
+	},
+}
+
+// SetPeers makes blobs be asked from other imgcd serve instances, given as
+// URLs or host:port, before they are downloaded from the registry
+func (bd *BlobDownloader) SetPeers(peers []string) {
+	bd.peers = nil
+	for _, peer := range peers {
+		if !strings.Contains(peer, "://") {
+			peer = "http://" + peer
+		}
+		bd.peers = append(bd.peers, strings.TrimSuffix(peer, "/"))
+	}
+}
+
+// downloadFromPeer fetches a blob from the first peer that has it into the
+// cache, whose digest check guards against a peer serving anything else. It
+// returns the peer, or "" when none had the blob.
+func (bd *BlobDownloader) downloadFromPeer(ctx context.Context, digest, diffID v1.Hash, imageRef string, bar *progress.Bar) string {
+	if len(bd.peers) == 0 || !bd.blobCache.Enabled() {
+		return ""
+	}
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return ""
+	}
+
+	for _, peer := range bd.peers {
+		// imgcd serve serves blobs under the repository path without the registry
+		url := fmt.Sprintf("%s/v2/%s/blobs/%s", peer, ref.Context().RepositoryStr(), digest)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			continue
+		}
+		resp, err := peerClient.Do(req)
+		if err != nil {
+			slog.Debug("peer unreachable", "peer", peer, "error", err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			slog.Debug("peer lacks blob", "peer", peer, "digest", digest.String(), "status", resp.StatusCode)
+			resp.Body.Close()
+			continue
+		}
+		err = bd.blobCache.Put(digest.String(), diffID.String(), io.TeeReader(resp.Body, bar), imageRef)
+		resp.Body.Close()
+		if err != nil {
+			bar.Restart()
+			ui.Warning("blob %s from peer %s: %v", digest.String()[:19], peer, err)
+			continue
+		}
+		return peer
+	}
+	return ""
+}
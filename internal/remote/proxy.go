@@ -0,0 +1,133 @@
+package remote
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// DirectProxy is the proxy value that sends a host's requests straight to
+// it, past HTTPS_PROXY
+const DirectProxy = "direct"
+
+// ProxyRegistries sends the requests to each host in proxies through the
+// proxy it maps to (an http, https or socks5 URL, or DirectProxy). Hosts are
+// matched with their port first, then without it; *.example.com matches the
+// subdomains of example.com. A redirect, e.g. to a registry's blob storage,
+// keeps the proxy of the host that redirected unless its target has one of
+// its own. Other hosts use HTTPS_PROXY, HTTP_PROXY and NO_PROXY as before.
+// It replaces the transports the other wrappers build on, so it must be
+// called before them.
+func ProxyRegistries(proxies map[string]string) error {
+	if len(proxies) == 0 {
+		return nil
+	}
+	p := &hostProxies{exact: make(map[string]*url.URL)}
+	for host, value := range proxies {
+		proxyURL, err := parseProxy(value)
+		if err != nil {
+			return fmt.Errorf("invalid proxy for %s: %w", host, err)
+		}
+		host = strings.ToLower(host)
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			p.wildcards = append(p.wildcards, wildcardProxy{suffix: "." + suffix, proxy: proxyURL})
+		} else {
+			p.exact[host] = proxyURL
+		}
+	}
+
+	// go-containerregistry uses its own default transport; segmented
+	// downloads use net/http's
+	remote.DefaultTransport = proxiedTransport(remote.DefaultTransport, p.proxy)
+	http.DefaultTransport = proxiedTransport(http.DefaultTransport, p.proxy)
+	return nil
+}
+
+// parseProxy parses a proxy URL; DirectProxy gives nil
+func parseProxy(value string) (*url.URL, error) {
+	if value == DirectProxy {
+		return nil, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%q is not an http, https or socks5 URL or %s", value, DirectProxy)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q has no host", value)
+	}
+	return u, nil
+}
+
+// proxiedTransport returns a copy of rt that picks proxies with proxy
+func proxiedTransport(rt http.RoundTripper, proxy func(*http.Request) (*url.URL, error)) http.RoundTripper {
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	t = t.Clone()
+	t.Proxy = proxy
+	return t
+}
+
+// hostProxies are the proxies of ProxyRegistries; a nil proxy is direct
+type hostProxies struct {
+	exact     map[string]*url.URL // host:port or host
+	wildcards []wildcardProxy
+}
+
+// wildcardProxy is the proxy of the subdomains of a domain
+type wildcardProxy struct {
+	suffix string // .example.com
+	proxy  *url.URL
+}
+
+// proxy is the http.Transport Proxy function of ProxyRegistries
+func (p *hostProxies) proxy(req *http.Request) (*url.URL, error) {
+	// The request itself, then the requests that redirected to it
+	for r := req; r != nil; {
+		if proxyURL, ok := p.lookup(r.URL); ok {
+			return proxyURL, nil
+		}
+		if r.Response == nil {
+			break
+		}
+		r = r.Response.Request
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// lookup finds the proxy configured for the host of u
+func (p *hostProxies) lookup(u *url.URL) (*url.URL, bool) {
+	host := strings.ToLower(u.Host)
+	if proxyURL, ok := p.exact[host]; ok {
+		return proxyURL, true
+	}
+	hostname := strings.ToLower(u.Hostname())
+	if proxyURL, ok := p.exact[hostname]; ok {
+		return proxyURL, true
+	}
+	if net.ParseIP(hostname) != nil {
+		return nil, false
+	}
+
+	// The longest matching domain is the most specific
+	var match *wildcardProxy
+	for i, w := range p.wildcards {
+		if strings.HasSuffix(hostname, w.suffix) && (match == nil || len(w.suffix) > len(match.suffix)) {
+			match = &p.wildcards[i]
+		}
+	}
+	if match == nil {
+		return nil, false
+	}
+	return match.proxy, true
+}
@@ -0,0 +1,104 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/so2liu/imgcd/internal/retry"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// RetryRequests makes the GET and HEAD requests of registry operations, the
+// manifest, config and layer requests of fetches, saves and pulls, retry
+// timeouts, 429 and 5xx responses as retry.DefaultPolicy says (--retries,
+// --retry-delay), honoring Retry-After. A request that still fails after the
+// last attempt ends in an error go-containerregistry does not retry again.
+func RetryRequests() {
+	remote.DefaultTransport = &retryTransport{next: remote.DefaultTransport}
+}
+
+// retryTransport retries the idempotent requests it passes on to next
+type retryTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || req.Body != nil && req.Body != http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+
+	policy := retry.DefaultPolicy
+	policy.OnRetry = func(attempt int, delay time.Duration, err error) {
+		ui.Warning("%s %s failed (%v), retrying in %s", req.Method, traceURL(req.URL), err, delay)
+	}
+
+	var resp *http.Response
+	err := retry.Do(req.Context(), policy, func(attempt int) error {
+		var err error
+		resp, err = t.next.RoundTrip(req)
+		if err != nil {
+			return transient(err)
+		}
+		if !retryableStatus(resp.StatusCode) {
+			return nil
+		}
+		err = fmt.Errorf("%s", resp.Status)
+		delay := retry.ServerDelay(resp)
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		return retry.After(err, delay)
+	})
+	if err == nil {
+		return resp, nil
+	}
+	if req.Context().Err() != nil {
+		return nil, err
+	}
+	// Formatted with %v, so go-containerregistry does not take the error as
+	// temporary and retry on its own; net/http adds method and URL
+	if policy.Attempts > 1 {
+		return nil, fmt.Errorf("%v (gave up after %d attempts)", err, policy.Attempts)
+	}
+	return nil, fmt.Errorf("%v", err)
+}
+
+// retryableStatus reports whether a response status is worth another try
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// transientError reports whether a failed request or transfer may succeed
+// when tried again: timeouts, broken connections and 408, 429 and 5xx
+// answers
+func transientError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var registryErr *transport.Error
+	if errors.As(err, &registryErr) {
+		return retryableStatus(registryErr.StatusCode)
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, net.ErrClosed)
+}
+
+// transient marks err as permanent for retry.Do unless transientError
+// says it may pass
+func transient(err error) error {
+	if !transientError(err) {
+		return retry.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/so2liu/imgcd/internal/progress"
+	"github.com/so2liu/imgcd/internal/ratelimit"
+	"github.com/so2liu/imgcd/internal/retry"
+	"github.com/so2liu/imgcd/internal/ui"
+)
+
+// SegmentOptions makes large blobs download as several ranged requests at
+// once, from the image's registry and its mirrors in turn, which fills
+// high-latency links that a single stream cannot
+type SegmentOptions struct {
+	Segments int      // Ranged requests per blob; 1 or less downloads blobs whole
+	MinSize  int64    // Smaller blobs are downloaded whole
+	Mirrors  []string // Registries serving the same repositories, e.g. mirror.local:5000
+}
+
+// DefaultSegmentMinSize is the smallest blob downloaded in segments unless
+// SegmentOptions.MinSize says otherwise
+const DefaultSegmentMinSize = 64 << 20
+
+// errRangeUnsupported reports a registry answering a ranged request with the
+// whole blob
+var errRangeUnsupported = errors.New("registry does not support ranged blob requests")
+
+// segments returns the number of segments for a blob of size, 0 or 1 for a
+// plain download
+func (o SegmentOptions) segments(size int64) int {
+	n := o.Segments
+	if n <= 1 && len(o.Mirrors) > 0 {
+		n = 1 + len(o.Mirrors) // Mirrors alone mean one segment from each
+	}
+	minSize := o.MinSize
+	if minSize <= 0 {
+		minSize = DefaultSegmentMinSize
+	}
+	if n <= 1 || size < minSize {
+		return 0
+	}
+	if int64(n) > size {
+		n = int(size)
+	}
+	return n
+}
+
+// blobSource is a repository blobs can be requested from
+type blobSource struct {
+	client *http.Client
+	url    string // Repository URL, to which /blobs/<digest> is added
+}
+
+// downloadSegmented downloads a blob in ranged segments into a temporary
+// file of the blob cache and moves it into the cache once its digest is
+// verified. Segments failing on one source are retried on the next.
+func (bd *BlobDownloader) downloadSegmented(ctx context.Context, digest, diffID v1.Hash, size int64, imageRef string, segments int, bar *progress.Bar) error {
+	sources, err := bd.blobSources(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	slog.Debug("downloading blob in segments", "digest", digest.String(), "segments", segments, "sources", len(sources))
+
+	file, err := bd.blobCache.TempFile()
+	if err != nil {
+		return fmt.Errorf("failed to create download file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to allocate download file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, segments)
+	var wg sync.WaitGroup
+	segmentSize := (size + int64(segments) - 1) / int64(segments)
+	for i := 0; i < segments; i++ {
+		start := int64(i) * segmentSize
+		end := min(start+segmentSize, size) - 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = retry.Do(ctx, retry.DefaultPolicy, func(attempt int) error {
+				source := sources[(i+attempt-1)%len(sources)]
+				err := fetchRange(ctx, source, digest, io.MultiWriter(io.NewOffsetWriter(file, start), bar), start, end, bd.limiter)
+				if errors.Is(err, errRangeUnsupported) {
+					return retry.Permanent(err)
+				}
+				return err
+			})
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		if errors.Is(err, errRangeUnsupported) {
+			return errRangeUnsupported
+		}
+		return fmt.Errorf("failed to download segments: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return bd.blobCache.PutFile(digest.String(), diffID.String(), file.Name(), imageRef)
+}
+
+// blobSources returns the repository of the image on its registry and on
+// each reachable mirror, with clients authenticated for pulling. They are
+// looked up once per image.
+func (bd *BlobDownloader) blobSources(ctx context.Context, imageRef string) ([]blobSource, error) {
+	bd.sourcesMu.Lock()
+	defer bd.sourcesMu.Unlock()
+	if sources, ok := bd.sources[imageRef]; ok {
+		return sources, nil
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference: %w", err)
+	}
+	repos := []name.Repository{ref.Context()}
+	for _, mirror := range bd.segments.Mirrors {
+		repo, err := name.NewRepository(strings.TrimSuffix(mirror, "/") + "/" + ref.Context().RepositoryStr())
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirror %s: %w", mirror, err)
+		}
+		repos = append(repos, repo)
+	}
+
+	var sources []blobSource
+	for _, repo := range repos {
+		auth, err := authn.DefaultKeychain.Resolve(repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve credentials for %s: %w", repo.RegistryStr(), err)
+		}
+		rt, err := transport.NewWithContext(ctx, repo.Registry, auth, http.DefaultTransport, []string{repo.Scope(transport.PullScope)})
+		if err != nil {
+			// An unreachable mirror leaves the others to do the work
+			ui.Warning("skipping %s: %v", repo.RegistryStr(), err)
+			continue
+		}
+		sources = append(sources, blobSource{
+			client: &http.Client{Transport: rt},
+			url:    fmt.Sprintf("%s://%s/v2/%s", repo.Scheme(), repo.RegistryStr(), repo.RepositoryStr()),
+		})
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no registry of %s is reachable", ref.Context().RepositoryStr())
+	}
+	if bd.sources == nil {
+		bd.sources = make(map[string][]blobSource)
+	}
+	bd.sources[imageRef] = sources
+	return sources, nil
+}
+
+// fetchRange writes the bytes start to end (inclusive) of a blob to w, at
+// the pace of limiter
+func fetchRange(ctx context.Context, source blobSource, digest v1.Hash, w io.Writer, start, end int64, limiter *ratelimit.Limiter) error {
+	url := source.url + "/blobs/" + digest.String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := source.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return errRangeUnsupported
+	default:
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	written, err := io.Copy(w, limiter.Reader(ctx, io.LimitReader(resp.Body, end-start+1)))
+	if err != nil {
+		return err
+	}
+	if written != end-start+1 {
+		return fmt.Errorf("GET %s: short segment: %d of %d bytes", url, written, end-start+1)
+	}
+	return nil
+}
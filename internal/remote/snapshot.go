@@ -0,0 +1,245 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// snapshotVersion is the format version of metadata snapshot files
+const snapshotVersion = "1"
+
+// Snapshot holds what the registry answered to the metadata requests of a
+// set of images: their manifests (every platform of an index), configs and
+// tag lists. Fetchers given its Options answer from it alone, so diff can
+// compare those images without a connection, e.g. inside an air gap with a
+// snapshot exported outside.
+type Snapshot struct {
+	Version    string                      `json:"version"`
+	CreatedAt  time.Time                   `json:"created_at"`
+	References []string                    `json:"references"`
+	Manifests  map[string]snapshotManifest `json:"manifests"` // registry host and manifest path -> answer
+	Tags       map[string][]string         `json:"tags"`      // registry host and repository -> tags
+	Content    map[string][]byte           `json:"content"`   // Digest -> manifest or config
+
+	path string
+	mu   sync.Mutex
+}
+
+// snapshotManifest records the answer to a manifest request
+type snapshotManifest struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"media_type"`
+}
+
+// ExportSnapshot fetches the manifests, configs and tag lists of images
+// into a snapshot
+func ExportSnapshot(ctx context.Context, refs []string) (*Snapshot, error) {
+	s := &Snapshot{
+		Version:   snapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Manifests: make(map[string]snapshotManifest),
+		Tags:      make(map[string][]string),
+		Content:   make(map[string][]byte),
+	}
+	opts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithTransport(&snapshotRecorder{next: remote.DefaultTransport, snapshot: s}),
+	}
+
+	for _, imageRef := range refs {
+		ref, err := name.ParseReference(imageRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse image reference %q: %w", imageRef, err)
+		}
+		if err := s.fetch(ref, opts); err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", imageRef, err)
+		}
+
+		repo := ref.Context()
+		tags, err := remote.List(repo, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of %s: %w", repo, err)
+		}
+		s.Tags[repo.RegistryStr()+"/"+repo.RepositoryStr()] = tags
+		s.References = append(s.References, ref.Name())
+	}
+	return s, nil
+}
+
+// fetch reads the manifest and config of an image, of every image of an
+// index, through the recorder
+func (s *Snapshot) fetch(ref name.Reference, opts []remote.Option) error {
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return err
+	}
+	if !desc.MediaType.IsIndex() {
+		img, err := desc.Image()
+		if err != nil {
+			return err
+		}
+		_, err = img.RawConfigFile()
+		return err
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, child := range manifest.Manifests {
+		if !child.MediaType.IsImage() {
+			continue
+		}
+		img, err := remote.Image(ref.Context().Digest(child.Digest.String()), opts...)
+		if err != nil {
+			return err
+		}
+		if _, err := img.RawConfigFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save writes the snapshot to path
+func (s *Snapshot) Save(path string) error {
+	sort.Strings(s.References)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot reads a snapshot written by Save
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata snapshot: %w", err)
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata snapshot %s: %w", path, err)
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported metadata snapshot version: %s (expected %s)", s.Version, snapshotVersion)
+	}
+	s.path = path
+	return s, nil
+}
+
+// Options makes a Fetcher answer from the snapshot instead of the network;
+// whatever the snapshot lacks fails
+func (s *Snapshot) Options() []remote.Option {
+	return []remote.Option{remote.WithTransport(&snapshotTransport{snapshot: s})}
+}
+
+// snapshotRecorder records the manifests and blobs next answers
+type snapshotRecorder struct {
+	next     http.RoundTripper
+	snapshot *Snapshot
+}
+
+func (r *snapshotRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	kind, reference, ok := registryPath(req.URL.Path)
+	if !ok {
+		return resp, nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	digest := sha256Digest(data)
+	if kind == "blobs" && digest != reference {
+		return nil, fmt.Errorf("blob %s: content is %s", reference, digest)
+	}
+	s := r.snapshot
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Content[digest] = data
+	if kind == "manifests" {
+		s.Manifests[req.URL.Host+req.URL.Path] = snapshotManifest{Digest: digest, MediaType: resp.Header.Get("Content-Type")}
+	}
+	return resp, nil
+}
+
+// snapshotTransport answers registry requests from a snapshot: pings as an
+// anonymous registry, manifests, configs and tag lists as recorded
+type snapshotTransport struct {
+	snapshot *Snapshot
+}
+
+func (t *snapshotTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s := t.snapshot
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	if path == "/v2" {
+		return cachedResponse(req, []byte("{}"), "application/json", ""), nil
+	}
+
+	if repo, ok := strings.CutSuffix(strings.TrimPrefix(path, "/v2/"), "/tags/list"); ok {
+		if tags, ok := s.Tags[req.URL.Host+"/"+repo]; ok {
+			data, err := json.Marshal(struct {
+				Name string   `json:"name"`
+				Tags []string `json:"tags"`
+			}{repo, tags})
+			if err != nil {
+				return nil, err
+			}
+			return cachedResponse(req, data, "application/json", ""), nil
+		}
+	} else if kind, reference, ok := registryPath(req.URL.Path); ok {
+		digest, mediaType := reference, "application/octet-stream"
+		if kind == "manifests" {
+			if manifest, ok := s.Manifests[req.URL.Host+req.URL.Path]; ok {
+				digest, mediaType = manifest.Digest, manifest.MediaType
+			} else if manifest, ok := s.manifestByDigest(reference); ok {
+				mediaType = manifest.MediaType
+			}
+		}
+		if data, ok := s.Content[digest]; ok {
+			return cachedResponse(req, data, mediaType, digest), nil
+		}
+	}
+	return nil, fmt.Errorf("%s%s is not in the metadata snapshot %s (exported %s); export it with imgcd metadata export",
+		req.URL.Host, req.URL.Path, s.path, s.CreatedAt.Local().Format("2006-01-02 15:04"))
+}
+
+// manifestByDigest finds a recorded manifest by its digest, e.g. an image
+// of an index exported by tag
+func (s *Snapshot) manifestByDigest(digest string) (snapshotManifest, bool) {
+	for _, manifest := range s.Manifests {
+		if manifest.Digest == digest {
+			return manifest, true
+		}
+	}
+	return snapshotManifest{}, false
+}
@@ -0,0 +1,137 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// TraceHTTP logs every HTTP request to the file at path (appended to), one
+// line each: method, URL, status, bytes sent and received, duration and,
+// for requests repeated after a failure, the retry number. Besides registry
+// requests, token requests included, this covers segmented downloads and
+// http(s) destinations. Query strings are left out, as they can carry
+// credentials.
+func TraceHTTP(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open HTTP trace file: %w", err)
+	}
+	log := &traceLog{w: f, failures: make(map[string]int)}
+
+	// go-containerregistry uses its own default transport; segmented
+	// downloads use net/http's
+	remote.DefaultTransport = &tracingTransport{next: remote.DefaultTransport, log: log}
+	http.DefaultTransport = &tracingTransport{next: http.DefaultTransport, log: log}
+	return nil
+}
+
+// traceLog writes the trace lines and counts consecutive failures of the
+// same request to number its retries
+type traceLog struct {
+	mu       sync.Mutex
+	w        io.Writer
+	failures map[string]int // method and URL -> failed attempts in a row
+}
+
+// tracingTransport logs the requests it passes on to next
+type tracingTransport struct {
+	next http.RoundTripper
+	log  *traceLog
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.log.record(req, nil, 0, time.Since(start), err)
+		return nil, err
+	}
+	// The line is written when the body is done with, so the duration and
+	// size cover the transfer
+	resp.Body = &tracedBody{ReadCloser: resp.Body, done: func(n int64, err error) {
+		t.log.record(req, resp, n, time.Since(start), err)
+	}}
+	return resp, nil
+}
+
+// tracedBody counts the bytes read from a response body and reports them
+// once, on EOF, error or Close
+type tracedBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64, err error)
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.n, nil) })
+	} else if err != nil {
+		b.once.Do(func() { b.done(b.n, err) })
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	b.once.Do(func() { b.done(b.n, nil) })
+	return b.ReadCloser.Close()
+}
+
+func (l *traceLog) record(req *http.Request, resp *http.Response, received int64, elapsed time.Duration, err error) {
+	target := traceURL(req.URL)
+	// Without the scheme, so the plain HTTP request after a failed HTTPS
+	// one is not counted as its retry
+	key := req.Method + " " + req.URL.Host + req.URL.Path
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s %s %s", time.Now().UTC().Format(time.RFC3339Nano), req.Method, target)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if retry := l.failures[key]; retry > 0 {
+		fmt.Fprintf(&line, " retry=%d", retry)
+	}
+	failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	if failed {
+		l.failures[key]++
+	} else {
+		delete(l.failures, key)
+	}
+
+	if resp != nil {
+		fmt.Fprintf(&line, " status=%d", resp.StatusCode)
+	}
+	fmt.Fprintf(&line, " sent=%d recv=%d time=%s", max(req.ContentLength, 0), received, elapsed.Round(time.Millisecond))
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		// The challenge names the token service, which proxies often rewrite
+		if challenge := resp.Header.Get("WWW-Authenticate"); challenge != "" {
+			fmt.Fprintf(&line, " challenge=%q", challenge)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(&line, " error=%q", err.Error())
+	}
+	line.WriteString("\n")
+	io.WriteString(l.w, line.String())
+}
+
+// traceURL is the URL without query and credentials
+func traceURL(u *url.URL) string {
+	clean := *u
+	clean.User = nil
+	if clean.RawQuery != "" {
+		clean.RawQuery = "..."
+	}
+	return clean.String()
+}
@@ -0,0 +1,155 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Policy controls how often and how fast an operation is retried
+type Policy struct {
+	Attempts int           // Total attempts including the first one
+	Delay    time.Duration // Delay before the first retry, doubled after each failure
+	MaxDelay time.Duration // Upper bound for a single delay
+
+	// OnRetry is called before waiting for the next attempt (optional)
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// DefaultPolicy retries up to 4 times with delays of 1s, 2s, 4s
+var DefaultPolicy = Policy{
+	Attempts: 4,
+	Delay:    time.Second,
+	MaxDelay: 30 * time.Second,
+}
+
+// Configure applies --retries and --retry-delay to DefaultPolicy: the
+// retries after a failed first attempt, and the delay before the first of
+// them, doubled for each further one
+func Configure(retries int, delay time.Duration) error {
+	if retries < 0 {
+		return fmt.Errorf("--retries must not be negative, got %d", retries)
+	}
+	if delay <= 0 {
+		return fmt.Errorf("--retry-delay must be positive, got %s", delay)
+	}
+	DefaultPolicy.Attempts = retries + 1
+	DefaultPolicy.Delay = delay
+	DefaultPolicy.MaxDelay = max(DefaultPolicy.MaxDelay, delay)
+	return nil
+}
+
+// permanentError marks an error that must not be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that Do returns it immediately without retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// delayError carries a server-provided hint of when to retry
+type delayError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *delayError) Error() string { return e.err.Error() }
+func (e *delayError) Unwrap() error { return e.err }
+
+// After wraps err so that Do waits at least d before the next attempt,
+// e.g. to honor a Retry-After header
+func After(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &delayError{err: err, delay: d}
+}
+
+// Do runs fn until it succeeds, returns a permanent error, the attempts are
+// exhausted or ctx is cancelled. The last error is returned unwrapped.
+func Do(ctx context.Context, p Policy, fn func(attempt int) error) error {
+	attempts := p.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := p.Delay
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = fn(attempt)
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		if attempt == attempts {
+			break
+		}
+
+		// Honor the server's hint if it asks for a longer pause
+		wait := delay
+		var hinted *delayError
+		if errors.As(err, &hinted) && hinted.delay > wait {
+			wait = hinted.delay
+		}
+		if p.MaxDelay > 0 && wait > p.MaxDelay {
+			wait = p.MaxDelay
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, wait, err)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay *= 2
+	}
+
+	var hinted *delayError
+	if errors.As(err, &hinted) {
+		return hinted.err
+	}
+	return err
+}
+
+// ServerDelay returns how long a server asked to wait before the next
+// request (Retry-After, X-RateLimit-Reset), zero if unspecified
+func ServerDelay(resp *http.Response) time.Duration {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return time.Until(at)
+		}
+	}
+
+	if value := resp.Header.Get("X-RateLimit-Reset"); value != "" {
+		if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Until(time.Unix(epoch, 0))
+		}
+	}
+
+	return 0
+}
@@ -1,15 +1,23 @@
 package runtime
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	goruntime "runtime"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
 type ContainerdRuntime struct {
 	ctrPath string
+	remote  *RemoteShell // Runs ctr on the host of an ssh:// target
 }
 
 func NewContainerdRuntime() (*ContainerdRuntime, error) {
@@ -28,7 +36,18 @@ func NewContainerdRuntime() (*ContainerdRuntime, error) {
 	return &ContainerdRuntime{ctrPath: ctrPath}, nil
 }
 
+// command builds a ctr command, run on the remote host for ssh:// targets
+func (c *ContainerdRuntime) command(ctx context.Context, args ...string) *exec.Cmd {
+	if c.remote != nil {
+		return c.remote.command(ctx, "ctr", args...)
+	}
+	return exec.CommandContext(ctx, c.ctrPath, args...)
+}
+
 func (c *ContainerdRuntime) Name() string {
+	if c.remote != nil {
+		return "containerd@" + c.remote.target
+	}
 	return "containerd"
 }
 
@@ -69,7 +88,7 @@ func (c *ContainerdRuntime) GetImageWithPlatform(ctx context.Context, ref, platf
 
 func (c *ContainerdRuntime) checkImage(ctx context.Context, ref string) (*ImageInfo, error) {
 	// Use ctr to check if image exists
-	cmd := exec.CommandContext(ctx, c.ctrPath, "image", "ls", fmt.Sprintf("name==%s", ref))
+	cmd := c.command(ctx, "image", "ls", fmt.Sprintf("name==%s", ref))
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list image: %w", err)
@@ -95,15 +114,29 @@ func (c *ContainerdRuntime) pullImage(ctx context.Context, ref, platform string)
 	}
 	args = append(args, ref)
 
-	cmd := exec.CommandContext(ctx, c.ctrPath, args...)
+	cmd := c.command(ctx, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
 func (c *ContainerdRuntime) SaveImage(ctx context.Context, ref, outputPath string) error {
+	if c.remote != nil {
+		return saveToFile(ctx, c, ref, outputPath)
+	}
 	// Use ctr export to save image
-	cmd := exec.CommandContext(ctx, c.ctrPath, "image", "export", outputPath, ref)
+	cmd := c.command(ctx, "image", "export", outputPath, ref)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to export image: %w", err)
+	}
+	return nil
+}
+
+func (c *ContainerdRuntime) SaveImageToWriter(ctx context.Context, ref string, w io.Writer) error {
+	// ctr treats "-" as stdout
+	cmd := c.command(ctx, "image", "export", "-", ref)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to export image: %w", err)
 	}
@@ -111,8 +144,17 @@ func (c *ContainerdRuntime) SaveImage(ctx context.Context, ref, outputPath strin
 }
 
 func (c *ContainerdRuntime) LoadImage(ctx context.Context, inputPath string) error {
+	if c.remote != nil {
+		f, err := os.Open(inputPath)
+		if err != nil {
+			return fmt.Errorf("failed to open image file: %w", err)
+		}
+		defer f.Close()
+		return c.LoadImageFromReader(ctx, f)
+	}
+
 	// Use ctr import to load image
-	cmd := exec.CommandContext(ctx, c.ctrPath, "image", "import", inputPath)
+	cmd := c.command(ctx, "image", "import", inputPath)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed to import image: %w\nOutput: %s", err, string(output))
@@ -121,7 +163,7 @@ func (c *ContainerdRuntime) LoadImage(ctx context.Context, inputPath string) err
 }
 
 func (c *ContainerdRuntime) LoadImageFromReader(ctx context.Context, r io.Reader) error {
-	cmd := exec.CommandContext(ctx, c.ctrPath, "image", "import", "-")
+	cmd := c.command(ctx, "image", "import", "-")
 	cmd.Stdin = r
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -133,6 +175,218 @@ func (c *ContainerdRuntime) LoadImageFromReader(ctx context.Context, r io.Reader
 	return nil
 }
 
+// Platform is the local platform: ctr talks to the containerd of this
+// host. For ssh:// targets it is the remote host's.
+func (c *ContainerdRuntime) Platform(ctx context.Context) (string, error) {
+	if c.remote != nil {
+		return c.remote.platform(ctx)
+	}
+	return goruntime.GOOS + "/" + goruntime.GOARCH, nil
+}
+
+// ImageManifest resolves ref in the content store and returns the manifest
+// for platform, descending into the index for multi-platform images
+func (c *ContainerdRuntime) ImageManifest(ctx context.Context, ref, platform string) ([]byte, error) {
+	cmd := c.command(ctx, "image", "ls", fmt.Sprintf("name==%s", ref))
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list image: %w", err)
+	}
+
+	// Output is a header line followed by: REF TYPE DIGEST SIZE PLATFORMS LABELS
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) < 2 {
+		return nil, ErrImageNotFound
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("unexpected ctr image ls output: %s", lines[1])
+	}
+
+	data, err := c.readBlobBytes(ctx, fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := types.MediaType(fields[1])
+	if !mediaType.IsIndex() {
+		return data, nil
+	}
+
+	want, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse platform: %w", err)
+	}
+
+	index, err := v1.ParseIndexManifest(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image index: %w", err)
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Platform != nil && desc.Platform.Satisfies(*want) {
+			return c.readBlobBytes(ctx, desc.Digest.String())
+		}
+	}
+
+	return nil, fmt.Errorf("no manifest for platform %s in %s", platform, ref)
+}
+
+// ReadBlob streams a blob out of the containerd content store
+func (c *ContainerdRuntime) ReadBlob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	cmd := c.command(ctx, "content", "get", digest)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+
+	return &cmdReadCloser{ReadCloser: stdout, cmd: cmd, digest: digest}, nil
+}
+
+func (c *ContainerdRuntime) readBlobBytes(ctx context.Context, digest string) ([]byte, error) {
+	cmd := c.command(ctx, "content", "get", digest)
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	return data, nil
+}
+
+// cmdReadCloser waits for the producing command when the reader is closed,
+// surfacing its exit status as the Close error: a blob ctr fails to read
+// part way through otherwise looks like a complete, shorter one
+type cmdReadCloser struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	digest string
+}
+
+func (r *cmdReadCloser) Close() error {
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", r.digest, err)
+	}
+	return nil
+}
+
+// RunningContainers joins ctr's task and container lists. Both use the same
+// namespace as image import (CONTAINERD_NAMESPACE, e.g. k8s.io for pods
+// started through CRI), which is the only namespace a retag can affect.
+func (c *ContainerdRuntime) RunningContainers(ctx context.Context) ([]Container, error) {
+	tasks, err := c.listColumns(ctx, "task", "ls")
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[string]bool)
+	for _, task := range tasks {
+		// TASK PID STATUS
+		if len(task) >= 3 && task[2] == "RUNNING" {
+			running[task[0]] = true
+		}
+	}
+	if len(running) == 0 {
+		return nil, nil
+	}
+
+	records, err := c.listColumns(ctx, "container", "ls")
+	if err != nil {
+		return nil, err
+	}
+	var containers []Container
+	for _, record := range records {
+		// CONTAINER IMAGE RUNTIME
+		if len(record) < 2 || !running[record[0]] {
+			continue
+		}
+		containers = append(containers, Container{ID: record[0], Name: record[0], Image: record[1]})
+	}
+	return containers, nil
+}
+
+// ListImages lists the images of repository, or all images for an empty
+// repository, in the namespace of ctr
+func (c *ContainerdRuntime) ListImages(ctx context.Context, repository string) ([]string, error) {
+	var want name.Repository
+	if repository != "" {
+		var err error
+		if want, err = name.NewRepository(repository); err != nil {
+			return nil, fmt.Errorf("invalid repository %s: %w", repository, err)
+		}
+	}
+	rows, err := c.listColumns(ctx, "image", "ls")
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	for _, row := range rows {
+		// REF TYPE DIGEST SIZE PLATFORMS LABELS
+		tag, err := name.NewTag(row[0])
+		if err == nil && (repository == "" || tag.Context().Name() == want.Name()) {
+			refs = append(refs, row[0])
+		}
+	}
+	return refs, nil
+}
+
+// ImageDiffIDs reads the DiffIDs from the config of the image's manifest
+// for platform
+func (c *ContainerdRuntime) ImageDiffIDs(ctx context.Context, ref, platform string) ([]string, error) {
+	data, err := c.ImageManifest(ctx, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	data, err = c.readBlobBytes(ctx, manifest.Config.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+	config, err := v1.ParseConfigFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image config: %w", err)
+	}
+
+	diffIDs := make([]string, len(config.RootFS.DiffIDs))
+	for i, diffID := range config.RootFS.DiffIDs {
+		diffIDs[i] = diffID.String()
+	}
+	return diffIDs, nil
+}
+
+// listColumns runs a ctr list command and splits its rows, header excluded
+func (c *ContainerdRuntime) listColumns(ctx context.Context, args ...string) ([][]string, error) {
+	cmd := c.command(ctx, args...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ctr %s failed: %w", strings.Join(args, " "), err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	var rows [][]string
+	for _, line := range lines[1:] {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			rows = append(rows, fields)
+		}
+	}
+	return rows, nil
+}
+
+// RemoveImage removes an image reference with ctr image rm
+func (c *ContainerdRuntime) RemoveImage(ctx context.Context, ref string) error {
+	output, err := c.command(ctx, "image", "rm", ref).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove image: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
 func (c *ContainerdRuntime) Close() error {
 	return nil
 }
@@ -7,9 +7,13 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 )
 
-type DockerRuntime struct{}
+type DockerRuntime struct {
+	target string       // Docker context or daemon host; empty for the default
+	remote *RemoteShell // Runs the docker CLI of an ssh:// target on that host
+}
 
 func NewDockerRuntime() (*DockerRuntime, error) {
 	// Check if docker is available
@@ -21,7 +25,34 @@ func NewDockerRuntime() (*DockerRuntime, error) {
 	return &DockerRuntime{}, nil
 }
 
+// NewDockerTarget returns a docker runtime that talks to another daemon: a
+// docker context name, or a host such as ssh://user@node or tcp://node:2376
+func NewDockerTarget(target string) (*DockerRuntime, error) {
+	d := &DockerRuntime{target: target}
+	if err := d.command(context.Background(), "version").Run(); err != nil {
+		return nil, fmt.Errorf("docker at %s not available: %w", target, err)
+	}
+	return d, nil
+}
+
+// command builds a docker command against the runtime's target
+func (d *DockerRuntime) command(ctx context.Context, args ...string) *exec.Cmd {
+	switch {
+	case d.remote != nil:
+		return d.remote.command(ctx, "docker", args...)
+	case d.target == "":
+	case strings.Contains(d.target, "://"):
+		args = append([]string{"--host", d.target}, args...)
+	default:
+		args = append([]string{"--context", d.target}, args...)
+	}
+	return exec.CommandContext(ctx, "docker", args...)
+}
+
 func (d *DockerRuntime) Name() string {
+	if d.target != "" {
+		return "docker@" + d.target
+	}
 	return "docker"
 }
 
@@ -64,7 +95,7 @@ func (d *DockerRuntime) GetImageWithPlatform(ctx context.Context, ref, platform
 
 func (d *DockerRuntime) inspectImage(ctx context.Context, ref string) (*ImageInfo, error) {
 	// Use docker inspect to get image information
-	cmd := exec.CommandContext(ctx, "docker", "inspect", ref)
+	cmd := d.command(ctx, "inspect", ref)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect image: %w", err)
@@ -92,11 +123,20 @@ func (d *DockerRuntime) inspectImage(ctx context.Context, ref string) (*ImageInf
 		}
 	}
 
+	platform := ""
+	if imageData.Os != "" && imageData.Architecture != "" {
+		platform = imageData.Os + "/" + imageData.Architecture
+		if imageData.Variant != "" {
+			platform += "/" + imageData.Variant
+		}
+	}
+
 	return &ImageInfo{
 		Reference: ref,
 		ID:        imageData.ID,
 		Layers:    layers,
 		RepoTags:  imageData.RepoTags,
+		Platform:  platform,
 	}, nil
 }
 
@@ -107,15 +147,29 @@ func (d *DockerRuntime) pullImage(ctx context.Context, ref, platform string) err
 	}
 	args = append(args, ref)
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd := d.command(ctx, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
 func (d *DockerRuntime) SaveImage(ctx context.Context, ref, outputPath string) error {
+	if d.remote != nil {
+		return saveToFile(ctx, d, ref, outputPath)
+	}
 	// Use docker save to export image
-	cmd := exec.CommandContext(ctx, "docker", "save", "-o", outputPath, ref)
+	cmd := d.command(ctx, "save", "-o", outputPath, ref)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+	return nil
+}
+
+func (d *DockerRuntime) SaveImageToWriter(ctx context.Context, ref string, w io.Writer) error {
+	// Without -o, docker save writes the archive to stdout
+	cmd := d.command(ctx, "save", ref)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to save image: %w", err)
 	}
@@ -123,14 +177,14 @@ func (d *DockerRuntime) SaveImage(ctx context.Context, ref, outputPath string) e
 }
 
 func (d *DockerRuntime) LoadImage(ctx context.Context, inputPath string) error {
-	// Use docker load to import image
+	// Use docker load to import image; the file reaches remote hosts on stdin
 	f, err := os.Open(inputPath)
 	if err != nil {
 		return fmt.Errorf("failed to open image file: %w", err)
 	}
 	defer f.Close()
 
-	cmd := exec.CommandContext(ctx, "docker", "load")
+	cmd := d.command(ctx, "load")
 	cmd.Stdin = f
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -141,7 +195,7 @@ func (d *DockerRuntime) LoadImage(ctx context.Context, inputPath string) error {
 }
 
 func (d *DockerRuntime) LoadImageFromReader(ctx context.Context, r io.Reader) error {
-	cmd := exec.CommandContext(ctx, "docker", "load")
+	cmd := d.command(ctx, "load")
 	cmd.Stdin = r
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -153,15 +207,96 @@ func (d *DockerRuntime) LoadImageFromReader(ctx context.Context, r io.Reader) er
 	return nil
 }
 
+func (d *DockerRuntime) RunningContainers(ctx context.Context) ([]Container, error) {
+	cmd := d.command(ctx, "ps", "--no-trunc", "--format", "{{.ID}}\t{{.Image}}\t{{.Names}}")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+
+	var containers []Container
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		containers = append(containers, Container{ID: fields[0], Image: fields[1], Name: fields[2]})
+	}
+	return containers, nil
+}
+
+// Platform asks the daemon for its platform, which differs from the local
+// one for remote targets
+func (d *DockerRuntime) Platform(ctx context.Context) (string, error) {
+	output, err := d.command(ctx, "version", "--format", "{{.Server.Os}}/{{.Server.Arch}}").Output()
+	if err != nil {
+		return "", fmt.Errorf("docker version failed: %w", err)
+	}
+	platform := strings.TrimSpace(string(output))
+	if strings.Count(platform, "/") != 1 || strings.HasPrefix(platform, "/") || strings.HasSuffix(platform, "/") {
+		return "", fmt.Errorf("unexpected docker platform %q", platform)
+	}
+	return platform, nil
+}
+
+// ListImages lists the tagged images of repository, or all tagged images for
+// an empty repository, newest first
+func (d *DockerRuntime) ListImages(ctx context.Context, repository string) ([]string, error) {
+	args := []string{"images", "--format", "{{.Repository}}:{{.Tag}}"}
+	if repository != "" {
+		args = append(args, repository)
+	}
+	output, err := d.command(ctx, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker images failed: %w", err)
+	}
+
+	var refs []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, ":<none>") {
+			continue
+		}
+		refs = append(refs, line)
+	}
+	return refs, nil
+}
+
+// ImageDiffIDs reads the layers of a local image; the daemon holds one
+// platform per tag, so platform is not checked
+func (d *DockerRuntime) ImageDiffIDs(ctx context.Context, ref, platform string) ([]string, error) {
+	info, err := d.inspectImage(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := make([]string, len(info.Layers))
+	for i, layer := range info.Layers {
+		diffIDs[i] = layer.Digest
+	}
+	return diffIDs, nil
+}
+
+// RemoveImage removes a tag with docker rmi
+func (d *DockerRuntime) RemoveImage(ctx context.Context, ref string) error {
+	output, err := d.command(ctx, "rmi", ref).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove image: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
 func (d *DockerRuntime) Close() error {
 	return nil
 }
 
 // dockerInspectOutput represents the output of docker inspect
 type dockerInspectOutput struct {
-	ID       string   `json:"Id"`
-	RepoTags []string `json:"RepoTags"`
-	RootFS   struct {
+	ID           string   `json:"Id"`
+	RepoTags     []string `json:"RepoTags"`
+	Os           string   `json:"Os"`
+	Architecture string   `json:"Architecture"`
+	Variant      string   `json:"Variant"`
+	RootFS       struct {
 		Type   string   `json:"Type"`
 		Layers []string `json:"Layers"`
 	} `json:"RootFS"`
@@ -0,0 +1,206 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Fanout loads images into several runtimes at once, e.g. the docker daemons
+// of a small cluster. Loads stream the same data into every target
+// concurrently; everything else (inspect, save) uses the first target.
+type Fanout struct {
+	targets []Runtime
+}
+
+// NewFanout combines runtimes; the first one answers queries
+func NewFanout(targets ...Runtime) *Fanout {
+	return &Fanout{targets: targets}
+}
+
+// NewDockerTargets connects to every docker context or host; all of them
+// must be reachable. ssh:// hosts are driven through a remote shell, with
+// docker or containerd, whichever runs there.
+func NewDockerTargets(targets []string) (Runtime, error) {
+	var runtimes []Runtime
+	for _, target := range targets {
+		var rt Runtime
+		var err error
+		if IsSSHTarget(target) {
+			rt, err = NewSSHTarget(target)
+		} else {
+			rt, err = NewDockerTarget(target)
+		}
+		if err != nil {
+			return nil, err
+		}
+		runtimes = append(runtimes, rt)
+	}
+	if len(runtimes) == 1 {
+		return runtimes[0], nil
+	}
+	return NewFanout(runtimes...), nil
+}
+
+func (f *Fanout) Name() string {
+	names := make([]string, len(f.targets))
+	for i, target := range f.targets {
+		names[i] = target.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+func (f *Fanout) GetImage(ctx context.Context, ref string) (*ImageInfo, error) {
+	return f.targets[0].GetImage(ctx, ref)
+}
+
+func (f *Fanout) GetImageWithPlatform(ctx context.Context, ref, platform string) (*ImageInfo, error) {
+	return f.targets[0].GetImageWithPlatform(ctx, ref, platform)
+}
+
+func (f *Fanout) SaveImage(ctx context.Context, ref, outputPath string) error {
+	return f.targets[0].SaveImage(ctx, ref, outputPath)
+}
+
+func (f *Fanout) SaveImageToWriter(ctx context.Context, ref string, w io.Writer) error {
+	return f.targets[0].SaveImageToWriter(ctx, ref, w)
+}
+
+func (f *Fanout) LoadImage(ctx context.Context, inputPath string) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open image file: %w", err)
+	}
+	defer file.Close()
+
+	return f.LoadImageFromReader(ctx, file)
+}
+
+// LoadImageFromReader reads r once and streams it into every target. A
+// target that fails stops receiving data; the others carry on.
+func (f *Fanout) LoadImageFromReader(ctx context.Context, r io.Reader) error {
+	pipes := make([]*io.PipeWriter, len(f.targets))
+	errs := make([]error, len(f.targets))
+	var wg sync.WaitGroup
+	for i, target := range f.targets {
+		pr, pw := io.Pipe()
+		pipes[i] = pw
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = target.LoadImageFromReader(ctx, pr)
+			// Unblock the writer if the load stopped reading early
+			if errs[i] != nil {
+				pr.CloseWithError(errs[i])
+			} else {
+				pr.CloseWithError(io.ErrClosedPipe)
+			}
+		}()
+	}
+
+	_, copyErr := io.Copy(&fanoutWriter{pipes: pipes}, r)
+	for _, pw := range pipes {
+		pw.CloseWithError(copyErr)
+	}
+	wg.Wait()
+
+	if copyErr != nil && !errors.Is(copyErr, errNoTargetLeft) {
+		return fmt.Errorf("failed to read image: %w", copyErr)
+	}
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", f.targets[i].Name(), err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// RunningContainers lists the running containers of every target that can
+// list them, prefixing names with the target
+func (f *Fanout) RunningContainers(ctx context.Context) ([]Container, error) {
+	var all []Container
+	for _, target := range f.targets {
+		lister, ok := target.(ContainerLister)
+		if !ok {
+			continue
+		}
+		containers, err := lister.RunningContainers(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", target.Name(), err)
+		}
+		for _, c := range containers {
+			c.Name = target.Name() + "/" + c.Name
+			all = append(all, c)
+		}
+	}
+	return all, nil
+}
+
+// ByPlatform groups the targets by platform, so each group can be sent the
+// image variant it runs; targets that cannot tell are assumed to run the
+// local platform
+func (f *Fanout) ByPlatform(ctx context.Context) (map[string]Runtime, error) {
+	groups := make(map[string][]Runtime)
+	for _, target := range f.targets {
+		platform, err := PlatformOf(ctx, target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", target.Name(), err)
+		}
+		groups[platform] = append(groups[platform], target)
+	}
+
+	runtimes := make(map[string]Runtime, len(groups))
+	for platform, targets := range groups {
+		if len(targets) == 1 {
+			runtimes[platform] = targets[0]
+		} else {
+			runtimes[platform] = NewFanout(targets...)
+		}
+	}
+	return runtimes, nil
+}
+
+func (f *Fanout) Close() error {
+	var errs []error
+	for _, target := range f.targets {
+		errs = append(errs, target.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// errNoTargetLeft stops the stream once every target failed
+var errNoTargetLeft = errors.New("every target stopped reading")
+
+// fanoutWriter writes to every pipe that still accepts data; it only fails
+// once no pipe is left
+type fanoutWriter struct {
+	pipes []*io.PipeWriter
+	dead  []bool
+}
+
+func (w *fanoutWriter) Write(p []byte) (int, error) {
+	if w.dead == nil {
+		w.dead = make([]bool, len(w.pipes))
+	}
+
+	alive := 0
+	for i, pipe := range w.pipes {
+		if w.dead[i] {
+			continue
+		}
+		if _, err := pipe.Write(p); err != nil {
+			w.dead[i] = true
+			continue
+		}
+		alive++
+	}
+	if alive == 0 {
+		return 0, errNoTargetLeft
+	}
+	return len(p), nil
+}
@@ -2,7 +2,9 @@ package runtime
 
 import (
 	"context"
+	"fmt"
 	"io"
+	goruntime "runtime"
 )
 
 // Runtime represents a container runtime interface
@@ -20,6 +22,9 @@ type Runtime interface {
 	// SaveImage saves an image to a file
 	SaveImage(ctx context.Context, ref, outputPath string) error
 
+	// SaveImageToWriter streams an image archive (docker save format) to w
+	SaveImageToWriter(ctx context.Context, ref string, w io.Writer) error
+
 	// LoadImage loads an image from a file
 	LoadImage(ctx context.Context, inputPath string) error
 
@@ -30,12 +35,65 @@ type Runtime interface {
 	Close() error
 }
 
+// ContentStore is implemented by runtimes that can read individual blobs
+// (manifests, configs, compressed layers) from their content store.
+// Exporters use it to copy only the layers they need instead of
+// serializing the whole image first.
+type ContentStore interface {
+	// ImageManifest returns the raw image manifest of ref for the given platform
+	ImageManifest(ctx context.Context, ref, platform string) ([]byte, error)
+
+	// ReadBlob opens a blob from the content store by digest
+	ReadBlob(ctx context.Context, digest string) (io.ReadCloser, error)
+}
+
+// ContainerLister is implemented by runtimes that can list their running
+// containers, so imports can warn before retagging an image in use
+type ContainerLister interface {
+	// RunningContainers returns the containers that are currently running
+	RunningContainers(ctx context.Context) ([]Container, error)
+}
+
+// PlatformReporter is implemented by runtimes that know the platform of the
+// images they run, so loads of multi-platform bundles can pick the variant
+type PlatformReporter interface {
+	// Platform returns the runtime's platform as os/arch, e.g. "linux/arm64"
+	Platform(ctx context.Context) (string, error)
+}
+
+// ImageLister is implemented by runtimes that can list their images and
+// read their layers without pulling, so save can pick a --since base among
+// the images already present and load can find shared layers in them
+type ImageLister interface {
+	// ListImages returns the tagged references of repository's local
+	// images, or of all local images for an empty repository
+	ListImages(ctx context.Context, repository string) ([]string, error)
+
+	// ImageDiffIDs returns the layer DiffIDs of a local image for platform
+	ImageDiffIDs(ctx context.Context, ref, platform string) ([]string, error)
+}
+
+// ImageRemover is implemented by runtimes that can remove an image tag, so
+// selftest can clean up the image it loaded
+type ImageRemover interface {
+	// RemoveImage removes ref; the image goes when no other tag names it
+	RemoveImage(ctx context.Context, ref string) error
+}
+
+// Container is a running container and the image reference it was started from
+type Container struct {
+	ID    string
+	Name  string
+	Image string // As the runtime reports it: a reference, or an image ID when the tag has moved
+}
+
 // ImageInfo contains essential image information
 type ImageInfo struct {
 	Reference string
 	ID        string
 	Layers    []LayerInfo
 	RepoTags  []string
+	Platform  string // os/arch[/variant] of the image; empty if the runtime does not tell
 }
 
 // LayerInfo contains information about a layer
@@ -60,3 +118,25 @@ func DetectRuntime() (Runtime, error) {
 
 	return nil, ErrNoRuntimeAvailable
 }
+
+// PlatformOf returns the platform of a runtime's images; runtimes that
+// cannot tell are assumed to run the local platform
+func PlatformOf(ctx context.Context, rt Runtime) (string, error) {
+	if reporter, ok := rt.(PlatformReporter); ok {
+		return reporter.Platform(ctx)
+	}
+	return goruntime.GOOS + "/" + goruntime.GOARCH, nil
+}
+
+// GroupByPlatform maps the platforms of rt's targets to the runtimes that
+// load images for them; a single runtime forms one group
+func GroupByPlatform(ctx context.Context, rt Runtime) (map[string]Runtime, error) {
+	if fanout, ok := rt.(*Fanout); ok {
+		return fanout.ByPlatform(ctx)
+	}
+	platform, err := PlatformOf(ctx, rt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", rt.Name(), err)
+	}
+	return map[string]Runtime{platform: rt}, nil
+}
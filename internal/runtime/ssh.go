@@ -0,0 +1,162 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// RemoteShell runs the commands of a runtime on another host through ssh,
+// so loads can drive the docker or containerd of a machine that has neither
+// imgcd nor a reachable daemon socket. Image archives stream through the
+// ssh connection's stdin and stdout.
+type RemoteShell struct {
+	target string   // As given, ssh://[user@]host[:port]
+	args   []string // ssh options and destination
+	sudo   bool     // Run the runtime's CLI with sudo -n
+}
+
+// NewRemoteShell parses an ssh://[user@]host[:port] target
+func NewRemoteShell(target string) (*RemoteShell, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ssh target %q: expected ssh://[user@]host[:port]", target)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("invalid ssh target %q: unexpected path %s", target, u.Path)
+	}
+
+	// BatchMode fails instead of prompting for a password the pipes of
+	// the runtime commands cannot answer
+	args := []string{"-o", "BatchMode=yes"}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	destination := u.Hostname()
+	if u.User != nil && u.User.Username() != "" {
+		destination = u.User.Username() + "@" + destination
+	}
+	return &RemoteShell{target: target, args: append(args, destination)}, nil
+}
+
+// IsSSHTarget reports whether a --host target is an ssh:// URL
+func IsSSHTarget(target string) bool {
+	return strings.HasPrefix(target, "ssh://")
+}
+
+// NewSSHTarget connects to an ssh:// host and returns the runtime found
+// there: docker if its daemon answers, otherwise containerd's ctr. Either
+// is run with sudo -n when the login user cannot reach the daemon itself.
+func NewSSHTarget(target string) (Runtime, error) {
+	shell, err := NewRemoteShell(target)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+
+	if shell.probe(ctx, "docker") {
+		return &DockerRuntime{target: target, remote: shell}, nil
+	}
+	if shell.probe(ctx, "ctr") {
+		return &ContainerdRuntime{ctrPath: "ctr", remote: shell}, nil
+	}
+	if err := shell.command(ctx, "true").Run(); err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w (ssh runs with BatchMode, so use a key or an agent)", target, err)
+	}
+	return nil, fmt.Errorf("neither docker nor ctr answers on %s, also not with sudo -n", target)
+}
+
+// probe reports whether the CLI answers "version" on the remote host,
+// trying sudo -n second
+func (s *RemoteShell) probe(ctx context.Context, cli string) bool {
+	if s.command(ctx, cli, "version").Run() == nil {
+		return true
+	}
+	s.sudo = true
+	if s.command(ctx, cli, "version").Run() == nil {
+		return true
+	}
+	s.sudo = false
+	return false
+}
+
+// command builds an ssh command that runs name with args on the remote
+// host. ctr gets the local CONTAINERD_NAMESPACE, which ssh does not pass on.
+func (s *RemoteShell) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	words := []string{name}
+	if name == "ctr" {
+		if namespace := os.Getenv("CONTAINERD_NAMESPACE"); namespace != "" {
+			words = []string{name, "--namespace", namespace}
+		}
+	}
+	if s.sudo && name != "true" {
+		words = append([]string{"sudo", "-n"}, words...)
+	}
+	words = append(words, args...)
+
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = shellQuote(word)
+	}
+	sshArgs := append(append([]string{}, s.args...), "--", strings.Join(quoted, " "))
+	return exec.CommandContext(ctx, "ssh", sshArgs...)
+}
+
+// platform asks the remote host for its os/arch through uname
+func (s *RemoteShell) platform(ctx context.Context) (string, error) {
+	output, err := s.command(ctx, "uname", "-s", "-m").Output()
+	if err != nil {
+		return "", fmt.Errorf("uname on %s failed: %w", s.target, err)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return "", fmt.Errorf("unexpected uname output %q from %s", strings.TrimSpace(string(output)), s.target)
+	}
+	arch, ok := unameArchs[fields[1]]
+	if !ok {
+		return "", fmt.Errorf("unknown architecture %s on %s", fields[1], s.target)
+	}
+	return strings.ToLower(fields[0]) + "/" + arch, nil
+}
+
+// unameArchs maps uname -m to the architectures of image platforms
+var unameArchs = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i686":    "386",
+	"i386":    "386",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// shellQuote quotes a word for the remote POSIX shell
+func shellQuote(word string) string {
+	if word != "" && strings.IndexFunc(word, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+	}) < 0 {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// saveToFile writes the image archive of a remote runtime to a local file,
+// since save -o and ctr export would write it on the remote host
+func saveToFile(ctx context.Context, rt Runtime, ref, outputPath string) error {
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+	if err := rt.SaveImageToWriter(ctx, ref, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
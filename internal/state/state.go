@@ -0,0 +1,69 @@
+// Package state locates the directory imgcd keeps its caches and indexes in,
+// and lets it be disabled for read-only environments
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrDisabled is returned when state is needed but --no-state is in effect
+var ErrDisabled = errors.New("imgcd state is disabled (--no-state)")
+
+var (
+	disabled bool
+	dir      string
+)
+
+// Configure applies the --no-state and --state-dir flags; the IMGCD_NO_STATE
+// and IMGCD_STATE_DIR environment variables are used when they are unset
+func Configure(noState bool, stateDir string) error {
+	disabled = noState || os.Getenv("IMGCD_NO_STATE") == "1"
+	dir = stateDir
+	if dir == "" {
+		dir = os.Getenv("IMGCD_STATE_DIR")
+	}
+
+	if disabled && stateDir != "" {
+		return fmt.Errorf("--no-state and --state-dir cannot be used together")
+	}
+	if dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("invalid state directory %s: %w", dir, err)
+		}
+		dir = abs
+	}
+	return nil
+}
+
+// Override points the state directory at path, enabled, until restore is
+// called; for runs that must neither use nor touch the user's caches
+func Override(path string) (restore func()) {
+	prevDisabled, prevDir := disabled, dir
+	disabled, dir = false, path
+	return func() { disabled, dir = prevDisabled, prevDir }
+}
+
+// Disabled reports whether imgcd must not write any state
+func Disabled() bool {
+	return disabled
+}
+
+// Dir returns the state directory: --state-dir, IMGCD_STATE_DIR or ~/.imgcd
+func Dir() (string, error) {
+	if disabled {
+		return "", ErrDisabled
+	}
+	if dir != "" {
+		return dir, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".imgcd"), nil
+}
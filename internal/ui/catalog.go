@@ -0,0 +1,117 @@
+package ui
+
+import (
+	"sort"
+	"strings"
+)
+
+// catalogs maps a language to translations of message formats, keyed by the
+// English format. Translations must keep the verbs of the English format;
+// where the language orders them differently, they index the arguments
+// (%[2]s ... %[1]s).
+var catalogs = map[string]map[string]string{
+	"zh-CN": zhCN,
+}
+
+// Languages lists the languages with a catalog
+func Languages() []string {
+	var languages []string
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// catalogFor maps a locale such as "zh_CN.UTF-8" or "zh-cn" to the language
+// of a catalog, or "" when there is none and messages stay in English
+func catalogFor(locale string) string {
+	if i := strings.IndexAny(locale, ".@"); i >= 0 {
+		locale = locale[:i]
+	}
+	locale = strings.ReplaceAll(locale, "_", "-")
+	for language := range catalogs {
+		if strings.EqualFold(language, locale) {
+			return language
+		}
+	}
+	// Other Chinese variants fall back to Simplified Chinese
+	if strings.EqualFold(locale, "zh") || strings.HasPrefix(strings.ToLower(locale), "zh-hans") {
+		return "zh-CN"
+	}
+	return ""
+}
+
+var zhCN = map[string]string{
+	"OK:":      "成功:",
+	"FAILED:":  "失败:",
+	"Error:":   "错误:",
+	"Warning:": "警告:",
+
+	// save, load, push, cp and the other bundle commands
+	"Imported %d bundle(s)":                                           "已导入 %d 个包",
+	"%s imported in %s (%d/%d done)":                                  "%s 已导入，用时 %s (%d/%d 完成)",
+	"%s failed: %v":                                                   "%s 失败: %v",
+	"%s cannot be read: %v":                                           "无法读取 %s: %v",
+	"Attestation written: %s":                                         "证明已写入: %s",
+	"Checksum verified (sha256:%s)":                                   "校验和已验证 (sha256:%s)",
+	"Checksum verified (%s)":                                          "校验和已验证 (%s)",
+	"Checksum signature verified":                                     "校验和签名已验证",
+	"Delivered to %s":                                                 "已投递到 %s",
+	"Copied and verified: %s":                                         "已复制并校验: %s",
+	"Successfully imported artifact: %s":                              "制品导入成功: %s",
+	"Successfully imported image: %s":                                 "镜像导入成功: %s",
+	"Successfully imported %d images: %s":                             "已成功导入 %d 个镜像: %s",
+	"Successfully pushed: %s":                                         "推送成功: %s",
+	"Removed %s (%s)":                                                 "已删除 %s (%s)",
+	"Successfully created bundle: %s":                                 "包创建成功: %s",
+	"No changes since %s, no bundle created":                          "自 %s 以来没有变化，未创建包",
+	"Bundle is up to date, nothing changed since the last export: %s": "包已是最新，自上次导出以来没有变化: %s",
+	"Dry run: would create %s (about %s)":                             "试运行: 将创建 %s (约 %s)",
+	"Dry run: would create %s":                                        "试运行: 将创建 %s",
+	"Dry run: bundle is up to date, nothing to download: %s":          "试运行: 包已是最新，无需下载: %s",
+
+	// cache, pull and verify
+	"Successfully cleaned cache (freed %s)":                                     "缓存已清理 (释放 %s)",
+	"Successfully pruned %d entries (freed %s); blob cache is %s":               "已清理 %d 个条目 (释放 %s)；blob 缓存现为 %s",
+	"Pinned %s (no blobs cached yet; they are kept once an export caches them)": "已固定 %s (尚未缓存 blob；导出缓存后即保留)",
+	"Pinned %s (%d blobs, %s)":                                                  "已固定 %s (%d 个 blob，%s)",
+	"Unpinned %s":                                                               "已取消固定 %s",
+	"Removed %d unreferenced blobs (freed %s); blob cache is %s":                "已删除 %d 个未引用的 blob (释放 %s)；blob 缓存现为 %s",
+	"%s: %d blob(s), %d new (%s), %d already cached":                            "%s: %d 个 blob，新下载 %d 个 (%s)，%d 个已缓存",
+	"Copied %s to %s: %d blob(s) transferred (%s), %d from the cache":           "已将 %s 复制到 %s：传输 %d 个 blob (%s)，%d 个来自缓存",
+	"%s is shadowed by the built-in command imgcd %s":                           "%s 被内置命令 imgcd %s 覆盖",
+	"%s is shadowed by %s":                                                      "%s 被 %s 覆盖",
+	"Set %s to %s":                                                              "已将 %s 设为 %s",
+	"Unset %s":                                                                  "已删除 %s",
+	"Ignoring unknown key %s in %s":                                             "忽略 %[2]s 中的未知配置项 %[1]s",
+	"%s is intact: %s (%s) in %s%s":                                             "%s 完好: %s (%s)，用时 %s%s",
+	"Serving %d image(s) from the cache on %s://%s":                             "正在从缓存提供 %d 个镜像，地址 %s://%s",
+	"Split the bundle into %d parts":                                            "已将包拆分为 %d 个分卷",
+	"Joined %d parts into %s (%s)":                                              "已将 %d 个分卷合并为 %s (%s)",
+	"Peer discovery failed: %v":                                                 "查找对等节点失败: %v",
+	"Not announcing to peers: %v":                                               "未向对等节点广播: %v",
+	"Converted %d image(s) into %s (%s)":                                        "已将 %d 个镜像转换为 %s (%s)",
+	"Converted %d image(s) into %s":                                             "已将 %d 个镜像转换为 %s",
+	"Wrote %s to %s (%s)":                                                       "已将 %s 写入 %s (%s)",
+	"Wrote %s to %s":                                                            "已将 %s 写入 %s",
+	"Wrote %d layer(s) of %s to %s":                                             "已将 %[2]s 的 %[1]d 个镜像层写入 %[3]s",
+	"Streamed bundle %s to stdout (%s)":                                         "已将包 %s 输出到标准输出 (%s)",
+	"Signature verified (%s)":                                                   "包签名已验证 (%s)",
+	"Checksums verified (%d files)":                                             "文件校验和已验证 (%d 个文件)",
+	"Metadata of %d images saved to %s":                                         "已将 %d 个镜像的元数据保存到 %s",
+	"Bundle verified (%d blobs)":                                                "包已校验 (%d 个数据块)",
+	"Self-test passed":                                                          "自检通过",
+
+	// Ages, as in cache list
+	"just now":       "刚刚",
+	"1 minute ago":   "1 分钟前",
+	"%d minutes ago": "%d 分钟前",
+	"1 hour ago":     "1 小时前",
+	"%d hours ago":   "%d 小时前",
+	"1 day ago":      "1 天前",
+	"%d days ago":    "%d 天前",
+
+	// man
+	"Wrote %d man pages to %s": "已将 %d 个手册页写入 %s",
+}
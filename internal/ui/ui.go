@@ -0,0 +1,202 @@
+// Package ui prints imgcd's status lines: success and failure markers,
+// color on terminals, and messages translated from a catalog
+package ui
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/so2liu/imgcd/internal/ci"
+	"github.com/so2liu/imgcd/internal/logging"
+)
+
+const (
+	colorGreen = "\033[32m"
+	colorRed   = "\033[31m"
+	colorReset = "\033[0m"
+)
+
+var (
+	plain   bool
+	noColor bool
+	lang    string
+)
+
+func init() {
+	Configure(false, "")
+}
+
+// Configure applies --plain and --lang. IMGCD_PLAIN=1 also selects plain
+// output and NO_COLOR (https://no-color.org) turns color off; without
+// --lang the language comes from IMGCD_LANG, LC_ALL, LC_MESSAGES or LANG.
+func Configure(plainOutput bool, language string) error {
+	plain = plainOutput || os.Getenv("IMGCD_PLAIN") == "1"
+	noColor = plain || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb"
+
+	if language == "" {
+		language = localeFromEnv()
+	} else if !isEnglish(language) && catalogFor(language) == "" {
+		return fmt.Errorf("unsupported language %q (supported: en, %s)", language, strings.Join(Languages(), ", "))
+	}
+	lang = catalogFor(language)
+	return nil
+}
+
+// isEnglish reports whether a language selects the untranslated messages:
+// en, en-* and the C and POSIX locales
+func isEnglish(language string) bool {
+	if i := strings.IndexAny(language, ".@"); i >= 0 {
+		language = language[:i]
+	}
+	language = strings.ToLower(strings.ReplaceAll(language, "_", "-"))
+	return language == "en" || strings.HasPrefix(language, "en-") || language == "c" || language == "posix"
+}
+
+// localeFromEnv returns the first locale set in the environment
+func localeFromEnv() string {
+	for _, key := range []string{"IMGCD_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Plain reports whether output avoids symbols and color
+func Plain() bool {
+	return plain
+}
+
+// T translates a message format into the configured language; messages
+// without a translation are returned unchanged
+func T(format string) string {
+	if translated, ok := catalogs[lang][format]; ok {
+		return translated
+	}
+	return format
+}
+
+// Success prints a line reporting that something succeeded
+func Success(format string, args ...any) {
+	Fsuccess(os.Stdout, format, args...)
+}
+
+// Fsuccess prints a success line to w: "✓ message", green on terminals, or
+// "OK: message" in plain mode
+func Fsuccess(w io.Writer, format string, args ...any) {
+	status(w, "✓", "OK:", colorGreen, format, args...)
+}
+
+// Failure prints a line reporting that something failed
+func Failure(format string, args ...any) {
+	Ffailure(os.Stdout, format, args...)
+}
+
+// Ffailure prints a failure line to w: "✗ message", red on terminals, or
+// "FAILED: message" in plain mode
+func Ffailure(w io.Writer, format string, args ...any) {
+	status(w, "✗", "FAILED:", colorRed, format, args...)
+}
+
+// stderr is where Warning prints, os.Stderr unless redirected
+var (
+	stderrMu sync.Mutex
+	stderr   io.Writer = os.Stderr
+)
+
+// RedirectStderr sends the warnings of Warning to w, e.g. a progress
+// display that prints them above its bars, until restore is called
+func RedirectStderr(w io.Writer) (restore func()) {
+	stderrMu.Lock()
+	defer stderrMu.Unlock()
+	previous := stderr
+	stderr = w
+	return func() {
+		stderrMu.Lock()
+		defer stderrMu.Unlock()
+		stderr = previous
+	}
+}
+
+// Stderr writes where Warning prints, following RedirectStderr, so other
+// diagnostics do not break into a progress display either
+var Stderr io.Writer = stderrWriter{}
+
+type stderrWriter struct{}
+
+func (stderrWriter) Write(p []byte) (int, error) {
+	stderrMu.Lock()
+	w := stderr
+	stderrMu.Unlock()
+	return w.Write(p)
+}
+
+// Warning prints a warning to stderr
+func Warning(format string, args ...any) {
+	stderrMu.Lock()
+	w := stderr
+	stderrMu.Unlock()
+	Fwarning(w, format, args...)
+}
+
+// Fwarning prints "Warning: message" to w; under --ci the message becomes a
+// warning annotation instead, and with --log-format json a log record.
+// --log-level error silences warnings.
+func Fwarning(w io.Writer, format string, args ...any) {
+	message := fmt.Sprintf(T(format), args...)
+	switch {
+	case ci.Enabled():
+		ci.Annotate(ci.Warning, "", "%s", message)
+	case !logging.Enabled(slog.LevelWarn):
+	case logging.JSON():
+		slog.Warn(message)
+	default:
+		fmt.Fprintf(w, "%s %s\n", T("Warning:"), message)
+	}
+}
+
+// Error prints the error that ended a command; under --ci it becomes an
+// error annotation, and with --log-format json a log record
+func Error(w io.Writer, err error) {
+	if ci.Enabled() {
+		ci.Annotate(ci.Error, "imgcd", "%v", err)
+		return
+	}
+	if logging.JSON() {
+		slog.Error(err.Error())
+		return
+	}
+	label := T("Error:")
+	if useColor(w) {
+		label = colorRed + label + colorReset
+	}
+	fmt.Fprintf(w, "%s %v\n", label, err)
+}
+
+func status(w io.Writer, symbol, plainLabel, color, format string, args ...any) {
+	marker := symbol
+	switch {
+	case plain:
+		marker = T(plainLabel)
+	case useColor(w):
+		marker = color + symbol + colorReset
+	}
+	fmt.Fprintf(w, "%s %s\n", marker, fmt.Sprintf(T(format), args...))
+}
+
+// useColor reports whether w is a terminal that may be colored
+func useColor(w io.Writer) bool {
+	if noColor {
+		return false
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
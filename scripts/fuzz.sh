@@ -0,0 +1,51 @@
+#!/bin/bash
+#
+# Fuzz the parsers of untrusted bundle data with go-fuzz
+#
+# Usage: scripts/fuzz.sh <package> <target> [go-fuzz flags...]
+#   scripts/fuzz.sh bundle FuzzReader
+#   scripts/fuzz.sh image FuzzBundleStream -procs 4
+#
+# Targets (build tag gofuzz):
+#   bundle: FuzzReader FuzzMetadata FuzzChecksums FuzzSignature
+#   image:  FuzzSelfExtractorHeader FuzzBundleStream
+#
+# The seed corpus comes from real bundles and lives in
+# internal/<package>/testdata/gofuzz/<target>/corpus. go-fuzz runs in a copy
+# of it under ${FUZZ_WORKDIR:-/tmp/imgcd-fuzz}/<package>/<target>, so
+# crashers and the grown corpus stay out of the tree; copy interesting
+# inputs back into the seed corpus by hand.
+#
+# Requires go-fuzz and go-fuzz-build:
+#   go install github.com/dvyukov/go-fuzz/go-fuzz@latest github.com/dvyukov/go-fuzz/go-fuzz-build@latest
+#
+
+set -e
+
+if [ $# -lt 2 ]; then
+    sed -n '5,7p' "$0" | sed 's/^# \{0,1\}//'
+    exit 2
+fi
+
+PACKAGE="$1"
+TARGET="$2"
+shift 2
+
+ROOT="$(cd "$(dirname "$0")/.." && pwd)"
+SEEDS="${ROOT}/internal/${PACKAGE}/testdata/gofuzz/${TARGET}/corpus"
+WORKDIR="${FUZZ_WORKDIR:-/tmp/imgcd-fuzz}/${PACKAGE}/${TARGET}"
+
+if [ ! -d "${SEEDS}" ]; then
+    echo "No seed corpus for ${PACKAGE}/${TARGET} at ${SEEDS}" >&2
+    exit 1
+fi
+
+mkdir -p "${WORKDIR}/corpus"
+cp -n "${SEEDS}"/* "${WORKDIR}/corpus/"
+
+echo "Building ${PACKAGE}/${TARGET}..."
+(cd "${ROOT}" && go-fuzz-build -tags gofuzz -func "${TARGET}" \
+    -o "${WORKDIR}/${TARGET}.zip" "./internal/${PACKAGE}")
+
+echo "Fuzzing in ${WORKDIR} (Ctrl-C to stop)"
+exec go-fuzz -bin "${WORKDIR}/${TARGET}.zip" -workdir "${WORKDIR}" "$@"
@@ -0,0 +1,26 @@
+// Command render-self-extractor wraps a bundle tar into a self-extracting
+// script with the generator imgcd save --self-extracting uses, so
+// scripts/test-self-extractor.sh runs exactly what users get.
+//
+// Usage: go run ./scripts/render-self-extractor <bundle.tar> <output.sh> <platform> <image>
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/so2liu/imgcd/internal/image"
+)
+
+func main() {
+	if len(os.Args) != 5 {
+		fmt.Fprintln(os.Stderr, "usage: render-self-extractor <bundle.tar> <output.sh> <platform> <image>")
+		os.Exit(2)
+	}
+	bundlePath, outputPath, platform, imageName := os.Args[1], os.Args[2], os.Args[3], os.Args[4]
+
+	if err := image.NewBundleGenerator("test").GenerateSelfExtractor(bundlePath, outputPath, platform, imageName); err != nil {
+		fmt.Fprintln(os.Stderr, "render-self-extractor:", err)
+		os.Exit(1)
+	}
+}
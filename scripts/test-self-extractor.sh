@@ -0,0 +1,184 @@
+#!/bin/sh
+#
+# Run self-extracting bundles rendered by imgcd's own generator
+# (scripts/render-self-extractor) under every POSIX shell available
+# (sh, dash, busybox ash, bash --posix, ...) with a stub imgcd binary,
+# so bashisms and rendering bugs are caught before a bundle reaches a
+# bash-less target. Needs go.
+#
+
+set -e
+
+ROOT_DIR=$(cd "$(dirname "$0")/.." && pwd)
+TEMPLATE="${ROOT_DIR}/templates/self-extractor.sh"
+
+WORK_DIR=$(mktemp -d "${TMPDIR:-/tmp}/imgcd-sfx-test.XXXXXX")
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# The generator of imgcd save --self-extracting
+(cd "$ROOT_DIR" && go build -o "${WORK_DIR}/render" ./scripts/render-self-extractor)
+
+# Stub binary: checks it was asked to load the embedded image unchanged
+cat > "${WORK_DIR}/imgcd" <<'EOF'
+#!/bin/sh
+[ "$1" = "load" ] && [ "$2" = "--from" ] || exit 2
+cmp -s "$3" "$EXPECTED_IMAGE" || exit 3
+echo "stub imgcd: loaded image"
+EOF
+# Stub runtime and df so the pre-checks behave the same on every machine
+mkdir -p "${WORK_DIR}/bin"
+cat > "${WORK_DIR}/bin/docker" <<'EOF'
+#!/bin/sh
+[ "$STUB_DOCKER" != "down" ]
+EOF
+cat > "${WORK_DIR}/bin/df" <<'EOF'
+#!/bin/sh
+echo "Filesystem 1024-blocks Used Available Capacity Mounted on"
+echo "stub 999999999 0 ${STUB_DF_KB:-999999999} 0% /"
+EOF
+chmod +x "${WORK_DIR}/bin/docker" "${WORK_DIR}/bin/df"
+PATH="${WORK_DIR}/bin:${PATH}"
+STUB_DOCKER=""
+STUB_DF_KB=""
+IMGCD_SKIP_CHECKS=""
+export PATH STUB_DOCKER STUB_DF_KB IMGCD_SKIP_CHECKS
+
+sha256() {
+    if command -v sha256sum >/dev/null 2>&1; then
+        sha256sum < "$1" | awk '{ print $1 }'
+    else
+        shasum -a 256 < "$1" | awk '{ print $1 }'
+    fi
+}
+
+# Payload: a bundle tar with the stub binary, random image data and the
+# checksums of the binary
+dd if=/dev/urandom of="${WORK_DIR}/image.tar.gz" bs=1024 count=64 2>/dev/null
+chmod +x "${WORK_DIR}/imgcd"
+echo "$(sha256 "${WORK_DIR}/imgcd")  imgcd" > "${WORK_DIR}/checksums.txt"
+tar -cf "${WORK_DIR}/payload.tar" -C "$WORK_DIR" imgcd image.tar.gz checksums.txt
+EXPECTED_IMAGE="${WORK_DIR}/image.tar.gz"
+export EXPECTED_IMAGE
+
+# Platform as the template detects it, so the matching case needs no prompt
+HOST_OS=$(uname -s | tr '[:upper:]' '[:lower:]')
+case "$(uname -m)" in
+    x86_64|amd64) HOST_ARCH="amd64" ;;
+    aarch64|arm64) HOST_ARCH="arm64" ;;
+    *) echo "Skipping: unsupported architecture $(uname -m)"; exit 0 ;;
+esac
+
+# render <platform> <output> [image] writes a complete self-extracting
+# bundle of the payload
+render() {
+    "${WORK_DIR}/render" "${WORK_DIR}/payload.tar" "$2" "$1" "${3:-example/app:1.0}" > /dev/null
+}
+
+render "${HOST_OS}/${HOST_ARCH}" "${WORK_DIR}/bundle.sh"
+render "other/arch" "${WORK_DIR}/bundle-mismatch.sh"
+
+# An image name with what the shell expands inside double quotes, to check
+# the generator's escaping (no backslash: echo of dash would interpret it)
+ODD_NAME='example/app:1.0 "q" $HOME `false`'
+render "${HOST_OS}/${HOST_ARCH}" "${WORK_DIR}/bundle-odd-name.sh" "$ODD_NAME"
+
+# The script header alone, as the generator sized it, for syntax checks
+PAYLOAD_OFFSET=$(sed -n 's/^PAYLOAD_OFFSET=\([0-9]*\).*/\1/p' "${WORK_DIR}/bundle.sh")
+head -c "$PAYLOAD_OFFSET" "${WORK_DIR}/bundle.sh" > "${WORK_DIR}/header.sh"
+if [ "$(tail -c 1 "${WORK_DIR}/header.sh" | od -An -c | tr -d ' ')" != '\n' ]; then
+    echo "FAIL PAYLOAD_OFFSET ${PAYLOAD_OFFSET} does not end the script header"
+    exit 1
+fi
+if ! tail -c +$((PAYLOAD_OFFSET + 1)) "${WORK_DIR}/bundle.sh" | cmp -s - "${WORK_DIR}/payload.tar"; then
+    echo "FAIL the payload does not start at PAYLOAD_OFFSET ${PAYLOAD_OFFSET}"
+    exit 1
+fi
+
+# Damaged copies: cut short, and one byte of the payload changed
+head -c $(( $(wc -c < "${WORK_DIR}/bundle.sh") - 100 )) "${WORK_DIR}/bundle.sh" > "${WORK_DIR}/bundle-truncated.sh"
+cp "${WORK_DIR}/bundle.sh" "${WORK_DIR}/bundle-corrupt.sh"
+printf 'X' | dd of="${WORK_DIR}/bundle-corrupt.sh" bs=1 seek=$(( $(wc -c < "${WORK_DIR}/bundle.sh") - 2000 )) conv=notrunc 2>/dev/null
+
+FAILED=0
+TESTED=0
+
+# check <shell command> <name> <expected exit> <stdin> <shell args...>
+check() {
+    shell_cmd=$1
+    name=$2
+    want=$3
+    input=$4
+    shift 4
+
+    mkdir -p "${WORK_DIR}/tmp"
+    status=0
+    printf '%s' "$input" | TMPDIR="${WORK_DIR}/tmp" $shell_cmd "$@" > "${WORK_DIR}/output" 2>&1 || status=$?
+    if [ "$status" -ne "$want" ]; then
+        echo "FAIL [$shell_cmd] $name: exit $status, want $want"
+        sed 's/^/    /' "${WORK_DIR}/output"
+        FAILED=1
+        return
+    fi
+    if [ -n "$(ls -A "${WORK_DIR}/tmp")" ]; then
+        echo "FAIL [$shell_cmd] $name: temporary directory left behind"
+        FAILED=1
+        return
+    fi
+    echo "ok   [$shell_cmd] $name"
+}
+
+for shell in "sh" "dash" "busybox ash" "bash --posix" "ksh" "mksh" "yash" "posh"; do
+    set -- $shell
+    if ! command -v "$1" >/dev/null 2>&1; then
+        echo "skip [$shell] not installed"
+        continue
+    fi
+    if [ "$1" = "busybox" ] && ! busybox ash -c true >/dev/null 2>&1; then
+        echo "skip [$shell] busybox built without ash"
+        continue
+    fi
+
+    TESTED=$((TESTED + 1))
+    check "$shell" "syntax" 0 "" -n "${WORK_DIR}/header.sh"
+    check "$shell" "load" 0 "" "${WORK_DIR}/bundle.sh"
+    check "$shell" "image name escaping" 0 "" "${WORK_DIR}/bundle-odd-name.sh"
+    if ! grep -qF "Image: ${ODD_NAME}" "${WORK_DIR}/output"; then
+        echo "FAIL [$shell] image name escaping: name not printed verbatim"
+        sed 's/^/    /' "${WORK_DIR}/output"
+        FAILED=1
+    fi
+    check "$shell" "platform mismatch declined" 1 "n
+" "${WORK_DIR}/bundle-mismatch.sh"
+    check "$shell" "platform mismatch accepted" 0 "y
+" "${WORK_DIR}/bundle-mismatch.sh"
+    check "$shell" "platform mismatch without input" 1 "" "${WORK_DIR}/bundle-mismatch.sh"
+
+    check "$shell" "truncated payload" 1 "" "${WORK_DIR}/bundle-truncated.sh"
+    check "$shell" "corrupted payload" 1 "" "${WORK_DIR}/bundle-corrupt.sh"
+
+    STUB_DOCKER="down"
+    check "$shell" "runtime unreachable" 1 "" "${WORK_DIR}/bundle.sh"
+    IMGCD_SKIP_CHECKS="1"
+    check "$shell" "checks skipped" 0 "" "${WORK_DIR}/bundle.sh"
+    STUB_DOCKER=""
+    IMGCD_SKIP_CHECKS=""
+
+    STUB_DF_KB="1"
+    check "$shell" "not enough disk space" 1 "" "${WORK_DIR}/bundle.sh"
+    STUB_DF_KB=""
+done
+
+# Static check for bashisms when the tool is around
+if command -v checkbashisms >/dev/null 2>&1; then
+    if checkbashisms "$TEMPLATE"; then
+        echo "ok   [checkbashisms]"
+    else
+        FAILED=1
+    fi
+fi
+
+if [ "$TESTED" -eq 0 ]; then
+    echo "No shell found to test with"
+    exit 1
+fi
+exit "$FAILED"
@@ -0,0 +1,158 @@
+// Package sdk is the public API for imgcd plugins, the imgcd-<name>
+// executables on PATH that imgcd runs for "imgcd <name>". It reads and
+// writes bundles in the format imgcd load understands, so site-specific
+// commands (custom transports, approval workflows) need no fork of imgcd.
+package sdk
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/so2liu/imgcd/internal/bundle"
+	"github.com/so2liu/imgcd/internal/image"
+)
+
+// Metadata and the types it is made of describe a bundle's images; see
+// metadata.json inside any bundle
+type (
+	Metadata   = bundle.Metadata
+	ImageEntry = bundle.ImageEntry
+	LayerInfo  = bundle.LayerInfo
+	Artifact   = bundle.Artifact
+	Provenance = bundle.Provenance
+)
+
+// FormatVersion is the Metadata.Version of the bundles Write produces
+const FormatVersion = "2"
+
+// Bundle is a bundle file opened for reading
+type Bundle struct {
+	Path     string
+	Metadata *Metadata
+}
+
+// Entry is one file of a bundle's image data; Kind says what it holds
+type (
+	Entry     = bundle.Entry
+	EntryKind = bundle.EntryKind
+)
+
+// Kinds of entries
+const (
+	OtherEntry          = bundle.OtherEntry
+	MetadataEntry       = bundle.MetadataEntry
+	LegacyMetadataEntry = bundle.LegacyMetadataEntry
+	BlobEntry           = bundle.BlobEntry
+)
+
+// ErrDigestMismatch is wrapped by the error of reading a blob whose content
+// does not match its digest
+var ErrDigestMismatch = bundle.ErrDigestMismatch
+
+// Reader reads the image data of a bundle entry by entry in one pass, with
+// Next or the All and Blobs iterators. Metadata is decoded as it passes,
+// blobs read to the end are checked against their digest, and the end of
+// the stream checks the gzip CRC.
+type Reader struct {
+	*bundle.Reader
+	file io.Closer
+}
+
+// NewReader reads image data (image.tar.gz) from r, e.g. a bundle streamed
+// over the network; Open and Bundle.Reader read bundle files
+func NewReader(r io.Reader) (*Reader, error) {
+	br, err := bundle.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{Reader: br}, nil
+}
+
+// Close releases the reader and the bundle file it reads
+func (r *Reader) Close() error {
+	err := r.Reader.Close()
+	if r.file != nil {
+		if closeErr := r.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Open reads the metadata of a .tar, .sh or image.tar.gz bundle. Bundles
+// saved in local mode (format 1.0) hold a docker archive instead of blobs
+// and are not supported.
+func Open(bundlePath string) (*Bundle, error) {
+	b := &Bundle{Path: bundlePath}
+	r, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for entry, err := range r.All() {
+		if err != nil {
+			return nil, err
+		}
+		switch entry.Kind {
+		case MetadataEntry:
+			b.Metadata = r.Metadata()
+			return b, nil
+		case LegacyMetadataEntry:
+			return nil, fmt.Errorf("%s was saved in local mode (format 1.0), which stores no blobs", bundlePath)
+		}
+	}
+	return nil, fmt.Errorf("metadata not found in %s", bundlePath)
+}
+
+// Reader opens the bundle's image data for reading entry by entry
+func (b *Bundle) Reader() (*Reader, error) {
+	data, err := image.OpenBundleData(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(data)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	r.file = data
+	return r, nil
+}
+
+// Blobs calls fn for every blob stored in the bundle, in stored order, with
+// its digest ("sha256:...") and compressed size. Layers an incremental
+// bundle shares with its base are not stored. Blobs fn reads to the end are
+// checked against their digest.
+func (b *Bundle) Blobs(fn func(digest string, size int64, r io.Reader) error) error {
+	r, err := b.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for entry, err := range r.Blobs() {
+		if err != nil {
+			return err
+		}
+		if err := fn(entry.Digest, entry.Size(), entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes a bundle to w: meta followed by the blobs of digests, read
+// through open, which also reports each blob's size. The result is the
+// image data of a bundle (image.tar.gz); imgcd load and imgcd inspect
+// accept it as is. Leave Version empty to get FormatVersion.
+func Write(w io.Writer, meta *Metadata, digests []string, open func(digest string) (io.ReadCloser, int64, error)) error {
+	metadata := *meta
+	if metadata.Version == "" {
+		metadata.Version = FormatVersion
+	}
+	if metadata.Version != FormatVersion {
+		return fmt.Errorf("unsupported metadata version %q (supported: %s)", metadata.Version, FormatVersion)
+	}
+	return image.WriteBundleData(w, metadata, digests, open)
+}
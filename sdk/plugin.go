@@ -0,0 +1,24 @@
+package sdk
+
+import "os"
+
+// Environment imgcd sets for the plugins it runs
+const (
+	EnvExecutable = "IMGCD_EXECUTABLE" // Path of the imgcd binary that ran the plugin
+	EnvVersion    = "IMGCD_VERSION"    // Version of that binary
+)
+
+// Executable returns the imgcd binary that ran the plugin, for plugins that
+// call back into imgcd; "imgcd" from PATH when the plugin was run directly
+func Executable() string {
+	if path := os.Getenv(EnvExecutable); path != "" {
+		return path
+	}
+	return "imgcd"
+}
+
+// Version returns the version of the imgcd that ran the plugin, empty when
+// the plugin was run directly
+func Version() string {
+	return os.Getenv(EnvVersion)
+}
@@ -0,0 +1,15 @@
+# Full export: ship one image to an offline host
+#
+# On the connected side: save the image for the target platform. The bundle
+# carries the imgcd binary, so the offline host needs nothing installed.
+imgcd save {{.Image}}:1.0 --target-platform {{.Platform}} --out-dir ./out
+
+# Carry these two files across:
+#   ./out/{{bundle "1.0" ""}}
+#   ./out/{{bundle "1.0" ""}}.sha256
+
+# On the offline side: unpack imgcd, check the copy, then import it
+tar xf {{bundle "1.0" ""}} imgcd
+./imgcd verify {{bundle "1.0" ""}}
+./imgcd load --from {{bundle "1.0" ""}}
+docker image ls {{.Image}}
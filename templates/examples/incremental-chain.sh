@@ -0,0 +1,20 @@
+# Incremental chain: after one full export, ship only the changed layers
+#
+# On the connected side: a full bundle once, then each release on top of
+# the one before it
+imgcd save {{.Image}}:1.0 --target-platform {{.Platform}} --out-dir ./out
+imgcd save {{.Image}}:1.1 --since 1.0 --target-platform {{.Platform}} --out-dir ./out
+imgcd save {{.Image}}:1.2 --since 1.1 --target-platform {{.Platform}} --out-dir ./out
+
+# On the offline side: apply imports the chain base first, whatever the
+# order of the arguments
+tar xf {{bundle "1.0" ""}} imgcd
+./imgcd apply {{bundle "1.0" ""}} {{bundle "1.1" "1.0"}} {{bundle "1.2" "1.1"}}
+
+# Later releases need only their increment, e.g. when 1.3 comes out:
+#   imgcd save {{.Image}}:1.3 --since 1.2 --target-platform {{.Platform}} --out-dir ./out
+#   ./imgcd load --from {{bundle "1.3" "1.2"}}
+
+# Free space once the images are loaded; rm-bundle refuses to delete a
+# bundle that others in the directory still build on
+./imgcd rm-bundle --dry-run {{bundle "1.0" ""}}
@@ -0,0 +1,15 @@
+# Offline registry seed: fill the private registry of an air-gapped site
+#
+# On the connected side: save the images the site needs
+imgcd save {{.Image}}:1.0 --target-platform {{.Platform}} --out-dir ./out
+imgcd save {{.Image}}:1.1 --since 1.0 --target-platform {{.Platform}} --out-dir ./out
+
+# On the offline side: push the bundles, no container runtime needed.
+# The base goes first, so the registry can supply the layers the
+# incremental bundle leaves out.
+tar xf {{bundle "1.0" ""}} imgcd
+./imgcd push {{bundle "1.0" ""}} {{.Registry}}/{{.Repository}}
+./imgcd push {{bundle "1.1" "1.0"}} {{.Registry}}/{{.Repository}}
+
+# Hosts of the site now pull from the registry
+docker pull {{.Registry}}/{{.Repository}}:1.1
@@ -1,7 +1,11 @@
-#!/bin/bash
+#!/bin/sh
 # imgcd self-extracting bundle
 # This script contains an embedded imgcd binary and container image data
 # Generated by imgcd - https://github.com/so2liu/imgcd
+#
+# Strictly POSIX sh: embedded targets often only ship busybox ash or dash,
+# so no bashisms (local, echo -e, read -p, [[ ]], arrays, $'...').
+# scripts/test-self-extractor.sh runs it under every shell it can find.
 
 set -e
 
@@ -10,31 +14,159 @@ TARGET_PLATFORM="{{TARGET_PLATFORM}}"
 IMAGE_NAME="{{IMAGE_NAME}}"
 IMGCD_VERSION="{{IMGCD_VERSION}}"
 
-# Colors for output
-RED='\033[0;31m'
-GREEN='\033[0;32m'
-YELLOW='\033[1;33m'
-NC='\033[0m' # No Color
+# Payload location: the bundle tar (imgcd binary, image.tar.gz and the
+# checksums.txt of both) is appended raw right after this script,
+# PAYLOAD_OFFSET bytes from the start of the file.
+# imgcd reads these lines too, keep them one assignment per line.
+PAYLOAD_OFFSET={{PAYLOAD_OFFSET}}
+PAYLOAD_SIZE={{PAYLOAD_SIZE}}
+PAYLOAD_SHA256="{{PAYLOAD_SHA256}}"
+
+# Colors for output, only on terminals
+if [ -t 1 ]; then
+    ESC=$(printf '\033')
+    RED="${ESC}[0;31m"
+    GREEN="${ESC}[0;32m"
+    YELLOW="${ESC}[1;33m"
+    NC="${ESC}[0m" # No Color
+else
+    RED=''
+    GREEN=''
+    YELLOW=''
+    NC=''
+fi
+
+# Print a colored message
+say() {
+    printf '%s%s%s\n' "$1" "$2" "$NC"
+}
 
 # Detect current platform
 detect_platform() {
-    local os=$(uname -s | tr '[:upper:]' '[:lower:]')
-    local arch=$(uname -m)
+    detect_os=$(uname -s | tr '[:upper:]' '[:lower:]')
+    detect_arch=$(uname -m)
 
-    case "$arch" in
-        x86_64)
-            arch="amd64"
+    case "$detect_arch" in
+        x86_64|amd64)
+            detect_arch="amd64"
             ;;
         aarch64|arm64)
-            arch="arm64"
+            detect_arch="arm64"
             ;;
         *)
-            echo -e "${RED}Error: Unsupported architecture: $arch${NC}" >&2
+            say "$RED" "Error: Unsupported architecture: $detect_arch" >&2
             exit 1
             ;;
     esac
 
-    echo "${os}/${arch}"
+    echo "${detect_os}/${detect_arch}"
+}
+
+# Write the payload to stdout; tail -c is POSIX and binary safe
+read_payload() {
+    tail -c +$((PAYLOAD_OFFSET + 1)) "$0"
+}
+
+# Print the sha256 of stdin with whatever tool the system has
+sha256_stdin() {
+    if command -v sha256sum >/dev/null 2>&1; then
+        sha256sum | awk '{ print $1 }'
+    elif command -v shasum >/dev/null 2>&1; then
+        shasum -a 256 | awk '{ print $1 }'
+    elif command -v openssl >/dev/null 2>&1; then
+        openssl dgst -sha256 | awk '{ print $NF }'
+    fi
+}
+
+# Check that the payload is complete and unmodified
+verify_payload() {
+    actual_size=$(( $(wc -c < "$0") - PAYLOAD_OFFSET ))
+    if [ "$actual_size" -ne "$PAYLOAD_SIZE" ]; then
+        say "$RED" "Error: bundle is truncated or modified: payload is $actual_size bytes, expected $PAYLOAD_SIZE." >&2
+        echo "  Copy the bundle again and compare its checksum with the original." >&2
+        exit 1
+    fi
+
+    actual_sha256=$(read_payload | sha256_stdin)
+    if [ -z "$actual_sha256" ]; then
+        say "$YELLOW" "Warning: no sha256 tool found (sha256sum, shasum, openssl), skipping checksum verification"
+        return 0
+    fi
+    if [ "$actual_sha256" != "$PAYLOAD_SHA256" ]; then
+        say "$RED" "Error: bundle checksum mismatch, the payload is corrupted." >&2
+        echo "  Expected: $PAYLOAD_SHA256" >&2
+        echo "  Actual:   $actual_sha256" >&2
+        exit 1
+    fi
+    echo "Payload checksum verified"
+}
+
+# Check the unpacked imgcd binary against checksums.txt before running it;
+# imgcd load checks image.tar.gz against the rest of the file
+verify_binary() {
+    [ -f "$TEMP_DIR/checksums.txt" ] || return 0
+    expected_sha256=$(awk '$2 == "imgcd" || $2 == "*imgcd" { print $1 }' "$TEMP_DIR/checksums.txt")
+    [ -n "$expected_sha256" ] || return 0
+
+    actual_sha256=$(sha256_stdin < "$IMGCD_BIN")
+    # Without a sha256 tool verify_payload already warned
+    [ -n "$actual_sha256" ] || return 0
+    if [ "$actual_sha256" != "$expected_sha256" ]; then
+        say "$RED" "Error: the unpacked imgcd binary is corrupted, check the space in ${EXTRACT_BASE}." >&2
+        echo "  Expected: $expected_sha256" >&2
+        echo "  Actual:   $actual_sha256" >&2
+        exit 1
+    fi
+}
+
+# Check that a container runtime imgcd can load into is usable
+check_runtime() {
+    if command -v docker >/dev/null 2>&1; then
+        if docker version >/dev/null 2>&1; then
+            echo "Container runtime: docker"
+            return 0
+        fi
+        say "$RED" "Error: docker is installed but the daemon is not reachable." >&2
+        echo "  Start it (e.g. 'sudo systemctl start docker') and make sure this user may use it" >&2
+        echo "  (member of the 'docker' group, or run this bundle with sudo)." >&2
+        exit 1
+    fi
+
+    if command -v ctr >/dev/null 2>&1; then
+        if ctr version >/dev/null 2>&1; then
+            echo "Container runtime: containerd"
+            return 0
+        fi
+        say "$RED" "Error: ctr is installed but containerd is not reachable." >&2
+        echo "  Start containerd (e.g. 'sudo systemctl start containerd') or run this bundle with sudo." >&2
+        exit 1
+    fi
+
+    say "$RED" "Error: no supported container runtime found." >&2
+    echo "  Install docker or containerd (ctr) on this machine, then run this bundle again." >&2
+    exit 1
+}
+
+# Check that the extraction directory can hold the decoded payload
+check_disk_space() {
+    # The unpacked payload is as large as the embedded one, plus some slack
+    need_kb=$(( PAYLOAD_SIZE / 1024 + 1024 ))
+    avail_kb=$(df -Pk "$1" 2>/dev/null | awk 'NR == 2 { print $4 }')
+
+    case "$avail_kb" in
+        ''|*[!0-9]*)
+            say "$YELLOW" "Warning: could not determine free space in $1"
+            return 0
+            ;;
+    esac
+
+    if [ "$avail_kb" -lt "$need_kb" ]; then
+        say "$RED" "Error: not enough space in $1: need $((need_kb / 1024)) MB, $((avail_kb / 1024)) MB available." >&2
+        echo "  Free up space there, or point TMPDIR at a larger directory:" >&2
+        echo "    TMPDIR=/path/with/space sh $0" >&2
+        exit 1
+    fi
+    echo "Free space in $1: $((avail_kb / 1024)) MB (need $((need_kb / 1024)) MB)"
 }
 
 # Main execution
@@ -50,61 +182,68 @@ main() {
 
     # Warn if platforms don't match
     if [ "$CURRENT_PLATFORM" != "$TARGET_PLATFORM" ]; then
-        echo -e "${YELLOW}Warning: Current platform ($CURRENT_PLATFORM) differs from target platform ($TARGET_PLATFORM)${NC}"
-        echo -e "${YELLOW}The embedded imgcd binary may not be compatible with this system.${NC}"
-        read -p "Continue anyway? (y/N) " -n 1 -r
-        echo
-        if [[ ! $REPLY =~ ^[Yy]$ ]]; then
-            echo "Aborted."
-            exit 1
-        fi
+        say "$YELLOW" "Warning: Current platform ($CURRENT_PLATFORM) differs from target platform ($TARGET_PLATFORM)"
+        say "$YELLOW" "The embedded imgcd binary may not be compatible with this system."
+        printf "Continue anyway? (y/N) "
+        REPLY=""
+        read -r REPLY || true
+        case "$REPLY" in
+            [Yy]*)
+                ;;
+            *)
+                echo "Aborted."
+                exit 1
+                ;;
+        esac
+    fi
+
+    # Check everything that can fail before unpacking gigabytes;
+    # IMGCD_SKIP_CHECKS=1 skips the runtime and disk space checks
+    EXTRACT_BASE="${TMPDIR:-/tmp}"
+    verify_payload
+    if [ "${IMGCD_SKIP_CHECKS:-}" != "1" ]; then
+        check_runtime
+        check_disk_space "$EXTRACT_BASE"
     fi
 
-    # Create temporary directory
-    TEMP_DIR=$(mktemp -d -t imgcd-bundle.XXXXXX)
-    trap "rm -rf $TEMP_DIR" EXIT
+    # Create temporary directory (busybox mktemp has no -t)
+    TEMP_DIR=$(mktemp -d "${EXTRACT_BASE}/imgcd-bundle.XXXXXX")
+    trap 'rm -rf "$TEMP_DIR"' EXIT
+    trap 'exit 130' INT
+    trap 'exit 143' TERM
 
     echo ""
     echo "Extracting bundle to temporary directory..."
 
-    # Extract imgcd binary
+    # Unpack the imgcd binary and image data
+    if ! read_payload | tar -xf - -C "$TEMP_DIR"; then
+        say "$RED" "Error: failed to unpack the bundle payload" >&2
+        exit 1
+    fi
     IMGCD_BIN="$TEMP_DIR/imgcd"
-    sed -n '/^__IMGCD_BINARY_START__$/,/^__IMGCD_BINARY_END__$/p' "$0" | \
-        sed '1d;$d' | \
-        base64 -d > "$IMGCD_BIN"
-    chmod +x "$IMGCD_BIN"
-
-    # Extract image data
     IMAGE_FILE="$TEMP_DIR/image.tar.gz"
-    sed -n '/^__IMAGE_DATA_START__$/,/^__IMAGE_DATA_END__$/p' "$0" | \
-        sed '1d;$d' | \
-        base64 -d > "$IMAGE_FILE"
+    verify_binary
+    chmod +x "$IMGCD_BIN"
 
     echo "Extraction complete."
     echo ""
     echo "Importing image..."
 
-    # Import the image using the extracted imgcd binary
-    if "$IMGCD_BIN" load --from "$IMAGE_FILE"; then
+    # Import the image using the extracted imgcd binary, which checks it
+    # against the checksums.txt next to it;
+    # IMGCD_EXTRAS_DIR=DIR also extracts the attached files and
+    # IMGCD_RUN_POST_LOAD=1 runs the bundle's on-load commands after confirmation
+    if "$IMGCD_BIN" load --from "$IMAGE_FILE" ${IMGCD_EXTRAS_DIR:+--extras-dir "$IMGCD_EXTRAS_DIR"} ${IMGCD_RUN_POST_LOAD:+--run-post-load}; then
         echo ""
-        echo -e "${GREEN}Successfully imported image: ${IMAGE_NAME}${NC}"
+        say "$GREEN" "Successfully imported image: ${IMAGE_NAME}"
         exit 0
     else
         echo ""
-        echo -e "${RED}Failed to import image${NC}" >&2
+        say "$RED" "Failed to import image" >&2
         exit 1
     fi
 }
 
-# Run main function
+# Run main function, never reaching the payload below
 main "$@"
-
-# Exit before data section
 exit 0
-
-__IMGCD_BINARY_START__
-{{IMGCD_BINARY_BASE64}}
-__IMGCD_BINARY_END__
-__IMAGE_DATA_START__
-{{IMAGE_DATA_BASE64}}
-__IMAGE_DATA_END__
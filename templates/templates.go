@@ -0,0 +1,20 @@
+// Package templates embeds the files imgcd renders into generated artifacts
+package templates
+
+import "embed"
+
+// SelfExtractor is the shell script header of self-extracting bundles
+//
+//go:embed self-extractor.sh
+var SelfExtractor string
+
+// VerifyDelivery is the checksum script placed on delivery media
+//
+//go:embed verify-delivery.sh
+var VerifyDelivery string
+
+// Examples holds the scenarios printed by imgcd examples, as text/template
+// shell scripts
+//
+//go:embed examples/*.sh
+var Examples embed.FS
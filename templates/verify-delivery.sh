@@ -0,0 +1,32 @@
+#!/bin/sh
+# imgcd delivery verification
+# Checks every file of this delivery against SHA256SUMS.
+# Generated by imgcd - https://github.com/so2liu/imgcd
+#
+# Run it from the mounted media, which is usually read-only and noexec:
+#   sh /media/cdrom/verify.sh
+
+set -e
+
+cd "$(dirname "$0")"
+
+if command -v sha256sum >/dev/null 2>&1; then
+    sha256sum -c SHA256SUMS
+elif command -v shasum >/dev/null 2>&1; then
+    shasum -a 256 -c SHA256SUMS
+else
+    # openssl fallback: compare each line by hand
+    status=0
+    while read -r sum name; do
+        actual=$(openssl dgst -sha256 -r "$name" | cut -d' ' -f1)
+        if [ "$actual" = "$sum" ]; then
+            printf '%s: OK\n' "$name"
+        else
+            printf '%s: FAILED\n' "$name"
+            status=1
+        fi
+    done < SHA256SUMS
+    [ "$status" -eq 0 ] || exit 1
+fi
+
+printf 'All files verified\n'